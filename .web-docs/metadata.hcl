@@ -53,4 +53,14 @@ integration {
     name = "Amazon Import"
     slug = "import"
   }
+  component {
+    type = "post-processor"
+    name = "Amazon Metadata"
+    slug = "metadata"
+  }
+  component {
+    type = "post-processor"
+    name = "Amazon Webhook"
+    slug = "webhook"
+  }
 }
@@ -165,6 +165,13 @@ type Config struct {
 	//criteria provided in `source_ami_filter`; this pins the AMI returned by the
 	//filter, but will cause Packer to fail if the `source_ami` does not exist.
 	SourceAmiFilter awscommon.AmiFilterOptions `mapstructure:"source_ami_filter" required:"false"`
+	// A list of AWS account IDs allowed to own the source AMI. The owner
+	// aliases `amazon` and `aws-marketplace` are also accepted, and `self` for
+	// the account of the build. If the resolved source AMI is owned by anyone
+	// else, the build fails. This guards against building from an untrusted
+	// public AMI that happens to match `source_ami_filter`. By default any
+	// owner is allowed.
+	AllowedSourceAmiOwners []string `mapstructure:"allowed_source_ami_owners" required:"false"`
 	// Key/value pair tags to apply to the volumes that are *launched*. This is
 	// a [template engine](/packer/docs/templates/legacy_json_templates/engine), see [Build template
 	// data](#build-template-data) for more information.
@@ -194,10 +201,16 @@ type Config struct {
 	// This field is validated by Packer, when using an alias, you will have to
 	// prefix `kms_key_id` with `alias/`.
 	RootVolumeKmsKeyId string `mapstructure:"root_volume_kms_key_id" required:"false"`
+	// The ARN of the AWS Outpost to build on, such as
+	// `arn:aws:outposts:us-east-1:123456789012:outpost/op-1234567890abcdef0`.
+	// The root volume is created on the Outpost, and its snapshot is stored
+	// there rather than in the region. The instance Packer runs on must itself
+	// be on the Outpost.
+	OutpostArn string `mapstructure:"outpost_arn" required:"false"`
 	// what architecture to use when registering the final AMI; valid options
 	// are "arm64", "arm64_mac", "i386", "x86_64", or "x86_64_mac". Defaults to "x86_64".
 	Architecture string `mapstructure:"ami_architecture" required:"false"`
-	// The boot mode. Valid options are `legacy-bios` and `uefi`. See the documentation on
+	// The boot mode. Valid options are `legacy-bios`, `uefi` and `uefi-preferred`. See the documentation on
 	// [boot modes](https://docs.aws.amazon.com/AWSEC2/latest/UserGuide/ami-boot.html) for
 	// more information. Defaults to `legacy-bios` when `ami_architecture` is `x86_64` and
 	// `uefi` when `ami_architecture` is `arm64`.
@@ -209,6 +222,20 @@ type Config struct {
 	// [NitroTPM Support](https://docs.aws.amazon.com/AWSEC2/latest/UserGuide/enable-nitrotpm-support-on-ami.html) for
 	// more information. Only enabled if a valid option is provided, otherwise ignored.
 	TpmSupport string `mapstructure:"tpm_support" required:"false"`
+	// Dump the root device to a local disk image file once provisioning is
+	// done and the device is unmounted. The file is added to the artifact
+	// next to the AMI. The dump command is run without a shell, so
+	// `command_wrapper` must run it as a separate word, like
+	// `sudo {{.Command}}`. Default `false`.
+	ProduceDiskImage bool `mapstructure:"produce_disk_image" required:"false"`
+	// The format of the disk image written when `produce_disk_image` is set.
+	// Valid options are `raw`, `qcow2`, `vmdk`, `vhd` and `vhdx`. The `raw`
+	// format is written with `dd`, every other format requires `qemu-img` to
+	// be installed on the instance running Packer. Defaults to `raw`.
+	DiskImageFormat string `mapstructure:"disk_image_format" required:"false"`
+	// The path of the disk image written when `produce_disk_image` is set.
+	// Defaults to `output-<build name>/<build name>.<disk_image_format>`.
+	DiskImageOutputPath string `mapstructure:"disk_image_output_path" required:"false"`
 
 	ctx interpolate.Context
 }
@@ -305,6 +332,11 @@ func (b *Builder) Prepare(raws ...interface{}) ([]string, []string, error) {
 	errs = packersdk.MultiErrorAppend(errs,
 		b.config.AMIConfig.Prepare(&b.config.AccessConfig, &b.config.ctx)...)
 
+	rootVolume := awscommon.BlockDevice{DeviceName: b.config.RootDeviceName, VolumeSize: b.config.RootVolumeSize}
+	if err := b.config.CheckMaxSize([]awscommon.BlockDevice{rootVolume}, b.config.AMIMappings); err != nil {
+		errs = packersdk.MultiErrorAppend(errs, err)
+	}
+
 	for _, mounts := range b.config.ChrootMounts {
 		if len(mounts) != 3 {
 			errs = packersdk.MultiErrorAppend(
@@ -369,6 +401,11 @@ func (b *Builder) Prepare(raws ...interface{}) ([]string, []string, error) {
 		}
 
 	}
+	if b.config.OutpostArn != "" {
+		if err := awscommon.IsValidOutpostArn(b.config.OutpostArn); err != nil {
+			errs = packersdk.MultiErrorAppend(errs, err)
+		}
+	}
 	valid := false
 	for _, validArch := range []string{"arm64", "arm64_mac", "i386", "x86_64", "x86_64_mac"} {
 		if validArch == b.config.Architecture {
@@ -391,6 +428,22 @@ func (b *Builder) Prepare(raws ...interface{}) ([]string, []string, error) {
 		}
 	}
 
+	if b.config.ProduceDiskImage {
+		if b.config.DiskImageFormat == "" {
+			b.config.DiskImageFormat = "raw"
+		}
+		if _, ok := qemuImgFormats[b.config.DiskImageFormat]; !ok && b.config.DiskImageFormat != "raw" {
+			errs = packersdk.MultiErrorAppend(errs, fmt.Errorf(
+				`The only valid disk_image_format values are "raw", "qcow2", "vmdk", "vhd", or "vhdx"`))
+		}
+		if b.config.DiskImageOutputPath == "" {
+			b.config.DiskImageOutputPath = fmt.Sprintf("output-%s/%s.%s",
+				b.config.PackerBuildName, b.config.PackerBuildName, b.config.DiskImageFormat)
+		}
+	} else if b.config.DiskImageFormat != "" || b.config.DiskImageOutputPath != "" {
+		warns = append(warns, "disk_image_format and disk_image_output_path are unused when produce_disk_image is false")
+	}
+
 	if b.config.UefiData != "" {
 		if b.config.BootMode == "legacy-bios" {
 			errs = packersdk.MultiErrorAppend(errs, fmt.Errorf(`You can't use uefi_data with boot_mode set to "legacy-bios".`))
@@ -406,7 +459,7 @@ func (b *Builder) Prepare(raws ...interface{}) ([]string, []string, error) {
 
 	packersdk.LogSecretFilter.Set(b.config.AccessKey, b.config.SecretKey, b.config.Token)
 	generatedData := awscommon.GetGeneratedDataList()
-	generatedData = append(generatedData, "Device", "MountPath")
+	generatedData = append(generatedData, "Device", "MountPath", "CentralAMI", "UnshareAfter", "FailedCopyRegions")
 
 	return generatedData, warns, nil
 }
@@ -457,6 +510,7 @@ func (b *Builder) Run(ctx context.Context, ui packersdk.Ui, hook packersdk.Hook)
 				EnableAMIENASupport:      b.config.AMIENASupport,
 				AmiFilters:               b.config.SourceAmiFilter,
 				AMIVirtType:              b.config.AMIVirtType,
+				AllowedOwners:            b.config.AllowedSourceAmiOwners,
 			},
 			&StepCheckRootDevice{},
 		)
@@ -474,6 +528,7 @@ func (b *Builder) Run(ctx context.Context, ui packersdk.Ui, hook packersdk.Hook)
 			RootVolumeTags:        b.config.RootVolumeTags,
 			RootVolumeEncryptBoot: b.config.RootVolumeEncryptBoot,
 			RootVolumeKmsKeyId:    b.config.RootVolumeKmsKeyId,
+			OutpostArn:            b.config.OutpostArn,
 			Ctx:                   b.config.ctx,
 		},
 		&StepAttachVolume{
@@ -501,9 +556,22 @@ func (b *Builder) Run(ctx context.Context, ui packersdk.Ui, hook packersdk.Hook)
 			GeneratedData: generatedData,
 		},
 		&chroot.StepChrootProvision{},
+	)
+
+	if b.config.ProduceDiskImage {
+		steps = append(steps,
+			&StepCreateDiskImage{
+				Format:     b.config.DiskImageFormat,
+				OutputPath: b.config.DiskImageOutputPath,
+			},
+		)
+	}
+
+	steps = append(steps,
 		&chroot.StepEarlyCleanup{},
 		&StepSnapshot{
 			PollingConfig: b.config.PollingConfig,
+			OutpostArn:    b.config.OutpostArn,
 		},
 		&awscommon.StepDeregisterAMI{
 			AccessConfig:        &b.config.AccessConfig,
@@ -531,32 +599,50 @@ func (b *Builder) Run(ctx context.Context, ui packersdk.Ui, hook packersdk.Hook)
 			Name:                           b.config.AMIName,
 			OriginalRegion:                 *ec2conn.Config.Region,
 			AMISnapshotCopyDurationMinutes: b.config.AMISnapshotCopyDurationMinutes,
+			IgnoreCopyErrors:               b.config.AMIIgnoreCopyErrors,
+			RegionVolumeTypes:              b.config.AMIRegionVolumeTypes,
+			GeneratedData:                  generatedData,
 		},
 		&awscommon.StepEnableDeprecation{
-			AccessConfig:    &b.config.AccessConfig,
-			DeprecationTime: b.config.DeprecationTime,
+			AccessConfig:          &b.config.AccessConfig,
+			DeprecationTime:       b.config.DeprecationTime,
+			DeprecatePreviousTags: b.config.DeprecatePreviousTags,
 		},
 		&awscommon.StepEnableDeregistrationProtection{
 			AccessConfig:             &b.config.AccessConfig,
 			DeregistrationProtection: &b.config.DeregistrationProtection,
 		},
+		&awscommon.StepCentralAccountCopy{
+			AccessConfig:  &b.config.AccessConfig,
+			Role:          b.config.AMICentralAccountRole,
+			KmsKeyId:      b.config.AMICentralAccountKmsKeyId,
+			Name:          b.config.AMIName,
+			Region:        *ec2conn.Config.Region,
+			PollingConfig: b.config.PollingConfig,
+			GeneratedData: generatedData,
+		},
 		&awscommon.StepModifyAMIAttributes{
-			Description:    b.config.AMIDescription,
-			Users:          b.config.AMIUsers,
-			Groups:         b.config.AMIGroups,
-			OrgArns:        b.config.AMIOrgArns,
-			OuArns:         b.config.AMIOuArns,
-			ProductCodes:   b.config.AMIProductCodes,
-			SnapshotUsers:  b.config.SnapshotUsers,
-			SnapshotGroups: b.config.SnapshotGroups,
-			IMDSSupport:    b.config.AMIIMDSSupport,
-			Ctx:            b.config.ctx,
-			GeneratedData:  generatedData,
+			Description:       b.config.AMIDescription,
+			Users:             b.config.AMIUsers,
+			UsersSSMParameter: b.config.AMIUsersSSMParameter,
+			Groups:            b.config.AMIGroups,
+			OrgArns:           b.config.AMIOrgArns,
+			OuArns:            b.config.AMIOuArns,
+			ProductCodes:      b.config.AMIProductCodes,
+			ShareDuration:     b.config.AMIShareDuration,
+			SnapshotUsers:     b.config.SnapshotUsers,
+			SnapshotGroups:    b.config.SnapshotGroups,
+			WaitForSnapshots:  b.config.AMIWaitForSnapshots,
+			PollingConfig:     b.config.PollingConfig,
+			IMDSSupport:       b.config.AMIIMDSSupport,
+			Ctx:               b.config.ctx,
+			GeneratedData:     generatedData,
 		},
 		&awscommon.StepCreateTags{
-			Tags:         b.config.AMITags,
-			SnapshotTags: b.config.SnapshotTags,
-			Ctx:          b.config.ctx,
+			Tags:           b.config.AMITags,
+			SnapshotTags:   b.config.SnapshotTags,
+			TagCopyRegions: b.config.AMITagCopyRegions,
+			Ctx:            b.config.ctx,
 		},
 	)
 
@@ -582,5 +668,12 @@ func (b *Builder) Run(ctx context.Context, ui packersdk.Ui, hook packersdk.Hook)
 		StateData:      map[string]interface{}{"generated_data": state.Get("generated_data")},
 	}
 
+	if centralAmi, ok := state.GetOk("central_ami"); ok {
+		artifact.CentralAmi = centralAmi.(string)
+	}
+	if diskImagePath, ok := state.GetOk("disk_image_path"); ok {
+		artifact.LocalFiles = []string{diskImagePath.(string)}
+	}
+
 	return artifact, nil
 }
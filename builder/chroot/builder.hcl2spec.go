@@ -24,14 +24,20 @@ type FlatConfig struct {
 	AMIDescription                 *string                                     `mapstructure:"ami_description" required:"false" cty:"ami_description" hcl:"ami_description"`
 	AMIVirtType                    *string                                     `mapstructure:"ami_virtualization_type" required:"false" cty:"ami_virtualization_type" hcl:"ami_virtualization_type"`
 	AMIUsers                       []string                                    `mapstructure:"ami_users" required:"false" cty:"ami_users" hcl:"ami_users"`
+	AMIUsersFile                   *string                                     `mapstructure:"ami_users_file" required:"false" cty:"ami_users_file" hcl:"ami_users_file"`
+	AMIUsersSSMParameter           *string                                     `mapstructure:"ami_users_ssm_parameter" required:"false" cty:"ami_users_ssm_parameter" hcl:"ami_users_ssm_parameter"`
 	AMIGroups                      []string                                    `mapstructure:"ami_groups" required:"false" cty:"ami_groups" hcl:"ami_groups"`
 	AMIOrgArns                     []string                                    `mapstructure:"ami_org_arns" required:"false" cty:"ami_org_arns" hcl:"ami_org_arns"`
 	AMIOuArns                      []string                                    `mapstructure:"ami_ou_arns" required:"false" cty:"ami_ou_arns" hcl:"ami_ou_arns"`
+	AMIShareDuration               *string                                     `mapstructure:"ami_share_duration" required:"false" cty:"ami_share_duration" hcl:"ami_share_duration"`
 	AMIProductCodes                []string                                    `mapstructure:"ami_product_codes" required:"false" cty:"ami_product_codes" hcl:"ami_product_codes"`
+	AMIBillingProducts             []string                                    `mapstructure:"ami_billing_products" required:"false" cty:"ami_billing_products" hcl:"ami_billing_products"`
 	AMIRegions                     []string                                    `mapstructure:"ami_regions" required:"false" cty:"ami_regions" hcl:"ami_regions"`
 	AMISkipRegionValidation        *bool                                       `mapstructure:"skip_region_validation" required:"false" cty:"skip_region_validation" hcl:"skip_region_validation"`
 	AMITags                        map[string]string                           `mapstructure:"tags" required:"false" cty:"tags" hcl:"tags"`
 	AMITag                         []config.FlatKeyValue                       `mapstructure:"tag" required:"false" cty:"tag" hcl:"tag"`
+	AMITagCopyRegions              *bool                                       `mapstructure:"tag_copy_regions" required:"false" cty:"tag_copy_regions" hcl:"tag_copy_regions"`
+	AMIMaxSizeGB                   *int64                                      `mapstructure:"max_ami_size_gb" required:"false" cty:"max_ami_size_gb" hcl:"max_ami_size_gb"`
 	AMIENASupport                  *bool                                       `mapstructure:"ena_support" required:"false" cty:"ena_support" hcl:"ena_support"`
 	AMISriovNetSupport             *bool                                       `mapstructure:"sriov_support" required:"false" cty:"sriov_support" hcl:"sriov_support"`
 	AMIForceDeregister             *bool                                       `mapstructure:"force_deregister" required:"false" cty:"force_deregister" hcl:"force_deregister"`
@@ -39,10 +45,18 @@ type FlatConfig struct {
 	AMIEncryptBootVolume           *bool                                       `mapstructure:"encrypt_boot" required:"false" cty:"encrypt_boot" hcl:"encrypt_boot"`
 	AMIKmsKeyId                    *string                                     `mapstructure:"kms_key_id" required:"false" cty:"kms_key_id" hcl:"kms_key_id"`
 	AMIRegionKMSKeyIDs             map[string]string                           `mapstructure:"region_kms_key_ids" required:"false" cty:"region_kms_key_ids" hcl:"region_kms_key_ids"`
+	AMIValidateKmsKeys             *bool                                       `mapstructure:"validate_kms_keys" required:"false" cty:"validate_kms_keys" hcl:"validate_kms_keys"`
 	AMISkipBuildRegion             *bool                                       `mapstructure:"skip_save_build_region" cty:"skip_save_build_region" hcl:"skip_save_build_region"`
 	AMISnapshotCopyDurationMinutes *int64                                      `mapstructure:"snapshot_copy_duration_minutes" required:"false" cty:"snapshot_copy_duration_minutes" hcl:"snapshot_copy_duration_minutes"`
+	AMIIgnoreCopyErrors            *bool                                       `mapstructure:"ignore_copy_errors" required:"false" cty:"ignore_copy_errors" hcl:"ignore_copy_errors"`
+	AMIRegionVolumeTypes           map[string]string                           `mapstructure:"region_volume_types" required:"false" cty:"region_volume_types" hcl:"region_volume_types"`
 	AMIIMDSSupport                 *string                                     `mapstructure:"imds_support" required:"false" cty:"imds_support" hcl:"imds_support"`
+	AMIInstanceMetadataTags        *bool                                       `mapstructure:"imds_instance_metadata_tags" required:"false" cty:"imds_instance_metadata_tags" hcl:"imds_instance_metadata_tags"`
 	DeprecationTime                *string                                     `mapstructure:"deprecate_at" cty:"deprecate_at" hcl:"deprecate_at"`
+	DeprecatePreviousTags          map[string]string                           `mapstructure:"deprecate_previous_tags" required:"false" cty:"deprecate_previous_tags" hcl:"deprecate_previous_tags"`
+	AMIWaitForSnapshots            *bool                                       `mapstructure:"wait_for_snapshots_complete" required:"false" cty:"wait_for_snapshots_complete" hcl:"wait_for_snapshots_complete"`
+	AMICentralAccountRole          *common.FlatAssumeRoleConfig                `mapstructure:"central_account_role" required:"false" cty:"central_account_role" hcl:"central_account_role"`
+	AMICentralAccountKmsKeyId      *string                                     `mapstructure:"central_account_kms_key_id" required:"false" cty:"central_account_kms_key_id" hcl:"central_account_kms_key_id"`
 	SnapshotTags                   map[string]string                           `mapstructure:"snapshot_tags" required:"false" cty:"snapshot_tags" hcl:"snapshot_tags"`
 	SnapshotTag                    []config.FlatKeyValue                       `mapstructure:"snapshot_tag" required:"false" cty:"snapshot_tag" hcl:"snapshot_tag"`
 	SnapshotUsers                  []string                                    `mapstructure:"snapshot_users" required:"false" cty:"snapshot_users" hcl:"snapshot_users"`
@@ -55,12 +69,15 @@ type FlatConfig struct {
 	DecodeAuthZMessages            *bool                                       `mapstructure:"decode_authorization_messages" required:"false" cty:"decode_authorization_messages" hcl:"decode_authorization_messages"`
 	InsecureSkipTLSVerify          *bool                                       `mapstructure:"insecure_skip_tls_verify" required:"false" cty:"insecure_skip_tls_verify" hcl:"insecure_skip_tls_verify"`
 	MaxRetries                     *int                                        `mapstructure:"max_retries" required:"false" cty:"max_retries" hcl:"max_retries"`
+	RetryMode                      *string                                     `mapstructure:"aws_retry_mode" required:"false" cty:"aws_retry_mode" hcl:"aws_retry_mode"`
 	MFACode                        *string                                     `mapstructure:"mfa_code" required:"false" cty:"mfa_code" hcl:"mfa_code"`
 	ProfileName                    *string                                     `mapstructure:"profile" required:"false" cty:"profile" hcl:"profile"`
 	RawRegion                      *string                                     `mapstructure:"region" required:"true" cty:"region" hcl:"region"`
 	SecretKey                      *string                                     `mapstructure:"secret_key" required:"true" cty:"secret_key" hcl:"secret_key"`
-	SkipMetadataApiCheck           *bool                                       `mapstructure:"skip_metadata_api_check" cty:"skip_metadata_api_check" hcl:"skip_metadata_api_check"`
+	SkipMetadataApiCheck           *bool                                       `mapstructure:"skip_metadata_api_check" required:"false" cty:"skip_metadata_api_check" hcl:"skip_metadata_api_check"`
 	SkipCredsValidation            *bool                                       `mapstructure:"skip_credential_validation" cty:"skip_credential_validation" hcl:"skip_credential_validation"`
+	UserAgentSuffix                *string                                     `mapstructure:"aws_user_agent_suffix" required:"false" cty:"aws_user_agent_suffix" hcl:"aws_user_agent_suffix"`
+	PerRequestTimeout              *string                                     `mapstructure:"per_request_timeout" required:"false" cty:"per_request_timeout" hcl:"per_request_timeout"`
 	Token                          *string                                     `mapstructure:"token" required:"false" cty:"token" hcl:"token"`
 	VaultAWSEngine                 *common.FlatVaultAWSEngineOptions           `mapstructure:"vault_aws_engine" required:"false" cty:"vault_aws_engine" hcl:"vault_aws_engine"`
 	PollingConfig                  *common.FlatAWSPollingConfig                `mapstructure:"aws_polling" required:"false" cty:"aws_polling" hcl:"aws_polling"`
@@ -81,14 +98,19 @@ type FlatConfig struct {
 	RootVolumeType                 *string                                     `mapstructure:"root_volume_type" required:"false" cty:"root_volume_type" hcl:"root_volume_type"`
 	SourceAmi                      *string                                     `mapstructure:"source_ami" required:"true" cty:"source_ami" hcl:"source_ami"`
 	SourceAmiFilter                *common.FlatAmiFilterOptions                `mapstructure:"source_ami_filter" required:"false" cty:"source_ami_filter" hcl:"source_ami_filter"`
+	AllowedSourceAmiOwners         []string                                    `mapstructure:"allowed_source_ami_owners" required:"false" cty:"allowed_source_ami_owners" hcl:"allowed_source_ami_owners"`
 	RootVolumeTags                 map[string]string                           `mapstructure:"root_volume_tags" required:"false" cty:"root_volume_tags" hcl:"root_volume_tags"`
 	RootVolumeTag                  []config.FlatKeyValue                       `mapstructure:"root_volume_tag" required:"false" cty:"root_volume_tag" hcl:"root_volume_tag"`
 	RootVolumeEncryptBoot          *bool                                       `mapstructure:"root_volume_encrypt_boot" required:"false" cty:"root_volume_encrypt_boot" hcl:"root_volume_encrypt_boot"`
 	RootVolumeKmsKeyId             *string                                     `mapstructure:"root_volume_kms_key_id" required:"false" cty:"root_volume_kms_key_id" hcl:"root_volume_kms_key_id"`
+	OutpostArn                     *string                                     `mapstructure:"outpost_arn" required:"false" cty:"outpost_arn" hcl:"outpost_arn"`
 	Architecture                   *string                                     `mapstructure:"ami_architecture" required:"false" cty:"ami_architecture" hcl:"ami_architecture"`
 	BootMode                       *string                                     `mapstructure:"boot_mode" required:"false" cty:"boot_mode" hcl:"boot_mode"`
 	UefiData                       *string                                     `mapstructure:"uefi_data" required:"false" cty:"uefi_data" hcl:"uefi_data"`
 	TpmSupport                     *string                                     `mapstructure:"tpm_support" required:"false" cty:"tpm_support" hcl:"tpm_support"`
+	ProduceDiskImage               *bool                                       `mapstructure:"produce_disk_image" required:"false" cty:"produce_disk_image" hcl:"produce_disk_image"`
+	DiskImageFormat                *string                                     `mapstructure:"disk_image_format" required:"false" cty:"disk_image_format" hcl:"disk_image_format"`
+	DiskImageOutputPath            *string                                     `mapstructure:"disk_image_output_path" required:"false" cty:"disk_image_output_path" hcl:"disk_image_output_path"`
 }
 
 // FlatMapstructure returns a new FlatConfig.
@@ -115,14 +137,20 @@ func (*FlatConfig) HCL2Spec() map[string]hcldec.Spec {
 		"ami_description":                &hcldec.AttrSpec{Name: "ami_description", Type: cty.String, Required: false},
 		"ami_virtualization_type":        &hcldec.AttrSpec{Name: "ami_virtualization_type", Type: cty.String, Required: false},
 		"ami_users":                      &hcldec.AttrSpec{Name: "ami_users", Type: cty.List(cty.String), Required: false},
+		"ami_users_file":                 &hcldec.AttrSpec{Name: "ami_users_file", Type: cty.String, Required: false},
+		"ami_users_ssm_parameter":        &hcldec.AttrSpec{Name: "ami_users_ssm_parameter", Type: cty.String, Required: false},
 		"ami_groups":                     &hcldec.AttrSpec{Name: "ami_groups", Type: cty.List(cty.String), Required: false},
 		"ami_org_arns":                   &hcldec.AttrSpec{Name: "ami_org_arns", Type: cty.List(cty.String), Required: false},
 		"ami_ou_arns":                    &hcldec.AttrSpec{Name: "ami_ou_arns", Type: cty.List(cty.String), Required: false},
+		"ami_share_duration":             &hcldec.AttrSpec{Name: "ami_share_duration", Type: cty.String, Required: false},
 		"ami_product_codes":              &hcldec.AttrSpec{Name: "ami_product_codes", Type: cty.List(cty.String), Required: false},
+		"ami_billing_products":           &hcldec.AttrSpec{Name: "ami_billing_products", Type: cty.List(cty.String), Required: false},
 		"ami_regions":                    &hcldec.AttrSpec{Name: "ami_regions", Type: cty.List(cty.String), Required: false},
 		"skip_region_validation":         &hcldec.AttrSpec{Name: "skip_region_validation", Type: cty.Bool, Required: false},
 		"tags":                           &hcldec.AttrSpec{Name: "tags", Type: cty.Map(cty.String), Required: false},
 		"tag":                            &hcldec.BlockListSpec{TypeName: "tag", Nested: hcldec.ObjectSpec((*config.FlatKeyValue)(nil).HCL2Spec())},
+		"tag_copy_regions":               &hcldec.AttrSpec{Name: "tag_copy_regions", Type: cty.Bool, Required: false},
+		"max_ami_size_gb":                &hcldec.AttrSpec{Name: "max_ami_size_gb", Type: cty.Number, Required: false},
 		"ena_support":                    &hcldec.AttrSpec{Name: "ena_support", Type: cty.Bool, Required: false},
 		"sriov_support":                  &hcldec.AttrSpec{Name: "sriov_support", Type: cty.Bool, Required: false},
 		"force_deregister":               &hcldec.AttrSpec{Name: "force_deregister", Type: cty.Bool, Required: false},
@@ -130,10 +158,18 @@ func (*FlatConfig) HCL2Spec() map[string]hcldec.Spec {
 		"encrypt_boot":                   &hcldec.AttrSpec{Name: "encrypt_boot", Type: cty.Bool, Required: false},
 		"kms_key_id":                     &hcldec.AttrSpec{Name: "kms_key_id", Type: cty.String, Required: false},
 		"region_kms_key_ids":             &hcldec.AttrSpec{Name: "region_kms_key_ids", Type: cty.Map(cty.String), Required: false},
+		"validate_kms_keys":              &hcldec.AttrSpec{Name: "validate_kms_keys", Type: cty.Bool, Required: false},
 		"skip_save_build_region":         &hcldec.AttrSpec{Name: "skip_save_build_region", Type: cty.Bool, Required: false},
 		"snapshot_copy_duration_minutes": &hcldec.AttrSpec{Name: "snapshot_copy_duration_minutes", Type: cty.Number, Required: false},
+		"ignore_copy_errors":             &hcldec.AttrSpec{Name: "ignore_copy_errors", Type: cty.Bool, Required: false},
+		"region_volume_types":            &hcldec.AttrSpec{Name: "region_volume_types", Type: cty.Map(cty.String), Required: false},
 		"imds_support":                   &hcldec.AttrSpec{Name: "imds_support", Type: cty.String, Required: false},
+		"imds_instance_metadata_tags":    &hcldec.AttrSpec{Name: "imds_instance_metadata_tags", Type: cty.Bool, Required: false},
 		"deprecate_at":                   &hcldec.AttrSpec{Name: "deprecate_at", Type: cty.String, Required: false},
+		"deprecate_previous_tags":        &hcldec.AttrSpec{Name: "deprecate_previous_tags", Type: cty.Map(cty.String), Required: false},
+		"wait_for_snapshots_complete":    &hcldec.AttrSpec{Name: "wait_for_snapshots_complete", Type: cty.Bool, Required: false},
+		"central_account_role":           &hcldec.BlockSpec{TypeName: "central_account_role", Nested: hcldec.ObjectSpec((*common.FlatAssumeRoleConfig)(nil).HCL2Spec())},
+		"central_account_kms_key_id":     &hcldec.AttrSpec{Name: "central_account_kms_key_id", Type: cty.String, Required: false},
 		"snapshot_tags":                  &hcldec.AttrSpec{Name: "snapshot_tags", Type: cty.Map(cty.String), Required: false},
 		"snapshot_tag":                   &hcldec.BlockListSpec{TypeName: "snapshot_tag", Nested: hcldec.ObjectSpec((*config.FlatKeyValue)(nil).HCL2Spec())},
 		"snapshot_users":                 &hcldec.AttrSpec{Name: "snapshot_users", Type: cty.List(cty.String), Required: false},
@@ -146,12 +182,15 @@ func (*FlatConfig) HCL2Spec() map[string]hcldec.Spec {
 		"decode_authorization_messages":  &hcldec.AttrSpec{Name: "decode_authorization_messages", Type: cty.Bool, Required: false},
 		"insecure_skip_tls_verify":       &hcldec.AttrSpec{Name: "insecure_skip_tls_verify", Type: cty.Bool, Required: false},
 		"max_retries":                    &hcldec.AttrSpec{Name: "max_retries", Type: cty.Number, Required: false},
+		"aws_retry_mode":                 &hcldec.AttrSpec{Name: "aws_retry_mode", Type: cty.String, Required: false},
 		"mfa_code":                       &hcldec.AttrSpec{Name: "mfa_code", Type: cty.String, Required: false},
 		"profile":                        &hcldec.AttrSpec{Name: "profile", Type: cty.String, Required: false},
 		"region":                         &hcldec.AttrSpec{Name: "region", Type: cty.String, Required: false},
 		"secret_key":                     &hcldec.AttrSpec{Name: "secret_key", Type: cty.String, Required: false},
 		"skip_metadata_api_check":        &hcldec.AttrSpec{Name: "skip_metadata_api_check", Type: cty.Bool, Required: false},
 		"skip_credential_validation":     &hcldec.AttrSpec{Name: "skip_credential_validation", Type: cty.Bool, Required: false},
+		"aws_user_agent_suffix":          &hcldec.AttrSpec{Name: "aws_user_agent_suffix", Type: cty.String, Required: false},
+		"per_request_timeout":            &hcldec.AttrSpec{Name: "per_request_timeout", Type: cty.String, Required: false},
 		"token":                          &hcldec.AttrSpec{Name: "token", Type: cty.String, Required: false},
 		"vault_aws_engine":               &hcldec.BlockSpec{TypeName: "vault_aws_engine", Nested: hcldec.ObjectSpec((*common.FlatVaultAWSEngineOptions)(nil).HCL2Spec())},
 		"aws_polling":                    &hcldec.BlockSpec{TypeName: "aws_polling", Nested: hcldec.ObjectSpec((*common.FlatAWSPollingConfig)(nil).HCL2Spec())},
@@ -172,14 +211,19 @@ func (*FlatConfig) HCL2Spec() map[string]hcldec.Spec {
 		"root_volume_type":               &hcldec.AttrSpec{Name: "root_volume_type", Type: cty.String, Required: false},
 		"source_ami":                     &hcldec.AttrSpec{Name: "source_ami", Type: cty.String, Required: false},
 		"source_ami_filter":              &hcldec.BlockSpec{TypeName: "source_ami_filter", Nested: hcldec.ObjectSpec((*common.FlatAmiFilterOptions)(nil).HCL2Spec())},
+		"allowed_source_ami_owners":      &hcldec.AttrSpec{Name: "allowed_source_ami_owners", Type: cty.List(cty.String), Required: false},
 		"root_volume_tags":               &hcldec.AttrSpec{Name: "root_volume_tags", Type: cty.Map(cty.String), Required: false},
 		"root_volume_tag":                &hcldec.BlockListSpec{TypeName: "root_volume_tag", Nested: hcldec.ObjectSpec((*config.FlatKeyValue)(nil).HCL2Spec())},
 		"root_volume_encrypt_boot":       &hcldec.AttrSpec{Name: "root_volume_encrypt_boot", Type: cty.Bool, Required: false},
 		"root_volume_kms_key_id":         &hcldec.AttrSpec{Name: "root_volume_kms_key_id", Type: cty.String, Required: false},
+		"outpost_arn":                    &hcldec.AttrSpec{Name: "outpost_arn", Type: cty.String, Required: false},
 		"ami_architecture":               &hcldec.AttrSpec{Name: "ami_architecture", Type: cty.String, Required: false},
 		"boot_mode":                      &hcldec.AttrSpec{Name: "boot_mode", Type: cty.String, Required: false},
 		"uefi_data":                      &hcldec.AttrSpec{Name: "uefi_data", Type: cty.String, Required: false},
 		"tpm_support":                    &hcldec.AttrSpec{Name: "tpm_support", Type: cty.String, Required: false},
+		"produce_disk_image":             &hcldec.AttrSpec{Name: "produce_disk_image", Type: cty.Bool, Required: false},
+		"disk_image_format":              &hcldec.AttrSpec{Name: "disk_image_format", Type: cty.String, Required: false},
+		"disk_image_output_path":         &hcldec.AttrSpec{Name: "disk_image_output_path", Type: cty.String, Required: false},
 	}
 	return s
 }
@@ -409,3 +409,28 @@ func TestBuilderPrepare_TpmSupportValue(t *testing.T) {
 		})
 	}
 }
+
+func TestBuilderPrepare_DiskImage(t *testing.T) {
+	var b Builder
+	config := testConfig()
+	config["produce_disk_image"] = true
+	config["packer_build_name"] = "chroot"
+
+	_, _, err := b.Prepare(config)
+	if err != nil {
+		t.Fatalf("should not have error: %s", err)
+	}
+	if b.config.DiskImageFormat != "raw" {
+		t.Fatalf("disk_image_format should default to raw, got %q", b.config.DiskImageFormat)
+	}
+	if b.config.DiskImageOutputPath != "output-chroot/chroot.raw" {
+		t.Fatalf("unexpected default disk_image_output_path %q", b.config.DiskImageOutputPath)
+	}
+
+	config["disk_image_format"] = "iso"
+	b = Builder{}
+	_, _, err = b.Prepare(config)
+	if err == nil {
+		t.Fatal("should have error for an invalid disk_image_format")
+	}
+}
@@ -0,0 +1,137 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package chroot
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/hashicorp/packer-plugin-sdk/chroot"
+	"github.com/hashicorp/packer-plugin-sdk/common"
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
+)
+
+// qemuImgFormats maps the supported disk_image_format values to the output
+// format names understood by qemu-img.
+var qemuImgFormats = map[string]string{
+	"qcow2": "qcow2",
+	"vmdk":  "vmdk",
+	"vhd":   "vpc",
+	"vhdx":  "vhdx",
+}
+
+// execCommand creates the dump command, it is replaced in tests.
+var execCommand = exec.Command
+
+// StepCreateDiskImage dumps the root device to a local disk image file. The
+// dump has to happen once the device is unmounted but before the volume is
+// detached, so this step wraps the attach cleanup that StepEarlyCleanup runs
+// last.
+//
+// Produces:
+//
+//	disk_image_path string - The path of the produced disk image.
+//	attach_cleanup CleanupFunc - Dumps the device, then detaches it.
+type StepCreateDiskImage struct {
+	Format     string
+	OutputPath string
+
+	attachCleanup chroot.Cleanup
+	created       bool
+}
+
+func (s *StepCreateDiskImage) Run(ctx context.Context, state multistep.StateBag) multistep.StepAction {
+	s.attachCleanup = state.Get("attach_cleanup").(chroot.Cleanup)
+	state.Put("attach_cleanup", s)
+	return multistep.ActionContinue
+}
+
+func (s *StepCreateDiskImage) Cleanup(state multistep.StateBag) {
+	_, cancelled := state.GetOk(multistep.StateCancelled)
+	_, halted := state.GetOk(multistep.StateHalted)
+
+	if s.created && (cancelled || halted) {
+		ui := state.Get("ui").(packersdk.Ui)
+		ui.Say("Removing disk image since we cancelled or halted...")
+		if err := os.Remove(s.OutputPath); err != nil {
+			ui.Error(fmt.Sprintf("Error removing disk image: %s", err))
+		}
+	}
+}
+
+func (s *StepCreateDiskImage) CleanupFunc(state multistep.StateBag) error {
+	if !s.created {
+		if err := s.createDiskImage(state); err != nil {
+			return err
+		}
+	}
+
+	return s.attachCleanup.CleanupFunc(state)
+}
+
+func (s *StepCreateDiskImage) createDiskImage(state multistep.StateBag) error {
+	config := state.Get("config").(*Config)
+	ui := state.Get("ui").(packersdk.Ui)
+	device := state.Get("device").(string)
+	if config.NVMEDevicePath != "" {
+		device = config.NVMEDevicePath
+	}
+	wrappedCommand := state.Get("wrappedCommand").(common.CommandWrapper)
+
+	if err := os.MkdirAll(filepath.Dir(s.OutputPath), 0755); err != nil {
+		return fmt.Errorf("Error creating disk image directory: %s", err)
+	}
+
+	args := []string{"dd", "if=" + device, "of=" + s.OutputPath, "bs=4M"}
+	if format, ok := qemuImgFormats[s.Format]; ok {
+		args = []string{"qemu-img", "convert", "-f", "raw", "-O", format, device, s.OutputPath}
+	}
+
+	ui.Say(fmt.Sprintf("Creating %s disk image %s...", s.Format, s.OutputPath))
+	args, err := wrapArgs(wrappedCommand, args)
+	if err != nil {
+		return fmt.Errorf("Error creating disk image command: %s", err)
+	}
+	log.Printf("[DEBUG] (step disk image) dump command is %q", args)
+
+	stderr := new(bytes.Buffer)
+	cmd := execCommand(args[0], args[1:]...)
+	cmd.Stderr = stderr
+	if err := cmd.Run(); err != nil {
+		os.Remove(s.OutputPath)
+		return fmt.Errorf("Error creating disk image: %s\nStderr: %s", err, stderr.String())
+	}
+
+	s.created = true
+	state.Put("disk_image_path", s.OutputPath)
+	return nil
+}
+
+// wrapArgs applies the command wrapper to the command args without a shell,
+// so that the paths in args are passed as they are. The wrapper is rendered
+// for the program alone and split into words, and the arguments are inserted
+// after the program.
+func wrapArgs(wrappedCommand common.CommandWrapper, args []string) ([]string, error) {
+	wrapped, err := wrappedCommand(args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	words := strings.Fields(wrapped)
+	for i, word := range words {
+		if word == args[0] {
+			wrappedArgs := append([]string{}, words[:i+1]...)
+			wrappedArgs = append(wrappedArgs, args[1:]...)
+			return append(wrappedArgs, words[i+1:]...), nil
+		}
+	}
+	return nil, fmt.Errorf("command_wrapper must run %s as a separate word, got %q", args[0], wrapped)
+}
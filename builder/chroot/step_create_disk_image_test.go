@@ -0,0 +1,146 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package chroot
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/hashicorp/packer-plugin-sdk/chroot"
+	"github.com/hashicorp/packer-plugin-sdk/common"
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
+	"github.com/hashicorp/packer-plugin-sdk/template/interpolate"
+)
+
+type mockAttachCleanup struct {
+	called bool
+}
+
+func (m *mockAttachCleanup) CleanupFunc(state multistep.StateBag) error {
+	m.called = true
+	return nil
+}
+
+// stubExecCommand records the dump command and runs stub instead.
+func stubExecCommand(t *testing.T, stub ...string) *[]string {
+	var dumpCommand []string
+	t.Cleanup(func() { execCommand = exec.Command })
+	execCommand = func(name string, arg ...string) *exec.Cmd {
+		dumpCommand = append([]string{name}, arg...)
+		return exec.Command(stub[0], stub[1:]...)
+	}
+	return &dumpCommand
+}
+
+func TestCreateDiskImageCleanupFunc_ImplementsCleanupFunc(t *testing.T) {
+	var raw interface{}
+	raw = new(StepCreateDiskImage)
+	if _, ok := raw.(chroot.Cleanup); !ok {
+		t.Fatalf("cleanup func should be a CleanupFunc")
+	}
+}
+
+func TestCreateDiskImage_DumpsDeviceBeforeDetach(t *testing.T) {
+	// The path is passed as is, without being split by a shell.
+	outputPath := filepath.Join(t.TempDir(), "output dir", "disk.raw")
+	attachCleanup := &mockAttachCleanup{}
+	dumpCommand := stubExecCommand(t, "touch", outputPath)
+
+	state := new(multistep.BasicStateBag)
+	state.Put("config", &Config{})
+	state.Put("ui", packersdk.TestUi(t))
+	state.Put("device", "/dev/xvdf")
+	state.Put("attach_cleanup", attachCleanup)
+	state.Put("wrappedCommand", common.CommandWrapper(func(command string) (string, error) {
+		return "sudo " + command, nil
+	}))
+
+	step := &StepCreateDiskImage{
+		Format:     "raw",
+		OutputPath: outputPath,
+	}
+	if action := step.Run(context.Background(), state); action != multistep.ActionContinue {
+		t.Fatalf("bad action: %#v", action)
+	}
+
+	cleanup := state.Get("attach_cleanup").(chroot.Cleanup)
+	if err := cleanup.CleanupFunc(state); err != nil {
+		t.Fatalf("should not have error: %s", err)
+	}
+
+	want := []string{"sudo", "dd", "if=/dev/xvdf", "of=" + outputPath, "bs=4M"}
+	if diff := cmp.Diff(want, *dumpCommand); diff != "" {
+		t.Fatalf("unexpected dump command: %s", diff)
+	}
+	if !attachCleanup.called {
+		t.Fatal("the volume should be detached once the disk image is created")
+	}
+	if path := state.Get("disk_image_path"); path != outputPath {
+		t.Fatalf("expected disk_image_path %q, got %v", outputPath, path)
+	}
+	if _, err := os.Stat(outputPath); err != nil {
+		t.Fatalf("disk image should exist: %s", err)
+	}
+}
+
+func TestCreateDiskImage_QemuImgFormat(t *testing.T) {
+	outputPath := filepath.Join(t.TempDir(), "disk.vhd")
+
+	dumpCommand := stubExecCommand(t, "true")
+
+	state := new(multistep.BasicStateBag)
+	state.Put("config", &Config{NVMEDevicePath: "/dev/nvme1n1"})
+	state.Put("ui", packersdk.TestUi(t))
+	state.Put("device", "/dev/xvdf")
+	state.Put("attach_cleanup", &mockAttachCleanup{})
+	state.Put("wrappedCommand", common.CommandWrapper(func(command string) (string, error) {
+		return command, nil
+	}))
+
+	step := &StepCreateDiskImage{
+		Format:     "vhd",
+		OutputPath: outputPath,
+	}
+	step.Run(context.Background(), state)
+	if err := step.CleanupFunc(state); err != nil {
+		t.Fatalf("should not have error: %s", err)
+	}
+
+	want := []string{"qemu-img", "convert", "-f", "raw", "-O", "vpc", "/dev/nvme1n1", outputPath}
+	if diff := cmp.Diff(want, *dumpCommand); diff != "" {
+		t.Fatalf("unexpected dump command: %s", diff)
+	}
+}
+
+func TestWrapArgs(t *testing.T) {
+	tests := []struct {
+		wrapper     string
+		expected    []string
+		expectError bool
+	}{
+		{"{{.Command}}", []string{"dd", "if=/dev/xvdf"}, false},
+		{"sudo -E {{.Command}}", []string{"sudo", "-E", "dd", "if=/dev/xvdf"}, false},
+		{"nice {{.Command}} ", []string{"nice", "dd", "if=/dev/xvdf"}, false},
+		{"sh -c '{{.Command}}'", nil, true},
+	}
+	for _, tt := range tests {
+		config := &Config{CommandWrapper: tt.wrapper}
+		wrappedCommand := common.CommandWrapper(func(command string) (string, error) {
+			config.ctx.Data = &wrappedCommandTemplate{Command: command}
+			return interpolate.Render(config.CommandWrapper, &config.ctx)
+		})
+		args, err := wrapArgs(wrappedCommand, []string{"dd", "if=/dev/xvdf"})
+		if (err != nil) != tt.expectError {
+			t.Fatalf("%s: expected error %t, got %v", tt.wrapper, tt.expectError, err)
+		}
+		if diff := cmp.Diff(tt.expected, args); diff != "" {
+			t.Fatalf("%s: unexpected args: %s", tt.wrapper, diff)
+		}
+	}
+}
@@ -32,6 +32,7 @@ type StepCreateVolume struct {
 	RootVolumeTags        map[string]string
 	RootVolumeEncryptBoot config.Trilean
 	RootVolumeKmsKeyId    string
+	OutpostArn            string
 	Ctx                   interpolate.Context
 }
 
@@ -77,6 +78,9 @@ func (s *StepCreateVolume) Run(ctx context.Context, state multistep.StateBag) mu
 			Size:             aws.Int64(s.RootVolumeSize),
 			VolumeType:       aws.String(rootVolumeType),
 		}
+		if s.OutpostArn != "" {
+			createVolume.OutpostArn = aws.String(s.OutpostArn)
+		}
 
 	} else {
 		// Determine the root device snapshot
@@ -158,6 +162,9 @@ func (s *StepCreateVolume) buildCreateVolumeInput(az string, rootDevice *ec2.Blo
 		Encrypted:        rootDevice.Ebs.Encrypted,
 		KmsKeyId:         rootDevice.Ebs.KmsKeyId,
 	}
+	if s.OutpostArn != "" {
+		createVolumeInput.OutpostArn = aws.String(s.OutpostArn)
+	}
 	if s.RootVolumeSize > *rootDevice.Ebs.VolumeSize {
 		createVolumeInput.Size = aws.Int64(s.RootVolumeSize)
 	}
@@ -98,3 +98,15 @@ func TestCreateVolume_Custom_KMS_Key_Encrypted(t *testing.T) {
 	// Ensure that the new value is equal to the value passed in
 	assert.Equal(t, *ret.KmsKeyId, stepCreateVolume.RootVolumeKmsKeyId)
 }
+
+func TestCreateVolume_OutpostArn(t *testing.T) {
+	outpostArn := "arn:aws:outposts:us-east-1:123456789012:outpost/op-1234567890abcdef0"
+	stepCreateVolume := &StepCreateVolume{OutpostArn: outpostArn}
+	ret, err := stepCreateVolume.buildCreateVolumeInput("test-az", buildTestRootDevice())
+	assert.NoError(t, err)
+	assert.Equal(t, outpostArn, aws.StringValue(ret.OutpostArn))
+
+	ret, err = new(StepCreateVolume).buildCreateVolumeInput("test-az", buildTestRootDevice())
+	assert.NoError(t, err)
+	assert.Nil(t, ret.OutpostArn)
+}
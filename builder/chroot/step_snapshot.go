@@ -8,6 +8,7 @@ import (
 	"fmt"
 	"time"
 
+	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/ec2"
 	awscommon "github.com/hashicorp/packer-plugin-amazon/builder/common"
 	"github.com/hashicorp/packer-plugin-sdk/multistep"
@@ -21,6 +22,7 @@ import (
 //	snapshot_id string - ID of the created snapshot
 type StepSnapshot struct {
 	PollingConfig *awscommon.AWSPollingConfig
+	OutpostArn    string
 	snapshotId    string
 }
 
@@ -32,10 +34,15 @@ func (s *StepSnapshot) Run(ctx context.Context, state multistep.StateBag) multis
 	ui.Say("Creating snapshot...")
 	description := fmt.Sprintf("Packer: %s", time.Now().String())
 
-	createSnapResp, err := ec2conn.CreateSnapshot(&ec2.CreateSnapshotInput{
+	createSnapshot := &ec2.CreateSnapshotInput{
 		VolumeId:    &volumeId,
 		Description: &description,
-	})
+	}
+	if s.OutpostArn != "" {
+		createSnapshot.OutpostArn = aws.String(s.OutpostArn)
+	}
+
+	createSnapResp, err := ec2conn.CreateSnapshot(createSnapshot)
 	if err != nil {
 		err := fmt.Errorf("Error creating snapshot: %s", err)
 		state.Put("error", err)
@@ -47,8 +54,15 @@ func (s *StepSnapshot) Run(ctx context.Context, state multistep.StateBag) multis
 	s.snapshotId = *createSnapResp.SnapshotId
 	ui.Message(fmt.Sprintf("Snapshot ID: %s", s.snapshotId))
 
-	// Wait for the snapshot to be ready
-	err = s.PollingConfig.WaitUntilSnapshotDone(ctx, ec2conn, s.snapshotId)
+	// Wait for the snapshot to be ready, reporting its progress as large
+	// snapshots can take a long time.
+	lastProgress := ""
+	err = s.PollingConfig.WaitUntilSnapshotDoneWithProgress(ctx, ec2conn, s.snapshotId, func(progress string) {
+		if progress != lastProgress {
+			lastProgress = progress
+			ui.Message(fmt.Sprintf("Snapshot progress: %s", progress))
+		}
+	})
 	if err != nil {
 		err := fmt.Errorf("Error waiting for snapshot: %s", err)
 		state.Put("error", err)
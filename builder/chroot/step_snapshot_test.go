@@ -0,0 +1,73 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package chroot
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	awscommon "github.com/hashicorp/packer-plugin-amazon/builder/common"
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
+)
+
+func TestStepSnapshot_ReportsProgress(t *testing.T) {
+	// The snapshot progresses at every poll, and is reported twice at 50%.
+	progress := []string{"0%", "50%", "50%", "100%"}
+	polls := 0
+	conn := awscommon.FakeEC2Conn(func(r *request.Request) {
+		switch out := r.Data.(type) {
+		case *ec2.Snapshot:
+			// The output of CreateSnapshot.
+			out.SnapshotId = aws.String("snap-12345")
+		case *ec2.DescribeSnapshotsOutput:
+			snapshotState := ec2.SnapshotStatePending
+			if polls == len(progress)-1 {
+				snapshotState = ec2.SnapshotStateCompleted
+			}
+			out.Snapshots = []*ec2.Snapshot{
+				{
+					SnapshotId: aws.String("snap-12345"),
+					Progress:   aws.String(progress[polls]),
+					State:      aws.String(snapshotState),
+				},
+			}
+			polls++
+		}
+	})
+
+	output := new(bytes.Buffer)
+	state := new(multistep.BasicStateBag)
+	state.Put("ec2", conn)
+	state.Put("ui", &packersdk.BasicUi{
+		Reader: new(bytes.Buffer),
+		Writer: output,
+	})
+	state.Put("volume_id", "vol-12345")
+
+	step := &StepSnapshot{
+		PollingConfig: &awscommon.AWSPollingConfig{DelaySeconds: 1},
+	}
+	if action := step.Run(context.Background(), state); action != multistep.ActionContinue {
+		t.Fatalf("step should continue, got %v: %v", action, state.Get("error"))
+	}
+	if polls != len(progress) {
+		t.Fatalf("expected %d polls, got %d", len(progress), polls)
+	}
+
+	var reported []string
+	for _, line := range strings.Split(output.String(), "\n") {
+		if _, p, ok := strings.Cut(line, "Snapshot progress: "); ok {
+			reported = append(reported, p)
+		}
+	}
+	if strings.Join(reported, ",") != "0%,50%,100%" {
+		t.Fatalf("expected each new progress to be reported once, got %v", reported)
+	}
+}
@@ -10,18 +10,26 @@ import (
 	"context"
 	"crypto/tls"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
+	"os"
+	"reflect"
 	"strings"
 	"time"
 
 	awsv2 "github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/ec2/imds"
 	"github.com/aws/aws-sdk-go/aws"
 	awsCredentials "github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
 	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/aws/smithy-go/middleware"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
 	awsbase "github.com/hashicorp/aws-sdk-go-base"
 	awsbase_v2 "github.com/hashicorp/aws-sdk-go-base/v2"
 	basediag "github.com/hashicorp/aws-sdk-go-base/v2/diag"
@@ -33,6 +41,10 @@ import (
 	vaultapi "github.com/hashicorp/vault/api"
 )
 
+// ec2MetadataDisabledEnvVar is the environment variable the AWS SDKs read to
+// turn off access to the EC2 instance metadata endpoint.
+const ec2MetadataDisabledEnvVar = "AWS_EC2_METADATA_DISABLED"
+
 // AssumeRoleConfig lets users set configuration options for assuming a special
 // role when executing Packer.
 //
@@ -82,12 +94,54 @@ type AssumeRoleConfig struct {
 	AssumeRolePolicyARNs []string `mapstructure:"policy_arns" required:"false"`
 	// Session name to use when assuming the role.
 	AssumeRoleSessionName string `mapstructure:"session_name" required:"false"`
-	// Map of assume role session tags.
+	// Map of assume role session tags. Session tags are passed to the
+	// AssumeRole call, so that accounts governed by attribute-based access
+	// control (ABAC) can match them in the `aws:PrincipalTag` conditions of
+	// their policies.
 	AssumeRoleTags map[string]string `mapstructure:"tags" required:"false"`
 	// Set of assume role session tag keys to pass to any subsequent sessions.
+	// Each key must be set in `tags`.
 	AssumeRoleTransitiveTagKeys []string `mapstructure:"transitive_tag_keys" required:"false"`
 }
 
+// awsbaseAssumeRole returns the AssumeRole input of the shared SDK.
+func (c *AssumeRoleConfig) awsbaseAssumeRole() awsbase_v2.AssumeRole {
+	return awsbase_v2.AssumeRole{
+		RoleARN:           c.AssumeRoleARN,
+		Duration:          time.Duration(c.AssumeRoleDurationSeconds) * time.Second,
+		ExternalID:        c.AssumeRoleExternalID,
+		Policy:            c.AssumeRolePolicy,
+		PolicyARNs:        c.AssumeRolePolicyARNs,
+		SessionName:       c.AssumeRoleSessionName,
+		Tags:              c.AssumeRoleTags,
+		TransitiveTagKeys: c.AssumeRoleTransitiveTagKeys,
+	}
+}
+
+// credentials returns the credentials of the role, assumed with the
+// credentials of sess.
+func (c *AssumeRoleConfig) credentials(sess *session.Session) *awsCredentials.Credentials {
+	return stscreds.NewCredentials(sess, c.AssumeRoleARN, func(p *stscreds.AssumeRoleProvider) {
+		p.Duration = time.Duration(c.AssumeRoleDurationSeconds) * time.Second
+		if c.AssumeRoleExternalID != "" {
+			p.ExternalID = aws.String(c.AssumeRoleExternalID)
+		}
+		if c.AssumeRolePolicy != "" {
+			p.Policy = aws.String(c.AssumeRolePolicy)
+		}
+		for _, policyARN := range c.AssumeRolePolicyARNs {
+			p.PolicyArns = append(p.PolicyArns, &sts.PolicyDescriptorType{Arn: aws.String(policyARN)})
+		}
+		if c.AssumeRoleSessionName != "" {
+			p.RoleSessionName = c.AssumeRoleSessionName
+		}
+		for key, value := range c.AssumeRoleTags {
+			p.Tags = append(p.Tags, &sts.Tag{Key: aws.String(key), Value: aws.String(value)})
+		}
+		p.TransitiveTagKeys = aws.StringSlice(c.AssumeRoleTransitiveTagKeys)
+	})
+}
+
 type VaultAWSEngineOptions struct {
 	Name    string `mapstructure:"name"`
 	RoleARN string `mapstructure:"role_arn"`
@@ -138,6 +192,13 @@ type AccessConfig struct {
 	// where requests are being throttled or experiencing transient failures.
 	// The delay between the subsequent API calls increases exponentially.
 	MaxRetries int `mapstructure:"max_retries" required:"false"`
+	// The retry mode of the AWS SDK, either `standard` or `adaptive`. The
+	// `adaptive` mode adds client-side rate limiting on top of the `standard`
+	// exponential backoff, which helps in heavily throttled accounts. The
+	// number of attempts is set by `max_retries`. Defaults to `standard`.
+	// Note: the EC2 client of the builders uses the v1 AWS SDK, which only
+	// supports the `standard` mode; `adaptive` applies to the v2 SDK clients.
+	RetryMode string `mapstructure:"aws_retry_mode" required:"false"`
 	// The MFA
 	// [TOTP](https://en.wikipedia.org/wiki/Time-based_One-time_Password_Algorithm)
 	// code. This should probably be a user variable since it changes all the
@@ -155,10 +216,25 @@ type AccessConfig struct {
 	// The secret key used to communicate with AWS. [Learn how to set
 	// this](/packer/plugins/builders/amazon#specifying-amazon-credentials). This is not required
 	// if you are using `use_vault_aws_engine` for authentication instead.
-	SecretKey            string `mapstructure:"secret_key" required:"true"`
-	SkipMetadataApiCheck bool   `mapstructure:"skip_metadata_api_check"`
+	SecretKey string `mapstructure:"secret_key" required:"true"`
+	// Skip querying the EC2 instance metadata endpoint when resolving
+	// credentials. This avoids a long delay in environments where that
+	// endpoint is blocked or firewalled. This is also enabled when the
+	// `AWS_EC2_METADATA_DISABLED` environment variable is set to `true`.
+	// Default `false`.
+	SkipMetadataApiCheck bool `mapstructure:"skip_metadata_api_check" required:"false"`
 	// Set to true if you want to skip validating AWS credentials before runtime.
 	SkipCredsValidation bool `mapstructure:"skip_credential_validation"`
+	// A string appended to the User-Agent of the requests made to AWS, such
+	// as `team-images/1.0`. It identifies the calls made by Packer in
+	// CloudTrail and cost tooling.
+	UserAgentSuffix string `mapstructure:"aws_user_agent_suffix" required:"false"`
+	// The maximum duration of each AWS API call, such as `30s` or `5m`,
+	// retries included. A call that hangs past it is cancelled and fails the
+	// step, instead of stalling the build. This applies to every request,
+	// including the parts of S3 uploads, so it must leave room for the
+	// slowest of them. Unset by default, calls are not bounded.
+	PerRequestTimeout time.Duration `mapstructure:"per_request_timeout" required:"false"`
 	// The access token to use. This is different from the
 	// access key and secret key. If you're not sure what this is, then you
 	// probably don't need it. This will also be read from the AWS_SESSION_TOKEN
@@ -227,32 +303,35 @@ func (c *AccessConfig) GetAWSConfig(ctx context.Context) (*awsv2.Config, error)
 	// Reload values into the config used by the Packer-Terraform shared SDK
 	assumeRoles := []awsbase_v2.AssumeRole{}
 	if c.AssumeRole.AssumeRoleARN != "" {
-		awsbaseAssumeRole := awsbase_v2.AssumeRole{
-			RoleARN:           c.AssumeRole.AssumeRoleARN,
-			Duration:          time.Duration(c.AssumeRole.AssumeRoleDurationSeconds) * time.Second,
-			ExternalID:        c.AssumeRole.AssumeRoleExternalID,
-			Policy:            c.AssumeRole.AssumeRolePolicy,
-			PolicyARNs:        c.AssumeRole.AssumeRolePolicyARNs,
-			SessionName:       c.AssumeRole.AssumeRoleSessionName,
-			Tags:              c.AssumeRole.AssumeRoleTags,
-			TransitiveTagKeys: c.AssumeRole.AssumeRoleTransitiveTagKeys,
-		}
-		assumeRoles = append(assumeRoles, awsbaseAssumeRole)
+		assumeRoles = append(assumeRoles, c.AssumeRole.awsbaseAssumeRole())
+	}
+
+	imdsEnabledState := imds.ClientEnabled
+	if c.SkipMetadataApiCheck {
+		imdsEnabledState = imds.ClientDisabled
 	}
 
 	awsbaseConfig := awsbase_v2.Config{
-		AccessKey:           c.AccessKey,
-		AssumeRole:          assumeRoles,
-		Insecure:            c.InsecureSkipTLSVerify,
-		MaxRetries:          c.MaxRetries,
-		Profile:             c.ProfileName,
-		Region:              c.RawRegion,
-		SecretKey:           c.SecretKey,
-		SkipCredsValidation: c.SkipCredsValidation,
-		Token:               c.Token,
+		AccessKey:                     c.AccessKey,
+		AssumeRole:                    assumeRoles,
+		EC2MetadataServiceEnableState: imdsEnabledState,
+		Insecure:                      c.InsecureSkipTLSVerify,
+		MaxRetries:                    c.MaxRetries,
+		Profile:                       c.ProfileName,
+		Region:                        c.RawRegion,
+		RetryMode:                     awsv2.RetryMode(c.RetryMode),
+		SecretKey:                     c.SecretKey,
+		SkipCredsValidation:           c.SkipCredsValidation,
+		Token:                         c.Token,
 	}
 
 	_, awsConfig, awsDiags := awsbase_v2.GetAwsConfig(ctx, &awsbaseConfig)
+	if c.UserAgentSuffix != "" {
+		awsConfig.APIOptions = append(awsConfig.APIOptions, withUserAgentSuffix(c.UserAgentSuffix))
+	}
+	if c.PerRequestTimeout > 0 {
+		awsConfig.APIOptions = append(awsConfig.APIOptions, withRequestTimeout(c.PerRequestTimeout))
+	}
 
 	for _, d := range awsDiags {
 		switch d.Severity() {
@@ -363,10 +442,90 @@ func (c *AccessConfig) Session() (*session.Session, error) {
 		product := userAgentProducts[i]
 		c.session.Handlers.Build.PushFront(request.MakeAddToUserAgentHandler(product.Name, product.Version, product.Extra...))
 	}
+	if c.UserAgentSuffix != "" {
+		c.session.Handlers.Build.PushBack(request.MakeAddToUserAgentFreeFormHandler(c.UserAgentSuffix))
+	}
+	if c.PerRequestTimeout > 0 {
+		c.session.Handlers.Validate.PushFront(requestTimeoutHandler(c.PerRequestTimeout))
+	}
 
 	return c.session, nil
 }
 
+// withUserAgentSuffix appends suffix to the User-Agent header of the
+// requests, once the SDK has set it.
+func withUserAgentSuffix(suffix string) func(*middleware.Stack) error {
+	return func(stack *middleware.Stack) error {
+		return stack.Build.Add(middleware.BuildMiddlewareFunc("PackerUserAgentSuffix",
+			func(ctx context.Context, in middleware.BuildInput, next middleware.BuildHandler) (middleware.BuildOutput, middleware.Metadata, error) {
+				if req, ok := in.Request.(*smithyhttp.Request); ok {
+					userAgent := strings.TrimSpace(req.Header.Get("User-Agent") + " " + suffix)
+					req.Header.Set("User-Agent", userAgent)
+				}
+				return next.HandleBuild(ctx, in)
+			}), middleware.After)
+	}
+}
+
+// requestTimeoutHandler bounds each request sent with the session to
+// timeout.
+func requestTimeoutHandler(timeout time.Duration) func(*request.Request) {
+	return func(r *request.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		r.SetContext(ctx)
+		r.Handlers.Complete.PushBack(func(r *request.Request) {
+			if r.Error != nil || !cancelOnBodyClose(r.Data, cancel) {
+				cancel()
+			}
+		})
+	}
+}
+
+// withRequestTimeout bounds each call made with the config to timeout.
+func withRequestTimeout(timeout time.Duration) func(*middleware.Stack) error {
+	return func(stack *middleware.Stack) error {
+		return stack.Initialize.Add(middleware.InitializeMiddlewareFunc("PackerRequestTimeout",
+			func(ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler) (middleware.InitializeOutput, middleware.Metadata, error) {
+				ctx, cancel := context.WithTimeout(ctx, timeout)
+				out, metadata, err := next.HandleInitialize(ctx, in)
+				if err != nil || !cancelOnBodyClose(out.Result, cancel) {
+					cancel()
+				}
+				return out, metadata, err
+			}), middleware.Before)
+	}
+}
+
+// cancelOnBodyClose defers cancel to the closing of the response body held
+// by output, if any, as the caller reads it once the call returned. It
+// reports whether it did.
+func cancelOnBodyClose(output interface{}, cancel context.CancelFunc) bool {
+	v := reflect.Indirect(reflect.ValueOf(output))
+	if v.Kind() != reflect.Struct {
+		return false
+	}
+	body := v.FieldByName("Body")
+	if !body.IsValid() || !body.CanSet() || body.Type() != reflect.TypeOf((*io.ReadCloser)(nil)).Elem() || body.IsNil() {
+		return false
+	}
+	body.Set(reflect.ValueOf(io.ReadCloser(&cancelingBody{
+		ReadCloser: body.Interface().(io.ReadCloser),
+		cancel:     cancel,
+	})))
+	return true
+}
+
+// cancelingBody cancels the context of its request once closed.
+type cancelingBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelingBody) Close() error {
+	defer b.cancel()
+	return b.ReadCloser.Close()
+}
+
 func (c *AccessConfig) SessionRegion() string {
 	if c.session == nil {
 		panic("access config session should be set.")
@@ -419,6 +578,14 @@ func (c *AccessConfig) GetCredentials(config *aws.Config) (*awsCredentials.Crede
 		Token: c.Token,
 	}
 
+	if c.SkipMetadataApiCheck {
+		// The SDK has no setting to turn the EC2 metadata client off, it only
+		// reads the environment when the client is created, including by the
+		// session awsbase falls back to for SSO, credential_process and
+		// source_profile credentials. awsbase does the same in GetSession.
+		os.Setenv(ec2MetadataDisabledEnvVar, "true")
+	}
+
 	return awsbase.GetCredentials(awsbaseConfig)
 }
 
@@ -476,8 +643,8 @@ func (c *AccessConfig) GetCredsFromVault() error {
 func (c *AccessConfig) Prepare(packerConfig *common.PackerConfig) []error {
 	var errs []error
 
-	if c.SkipMetadataApiCheck {
-		log.Println("(WARN) skip_metadata_api_check ignored.")
+	if strings.EqualFold(os.Getenv(ec2MetadataDisabledEnvVar), "true") {
+		c.SkipMetadataApiCheck = true
 	}
 
 	// Make sure it's obvious from the config how we're getting credentials:
@@ -505,6 +672,25 @@ func (c *AccessConfig) Prepare(packerConfig *common.PackerConfig) []error {
 		c.PollingConfig = new(AWSPollingConfig)
 	}
 	c.PollingConfig.LogEnvOverrideWarnings()
+	if c.PollingConfig.Timeout < 0 {
+		errs = append(errs, fmt.Errorf("aws_polling timeout must be positive, got %s", c.PollingConfig.Timeout))
+	}
+
+	for _, key := range c.AssumeRole.AssumeRoleTransitiveTagKeys {
+		if _, ok := c.AssumeRole.AssumeRoleTags[key]; !ok {
+			errs = append(errs, fmt.Errorf("assume_role transitive_tag_keys: %q is not set in tags", key))
+		}
+	}
+
+	switch awsv2.RetryMode(c.RetryMode) {
+	case "", awsv2.RetryModeStandard, awsv2.RetryModeAdaptive:
+	default:
+		errs = append(errs, fmt.Errorf("aws_retry_mode must be one of \"standard\" or \"adaptive\", got %q", c.RetryMode))
+	}
+
+	if c.PerRequestTimeout < 0 {
+		errs = append(errs, fmt.Errorf("per_request_timeout must be positive, got %s", c.PerRequestTimeout))
+	}
 
 	// Default MaxRetries to 10, to make throttling issues less likely. The
 	// Aws sdk defaults this to 3, which regularly gets tripped by users.
@@ -12,11 +12,15 @@ import (
 	"regexp"
 	"time"
 
+	"github.com/aws/aws-sdk-go/aws/arn"
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/hashicorp/packer-plugin-sdk/template/config"
 	"github.com/hashicorp/packer-plugin-sdk/template/interpolate"
 )
 
+// The key of the tag set on the AMI by imds_instance_metadata_tags.
+const instanceMetadataTagsTagKey = "InstanceMetadataTags"
+
 // DeregistrationProtectionOptions lets users set AMI deregistration protection
 //
 // HCL2 example:
@@ -68,6 +72,15 @@ type AMIConfig struct {
 	// AWS console or via APIs. This must be unique. To help make this unique,
 	// use a function like timestamp (see [template
 	// engine](/packer/docs/templates/legacy_json_templates/engine) for more info).
+	//
+	// The name and the tags of the AMI can also embed the metadata of the CI
+	// job running Packer with the `{{ ci_commit }}`, `{{ ci_branch }}` and
+	// `{{ ci_build_id }}` functions. They read the variables set by Jenkins,
+	// GitHub Actions, GitLab CI, CircleCI, Azure Pipelines and AWS CodeBuild,
+	// for example `GIT_COMMIT` or `GITHUB_SHA`, and are empty outside of CI.
+	// Combine them with `clean_resource_name`, as branch names may contain
+	// characters that aren't allowed in AMI names:
+	// `app-{{ ci_branch | clean_resource_name }}-{{ ci_commit }}`.
 	AMIName string `mapstructure:"ami_name" required:"true"`
 	// The description to set for the resulting
 	// AMI(s). By default this description is empty.  This is a
@@ -76,12 +89,26 @@ type AMIConfig struct {
 	AMIDescription string `mapstructure:"ami_description" required:"false"`
 	// The type of virtualization for the AMI
 	// you are building. This option is required to register HVM images. Can be
-	// paravirtual (default) or hvm.
+	// paravirtual (default) or hvm. The amazon-instance builder requires it
+	// to match the virtualization type of the source AMI, as the AMI is
+	// registered from the disk of the source instance.
 	AMIVirtType string `mapstructure:"ami_virtualization_type" required:"false"`
 	// A list of account IDs that have access to
 	// launch the resulting AMI(s). By default no additional users other than the
-	// user creating the AMI has permissions to launch it.
+	// user creating the AMI has permissions to launch it. To share with many
+	// accounts, prefer `ami_org_arns` or `ami_ou_arns`: AMIs can't be shared
+	// through AWS Resource Access Manager (RAM), only through launch permissions.
 	AMIUsers []string `mapstructure:"ami_users" required:"false"`
+	// Path to a file listing more account IDs to add to `ami_users`, separated
+	// by commas or newlines. Everything after a `#` on a line is a comment.
+	// The file is read when the template is validated.
+	AMIUsersFile string `mapstructure:"ami_users_file" required:"false"`
+	// The name of an SSM parameter listing more account IDs to add to
+	// `ami_users`, as a `StringList` or one account per line. The parameter is
+	// read at build time, right before sharing the AMI(s), so that large
+	// sharing lists can be maintained outside of the template. This needs the
+	// `ssm:GetParameter` permission.
+	AMIUsersSSMParameter string `mapstructure:"ami_users_ssm_parameter" required:"false"`
 	// A list of groups that have access to
 	// launch the resulting AMI(s). By default no groups have permission to launch
 	// the AMI. `all` will make the AMI publicly accessible.
@@ -95,13 +122,31 @@ type AMIConfig struct {
 	// launch the resulting AMI(s). By default no organizational units have permission to launch
 	// the AMI.
 	AMIOuArns []string `mapstructure:"ami_ou_arns" required:"false"`
+	// How long the AMI is meant to be shared for, such as `72h`. Packer
+	// doesn't revoke the sharing: the AMIs are tagged with `unshare-after`,
+	// set to when the duration ends in the RFC 3339 format, for external
+	// automation to revoke it. The time is also recorded in the
+	// `UnshareAfter` generated variable. Requires `ami_users`,
+	// `ami_users_ssm_parameter`, `ami_groups`, `ami_org_arns` or
+	// `ami_ou_arns` to be set.
+	AMIShareDuration time.Duration `mapstructure:"ami_share_duration" required:"false"`
 	// A list of product codes to
 	// associate with the AMI. By default no product codes are associated with the
 	// AMI.
 	AMIProductCodes []string `mapstructure:"ami_product_codes" required:"false"`
+	// A list of billing product codes, such as `bp-6ba54002`, to register the
+	// AMI with, for AWS partners whose account is allowed to set them, for
+	// instance to sell it on the AWS Marketplace. Unlike
+	// `ami_product_codes`, billing products can only be set when the AMI is
+	// registered, and never changed afterwards. The `amazon-ebs` builder,
+	// whose AMI is created from the instance, registers it again from its
+	// snapshots with them.
+	AMIBillingProducts []string `mapstructure:"ami_billing_products" required:"false"`
 	// A list of regions to copy the AMI to.
 	// Tags and attributes are copied along with the AMI. AMI copying takes time
 	// depending on the size of the AMI, but will generally take many minutes.
+	// Unless `force_deregister` is set, Packer makes sure that `ami_name` isn't
+	// already used in any of these regions before starting the build.
 	AMIRegions []string `mapstructure:"ami_regions" required:"false"`
 	// Set to true if you want to skip
 	// validation of the ami_regions configuration option. Default false.
@@ -110,6 +155,9 @@ type AMIConfig struct {
 	// engine](/packer/docs/templates/legacy_json_templates/engine), see [Build template
 	// data](#build-template-data) for more information.
 	//
+	// The tags are also applied to the snapshots of the AMI, including the
+	// AMIs copied to the regions listed in `ami_regions` and their snapshots.
+	//
 	// The builder no longer adds a "Name": "Packer Builder" entry to the tags.
 	AMITags map[string]string `mapstructure:"tags" required:"false"`
 	// Same as [`tags`](#tags) but defined as a singular repeatable block
@@ -117,6 +165,20 @@ type AMIConfig struct {
 	// [`dynamic_block`](/packer/docs/templates/hcl_templates/expressions#dynamic-blocks)
 	// will allow you to create those programatically.
 	AMITag config.KeyValues `mapstructure:"tag" required:"false"`
+	// Tag the AMI of the build region with the IDs of its copies in the
+	// regions listed in `ami_regions`, once they are done. The tag key is
+	// `CopiedTo` and its value is formatted as
+	// `us-west-2=ami-0123,eu-west-1=ami-4567`. As tag values are limited to
+	// 256 characters, longer lists are split across `CopiedTo`, `CopiedTo2`,
+	// ... tags. Can't be used with `skip_save_build_region`. Default `false`.
+	AMITagCopyRegions bool `mapstructure:"tag_copy_regions" required:"false"`
+	// The maximum total size, in GiB, of the volumes of the AMI. The build
+	// fails to validate if the `volume_size` of the block device mappings
+	// that make it into the AMI add up to more, which guards against
+	// accidentally creating huge, expensive snapshots. Volumes without a
+	// `volume_size` keep the size of their snapshot and aren't counted.
+	// Defaults to `0`, no limit.
+	AMIMaxSizeGB int64 `mapstructure:"max_ami_size_gb" required:"false"`
 	// Enable enhanced networking (ENA but not SriovNetSupport) on
 	// HVM-compatible AMIs. If set, add `ec2:ModifyInstanceAttribute` to your
 	// AWS IAM policy.
@@ -191,11 +253,22 @@ type AMIConfig struct {
 	// `region_kms_key_ids` for your build region and silently disregard the
 	// value provided in `kms_key_id`.
 	AMIRegionKMSKeyIDs map[string]string `mapstructure:"region_kms_key_ids" required:"false"`
+	// If true, Packer checks that the `kms_key_id` and `region_kms_key_ids`
+	// keys exist, are enabled and can be used by the build credentials before
+	// launching anything, instead of failing when the AMI is encrypted at the
+	// end of the build. This calls `kms:DescribeKey` and a dry run of
+	// `kms:GenerateDataKeyWithoutPlaintext` on each key, so both actions must
+	// be allowed. If the build credentials may call `kms:GetKeyPolicy`, the
+	// key policy is also checked for statements denying EC2 the use of the
+	// key, which would make EBS fail to encrypt the AMI. Default `false`.
+	AMIValidateKmsKeys bool `mapstructure:"validate_kms_keys" required:"false"`
 	// If true, Packer will not check whether an AMI with the `ami_name` exists
 	// in the region it is building in. It will use an intermediary AMI name,
 	// which it will not convert to an AMI in the build region. It will copy
 	// the intermediary AMI into any regions provided in `ami_regions`, then
-	// delete the intermediary AMI. Default `false`.
+	// delete the intermediary AMI. The intermediary AMI is kept if any of the
+	// copies fails, as it may be the only source left for that region.
+	// Default `false`.
 	AMISkipBuildRegion bool `mapstructure:"skip_save_build_region"`
 	// Specify a completion duration, in 15 minute increments, to initiate a
 	// time-based AMI copy. The specified completion duration applies to each of the
@@ -209,15 +282,74 @@ type AMIConfig struct {
 	//
 	// [Time-based copies]: https://docs.aws.amazon.com/ebs/latest/userguide/time-based-copies.html
 	AMISnapshotCopyDurationMinutes int64 `mapstructure:"snapshot_copy_duration_minutes" required:"false"`
+	// Keep going when copying the AMI to some of the regions listed in
+	// `ami_regions` fails. All the copies are waited for, the failures are
+	// reported at the end, and the artifact only contains the regions the AMI
+	// was successfully copied to, with a warning listing the others, which
+	// are also in the `FailedCopyRegions` generated variable. Copies that
+	// failed after being started are deregistered and their snapshots
+	// deleted. Default `false`, where any failed copy fails the build.
+	AMIIgnoreCopyErrors bool `mapstructure:"ignore_copy_errors" required:"false"`
+	// The volume type of the EBS volumes of the AMI copied to each region,
+	// for instance `{"eu-west-1": "gp3"}`. Regions must be in `ami_regions`
+	// and the volume type one of `gp2`, `gp3` or `standard`. CopyImage can't
+	// change volume types, so once the copy is available an AMI is registered
+	// with its snapshots and the new volume type, first under a temporary name,
+	// then under the name of the copy once the copy is deregistered. The IOPS
+	// and throughput of the volumes are reset to the defaults of the volume
+	// type. By default the copies keep the volume types of the source AMI.
+	AMIRegionVolumeTypes map[string]string `mapstructure:"region_volume_types" required:"false"`
 	// Enforce version of the Instance Metadata Service on the built AMI.
 	// Valid options are unset (legacy) and `v2.0`. See the documentation on
 	// [IMDS](https://docs.aws.amazon.com/AWSEC2/latest/UserGuide/ec2-instance-metadata.html)
 	// for more information. Defaults to legacy.
 	AMIIMDSSupport string `mapstructure:"imds_support" required:"false"`
+	// Record on the AMI that the instances launched from it should enable
+	// [instance metadata tags](https://docs.aws.amazon.com/AWSEC2/latest/UserGuide/work-with-tags-in-IMDS.html),
+	// with the tag `InstanceMetadataTags = enabled`. Instance metadata tags
+	// are a launch setting that an AMI can't enforce, the tag lets launch
+	// templates and tooling pick the recommendation up. Requires
+	// `imds_support` to be `v2.0`.
+	AMIInstanceMetadataTags bool `mapstructure:"imds_instance_metadata_tags" required:"false"`
 	// The date and time to deprecate the AMI, in UTC, in the following format: YYYY-MM-DDTHH:MM:SSZ.
 	// If you specify a value for seconds, Amazon EC2 rounds the seconds to the nearest minute.
 	// You can’t specify a date in the past. The upper limit for DeprecateAt is 10 years from now.
+	//
+	// This can also be a duration, such as `2160h` for 90 days, in which case
+	// the AMI is deprecated that long after the build completes.
 	DeprecationTime string `mapstructure:"deprecate_at"`
+	// Deprecate the AMIs previously built by the account that carry all of
+	// these tags, in every region the AMI is created in, once the new AMI is
+	// available. This rotates images: set it to the tags identifying the
+	// image family, such as `{ Family = "web" }`, and only the latest AMI of
+	// the family stays current. The AMIs already deprecated are left alone.
+	DeprecatePreviousTags map[string]string `mapstructure:"deprecate_previous_tags" required:"false"`
+	// Wait for the snapshots of the AMI to complete before sharing it and
+	// its snapshots with `ami_users`, `snapshot_users` and the other sharing
+	// options. An AMI can be available while its snapshots are still being
+	// created, and other accounts would then see it incomplete. Defaults to
+	// `false`.
+	AMIWaitForSnapshots bool `mapstructure:"wait_for_snapshots_complete" required:"false"`
+	// A role of a central account to copy the AMI of the build region to, for
+	// hub-and-spoke image distribution. Once the AMI is created, Packer shares
+	// it and its snapshots with the account of the role, assumes the role and
+	// copies the AMI with CopyImage in that account, so that it owns a copy.
+	// The AMI is unshared once the copy is available. The options are those
+	// of [`assume_role`](#assume-role-configuration); the role must be allowed
+	// to call `ec2:CopyImage` and `ec2:DescribeImages`, and
+	// `ec2:DeregisterImage` and `ec2:DeleteSnapshot` to delete the copy when
+	// the build fails. The copy is part of the artifact, and its ID is the
+	// `CentralAMI` generated variable. The copy of an encrypted AMI is
+	// encrypted with `central_account_kms_key_id`, and the role needs access
+	// to the KMS key of the AMI, which therefore can't be an AWS managed key
+	// such as the default key `aws/ebs`. The build fails before sharing an
+	// AMI whose snapshots are encrypted with one. Can't be used with
+	// `skip_save_build_region`.
+	AMICentralAccountRole AssumeRoleConfig `mapstructure:"central_account_role" required:"false"`
+	// The ID, alias or ARN of a KMS key of the central account to encrypt the
+	// copy of an encrypted AMI with. If empty, the default EBS key of the
+	// central account is used. Requires `central_account_role`.
+	AMICentralAccountKmsKeyId string `mapstructure:"central_account_kms_key_id" required:"false"`
 
 	SnapshotConfig `mapstructure:",squash"`
 
@@ -227,6 +359,19 @@ type AMIConfig struct {
 	DeregistrationProtection DeregistrationProtectionOptions `mapstructure:"deregistration_protection" required:"false"`
 }
 
+// deprecationTime returns the time set by deprecate_at, which is either a
+// time or a duration relative to now.
+func deprecationTime(deprecateAt string, now time.Time) (time.Time, error) {
+	if d, err := time.ParseDuration(deprecateAt); err == nil {
+		return now.Add(d), nil
+	}
+	return time.Parse(time.RFC3339, deprecateAt)
+}
+
+// billingProductPattern matches the billing product codes of AWS, such as
+// bp-6ba54002.
+var billingProductPattern = regexp.MustCompile(`^bp-[0-9a-f]{8,}$`)
+
 func stringInSlice(s []string, searchstr string) bool {
 	for _, item := range s {
 		if item == searchstr {
@@ -256,10 +401,54 @@ func (c *AMIConfig) Prepare(accessConfig *AccessConfig, ctx *interpolate.Context
 		}
 	}
 
+	for region, volumeType := range c.AMIRegionVolumeTypes {
+		if !stringInSlice(c.AMIRegions, region) {
+			errs = append(errs, fmt.Errorf("Region %s is in region_volume_types but not in ami_regions", region))
+		}
+		switch volumeType {
+		case "gp2", "gp3", "standard":
+		default:
+			errs = append(errs, fmt.Errorf("invalid volume type %q for region %s in region_volume_types, "+
+				"only 'gp2', 'gp3' and 'standard' are allowed", volumeType, region))
+		}
+	}
+
 	errs = append(errs, c.prepareRegions(accessConfig)...)
 
+	for _, code := range c.AMIBillingProducts {
+		if !billingProductPattern.MatchString(code) {
+			errs = append(errs, fmt.Errorf("invalid billing product code %q in ami_billing_products, "+
+				"expected a code like 'bp-6ba54002'", code))
+		}
+	}
+
+	if c.AMIMaxSizeGB < 0 {
+		errs = append(errs, fmt.Errorf("max_ami_size_gb must be a positive number of GiB"))
+	}
+
+	if c.AMITagCopyRegions && c.AMISkipBuildRegion {
+		errs = append(errs, fmt.Errorf("tag_copy_regions can't be used with skip_save_build_region, "+
+			"as no AMI is kept in the build region to carry the tag"))
+	}
+
+	if c.AMIUsersFile != "" {
+		users, err := loadAccountIdsFile(c.AMIUsersFile)
+		if err != nil {
+			errs = append(errs, err)
+		}
+		c.AMIUsers = append(c.AMIUsers, users...)
+	}
+
+	if c.AMIShareDuration < 0 {
+		errs = append(errs, fmt.Errorf("ami_share_duration can't be negative"))
+	} else if c.AMIShareDuration > 0 && len(c.AMIUsers) == 0 && c.AMIUsersSSMParameter == "" &&
+		len(c.AMIGroups) == 0 && len(c.AMIOrgArns) == 0 && len(c.AMIOuArns) == 0 {
+		errs = append(errs, fmt.Errorf("ami_share_duration requires ami_users, ami_users_ssm_parameter, "+
+			"ami_groups, ami_org_arns or ami_ou_arns to be set"))
+	}
+
 	// Prevent sharing of default KMS key encrypted volumes with other aws users
-	if len(c.AMIUsers) > 0 || len(c.AMIOrgArns) > 0 || len(c.AMIOuArns) > 0 {
+	if len(c.AMIUsers) > 0 || c.AMIUsersSSMParameter != "" || len(c.AMIOrgArns) > 0 || len(c.AMIOuArns) > 0 {
 		if len(c.AMIKmsKeyId) == 0 && len(c.AMIRegionKMSKeyIDs) == 0 && c.AMIEncryptBootVolume.True() {
 			errs = append(errs, fmt.Errorf("Cannot share AMI encrypted with default KMS key"))
 		}
@@ -295,6 +484,25 @@ func (c *AMIConfig) Prepare(accessConfig *AccessConfig, ctx *interpolate.Context
 		}
 	}
 
+	if c.AMICentralAccountRole.AssumeRoleARN != "" {
+		if _, err := arn.Parse(c.AMICentralAccountRole.AssumeRoleARN); err != nil {
+			errs = append(errs, fmt.Errorf("central_account_role: invalid role_arn %q: %s",
+				c.AMICentralAccountRole.AssumeRoleARN, err))
+		}
+		if c.AMISkipBuildRegion {
+			errs = append(errs, fmt.Errorf("central_account_role can't be used with skip_save_build_region, "+
+				"as no AMI is kept in the build region to copy"))
+		}
+		if len(c.AMIKmsKeyId) == 0 && len(c.AMIRegionKMSKeyIDs) == 0 && c.AMIEncryptBootVolume.True() {
+			errs = append(errs, fmt.Errorf("Cannot copy AMI encrypted with default KMS key to the central account"))
+		}
+		if c.AMICentralAccountKmsKeyId != "" && !ValidateKmsKey(c.AMICentralAccountKmsKeyId) {
+			errs = append(errs, fmt.Errorf("%q is not a valid KMS Key Id.", c.AMICentralAccountKmsKeyId))
+		}
+	} else if c.AMICentralAccountKmsKeyId != "" {
+		errs = append(errs, fmt.Errorf("central_account_kms_key_id requires central_account_role to be set"))
+	}
+
 	if len(c.SnapshotUsers) > 0 {
 		if len(c.AMIKmsKeyId) == 0 && len(c.AMIRegionKMSKeyIDs) == 0 && c.AMIEncryptBootVolume.True() {
 			errs = append(errs, fmt.Errorf("Cannot share snapshot encrypted "+
@@ -328,11 +536,33 @@ func (c *AMIConfig) Prepare(accessConfig *AccessConfig, ctx *interpolate.Context
 		)
 	}
 
+	if c.AMIInstanceMetadataTags {
+		if c.AMIIMDSSupport != ec2.ImdsSupportValuesV20 {
+			errs = append(errs, fmt.Errorf("imds_instance_metadata_tags requires imds_support to be %q", ec2.ImdsSupportValuesV20))
+		}
+		// The HCL tag blocks are already merged into AMITags.
+		if v, ok := c.AMITags[instanceMetadataTagsTagKey]; ok && v != "enabled" {
+			errs = append(errs, fmt.Errorf("the %s tag is set to %q, which contradicts imds_instance_metadata_tags", instanceMetadataTagsTagKey, v))
+		} else {
+			if c.AMITags == nil {
+				c.AMITags = make(map[string]string)
+			}
+			c.AMITags[instanceMetadataTagsTagKey] = "enabled"
+		}
+	}
+
 	if c.DeprecationTime != "" {
-		if _, err := time.Parse(time.RFC3339, c.DeprecationTime); err != nil {
+		now := time.Now()
+		deprecateAt, err := deprecationTime(c.DeprecationTime, now)
+		if err != nil {
 			errs = append(errs, fmt.Errorf(
-				"deprecate_at is not a valid time: %q. Expect time format: YYYY-MM-DDTHH:MM:SSZ",
+				"deprecate_at is not a valid time: %q. Expect time format: YYYY-MM-DDTHH:MM:SSZ, or a duration such as 2160h",
 				c.DeprecationTime))
+		} else if !deprecateAt.After(now) {
+			// AWS rejects a deprecation time in the past when the AMI is
+			// registered, so fail early instead of at the end of the build.
+			errs = append(errs, fmt.Errorf(
+				"deprecate_at must be a time in the future, got: %q", c.DeprecationTime))
 		}
 	}
 
@@ -382,6 +612,38 @@ func (c *AMIConfig) prepareRegions(accessConfig *AccessConfig) (errs []error) {
 	return errs
 }
 
+// CheckMaxSize returns an error if the volumes of the AMI add up to more than
+// max_ami_size_gb. A device found in several mappings counts once, with the
+// size from the last mapping that sets one, the way ami_block_device_mappings
+// override launch_block_device_mappings.
+func (c *AMIConfig) CheckMaxSize(mappings ...[]BlockDevice) error {
+	if c.AMIMaxSizeGB == 0 {
+		return nil
+	}
+
+	sizes := make(map[string]int64)
+	for _, mapping := range mappings {
+		for _, device := range mapping {
+			switch {
+			case device.NoDevice:
+				delete(sizes, device.DeviceName)
+			case device.VolumeSize > 0:
+				sizes[device.DeviceName] = device.VolumeSize
+			}
+		}
+	}
+
+	var total int64
+	for _, size := range sizes {
+		total += size
+	}
+	if total > c.AMIMaxSizeGB {
+		return fmt.Errorf("The volumes of the AMI add up to %d GiB, more than max_ami_size_gb (%d GiB)",
+			total, c.AMIMaxSizeGB)
+	}
+	return nil
+}
+
 // See https://docs.aws.amazon.com/AWSEC2/latest/APIReference/API_CopyImage.html
 func ValidateKmsKey(kmsKey string) (valid bool) {
 	//Pattern for matching KMS Key ID for multi-region keys
@@ -4,8 +4,11 @@
 package common
 
 import (
+	"os"
+	"path/filepath"
 	"reflect"
 	"testing"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/ec2"
@@ -314,3 +317,300 @@ func TestEnableDeregistrationProtection(t *testing.T) {
 		t.Fatal("expected setting cooldown must also enabled")
 	}
 }
+
+func TestAMIConfigPrepare_DeprecationTime(t *testing.T) {
+	tests := []struct {
+		name          string
+		deprecateAt   string
+		expectedError bool
+	}{
+		{
+			name:          "invalid format",
+			deprecateAt:   "2006-01-02 15:04:05",
+			expectedError: true,
+		},
+		{
+			name:          "past time",
+			deprecateAt:   time.Now().Add(-24 * time.Hour).UTC().Format(time.RFC3339),
+			expectedError: true,
+		},
+		{
+			name:          "now",
+			deprecateAt:   time.Now().UTC().Format(time.RFC3339),
+			expectedError: true,
+		},
+		{
+			name:          "future time",
+			deprecateAt:   time.Now().Add(24 * time.Hour).UTC().Format(time.RFC3339),
+			expectedError: false,
+		},
+		{
+			name:          "duration",
+			deprecateAt:   "2160h",
+			expectedError: false,
+		},
+		{
+			name:          "negative duration",
+			deprecateAt:   "-1h",
+			expectedError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := testAMIConfig()
+			c.DeprecationTime = tt.deprecateAt
+
+			errs := c.Prepare(FakeAccessConfig(), nil)
+			if tt.expectedError && len(errs) == 0 {
+				t.Fatalf("expected an error for deprecate_at %q", tt.deprecateAt)
+			}
+			if !tt.expectedError && len(errs) != 0 {
+				t.Fatalf("expected no error for deprecate_at %q, got: %v", tt.deprecateAt, errs)
+			}
+		})
+	}
+}
+
+func TestAMIConfigPrepare_TagCopyRegions(t *testing.T) {
+	c := testAMIConfig()
+	c.AMITagCopyRegions = true
+	if errs := c.Prepare(FakeAccessConfig(), nil); len(errs) != 0 {
+		t.Fatalf("shouldn't have err: %v", errs)
+	}
+
+	c.AMISkipBuildRegion = true
+	if errs := c.Prepare(FakeAccessConfig(), nil); len(errs) == 0 {
+		t.Fatal("tag_copy_regions should not be allowed with skip_save_build_region")
+	}
+}
+
+func TestAMIConfigPrepare_RegionVolumeTypes(t *testing.T) {
+	c := testAMIConfig()
+	c.AMIRegions = []string{"us-west-2"}
+	c.AMIRegionVolumeTypes = map[string]string{"us-west-2": "gp3"}
+	if errs := c.Prepare(FakeAccessConfig(), nil); len(errs) != 0 {
+		t.Fatalf("shouldn't have err: %v", errs)
+	}
+
+	c.AMIRegionVolumeTypes = map[string]string{"us-west-2": "st1"}
+	if errs := c.Prepare(FakeAccessConfig(), nil); len(errs) == 0 {
+		t.Fatal("st1 can't hold a root volume and should be refused")
+	}
+
+	c.AMIRegionVolumeTypes = map[string]string{"eu-west-1": "gp3"}
+	if errs := c.Prepare(FakeAccessConfig(), nil); len(errs) == 0 {
+		t.Fatal("regions of region_volume_types should be in ami_regions")
+	}
+}
+
+func TestAMIConfigPrepare_MaxSize(t *testing.T) {
+	c := testAMIConfig()
+	c.AMIMaxSizeGB = -1
+	if errs := c.Prepare(FakeAccessConfig(), nil); len(errs) == 0 {
+		t.Fatal("negative max_ami_size_gb should be refused")
+	}
+}
+
+func TestAMIConfigPrepare_ShareDuration(t *testing.T) {
+	c := testAMIConfig()
+	c.AMIShareDuration = 72 * time.Hour
+	c.AMIOrgArns = []string{"arn:aws:organizations::123456789012:organization/o-123456"}
+	if errs := c.Prepare(FakeAccessConfig(), nil); len(errs) != 0 {
+		t.Fatalf("should not have error: %v", errs)
+	}
+
+	c = testAMIConfig()
+	c.AMIShareDuration = 72 * time.Hour
+	if errs := c.Prepare(FakeAccessConfig(), nil); len(errs) == 0 {
+		t.Fatal("ami_share_duration should require the AMI to be shared")
+	}
+
+	c = testAMIConfig()
+	c.AMIShareDuration = -time.Hour
+	c.AMIGroups = []string{"all"}
+	if errs := c.Prepare(FakeAccessConfig(), nil); len(errs) == 0 {
+		t.Fatal("negative ami_share_duration should be refused")
+	}
+}
+
+func TestAMIConfigPrepare_BillingProducts(t *testing.T) {
+	c := testAMIConfig()
+	c.AMIBillingProducts = []string{"bp-6ba54002", "bp-6fa54006"}
+	if errs := c.Prepare(FakeAccessConfig(), nil); len(errs) != 0 {
+		t.Fatalf("valid billing products should be accepted: %v", errs)
+	}
+
+	for _, code := range []string{"6ba54002", "bp-", "bp-6BA5400G", "marketplace"} {
+		c := testAMIConfig()
+		c.AMIBillingProducts = []string{code}
+		if errs := c.Prepare(FakeAccessConfig(), nil); len(errs) == 0 {
+			t.Fatalf("billing product %q should be refused", code)
+		}
+	}
+}
+
+func TestAMIConfigPrepare_UsersFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "users")
+	contents := "# partners\n123456789012, 210987654321\n\n111122223333 # staging\n"
+	if err := os.WriteFile(path, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	c := testAMIConfig()
+	c.AMIUsers = []string{"444455556666"}
+	c.AMIUsersFile = path
+	if errs := c.Prepare(FakeAccessConfig(), nil); len(errs) != 0 {
+		t.Fatalf("shouldn't have err: %v", errs)
+	}
+	expected := []string{"444455556666", "123456789012", "210987654321", "111122223333"}
+	if !reflect.DeepEqual(c.AMIUsers, expected) {
+		t.Fatalf("expected ami_users %v, got %v", expected, c.AMIUsers)
+	}
+
+	if err := os.WriteFile(path, []byte("123456789012\nstaging\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	c = testAMIConfig()
+	c.AMIUsersFile = path
+	if errs := c.Prepare(FakeAccessConfig(), nil); len(errs) == 0 {
+		t.Fatal("entries that aren't account IDs should be refused")
+	}
+
+	c = testAMIConfig()
+	c.AMIUsersFile = filepath.Join(t.TempDir(), "missing")
+	if errs := c.Prepare(FakeAccessConfig(), nil); len(errs) == 0 {
+		t.Fatal("a missing ami_users_file should be refused")
+	}
+}
+
+func TestLoadSSMAccountIds(t *testing.T) {
+	conn := &userDataSSMConn{parameters: map[string]string{
+		"/sharing/list":  "123456789012,210987654321",
+		"/sharing/lines": "123456789012\n210987654321\n",
+		"/sharing/bad":   "123456789012,staging",
+	}}
+	expected := []string{"123456789012", "210987654321"}
+
+	for _, name := range []string{"/sharing/list", "/sharing/lines"} {
+		users, err := loadSSMAccountIds(conn, name)
+		if err != nil {
+			t.Fatalf("%s: shouldn't have err: %s", name, err)
+		}
+		if !reflect.DeepEqual(users, expected) {
+			t.Fatalf("%s: expected %v, got %v", name, expected, users)
+		}
+	}
+
+	for _, name := range []string{"/sharing/bad", "/sharing/missing"} {
+		if _, err := loadSSMAccountIds(conn, name); err == nil {
+			t.Fatalf("%s: should have err", name)
+		}
+	}
+}
+
+func TestAMIConfigCheckMaxSize(t *testing.T) {
+	launch := BlockDevices{
+		{DeviceName: "/dev/sda1", VolumeSize: 8},
+		{DeviceName: "/dev/sdb", VolumeSize: 10},
+		{DeviceName: "/dev/sdc"},
+	}
+
+	cases := []struct {
+		name    string
+		max     int64
+		ami     BlockDevices
+		wantErr bool
+	}{
+		{"no limit", 0, nil, false},
+		{"at the limit", 18, nil, false},
+		{"over the limit", 17, nil, true},
+		{"ami mapping overrides launch mapping", 20, BlockDevices{{DeviceName: "/dev/sdb", VolumeSize: 12}}, false},
+		{"ami mapping overrides launch mapping over the limit", 20, BlockDevices{{DeviceName: "/dev/sdb", VolumeSize: 13}}, true},
+		{"ami mapping adds a volume", 18, BlockDevices{{DeviceName: "/dev/sdd", VolumeSize: 1}}, true},
+		{"no_device removes a volume", 8, BlockDevices{{DeviceName: "/dev/sdb", NoDevice: true}}, false},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := testAMIConfig()
+			c.AMIMaxSizeGB = tc.max
+			err := c.CheckMaxSize(launch, tc.ami)
+			if tc.wantErr && err == nil {
+				t.Fatal("should have error")
+			}
+			if !tc.wantErr && err != nil {
+				t.Fatalf("shouldn't have err: %s", err)
+			}
+		})
+	}
+}
+
+func TestAMIConfigPrepare_InstanceMetadataTags(t *testing.T) {
+	c := testAMIConfig()
+	c.AMIInstanceMetadataTags = true
+	if errs := c.Prepare(FakeAccessConfig(), nil); len(errs) == 0 {
+		t.Fatal("imds_instance_metadata_tags should require imds_support")
+	}
+
+	c = testAMIConfig()
+	c.AMIIMDSSupport = "v2.0"
+	c.AMIInstanceMetadataTags = true
+	c.AMITags = map[string]string{"Name": "web"}
+	if errs := c.Prepare(FakeAccessConfig(), nil); len(errs) != 0 {
+		t.Fatalf("shouldn't have err: %v", errs)
+	}
+	if c.AMITags[instanceMetadataTagsTagKey] != "enabled" || c.AMITags["Name"] != "web" {
+		t.Fatalf("the AMI should be tagged with the recommendation, got %v", c.AMITags)
+	}
+
+	c = testAMIConfig()
+	c.AMIIMDSSupport = "v2.0"
+	c.AMIInstanceMetadataTags = true
+	c.AMITags = map[string]string{instanceMetadataTagsTagKey: "disabled"}
+	if errs := c.Prepare(FakeAccessConfig(), nil); len(errs) == 0 {
+		t.Fatal("a contradicting tag should be refused")
+	}
+
+	c = testAMIConfig()
+	c.AMIIMDSSupport = "v2.0"
+	c.AMIInstanceMetadataTags = true
+	c.AMITag = config.KeyValues{{Key: instanceMetadataTagsTagKey, Value: "disabled"}}
+	if errs := c.Prepare(FakeAccessConfig(), nil); len(errs) == 0 {
+		t.Fatal("a contradicting tag block should be refused")
+	}
+}
+
+func TestAMIConfigPrepare_CentralAccountRole(t *testing.T) {
+	accessConf := FakeAccessConfig()
+
+	c := testAMIConfig()
+	c.AMICentralAccountKmsKeyId = "alias/central"
+	if err := c.Prepare(accessConf, nil); err == nil {
+		t.Fatal("central_account_kms_key_id should require central_account_role")
+	}
+
+	c.AMICentralAccountRole.AssumeRoleARN = "arn:aws:iam::222222222222:role/image-copier"
+	if err := c.Prepare(accessConf, nil); err != nil {
+		t.Fatalf("shouldn't have err: %v", err)
+	}
+
+	c.AMICentralAccountRole.AssumeRoleARN = "image-copier"
+	if err := c.Prepare(accessConf, nil); err == nil {
+		t.Fatal("central_account_role should require a role ARN")
+	}
+
+	c = testAMIConfig()
+	c.AMICentralAccountRole.AssumeRoleARN = "arn:aws:iam::222222222222:role/image-copier"
+	c.AMIEncryptBootVolume = config.TriTrue
+	if err := c.Prepare(accessConf, nil); err == nil {
+		t.Fatal("shouldn't be able to copy an AMI encrypted with the default key")
+	}
+
+	c = testAMIConfig()
+	c.AMICentralAccountRole.AssumeRoleARN = "arn:aws:iam::222222222222:role/image-copier"
+	c.AMISkipBuildRegion = true
+	c.AMIRegions = []string{"us-west-2"}
+	if err := c.Prepare(accessConf, nil); err == nil {
+		t.Fatal("central_account_role should not be usable with skip_save_build_region")
+	}
+}
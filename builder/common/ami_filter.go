@@ -8,6 +8,7 @@ import (
 	"fmt"
 	"log"
 
+	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/ec2"
 )
 
@@ -49,6 +50,20 @@ func (d *AmiFilterOptions) NoOwner() bool {
 }
 
 func (d *AmiFilterOptions) GetFilteredImage(params *ec2.DescribeImagesInput, ec2conn *ec2.EC2) (*ec2.Image, error) {
+	images, err := d.getFilteredImages(params, ec2conn)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(images) == 0 {
+		err := fmt.Errorf("No AMI was found matching filters: %v", params)
+		return nil, err
+	}
+
+	return selectImage(images, d.MostRecent)
+}
+
+func (d *AmiFilterOptions) getFilteredImages(params *ec2.DescribeImagesInput, ec2conn *ec2.EC2) ([]*ec2.Image, error) {
 	// We have filters to apply
 	if len(d.Filters) > 0 {
 		amiFilters, err := buildEc2Filters(d.Filters)
@@ -74,21 +89,138 @@ func (d *AmiFilterOptions) GetFilteredImage(params *ec2.DescribeImagesInput, ec2
 		return nil, err
 	}
 
-	if len(imageResp.Images) == 0 {
-		err := fmt.Errorf("No AMI was found matching filters: %v", params)
-		return nil, err
-	}
+	return imageResp.Images, nil
+}
 
-	if len(imageResp.Images) > 1 && !d.MostRecent {
+func selectImage(images []*ec2.Image, mostRecent bool) (*ec2.Image, error) {
+	if len(images) > 1 && !mostRecent {
 		err := fmt.Errorf("Your query returned more than one result. Please try a more specific search, or set most_recent to true.")
 		return nil, err
 	}
 
 	var image *ec2.Image
-	if d.MostRecent {
-		image = mostRecentAmi(imageResp.Images)
+	if mostRecent {
+		image = mostRecentAmi(images)
 	} else {
-		image = imageResp.Images[0]
+		image = images[0]
 	}
 	return image, nil
 }
+
+const (
+	AmiFilterOperatorAnd = "and"
+	AmiFilterOperatorOr  = "or"
+)
+
+type AmiFilterGroupOptions struct {
+	// How the images matched by each filter group are combined: `and` only
+	// keeps the images matched by every group, `or` keeps the images matched
+	// by any group. Defaults to `or`.
+	Operator string `mapstructure:"operator"`
+	// The filter groups. Each group takes the same options as
+	// `source_ami_filter`, except `most_recent` which can only be set for
+	// the combined result. At least one group must be set.
+	FilterGroups []AmiFilterOptions `mapstructure:"filter_group"`
+	// Selects the newest created image out of the combined result when true.
+	MostRecent bool `mapstructure:"most_recent"`
+}
+
+func (d *AmiFilterGroupOptions) Empty() bool {
+	return d.Operator == "" && len(d.FilterGroups) == 0 && !d.MostRecent
+}
+
+func (d *AmiFilterGroupOptions) Prepare() []error {
+	var errs []error
+
+	if d.Empty() {
+		return nil
+	}
+
+	if d.Operator == "" {
+		d.Operator = AmiFilterOperatorOr
+	}
+	if d.Operator != AmiFilterOperatorAnd && d.Operator != AmiFilterOperatorOr {
+		errs = append(errs, fmt.Errorf("source_ami_filters operator must be one of %q or %q, got %q",
+			AmiFilterOperatorAnd, AmiFilterOperatorOr, d.Operator))
+	}
+
+	if len(d.FilterGroups) == 0 {
+		errs = append(errs, fmt.Errorf("source_ami_filters must declare at least one filter_group"))
+	}
+
+	for i, group := range d.FilterGroups {
+		if group.Empty() {
+			errs = append(errs, fmt.Errorf("source_ami_filters filter_group %d must declare filters or owners", i))
+		}
+		if group.NoOwner() {
+			errs = append(errs, fmt.Errorf("For security reasons, source_ami_filters filter_group %d must declare an owner.", i))
+		}
+		if group.MostRecent {
+			errs = append(errs, fmt.Errorf("source_ami_filters filter_group %d can't set most_recent, "+
+				"set it on source_ami_filters to select the newest image of the combined result", i))
+		}
+	}
+
+	return errs
+}
+
+// GetFilteredImage queries the images matched by every filter group and
+// combines them according to the operator.
+func (d *AmiFilterGroupOptions) GetFilteredImage(params *ec2.DescribeImagesInput, ec2conn *ec2.EC2) (*ec2.Image, error) {
+	var images []*ec2.Image
+	for i, group := range d.FilterGroups {
+		groupParams := &ec2.DescribeImagesInput{
+			ImageIds: params.ImageIds,
+		}
+		groupImages, err := group.getFilteredImages(groupParams, ec2conn)
+		if err != nil {
+			return nil, err
+		}
+
+		if i == 0 {
+			images = groupImages
+		} else if d.Operator == AmiFilterOperatorAnd {
+			images = intersectImages(images, groupImages)
+		} else {
+			images = unionImages(images, groupImages)
+		}
+	}
+
+	if len(images) == 0 {
+		err := fmt.Errorf("No AMI was found matching the %q combination of source_ami_filters", d.Operator)
+		return nil, err
+	}
+
+	return selectImage(images, d.MostRecent)
+}
+
+func intersectImages(a, b []*ec2.Image) []*ec2.Image {
+	ids := make(map[string]bool, len(b))
+	for _, image := range b {
+		ids[aws.StringValue(image.ImageId)] = true
+	}
+
+	var images []*ec2.Image
+	for _, image := range a {
+		if ids[aws.StringValue(image.ImageId)] {
+			images = append(images, image)
+		}
+	}
+	return images
+}
+
+func unionImages(a, b []*ec2.Image) []*ec2.Image {
+	ids := make(map[string]bool, len(a))
+	for _, image := range a {
+		ids[aws.StringValue(image.ImageId)] = true
+	}
+
+	images := a
+	for _, image := range b {
+		if !ids[aws.StringValue(image.ImageId)] {
+			ids[aws.StringValue(image.ImageId)] = true
+			images = append(images, image)
+		}
+	}
+	return images
+}
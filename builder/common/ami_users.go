@@ -0,0 +1,73 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package common
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/aws/aws-sdk-go/service/ssm/ssmiface"
+)
+
+var reAccountId = regexp.MustCompile(`^\d{12}$`)
+
+// parseAccountIds splits a list of account IDs separated by commas or
+// whitespace. Everything after a # on a line is a comment.
+func parseAccountIds(list string) []string {
+	var ids []string
+	for _, line := range strings.Split(list, "\n") {
+		line, _, _ = strings.Cut(line, "#")
+		ids = append(ids, strings.FieldsFunc(line, func(r rune) bool {
+			return r == ',' || r == ' ' || r == '\t' || r == '\r'
+		})...)
+	}
+	return ids
+}
+
+// validateAccountIds checks that every entry read from source is an AWS
+// account ID.
+func validateAccountIds(source string, ids []string) error {
+	for _, id := range ids {
+		if !reAccountId.MatchString(id) {
+			return fmt.Errorf("%s: %q is not a 12-digit AWS account ID", source, id)
+		}
+	}
+	return nil
+}
+
+// loadAccountIdsFile reads the account IDs listed in path.
+func loadAccountIdsFile(path string) ([]string, error) {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Problem reading ami_users_file: %s", err)
+	}
+	ids := parseAccountIds(string(contents))
+	if err := validateAccountIds(path, ids); err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+// loadSSMAccountIds reads the account IDs stored in the SSM parameter name,
+// either as a StringList or one account per line.
+func loadSSMAccountIds(conn ssmiface.SSMAPI, name string) ([]string, error) {
+	log.Printf("[DEBUG] Reading AMI users from SSM parameter %s", name)
+	resp, err := conn.GetParameter(&ssm.GetParameterInput{
+		Name:           aws.String(name),
+		WithDecryption: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Problem reading AMI users from SSM parameter %s: %s", name, err)
+	}
+	ids := parseAccountIds(aws.StringValue(resp.Parameter.Value))
+	if err := validateAccountIds("SSM parameter "+name, ids); err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
@@ -6,6 +6,7 @@ package common
 import (
 	"fmt"
 	"log"
+	"os"
 	"sort"
 	"strings"
 
@@ -28,6 +29,14 @@ type Artifact struct {
 	// to be shared with post-processors
 	StateData map[string]interface{}
 
+	// CentralAmi is the ID of the copy of the AMI in the account of
+	// central_account_role, in the build region.
+	CentralAmi string
+
+	// LocalFiles are files produced on the machine running Packer next to
+	// the AMIs, such as a disk image dump of the root volume.
+	LocalFiles []string
+
 	// EC2 connection for performing API stuff.
 	Session *session.Session
 }
@@ -36,9 +45,8 @@ func (a *Artifact) BuilderId() string {
 	return a.BuilderIdValue
 }
 
-func (*Artifact) Files() []string {
-	// We have no files
-	return nil
+func (a *Artifact) Files() []string {
+	return a.LocalFiles
 }
 
 func (a *Artifact) Id() string {
@@ -59,6 +67,10 @@ func (a *Artifact) String() string {
 	}
 
 	sort.Strings(amiStrings)
+	if a.CentralAmi != "" {
+		return fmt.Sprintf("AMIs were created:\n%s\nAMI copied to the central account: %s\n",
+			strings.Join(amiStrings, "\n"), a.CentralAmi)
+	}
 	return fmt.Sprintf("AMIs were created:\n%s\n", strings.Join(amiStrings, "\n"))
 }
 
@@ -107,6 +119,13 @@ func (a *Artifact) Destroy() error {
 		}
 	}
 
+	for _, path := range a.LocalFiles {
+		log.Printf("Removing local file (%s)", path)
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			errors = append(errors, err)
+		}
+	}
+
 	if len(errors) > 0 {
 		if len(errors) == 1 {
 			return errors[0]
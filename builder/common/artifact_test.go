@@ -69,6 +69,19 @@ west: bar
 	}
 }
 
+func TestArtifactString_centralAmi(t *testing.T) {
+	expected := `AMIs were created:
+east: foo
+AMI copied to the central account: baz
+`
+
+	a := &Artifact{Amis: map[string]string{"east": "foo"}, CentralAmi: "baz"}
+	result := a.String()
+	if result != expected {
+		t.Fatalf("bad: %s", result)
+	}
+}
+
 func TestArtifactState(t *testing.T) {
 	expectedData := "this is the data"
 	artifact := &Artifact{
@@ -148,3 +161,15 @@ func TestArtifactState_hcpPackerRegistryMetadata(t *testing.T) {
 		t.Fatalf("bad: %#v", images)
 	}
 }
+
+func TestArtifactFiles(t *testing.T) {
+	a := &Artifact{}
+	if files := a.Files(); len(files) != 0 {
+		t.Fatalf("bad: %#v", files)
+	}
+
+	a.LocalFiles = []string{"output-chroot/chroot.raw"}
+	if files := a.Files(); !reflect.DeepEqual(files, a.LocalFiles) {
+		t.Fatalf("bad: %#v", files)
+	}
+}
@@ -80,7 +80,7 @@ type BlockDevice struct {
 	// The number of I/O operations per second (IOPS) that the volume supports.
 	// See the documentation on
 	// [IOPs](https://docs.aws.amazon.com/AWSEC2/latest/APIReference/API_EbsBlockDevice.html)
-	// for more information
+	// for more information. Not supported for standard (magnetic) volumes.
 	IOPS *int64 `mapstructure:"iops" required:"false"`
 	// Suppresses the specified device included in the block device mapping of
 	// the AMI.
@@ -201,6 +201,12 @@ func (b *BlockDevice) Prepare(ctx *interpolate.Context) error {
 			"true` when setting a kms_key_id.", b.DeviceName)
 	}
 
+	// Magnetic volumes don't support provisioned IOPS
+	if b.VolumeType == "standard" && b.IOPS != nil {
+		return fmt.Errorf("IOPS is not valid for standard volumes, %q is of type %s",
+			b.DeviceName, b.VolumeType)
+	}
+
 	if ratio, ok := iopsRatios[b.VolumeType]; b.VolumeSize != 0 && ok {
 		if b.IOPS != nil && (*b.IOPS/b.VolumeSize > ratio) {
 			return fmt.Errorf("%s: the maximum ratio of provisioned IOPS to requested volume size "+
@@ -320,6 +320,34 @@ func TestIOPSValidation(t *testing.T) {
 			ok:  false,
 			msg: "IOPS must be between 3000 and 16000 for device /dev/sdb",
 		},
+		// gp3 without explicit iops uses the baseline performance
+		{
+			device: BlockDevice{
+				DeviceName: "/dev/sdb",
+				VolumeType: "gp3",
+				VolumeSize: 50,
+			},
+			ok: true,
+		},
+		// standard (magnetic) volumes don't support provisioned iops
+		{
+			device: BlockDevice{
+				DeviceName: "/dev/sdb",
+				VolumeType: "standard",
+				VolumeSize: 50,
+			},
+			ok: true,
+		},
+		{
+			device: BlockDevice{
+				DeviceName: "/dev/sdb",
+				VolumeType: "standard",
+				VolumeSize: 50,
+				IOPS:       aws.Int64(1000),
+			},
+			ok:  false,
+			msg: "IOPS is not valid for standard volumes, \"/dev/sdb\" is of type standard",
+		},
 	}
 
 	ctx := interpolate.Context{}
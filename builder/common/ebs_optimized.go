@@ -0,0 +1,123 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package common
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// EBS optimization support of an instance family.
+const (
+	ebsOptimizedUnsupported = iota
+	ebsOptimizedOptional
+	ebsOptimizedDefault
+)
+
+// ebsOptimizedFamilies maps instance families, the series and generation of
+// an instance type, to their EBS optimization support. See
+// https://docs.aws.amazon.com/AWSEC2/latest/UserGuide/ebs-optimized.html.
+// Families missing from the map are not validated.
+var ebsOptimizedFamilies = map[string]int{
+	// EBS optimized by default, setting ebs_optimized has no effect.
+	"a1": ebsOptimizedDefault, "c4": ebsOptimizedDefault, "c5": ebsOptimizedDefault,
+	"c6": ebsOptimizedDefault, "c7": ebsOptimizedDefault, "c8": ebsOptimizedDefault,
+	"d2": ebsOptimizedDefault, "d3": ebsOptimizedDefault, "dl1": ebsOptimizedDefault,
+	"f1": ebsOptimizedDefault, "g3": ebsOptimizedDefault, "g4": ebsOptimizedDefault,
+	"g5": ebsOptimizedDefault, "g6": ebsOptimizedDefault, "h1": ebsOptimizedDefault,
+	"hpc6": ebsOptimizedDefault, "hpc7": ebsOptimizedDefault, "i3": ebsOptimizedDefault,
+	"i4": ebsOptimizedDefault, "im4": ebsOptimizedDefault, "inf1": ebsOptimizedDefault,
+	"inf2": ebsOptimizedDefault, "is4": ebsOptimizedDefault, "m4": ebsOptimizedDefault,
+	"m5": ebsOptimizedDefault, "m6": ebsOptimizedDefault, "m7": ebsOptimizedDefault,
+	"m8": ebsOptimizedDefault, "mac1": ebsOptimizedDefault, "mac2": ebsOptimizedDefault,
+	"p2": ebsOptimizedDefault, "p3": ebsOptimizedDefault, "p4": ebsOptimizedDefault,
+	"p5": ebsOptimizedDefault, "r4": ebsOptimizedDefault, "r5": ebsOptimizedDefault,
+	"r6": ebsOptimizedDefault, "r7": ebsOptimizedDefault, "r8": ebsOptimizedDefault,
+	"t3": ebsOptimizedDefault, "t4": ebsOptimizedDefault, "trn1": ebsOptimizedDefault,
+	"trn2": ebsOptimizedDefault, "u7": ebsOptimizedDefault, "vt1": ebsOptimizedDefault,
+	"x1": ebsOptimizedDefault, "x2": ebsOptimizedDefault, "z1": ebsOptimizedDefault,
+
+	// EBS optimization isn't available, except for the instance types of
+	// ebsOptimizedOptionalTypes.
+	"c1": ebsOptimizedUnsupported, "c3": ebsOptimizedUnsupported, "g2": ebsOptimizedUnsupported,
+	"i2": ebsOptimizedUnsupported, "m1": ebsOptimizedUnsupported, "m2": ebsOptimizedUnsupported,
+	"m3": ebsOptimizedUnsupported, "r3": ebsOptimizedUnsupported,
+	"cc2": ebsOptimizedUnsupported, "cr1": ebsOptimizedUnsupported,
+	"hs1": ebsOptimizedUnsupported, "t1": ebsOptimizedUnsupported,
+	"t2": ebsOptimizedUnsupported,
+}
+
+// ebsOptimizedOptionalTypes are the previous generation instance types on
+// which EBS optimization is available, but must be requested. The other
+// sizes of their families don't support it.
+var ebsOptimizedOptionalTypes = map[string]bool{
+	"c1.xlarge": true, "c3.xlarge": true, "c3.2xlarge": true, "c3.4xlarge": true,
+	"g2.2xlarge": true, "i2.xlarge": true, "i2.2xlarge": true, "i2.4xlarge": true,
+	"m1.large": true, "m1.xlarge": true, "m2.2xlarge": true, "m2.4xlarge": true,
+	"m3.xlarge": true, "m3.2xlarge": true, "r3.xlarge": true, "r3.2xlarge": true,
+	"r3.4xlarge": true,
+}
+
+var instanceFamilyRe = regexp.MustCompile(`^([a-z]+\d+)`)
+
+// instanceFamily returns the family of instanceType, e.g. "m5" for
+// "m5d.large", or "" if it can't be parsed.
+func instanceFamily(instanceType string) string {
+	series, _, _ := strings.Cut(instanceType, ".")
+	return instanceFamilyRe.FindString(series)
+}
+
+// ebsOptimizedSupport returns the EBS optimization support of instanceType,
+// and whether its family is known.
+func ebsOptimizedSupport(instanceType string) (int, bool) {
+	if ebsOptimizedOptionalTypes[instanceType] {
+		return ebsOptimizedOptional, true
+	}
+	support, ok := ebsOptimizedFamilies[instanceFamily(instanceType)]
+	return support, ok
+}
+
+// ebsOptimizedInstanceTypes returns the instance types the source instance
+// may be launched with.
+func (c *RunConfig) ebsOptimizedInstanceTypes() []string {
+	if c.InstanceType != "" {
+		return []string{c.InstanceType}
+	}
+	return c.SpotInstanceTypes
+}
+
+// prepareEbsOptimized errors if ebs_optimized is set for an instance type
+// that can't be EBS optimized.
+func (c *RunConfig) prepareEbsOptimized() []error {
+	if !c.EbsOptimized {
+		return nil
+	}
+	var errs []error
+	for _, instanceType := range c.ebsOptimizedInstanceTypes() {
+		if support, ok := ebsOptimizedSupport(instanceType); ok && support == ebsOptimizedUnsupported {
+			errs = append(errs, fmt.Errorf("ebs_optimized is not supported by the instance type %s", instanceType))
+		}
+	}
+	return errs
+}
+
+// EbsOptimizedWarning returns a warning if ebs_optimized is set but all the
+// instance types are EBS optimized by default, or "" otherwise.
+func (c *RunConfig) EbsOptimizedWarning() string {
+	if !c.EbsOptimized {
+		return ""
+	}
+	instanceTypes := c.ebsOptimizedInstanceTypes()
+	if len(instanceTypes) == 0 {
+		return ""
+	}
+	for _, instanceType := range instanceTypes {
+		if support, ok := ebsOptimizedSupport(instanceType); !ok || support != ebsOptimizedDefault {
+			return ""
+		}
+	}
+	return fmt.Sprintf("ebs_optimized has no effect, the instance types %s are EBS "+
+		"optimized by default.", strings.Join(instanceTypes, ", "))
+}
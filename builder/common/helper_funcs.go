@@ -11,12 +11,14 @@ import (
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
 	"github.com/hashicorp/packer-plugin-amazon/builder/common/awserrors"
+	awscommon "github.com/hashicorp/packer-plugin-amazon/common"
 	"github.com/hashicorp/packer-plugin-sdk/retry"
 )
 
 // DestroyAMIs deregisters the AWS machine images in imageids from an active AWS account
-func DestroyAMIs(imageids []*string, ec2conn *ec2.EC2) error {
+func DestroyAMIs(imageids []*string, ec2conn ec2iface.EC2API) error {
 	resp, err := ec2conn.DescribeImages(&ec2.DescribeImagesInput{
 		ImageIds: imageids,
 	})
@@ -76,3 +78,77 @@ func DestroyAMIs(imageids []*string, ec2conn *ec2.EC2) error {
 	}
 	return nil
 }
+
+// CheckRegisterAgain returns an error if image can't be registered again
+// from its snapshots. RegisterImage doesn't carry over its platform and usage
+// operation, so an AMI billed with a license, such as the one of Windows,
+// would lose it.
+func CheckRegisterAgain(image *ec2.Image) error {
+	usage := aws.StringValue(image.UsageOperation)
+	if usage == "" || usage == "RunInstances" {
+		return nil
+	}
+	return fmt.Errorf("AMI %s is billed as %s (%s), which registering it again from its snapshots would lose",
+		aws.StringValue(image.ImageId), aws.StringValue(image.PlatformDetails), usage)
+}
+
+// RegisterImageInputFromImage returns the input registering an AMI like image
+// from its snapshots, with its name and tags. The encryption of the volumes
+// is left to the one of their snapshots.
+func RegisterImageInputFromImage(image *ec2.Image) *ec2.RegisterImageInput {
+	mappings := make([]*ec2.BlockDeviceMapping, len(image.BlockDeviceMappings))
+	for i, mapping := range image.BlockDeviceMappings {
+		m := *mapping
+		if mapping.Ebs != nil {
+			ebs := *mapping.Ebs
+			ebs.Encrypted = nil
+			ebs.KmsKeyId = nil
+			m.Ebs = &ebs
+		}
+		mappings[i] = &m
+	}
+
+	input := &ec2.RegisterImageInput{
+		Name:                image.Name,
+		Description:         image.Description,
+		Architecture:        image.Architecture,
+		RootDeviceName:      image.RootDeviceName,
+		VirtualizationType:  image.VirtualizationType,
+		EnaSupport:          image.EnaSupport,
+		SriovNetSupport:     image.SriovNetSupport,
+		BootMode:            image.BootMode,
+		TpmSupport:          image.TpmSupport,
+		ImdsSupport:         image.ImdsSupport,
+		BlockDeviceMappings: mappings,
+	}
+	if len(image.Tags) > 0 {
+		input.TagSpecifications = []*ec2.TagSpecification{{
+			ResourceType: aws.String(ec2.ResourceTypeImage),
+			Tags:         image.Tags,
+		}}
+	}
+	return input
+}
+
+// ReregisterImage registers input in place of the AMI imageId, see
+// awscommon.ReplaceImage, and returns the ID of the new AMI.
+func ReregisterImage(ctx context.Context, conn ec2iface.EC2API, pollingConfig *AWSPollingConfig, imageId string, input *ec2.RegisterImageInput) (string, error) {
+	register := func(name string) (string, error) {
+		params := *input
+		params.Name = aws.String(name)
+		resp, err := conn.RegisterImage(&params)
+		if err != nil {
+			return "", err
+		}
+		newImageId := aws.StringValue(resp.ImageId)
+		if err := pollingConfig.WaitUntilAMIAvailable(ctx, conn, newImageId); err != nil {
+			return "", fmt.Errorf("Error waiting for AMI (%s): %s", newImageId, err)
+		}
+		return newImageId, nil
+	}
+	deregister := func(imageId string) error {
+		_, err := conn.DeregisterImage(&ec2.DeregisterImageInput{ImageId: aws.String(imageId)})
+		return err
+	}
+	return awscommon.ReplaceImage(imageId, aws.StringValue(input.Name), register, deregister)
+}
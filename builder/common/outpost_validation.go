@@ -0,0 +1,20 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package common
+
+import (
+	"fmt"
+	"regexp"
+)
+
+var outpostArnPattern = regexp.MustCompile(`^arn:aws(-[a-z]{2}(-gov)?)?:outposts:[a-z]{2}-(gov-)?[a-z]+-\d{1}:\d{12}:outpost/op-[a-f0-9]{17}$`)
+
+// IsValidOutpostArn checks that outpostArn is the ARN of an AWS Outpost
+func IsValidOutpostArn(outpostArn string) error {
+	if !outpostArnPattern.MatchString(outpostArn) {
+		return fmt.Errorf("invalid outpost_arn %q, expected an ARN like "+
+			"'arn:aws:outposts:us-east-1:123456789012:outpost/op-1234567890abcdef0'", outpostArn)
+	}
+	return nil
+}
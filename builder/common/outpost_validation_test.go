@@ -0,0 +1,32 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package common
+
+import "testing"
+
+func TestIsValidOutpostArn(t *testing.T) {
+	tests := []struct {
+		name        string
+		value       string
+		expectError bool
+	}{
+		{"Valid ARN", "arn:aws:outposts:us-east-1:123456789012:outpost/op-1234567890abcdef0", false},
+		{"Valid GovCloud ARN", "arn:aws-us-gov:outposts:us-gov-west-1:123456789012:outpost/op-1234567890abcdef0", false},
+		{"Outpost ID only", "op-1234567890abcdef0", true},
+		{"Not an outpost", "arn:aws:ec2:us-east-1:123456789012:volume/vol-1234567890abcdef0", true},
+		{"Short outpost ID", "arn:aws:outposts:us-east-1:123456789012:outpost/op-1234", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := IsValidOutpostArn(tt.value)
+			if tt.expectError && err == nil {
+				t.Errorf("expected %q to be invalid", tt.value)
+			}
+			if !tt.expectError && err != nil {
+				t.Errorf("expected %q to be valid, got %s", tt.value, err)
+			}
+		})
+	}
+}
@@ -2,7 +2,7 @@
 // SPDX-License-Identifier: MPL-2.0
 
 //go:generate packer-sdc struct-markdown
-//go:generate packer-sdc mapstructure-to-hcl2 -type AmiFilterOptions,SecurityGroupFilterOptions,SubnetFilterOptions,VpcFilterOptions,PolicyDocument,Statement,MetadataOptions,LicenseConfigurationRequest,LicenseSpecification,Placement
+//go:generate packer-sdc mapstructure-to-hcl2 -type AmiFilterOptions,AmiFilterGroupOptions,SecurityGroupFilterOptions,SubnetFilterOptions,VpcFilterOptions,PolicyDocument,Statement,MetadataOptions,PrivateDnsNameOptions,LicenseConfigurationRequest,LicenseSpecification,Placement
 
 package common
 
@@ -15,6 +15,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/hashicorp/packer-plugin-sdk/communicator"
 	"github.com/hashicorp/packer-plugin-sdk/template/config"
@@ -28,8 +29,22 @@ const (
 	CPUCreditsUnlimited = "unlimited"
 )
 
+// RunUUIDTagKey is the key of the tag set to the UUID of the Packer run on
+// the resources of the build when tag_ephemeral_with_run_uuid is set.
+const RunUUIDTagKey = "packer:run-uuid"
+
 var reShutdownBehavior = regexp.MustCompile("^(stop|terminate)$")
 
+var reEIPAllocationId = regexp.MustCompile(`^eipalloc-([0-9a-f]{8}|[0-9a-f]{17})$`)
+
+// Local Zones are named after their parent region and a metro code, e.g.
+// us-west-2-lax-1a, and Wavelength Zones after their carrier, e.g.
+// us-east-1-wl1-bos-wlz-1.
+var (
+	reLocalZone      = regexp.MustCompile(`^[a-z]{2}(-gov)?-[a-z]+-\d+-[a-z]+-\d+[a-z]$`)
+	reWavelengthZone = regexp.MustCompile(`^[a-z]{2}-[a-z]+-\d+-wl\d+-[a-z]+-wlz-\d+$`)
+)
+
 type SubnetFilterOptions struct {
 	config.NameValueFilter `mapstructure:",squash"`
 	MostFree               bool `mapstructure:"most_free"`
@@ -114,6 +129,37 @@ type MetadataOptions struct {
 	InstanceMetadataTags string `mapstructure:"instance_metadata_tags" required:"false"`
 }
 
+// Configures the hostname of the instance and the DNS records of its
+// resource name.
+// See [Amazon EC2 instance hostname types](https://docs.aws.amazon.com/AWSEC2/latest/UserGuide/ec2-instance-naming.html) for details.
+type PrivateDnsNameOptions struct {
+	// The type of hostname of the instance, either `ip-name` or
+	// `resource-name`. Defaults to the setting of the subnet.
+	HostnameType string `mapstructure:"hostname_type" required:"false"`
+	// Answer DNS queries for the resource name with the IPv4 address of the
+	// instance. Defaults to false.
+	EnableResourceNameDnsARecord bool `mapstructure:"enable_resource_name_dns_a_record" required:"false"`
+	// Answer DNS queries for the resource name with the IPv6 address of the
+	// instance. The subnet must support IPv6. Defaults to false.
+	EnableResourceNameDnsAAAARecord bool `mapstructure:"enable_resource_name_dns_aaaa_record" required:"false"`
+}
+
+// Empty reports whether none of the options are set.
+func (o *PrivateDnsNameOptions) Empty() bool {
+	return o.HostnameType == "" && !o.EnableResourceNameDnsARecord && !o.EnableResourceNameDnsAAAARecord
+}
+
+func (o *PrivateDnsNameOptions) request() *ec2.PrivateDnsNameOptionsRequest {
+	request := &ec2.PrivateDnsNameOptionsRequest{
+		EnableResourceNameDnsARecord:    aws.Bool(o.EnableResourceNameDnsARecord),
+		EnableResourceNameDnsAAAARecord: aws.Bool(o.EnableResourceNameDnsAAAARecord),
+	}
+	if o.HostnameType != "" {
+		request.HostnameType = aws.String(o.HostnameType)
+	}
+	return request
+}
+
 // RunConfig contains configuration for running an instance from a source
 // AMI and details on how to access that launched image.
 type RunConfig struct {
@@ -136,8 +182,21 @@ type RunConfig struct {
 	// Otherwise, Packer will pick the most available subnet in the VPC selected,
 	// which may not be able to host the instance type you provided.
 	AssociatePublicIpAddress config.Trilean `mapstructure:"associate_public_ip_address" required:"false"`
+	// The allocation ID of an existing Elastic IP, such as
+	// `eipalloc-0123456789abcdef0`, to associate with the source instance once
+	// it is running. This is useful when egress controls only allow a known
+	// address. The Elastic IP is disassociated, but not released, at the end
+	// of the build. It can't be used with spot instances.
+	AssociateEIPAllocationId string `mapstructure:"associate_eip_allocation_id" required:"false"`
 	// Destination availability zone to launch
 	// instance in. Leave this empty to allow Amazon to auto-assign.
+	//
+	// This can also be a Local Zone, such as `us-west-2-lax-1a`, or a
+	// Wavelength Zone, such as `us-east-1-wl1-bos-wlz-1`, for the instance
+	// and its volumes to be created there. These zones have no default
+	// subnet, so `subnet_id` or `subnet_filter` must select a subnet of the
+	// zone, and Wavelength Zones can't be used with
+	// `associate_public_ip_address`, as they only offer carrier IP addresses.
 	AvailabilityZone string `mapstructure:"availability_zone" required:"false"`
 	// Requires spot_price to be set. The
 	// required duration for the Spot Instances (also known as Spot blocks). This
@@ -183,12 +242,21 @@ type RunConfig struct {
 	DisableStopInstance bool `mapstructure:"disable_stop_instance" required:"false"`
 	// Mark instance as [EBS
 	// Optimized](https://docs.aws.amazon.com/AWSEC2/latest/UserGuide/EBSOptimized.html).
-	// Default `false`.
+	// Default `false`. Packer errors if the instance type can't be EBS
+	// optimized, e.g. `t2`, and warns if it already is by default, e.g. `m5`.
 	EbsOptimized bool `mapstructure:"ebs_optimized" required:"false"`
 	// Enable support for Nitro Enclaves on the instance.  Note that the instance type must
 	// be able to [support Nitro Enclaves](https://aws.amazon.com/ec2/nitro/nitro-enclaves/faqs/).
 	// This option is not supported for spot instances.
 	EnableNitroEnclave bool `mapstructure:"enable_nitro_enclave" required:"false"`
+	// Assign the first IPv6 address of the primary network interface of the
+	// instance as its primary IPv6 address, which doesn't change for the
+	// lifetime of the interface. Requires `subnet_id` or `subnet_filter`,
+	// and the subnet must support IPv6. Defaults to false.
+	EnablePrimaryIpv6 bool `mapstructure:"enable_primary_ipv6" required:"false"`
+	// The hostname of the instance and the DNS records of its resource name.
+	// See [Private DNS Name Options](#private-dns-name-options) for fields.
+	PrivateDnsNameOptions PrivateDnsNameOptions `mapstructure:"private_dns_name_options" required:"false"`
 	// Deprecated argument - please use "enable_unlimited_credits".
 	// Enabling T2 Unlimited allows the source instance to burst additional CPU
 	// beyond its available [CPU
@@ -233,6 +301,13 @@ type RunConfig struct {
 	// By default the supported burstable instance types (including t3/t3a/t4g) will be provisioned with its cpu credits set to standard,
 	// only when `enable_unlimited_credits` is true will the instance be provisioned with unlimited cpu credits.
 	EnableUnlimitedCredits bool `mapstructure:"enable_unlimited_credits" required:"false"`
+	// The credit option for CPU usage of burstable instance types, either
+	// `standard` or `unlimited`. Setting `unlimited` is the same as setting
+	// `enable_unlimited_credits`. Burstable instances default to `standard`
+	// already, setting it explicitly makes Packer fail to validate if the
+	// instance type isn't a burstable one, or if `enable_unlimited_credits` is
+	// also set.
+	CPUCredits string `mapstructure:"cpu_credits" required:"false"`
 	// The name of an [IAM instance
 	// profile](https://docs.aws.amazon.com/IAM/latest/UserGuide/instance-profiles.html)
 	// to launch the EC2 instance with.
@@ -246,6 +321,18 @@ type RunConfig struct {
 	FleetTag config.KeyValues `mapstructure:"fleet_tag" required:"false"`
 	// Whether or not to check if the IAM instance profile exists. Defaults to false
 	SkipProfileValidation bool `mapstructure:"skip_profile_validation" required:"false"`
+	// If true, Packer simulates the IAM policies of the build credentials
+	// before starting the build, and errors with every action of the build
+	// they aren't allowed to call, e.g. `ec2:RunInstances` or
+	// `ec2:CreateImage`. EC2 actions are simulated on any resource of the
+	// region of the build: the ones not allowed there are only warned about,
+	// unless a policy explicitly denies them, as policies may only allow them on
+	// some resources. Actions whose decision depends on a condition key that can't
+	// be simulated, like the tags of a resource, are only warned about, as are
+	// federated users, whose policies can't be simulated. The credentials need
+	// `sts:GetCallerIdentity`, `iam:SimulatePrincipalPolicy` and, for an
+	// assumed role, `iam:GetRole`. Defaults to false.
+	CheckIAMPermissions bool `mapstructure:"check_iam_permissions" required:"false"`
 	// Temporary IAM instance profile policy document
 	// If IamInstanceProfile is specified it will be used instead.
 	//
@@ -328,6 +415,14 @@ type RunConfig struct {
 	// [`dynamic_block`](/packer/docs/templates/hcl_templates/expressions#dynamic-blocks)
 	// will allow you to create those programatically.
 	RunTag config.KeyValues `mapstructure:"run_tag" required:"false"`
+	// If true, the resources that only exist for the time of the build, the
+	// instance, its volumes, and the temporary key pair, security group and
+	// IAM instance profile, are tagged with `packer:run-uuid`, the UUID of
+	// the Packer run, along with `run_tags`. It lets external tooling find
+	// the resources leaked by a crashed build and delete them. The AMI,
+	// snapshots and volumes kept by the build aren't tagged with it.
+	// Defaults to false.
+	TagEphemeralWithRunUUID bool `mapstructure:"tag_ephemeral_with_run_uuid" required:"false"`
 	// The ID (not the name) of the security
 	// group to assign to the instance. By default this is not set and Packer will
 	// automatically create a new temporary security group to allow SSH access.
@@ -403,6 +498,52 @@ type RunConfig struct {
 	//   criteria provided in `source_ami_filter`; this pins the AMI returned by the
 	//   filter, but will cause Packer to fail if the `source_ami` does not exist.
 	SourceAmiFilter AmiFilterOptions `mapstructure:"source_ami_filter" required:"false"`
+	// Several groups of filters used to populate the `source_ami` field, when
+	// a single `source_ami_filter` can't express the selection criteria. The
+	// images matched by each `filter_group` are combined according to
+	// `operator`, then exactly one image must remain unless `most_recent` is
+	// set. Cannot be used with `source_ami_filter`.
+	//
+	// HCL2 example:
+	// ```hcl
+	// source "amazon-ebs" "basic-example" {
+	//   source_ami_filters {
+	//     operator = "or"
+	//     filter_group {
+	//       filters = {
+	//         name = "ubuntu/images/*ubuntu-jammy-22.04-amd64-server-*"
+	//       }
+	//       owners = ["099720109477"]
+	//     }
+	//     filter_group {
+	//       filters = {
+	//         name = "ubuntu/images/*ubuntu-noble-24.04-amd64-server-*"
+	//       }
+	//       owners = ["099720109477"]
+	//     }
+	//     most_recent = true
+	//   }
+	// }
+	// ```
+	//
+	//   -   `operator` (string) - `and` keeps the images matched by every
+	//       group, `or` keeps the images matched by any group. Defaults to `or`.
+	//
+	//   -   `filter_group` (block) - A group of filters, taking the same
+	//       `filters`, `owners` and `include_deprecated` options as
+	//       `source_ami_filter`. `owners` is required. At least one group must
+	//       be set.
+	//
+	//   -   `most_recent` (boolean) - Selects the newest created image out of the
+	//       combined result when true.
+	SourceAmiFilters AmiFilterGroupOptions `mapstructure:"source_ami_filters" required:"false"`
+	// A list of AWS account IDs allowed to own the source AMI. The owner
+	// aliases `amazon` and `aws-marketplace` are also accepted, and `self` for
+	// the account of the build. If the resolved source AMI is owned by anyone
+	// else, the build fails. This guards against building from an untrusted
+	// public AMI that happens to match `source_ami_filter`. By default any
+	// owner is allowed.
+	AllowedSourceAmiOwners []string `mapstructure:"allowed_source_ami_owners" required:"false"`
 	// One of  `price-capacity-optimized`, `capacity-optimized`, `diversified` or `lowest-price`.
 	// The strategy that determines how to allocate the target Spot Instance capacity
 	// across the Spot Instance pools specified by the EC2 Fleet launch configuration.
@@ -441,6 +582,19 @@ type RunConfig struct {
 	// Windows, Linux/UNIX (Amazon VPC), SUSE Linux (Amazon VPC),
 	// Windows (Amazon VPC)
 	SpotPriceAutoProduct string `mapstructure:"spot_price_auto_product" required:"false" undocumented:"true"`
+	// Requires spot_price to be set. The maximum amount of time to wait for
+	// the spot request to be fulfilled while spot capacity is unavailable.
+	// On timeout, the build fails with the status code and message reported
+	// for the request, such as `InsufficientInstanceCapacity`. The value of
+	// this should be a duration, for example `10m`. If not set, Packer gives
+	// up after a fixed number of retries.
+	SpotRequestTimeout time.Duration `mapstructure:"spot_request_timeout" required:"false"`
+	// Requires spot_price to be set. Before requesting the spot instance, log
+	// the current spot prices of the requested instance types in the
+	// availability zone of the build, or in every availability zone of the
+	// region if none is selected. This helps choosing which instance types
+	// to request. Defaults to `false`.
+	LogSpotPrices bool `mapstructure:"log_spot_prices" required:"false"`
 	// Requires spot_price to be set. Key/value pair tags to apply tags to the
 	// spot request that is issued.
 	SpotTags map[string]string `mapstructure:"spot_tags" required:"false"`
@@ -504,6 +658,14 @@ type RunConfig struct {
 	// subnet-12345def, where Packer will launch the EC2 instance. This field is
 	// required if you are using an non-default VPC.
 	SubnetId string `mapstructure:"subnet_id" required:"false"`
+	// The number of times to launch the source instance in another
+	// availability zone when the one of the subnet lacks capacity for
+	// `instance_type`. The other zones are those of the subnets matching
+	// `subnet_filter`, with `most_free` or `random` set, tried from the subnet
+	// with the most free addresses. Defaults to `0`, failing the build on the
+	// first `InsufficientInstanceCapacity` error. Spot instances aren't
+	// retried, as the spot fleet already picks the zone.
+	InsufficientCapacityRetries int `mapstructure:"insufficient_capacity_retries" required:"false"`
 	// The license configurations.
 	//
 	// HCL2 example:
@@ -594,6 +756,16 @@ type RunConfig struct {
 	// Path to a file that will be used for the user
 	// data when launching the instance.
 	UserDataFile string `mapstructure:"user_data_file" required:"false"`
+	// URL of an S3 object, formatted as `s3://bucket/key`, that will be
+	// used for the user data when launching the instance. The object is read
+	// at build time, through the region of the build. Requires
+	// `s3:GetObject` on the object.
+	UserDataS3Url string `mapstructure:"user_data_s3_url" required:"false"`
+	// Name or ARN of an SSM parameter whose value will be used for the user
+	// data when launching the instance. The parameter is read at build time,
+	// and `SecureString` parameters are decrypted. Requires
+	// `ssm:GetParameter` on the parameter.
+	UserDataSSMParameter string `mapstructure:"user_data_ssm_parameter" required:"false"`
 	// Filters used to populate the `vpc_id` field.
 	//
 	// HCL2 example:
@@ -642,6 +814,12 @@ type RunConfig struct {
 	// subnet_id to be set. If this field is left blank, Packer will try to get
 	// the VPC ID from the subnet_id.
 	VpcId string `mapstructure:"vpc_id" required:"false"`
+	// If true, Packer runs `cloud-init status --wait` on the instance once
+	// provisioning is done, and fails the build if cloud-init reports an
+	// error. This makes sure the image isn't taken while cloud-init is still
+	// configuring the instance. It is skipped for Windows source AMIs, and
+	// requires a communicator. Defaults to false.
+	WaitForCloudInit bool `mapstructure:"wait_for_cloud_init" required:"false"`
 	// The timeout for waiting for a Windows
 	// password for Windows instances. Defaults to 20 minutes. Example value:
 	// 10m
@@ -690,6 +868,15 @@ type RunConfig struct {
 	// left blank, Packer will choose a port for you from available ports.
 	// This option is only used when `ssh_interface` is set `session_manager`.
 	SessionManagerPort int `mapstructure:"session_manager_port"`
+
+	// A range of ports, formatted as `min-max`, in which Packer picks an
+	// available port for the local end of the session tunnel, e.g.
+	// `8000-8100`. This avoids conflicts on hosts running several builds at
+	// once. Can't be used with `session_manager_port`.
+	// This option is only used when `ssh_interface` is set `session_manager`.
+	SessionManagerPortRange string `mapstructure:"session_manager_port_range"`
+
+	runUUID string
 }
 
 func (c *RunConfig) Prepare(ctx *interpolate.Context) []error {
@@ -717,6 +904,14 @@ func (c *RunConfig) Prepare(ctx *interpolate.Context) []error {
 		c.RunTags = make(map[string]string)
 	}
 
+	if c.TagEphemeralWithRunUUID {
+		// Packer core sets the UUID of the run for the plugins.
+		c.runUUID = os.Getenv("PACKER_RUN_UUID")
+		if c.runUUID == "" {
+			c.runUUID = uuid.TimeOrderedUUID()
+		}
+	}
+
 	// EnableT2Unlimited has been deprecated so we preserve any config settings.
 	if c.EnableT2Unlimited && !c.EnableUnlimitedCredits {
 		c.EnableUnlimitedCredits = c.EnableT2Unlimited
@@ -803,6 +998,15 @@ func (c *RunConfig) Prepare(ctx *interpolate.Context) []error {
 		}
 	}
 
+	if c.SessionManagerPortRange != "" {
+		if c.SessionManagerPort != 0 {
+			errs = append(errs, fmt.Errorf("Only one of session_manager_port or session_manager_port_range can be specified"))
+		}
+		if _, _, err := parsePortRange(c.SessionManagerPortRange); err != nil {
+			errs = append(errs, fmt.Errorf("Invalid session_manager_port_range: %s", err))
+		}
+	}
+
 	if c.Comm.SSHKeyPairName != "" {
 		if c.Comm.Type == "winrm" && c.Comm.WinRMPassword == "" && c.Comm.SSHPrivateKeyFile == "" {
 			errs = append(errs, fmt.Errorf("ssh_private_key_file must be provided to retrieve the winrm password when using ssh_keypair_name."))
@@ -811,12 +1015,19 @@ func (c *RunConfig) Prepare(ctx *interpolate.Context) []error {
 		}
 	}
 
-	if c.SourceAmi == "" && c.SourceAmiFilter.Empty() {
-		errs = append(errs, fmt.Errorf("A source_ami or source_ami_filter must be specified"))
-	}
+	if !c.SourceAmiFilters.Empty() {
+		if !c.SourceAmiFilter.Empty() {
+			errs = append(errs, fmt.Errorf("Only one of source_ami_filter or source_ami_filters can be specified"))
+		}
+		errs = append(errs, c.SourceAmiFilters.Prepare()...)
+	} else {
+		if c.SourceAmi == "" && c.SourceAmiFilter.Empty() {
+			errs = append(errs, fmt.Errorf("A source_ami, source_ami_filter or source_ami_filters must be specified"))
+		}
 
-	if c.SourceAmi == "" && c.SourceAmiFilter.NoOwner() {
-		errs = append(errs, fmt.Errorf("For security reasons, your source AMI filter must declare an owner."))
+		if c.SourceAmi == "" && c.SourceAmiFilter.NoOwner() {
+			errs = append(errs, fmt.Errorf("For security reasons, your source AMI filter must declare an owner."))
+		}
 	}
 
 	if c.InstanceType == "" && len(c.SpotInstanceTypes) == 0 {
@@ -848,18 +1059,87 @@ func (c *RunConfig) Prepare(ctx *interpolate.Context) []error {
 		}
 	}
 
+	if c.SpotRequestTimeout != 0 {
+		if c.SpotPrice == "" || c.SpotPrice == "0" {
+			errs = append(errs, fmt.Errorf(
+				"spot_request_timeout should not be set when not requesting a spot instance"))
+		}
+		if c.SpotRequestTimeout < 0 {
+			errs = append(errs, fmt.Errorf(
+				"spot_request_timeout must be a positive duration"))
+		}
+	}
+
+	if c.LogSpotPrices {
+		if c.SpotPrice == "" || c.SpotPrice == "0" {
+			errs = append(errs, fmt.Errorf(
+				"log_spot_prices should not be set when not requesting a spot instance"))
+		}
+	}
+
 	if c.SpotAllocationStrategy != "" && !slices.Contains(ec2.SpotAllocationStrategy_Values(),
 		c.SpotAllocationStrategy) {
 		errs = append(errs, fmt.Errorf(
 			"Unknown spot_allocation_strategy: %s", c.SpotAllocationStrategy))
 	}
 
-	if c.UserData != "" && c.UserDataFile != "" {
-		errs = append(errs, fmt.Errorf("Only one of user_data or user_data_file can be specified."))
+	userDataSources := 0
+	for _, source := range []string{c.UserData, c.UserDataFile, c.UserDataS3Url, c.UserDataSSMParameter} {
+		if source != "" {
+			userDataSources++
+		}
+	}
+	if userDataSources > 1 {
+		errs = append(errs, fmt.Errorf("Only one of user_data, user_data_file, user_data_s3_url or user_data_ssm_parameter can be specified."))
 	} else if c.UserDataFile != "" {
 		if _, err := os.Stat(c.UserDataFile); err != nil {
 			errs = append(errs, fmt.Errorf("user_data_file not found: %s", c.UserDataFile))
 		}
+	} else if c.UserDataS3Url != "" {
+		if _, _, err := parseS3Url(c.UserDataS3Url); err != nil {
+			errs = append(errs, fmt.Errorf("Invalid user_data_s3_url: %s", err))
+		}
+	}
+
+	if c.AssociateEIPAllocationId != "" {
+		if !reEIPAllocationId.MatchString(c.AssociateEIPAllocationId) {
+			errs = append(errs, fmt.Errorf("associate_eip_allocation_id %q is not a valid allocation ID, "+
+				"expected something like eipalloc-0123456789abcdef0", c.AssociateEIPAllocationId))
+		}
+		if c.IsSpotInstance() {
+			errs = append(errs, fmt.Errorf("associate_eip_allocation_id can't be used with spot instances"))
+		}
+	}
+
+	if c.InsufficientCapacityRetries < 0 {
+		errs = append(errs, fmt.Errorf("insufficient_capacity_retries must be positive"))
+	}
+
+	if reLocalZone.MatchString(c.AvailabilityZone) || reWavelengthZone.MatchString(c.AvailabilityZone) {
+		if c.SubnetId == "" && c.SubnetFilter.Empty() {
+			errs = append(errs, fmt.Errorf("availability_zone %s is a Local or Wavelength Zone, which has "+
+				"no default subnet: subnet_id or subnet_filter must be set", c.AvailabilityZone))
+		}
+		if reWavelengthZone.MatchString(c.AvailabilityZone) && c.AssociatePublicIpAddress.True() {
+			errs = append(errs, fmt.Errorf("associate_public_ip_address can't be used in Wavelength Zone %s",
+				c.AvailabilityZone))
+		}
+	}
+
+	switch c.PrivateDnsNameOptions.HostnameType {
+	case "", ec2.HostnameTypeIpName, ec2.HostnameTypeResourceName:
+	default:
+		errs = append(errs, fmt.Errorf("private_dns_name_options.hostname_type must be one of %s",
+			strings.Join(ec2.HostnameType_Values(), ", ")))
+	}
+
+	if c.EnablePrimaryIpv6 && c.SubnetId == "" && c.SubnetFilter.Empty() {
+		errs = append(errs, fmt.Errorf("enable_primary_ipv6 requires subnet_id or subnet_filter, "+
+			"to pick a subnet that supports IPv6"))
+	}
+
+	if c.WaitForCloudInit && c.Comm.Type == "none" {
+		errs = append(errs, fmt.Errorf("wait_for_cloud_init requires a communicator"))
 	}
 
 	if c.SecurityGroupId != "" {
@@ -891,6 +1171,23 @@ func (c *RunConfig) Prepare(ctx *interpolate.Context) []error {
 		errs = append(errs, fmt.Errorf("shutdown_behavior only accepts 'stop' or 'terminate' values."))
 	}
 
+	switch c.CPUCredits {
+	case "":
+	case CPUCreditsStandard, CPUCreditsUnlimited:
+		if c.CPUCredits == CPUCreditsStandard && c.EnableUnlimitedCredits {
+			errs = append(errs, fmt.Errorf("cpu_credits %q conflicts with enable_unlimited_credits", c.CPUCredits))
+		}
+		if c.CPUCredits == CPUCreditsUnlimited {
+			c.EnableUnlimitedCredits = true
+		}
+		// Unlimited credits are validated along with enable_unlimited_credits.
+		if !c.IsBurstableInstanceType() && !c.EnableUnlimitedCredits {
+			errs = append(errs, fmt.Errorf("Error: Instance Type: %s is not a burstable instance type, cpu_credits can't be set. Supported instance types are T2, T3, and T4g", c.InstanceType))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("cpu_credits must be %q or %q", CPUCreditsStandard, CPUCreditsUnlimited))
+	}
+
 	if c.EnableUnlimitedCredits {
 		if !c.IsBurstableInstanceType() {
 			errs = append(errs, fmt.Errorf("Error: Instance Type: %s is not within the supported types for Unlimited credits. Supported instance types are T2, T3, and T4g", c.InstanceType))
@@ -944,6 +1241,7 @@ func (c *RunConfig) Prepare(ctx *interpolate.Context) []error {
 	}
 
 	errs = append(errs, c.Placement.Prepare()...)
+	errs = append(errs, c.prepareEbsOptimized()...)
 
 	if c.EnableNitroEnclave {
 		if c.SpotPrice != "" {
@@ -958,6 +1256,21 @@ func (c *RunConfig) Prepare(ctx *interpolate.Context) []error {
 	return errs
 }
 
+// EphemeralTags returns tags, along with the run UUID tag if
+// tag_ephemeral_with_run_uuid is set, for the resources deleted once the
+// build completes.
+func (c *RunConfig) EphemeralTags(tags map[string]string) map[string]string {
+	if c.runUUID == "" {
+		return tags
+	}
+	ephemeral := make(map[string]string, len(tags)+1)
+	for k, v := range tags {
+		ephemeral[k] = v
+	}
+	ephemeral[RunUUIDTagKey] = c.runUUID
+	return ephemeral
+}
+
 func (c *RunConfig) IsSpotInstance() bool {
 	return c.SpotPrice != "" && c.SpotPrice != "0"
 }
@@ -973,3 +1286,15 @@ func (c *RunConfig) IsBurstableInstanceType() bool {
 	r := `^t(:?2|3a?|4g)\.`
 	return regexp.MustCompile(r).MatchString(c.InstanceType)
 }
+
+// creditSpecification returns the credit specification of the source instance,
+// or nil if its instance type doesn't have one.
+func creditSpecification(burstable, unlimited bool) *ec2.CreditSpecificationRequest {
+	switch {
+	case unlimited:
+		return &ec2.CreditSpecificationRequest{CpuCredits: aws.String(CPUCreditsUnlimited)}
+	case burstable:
+		return &ec2.CreditSpecificationRequest{CpuCredits: aws.String(CPUCreditsStandard)}
+	}
+	return nil
+}
@@ -8,6 +8,33 @@ import (
 	"github.com/zclconf/go-cty/cty"
 )
 
+// FlatAmiFilterGroupOptions is an auto-generated flat version of AmiFilterGroupOptions.
+// Where the contents of a field with a `mapstructure:,squash` tag are bubbled up.
+type FlatAmiFilterGroupOptions struct {
+	Operator     *string                `mapstructure:"operator" cty:"operator" hcl:"operator"`
+	FilterGroups []FlatAmiFilterOptions `mapstructure:"filter_group" cty:"filter_group" hcl:"filter_group"`
+	MostRecent   *bool                  `mapstructure:"most_recent" cty:"most_recent" hcl:"most_recent"`
+}
+
+// FlatMapstructure returns a new FlatAmiFilterGroupOptions.
+// FlatAmiFilterGroupOptions is an auto-generated flat version of AmiFilterGroupOptions.
+// Where the contents a fields with a `mapstructure:,squash` tag are bubbled up.
+func (*AmiFilterGroupOptions) FlatMapstructure() interface{ HCL2Spec() map[string]hcldec.Spec } {
+	return new(FlatAmiFilterGroupOptions)
+}
+
+// HCL2Spec returns the hcl spec of a AmiFilterGroupOptions.
+// This spec is used by HCL to read the fields of AmiFilterGroupOptions.
+// The decoded values from this spec will then be applied to a FlatAmiFilterGroupOptions.
+func (*FlatAmiFilterGroupOptions) HCL2Spec() map[string]hcldec.Spec {
+	s := map[string]hcldec.Spec{
+		"operator":     &hcldec.AttrSpec{Name: "operator", Type: cty.String, Required: false},
+		"filter_group": &hcldec.BlockListSpec{TypeName: "filter_group", Nested: hcldec.ObjectSpec((*FlatAmiFilterOptions)(nil).HCL2Spec())},
+		"most_recent":  &hcldec.AttrSpec{Name: "most_recent", Type: cty.Bool, Required: false},
+	}
+	return s
+}
+
 // FlatAmiFilterOptions is an auto-generated flat version of AmiFilterOptions.
 // Where the contents of a field with a `mapstructure:,squash` tag are bubbled up.
 type FlatAmiFilterOptions struct {
@@ -164,6 +191,33 @@ func (*FlatPolicyDocument) HCL2Spec() map[string]hcldec.Spec {
 	return s
 }
 
+// FlatPrivateDnsNameOptions is an auto-generated flat version of PrivateDnsNameOptions.
+// Where the contents of a field with a `mapstructure:,squash` tag are bubbled up.
+type FlatPrivateDnsNameOptions struct {
+	HostnameType                    *string `mapstructure:"hostname_type" required:"false" cty:"hostname_type" hcl:"hostname_type"`
+	EnableResourceNameDnsARecord    *bool   `mapstructure:"enable_resource_name_dns_a_record" required:"false" cty:"enable_resource_name_dns_a_record" hcl:"enable_resource_name_dns_a_record"`
+	EnableResourceNameDnsAAAARecord *bool   `mapstructure:"enable_resource_name_dns_aaaa_record" required:"false" cty:"enable_resource_name_dns_aaaa_record" hcl:"enable_resource_name_dns_aaaa_record"`
+}
+
+// FlatMapstructure returns a new FlatPrivateDnsNameOptions.
+// FlatPrivateDnsNameOptions is an auto-generated flat version of PrivateDnsNameOptions.
+// Where the contents a fields with a `mapstructure:,squash` tag are bubbled up.
+func (*PrivateDnsNameOptions) FlatMapstructure() interface{ HCL2Spec() map[string]hcldec.Spec } {
+	return new(FlatPrivateDnsNameOptions)
+}
+
+// HCL2Spec returns the hcl spec of a PrivateDnsNameOptions.
+// This spec is used by HCL to read the fields of PrivateDnsNameOptions.
+// The decoded values from this spec will then be applied to a FlatPrivateDnsNameOptions.
+func (*FlatPrivateDnsNameOptions) HCL2Spec() map[string]hcldec.Spec {
+	s := map[string]hcldec.Spec{
+		"hostname_type":                        &hcldec.AttrSpec{Name: "hostname_type", Type: cty.String, Required: false},
+		"enable_resource_name_dns_a_record":    &hcldec.AttrSpec{Name: "enable_resource_name_dns_a_record", Type: cty.Bool, Required: false},
+		"enable_resource_name_dns_aaaa_record": &hcldec.AttrSpec{Name: "enable_resource_name_dns_aaaa_record", Type: cty.Bool, Required: false},
+	}
+	return s
+}
+
 // FlatSecurityGroupFilterOptions is an auto-generated flat version of SecurityGroupFilterOptions.
 // Where the contents of a field with a `mapstructure:,squash` tag are bubbled up.
 type FlatSecurityGroupFilterOptions struct {
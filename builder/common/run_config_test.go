@@ -4,12 +4,21 @@
 package common
 
 import (
+	"context"
+	"errors"
 	"io/ioutil"
 	"os"
 	"regexp"
 	"testing"
 
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/iam"
 	"github.com/hashicorp/packer-plugin-sdk/communicator"
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
+	"github.com/hashicorp/packer-plugin-sdk/template/config"
 )
 
 func init() {
@@ -97,6 +106,102 @@ func TestRunConfigPrepare_SourceAmiFilterGood(t *testing.T) {
 	}
 }
 
+func TestRunConfigPrepare_SourceAmiFilters(t *testing.T) {
+	group := AmiFilterOptions{
+		Owners:  []string{"123"},
+		Filters: map[string]string{"name": "foo"},
+	}
+
+	tests := []struct {
+		name       string
+		filters    AmiFilterGroupOptions
+		filter     AmiFilterOptions
+		errorCount int
+	}{
+		{
+			name:       "good",
+			filters:    AmiFilterGroupOptions{Operator: "and", FilterGroups: []AmiFilterOptions{group, group}},
+			errorCount: 0,
+		},
+		{
+			name:       "no filter group",
+			filters:    AmiFilterGroupOptions{Operator: "or"},
+			errorCount: 1,
+		},
+		{
+			name:       "bad operator",
+			filters:    AmiFilterGroupOptions{Operator: "xor", FilterGroups: []AmiFilterOptions{group}},
+			errorCount: 1,
+		},
+		{
+			name: "group without owner",
+			filters: AmiFilterGroupOptions{FilterGroups: []AmiFilterOptions{
+				{Filters: map[string]string{"name": "foo"}},
+			}},
+			errorCount: 1,
+		},
+		{
+			name: "group with most_recent",
+			filters: AmiFilterGroupOptions{FilterGroups: []AmiFilterOptions{
+				{Owners: []string{"123"}, Filters: map[string]string{"name": "foo"}, MostRecent: true},
+			}},
+			errorCount: 1,
+		},
+		{
+			name:       "with source_ami_filter",
+			filters:    AmiFilterGroupOptions{FilterGroups: []AmiFilterOptions{group}},
+			filter:     group,
+			errorCount: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := testConfigFilter()
+			c.SourceAmiFilters = tt.filters
+			c.SourceAmiFilter = tt.filter
+			if errs := c.Prepare(nil); len(errs) != tt.errorCount {
+				t.Fatalf("expected %d errors, got %d: %v", tt.errorCount, len(errs), errs)
+			}
+		})
+	}
+
+	c := testConfigFilter()
+	c.SourceAmiFilters = AmiFilterGroupOptions{FilterGroups: []AmiFilterOptions{group}}
+	c.Prepare(nil)
+	if c.SourceAmiFilters.Operator != AmiFilterOperatorOr {
+		t.Fatalf("operator should default to %q, got %q", AmiFilterOperatorOr, c.SourceAmiFilters.Operator)
+	}
+}
+
+func TestRunConfigPrepare_SessionManagerPortRange(t *testing.T) {
+	tests := []struct {
+		name       string
+		portRange  string
+		port       int
+		errorCount int
+	}{
+		{name: "good", portRange: "8000-8100", errorCount: 0},
+		{name: "single port", portRange: "8000-8000", errorCount: 0},
+		{name: "not a range", portRange: "8000", errorCount: 1},
+		{name: "not a number", portRange: "8000-foo", errorCount: 1},
+		{name: "reversed", portRange: "8100-8000", errorCount: 1},
+		{name: "out of bounds", portRange: "0-70000", errorCount: 1},
+		{name: "with session_manager_port", portRange: "8000-8100", port: 8000, errorCount: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := testConfig()
+			c.SessionManagerPortRange = tt.portRange
+			c.SessionManagerPort = tt.port
+			if errs := c.Prepare(nil); len(errs) != tt.errorCount {
+				t.Fatalf("expected %d errors, got %d: %v", tt.errorCount, len(errs), errs)
+			}
+		})
+	}
+}
+
 func TestRunConfigPrepare_EnableT2UnlimitedGood(t *testing.T) {
 	c := testConfig()
 	// Must have a T2 instance type if T2 Unlimited is enabled
@@ -179,6 +284,45 @@ func TestRunConfigPrepare_EnableUnlimitedCredits(t *testing.T) {
 	}
 }
 
+func TestRunConfigPrepare_CPUCredits(t *testing.T) {
+	tc := []struct {
+		name          string
+		instanceType  string
+		cpuCredits    string
+		enableCredits bool
+		errorCount    int
+		credits       string
+	}{
+		{"T3 standard", "t3.micro", "standard", false, 0, "standard"},
+		{"T3 unlimited", "t3.micro", "unlimited", false, 0, "unlimited"},
+		{"T3 unlimited and enable_unlimited_credits", "t3.micro", "unlimited", true, 0, "unlimited"},
+		{"T3 standard and enable_unlimited_credits", "t3.micro", "standard", true, 1, "unlimited"},
+		{"M5 standard", "m5.large", "standard", false, 1, ""},
+		{"M5 unlimited", "m5.large", "unlimited", false, 1, "unlimited"},
+		{"T3 invalid", "t3.micro", "burst", false, 1, "standard"},
+	}
+
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			c := testConfig()
+			c.InstanceType = tt.instanceType
+			c.CPUCredits = tt.cpuCredits
+			c.EnableUnlimitedCredits = tt.enableCredits
+			if errs := c.Prepare(nil); len(errs) != tt.errorCount {
+				t.Fatalf("expected %d errors, got %d: %v", tt.errorCount, len(errs), errs)
+			}
+
+			var credits string
+			if spec := creditSpecification(c.IsBurstableInstanceType(), c.EnableUnlimitedCredits); spec != nil {
+				credits = aws.StringValue(spec.CpuCredits)
+			}
+			if credits != tt.credits {
+				t.Fatalf("expected %q cpu credits, got %q", tt.credits, credits)
+			}
+		})
+	}
+}
+
 func TestRunConfigPrepare_SpotAuto(t *testing.T) {
 	c := testConfig()
 	c.SpotPrice = "auto"
@@ -254,6 +398,90 @@ func TestRunConfigPrepare_UserDataFile(t *testing.T) {
 	}
 }
 
+func TestRunConfigPrepare_UserDataRemoteSources(t *testing.T) {
+	tests := []struct {
+		name       string
+		userData   string
+		s3Url      string
+		parameter  string
+		errorCount int
+	}{
+		{name: "s3 url", s3Url: "s3://bucket/path/to/user-data.sh", errorCount: 0},
+		{name: "ssm parameter", parameter: "/bootstrap/user-data", errorCount: 0},
+		{name: "s3 url without key", s3Url: "s3://bucket", errorCount: 1},
+		{name: "https url", s3Url: "https://bucket.s3.amazonaws.com/user-data.sh", errorCount: 1},
+		{name: "s3 url and ssm parameter", s3Url: "s3://bucket/user-data.sh", parameter: "/bootstrap/user-data", errorCount: 1},
+		{name: "user_data and ssm parameter", userData: "foo", parameter: "/bootstrap/user-data", errorCount: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := testConfig()
+			c.UserData = tt.userData
+			c.UserDataS3Url = tt.s3Url
+			c.UserDataSSMParameter = tt.parameter
+			if errs := c.Prepare(nil); len(errs) != tt.errorCount {
+				t.Fatalf("expected %d errors, got %d: %v", tt.errorCount, len(errs), errs)
+			}
+		})
+	}
+}
+
+func TestRunConfigPrepare_AssociateEIPAllocationId(t *testing.T) {
+	tests := []struct {
+		name         string
+		allocationId string
+		spotPrice    string
+		errorCount   int
+	}{
+		{name: "short id", allocationId: "eipalloc-12345678", errorCount: 0},
+		{name: "long id", allocationId: "eipalloc-0123456789abcdef0", errorCount: 0},
+		{name: "public ip", allocationId: "203.0.113.10", errorCount: 1},
+		{name: "association id", allocationId: "eipassoc-0123456789abcdef0", errorCount: 1},
+		{name: "spot instance", allocationId: "eipalloc-0123456789abcdef0", spotPrice: "auto", errorCount: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := testConfig()
+			c.AssociateEIPAllocationId = tt.allocationId
+			c.SpotPrice = tt.spotPrice
+			if errs := c.Prepare(nil); len(errs) != tt.errorCount {
+				t.Fatalf("expected %d errors, got %d: %v", tt.errorCount, len(errs), errs)
+			}
+		})
+	}
+}
+
+func TestRunConfigPrepare_LocalZone(t *testing.T) {
+	tests := []struct {
+		name             string
+		availabilityZone string
+		subnetId         string
+		publicIp         config.Trilean
+		errorCount       int
+	}{
+		{name: "availability zone", availabilityZone: "us-west-2a", errorCount: 0},
+		{name: "local zone", availabilityZone: "us-west-2-lax-1a", subnetId: "subnet-12345678", errorCount: 0},
+		{name: "local zone without subnet", availabilityZone: "us-west-2-lax-1a", errorCount: 1},
+		{name: "wavelength zone", availabilityZone: "us-east-1-wl1-bos-wlz-1", subnetId: "subnet-12345678", errorCount: 0},
+		{name: "wavelength zone without subnet", availabilityZone: "us-east-1-wl1-bos-wlz-1", errorCount: 1},
+		{name: "wavelength zone with public ip", availabilityZone: "us-east-1-wl1-bos-wlz-1", subnetId: "subnet-12345678", publicIp: config.TriTrue, errorCount: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := testConfig()
+			c.AvailabilityZone = tt.availabilityZone
+			c.SubnetId = tt.subnetId
+			c.AssociatePublicIpAddress = tt.publicIp
+			if errs := c.Prepare(nil); len(errs) != tt.errorCount {
+				t.Fatalf("expected %d errors, got %d: %v", tt.errorCount, len(errs), errs)
+			}
+		})
+	}
+}
+
 func TestRunConfigPrepare_TemporaryKeyPairName(t *testing.T) {
 	c := testConfig()
 	c.Comm.SSHTemporaryKeyPairName = ""
@@ -612,3 +840,190 @@ func TestRunConfigPrepare_SSHInterfaceIPv6_DefaultCIDR(t *testing.T) {
 		t.Errorf("expected default CIDR to be '::/0', got: %s", c.TemporarySGSourceCidrs[0])
 	}
 }
+
+func TestRunConfigPrepare_EbsOptimized(t *testing.T) {
+	cases := []struct {
+		name              string
+		instanceType      string
+		spotInstanceTypes []string
+		ebsOptimized      bool
+		wantErr           bool
+		wantWarn          bool
+	}{
+		{name: "optional instance type", instanceType: "m3.xlarge", ebsOptimized: true},
+		{name: "unsupported size of an optional family", instanceType: "m3.medium", ebsOptimized: true, wantErr: true},
+		{name: "unsupported large size of an optional family", instanceType: "c3.large", ebsOptimized: true, wantErr: true},
+		{name: "default family", instanceType: "m5.large", ebsOptimized: true, wantWarn: true},
+		{name: "default family with suffix", instanceType: "c6gn.xlarge", ebsOptimized: true, wantWarn: true},
+		{name: "default burstable family", instanceType: "t3.micro", ebsOptimized: true, wantWarn: true},
+		{name: "unsupported family", instanceType: "t2.micro", ebsOptimized: true, wantErr: true},
+		{name: "unsupported family not optimized", instanceType: "t2.micro"},
+		{name: "default family not optimized", instanceType: "m5.large"},
+		{name: "unknown family", instanceType: "zz9.large", ebsOptimized: true},
+		{name: "spot default families", spotInstanceTypes: []string{"m5.large", "c5.large"}, ebsOptimized: true, wantWarn: true},
+		{name: "spot mixed families", spotInstanceTypes: []string{"m5.large", "m3.xlarge"}, ebsOptimized: true},
+		{name: "spot unsupported family", spotInstanceTypes: []string{"m5.large", "t2.large"}, ebsOptimized: true, wantErr: true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := testConfig()
+			c.InstanceType = tc.instanceType
+			c.SpotInstanceTypes = tc.spotInstanceTypes
+			if len(tc.spotInstanceTypes) > 0 {
+				c.SpotPrice = "auto"
+			}
+			c.EbsOptimized = tc.ebsOptimized
+
+			errs := c.Prepare(nil)
+			if tc.wantErr != (len(errs) > 0) {
+				t.Fatalf("expected error: %t, got %v", tc.wantErr, errs)
+			}
+			if warn := c.EbsOptimizedWarning(); tc.wantWarn != (warn != "") {
+				t.Fatalf("expected warning: %t, got %q", tc.wantWarn, warn)
+			}
+		})
+	}
+}
+
+func TestRunConfigPrepare_Ipv6Options(t *testing.T) {
+	cases := []struct {
+		name         string
+		primaryIpv6  bool
+		subnetId     string
+		hostnameType string
+		wantErr      bool
+	}{
+		{name: "primary ipv6 with subnet", primaryIpv6: true, subnetId: "subnet-12345678"},
+		{name: "primary ipv6 without subnet", primaryIpv6: true, wantErr: true},
+		{name: "resource name hostname", hostnameType: "resource-name"},
+		{name: "ip name hostname", hostnameType: "ip-name"},
+		{name: "bad hostname type", hostnameType: "dns-name", wantErr: true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := testConfig()
+			c.EnablePrimaryIpv6 = tc.primaryIpv6
+			c.SubnetId = tc.subnetId
+			c.PrivateDnsNameOptions.HostnameType = tc.hostnameType
+
+			errs := c.Prepare(nil)
+			if tc.wantErr != (len(errs) > 0) {
+				t.Fatalf("expected error: %t, got %v", tc.wantErr, errs)
+			}
+		})
+	}
+}
+
+func TestRunConfig_EphemeralTags(t *testing.T) {
+	c := testConfig()
+	c.RunTags = map[string]string{"Name": "packer"}
+	if err := c.Prepare(nil); len(err) != 0 {
+		t.Fatalf("err: %s", err)
+	}
+	if tags := c.EphemeralTags(c.RunTags); len(tags) != 1 {
+		t.Fatalf("no run UUID tag should be added by default, got %v", tags)
+	}
+
+	t.Setenv("PACKER_RUN_UUID", "run-1234")
+	c = testConfig()
+	c.RunTags = map[string]string{"Name": "packer"}
+	c.TagEphemeralWithRunUUID = true
+	if err := c.Prepare(nil); len(err) != 0 {
+		t.Fatalf("err: %s", err)
+	}
+	tags := c.EphemeralTags(c.RunTags)
+	if tags["Name"] != "packer" || tags[RunUUIDTagKey] != "run-1234" {
+		t.Fatalf("expected the run tags and the run UUID tag, got %v", tags)
+	}
+	if _, ok := c.RunTags[RunUUIDTagKey]; ok {
+		t.Fatal("run_tags should not be modified, they also tag the AMI")
+	}
+}
+
+func TestRunConfig_TagEphemeralWithRunUUID(t *testing.T) {
+	t.Setenv("PACKER_RUN_UUID", "run-1234")
+	c := testConfig()
+	c.TagEphemeralWithRunUUID = true
+	if err := c.Prepare(nil); len(err) != 0 {
+		t.Fatalf("err: %s", err)
+	}
+
+	// Each step stops once it has requested its resource, whose run UUID
+	// tag is recorded by resource type.
+	runUUIDs := make(map[string]string)
+	recordTags := func(specs []*ec2.TagSpecification) {
+		for _, spec := range specs {
+			for _, tag := range spec.Tags {
+				if aws.StringValue(tag.Key) == RunUUIDTagKey {
+					runUUIDs[aws.StringValue(spec.ResourceType)] = aws.StringValue(tag.Value)
+				}
+			}
+		}
+	}
+	errStop := errors.New("resource requested")
+
+	ec2conn := FakeEC2Conn(func(r *request.Request) {
+		switch in := r.Params.(type) {
+		case *ec2.CreateKeyPairInput:
+			recordTags(in.TagSpecifications)
+			r.Data.(*ec2.CreateKeyPairOutput).KeyMaterial = aws.String("key")
+			return
+		case *ec2.CreateSecurityGroupInput:
+			recordTags(in.TagSpecifications)
+		case *ec2.RunInstancesInput:
+			recordTags(in.TagSpecifications)
+		}
+		r.Error = errStop
+	})
+
+	iamconn := iam.New(FakeSession())
+	FakeClient(iamconn.Client, func(r *request.Request) {
+		if in, ok := r.Params.(*iam.CreateInstanceProfileInput); ok {
+			for _, tag := range in.Tags {
+				if aws.StringValue(tag.Key) == RunUUIDTagKey {
+					runUUIDs["instance-profile"] = aws.StringValue(tag.Value)
+				}
+			}
+		}
+		r.Error = errStop
+	})
+
+	state := new(multistep.BasicStateBag)
+	state.Put("ui", packersdk.TestUi(t))
+	state.Put("ec2", ec2conn)
+	state.Put("iam", iamconn)
+	state.Put("region", aws.String("us-east-1"))
+	state.Put("vpc_id", "")
+	state.Put("subnet_id", "")
+	state.Put("availability_zone", "")
+	state.Put("securityGroupIds", []string{"sg-12345678"})
+	state.Put("source_image", &ec2.Image{ImageId: aws.String("ami-12345678"), RootDeviceType: aws.String("ebs")})
+
+	tags := c.EphemeralTags(c.RunTags)
+	steps := []multistep.Step{
+		&StepKeyPair{Comm: &c.Comm, Tags: tags},
+		&StepSecurityGroup{Tags: tags},
+		&StepIamInstanceProfile{
+			TemporaryIamInstanceProfilePolicyDocument: &PolicyDocument{Version: "2012-10-17"},
+			Tags: tags,
+		},
+		&StepRunSourceInstance{
+			Comm:           &c.Comm,
+			InstanceType:   c.InstanceType,
+			LaunchMappings: BlockDevices{},
+			Tags:           tags,
+			VolumeTags:     c.EphemeralTags(nil),
+		},
+	}
+	for _, step := range steps {
+		step.Run(context.Background(), state)
+	}
+
+	for _, resource := range []string{"key-pair", "security-group", "instance-profile", "instance", "volume", "network-interface"} {
+		if runUUIDs[resource] != "run-1234" {
+			t.Errorf("expected the %s to be tagged with the run UUID, got %q", resource, runUUIDs[resource])
+		}
+	}
+}
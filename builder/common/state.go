@@ -17,6 +17,8 @@ import (
 	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	awscommon "github.com/hashicorp/packer-plugin-amazon/common"
 	"github.com/hashicorp/packer-plugin-sdk/multistep"
 )
 
@@ -76,6 +78,17 @@ type AWSPollingConfig struct {
 	// If both option and environment variable are set, the delay_seconds will be considered over the AWS_POLL_DELAY_SECONDS.
 	// If none is set, defaults to AWS waiter default which is 15 seconds.
 	DelaySeconds int `mapstructure:"delay_seconds" required:"false"`
+	// Specifies the maximum wall-clock time to wait for the resource state, such as `90m`,
+	// whatever the number of attempts. The wait fails with a "polling timed out" error once it is over.
+	// If none is set, the wait is only limited by max_attempts and delay_seconds. Can't be negative.
+	Timeout time.Duration `mapstructure:"timeout" required:"false"`
+	// Specifies the maximum jitter in seconds applied to the delay between attempts.
+	// Each attempt waits for the delay plus or minus a random duration of up to jitter_seconds,
+	// so that concurrent builds don't poll the AWS API in lockstep and get throttled.
+	// This value can also be set via the AWS_POLL_JITTER_SECONDS.
+	// If both option and environment variable are set, the jitter_seconds will be considered over the AWS_POLL_JITTER_SECONDS.
+	// If none is set, defaults to 0, no jitter.
+	JitterSeconds int `mapstructure:"jitter_seconds" required:"false"`
 }
 
 func (w *AWSPollingConfig) WaitUntilAMIAvailable(ctx aws.Context, conn ec2iface.EC2API, imageId string) error {
@@ -83,16 +96,15 @@ func (w *AWSPollingConfig) WaitUntilAMIAvailable(ctx aws.Context, conn ec2iface.
 		ImageIds: []*string{&imageId},
 	}
 	log.Printf("Waiting for AMI (%s) to be available...", imageId)
-	waitOpts := w.getWaiterOptions()
-	if len(waitOpts) == 0 {
-		// Bump this default to 30 minutes because the aws default
-		// of ten minutes doesn't work for some of our long-running copies.
-		waitOpts = append(waitOpts, request.WithWaiterMaxAttempts(120))
-	}
-	err := conn.WaitUntilImageAvailableWithContext(
-		ctx,
-		&imageInput,
-		waitOpts...)
+	// Bump this default to 30 minutes because the aws default
+	// of ten minutes doesn't work for some of our long-running copies.
+	waitOpts := w.getWaiterOptions(request.WithWaiterMaxAttempts(120))
+	err := w.withTimeout(ctx, func(ctx aws.Context) error {
+		return conn.WaitUntilImageAvailableWithContext(
+			ctx,
+			&imageInput,
+			waitOpts...)
+	})
 	if err != nil {
 		if strings.Contains(err.Error(), request.WaiterResourceNotReadyErrorCode) {
 			err = fmt.Errorf("Failed with ResourceNotReady error, which can "+
@@ -112,10 +124,12 @@ func (w *AWSPollingConfig) WaitUntilInstanceRunning(ctx aws.Context, conn ec2ifa
 		InstanceIds: []*string{&instanceId},
 	}
 
-	err := conn.WaitUntilInstanceRunningWithContext(
-		ctx,
-		&instanceInput,
-		w.getWaiterOptions()...)
+	err := w.withTimeout(ctx, func(ctx aws.Context) error {
+		return conn.WaitUntilInstanceRunningWithContext(
+			ctx,
+			&instanceInput,
+			w.getWaiterOptions()...)
+	})
 	return err
 }
 
@@ -124,10 +138,12 @@ func (w *AWSPollingConfig) WaitUntilInstanceTerminated(ctx aws.Context, conn *ec
 		InstanceIds: []*string{&instanceId},
 	}
 
-	err := conn.WaitUntilInstanceTerminatedWithContext(
-		ctx,
-		&instanceInput,
-		w.getWaiterOptions()...)
+	err := w.withTimeout(ctx, func(ctx aws.Context) error {
+		return conn.WaitUntilInstanceTerminatedWithContext(
+			ctx,
+			&instanceInput,
+			w.getWaiterOptions()...)
+	})
 	return err
 }
 
@@ -137,41 +153,69 @@ func (w *AWSPollingConfig) WaitUntilSpotRequestFulfilled(ctx aws.Context, conn *
 		SpotInstanceRequestIds: []*string{&spotRequestId},
 	}
 
-	err := conn.WaitUntilSpotInstanceRequestFulfilledWithContext(
-		ctx,
-		&spotRequestInput,
-		w.getWaiterOptions()...)
+	err := w.withTimeout(ctx, func(ctx aws.Context) error {
+		return conn.WaitUntilSpotInstanceRequestFulfilledWithContext(
+			ctx,
+			&spotRequestInput,
+			w.getWaiterOptions()...)
+	})
 	return err
 }
 
-func (w *AWSPollingConfig) WaitUntilVolumeAvailable(ctx aws.Context, conn *ec2.EC2, volumeId string) error {
+func (w *AWSPollingConfig) WaitUntilVolumeAvailable(ctx aws.Context, conn ec2iface.EC2API, volumeId string) error {
 	volumeInput := ec2.DescribeVolumesInput{
 		VolumeIds: []*string{&volumeId},
 	}
 
-	err := conn.WaitUntilVolumeAvailableWithContext(
-		ctx,
-		&volumeInput,
-		w.getWaiterOptions()...)
+	err := w.withTimeout(ctx, func(ctx aws.Context) error {
+		return conn.WaitUntilVolumeAvailableWithContext(
+			ctx,
+			&volumeInput,
+			w.getWaiterOptions()...)
+	})
 	return err
 }
 
 func (w *AWSPollingConfig) WaitUntilSnapshotDone(ctx aws.Context, conn ec2iface.EC2API, snapshotID string) error {
+	return w.waitUntilSnapshotDone(ctx, conn, snapshotID)
+}
+
+// WaitUntilSnapshotDoneWithProgress waits like WaitUntilSnapshotDone, and
+// calls report with the progress of the snapshot, such as "42%", every time
+// it is polled.
+func (w *AWSPollingConfig) WaitUntilSnapshotDoneWithProgress(ctx aws.Context, conn ec2iface.EC2API, snapshotID string, report func(progress string)) error {
+	return w.waitUntilSnapshotDone(ctx, conn, snapshotID,
+		request.WithWaiterRequestOptions(func(r *request.Request) {
+			r.Handlers.Complete.PushBack(func(r *request.Request) {
+				out, ok := r.Data.(*ec2.DescribeSnapshotsOutput)
+				if r.Error != nil || !ok {
+					return
+				}
+				for _, snapshot := range out.Snapshots {
+					if snapshot.Progress != nil {
+						report(*snapshot.Progress)
+					}
+				}
+			})
+		}))
+}
+
+func (w *AWSPollingConfig) waitUntilSnapshotDone(ctx aws.Context, conn ec2iface.EC2API, snapshotID string, opts ...request.WaiterOption) error {
 	snapInput := ec2.DescribeSnapshotsInput{
 		SnapshotIds: []*string{&snapshotID},
 	}
 
-	waitOpts := w.getWaiterOptions()
-	if len(waitOpts) == 0 {
-		// Bump this default to 30 minutes.
-		// Large snapshots can take a long time for the copy to s3
-		waitOpts = append(waitOpts, request.WithWaiterMaxAttempts(120))
-	}
-
-	err := conn.WaitUntilSnapshotCompletedWithContext(
-		ctx,
-		&snapInput,
-		waitOpts...)
+	// Bump this default to 30 minutes.
+	// Large snapshots can take a long time for the copy to s3
+	waitOpts := w.getWaiterOptions(request.WithWaiterMaxAttempts(120))
+	waitOpts = append(waitOpts, opts...)
+
+	err := w.withTimeout(ctx, func(ctx aws.Context) error {
+		return conn.WaitUntilSnapshotCompletedWithContext(
+			ctx,
+			&snapInput,
+			waitOpts...)
+	})
 	return err
 }
 
@@ -182,10 +226,12 @@ func (w *AWSPollingConfig) WaitUntilVolumeAttached(ctx aws.Context, conn *ec2.EC
 		VolumeIds: []*string{&volumeId},
 	}
 
-	err := WaitForVolumeToBeAttached(conn,
-		ctx,
-		&volumeInput,
-		w.getWaiterOptions()...)
+	err := w.withTimeout(ctx, func(ctx aws.Context) error {
+		return WaitForVolumeToBeAttached(conn,
+			ctx,
+			&volumeInput,
+			w.getWaiterOptions()...)
+	})
 	return err
 }
 
@@ -194,10 +240,12 @@ func (w *AWSPollingConfig) WaitUntilVolumeDetached(ctx aws.Context, conn *ec2.EC
 		VolumeIds: []*string{&volumeId},
 	}
 
-	err := WaitForVolumeToBeDetached(conn,
-		ctx,
-		&volumeInput,
-		w.getWaiterOptions()...)
+	err := w.withTimeout(ctx, func(ctx aws.Context) error {
+		return WaitForVolumeToBeDetached(conn,
+			ctx,
+			&volumeInput,
+			w.getWaiterOptions()...)
+	})
 	return err
 }
 
@@ -206,10 +254,12 @@ func (w *AWSPollingConfig) WaitUntilImageImported(ctx aws.Context, conn *ec2.EC2
 		ImportTaskIds: []*string{&taskID},
 	}
 
-	err := WaitForImageToBeImported(conn,
-		ctx,
-		&importInput,
-		w.getWaiterOptions()...)
+	err := w.withTimeout(ctx, func(ctx aws.Context) error {
+		return WaitForImageToBeImported(conn,
+			ctx,
+			&importInput,
+			w.getWaiterOptions()...)
+	})
 	return err
 }
 
@@ -220,10 +270,31 @@ func (w *AWSPollingConfig) WaitUntilFastLaunchEnabled(ctx aws.Context, conn *ec2
 		},
 	}
 
-	err := WaitUntilFastLaunchEnabled(conn,
-		ctx,
-		fastLaunchDescribeInput,
-		w.getWaiterOptions()...)
+	err := w.withTimeout(ctx, func(ctx aws.Context) error {
+		return WaitUntilFastLaunchEnabled(conn,
+			ctx,
+			fastLaunchDescribeInput,
+			w.getWaiterOptions()...)
+	})
+	return err
+}
+
+func (w *AWSPollingConfig) WaitUntilSSMInstanceOnline(ctx aws.Context, conn *ssm.SSM, instanceID string) error {
+	instanceInput := &ssm.DescribeInstanceInformationInput{
+		Filters: []*ssm.InstanceInformationStringFilter{
+			{
+				Key:    aws.String("InstanceIds"),
+				Values: []*string{&instanceID},
+			},
+		},
+	}
+
+	err := w.withTimeout(ctx, func(ctx aws.Context) error {
+		return WaitForSSMInstanceToBeOnline(conn,
+			ctx,
+			instanceInput,
+			w.getWaiterOptions()...)
+	})
 	return err
 }
 
@@ -371,6 +442,50 @@ func WaitUntilFastLaunchEnabled(c *ec2.EC2, ctx aws.Context, input *ec2.Describe
 	return w.WaitWithContext(ctx)
 }
 
+// WaitForSSMInstanceToBeOnline waits for the SSM agent of the instance to
+// register with Systems Manager. Until then, the instance is either not listed
+// or reported as an invalid instance.
+func WaitForSSMInstanceToBeOnline(c *ssm.SSM, ctx aws.Context, input *ssm.DescribeInstanceInformationInput, opts ...request.WaiterOption) error {
+	w := request.Waiter{
+		Name:        "DescribeInstanceInformation",
+		MaxAttempts: 60,
+		Delay:       request.ConstantWaiterDelay(5 * time.Second),
+		Acceptors: []request.WaiterAcceptor{
+			{
+				State:    request.SuccessWaiterState,
+				Matcher:  request.PathAllWaiterMatch,
+				Argument: "InstanceInformationList[].PingStatus",
+				Expected: ssm.PingStatusOnline,
+			},
+			{
+				State:    request.RetryWaiterState,
+				Matcher:  request.ErrorWaiterMatch,
+				Expected: ssm.ErrCodeInvalidInstanceId,
+			},
+			{
+				State:    request.FailureWaiterState,
+				Matcher:  request.ErrorWaiterMatch,
+				Expected: "AccessDeniedException",
+			},
+		},
+		Logger: c.Config.Logger,
+		NewRequest: func(opts []request.Option) (*request.Request, error) {
+			var inCpy *ssm.DescribeInstanceInformationInput
+			if input != nil {
+				tmp := *input
+				inCpy = &tmp
+			}
+			req, _ := c.DescribeInstanceInformationRequest(inCpy)
+			req.SetContext(ctx)
+			req.ApplyOptions(opts...)
+			return req, nil
+		},
+	}
+	w.ApplyOptions(opts...)
+
+	return w.WaitWithContext(ctx)
+}
+
 // This helper function uses the environment variables AWS_TIMEOUT_SECONDS and
 // AWS_POLL_DELAY_SECONDS to generate waiter options that can be passed into any
 // request.Waiter function. These options will control how many times the waiter
@@ -396,12 +511,16 @@ type envInfo struct {
 }
 
 type overridableWaitVars struct {
-	awsPollDelaySeconds envInfo
-	awsMaxAttempts      envInfo
-	awsTimeoutSeconds   envInfo
+	awsPollDelaySeconds  envInfo
+	awsMaxAttempts       envInfo
+	awsTimeoutSeconds    envInfo
+	awsPollJitterSeconds envInfo
 }
 
-func (w *AWSPollingConfig) getWaiterOptions() []request.WaiterOption {
+// getWaiterOptions returns the waiter options of the config and of the
+// environment variables, or defaults when neither overrides the delay or
+// the number of attempts. The jitter is applied to the delay either way.
+func (w *AWSPollingConfig) getWaiterOptions(defaults ...request.WaiterOption) []request.WaiterOption {
 	envOverrides := getEnvOverrides()
 
 	if w.MaxAttempts != 0 {
@@ -412,8 +531,26 @@ func (w *AWSPollingConfig) getWaiterOptions() []request.WaiterOption {
 		envOverrides.awsPollDelaySeconds.Val = w.DelaySeconds
 		envOverrides.awsPollDelaySeconds.overridden = true
 	}
+	if w.JitterSeconds != 0 {
+		envOverrides.awsPollJitterSeconds.Val = w.JitterSeconds
+		envOverrides.awsPollJitterSeconds.overridden = true
+	}
 
 	waitOpts := applyEnvOverrides(envOverrides)
+	if len(waitOpts) == 0 {
+		waitOpts = append(waitOpts, defaults...)
+	}
+	// if poll jitter is set, each attempt waits for the delay of the waiter
+	// plus or minus a random jitter.
+	if envOverrides.awsPollJitterSeconds.overridden && envOverrides.awsPollJitterSeconds.Val > 0 {
+		jitter := time.Duration(envOverrides.awsPollJitterSeconds.Val) * time.Second
+		waitOpts = append(waitOpts, func(waiter *request.Waiter) {
+			delay := waiter.Delay
+			waiter.Delay = func(attempt int) time.Duration {
+				return awscommon.JitterDelay(delay(attempt), jitter)
+			}
+		})
+	}
 	return waitOpts
 }
 
@@ -437,19 +574,32 @@ func getEnvOverrides() overridableWaitVars {
 		envInfo{"AWS_POLL_DELAY_SECONDS", 2, false},
 		envInfo{"AWS_MAX_ATTEMPTS", 0, false},
 		envInfo{"AWS_TIMEOUT_SECONDS", 0, false},
+		envInfo{"AWS_POLL_JITTER_SECONDS", 0, false},
 	}
 
 	envValues.awsMaxAttempts = getOverride(envValues.awsMaxAttempts)
 	envValues.awsPollDelaySeconds = getOverride(envValues.awsPollDelaySeconds)
 	envValues.awsTimeoutSeconds = getOverride(envValues.awsTimeoutSeconds)
+	envValues.awsPollJitterSeconds = getOverride(envValues.awsPollJitterSeconds)
 
 	return envValues
 }
 
+// ErrPollingTimeout is returned, wrapped, by the waits that went on for
+// longer than the timeout of their AWSPollingConfig.
+var ErrPollingTimeout = awscommon.ErrPollingTimeout
+
+// withTimeout calls wait bounded by the timeout of the config, see
+// awscommon.WithPollingTimeout.
+func (w *AWSPollingConfig) withTimeout(ctx aws.Context, wait func(ctx aws.Context) error) error {
+	return awscommon.WithPollingTimeout(ctx, w.Timeout, wait)
+}
+
 func (w *AWSPollingConfig) LogEnvOverrideWarnings() {
 	pollDelayEnv := os.Getenv("AWS_POLL_DELAY_SECONDS")
 	timeoutSecondsEnv := os.Getenv("AWS_TIMEOUT_SECONDS")
 	maxAttemptsEnv := os.Getenv("AWS_MAX_ATTEMPTS")
+	jitterSecondsEnv := os.Getenv("AWS_POLL_JITTER_SECONDS")
 
 	maxAttemptsIsSet := maxAttemptsEnv != "" || w.MaxAttempts != 0
 	timeoutSecondsIsSet := timeoutSecondsEnv != ""
@@ -466,18 +616,30 @@ func (w *AWSPollingConfig) LogEnvOverrideWarnings() {
 		log.Print(warning)
 	} else if timeoutSecondsIsSet {
 		log.Printf("[WARNING] (aws): env var AWS_TIMEOUT_SECONDS is " +
-			"deprecated in favor of AWS_MAX_ATTEMPTS env or aws_polling_max_attempts config option. " +
+			"deprecated in favor of AWS_MAX_ATTEMPTS env, aws_polling_max_attempts or aws_polling_timeout config option. " +
 			"If you have not explicitly set AWS_POLL_DELAY_SECONDS env or aws_polling_delay_seconds config option, " +
 			"we are defaulting to a poll delay of 2 seconds, regardless of the AWS waiter's default.")
 	}
-	if !maxAttemptsIsSet && !timeoutSecondsIsSet && !pollDelayIsSet {
+	if w.Timeout > 0 {
+		log.Printf("[INFO] (aws): The timeout option is set. Packer will stop waiting "+
+			"for AWS resources after %s, whatever the number of attempts left.", awscommon.FormatTimeout(w.Timeout))
+	}
+	if !maxAttemptsIsSet && !timeoutSecondsIsSet && !pollDelayIsSet && w.Timeout <= 0 {
 		log.Printf("[INFO] (aws): No AWS timeout and polling overrides have been set. " +
 			"Packer will default to waiter-specific delays and timeouts. If you would " +
 			"like to customize the length of time between retries and max " +
 			"number of retries you may do so by setting the environment " +
 			"variables AWS_POLL_DELAY_SECONDS and AWS_MAX_ATTEMPTS or the " +
 			"configuration options aws_polling_delay_seconds and aws_polling_max_attempts " +
-			"to your desired values.")
+			"to your desired values, or bound the wait time with the aws_polling_timeout " +
+			"configuration option.")
+	}
+	if w.JitterSeconds < 0 {
+		log.Printf("[WARNING] (aws): jitter_seconds is negative, Packer will not " +
+			"apply any jitter to the poll delay.")
+	} else if w.JitterSeconds != 0 || jitterSecondsEnv != "" {
+		log.Printf("[INFO] (aws): A poll jitter has been set. Packer will randomize " +
+			"the delay between retries by up to the jitter in either direction.")
 	}
 }
 
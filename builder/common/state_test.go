@@ -0,0 +1,73 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package common
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// mockWaitEC2Conn never sees the image become available.
+type mockWaitEC2Conn struct {
+	mockEC2Conn
+}
+
+func (m *mockWaitEC2Conn) WaitUntilImageAvailableWithContext(ctx aws.Context, input *ec2.DescribeImagesInput, opts ...request.WaiterOption) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestAWSPollingConfig_Timeout(t *testing.T) {
+	w := &AWSPollingConfig{Timeout: 10 * time.Millisecond}
+	err := w.WaitUntilAMIAvailable(context.Background(), &mockWaitEC2Conn{}, "ami-12345678")
+	if !errors.Is(err, ErrPollingTimeout) || err.Error() != "polling timed out after 10ms" {
+		t.Fatalf("expected a polling timeout, got %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	w.Timeout = time.Hour
+	if err := w.WaitUntilAMIAvailable(ctx, &mockWaitEC2Conn{}, "ami-12345678"); errors.Is(err, ErrPollingTimeout) {
+		t.Fatalf("a cancelled context should not be reported as a timeout, got %v", err)
+	}
+}
+
+func TestAWSPollingConfig_Jitter(t *testing.T) {
+	for _, env := range []string{"AWS_POLL_DELAY_SECONDS", "AWS_MAX_ATTEMPTS", "AWS_TIMEOUT_SECONDS", "AWS_POLL_JITTER_SECONDS"} {
+		t.Setenv(env, "")
+	}
+
+	w := &AWSPollingConfig{JitterSeconds: 5}
+	waiter := request.Waiter{MaxAttempts: 40, Delay: request.ConstantWaiterDelay(10 * time.Second)}
+	waiter.ApplyOptions(w.getWaiterOptions(request.WithWaiterMaxAttempts(120))...)
+
+	// The jitter alone doesn't override the defaults.
+	if waiter.MaxAttempts != 120 {
+		t.Fatalf("expected the default 120 attempts, got %d", waiter.MaxAttempts)
+	}
+	for attempt := 0; attempt < 100; attempt++ {
+		if delay := waiter.Delay(attempt); delay < 5*time.Second || delay > 15*time.Second {
+			t.Fatalf("expected a delay within 10s ± 5s, got %s", delay)
+		}
+	}
+
+	t.Setenv("AWS_POLL_JITTER_SECONDS", "2")
+	w = &AWSPollingConfig{DelaySeconds: 30}
+	waiter = request.Waiter{MaxAttempts: 40, Delay: request.ConstantWaiterDelay(10 * time.Second)}
+	waiter.ApplyOptions(w.getWaiterOptions(request.WithWaiterMaxAttempts(120))...)
+	if waiter.MaxAttempts != 40 {
+		t.Fatalf("expected the waiter attempts to be kept, got %d", waiter.MaxAttempts)
+	}
+	for attempt := 0; attempt < 100; attempt++ {
+		if delay := waiter.Delay(attempt); delay < 28*time.Second || delay > 32*time.Second {
+			t.Fatalf("expected a delay within 30s ± 2s, got %s", delay)
+		}
+	}
+}
@@ -6,6 +6,8 @@ package common
 import (
 	"context"
 	"fmt"
+	"sort"
+	"strings"
 	"sync"
 
 	aws_v2 "github.com/aws/aws-sdk-go-v2/aws"
@@ -16,6 +18,7 @@ import (
 	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
 	"github.com/hashicorp/packer-plugin-sdk/multistep"
 	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
+	"github.com/hashicorp/packer-plugin-sdk/packerbuilderdata"
 	"github.com/hashicorp/packer-plugin-sdk/template/config"
 )
 
@@ -33,6 +36,9 @@ type StepAMIRegionCopy struct {
 	AMISkipCreateImage             bool
 	AMISkipBuildRegion             bool
 	AMISnapshotCopyDurationMinutes int64
+	IgnoreCopyErrors               bool
+	RegionVolumeTypes              map[string]string
+	GeneratedData                  *packerbuilderdata.GeneratedData
 }
 
 func (s *StepAMIRegionCopy) DeduplicateRegions(intermediary bool) {
@@ -72,6 +78,10 @@ func (s *StepAMIRegionCopy) DeduplicateRegions(intermediary bool) {
 func (s *StepAMIRegionCopy) Run(ctx context.Context, state multistep.StateBag) multistep.StepAction {
 	ui := state.Get("ui").(packersdk.Ui)
 
+	if s.GeneratedData != nil {
+		s.GeneratedData.Put("FailedCopyRegions", "")
+	}
+
 	if s.AMISkipCreateImage {
 		ui.Say("Skipping AMI region copy...")
 		return multistep.ActionContinue
@@ -84,11 +94,6 @@ func (s *StepAMIRegionCopy) Run(ctx context.Context, state multistep.StateBag) m
 	s.DeduplicateRegions(intermediary)
 	ami := amis[s.OriginalRegion]
 
-	// Make a note to delete the intermediary AMI if necessary.
-	if intermediary {
-		s.toDelete = ami
-	}
-
 	if s.EncryptBootVolume.True() {
 		// encrypt_boot is true, so we have to copy the temporary
 		// AMI with required encryption setting.
@@ -111,6 +116,10 @@ func (s *StepAMIRegionCopy) Run(ctx context.Context, state multistep.StateBag) m
 	}
 
 	if len(s.Regions) == 0 {
+		// Make a note to delete the intermediary AMI if necessary.
+		if intermediary {
+			s.toDelete = ami
+		}
 		return multistep.ActionContinue
 	}
 
@@ -119,6 +128,8 @@ func (s *StepAMIRegionCopy) Run(ctx context.Context, state multistep.StateBag) m
 	var lock sync.Mutex
 	var wg sync.WaitGroup
 	errs := new(packersdk.MultiError)
+	// The copies that were started but failed, by region.
+	failed := make(map[string]string)
 	wg.Add(len(s.Regions))
 	for _, region := range s.Regions {
 		var regKeyID string
@@ -139,11 +150,14 @@ func (s *StepAMIRegionCopy) Run(ctx context.Context, state multistep.StateBag) m
 				s.EncryptBootVolume.ToBoolPointer())
 			lock.Lock()
 			defer lock.Unlock()
-			amis[region] = id
-			snapshots[region] = snapshotIds
 			if err != nil {
 				errs = packersdk.MultiErrorAppend(errs, err)
+				// Only the successful copies are part of the artifact
+				failed[region] = id
+				return
 			}
+			amis[region] = id
+			snapshots[region] = snapshotIds
 		}(region)
 	}
 
@@ -153,15 +167,59 @@ func (s *StepAMIRegionCopy) Run(ctx context.Context, state multistep.StateBag) m
 
 	// If there were errors, show them
 	if len(errs.Errors) > 0 {
-		state.Put("error", errs)
-		ui.Error(errs.Error())
-		return multistep.ActionHalt
+		s.destroyFailedCopies(ui, failed)
+		// When the build region is skipped, the intermediary AMI is the
+		// only source left for the regions the copy failed in, so it is
+		// kept. Otherwise it is a temporary AMI, deleted as usual.
+		if intermediary && s.AMISkipBuildRegion {
+			ui.Error(fmt.Sprintf("Keeping the intermediary AMI (%s) in %s, "+
+				"as it couldn't be copied to every region", ami, s.OriginalRegion))
+		} else if intermediary {
+			s.toDelete = ami
+		}
+		if !s.IgnoreCopyErrors {
+			state.Put("error", errs)
+			ui.Error(errs.Error())
+			return multistep.ActionHalt
+		}
+		regions := make([]string, 0, len(failed))
+		for region := range failed {
+			regions = append(regions, region)
+		}
+		sort.Strings(regions)
+		ui.Say(fmt.Sprintf("Ignoring the failed AMI copies to %s, the artifact "+
+			"only contains the successful ones: %s", strings.Join(regions, ", "), errs))
+		if s.GeneratedData != nil {
+			s.GeneratedData.Put("FailedCopyRegions", strings.Join(regions, ","))
+		}
+	} else if intermediary {
+		// Only delete the intermediary AMI once every copy is available.
+		s.toDelete = ami
 	}
 
 	state.Put("amis", amis)
 	return multistep.ActionContinue
 }
 
+// destroyFailedCopies deregisters the AMIs of the copies that failed, by
+// region, and deletes their snapshots. Copies that failed before an AMI was
+// created have no ID.
+func (s *StepAMIRegionCopy) destroyFailedCopies(ui packersdk.Ui, failed map[string]string) {
+	for region, imageId := range failed {
+		if imageId == "" {
+			continue
+		}
+		ui.Say(fmt.Sprintf("Deregistering the failed copy (%s) in %s and deleting its snapshots", imageId, region))
+		regionconn, err := s.getRegionConn(s.AccessConfig, region)
+		if err == nil {
+			err = DestroyAMIs([]*string{aws.String(imageId)}, regionconn)
+		}
+		if err != nil {
+			ui.Error(fmt.Sprintf("Error cleaning up the failed copy (%s) in %s: %s", imageId, region, err))
+		}
+	}
+}
+
 func (s *StepAMIRegionCopy) Cleanup(state multistep.StateBag) {
 	ec2conn := state.Get("ec2").(*ec2.EC2)
 	ui := state.Get("ui").(packersdk.Ui)
@@ -286,16 +344,34 @@ func (s *StepAMIRegionCopy) amiRegionCopy(ctx context.Context, state multistep.S
 		}
 	}
 
+	// From here on, the ID of the copy is returned along with errors so
+	// that it can be cleaned up.
+
 	// Wait for the image to become ready
 	if err := s.AccessConfig.PollingConfig.WaitUntilAMIAvailable(ctx, regionconn, amiImageId); err != nil {
-		return "", snapshotIds, fmt.Errorf("Error waiting for AMI (%s) in region (%s): %s",
+		return amiImageId, snapshotIds, fmt.Errorf("Error waiting for AMI (%s) in region (%s): %s",
 			imageId, target, err)
 	}
 
+	if volumeType := s.RegionVolumeTypes[target]; volumeType != "" {
+		newImageId, err := s.registerWithVolumeType(ctx, regionconn, amiImageId, volumeType)
+		if err != nil {
+			err = fmt.Errorf("Error changing the volume type of AMI (%s) in region (%s): %s",
+				amiImageId, target, err)
+			// The copy may already be replaced by a temporary AMI of the
+			// same snapshots, which are deleted along with it.
+			if newImageId != "" {
+				amiImageId = newImageId
+			}
+			return amiImageId, snapshotIds, err
+		}
+		amiImageId = newImageId
+	}
+
 	// Getting snapshot IDs out of the copied AMI
 	describeImageResp, err := regionconn.DescribeImages(&ec2.DescribeImagesInput{ImageIds: []*string{&amiImageId}})
 	if err != nil {
-		return "", snapshotIds, fmt.Errorf("Error describing copied AMI (%s) in region (%s): %s",
+		return amiImageId, snapshotIds, fmt.Errorf("Error describing copied AMI (%s) in region (%s): %s",
 			imageId, target, err)
 	}
 
@@ -307,3 +383,45 @@ func (s *StepAMIRegionCopy) amiRegionCopy(ctx context.Context, state multistep.S
 
 	return amiImageId, snapshotIds, nil
 }
+
+// registerWithVolumeType replaces the AMI imageId with one registered from
+// the same snapshots, with EBS volumes of volumeType, and returns its ID. The
+// AMI is kept as is if its volumes already are of that type. On error, the ID
+// of the temporary AMI left in place of imageId is returned, if any.
+func (s *StepAMIRegionCopy) registerWithVolumeType(ctx context.Context, conn ec2iface.EC2API, imageId, volumeType string) (string, error) {
+	resp, err := conn.DescribeImages(&ec2.DescribeImagesInput{ImageIds: []*string{aws.String(imageId)}})
+	if err != nil {
+		return "", err
+	}
+	if len(resp.Images) == 0 {
+		return "", fmt.Errorf("AMI %s not found", imageId)
+	}
+	image := resp.Images[0]
+
+	input := RegisterImageInputFromImage(image)
+	changed := false
+	for i, mapping := range input.BlockDeviceMappings {
+		if mapping.Ebs == nil || mapping.Ebs.SnapshotId == nil {
+			continue
+		}
+		if aws.StringValue(mapping.Ebs.VolumeType) != volumeType {
+			changed = true
+		}
+		// The IOPS and throughput of the old volume type may not be valid
+		// for the new one, they are left to their defaults.
+		input.BlockDeviceMappings[i] = &ec2.BlockDeviceMapping{
+			DeviceName: mapping.DeviceName,
+			Ebs: &ec2.EbsBlockDevice{
+				DeleteOnTermination: mapping.Ebs.DeleteOnTermination,
+				SnapshotId:          mapping.Ebs.SnapshotId,
+				VolumeSize:          mapping.Ebs.VolumeSize,
+				VolumeType:          aws.String(volumeType),
+			},
+		}
+	}
+	if !changed {
+		return imageId, nil
+	}
+
+	return ReregisterImage(ctx, conn, s.AccessConfig.PollingConfig, imageId, input)
+}
@@ -7,6 +7,8 @@ import (
 	"bytes"
 	"context"
 	"fmt"
+	"reflect"
+	"strings"
 	"sync"
 	"testing"
 
@@ -16,6 +18,7 @@ import (
 	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
 	"github.com/hashicorp/packer-plugin-sdk/multistep"
 	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
+	"github.com/hashicorp/packer-plugin-sdk/packerbuilderdata"
 	"github.com/hashicorp/packer-plugin-sdk/template/config"
 )
 
@@ -408,3 +411,296 @@ func TestStepAmiRegionCopy_AMISkipBuildRegion(t *testing.T) {
 		t.Fatalf("Should not have added original ami to Regions; Regions: %#v", stepAMIRegionCopy.Regions)
 	}
 }
+
+type failingCopyEC2Conn struct {
+	mockEC2Conn
+}
+
+func (m *failingCopyEC2Conn) CopyImage(*ec2.CopyImageInput) (*ec2.CopyImageOutput, error) {
+	return nil, fmt.Errorf("InvalidRequest: region is unavailable")
+}
+
+// failingWaitEC2Conn starts copies that never become available.
+type failingWaitEC2Conn struct {
+	mockEC2Conn
+}
+
+func (m *failingWaitEC2Conn) WaitUntilImageAvailableWithContext(aws.Context, *ec2.DescribeImagesInput, ...request.WaiterOption) error {
+	return fmt.Errorf("ResourceNotReady: failed waiting for successful resource state")
+}
+
+func (m *failingWaitEC2Conn) DescribeImages(input *ec2.DescribeImagesInput) (*ec2.DescribeImagesOutput, error) {
+	return &ec2.DescribeImagesOutput{Images: []*ec2.Image{{
+		ImageId: input.ImageIds[0],
+		BlockDeviceMappings: []*ec2.BlockDeviceMapping{
+			{DeviceName: aws.String("/dev/xvda"), Ebs: &ec2.EbsBlockDevice{SnapshotId: aws.String("snap-copy")}},
+		},
+	}}}, nil
+}
+
+func TestStepAmiRegionCopy_IgnoreCopyErrors(t *testing.T) {
+	var lock sync.Mutex
+	var waitFailed *failingWaitEC2Conn
+	getRegionConn := func(config *AccessConfig, target string) (ec2iface.EC2API, error) {
+		switch target {
+		case "ap-south-1":
+			return &failingCopyEC2Conn{mockEC2Conn{Config: aws.NewConfig()}}, nil
+		case "sa-east-1":
+			lock.Lock()
+			defer lock.Unlock()
+			if waitFailed == nil {
+				waitFailed = &failingWaitEC2Conn{mockEC2Conn{Config: aws.NewConfig()}}
+			}
+			return waitFailed, nil
+		}
+		return getMockConn(config, target)
+	}
+
+	for _, ignore := range []bool{false, true} {
+		t.Run(fmt.Sprintf("ignore_copy_errors=%t", ignore), func(t *testing.T) {
+			stepAMIRegionCopy := StepAMIRegionCopy{
+				AccessConfig:     FakeAccessConfig(),
+				Regions:          []string{"us-west-1", "ap-south-1", "eu-west-1", "sa-east-1"},
+				Name:             "fake-ami-name",
+				OriginalRegion:   "us-east-1",
+				IgnoreCopyErrors: ignore,
+			}
+			stepAMIRegionCopy.getRegionConn = getRegionConn
+			waitFailed = nil
+
+			state := tState()
+			stepAMIRegionCopy.GeneratedData = &packerbuilderdata.GeneratedData{State: state}
+			state.Put("intermediary_image", false)
+			action := stepAMIRegionCopy.Run(context.Background(), state)
+
+			ui := state.Get("ui").(*packersdk.BasicUi)
+			output := ui.Writer.(*bytes.Buffer).String()
+
+			// The copy that was started is cleaned up either way.
+			if waitFailed.deregisterImageCount != 1 || waitFailed.deleteSnapshotCount != 1 {
+				t.Fatalf("the failed copy should be deregistered and its snapshot deleted, got %d deregistrations and %d deletions",
+					waitFailed.deregisterImageCount, waitFailed.deleteSnapshotCount)
+			}
+
+			if !ignore {
+				if action != multistep.ActionHalt {
+					t.Fatalf("a failed copy should halt the build, got %v", action)
+				}
+				return
+			}
+
+			if action != multistep.ActionContinue {
+				t.Fatalf("should continue, got %v: %v", action, state.Get("error"))
+			}
+			if _, ok := state.GetOk("error"); ok {
+				t.Fatalf("failed copies should not fail the step: %v", state.Get("error"))
+			}
+
+			amis := state.Get("amis").(map[string]string)
+			for _, region := range []string{"us-east-1", "us-west-1", "eu-west-1"} {
+				if amis[region] == "" {
+					t.Fatalf("expected an AMI in %s, got %#v", region, amis)
+				}
+			}
+			for _, region := range []string{"ap-south-1", "sa-east-1"} {
+				if _, ok := amis[region]; ok {
+					t.Fatalf("the failed region %s should not be in the artifact: %#v", region, amis)
+				}
+			}
+			if _, ok := state.Get("snapshots").(map[string][]string)["ap-south-1"]; ok {
+				t.Fatalf("the failed region should not have snapshots")
+			}
+
+			if !strings.Contains(output, "region (ap-south-1)") {
+				t.Fatalf("the error should name the failed region, got output: %s", output)
+			}
+			if !strings.Contains(output, "failed AMI copies to ap-south-1, sa-east-1") {
+				t.Fatalf("the warning should list the skipped regions, got output: %s", output)
+			}
+			generated := state.Get("generated_data").(map[string]interface{})
+			if generated["FailedCopyRegions"] != "ap-south-1,sa-east-1" {
+				t.Fatalf("expected the skipped regions in the generated data, got %v", generated["FailedCopyRegions"])
+			}
+		})
+	}
+}
+
+func TestStepAmiRegionCopy_KeepsIntermediaryOnCopyFailure(t *testing.T) {
+	for _, ignore := range []bool{false, true} {
+		t.Run(fmt.Sprintf("ignore_copy_errors=%t", ignore), func(t *testing.T) {
+			stepAMIRegionCopy := StepAMIRegionCopy{
+				AccessConfig:       FakeAccessConfig(),
+				Regions:            []string{"us-west-1", "ap-south-1"},
+				Name:               "fake-ami-name",
+				OriginalRegion:     "us-east-1",
+				AMISkipBuildRegion: true,
+				IgnoreCopyErrors:   ignore,
+			}
+			stepAMIRegionCopy.getRegionConn = func(config *AccessConfig, target string) (ec2iface.EC2API, error) {
+				if target == "ap-south-1" {
+					return &failingCopyEC2Conn{mockEC2Conn{Config: aws.NewConfig()}}, nil
+				}
+				return getMockConn(config, target)
+			}
+
+			state := tState()
+			state.Put("intermediary_image", true)
+			stepAMIRegionCopy.Run(context.Background(), state)
+
+			if stepAMIRegionCopy.toDelete != "" {
+				t.Fatalf("the intermediary AMI should be kept when a copy fails, got %q to delete", stepAMIRegionCopy.toDelete)
+			}
+			output := state.Get("ui").(*packersdk.BasicUi).Writer.(*bytes.Buffer).String()
+			if !strings.Contains(output, "Keeping the intermediary AMI (ami-12345)") {
+				t.Fatalf("the user should be told the intermediary AMI is kept, got output: %s", output)
+			}
+		})
+	}
+}
+
+func TestStepAmiRegionCopy_DeletesTemporaryAMIOnCopyFailure(t *testing.T) {
+	stepAMIRegionCopy := StepAMIRegionCopy{
+		AccessConfig:      FakeAccessConfig(),
+		Regions:           []string{"us-west-1", "ap-south-1"},
+		Name:              "fake-ami-name",
+		OriginalRegion:    "us-east-1",
+		EncryptBootVolume: config.TriTrue,
+	}
+	stepAMIRegionCopy.getRegionConn = func(config *AccessConfig, target string) (ec2iface.EC2API, error) {
+		if target == "ap-south-1" {
+			return &failingCopyEC2Conn{mockEC2Conn{Config: aws.NewConfig()}}, nil
+		}
+		return getMockConn(config, target)
+	}
+
+	state := tState()
+	state.Put("intermediary_image", true)
+	stepAMIRegionCopy.Run(context.Background(), state)
+
+	// The build region has its own copy, the intermediary AMI is only a
+	// temporary one.
+	if stepAMIRegionCopy.toDelete != "ami-12345" {
+		t.Fatalf("the temporary AMI should be deleted when a copy fails, got %q to delete", stepAMIRegionCopy.toDelete)
+	}
+}
+
+type volumeTypeEC2Conn struct {
+	mockEC2Conn
+
+	registerImageInputs []*ec2.RegisterImageInput
+	failRegistration    bool
+	deregistered        []string
+}
+
+func (m *volumeTypeEC2Conn) DescribeImages(input *ec2.DescribeImagesInput) (*ec2.DescribeImagesOutput, error) {
+	for _, imageId := range m.deregistered {
+		if imageId == aws.StringValue(input.ImageIds[0]) {
+			return &ec2.DescribeImagesOutput{}, nil
+		}
+	}
+	return &ec2.DescribeImagesOutput{Images: []*ec2.Image{{
+		ImageId:        input.ImageIds[0],
+		Name:           aws.String("fake-ami-name"),
+		Architecture:   aws.String("x86_64"),
+		RootDeviceName: aws.String("/dev/xvda"),
+		Tags:           []*ec2.Tag{{Key: aws.String("team"), Value: aws.String("packer")}},
+		BlockDeviceMappings: []*ec2.BlockDeviceMapping{
+			{DeviceName: aws.String("/dev/xvda"), Ebs: &ec2.EbsBlockDevice{
+				SnapshotId: aws.String("snap-root"), VolumeSize: aws.Int64(8), VolumeType: aws.String("io1"), Iops: aws.Int64(5000),
+			}},
+			{DeviceName: aws.String("/dev/sdb"), VirtualName: aws.String("ephemeral0")},
+		},
+	}}}, nil
+}
+
+func (m *volumeTypeEC2Conn) DeregisterImage(input *ec2.DeregisterImageInput) (*ec2.DeregisterImageOutput, error) {
+	m.deregistered = append(m.deregistered, aws.StringValue(input.ImageId))
+	return m.mockEC2Conn.DeregisterImage(input)
+}
+
+func (m *volumeTypeEC2Conn) RegisterImage(input *ec2.RegisterImageInput) (*ec2.RegisterImageOutput, error) {
+	m.registerImageInputs = append(m.registerImageInputs, input)
+	if m.failRegistration && aws.StringValue(input.Name) == "fake-ami-name" {
+		return nil, fmt.Errorf("InvalidAMIName.Duplicate")
+	}
+	return &ec2.RegisterImageOutput{ImageId: aws.String(fmt.Sprintf("ami-gp3-%d", len(m.registerImageInputs)))}, nil
+}
+
+func TestStepAmiRegionCopy_RegionVolumeTypes(t *testing.T) {
+	conns := map[string]*volumeTypeEC2Conn{}
+	stepAMIRegionCopy := StepAMIRegionCopy{
+		AccessConfig:      FakeAccessConfig(),
+		Regions:           []string{"us-west-1", "eu-west-1"},
+		Name:              "fake-ami-name",
+		OriginalRegion:    "us-east-1",
+		RegionVolumeTypes: map[string]string{"eu-west-1": "gp3"},
+	}
+	var lock sync.Mutex
+	stepAMIRegionCopy.getRegionConn = func(config *AccessConfig, target string) (ec2iface.EC2API, error) {
+		lock.Lock()
+		defer lock.Unlock()
+		conns[target] = &volumeTypeEC2Conn{mockEC2Conn: mockEC2Conn{Config: aws.NewConfig()}}
+		return conns[target], nil
+	}
+
+	state := tState()
+	state.Put("intermediary_image", false)
+	if action := stepAMIRegionCopy.Run(context.Background(), state); action != multistep.ActionContinue {
+		t.Fatalf("should continue, got %v: %v", action, state.Get("error"))
+	}
+
+	if len(conns["us-west-1"].registerImageInputs) != 0 || conns["us-west-1"].deregisterImageCount != 0 {
+		t.Fatal("the copy to us-west-1 should be kept as is")
+	}
+
+	conn := conns["eu-west-1"]
+	// The copy is registered under a temporary name, then under its own
+	// once it is deregistered.
+	if conn.deregisterImageCount != 2 || len(conn.registerImageInputs) != 2 {
+		t.Fatalf("the copy to eu-west-1 should be registered again, got %d deregistrations and %d registrations",
+			conn.deregisterImageCount, len(conn.registerImageInputs))
+	}
+	input := conn.registerImageInputs[1]
+	if aws.StringValue(input.Name) != "fake-ami-name" || len(input.TagSpecifications) != 1 {
+		t.Fatalf("the new AMI should keep the name and tags of the copy: %s", input)
+	}
+	root := input.BlockDeviceMappings[0].Ebs
+	if aws.StringValue(root.VolumeType) != "gp3" || aws.StringValue(root.SnapshotId) != "snap-root" || root.Iops != nil {
+		t.Fatalf("the root volume should be a gp3 volume from the copied snapshot: %s", root)
+	}
+	if input.BlockDeviceMappings[1].Ebs != nil {
+		t.Fatalf("instance store mappings should be kept: %s", input.BlockDeviceMappings[1])
+	}
+
+	if amis := state.Get("amis").(map[string]string); amis["eu-west-1"] != "ami-gp3-2" {
+		t.Fatalf("the artifact should hold the registered AMI, got %#v", amis)
+	}
+}
+
+func TestStepAmiRegionCopy_RegionVolumeTypeFailure(t *testing.T) {
+	conn := &volumeTypeEC2Conn{mockEC2Conn: mockEC2Conn{Config: aws.NewConfig()}, failRegistration: true}
+	stepAMIRegionCopy := StepAMIRegionCopy{
+		AccessConfig:      FakeAccessConfig(),
+		Regions:           []string{"eu-west-1"},
+		Name:              "fake-ami-name",
+		OriginalRegion:    "us-east-1",
+		RegionVolumeTypes: map[string]string{"eu-west-1": "gp3"},
+		getRegionConn: func(*AccessConfig, string) (ec2iface.EC2API, error) {
+			return conn, nil
+		},
+	}
+
+	state := tState()
+	state.Put("intermediary_image", false)
+	if action := stepAMIRegionCopy.Run(context.Background(), state); action != multistep.ActionHalt {
+		t.Fatalf("should halt, got %v", action)
+	}
+
+	// The copy is deregistered once registered under a temporary name, the
+	// temporary AMI left when the final registration fails is deleted with
+	// its snapshots.
+	if !reflect.DeepEqual(conn.deregistered, []string{"ami-12345-copied-1", "ami-gp3-1"}) || conn.deleteSnapshotCount != 1 {
+		t.Fatalf("the temporary AMI and its snapshots should be deleted, got %v deregistered and %d snapshot deletions",
+			conn.deregistered, conn.deleteSnapshotCount)
+	}
+}
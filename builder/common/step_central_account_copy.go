@@ -0,0 +1,242 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package common
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/arn"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/aws/aws-sdk-go/service/kms/kmsiface"
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
+	"github.com/hashicorp/packer-plugin-sdk/packerbuilderdata"
+)
+
+// StepCentralAccountCopy copies the AMI of the build region into the account
+// of Role, with the credentials of Role, so that the account owns a copy.
+// The AMI and its snapshots are shared with the account for the copy, and
+// unshared once it completes. The copy is deregistered when the build fails.
+type StepCentralAccountCopy struct {
+	AccessConfig       *AccessConfig
+	Role               AssumeRoleConfig
+	KmsKeyId           string
+	Name               string
+	Region             string
+	AMISkipCreateImage bool
+	PollingConfig      *AWSPollingConfig
+	GeneratedData      *packerbuilderdata.GeneratedData
+
+	centralConn ec2iface.EC2API
+	centralId   string
+}
+
+func (s *StepCentralAccountCopy) Run(ctx context.Context, state multistep.StateBag) multistep.StepAction {
+	// CentralAMI is declared by the builders, it stays empty without copy.
+	if s.GeneratedData != nil {
+		s.GeneratedData.Put("CentralAMI", "")
+	}
+	if s.Role.AssumeRoleARN == "" {
+		return multistep.ActionContinue
+	}
+
+	ui := state.Get("ui").(packersdk.Ui)
+	if s.AMISkipCreateImage {
+		ui.Say("skip_create_ami was set. Skipping the copy to the central account...")
+		return multistep.ActionContinue
+	}
+
+	amis := state.Get("amis").(map[string]string)
+	imageId, ok := amis[s.Region]
+	if !ok {
+		err := fmt.Errorf("no AMI found in region %s to copy to the central account", s.Region)
+		state.Put("error", err)
+		ui.Error(err.Error())
+		return multistep.ActionHalt
+	}
+
+	centralId, err := s.copyToCentralAccount(ctx, state.Get("ec2").(ec2iface.EC2API), ui, imageId)
+	if err != nil {
+		err := fmt.Errorf("Error copying AMI (%s) to the central account: %s", imageId, err)
+		state.Put("error", err)
+		ui.Error(err.Error())
+		return multistep.ActionHalt
+	}
+
+	state.Put("central_ami", centralId)
+	if s.GeneratedData != nil {
+		s.GeneratedData.Put("CentralAMI", centralId)
+	}
+	return multistep.ActionContinue
+}
+
+func (s *StepCentralAccountCopy) copyToCentralAccount(ctx context.Context, conn ec2iface.EC2API, ui packersdk.Ui, imageId string) (string, error) {
+	roleArn, err := arn.Parse(s.Role.AssumeRoleARN)
+	if err != nil {
+		return "", fmt.Errorf("invalid role ARN %q: %s", s.Role.AssumeRoleARN, err)
+	}
+	account := roleArn.AccountID
+
+	imageResp, err := conn.DescribeImages(&ec2.DescribeImagesInput{
+		ImageIds: []*string{aws.String(imageId)},
+	})
+	if err != nil {
+		return "", err
+	}
+	if len(imageResp.Images) == 0 {
+		return "", fmt.Errorf("AMI not found")
+	}
+	image := imageResp.Images[0]
+
+	var snapshotIds []*string
+	encrypted := false
+	for _, bdm := range image.BlockDeviceMappings {
+		if bdm.Ebs != nil && bdm.Ebs.SnapshotId != nil {
+			snapshotIds = append(snapshotIds, bdm.Ebs.SnapshotId)
+			encrypted = encrypted || aws.BoolValue(bdm.Ebs.Encrypted)
+		}
+	}
+
+	session, err := s.AccessConfig.Session()
+	if err != nil {
+		return "", err
+	}
+
+	// The snapshots can be encrypted with the default EBS key even though
+	// no key was set, by the source AMI or by encryption by default.
+	if encrypted {
+		if err := checkShareableSnapshotKeys(conn, kms.New(session, aws.NewConfig().WithRegion(s.Region)), snapshotIds); err != nil {
+			return "", err
+		}
+	}
+
+	// CopyImage needs the central account to be able to launch the AMI and
+	// read its snapshots.
+	ui.Say(fmt.Sprintf("Sharing AMI (%s) with the central account %s...", imageId, account))
+	if err := shareWithAccount(conn, imageId, snapshotIds, account, true); err != nil {
+		return "", err
+	}
+	defer func() {
+		log.Printf("Unsharing AMI (%s) with the central account %s", imageId, account)
+		if err := shareWithAccount(conn, imageId, snapshotIds, account, false); err != nil {
+			ui.Error(fmt.Sprintf("Error unsharing AMI (%s) with the central account %s: %s", imageId, account, err))
+		}
+	}()
+
+	centralConn := ec2.New(session, &aws.Config{
+		Credentials: s.Role.credentials(session),
+		Region:      aws.String(s.Region),
+	})
+
+	input := &ec2.CopyImageInput{
+		SourceImageId: aws.String(imageId),
+		SourceRegion:  aws.String(s.Region),
+		Name:          aws.String(s.Name),
+	}
+	// The key of the AMI belongs to this account, the copy is encrypted
+	// with a key of the central account.
+	if encrypted {
+		input.Encrypted = aws.Bool(true)
+		if s.KmsKeyId != "" {
+			input.KmsKeyId = aws.String(s.KmsKeyId)
+		}
+	}
+
+	ui.Say(fmt.Sprintf("Copying AMI (%s) to the central account %s with role %s...", imageId, account, s.Role.AssumeRoleARN))
+	copyResp, err := centralConn.CopyImage(input)
+	if err != nil {
+		return "", err
+	}
+	centralId := aws.StringValue(copyResp.ImageId)
+	s.centralConn, s.centralId = centralConn, centralId
+
+	ui.Say(fmt.Sprintf("Waiting for AMI (%s) in the central account to become ready...", centralId))
+	if err := s.PollingConfig.WaitUntilAMIAvailable(ctx, centralConn, centralId); err != nil {
+		return "", fmt.Errorf("error waiting for AMI (%s): %s", centralId, err)
+	}
+	ui.Say(fmt.Sprintf("AMI copied to the central account %s: %s", account, centralId))
+	return centralId, nil
+}
+
+// checkShareableSnapshotKeys fails when one of the snapshots is encrypted with
+// an AWS managed key, such as the default EBS key aws/ebs, whose policy can't
+// grant other accounts access to it.
+func checkShareableSnapshotKeys(conn ec2iface.EC2API, kmsConn kmsiface.KMSAPI, snapshotIds []*string) error {
+	resp, err := conn.DescribeSnapshots(&ec2.DescribeSnapshotsInput{SnapshotIds: snapshotIds})
+	if err != nil {
+		return fmt.Errorf("error describing the snapshots of the AMI: %s", err)
+	}
+	for _, snapshot := range resp.Snapshots {
+		if !aws.BoolValue(snapshot.Encrypted) || snapshot.KmsKeyId == nil {
+			continue
+		}
+		keyResp, err := kmsConn.DescribeKey(&kms.DescribeKeyInput{KeyId: snapshot.KmsKeyId})
+		if err != nil {
+			return fmt.Errorf("error describing the KMS key (%s) of snapshot (%s): %s",
+				aws.StringValue(snapshot.KmsKeyId), aws.StringValue(snapshot.SnapshotId), err)
+		}
+		if aws.StringValue(keyResp.KeyMetadata.KeyManager) == kms.KeyManagerTypeAws {
+			return fmt.Errorf("snapshot (%s) is encrypted with the AWS managed KMS key %s, which can't be "+
+				"shared with the central account. Set kms_key_id to a customer managed key",
+				aws.StringValue(snapshot.SnapshotId), aws.StringValue(snapshot.KmsKeyId))
+		}
+	}
+	return nil
+}
+
+// shareWithAccount adds, or removes, the launch permission of account on the
+// AMI imageId and the create volume permission on its snapshots.
+func shareWithAccount(conn ec2iface.EC2API, imageId string, snapshotIds []*string, account string, add bool) error {
+	launchPermissions := []*ec2.LaunchPermission{{UserId: aws.String(account)}}
+	launchModifications := &ec2.LaunchPermissionModifications{}
+	volumePermissions := []*ec2.CreateVolumePermission{{UserId: aws.String(account)}}
+	volumeModifications := &ec2.CreateVolumePermissionModifications{}
+	if add {
+		launchModifications.Add = launchPermissions
+		volumeModifications.Add = volumePermissions
+	} else {
+		launchModifications.Remove = launchPermissions
+		volumeModifications.Remove = volumePermissions
+	}
+
+	_, err := conn.ModifyImageAttribute(&ec2.ModifyImageAttributeInput{
+		ImageId:          aws.String(imageId),
+		LaunchPermission: launchModifications,
+	})
+	if err != nil {
+		return err
+	}
+	for _, snapshotId := range snapshotIds {
+		_, err := conn.ModifySnapshotAttribute(&ec2.ModifySnapshotAttributeInput{
+			SnapshotId:             snapshotId,
+			CreateVolumePermission: volumeModifications,
+		})
+		if err != nil {
+			return fmt.Errorf("error modifying the attributes of snapshot (%s): %s", aws.StringValue(snapshotId), err)
+		}
+	}
+	return nil
+}
+
+func (s *StepCentralAccountCopy) Cleanup(state multistep.StateBag) {
+	if s.centralId == "" {
+		return
+	}
+	_, cancelled := state.GetOk(multistep.StateCancelled)
+	_, halted := state.GetOk(multistep.StateHalted)
+	if !cancelled && !halted {
+		return
+	}
+
+	ui := state.Get("ui").(packersdk.Ui)
+	ui.Say(fmt.Sprintf("Deregistering the AMI (%s) copied to the central account...", s.centralId))
+	if err := DestroyAMIs([]*string{aws.String(s.centralId)}, s.centralConn); err != nil {
+		ui.Error(fmt.Sprintf("Error deregistering the AMI (%s) copied to the central account: %s", s.centralId, err))
+	}
+}
@@ -0,0 +1,252 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package common
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
+	"github.com/hashicorp/packer-plugin-sdk/packerbuilderdata"
+)
+
+const assumeRoleResponse = `<AssumeRoleResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/">
+  <AssumeRoleResult>
+    <Credentials>
+      <AccessKeyId>CENTRALKEY</AccessKeyId>
+      <SecretAccessKey>central-secret</SecretAccessKey>
+      <SessionToken>central-token</SessionToken>
+      <Expiration>2099-01-01T00:00:00Z</Expiration>
+    </Credentials>
+    <AssumedRoleUser>
+      <Arn>arn:aws:sts::222222222222:assumed-role/image-copier/packer</Arn>
+      <AssumedRoleId>AROAEXAMPLE:packer</AssumedRoleId>
+    </AssumedRoleUser>
+  </AssumeRoleResult>
+</AssumeRoleResponse>`
+
+const describeEncryptedImageResponse = `<DescribeImagesResponse xmlns="http://ec2.amazonaws.com/doc/2016-11-15/">
+  <imagesSet>
+    <item>
+      <imageId>%s</imageId>
+      <imageState>available</imageState>
+      <blockDeviceMapping>
+        <item>
+          <deviceName>/dev/xvda</deviceName>
+          <ebs>
+            <snapshotId>snap-12345678</snapshotId>
+            <encrypted>true</encrypted>
+          </ebs>
+        </item>
+      </blockDeviceMapping>
+    </item>
+  </imagesSet>
+</DescribeImagesResponse>`
+
+const describeEncryptedSnapshotResponse = `<DescribeSnapshotsResponse xmlns="http://ec2.amazonaws.com/doc/2016-11-15/">
+  <snapshotSet>
+    <item>
+      <snapshotId>snap-12345678</snapshotId>
+      <encrypted>true</encrypted>
+      <kmsKeyId>arn:aws:kms:us-east-1:111111111111:key/1234abcd-12ab-34cd-56ef-1234567890ab</kmsKeyId>
+    </item>
+  </snapshotSet>
+</DescribeSnapshotsResponse>`
+
+// centralAccountServer answers the EC2, STS and KMS calls of
+// StepCentralAccountCopy, and records the calls made with each access key and
+// their parameters. The snapshots are encrypted with a key managed by
+// keyManager.
+type centralAccountServer struct {
+	*httptest.Server
+
+	lock   sync.Mutex
+	calls  map[string][]string
+	params map[string]map[string]string
+}
+
+func newCentralAccountServer(t *testing.T, keyManager string) *centralAccountServer {
+	accessKeyRe := regexp.MustCompile(`Credential=([^/]+)/`)
+
+	s := &centralAccountServer{
+		calls:  map[string][]string{},
+		params: map[string]map[string]string{},
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		accessKey := ""
+		if m := accessKeyRe.FindStringSubmatch(r.Header.Get("Authorization")); m != nil {
+			accessKey = m[1]
+		}
+
+		// KMS speaks JSON, its action is in the target header.
+		if target := r.Header.Get("X-Amz-Target"); target != "" {
+			action := strings.TrimPrefix(target, "TrentService.")
+			s.lock.Lock()
+			s.calls[accessKey] = append(s.calls[accessKey], action)
+			s.lock.Unlock()
+			w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+			fmt.Fprintf(w, `{"KeyMetadata":{"KeyId":"1234abcd-12ab-34cd-56ef-1234567890ab","KeyManager":%q}}`, keyManager)
+			return
+		}
+
+		if err := r.ParseForm(); err != nil {
+			t.Errorf("unexpected request: %s", err)
+		}
+		action := r.Form.Get("Action")
+
+		s.lock.Lock()
+		s.calls[accessKey] = append(s.calls[accessKey], action)
+		s.params[action] = map[string]string{}
+		for key := range r.Form {
+			s.params[action][key] = r.Form.Get(key)
+		}
+		s.lock.Unlock()
+
+		switch action {
+		case "AssumeRole":
+			fmt.Fprint(w, assumeRoleResponse)
+		case "DescribeImages":
+			fmt.Fprintf(w, describeEncryptedImageResponse, r.Form.Get("ImageId.1"))
+		case "DescribeSnapshots":
+			fmt.Fprint(w, describeEncryptedSnapshotResponse)
+		case "CopyImage":
+			fmt.Fprint(w, `<CopyImageResponse><imageId>ami-central</imageId></CopyImageResponse>`)
+		default:
+			fmt.Fprintf(w, `<%sResponse><return>true</return></%sResponse>`, action, action)
+		}
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// newCentralAccountStep returns the step copying ami-12345678 through the
+// server, and its state.
+func newCentralAccountStep(t *testing.T, server *centralAccountServer) (*StepCentralAccountCopy, multistep.StateBag) {
+	sess, err := session.NewSession(&aws.Config{
+		Region:      aws.String("us-east-1"),
+		Endpoint:    aws.String(server.URL),
+		Credentials: credentials.NewStaticCredentials("BUILDKEY", "build-secret", ""),
+		MaxRetries:  aws.Int(0),
+	})
+	if err != nil {
+		t.Fatalf("failed to create session: %s", err)
+	}
+
+	state := new(multistep.BasicStateBag)
+	state.Put("ui", packersdk.TestUi(t))
+	state.Put("ec2", ec2.New(sess))
+	state.Put("amis", map[string]string{"us-east-1": "ami-12345678"})
+	state.Put("generated_data", map[string]interface{}{})
+
+	step := &StepCentralAccountCopy{
+		AccessConfig: &AccessConfig{session: sess},
+		Role: AssumeRoleConfig{
+			AssumeRoleARN:        "arn:aws:iam::222222222222:role/image-copier",
+			AssumeRoleExternalID: "hub",
+		},
+		KmsKeyId:      "alias/central",
+		Name:          "web",
+		Region:        "us-east-1",
+		PollingConfig: new(AWSPollingConfig),
+		GeneratedData: &packerbuilderdata.GeneratedData{State: state},
+	}
+	return step, state
+}
+
+func TestStepCentralAccountCopy(t *testing.T) {
+	server := newCentralAccountServer(t, "CUSTOMER")
+	step, state := newCentralAccountStep(t, server)
+	if action := step.Run(context.Background(), state); action != multistep.ActionContinue {
+		t.Fatalf("expected the copy to succeed, got %v", state.Get("error"))
+	}
+	if id := state.Get("central_ami"); id != "ami-central" {
+		t.Fatalf("expected the ID of the copy in the state, got %v", id)
+	}
+	if id := state.Get("generated_data").(map[string]interface{})["CentralAMI"]; id != "ami-central" {
+		t.Fatalf("expected the ID of the copy in the generated data, got %v", id)
+	}
+
+	// The AMI is shared and unshared with the build credentials, the copy
+	// is made with the credentials of the assumed role.
+	expected := map[string][]string{
+		"BUILDKEY": {
+			"DescribeImages", "DescribeSnapshots", "DescribeKey",
+			"ModifyImageAttribute", "ModifySnapshotAttribute",
+			"AssumeRole",
+			"ModifyImageAttribute", "ModifySnapshotAttribute",
+		},
+		"CENTRALKEY": {"CopyImage", "DescribeImages"},
+	}
+	for accessKey, actions := range expected {
+		if fmt.Sprint(server.calls[accessKey]) != fmt.Sprint(actions) {
+			t.Errorf("expected %s to call %v, got %v", accessKey, actions, server.calls[accessKey])
+		}
+	}
+
+	params := server.params
+	if params["AssumeRole"]["RoleArn"] != step.Role.AssumeRoleARN || params["AssumeRole"]["ExternalId"] != "hub" {
+		t.Errorf("unexpected AssumeRole parameters: %v", params["AssumeRole"])
+	}
+	if params["ModifySnapshotAttribute"]["CreateVolumePermission.Remove.1.UserId"] != "222222222222" {
+		t.Errorf("expected the snapshot to be unshared with the central account, got %v", params["ModifySnapshotAttribute"])
+	}
+	copyParams := params["CopyImage"]
+	if copyParams["SourceImageId"] != "ami-12345678" || copyParams["Encrypted"] != "true" || copyParams["KmsKeyId"] != "alias/central" {
+		t.Errorf("unexpected CopyImage parameters: %v", copyParams)
+	}
+
+	// The copy is kept when the build succeeds.
+	step.Cleanup(state)
+	if len(server.calls["CENTRALKEY"]) != 2 {
+		t.Errorf("expected the copy to be kept, got the calls %v", server.calls["CENTRALKEY"])
+	}
+}
+
+func TestStepCentralAccountCopy_AWSManagedKey(t *testing.T) {
+	server := newCentralAccountServer(t, "AWS")
+	step, state := newCentralAccountStep(t, server)
+	if action := step.Run(context.Background(), state); action != multistep.ActionHalt {
+		t.Fatal("expected the copy of an AMI encrypted with an AWS managed key to fail")
+	}
+	if err := state.Get("error").(error); !strings.Contains(err.Error(), "AWS managed KMS key") {
+		t.Errorf("unexpected error: %s", err)
+	}
+
+	// The AMI isn't shared.
+	expected := []string{"DescribeImages", "DescribeSnapshots", "DescribeKey"}
+	if fmt.Sprint(server.calls["BUILDKEY"]) != fmt.Sprint(expected) {
+		t.Errorf("expected the calls %v, got %v", expected, server.calls["BUILDKEY"])
+	}
+}
+
+func TestStepCentralAccountCopy_Cleanup(t *testing.T) {
+	server := newCentralAccountServer(t, "CUSTOMER")
+	step, state := newCentralAccountStep(t, server)
+	if action := step.Run(context.Background(), state); action != multistep.ActionContinue {
+		t.Fatalf("expected the copy to succeed, got %v", state.Get("error"))
+	}
+
+	// A later step fails, the copy and its snapshot are deleted with the
+	// credentials of the assumed role.
+	state.Put(multistep.StateHalted, true)
+	step.Cleanup(state)
+	expected := []string{"CopyImage", "DescribeImages", "DescribeImages", "DeregisterImage", "DeleteSnapshot"}
+	if fmt.Sprint(server.calls["CENTRALKEY"]) != fmt.Sprint(expected) {
+		t.Errorf("expected the calls %v, got %v", expected, server.calls["CENTRALKEY"])
+	}
+	if imageId := server.params["DeregisterImage"]["ImageId"]; imageId != "ami-central" {
+		t.Errorf("expected the copy to be deregistered, got %s", imageId)
+	}
+}
@@ -0,0 +1,294 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package common
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/arn"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/aws/aws-sdk-go/service/iam/iamiface"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/aws/aws-sdk-go/service/sts/stsiface"
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
+)
+
+// StepCheckIAMPermissions makes sure the build credentials are allowed to
+// call every action of the build before it starts, so that a long build
+// doesn't fail at its last step for a missing permission.
+type StepCheckIAMPermissions struct {
+	// Actions are the IAM actions the build calls, nothing is checked if
+	// empty.
+	Actions []string
+	// Region is the region the build runs in, policies conditioned on
+	// aws:RequestedRegion or scoped to the EC2 resources of a region are
+	// evaluated for it.
+	Region string
+}
+
+func (s *StepCheckIAMPermissions) Run(ctx context.Context, state multistep.StateBag) multistep.StepAction {
+	if len(s.Actions) == 0 {
+		return multistep.ActionContinue
+	}
+
+	ui := state.Get("ui").(packersdk.Ui)
+	iamconn := state.Get("iam").(iamiface.IAMAPI)
+	stsconn := sts.New(state.Get("awsSession").(*session.Session))
+
+	ui.Say("Checking the IAM permissions of the build credentials...")
+	warnings, err := checkIAMPermissions(stsconn, iamconn, s.Region, s.Actions)
+	for _, warning := range warnings {
+		ui.Error(fmt.Sprintf("Warning: %s", warning))
+	}
+	if err != nil {
+		state.Put("error", err)
+		ui.Error(err.Error())
+		return multistep.ActionHalt
+	}
+	return multistep.ActionContinue
+}
+
+// checkIAMPermissions simulates the policies of the caller for actions in
+// region, and reports all the actions it isn't allowed to call at once. The
+// actions whose decision depends on context keys the simulation doesn't know
+// the value of are only warned about, as are callers whose policies can't be
+// simulated. EC2 actions are simulated on any resource of the region, which
+// policies granting them on specific resources implicitly deny, so only
+// their explicit denials are errors.
+func checkIAMPermissions(stsconn stsiface.STSAPI, iamconn iamiface.IAMAPI, region string, actions []string) ([]string, error) {
+	principal, err := simulationPrincipalArn(stsconn, iamconn)
+	if err != nil {
+		var notSimulated *principalNotSimulatedError
+		if errors.As(err, &notSimulated) {
+			return []string{fmt.Sprintf("skipping the IAM permissions check: %s", err)}, nil
+		}
+		return nil, fmt.Errorf("Error checking IAM permissions: %s", err)
+	}
+	if principal == "" {
+		return nil, nil
+	}
+	parsed, err := arn.Parse(principal)
+	if err != nil {
+		return nil, fmt.Errorf("Error checking IAM permissions: %s", err)
+	}
+
+	// EC2 actions are simulated on the resources of the region of the
+	// build, the others, like IAM ones, on any resource.
+	resources := map[string][]*string{}
+	seen := map[string]bool{}
+	for _, action := range actions {
+		if seen[action] {
+			continue
+		}
+		seen[action] = true
+		resource := "*"
+		if strings.HasPrefix(action, "ec2:") && region != "" {
+			resource = arn.ARN{
+				Partition: parsed.Partition,
+				Service:   "ec2",
+				Region:    region,
+				AccountID: parsed.AccountID,
+				Resource:  "*",
+			}.String()
+		}
+		resources[resource] = append(resources[resource], aws.String(action))
+	}
+
+	var contextEntries []*iam.ContextEntry
+	if region != "" {
+		contextEntries = append(contextEntries, &iam.ContextEntry{
+			ContextKeyName:   aws.String("aws:RequestedRegion"),
+			ContextKeyType:   aws.String(iam.ContextKeyTypeEnumString),
+			ContextKeyValues: []*string{aws.String(region)},
+		})
+	}
+
+	var denied, warnings []string
+	for resource, names := range resources {
+		input := &iam.SimulatePrincipalPolicyInput{
+			PolicySourceArn: aws.String(principal),
+			ActionNames:     names,
+			ResourceArns:    []*string{aws.String(resource)},
+			ContextEntries:  contextEntries,
+		}
+		for {
+			resp, err := iamconn.SimulatePrincipalPolicy(input)
+			if err != nil {
+				return nil, fmt.Errorf("Error checking IAM permissions of %s: %s", principal, err)
+			}
+			for _, result := range resp.EvaluationResults {
+				decision := aws.StringValue(result.EvalDecision)
+				if decision == iam.PolicyEvaluationDecisionTypeAllowed {
+					continue
+				}
+				action := aws.StringValue(result.EvalActionName)
+				if missing := aws.StringValueSlice(result.MissingContextValues); len(missing) > 0 {
+					warnings = append(warnings, fmt.Sprintf("%s may not be allowed to call %s (%s), "+
+						"it depends on %s which can't be simulated", principal, action, decision, strings.Join(missing, ", ")))
+					continue
+				}
+				if resource != "*" && decision == iam.PolicyEvaluationDecisionTypeImplicitDeny {
+					warnings = append(warnings, fmt.Sprintf("%s may not be allowed to call %s (%s), "+
+						"it isn't allowed on every resource of %s, only the ones the build uses may be",
+						principal, action, decision, region))
+					continue
+				}
+				denied = append(denied, fmt.Sprintf("%s (%s)", action, decision))
+			}
+			if !aws.BoolValue(resp.IsTruncated) {
+				break
+			}
+			input.Marker = resp.Marker
+		}
+	}
+
+	if len(denied) > 0 {
+		sort.Strings(denied)
+		return warnings, fmt.Errorf("%s is not allowed to call actions the build needs: %s",
+			principal, strings.Join(denied, ", "))
+	}
+	return warnings, nil
+}
+
+// principalNotSimulatedError is returned for callers whose policies
+// SimulatePrincipalPolicy can't simulate.
+type principalNotSimulatedError struct {
+	callerArn string
+}
+
+func (e *principalNotSimulatedError) Error() string {
+	return fmt.Sprintf("the policies of %s can't be simulated", e.callerArn)
+}
+
+// simulationPrincipalArn returns the ARN of the IAM user or role the build
+// credentials belong to, or "" for the root user, which has every
+// permission.
+func simulationPrincipalArn(stsconn stsiface.STSAPI, iamconn iamiface.IAMAPI) (string, error) {
+	identity, err := stsconn.GetCallerIdentity(&sts.GetCallerIdentityInput{})
+	if err != nil {
+		return "", err
+	}
+	callerArn := aws.StringValue(identity.Arn)
+	parsed, err := arn.Parse(callerArn)
+	if err != nil {
+		return "", err
+	}
+
+	switch {
+	case parsed.Service == "iam" && parsed.Resource == "root":
+		log.Printf("[INFO] %s is the root user, skipping the IAM permissions check", callerArn)
+		return "", nil
+	case parsed.Service == "iam":
+		return callerArn, nil
+	case parsed.Service == "sts" && strings.HasPrefix(parsed.Resource, "assumed-role/"):
+		// The session ARN holds the role name but not its path, which
+		// the role ARN needs.
+		roleName := strings.Split(parsed.Resource, "/")[1]
+		resp, err := iamconn.GetRole(&iam.GetRoleInput{RoleName: aws.String(roleName)})
+		if err != nil {
+			return "", err
+		}
+		return aws.StringValue(resp.Role.Arn), nil
+	}
+	// Federated users get the intersection of the policies of the IAM user
+	// that federated them and of their session policy, which can't be
+	// simulated.
+	return "", &principalNotSimulatedError{callerArn: callerArn}
+}
+
+// IAMActions returns the IAM actions needed to run and stop the source
+// instance.
+func (c *RunConfig) IAMActions() []string {
+	actions := []string{
+		"ec2:CreateTags",
+		"ec2:DescribeImages",
+		"ec2:DescribeInstances",
+		"ec2:DescribeSubnets",
+		"ec2:RunInstances",
+		"ec2:TerminateInstances",
+	}
+	if c.IsSpotInstance() {
+		actions = append(actions,
+			"ec2:CreateFleet",
+			"ec2:CreateLaunchTemplate",
+			"ec2:DeleteLaunchTemplate",
+			"ec2:DescribeSpotPriceHistory",
+		)
+	}
+	if c.Comm.SSHTemporaryKeyPairName != "" {
+		actions = append(actions, "ec2:CreateKeyPair", "ec2:DeleteKeyPair")
+	}
+	if len(c.SecurityGroupIds) == 0 && c.SecurityGroupFilter.Empty() {
+		actions = append(actions,
+			"ec2:AuthorizeSecurityGroupIngress",
+			"ec2:CreateSecurityGroup",
+			"ec2:DeleteSecurityGroup",
+		)
+	}
+	if c.IamInstanceProfile != "" {
+		actions = append(actions, "iam:PassRole")
+		if !c.SkipProfileValidation {
+			actions = append(actions, "iam:GetInstanceProfile")
+		}
+	} else if c.TemporaryIamInstanceProfilePolicyDocument != nil {
+		actions = append(actions,
+			"iam:AddRoleToInstanceProfile",
+			"iam:CreateInstanceProfile",
+			"iam:CreateRole",
+			"iam:DeleteInstanceProfile",
+			"iam:DeleteRole",
+			"iam:DeleteRolePolicy",
+			"iam:PassRole",
+			"iam:PutRolePolicy",
+			"iam:RemoveRoleFromInstanceProfile",
+		)
+	}
+	if !c.DisableStopInstance {
+		actions = append(actions, "ec2:StopInstances")
+	}
+	return actions
+}
+
+// IAMActions returns the IAM actions needed to copy, share and protect the
+// AMI once it is created.
+func (c *AMIConfig) IAMActions() []string {
+	var actions []string
+	if c.AMIForceDeregister {
+		actions = append(actions, "ec2:DeregisterImage")
+		if c.AMIForceDeleteSnapshot {
+			actions = append(actions, "ec2:DeleteSnapshot")
+		}
+	}
+	if len(c.AMIRegions) > 0 {
+		actions = append(actions, "ec2:CopyImage")
+	}
+	if len(c.AMIUsers) > 0 || len(c.AMIGroups) > 0 || len(c.AMIOrgArns) > 0 ||
+		len(c.AMIOuArns) > 0 || len(c.AMIProductCodes) > 0 {
+		actions = append(actions, "ec2:ModifyImageAttribute")
+	}
+	if len(c.SnapshotUsers) > 0 || len(c.SnapshotGroups) > 0 {
+		actions = append(actions, "ec2:ModifySnapshotAttribute")
+	}
+	if c.DeprecationTime != "" {
+		actions = append(actions, "ec2:EnableImageDeprecation")
+	}
+	if c.DeregistrationProtection.Enabled {
+		actions = append(actions, "ec2:EnableImageDeregistrationProtection")
+	}
+	if c.AMICentralAccountRole.AssumeRoleARN != "" {
+		actions = append(actions, "ec2:ModifyImageAttribute", "ec2:ModifySnapshotAttribute", "sts:AssumeRole")
+	}
+	return actions
+}
+
+// Cleanup ...
+func (s *StepCheckIAMPermissions) Cleanup(multistep.StateBag) {}
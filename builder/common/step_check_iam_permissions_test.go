@@ -0,0 +1,191 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package common
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/aws/aws-sdk-go/service/iam/iamiface"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/aws/aws-sdk-go/service/sts/stsiface"
+)
+
+type mockSTSIdentityConn struct {
+	stsiface.STSAPI
+	arn string
+}
+
+func (m *mockSTSIdentityConn) GetCallerIdentity(*sts.GetCallerIdentityInput) (*sts.GetCallerIdentityOutput, error) {
+	return &sts.GetCallerIdentityOutput{Arn: aws.String(m.arn)}, nil
+}
+
+type mockIAMSimulateConn struct {
+	iamiface.IAMAPI
+	denied map[string]bool
+	// The actions a statement denies.
+	explicitlyDenied map[string]bool
+	// The actions whose decision depends on a context key that isn't
+	// given.
+	conditioned map[string]bool
+
+	simulatedArn string
+	inputs       []*iam.SimulatePrincipalPolicyInput
+}
+
+func (m *mockIAMSimulateConn) GetRole(input *iam.GetRoleInput) (*iam.GetRoleOutput, error) {
+	return &iam.GetRoleOutput{Role: &iam.Role{
+		Arn: aws.String("arn:aws:iam::123456789012:role/builds/" + aws.StringValue(input.RoleName)),
+	}}, nil
+}
+
+func (m *mockIAMSimulateConn) SimulatePrincipalPolicy(input *iam.SimulatePrincipalPolicyInput) (*iam.SimulatePolicyResponse, error) {
+	m.simulatedArn = aws.StringValue(input.PolicySourceArn)
+	m.inputs = append(m.inputs, input)
+	var results []*iam.EvaluationResult
+	for _, action := range input.ActionNames {
+		result := &iam.EvaluationResult{
+			EvalActionName: action,
+			EvalDecision:   aws.String(iam.PolicyEvaluationDecisionTypeAllowed),
+		}
+		if m.denied[aws.StringValue(action)] {
+			result.EvalDecision = aws.String(iam.PolicyEvaluationDecisionTypeImplicitDeny)
+		}
+		if m.explicitlyDenied[aws.StringValue(action)] {
+			result.EvalDecision = aws.String(iam.PolicyEvaluationDecisionTypeExplicitDeny)
+		}
+		if m.conditioned[aws.StringValue(action)] {
+			result.EvalDecision = aws.String(iam.PolicyEvaluationDecisionTypeImplicitDeny)
+			result.MissingContextValues = []*string{aws.String("ec2:ResourceTag/team")}
+		}
+		results = append(results, result)
+	}
+	return &iam.SimulatePolicyResponse{EvaluationResults: results}, nil
+}
+
+func TestCheckIAMPermissions(t *testing.T) {
+	actions := []string{"ec2:RunInstances", "ec2:CreateImage", "ec2:CreateTags", "ec2:RunInstances", "iam:PassRole"}
+
+	tests := []struct {
+		name             string
+		callerArn        string
+		denied           map[string]bool
+		explicitlyDenied map[string]bool
+		conditioned      map[string]bool
+		simulatedArn     string
+		expectDenied     []string
+		expectWarning    string
+	}{
+		{
+			name:         "user allowed everything",
+			callerArn:    "arn:aws:iam::123456789012:user/packer",
+			simulatedArn: "arn:aws:iam::123456789012:user/packer",
+		},
+		{
+			name:         "user denied one action",
+			callerArn:    "arn:aws:iam::123456789012:user/packer",
+			denied:       map[string]bool{"iam:PassRole": true},
+			simulatedArn: "arn:aws:iam::123456789012:user/packer",
+			expectDenied: []string{"iam:PassRole (implicitDeny)"},
+		},
+		{
+			name:             "assumed role denied two actions",
+			callerArn:        "arn:aws:sts::123456789012:assumed-role/packer/session",
+			explicitlyDenied: map[string]bool{"ec2:CreateImage": true, "ec2:CreateTags": true},
+			simulatedArn:     "arn:aws:iam::123456789012:role/builds/packer",
+			expectDenied:     []string{"ec2:CreateImage (explicitDeny)", "ec2:CreateTags (explicitDeny)"},
+		},
+		{
+			name:          "action only allowed on some resources",
+			callerArn:     "arn:aws:iam::123456789012:user/packer",
+			denied:        map[string]bool{"ec2:CreateImage": true},
+			simulatedArn:  "arn:aws:iam::123456789012:user/packer",
+			expectWarning: "ec2:CreateImage (implicitDeny), it isn't allowed on every resource of eu-west-1",
+		},
+		{
+			name:          "action depending on a missing context value",
+			callerArn:     "arn:aws:iam::123456789012:user/packer",
+			conditioned:   map[string]bool{"ec2:CreateTags": true},
+			simulatedArn:  "arn:aws:iam::123456789012:user/packer",
+			expectWarning: "ec2:CreateTags (implicitDeny), it depends on ec2:ResourceTag/team",
+		},
+		{
+			name:      "root user is not checked",
+			callerArn: "arn:aws:iam::123456789012:root",
+			denied:    map[string]bool{"ec2:CreateImage": true},
+		},
+		{
+			name:          "federated user is not checked",
+			callerArn:     "arn:aws:sts::123456789012:federated-user/packer",
+			denied:        map[string]bool{"ec2:CreateImage": true},
+			expectWarning: "federated-user/packer can't be simulated",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			iamconn := &mockIAMSimulateConn{denied: tt.denied, explicitlyDenied: tt.explicitlyDenied, conditioned: tt.conditioned}
+			warnings, err := checkIAMPermissions(&mockSTSIdentityConn{arn: tt.callerArn}, iamconn, "eu-west-1", actions)
+
+			if iamconn.simulatedArn != tt.simulatedArn {
+				t.Errorf("simulated the policies of %q, expected %q", iamconn.simulatedArn, tt.simulatedArn)
+			}
+			if tt.expectWarning == "" && len(warnings) > 0 {
+				t.Errorf("expected no warnings, got %v", warnings)
+			}
+			if tt.expectWarning != "" && (len(warnings) != 1 || !strings.Contains(warnings[0], tt.expectWarning)) {
+				t.Errorf("expected a warning about %q, got %v", tt.expectWarning, warnings)
+			}
+			if len(tt.expectDenied) == 0 {
+				if err != nil {
+					t.Fatalf("got unexpected error: %s", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatal("expected an error for the denied actions, got none")
+			}
+			for _, denied := range tt.expectDenied {
+				if !strings.Contains(err.Error(), denied) {
+					t.Errorf("expected %q to be reported, got %q", denied, err)
+				}
+			}
+			if strings.Contains(err.Error(), "ec2:RunInstances") {
+				t.Errorf("the allowed ec2:RunInstances should not be reported, got %q", err)
+			}
+		})
+	}
+}
+
+func TestCheckIAMPermissions_RegionAndResources(t *testing.T) {
+	iamconn := &mockIAMSimulateConn{}
+	stsconn := &mockSTSIdentityConn{arn: "arn:aws-us-gov:iam::123456789012:user/packer"}
+	actions := []string{"ec2:RunInstances", "iam:PassRole", "ec2:CreateImage"}
+	if _, err := checkIAMPermissions(stsconn, iamconn, "us-gov-west-1", actions); err != nil {
+		t.Fatalf("got unexpected error: %s", err)
+	}
+
+	simulated := map[string][]string{}
+	for _, input := range iamconn.inputs {
+		if len(input.ResourceArns) != 1 {
+			t.Fatalf("expected a single resource, got %v", aws.StringValueSlice(input.ResourceArns))
+		}
+		if len(input.ContextEntries) != 1 || aws.StringValue(input.ContextEntries[0].ContextKeyName) != "aws:RequestedRegion" ||
+			aws.StringValue(input.ContextEntries[0].ContextKeyValues[0]) != "us-gov-west-1" {
+			t.Fatalf("expected the region to be passed as aws:RequestedRegion, got %v", input.ContextEntries)
+		}
+		resource := aws.StringValue(input.ResourceArns[0])
+		simulated[resource] = append(simulated[resource], aws.StringValueSlice(input.ActionNames)...)
+	}
+	expected := map[string][]string{
+		"arn:aws-us-gov:ec2:us-gov-west-1:123456789012:*": {"ec2:RunInstances", "ec2:CreateImage"},
+		"*": {"iam:PassRole"},
+	}
+	if !reflect.DeepEqual(simulated, expected) {
+		t.Fatalf("expected the actions to be simulated on %v, got %v", expected, simulated)
+	}
+}
@@ -7,14 +7,18 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/aws/aws-sdk-go/service/ec2instanceconnect"
 	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/hashicorp/packer-plugin-amazon/builder/common/awserrors"
 	pssm "github.com/hashicorp/packer-plugin-amazon/builder/common/ssm"
 	"github.com/hashicorp/packer-plugin-sdk/communicator"
 	"github.com/hashicorp/packer-plugin-sdk/communicator/sshkey"
@@ -27,11 +31,16 @@ type StepCreateSSMTunnel struct {
 	AWSSession       *session.Session
 	Region           string
 	LocalPortNumber  int
+	LocalPortRange   string
 	RemotePortNumber int
 	SSMAgentEnabled  bool
 	SSHConfig        *communicator.SSH
 	PauseBeforeSSM   time.Duration
+	PollingConfig    *AWSPollingConfig
 	stopSSMCommand   func()
+	// portControl is called before binding a candidate local port; an error
+	// marks the port as unavailable.
+	portControl func(network, address string, c syscall.RawConn) error
 }
 
 // Run executes the Packer build step that creates a session tunnel.
@@ -72,11 +81,18 @@ func (s *StepCreateSSMTunnel) Run(ctx context.Context, state multistep.StateBag)
 
 	state.Put("sessionPort", s.LocalPortNumber)
 
+	ssmconn := ssm.New(s.AWSSession)
+	if err := s.waitForSSMInstance(ctx, ui, ssmconn, aws.StringValue(instance.InstanceId)); err != nil {
+		err := fmt.Errorf("error waiting for instance to be online in Systems Manager: %s", err)
+		state.Put("error", err)
+		ui.Error(err.Error())
+		return multistep.ActionHalt
+	}
+
 	ssmCtx, ssmCancel := context.WithCancel(ctx)
 	s.stopSSMCommand = ssmCancel
 	ec2Conn := state.Get("ec2").(*ec2.EC2)
 
-	ssmconn := ssm.New(s.AWSSession)
 	session := pssm.Session{
 		SvcClient:  ssmconn,
 		InstanceID: aws.StringValue(instance.InstanceId),
@@ -90,6 +106,20 @@ func (s *StepCreateSSMTunnel) Run(ctx context.Context, state multistep.StateBag)
 	return multistep.ActionContinue
 }
 
+// waitForSSMInstance waits for the SSM agent of the instance to register with
+// Systems Manager, as starting a session before it is online fails.
+func (s *StepCreateSSMTunnel) waitForSSMInstance(ctx context.Context, ui packersdk.Ui, ssmconn *ssm.SSM, instanceID string) error {
+	ui.Say(fmt.Sprintf("Waiting for instance %s to be online in Systems Manager...", instanceID))
+	err := s.PollingConfig.WaitUntilSSMInstanceOnline(ctx, ssmconn, instanceID)
+	if aerr, ok := err.(awserr.Error); ok && awserrors.Matches(aerr.OrigErr(), "AccessDeniedException", "") {
+		// Not being able to check the agent status shouldn't prevent the
+		// session from being started, it will be retried if not connected.
+		log.Printf("[WARN] Unable to check instance SSM status, starting the session anyway: %s", err)
+		return nil
+	}
+	return err
+}
+
 func (s *StepCreateSSMTunnel) CreatePersistentSSMSession(ctx context.Context, ui packersdk.Ui, session *pssm.Session, instance *ec2.Instance) {
 	sessionChan := make(chan struct{})
 
@@ -156,22 +186,32 @@ func (s *StepCreateSSMTunnel) Cleanup(state multistep.StateBag) {
 }
 
 // ConfigureLocalHostPort finds an available port on the localhost that can be used for the remote tunnel.
-// Defaults to using s.LocalPortNumber if it is set.
+// Defaults to using s.LocalPortNumber if it is set, or a port within s.LocalPortRange if that is set.
 func (s *StepCreateSSMTunnel) ConfigureLocalHostPort(ctx context.Context) error {
 	minPortNumber, maxPortNumber := 8000, 9000
 
 	if s.LocalPortNumber != 0 {
 		minPortNumber = s.LocalPortNumber
 		maxPortNumber = minPortNumber
+	} else if s.LocalPortRange != "" {
+		lowPort, highPort, err := parsePortRange(s.LocalPortRange)
+		if err != nil {
+			return err
+		}
+		// The listen range excludes its upper bound.
+		minPortNumber, maxPortNumber = lowPort, highPort+1
 	}
 
-	// Find an available TCP port for our HTTP server
-	l, err := net.ListenRangeConfig{
+	lc := net.ListenRangeConfig{
 		Min:     minPortNumber,
 		Max:     maxPortNumber,
 		Addr:    "0.0.0.0",
 		Network: "tcp",
-	}.Listen(ctx)
+	}
+	lc.Control = s.portControl
+
+	// Find an available TCP port for our HTTP server
+	l, err := lc.Listen(ctx)
 	if err != nil {
 		return err
 	}
@@ -184,3 +224,28 @@ func (s *StepCreateSSMTunnel) ConfigureLocalHostPort(ctx context.Context) error
 	return nil
 
 }
+
+// parsePortRange parses an inclusive port range formatted as "min-max".
+func parsePortRange(portRange string) (int, int, error) {
+	bounds := strings.Split(portRange, "-")
+	if len(bounds) != 2 {
+		return 0, 0, fmt.Errorf("%q is not formatted as min-max", portRange)
+	}
+
+	lowPort, err := strconv.Atoi(strings.TrimSpace(bounds[0]))
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid lower bound in %q: %s", portRange, err)
+	}
+	highPort, err := strconv.Atoi(strings.TrimSpace(bounds[1]))
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid upper bound in %q: %s", portRange, err)
+	}
+
+	if lowPort < 1 || highPort > 65535 {
+		return 0, 0, fmt.Errorf("ports in %q must be between 1 and 65535", portRange)
+	}
+	if lowPort > highPort {
+		return 0, 0, fmt.Errorf("lower bound of %q is greater than its upper bound", portRange)
+	}
+	return lowPort, highPort, nil
+}
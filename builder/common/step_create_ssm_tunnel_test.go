@@ -0,0 +1,136 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package common
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
+)
+
+// fakeSSMConn returns an SSM client that never reaches AWS: each
+// DescribeInstanceInformation call is answered by the next response.
+func fakeSSMConn(t *testing.T, responses []func(r *request.Request)) (*ssm.SSM, *int) {
+	calls := 0
+	conn := ssm.New(FakeSession())
+	FakeClient(conn.Client, func(r *request.Request) {
+		if _, ok := r.Params.(*ssm.DescribeInstanceInformationInput); !ok {
+			t.Fatalf("unexpected request: %#v", r.Params)
+		}
+		if calls >= len(responses) {
+			t.Fatalf("unexpected DescribeInstanceInformation call #%d", calls+1)
+		}
+		responses[calls](r)
+		calls++
+	})
+	return conn, &calls
+}
+
+func instanceInformation(pingStatus string) func(r *request.Request) {
+	return func(r *request.Request) {
+		out := r.Data.(*ssm.DescribeInstanceInformationOutput)
+		if pingStatus == "" {
+			return
+		}
+		out.InstanceInformationList = []*ssm.InstanceInformation{
+			{
+				InstanceId: aws.String("i-12345"),
+				PingStatus: aws.String(pingStatus),
+			},
+		}
+	}
+}
+
+func TestStepCreateSSMTunnel_WaitForSSMInstance(t *testing.T) {
+	conn, calls := fakeSSMConn(t, []func(r *request.Request){
+		func(r *request.Request) {
+			r.Error = awserr.New(ssm.ErrCodeInvalidInstanceId, "instance not registered", nil)
+		},
+		instanceInformation(""),
+		instanceInformation(ssm.PingStatusOnline),
+	})
+
+	step := &StepCreateSSMTunnel{
+		PollingConfig: &AWSPollingConfig{MaxAttempts: 5, DelaySeconds: 1},
+	}
+	if err := step.waitForSSMInstance(context.Background(), packersdk.TestUi(t), conn, "i-12345"); err != nil {
+		t.Fatalf("should not have error: %s", err)
+	}
+	if *calls != 3 {
+		t.Fatalf("expected 3 DescribeInstanceInformation calls, got %d", *calls)
+	}
+}
+
+func TestStepCreateSSMTunnel_WaitForSSMInstanceTimeout(t *testing.T) {
+	conn, _ := fakeSSMConn(t, []func(r *request.Request){
+		instanceInformation(""),
+		instanceInformation(ssm.PingStatusConnectionLost),
+	})
+
+	step := &StepCreateSSMTunnel{
+		PollingConfig: &AWSPollingConfig{MaxAttempts: 2, DelaySeconds: 1},
+	}
+	if err := step.waitForSSMInstance(context.Background(), packersdk.TestUi(t), conn, "i-12345"); err == nil {
+		t.Fatalf("should error when the instance never comes online")
+	}
+}
+
+func TestStepCreateSSMTunnel_WaitForSSMInstanceAccessDenied(t *testing.T) {
+	conn, calls := fakeSSMConn(t, []func(r *request.Request){
+		func(r *request.Request) {
+			r.Error = awserr.New("AccessDeniedException", "not authorized", nil)
+		},
+	})
+
+	step := &StepCreateSSMTunnel{
+		PollingConfig: &AWSPollingConfig{MaxAttempts: 5, DelaySeconds: 1},
+	}
+	if err := step.waitForSSMInstance(context.Background(), packersdk.TestUi(t), conn, "i-12345"); err != nil {
+		t.Fatalf("should not fail when the status can't be checked: %s", err)
+	}
+	if *calls != 1 {
+		t.Fatalf("expected a single DescribeInstanceInformation call, got %d", *calls)
+	}
+}
+
+func TestStepCreateSSMTunnel_ConfigureLocalHostPortRange(t *testing.T) {
+	// Let the OS pick a free port, and make the port below it look occupied.
+	l, err := net.Listen("tcp", "0.0.0.0:0")
+	if err != nil {
+		t.Fatalf("failed to find a free port: %s", err)
+	}
+	free := l.Addr().(*net.TCPAddr).Port
+	l.Close()
+	occupied := free - 1
+
+	var checked []string
+	step := &StepCreateSSMTunnel{
+		LocalPortRange: fmt.Sprintf("%d-%d", occupied, free),
+		portControl: func(network, address string, c syscall.RawConn) error {
+			checked = append(checked, address)
+			if address == net.JoinHostPort("0.0.0.0", fmt.Sprint(occupied)) {
+				return syscall.EADDRINUSE
+			}
+			return nil
+		},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := step.ConfigureLocalHostPort(ctx); err != nil {
+		t.Fatalf("should not have error: %s", err)
+	}
+	if step.LocalPortNumber != free {
+		t.Fatalf("expected port %d to be selected, got %d (checked %v)", free, step.LocalPortNumber, checked)
+	}
+}
@@ -6,11 +6,13 @@ package common
 import (
 	"context"
 	"fmt"
+	"sort"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
 	"github.com/hashicorp/packer-plugin-amazon/builder/common/awserrors"
 	"github.com/hashicorp/packer-plugin-sdk/multistep"
 	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
@@ -18,12 +20,27 @@ import (
 	"github.com/hashicorp/packer-plugin-sdk/template/interpolate"
 )
 
+// copiedToTagKey is the tag listing the copies of the build region AMI.
+const copiedToTagKey = "CopiedTo"
+
+// maxTagValueLength is the longest value EC2 accepts for a tag.
+const maxTagValueLength = 256
+
 type StepCreateTags struct {
 	AMISkipCreateImage bool
+	TagCopyRegions     bool
 
 	Tags         map[string]string
 	SnapshotTags map[string]string
 	Ctx          interpolate.Context
+
+	getRegionConn func(*session.Session, string) ec2iface.EC2API
+}
+
+func getSessionRegionConn(session *session.Session, region string) ec2iface.EC2API {
+	return ec2.New(session, &aws.Config{
+		Region: aws.String(region),
+	})
 }
 
 func (s *StepCreateTags) Run(ctx context.Context, state multistep.StateBag) multistep.StepAction {
@@ -38,17 +55,30 @@ func (s *StepCreateTags) Run(ctx context.Context, state multistep.StateBag) mult
 
 	amis := state.Get("amis").(map[string]string)
 
+	if s.getRegionConn == nil {
+		s.getRegionConn = getSessionRegionConn
+	}
+
+	if s.TagCopyRegions {
+		if err := s.tagCopyRegions(ctx, ui, session, *ec2conn.Config.Region, amis); err != nil {
+			err := fmt.Errorf("Error tagging AMI with its copies: %s", err)
+			state.Put("error", err)
+			ui.Error(err.Error())
+			return multistep.ActionHalt
+		}
+	}
+
 	if len(s.Tags) == 0 && len(s.SnapshotTags) == 0 {
 		return multistep.ActionContinue
 	}
 
-	// Adds tags to AMIs and snapshots
+	// Adds tags to AMIs and snapshots, including the AMIs copied to other
+	// regions, whose snapshots are discovered through their block device
+	// mappings.
 	for region, ami := range amis {
 		ui.Say(fmt.Sprintf("Adding tags to AMI (%s)...", ami))
 
-		regionConn := ec2.New(session, &aws.Config{
-			Region: aws.String(region),
-		})
+		regionConn := s.getRegionConn(session, region)
 
 		// Retrieve image list for given AMI
 		resourceIds := []*string{&ami}
@@ -144,6 +174,67 @@ func (s *StepCreateTags) Run(ctx context.Context, state multistep.StateBag) mult
 	return multistep.ActionContinue
 }
 
+// tagCopyRegions tags the AMI of the build region with the IDs of the AMIs
+// copied to the other regions.
+func (s *StepCreateTags) tagCopyRegions(ctx context.Context, ui packersdk.Ui, session *session.Session, buildRegion string, amis map[string]string) error {
+	buildAmi, ok := amis[buildRegion]
+	if !ok {
+		return nil
+	}
+
+	copies := make([]string, 0, len(amis))
+	for region, ami := range amis {
+		if region != buildRegion {
+			copies = append(copies, fmt.Sprintf("%s=%s", region, ami))
+		}
+	}
+	if len(copies) == 0 {
+		return nil
+	}
+	sort.Strings(copies)
+
+	tags := copiedToTags(copies)
+	ui.Say(fmt.Sprintf("Adding tags to AMI (%s) listing its copies...", buildAmi))
+	EC2Tags(tags).Report(ui)
+
+	regionConn := s.getRegionConn(session, buildRegion)
+	return retry.Config{Tries: 11, ShouldRetry: func(err error) bool {
+		return awserrors.Matches(err, "InvalidAMIID.NotFound", "")
+	},
+		RetryDelay: (&retry.Backoff{InitialBackoff: 200 * time.Millisecond, MaxBackoff: 30 * time.Second, Multiplier: 2}).Linear,
+	}.Run(ctx, func(ctx context.Context) error {
+		_, err := regionConn.CreateTags(&ec2.CreateTagsInput{
+			Resources: []*string{aws.String(buildAmi)},
+			Tags:      tags,
+		})
+		return err
+	})
+}
+
+// copiedToTags joins the copies into CopiedTo tags, spreading them across
+// CopiedTo, CopiedTo2, ... tags when they don't fit in a single tag value.
+func copiedToTags(copies []string) []*ec2.Tag {
+	var values []string
+	for _, c := range copies {
+		last := len(values) - 1
+		if last >= 0 && len(values[last])+len(c)+1 <= maxTagValueLength {
+			values[last] = values[last] + "," + c
+			continue
+		}
+		values = append(values, c)
+	}
+
+	tags := make([]*ec2.Tag, 0, len(values))
+	for i, value := range values {
+		key := copiedToTagKey
+		if i > 0 {
+			key = fmt.Sprintf("%s%d", copiedToTagKey, i+1)
+		}
+		tags = append(tags, &ec2.Tag{Key: aws.String(key), Value: aws.String(value)})
+	}
+	return tags
+}
+
 func (s *StepCreateTags) Cleanup(state multistep.StateBag) {
 	// No cleanup...
 }
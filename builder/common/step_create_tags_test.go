@@ -0,0 +1,165 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package common
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
+)
+
+type createTagsEC2Conn struct {
+	ec2iface.EC2API
+
+	region           string
+	createTagsInputs []*ec2.CreateTagsInput
+}
+
+func (m *createTagsEC2Conn) DescribeImages(input *ec2.DescribeImagesInput) (*ec2.DescribeImagesOutput, error) {
+	return &ec2.DescribeImagesOutput{
+		Images: []*ec2.Image{
+			{
+				ImageId: input.ImageIds[0],
+				BlockDeviceMappings: []*ec2.BlockDeviceMapping{
+					{
+						DeviceName: aws.String("/dev/xvda"),
+						Ebs: &ec2.EbsBlockDevice{
+							SnapshotId: aws.String(fmt.Sprintf("snap-%s", m.region)),
+						},
+					},
+				},
+			},
+		},
+	}, nil
+}
+
+func (m *createTagsEC2Conn) CreateTags(input *ec2.CreateTagsInput) (*ec2.CreateTagsOutput, error) {
+	m.createTagsInputs = append(m.createTagsInputs, input)
+	return &ec2.CreateTagsOutput{}, nil
+}
+
+func TestStepCreateTags_CopiedRegionSnapshots(t *testing.T) {
+	sess := session.Must(session.NewSession(&aws.Config{Region: aws.String("us-east-1")}))
+
+	state := new(multistep.BasicStateBag)
+	state.Put("ui", packersdk.TestUi(t))
+	state.Put("ec2", ec2.New(sess))
+	state.Put("awsSession", sess)
+	state.Put("amis", map[string]string{
+		"us-east-1": "ami-original",
+		"us-west-2": "ami-copied",
+	})
+
+	conns := map[string]*createTagsEC2Conn{}
+	step := &StepCreateTags{
+		Tags: map[string]string{"Name": "packer"},
+		getRegionConn: func(_ *session.Session, region string) ec2iface.EC2API {
+			conn := &createTagsEC2Conn{region: region}
+			conns[region] = conn
+			return conn
+		},
+	}
+
+	if action := step.Run(context.Background(), state); action != multistep.ActionContinue {
+		t.Fatalf("should continue, got %v: %v", action, state.Get("error"))
+	}
+
+	conn, ok := conns["us-west-2"]
+	if !ok {
+		t.Fatalf("expected a connection to the copied region")
+	}
+	if len(conn.createTagsInputs) != 1 {
+		t.Fatalf("expected 1 CreateTags call in the copied region, got %d", len(conn.createTagsInputs))
+	}
+
+	input := conn.createTagsInputs[0]
+	resources := aws.StringValueSlice(input.Resources)
+	if len(resources) != 2 || resources[0] != "ami-copied" || resources[1] != "snap-us-west-2" {
+		t.Fatalf("expected the copied AMI and its snapshot to be tagged, got %v", resources)
+	}
+	if len(input.Tags) != 1 || aws.StringValue(input.Tags[0].Key) != "Name" ||
+		aws.StringValue(input.Tags[0].Value) != "packer" {
+		t.Fatalf("unexpected tags: %v", input.Tags)
+	}
+}
+
+func TestStepCreateTags_TagCopyRegions(t *testing.T) {
+	sess := session.Must(session.NewSession(&aws.Config{Region: aws.String("us-east-1")}))
+
+	state := new(multistep.BasicStateBag)
+	state.Put("ui", packersdk.TestUi(t))
+	state.Put("ec2", ec2.New(sess))
+	state.Put("awsSession", sess)
+	state.Put("amis", map[string]string{
+		"us-east-1": "ami-build",
+		"us-west-2": "ami-west",
+		"eu-west-1": "ami-eu",
+	})
+
+	conns := map[string]*createTagsEC2Conn{}
+	step := &StepCreateTags{
+		TagCopyRegions: true,
+		getRegionConn: func(_ *session.Session, region string) ec2iface.EC2API {
+			conn := &createTagsEC2Conn{region: region}
+			conns[region] = conn
+			return conn
+		},
+	}
+
+	if action := step.Run(context.Background(), state); action != multistep.ActionContinue {
+		t.Fatalf("should continue, got %v: %v", action, state.Get("error"))
+	}
+
+	if len(conns) != 1 {
+		t.Fatalf("only the build region AMI should be tagged, got connections to %d regions", len(conns))
+	}
+	conn, ok := conns["us-east-1"]
+	if !ok || len(conn.createTagsInputs) != 1 {
+		t.Fatalf("expected 1 CreateTags call in the build region")
+	}
+
+	input := conn.createTagsInputs[0]
+	resources := aws.StringValueSlice(input.Resources)
+	if len(resources) != 1 || resources[0] != "ami-build" {
+		t.Fatalf("expected the build AMI to be tagged, got %v", resources)
+	}
+	if len(input.Tags) != 1 || aws.StringValue(input.Tags[0].Key) != "CopiedTo" ||
+		aws.StringValue(input.Tags[0].Value) != "eu-west-1=ami-eu,us-west-2=ami-west" {
+		t.Fatalf("unexpected tags: %v", input.Tags)
+	}
+}
+
+func TestCopiedToTags_Split(t *testing.T) {
+	var copies []string
+	for i := 0; i < 12; i++ {
+		copies = append(copies, fmt.Sprintf("region-%02d=ami-0123456789abcdef0", i))
+	}
+
+	tags := copiedToTags(copies)
+	if len(tags) != 2 {
+		t.Fatalf("expected the copies to be split across 2 tags, got %d", len(tags))
+	}
+	if aws.StringValue(tags[0].Key) != "CopiedTo" || aws.StringValue(tags[1].Key) != "CopiedTo2" {
+		t.Fatalf("unexpected tag keys: %v", tags)
+	}
+
+	var joined []string
+	for _, tag := range tags {
+		if len(aws.StringValue(tag.Value)) > maxTagValueLength {
+			t.Fatalf("tag %s is longer than %d characters", aws.StringValue(tag.Key), maxTagValueLength)
+		}
+		joined = append(joined, aws.StringValue(tag.Value))
+	}
+	if strings.Join(joined, ",") != strings.Join(copies, ",") {
+		t.Fatalf("copies were lost while splitting: %v", joined)
+	}
+}
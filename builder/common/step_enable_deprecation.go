@@ -6,22 +6,28 @@ package common
 import (
 	"context"
 	"fmt"
+	"log"
 	"time"
 
+	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
 	"github.com/hashicorp/packer-plugin-sdk/multistep"
 	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
 )
 
 type StepEnableDeprecation struct {
-	AccessConfig       *AccessConfig
-	DeprecationTime    string
-	AMISkipCreateImage bool
+	AccessConfig          *AccessConfig
+	DeprecationTime       string
+	DeprecatePreviousTags map[string]string
+	AMISkipCreateImage    bool
+
+	getRegionConn func(*AccessConfig, string) (ec2iface.EC2API, error)
 }
 
 func (s *StepEnableDeprecation) Run(ctx context.Context, state multistep.StateBag) multistep.StepAction {
 	ui := state.Get("ui").(packersdk.Ui)
-	if s.AMISkipCreateImage || s.DeprecationTime == "" {
+	if s.AMISkipCreateImage || (s.DeprecationTime == "" && len(s.DeprecatePreviousTags) == 0) {
 		ui.Say("Skipping Enable AMI deprecation...")
 		return multistep.ActionContinue
 	}
@@ -34,11 +40,13 @@ func (s *StepEnableDeprecation) Run(ctx context.Context, state multistep.StateBa
 		return multistep.ActionHalt
 	}
 
-	deprecationTime, _ := time.Parse(time.RFC3339, s.DeprecationTime)
-	for region, ami := range amis {
-		ui.Say(fmt.Sprintf("Enabling deprecation on AMI (%s) in region %q ...", ami, region))
+	if s.getRegionConn == nil {
+		s.getRegionConn = GetRegionConn
+	}
 
-		conn, err := GetRegionConn(s.AccessConfig, region)
+	now := time.Now()
+	for region, ami := range amis {
+		conn, err := s.getRegionConn(s.AccessConfig, region)
 		if err != nil {
 			err := fmt.Errorf("failed to connect to region %s: %s", region, err)
 			state.Put("error", err.Error())
@@ -46,19 +54,83 @@ func (s *StepEnableDeprecation) Run(ctx context.Context, state multistep.StateBa
 			return multistep.ActionHalt
 		}
 
-		_, err = conn.EnableImageDeprecation(&ec2.EnableImageDeprecationInput{
-			ImageId:     &ami,
-			DeprecateAt: &deprecationTime,
+		if s.DeprecationTime != "" {
+			ui.Say(fmt.Sprintf("Enabling deprecation on AMI (%s) in region %q ...", ami, region))
+
+			// The time was validated in Prepare, a duration is relative to
+			// the completion of the build.
+			deprecationTime, _ := deprecationTime(s.DeprecationTime, now)
+			_, err = conn.EnableImageDeprecation(&ec2.EnableImageDeprecationInput{
+				ImageId:     aws.String(ami),
+				DeprecateAt: &deprecationTime,
+			})
+			if err != nil {
+				err := fmt.Errorf("Error enable AMI deprecation: %s", err)
+				state.Put("error", err)
+				ui.Error(err.Error())
+				return multistep.ActionHalt
+			}
+		}
+
+		if len(s.DeprecatePreviousTags) > 0 {
+			if err := s.deprecatePrevious(ui, conn, region, amis, now); err != nil {
+				err := fmt.Errorf("Error deprecating previous AMIs in region %q: %s", region, err)
+				state.Put("error", err)
+				ui.Error(err.Error())
+				return multistep.ActionHalt
+			}
+		}
+	}
+	return multistep.ActionContinue
+}
+
+// deprecatePrevious deprecates the AMIs of the account in region that carry
+// DeprecatePreviousTags, other than the AMIs of this build.
+func (s *StepEnableDeprecation) deprecatePrevious(ui packersdk.Ui, conn ec2iface.EC2API, region string, amis map[string]string, now time.Time) error {
+	input := &ec2.DescribeImagesInput{
+		Owners: []*string{aws.String("self")},
+	}
+	for k, v := range s.DeprecatePreviousTags {
+		input.Filters = append(input.Filters, &ec2.Filter{
+			Name:   aws.String("tag:" + k),
+			Values: []*string{aws.String(v)},
+		})
+	}
+	resp, err := conn.DescribeImages(input)
+	if err != nil {
+		return err
+	}
+
+	built := make(map[string]bool)
+	for _, ami := range amis {
+		built[ami] = true
+	}
+
+	// EC2 refuses deprecation times in the past, so the previous AMIs are
+	// deprecated as soon as it allows.
+	deprecateAt := now.Add(time.Minute)
+	for _, image := range resp.Images {
+		imageId := aws.StringValue(image.ImageId)
+		if built[imageId] {
+			continue
+		}
+		if t, err := time.Parse(time.RFC3339, aws.StringValue(image.DeprecationTime)); err == nil && !t.After(deprecateAt) {
+			log.Printf("AMI %s is already deprecated at %s", imageId, t)
+			continue
+		}
+
+		ui.Say(fmt.Sprintf("Deprecating previous AMI (%s) in region %q ...", imageId, region))
+		_, err := conn.EnableImageDeprecation(&ec2.EnableImageDeprecationInput{
+			ImageId:     image.ImageId,
+			DeprecateAt: &deprecateAt,
 		})
 		if err != nil {
-			err := fmt.Errorf("Error enable AMI deprecation: %s", err)
-			state.Put("error", err)
-			ui.Error(err.Error())
-			return multistep.ActionHalt
+			return fmt.Errorf("deprecating %s: %s", imageId, err)
 		}
 	}
-	return multistep.ActionContinue
+	return nil
 }
+
 func (s *StepEnableDeprecation) Cleanup(state multistep.StateBag) {
 	// No cleanup...
 }
@@ -0,0 +1,94 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package common
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
+)
+
+type deprecationEC2Conn struct {
+	ec2iface.EC2API
+
+	images               []*ec2.Image
+	describeImagesInputs []*ec2.DescribeImagesInput
+	deprecations         map[string]time.Time
+}
+
+func (m *deprecationEC2Conn) DescribeImages(input *ec2.DescribeImagesInput) (*ec2.DescribeImagesOutput, error) {
+	m.describeImagesInputs = append(m.describeImagesInputs, input)
+	return &ec2.DescribeImagesOutput{Images: m.images}, nil
+}
+
+func (m *deprecationEC2Conn) EnableImageDeprecation(input *ec2.EnableImageDeprecationInput) (*ec2.EnableImageDeprecationOutput, error) {
+	m.deprecations[*input.ImageId] = *input.DeprecateAt
+	return &ec2.EnableImageDeprecationOutput{}, nil
+}
+
+func TestStepEnableDeprecation_RotatePrevious(t *testing.T) {
+	conn := &deprecationEC2Conn{
+		images: []*ec2.Image{
+			{ImageId: aws.String("ami-new")},
+			{ImageId: aws.String("ami-old")},
+			{
+				ImageId:         aws.String("ami-older"),
+				DeprecationTime: aws.String(time.Now().Add(-time.Hour).UTC().Format(time.RFC3339)),
+			},
+		},
+		deprecations: make(map[string]time.Time),
+	}
+
+	state := new(multistep.BasicStateBag)
+	state.Put("ui", packersdk.TestUi(t))
+	state.Put("amis", map[string]string{"us-east-1": "ami-new"})
+
+	step := &StepEnableDeprecation{
+		DeprecationTime:       "720h",
+		DeprecatePreviousTags: map[string]string{"Family": "web"},
+		getRegionConn: func(_ *AccessConfig, region string) (ec2iface.EC2API, error) {
+			return conn, nil
+		},
+	}
+	before := time.Now()
+	if action := step.Run(context.Background(), state); action != multistep.ActionContinue {
+		t.Fatalf("step should continue, got %v: %v", action, state.Get("error"))
+	}
+
+	newDeprecation, ok := conn.deprecations["ami-new"]
+	if !ok {
+		t.Fatal("the new AMI should be deprecated")
+	}
+	if newDeprecation.Before(before.Add(720*time.Hour)) || newDeprecation.After(time.Now().Add(720*time.Hour)) {
+		t.Fatalf("the new AMI should be deprecated 720h after the build, got %s", newDeprecation)
+	}
+
+	oldDeprecation, ok := conn.deprecations["ami-old"]
+	if !ok {
+		t.Fatal("the previous AMI should be deprecated")
+	}
+	if !oldDeprecation.Before(newDeprecation) || oldDeprecation.After(time.Now().Add(time.Minute)) {
+		t.Fatalf("the previous AMI should be deprecated right away, got %s", oldDeprecation)
+	}
+	if _, ok := conn.deprecations["ami-older"]; ok {
+		t.Fatal("an AMI already deprecated should be left alone")
+	}
+	if len(conn.deprecations) != 2 {
+		t.Fatalf("unexpected deprecations: %v", conn.deprecations)
+	}
+
+	input := conn.describeImagesInputs[0]
+	if len(input.Owners) != 1 || *input.Owners[0] != "self" {
+		t.Fatalf("only the AMIs of the account should be looked up, got %v", input.Owners)
+	}
+	if len(input.Filters) != 1 || *input.Filters[0].Name != "tag:Family" || *input.Filters[0].Values[0] != "web" {
+		t.Fatalf("the AMIs should be filtered by tag, got %v", input.Filters)
+	}
+}
@@ -6,10 +6,14 @@ package common
 import (
 	"context"
 	"fmt"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/aws/aws-sdk-go/service/ssm/ssmiface"
+	awscommon "github.com/hashicorp/packer-plugin-amazon/common"
 	"github.com/hashicorp/packer-plugin-sdk/multistep"
 	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
 	"github.com/hashicorp/packer-plugin-sdk/packerbuilderdata"
@@ -19,18 +23,27 @@ import (
 type StepModifyAMIAttributes struct {
 	AMISkipCreateImage bool
 
-	Users          []string
-	Groups         []string
-	OrgArns        []string
-	OuArns         []string
-	SnapshotUsers  []string
-	SnapshotGroups []string
-	ProductCodes   []string
-	IMDSSupport    string
-	Description    string
-	Ctx            interpolate.Context
+	Users             []string
+	UsersSSMParameter string
+	Groups            []string
+	OrgArns           []string
+	OuArns            []string
+	SnapshotUsers     []string
+	SnapshotGroups    []string
+	ProductCodes      []string
+	ShareDuration     time.Duration
+	IMDSSupport       string
+	Description       string
+	WaitForSnapshots  bool
+	PollingConfig     *AWSPollingConfig
+	Ctx               interpolate.Context
 
 	GeneratedData *packerbuilderdata.GeneratedData
+
+	// ssmconn reads UsersSSMParameter, it is only set by tests.
+	ssmconn ssmiface.SSMAPI
+	// regionConn returns the EC2 client of a region, it is only set by tests.
+	regionConn func(region string) *ec2.EC2
 }
 
 func (s *StepModifyAMIAttributes) Run(ctx context.Context, state multistep.StateBag) multistep.StepAction {
@@ -38,11 +51,31 @@ func (s *StepModifyAMIAttributes) Run(ctx context.Context, state multistep.State
 	session := state.Get("awsSession").(*session.Session)
 	ui := state.Get("ui").(packersdk.Ui)
 
+	// UnshareAfter is declared by the builders, it stays empty without
+	// ami_share_duration.
+	if s.GeneratedData != nil {
+		s.GeneratedData.Put("UnshareAfter", "")
+	}
+
 	if s.AMISkipCreateImage {
 		ui.Say("Skipping AMI modify attributes...")
 		return multistep.ActionContinue
 	}
 
+	if s.UsersSSMParameter != "" {
+		ssmconn := s.ssmconn
+		if ssmconn == nil {
+			ssmconn = ssm.New(session)
+		}
+		users, err := loadSSMAccountIds(ssmconn, s.UsersSSMParameter)
+		if err != nil {
+			state.Put("error", err)
+			ui.Error(err.Error())
+			return multistep.ActionHalt
+		}
+		s.Users = append(s.Users, users...)
+	}
+
 	amis := state.Get("amis").(map[string]string)
 	snapshots := state.Get("snapshots").(map[string][]string)
 
@@ -201,12 +234,18 @@ func (s *StepModifyAMIAttributes) Run(ctx context.Context, state multistep.State
 		}
 	}
 
+	if s.WaitForSnapshots {
+		if err := s.waitForSnapshots(ctx, session, ui, snapshots); err != nil {
+			state.Put("error", err)
+			ui.Error(err.Error())
+			return multistep.ActionHalt
+		}
+	}
+
 	// Modifying image attributes
 	for region, ami := range amis {
 		ui.Say(fmt.Sprintf("Modifying attributes on AMI (%s)...", ami))
-		regionConn := ec2.New(session, &aws.Config{
-			Region: aws.String(region),
-		})
+		regionConn := s.getRegionConn(session, region)
 		for name, input := range options {
 			ui.Message(fmt.Sprintf("Modifying: %s", name))
 			input.ImageId = &ami
@@ -220,13 +259,23 @@ func (s *StepModifyAMIAttributes) Run(ctx context.Context, state multistep.State
 		}
 	}
 
+	if s.ShareDuration > 0 {
+		unshareAfter, err := s.tagUnshareAfter(session, ui, amis, time.Now())
+		if err != nil {
+			state.Put("error", err)
+			ui.Error(err.Error())
+			return multistep.ActionHalt
+		}
+		if s.GeneratedData != nil {
+			s.GeneratedData.Put("UnshareAfter", unshareAfter)
+		}
+	}
+
 	// Modifying snapshot attributes
 	for region, region_snapshots := range snapshots {
 		for _, snapshot := range region_snapshots {
 			ui.Say(fmt.Sprintf("Modifying attributes on snapshot (%s)...", snapshot))
-			regionConn := ec2.New(session, &aws.Config{
-				Region: aws.String(region),
-			})
+			regionConn := s.getRegionConn(session, region)
 			for name, input := range snapshotOptions {
 				ui.Message(fmt.Sprintf("Modifying: %s", name))
 				input.SnapshotId = &snapshot
@@ -244,6 +293,59 @@ func (s *StepModifyAMIAttributes) Run(ctx context.Context, state multistep.State
 	return multistep.ActionContinue
 }
 
+// tagUnshareAfter tags the amis with when their sharing should be revoked,
+// ShareDuration after now, and returns that time. The sharing isn't revoked
+// by Packer, external automation can use the tag to do so.
+func (s *StepModifyAMIAttributes) tagUnshareAfter(session *session.Session, ui packersdk.Ui, amis map[string]string, now time.Time) (string, error) {
+	unshareAfter := now.UTC().Add(s.ShareDuration).Format(time.RFC3339)
+	for region, ami := range amis {
+		ui.Say(fmt.Sprintf("Tagging AMI (%s) with %s=%s...", ami, awscommon.UnshareAfterTagKey, unshareAfter))
+		_, err := s.getRegionConn(session, region).CreateTags(&ec2.CreateTagsInput{
+			Resources: []*string{aws.String(ami)},
+			Tags: []*ec2.Tag{{
+				Key:   aws.String(awscommon.UnshareAfterTagKey),
+				Value: aws.String(unshareAfter),
+			}},
+		})
+		if err != nil {
+			return "", fmt.Errorf("Error tagging AMI (%s): %s", ami, err)
+		}
+	}
+	ui.Say(fmt.Sprintf("The sharing of the AMIs isn't revoked automatically, revoke it after %s", unshareAfter))
+	return unshareAfter, nil
+}
+
+func (s *StepModifyAMIAttributes) getRegionConn(session *session.Session, region string) *ec2.EC2 {
+	if s.regionConn != nil {
+		return s.regionConn(region)
+	}
+	return ec2.New(session, &aws.Config{
+		Region: aws.String(region),
+	})
+}
+
+// waitForSnapshots waits for the snapshots of the AMIs to reach 100%, so
+// that they are only shared once complete.
+func (s *StepModifyAMIAttributes) waitForSnapshots(ctx context.Context, session *session.Session, ui packersdk.Ui, snapshots map[string][]string) error {
+	for region, regionSnapshots := range snapshots {
+		regionConn := s.getRegionConn(session, region)
+		for _, snapshot := range regionSnapshots {
+			ui.Say(fmt.Sprintf("Waiting for snapshot (%s) to complete before sharing...", snapshot))
+			lastProgress := ""
+			err := s.PollingConfig.WaitUntilSnapshotDoneWithProgress(ctx, regionConn, snapshot, func(progress string) {
+				if progress != lastProgress {
+					ui.Message(fmt.Sprintf("Snapshot (%s) progress: %s", snapshot, progress))
+					lastProgress = progress
+				}
+			})
+			if err != nil {
+				return fmt.Errorf("Error waiting for snapshot (%s) to complete: %s", snapshot, err)
+			}
+		}
+	}
+	return nil
+}
+
 func (s *StepModifyAMIAttributes) Cleanup(state multistep.StateBag) {
 	// No cleanup...
 }
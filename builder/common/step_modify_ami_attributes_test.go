@@ -0,0 +1,119 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package common
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
+	"github.com/hashicorp/packer-plugin-sdk/packerbuilderdata"
+)
+
+func TestStepModifyAMIAttributes_WaitForSnapshots(t *testing.T) {
+	// The AMI is available, but its snapshot only completes on the second
+	// poll.
+	var calls []string
+	polls := 0
+	conn := FakeEC2Conn(func(r *request.Request) {
+		switch in := r.Params.(type) {
+		case *ec2.DescribeSnapshotsInput:
+			polls++
+			snapshot := &ec2.Snapshot{
+				SnapshotId: in.SnapshotIds[0],
+				State:      aws.String(ec2.SnapshotStatePending),
+				Progress:   aws.String("40%"),
+			}
+			if polls > 1 {
+				snapshot.State = aws.String(ec2.SnapshotStateCompleted)
+				snapshot.Progress = aws.String("100%")
+				calls = append(calls, fmt.Sprintf("%s completed", aws.StringValue(in.SnapshotIds[0])))
+			}
+			r.Data.(*ec2.DescribeSnapshotsOutput).Snapshots = []*ec2.Snapshot{snapshot}
+		case *ec2.ModifyImageAttributeInput:
+			calls = append(calls, fmt.Sprintf("share %s", aws.StringValue(in.ImageId)))
+		case *ec2.ModifySnapshotAttributeInput:
+			calls = append(calls, fmt.Sprintf("share %s", aws.StringValue(in.SnapshotId)))
+		default:
+			t.Fatalf("unexpected request: %#v", r.Params)
+		}
+	})
+
+	state := new(multistep.BasicStateBag)
+	state.Put("ec2", conn)
+	state.Put("awsSession", FakeSession())
+	state.Put("ui", packersdk.TestUi(t))
+	state.Put("amis", map[string]string{"us-east-1": "ami-12345"})
+	state.Put("snapshots", map[string][]string{"us-east-1": {"snap-12345"}})
+
+	step := &StepModifyAMIAttributes{
+		Users:            []string{"123456789012"},
+		SnapshotUsers:    []string{"123456789012"},
+		WaitForSnapshots: true,
+		PollingConfig:    &AWSPollingConfig{DelaySeconds: 1},
+		regionConn:       func(string) *ec2.EC2 { return conn },
+	}
+	if action := step.Run(context.Background(), state); action != multistep.ActionContinue {
+		t.Fatalf("should continue, got error: %v", state.Get("error"))
+	}
+
+	expected := []string{
+		"snap-12345 completed",
+		"share ami-12345",
+		"share snap-12345",
+	}
+	if fmt.Sprint(calls) != fmt.Sprint(expected) {
+		t.Fatalf("expected calls %v, got %v", expected, calls)
+	}
+}
+
+func TestStepModifyAMIAttributes_ShareDuration(t *testing.T) {
+	var tagged []*ec2.CreateTagsInput
+	conn := FakeEC2Conn(func(r *request.Request) {
+		switch in := r.Params.(type) {
+		case *ec2.ModifyImageAttributeInput:
+		case *ec2.CreateTagsInput:
+			tagged = append(tagged, in)
+		default:
+			t.Fatalf("unexpected request: %#v", r.Params)
+		}
+	})
+
+	state := new(multistep.BasicStateBag)
+	state.Put("ec2", conn)
+	state.Put("awsSession", FakeSession())
+	state.Put("ui", packersdk.TestUi(t))
+	state.Put("amis", map[string]string{"us-east-1": "ami-12345"})
+	state.Put("snapshots", map[string][]string{})
+
+	step := &StepModifyAMIAttributes{
+		Users:         []string{"123456789012"},
+		ShareDuration: 72 * time.Hour,
+		GeneratedData: &packerbuilderdata.GeneratedData{State: state},
+		regionConn:    func(string) *ec2.EC2 { return conn },
+	}
+	before := time.Now().UTC().Truncate(time.Second)
+	if action := step.Run(context.Background(), state); action != multistep.ActionContinue {
+		t.Fatalf("should continue, got error: %v", state.Get("error"))
+	}
+
+	if len(tagged) != 1 || aws.StringValue(tagged[0].Resources[0]) != "ami-12345" {
+		t.Fatalf("expected ami-12345 to be tagged, got %v", tagged)
+	}
+	tag := tagged[0].Tags[0]
+	unshareAfter, err := time.Parse(time.RFC3339, aws.StringValue(tag.Value))
+	if aws.StringValue(tag.Key) != "unshare-after" || err != nil || unshareAfter.Before(before.Add(72*time.Hour)) {
+		t.Fatalf("unexpected tag %s: %v", tag, err)
+	}
+	generated := state.Get("generated_data").(map[string]interface{})
+	if generated["UnshareAfter"] != aws.StringValue(tag.Value) {
+		t.Fatalf("expected UnshareAfter %s, got %v", aws.StringValue(tag.Value), generated["UnshareAfter"])
+	}
+}
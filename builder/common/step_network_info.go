@@ -9,6 +9,7 @@ import (
 	"log"
 	"math/rand"
 	"sort"
+	"strings"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/ec2"
@@ -26,6 +27,8 @@ import (
 //	vpc_id string - the VPC ID
 //	subnet_id string - the Subnet ID
 //	availability_zone string - the AZ name
+//	fallback_subnets []*ec2.Subnet - subnets matching subnet_filter in the
+//	  other AZs, to launch the instance in if the AZ lacks capacity
 type StepNetworkInfo struct {
 	VpcId                    string
 	VpcFilter                VpcFilterOptions
@@ -56,6 +59,32 @@ func mostFreeSubnet(subnets []*ec2.Subnet) *ec2.Subnet {
 	return sortedSubnets[len(sortedSubnets)-1]
 }
 
+// fallbackSubnets returns the most free subnet of each availability zone of
+// subnets other than the one of chosen, the most free first.
+func fallbackSubnets(subnets []*ec2.Subnet, chosen *ec2.Subnet) []*ec2.Subnet {
+	byAZ := make(map[string][]*ec2.Subnet)
+	for _, subnet := range subnets {
+		az := aws.StringValue(subnet.AvailabilityZone)
+		if az == aws.StringValue(chosen.AvailabilityZone) {
+			continue
+		}
+		byAZ[az] = append(byAZ[az], subnet)
+	}
+
+	var fallbacks []*ec2.Subnet
+	for _, azSubnets := range byAZ {
+		fallbacks = append(fallbacks, mostFreeSubnet(azSubnets))
+	}
+	sort.Slice(fallbacks, func(i, j int) bool {
+		freeI, freeJ := aws.Int64Value(fallbacks[i].AvailableIpAddressCount), aws.Int64Value(fallbacks[j].AvailableIpAddressCount)
+		if freeI != freeJ {
+			return freeI > freeJ
+		}
+		return aws.StringValue(fallbacks[i].AvailabilityZone) < aws.StringValue(fallbacks[j].AvailabilityZone)
+	})
+	return fallbacks
+}
+
 func (s *StepNetworkInfo) Run(ctx context.Context, state multistep.StateBag) multistep.StepAction {
 	ec2conn := state.Get("ec2").(ec2iface.EC2API)
 	ui := state.Get("ui").(packersdk.Ui)
@@ -148,6 +177,8 @@ func (s *StepNetworkInfo) Run(ctx context.Context, state multistep.StateBag) mul
 		}
 		s.SubnetId = *subnet.SubnetId
 		ui.Message(fmt.Sprintf("Found Subnet ID: %s", s.SubnetId))
+
+		state.Put("fallback_subnets", fallbackSubnets(subnetsResp.Subnets, subnet))
 	}
 
 	// Set VPC/Subnet if we explicitely enable or disable public IP assignment to the instance
@@ -220,6 +251,9 @@ func (s *StepNetworkInfo) GetDefaultVPCAndSubnet(ui packersdk.Ui, ec2conn ec2ifa
 		"vpc-id": vpc,
 		"state":  "available",
 	}
+	if s.AvailabilityZone != "" {
+		filters["availability-zone"] = s.AvailabilityZone
+	}
 	params.Filters, err = buildEc2Filters(filters)
 	if err != nil {
 		return fmt.Errorf("Failed to prepare subnet filters: %s", err)
@@ -326,3 +360,36 @@ outLoop:
 }
 
 func (s *StepNetworkInfo) Cleanup(multistep.StateBag) {}
+
+// checkSubnetIpv6 makes sure the subnet supports IPv6 if the instance needs
+// it, either for its primary IPv6 address or for the AAAA record of its
+// resource name. The default subnet, picked by EC2, isn't checked.
+func checkSubnetIpv6(ec2conn ec2iface.EC2API, subnetId string, primaryIpv6 bool, dnsOptions PrivateDnsNameOptions) error {
+	var options []string
+	if primaryIpv6 {
+		options = append(options, "enable_primary_ipv6")
+	}
+	if dnsOptions.EnableResourceNameDnsAAAARecord {
+		options = append(options, "private_dns_name_options.enable_resource_name_dns_aaaa_record")
+	}
+	if len(options) == 0 || subnetId == "" {
+		return nil
+	}
+
+	resp, err := ec2conn.DescribeSubnets(&ec2.DescribeSubnetsInput{SubnetIds: []*string{aws.String(subnetId)}})
+	if err != nil {
+		return fmt.Errorf("Error describing subnet %s: %s", subnetId, err)
+	}
+	for _, subnet := range resp.Subnets {
+		if aws.BoolValue(subnet.Ipv6Native) {
+			return nil
+		}
+		for _, association := range subnet.Ipv6CidrBlockAssociationSet {
+			if association.Ipv6CidrBlockState != nil &&
+				aws.StringValue(association.Ipv6CidrBlockState.State) == ec2.SubnetCidrBlockStateCodeAssociated {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("%s requires IPv6, but subnet %s has no IPv6 CIDR block", strings.Join(options, " and "), subnetId)
+}
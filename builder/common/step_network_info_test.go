@@ -571,3 +571,146 @@ func TestStepNetwork_SetPublicIPAddressWithoutSubnetAndMissingDescribeInstanceTy
 	}
 	t.Logf("set AZ is %q", az)
 }
+
+func TestStepNetwork_LocalZoneSubnetFilter(t *testing.T) {
+	mockConn := &mockEC2ClientStepNetworkTests{
+		describeSubnets: func(in *ec2.DescribeSubnetsInput) (*ec2.DescribeSubnetsOutput, error) {
+			for _, filter := range in.Filters {
+				if aws.StringValue(filter.Name) == "availabilityZone" {
+					if az := aws.StringValue(filter.Values[0]); az != "us-west-2-lax-1a" {
+						return nil, fmt.Errorf("unexpected availability zone filter %q", az)
+					}
+					return &ec2.DescribeSubnetsOutput{
+						Subnets: []*ec2.Subnet{{
+							SubnetId:         aws.String("subnet-lax"),
+							VpcId:            aws.String("vpc-1"),
+							AvailabilityZone: aws.String("us-west-2-lax-1a"),
+						}},
+					}, nil
+				}
+			}
+			return nil, fmt.Errorf("the subnets should be filtered by availability zone")
+		},
+	}
+
+	stepConfig := &StepNetworkInfo{
+		VpcId:            "vpc-1",
+		AvailabilityZone: "us-west-2-lax-1a",
+		SubnetFilter: SubnetFilterOptions{
+			NameValueFilter: confighelper.NameValueFilter{Filters: map[string]string{"tag:Name": "lax"}},
+		},
+	}
+
+	state := &multistep.BasicStateBag{}
+	state.Put("ec2", mockConn)
+	state.Put("ui", &packersdk.MockUi{})
+
+	if actRet := stepConfig.Run(context.Background(), state); actRet == multistep.ActionHalt {
+		t.Fatalf("running the step failed: %s", state.Get("error").(error))
+	}
+
+	if subnetid := state.Get("subnet_id"); subnetid != "subnet-lax" {
+		t.Errorf("subnet should be 'subnet-lax', but is %q", subnetid)
+	}
+	if az := state.Get("availability_zone"); az != "us-west-2-lax-1a" {
+		t.Errorf("the instance should be launched in the Local Zone, but availability_zone is %q", az)
+	}
+}
+
+func TestStepNetwork_FallbackSubnets(t *testing.T) {
+	subnet := func(id, az string, free int64) *ec2.Subnet {
+		return &ec2.Subnet{
+			SubnetId:                aws.String(id),
+			AvailabilityZone:        aws.String(az),
+			AvailableIpAddressCount: aws.Int64(free),
+		}
+	}
+	chosen := subnet("subnet-a1", "us-east-1a", 200)
+	subnets := []*ec2.Subnet{
+		chosen,
+		subnet("subnet-a2", "us-east-1a", 100),
+		subnet("subnet-b1", "us-east-1b", 10),
+		subnet("subnet-b2", "us-east-1b", 50),
+		subnet("subnet-c1", "us-east-1c", 80),
+	}
+
+	var ids []string
+	for _, fallback := range fallbackSubnets(subnets, chosen) {
+		ids = append(ids, aws.StringValue(fallback.SubnetId))
+	}
+	expected := []string{"subnet-c1", "subnet-b2"}
+	if diff := cmp.Diff(expected, ids); diff != "" {
+		t.Fatalf("unexpected fallback subnets: %s", diff)
+	}
+}
+
+func TestStepNetwork_DefaultVPCAvailabilityZoneFilter(t *testing.T) {
+	subnets := []*ec2.Subnet{
+		{
+			SubnetId:                aws.String("subnet-lax"),
+			AvailabilityZone:        aws.String("us-west-2-lax-1a"),
+			AvailableIpAddressCount: aws.Int64(16),
+		},
+		{
+			SubnetId:                aws.String("subnet-2a"),
+			AvailabilityZone:        aws.String("us-west-2a"),
+			AvailableIpAddressCount: aws.Int64(4096),
+		},
+	}
+
+	var azFilter []string
+	mockConn := &mockEC2ClientStepNetworkTests{
+		describeVpcs: func(*ec2.DescribeVpcsInput) (*ec2.DescribeVpcsOutput, error) {
+			return &ec2.DescribeVpcsOutput{
+				Vpcs: []*ec2.Vpc{{VpcId: aws.String("default-vpc")}},
+			}, nil
+		},
+		describeSubnets: func(in *ec2.DescribeSubnetsInput) (*ec2.DescribeSubnetsOutput, error) {
+			for _, filter := range in.Filters {
+				if aws.StringValue(filter.Name) == "availability-zone" {
+					azFilter = aws.StringValueSlice(filter.Values)
+				}
+			}
+			// Filter like EC2 does, so that the subnet with the most free
+			// addresses is only returned when the filter is missing.
+			var out []*ec2.Subnet
+			for _, subnet := range subnets {
+				if azFilter == nil || aws.StringValue(subnet.AvailabilityZone) == azFilter[0] {
+					out = append(out, subnet)
+				}
+			}
+			return &ec2.DescribeSubnetsOutput{Subnets: out}, nil
+		},
+		describeInstanceTypeOfferings: func(in *ec2.DescribeInstanceTypeOfferingsInput) (*ec2.DescribeInstanceTypeOfferingsOutput, error) {
+			return &ec2.DescribeInstanceTypeOfferingsOutput{
+				InstanceTypeOfferings: []*ec2.InstanceTypeOffering{
+					{InstanceType: aws.String("t3.large")},
+				},
+			}, nil
+		},
+	}
+
+	stepConfig := &StepNetworkInfo{
+		AssociatePublicIpAddress: confighelper.TriTrue,
+		AvailabilityZone:         "us-west-2-lax-1a",
+		RequestedMachineType:     "t3.large",
+	}
+
+	state := &multistep.BasicStateBag{}
+	state.Put("ec2", mockConn)
+	state.Put("ui", &packersdk.MockUi{})
+
+	if actRet := stepConfig.Run(context.Background(), state); actRet == multistep.ActionHalt {
+		t.Fatalf("running the step failed: %s", state.Get("error").(error))
+	}
+
+	if diff := cmp.Diff([]string{"us-west-2-lax-1a"}, azFilter); diff != "" {
+		t.Errorf("unexpected availability-zone filter: %s", diff)
+	}
+	if subnetid := state.Get("subnet_id"); subnetid != "subnet-lax" {
+		t.Errorf("subnet should be 'subnet-lax', but is %q", subnetid)
+	}
+	if az := state.Get("availability_zone"); az != "us-west-2-lax-1a" {
+		t.Errorf("availability_zone should be 'us-west-2-lax-1a', but is %q", az)
+	}
+}
@@ -5,14 +5,20 @@ package common
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"log"
+	"strings"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/arn"
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/aws/aws-sdk-go/service/kms/kmsiface"
 	"github.com/hashicorp/packer-plugin-amazon/builder/common/awserrors"
+	awscommon "github.com/hashicorp/packer-plugin-amazon/common"
 	"github.com/hashicorp/packer-plugin-sdk/multistep"
 	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
 	"github.com/hashicorp/packer-plugin-sdk/retry"
@@ -28,6 +34,12 @@ type StepPreValidate struct {
 	VpcId              string
 	SubnetId           string
 	HasSubnetFilter    bool
+
+	// newKMSConn returns a KMS client for a region, it is only set by tests.
+	newKMSConn func(region string) (kmsiface.KMSAPI, error)
+	// newRegionEC2Conn returns an EC2 client for a region, it is only set by
+	// tests.
+	newRegionEC2Conn func(region string) (ec2iface.EC2API, error)
 }
 
 func (s *StepPreValidate) Run(ctx context.Context, state multistep.StateBag) multistep.StepAction {
@@ -77,6 +89,15 @@ func (s *StepPreValidate) Run(ctx context.Context, state multistep.StateBag) mul
 					return multistep.ActionHalt
 				}
 			}
+
+			if amiconf.AMIValidateKmsKeys {
+				ui.Say("Prevalidating KMS keys")
+				if err := s.checkKmsKeys(accessconf, amiconf); err != nil {
+					state.Put("error", err)
+					ui.Error(err.Error())
+					return multistep.ActionHalt
+				}
+			}
 		}
 	}
 
@@ -85,6 +106,18 @@ func (s *StepPreValidate) Run(ctx context.Context, state multistep.StateBag) mul
 		return multistep.ActionContinue
 	}
 
+	if amiConfig, ok := state.GetOk("ami_config"); ok && !s.AMISkipCreateImage {
+		if regions := amiConfig.(*AMIConfig).AMIRegions; len(regions) > 0 {
+			ui.Say(fmt.Sprintf("Prevalidating AMI Name in ami_regions: %s", strings.Join(regions, ", ")))
+			accessconf := state.Get("access_config").(*AccessConfig)
+			if err := s.checkAMIRegions(accessconf, regions); err != nil {
+				state.Put("error", err)
+				ui.Error(err.Error())
+				return multistep.ActionHalt
+			}
+		}
+	}
+
 	if s.AMISkipBuildRegion {
 		ui.Say("skip_build_region was set; not prevalidating AMI name")
 		return multistep.ActionContinue
@@ -153,5 +186,195 @@ func (s *StepPreValidate) checkVpc(conn ec2iface.EC2API) error {
 	return nil
 }
 
+// checkAMIRegions makes sure no AMI is named like the one to be built in the
+// regions it will be copied to, so that the build doesn't fail once the copies
+// start.
+func (s *StepPreValidate) checkAMIRegions(accessconf *AccessConfig, regions []string) error {
+	newRegionEC2Conn := s.newRegionEC2Conn
+	if newRegionEC2Conn == nil {
+		newRegionEC2Conn = func(region string) (ec2iface.EC2API, error) {
+			sess, err := accessconf.Session()
+			if err != nil {
+				return nil, err
+			}
+			return ec2.New(sess, aws.NewConfig().WithRegion(region)), nil
+		}
+	}
+
+	for _, region := range regions {
+		conn, err := newRegionEC2Conn(region)
+		if err != nil {
+			return err
+		}
+		resp, err := conn.DescribeImages(&ec2.DescribeImagesInput{
+			Owners: []*string{aws.String("self")},
+			Filters: []*ec2.Filter{{
+				Name:   aws.String("name"),
+				Values: []*string{aws.String(s.DestAmiName)},
+			}}})
+		if err != nil {
+			return fmt.Errorf("Error querying AMI in %s: %s", region, err)
+		}
+		if len(resp.Images) > 0 {
+			return fmt.Errorf("Error: AMI Name: '%s' is used by an existing AMI in %s: %s",
+				*resp.Images[0].Name, region, *resp.Images[0].ImageId)
+		}
+	}
+	return nil
+}
+
+// checkKmsKeys makes sure the keys the AMI will be encrypted with can be used
+// by the build credentials, in the region each of them will be used in.
+func (s *StepPreValidate) checkKmsKeys(accessconf *AccessConfig, amiconf *AMIConfig) error {
+	keys := make(map[string]string)
+	for region, keyId := range amiconf.AMIRegionKMSKeyIDs {
+		keys[region] = keyId
+	}
+	if buildRegion := accessconf.SessionRegion(); amiconf.AMIKmsKeyId != "" {
+		if _, ok := keys[buildRegion]; !ok {
+			keys[buildRegion] = amiconf.AMIKmsKeyId
+		}
+	}
+
+	newKMSConn := s.newKMSConn
+	if newKMSConn == nil {
+		newKMSConn = func(region string) (kmsiface.KMSAPI, error) {
+			sess, err := accessconf.Session()
+			if err != nil {
+				return nil, err
+			}
+			return kms.New(sess, aws.NewConfig().WithRegion(region)), nil
+		}
+	}
+
+	for region, keyId := range keys {
+		if keyId == "" {
+			// The default EBS key of the region is always usable.
+			continue
+		}
+		// A key given by ARN lives in the region of its ARN.
+		if keyArn, err := arn.Parse(keyId); err == nil {
+			region = keyArn.Region
+		}
+
+		conn, err := newKMSConn(region)
+		if err != nil {
+			return err
+		}
+		if err := checkKmsKey(conn, keyId, region); err != nil {
+			return fmt.Errorf("KMS key %s can't be used in %s: %s", keyId, region, err)
+		}
+	}
+	return nil
+}
+
+func checkKmsKey(conn kmsiface.KMSAPI, keyId, region string) error {
+	resp, err := conn.DescribeKey(&kms.DescribeKeyInput{KeyId: aws.String(keyId)})
+	if err != nil {
+		return err
+	}
+	if state := aws.StringValue(resp.KeyMetadata.KeyState); state != kms.KeyStateEnabled {
+		return fmt.Errorf("the key is %s", state)
+	}
+
+	_, err = conn.GenerateDataKeyWithoutPlaintext(&kms.GenerateDataKeyWithoutPlaintextInput{
+		KeyId:   aws.String(keyId),
+		KeySpec: aws.String(kms.DataKeySpecAes256),
+		DryRun:  aws.Bool(true),
+	})
+	if err != nil && !awserrors.Matches(err, kms.ErrCodeDryRunOperationException, "") {
+		return err
+	}
+
+	// The key policy can't be read with an alias.
+	return checkKmsKeyPolicy(conn, aws.StringValue(resp.KeyMetadata.KeyId), region)
+}
+
+// ebsKmsActions are the actions EBS calls on behalf of the build credentials
+// to encrypt the volumes and snapshots of the AMI.
+var ebsKmsActions = []string{
+	"kms:CreateGrant",
+	"kms:Decrypt",
+	"kms:DescribeKey",
+	"kms:GenerateDataKeyWithoutPlaintext",
+	"kms:ReEncryptFrom",
+	"kms:ReEncryptTo",
+}
+
+// checkKmsKeyPolicy looks for a statement of the key policy that denies EC2
+// the use of the key, as the build credentials may be allowed to use the key
+// themselves while EBS, which uses it through them, is not. Credentials that
+// can't read the policy, and policies that can't be parsed, skip this check.
+func checkKmsKeyPolicy(conn kmsiface.KMSAPI, keyId, region string) error {
+	resp, err := conn.GetKeyPolicy(&kms.GetKeyPolicyInput{
+		KeyId:      aws.String(keyId),
+		PolicyName: aws.String("default"),
+	})
+	if awserrors.Matches(err, "AccessDeniedException", "") {
+		log.Printf("[WARN] Not allowed to read the policy of KMS key %s, skipping its validation: %s", keyId, err)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var policy keyPolicy
+	if err := json.Unmarshal([]byte(aws.StringValue(resp.Policy)), &policy); err != nil {
+		log.Printf("[WARN] Failed to parse the policy of KMS key %s, skipping its validation: %s", keyId, err)
+		return nil
+	}
+
+	for _, statement := range policy.Statement {
+		if statementDeniesEC2(statement, region) {
+			return fmt.Errorf("statement %q of the key policy denies EC2 the use of the key, so the AMI can't be "+
+				"encrypted with it. EBS needs %s through ec2.%s.amazonaws.com: remove the statement or exclude "+
+				"these actions with a kms:ViaService condition, see "+
+				"https://docs.aws.amazon.com/kms/latest/developerguide/key-policies.html",
+				statement.Sid, strings.Join(ebsKmsActions, ", "), region)
+		}
+	}
+	return nil
+}
+
+// keyPolicy is the part of a KMS key policy document needed to find the
+// statements denying EC2 the use of the key.
+type keyPolicy struct {
+	Statement awscommon.PolicyStatements
+}
+
+// statementDeniesEC2 reports whether the statement denies EC2 in region an
+// action needed to encrypt the AMI. Statements with principals or conditions
+// that depend on the caller are not evaluated, so only denials that apply to
+// anyone calling through EC2 are reported.
+func statementDeniesEC2(s awscommon.PolicyStatement, region string) bool {
+	if s.Effect != "Deny" || !s.Action.MatchesAny(ebsKmsActions...) {
+		return false
+	}
+
+	anyone := s.PrincipalValues("AWS").MatchesAny("*")
+	ec2 := s.PrincipalValues("Service").MatchesAny("ec2.amazonaws.com")
+	if !anyone && !ec2 {
+		return false
+	}
+
+	viaService := "ec2." + region + ".amazonaws.com"
+	for operator, conditions := range s.Condition {
+		for key, values := range conditions {
+			if !strings.EqualFold(key, "kms:ViaService") {
+				return false
+			}
+			switch operator {
+			case "StringEquals", "StringEqualsIgnoreCase", "StringLike":
+				if !values.MatchesAny(viaService) {
+					return false
+				}
+			default:
+				return false
+			}
+		}
+	}
+	return true
+}
+
 // Cleanup ...
 func (s *StepPreValidate) Cleanup(multistep.StateBag) {}
@@ -5,11 +5,16 @@ package common
 
 import (
 	"fmt"
+	"reflect"
 	"strings"
 	"testing"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/aws/aws-sdk-go/service/kms/kmsiface"
 )
 
 // DescribeVpcs mocks an ec2.DescribeVpcsOutput for a given input
@@ -71,3 +76,188 @@ func TestStepPreValidate_checkVpc(t *testing.T) {
 	}
 
 }
+
+type mockKMSConn struct {
+	kmsiface.KMSAPI
+
+	region   string
+	denied   map[string]bool
+	disabled map[string]bool
+	policies map[string]string
+	checked  *[]string
+}
+
+func (m *mockKMSConn) DescribeKey(input *kms.DescribeKeyInput) (*kms.DescribeKeyOutput, error) {
+	keyId := aws.StringValue(input.KeyId)
+	*m.checked = append(*m.checked, m.region+":"+keyId)
+	if m.denied[keyId] {
+		return nil, awserr.New("AccessDeniedException", "User is not authorized to perform: kms:DescribeKey", nil)
+	}
+	keyState := kms.KeyStateEnabled
+	if m.disabled[keyId] {
+		keyState = kms.KeyStateDisabled
+	}
+	return &kms.DescribeKeyOutput{KeyMetadata: &kms.KeyMetadata{KeyId: input.KeyId, KeyState: aws.String(keyState)}}, nil
+}
+
+func (m *mockKMSConn) GenerateDataKeyWithoutPlaintext(input *kms.GenerateDataKeyWithoutPlaintextInput) (*kms.GenerateDataKeyWithoutPlaintextOutput, error) {
+	if !aws.BoolValue(input.DryRun) {
+		return nil, fmt.Errorf("expected a dry run")
+	}
+	return nil, awserr.New(kms.ErrCodeDryRunOperationException, "The request would have succeeded", nil)
+}
+
+func (m *mockKMSConn) GetKeyPolicy(input *kms.GetKeyPolicyInput) (*kms.GetKeyPolicyOutput, error) {
+	policy, ok := m.policies[aws.StringValue(input.KeyId)]
+	if !ok {
+		policy = `{"Version": "2012-10-17", "Statement": {"Sid": "Enable IAM User Permissions", "Effect": "Allow",
+			"Principal": {"AWS": "arn:aws:iam::123456789012:root"}, "Action": "kms:*", "Resource": "*"}}`
+	}
+	return &kms.GetKeyPolicyOutput{Policy: aws.String(policy)}, nil
+}
+
+func TestStepPreValidate_checkKmsKeys(t *testing.T) {
+	tt := []struct {
+		name          string
+		amiConfig     AMIConfig
+		denied        map[string]bool
+		disabled      map[string]bool
+		policies      map[string]string
+		checked       []string
+		errorExpected bool
+	}{
+		{
+			name:      "BuildRegionKey",
+			amiConfig: AMIConfig{AMIKmsKeyId: "alias/build"},
+			checked:   []string{"us-west-1:alias/build"},
+		},
+		{
+			name: "RegionKeysSupersedeKmsKeyId",
+			amiConfig: AMIConfig{
+				AMIKmsKeyId:        "alias/build",
+				AMIRegionKMSKeyIDs: map[string]string{"us-west-1": "alias/west", "us-east-1": ""},
+			},
+			checked: []string{"us-west-1:alias/west"},
+		},
+		{
+			name:      "KeyArnRegion",
+			amiConfig: AMIConfig{AMIKmsKeyId: "arn:aws:kms:eu-west-1:123456789012:key/12345678-1234-1234-1234-123456789012"},
+			checked:   []string{"eu-west-1:arn:aws:kms:eu-west-1:123456789012:key/12345678-1234-1234-1234-123456789012"},
+		},
+		{
+			name:          "AccessDenied",
+			amiConfig:     AMIConfig{AMIKmsKeyId: "alias/secret"},
+			denied:        map[string]bool{"alias/secret": true},
+			checked:       []string{"us-west-1:alias/secret"},
+			errorExpected: true,
+		},
+		{
+			name:          "DisabledKey",
+			amiConfig:     AMIConfig{AMIRegionKMSKeyIDs: map[string]string{"us-east-1": "alias/old"}},
+			disabled:      map[string]bool{"alias/old": true},
+			checked:       []string{"us-east-1:alias/old"},
+			errorExpected: true,
+		},
+		{
+			name:      "PolicyDeniesEC2",
+			amiConfig: AMIConfig{AMIKmsKeyId: "alias/locked"},
+			policies: map[string]string{"alias/locked": `{"Version": "2012-10-17", "Statement": [
+				{"Sid": "Enable IAM User Permissions", "Effect": "Allow", "Principal": {"AWS": "arn:aws:iam::123456789012:root"}, "Action": "kms:*", "Resource": "*"},
+				{"Sid": "DenyEBS", "Effect": "Deny", "Principal": "*", "Action": ["kms:CreateGrant", "kms:ReEncrypt*"], "Resource": "*",
+					"Condition": {"StringLike": {"kms:ViaService": "ec2.*.amazonaws.com"}}}
+			]}`},
+			checked:       []string{"us-west-1:alias/locked"},
+			errorExpected: true,
+		},
+		{
+			name:      "PolicyDeniesOtherServices",
+			amiConfig: AMIConfig{AMIKmsKeyId: "alias/ebs-only"},
+			policies: map[string]string{"alias/ebs-only": `{"Version": "2012-10-17", "Statement": [
+				{"Sid": "Enable IAM User Permissions", "Effect": "Allow", "Principal": {"AWS": "arn:aws:iam::123456789012:root"}, "Action": "kms:*", "Resource": "*"},
+				{"Sid": "EBSOnly", "Effect": "Deny", "Principal": "*", "Action": "kms:*", "Resource": "*",
+					"Condition": {"StringNotEquals": {"kms:ViaService": "ec2.us-west-1.amazonaws.com"}}}
+			]}`},
+			checked: []string{"us-west-1:alias/ebs-only"},
+		},
+		{
+			name:      "PolicyWithBooleanCondition",
+			amiConfig: AMIConfig{AMIKmsKeyId: "alias/grants"},
+			policies: map[string]string{"alias/grants": `{"Version": "2012-10-17", "Statement": [
+				{"Sid": "Enable IAM User Permissions", "Effect": "Allow", "Principal": {"AWS": "arn:aws:iam::123456789012:root"}, "Action": "kms:*", "Resource": "*"},
+				{"Sid": "AllowAttachment", "Effect": "Allow", "Principal": {"AWS": "arn:aws:iam::123456789012:root"}, "Action": "kms:CreateGrant", "Resource": "*",
+					"Condition": {"Bool": {"kms:GrantIsForAWSResource": true}}}
+			]}`},
+			checked: []string{"us-west-1:alias/grants"},
+		},
+		{
+			name:      "UnparsablePolicy",
+			amiConfig: AMIConfig{AMIKmsKeyId: "alias/unparsable"},
+			policies:  map[string]string{"alias/unparsable": `{"Version": "2012-10-17", "Statement": [{"Action": {}}]}`},
+			checked:   []string{"us-west-1:alias/unparsable"},
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			var checked []string
+			step := StepPreValidate{
+				newKMSConn: func(region string) (kmsiface.KMSAPI, error) {
+					return &mockKMSConn{region: region, denied: tc.denied, disabled: tc.disabled, policies: tc.policies, checked: &checked}, nil
+				},
+			}
+
+			err := step.checkKmsKeys(FakeAccessConfig(), &tc.amiConfig)
+			if tc.errorExpected && err == nil {
+				t.Fatal("expected a KMS validation error")
+			}
+			if !tc.errorExpected && err != nil {
+				t.Fatalf("unexpected KMS validation error: %s", err)
+			}
+			if !reflect.DeepEqual(checked, tc.checked) {
+				t.Fatalf("expected keys %v to be checked, got %v", tc.checked, checked)
+			}
+		})
+	}
+}
+
+type mockRegionImagesConn struct {
+	ec2iface.EC2API
+
+	images []*ec2.Image
+}
+
+func (m *mockRegionImagesConn) DescribeImages(input *ec2.DescribeImagesInput) (*ec2.DescribeImagesOutput, error) {
+	var images []*ec2.Image
+	for _, image := range m.images {
+		if aws.StringValue(image.Name) == aws.StringValue(input.Filters[0].Values[0]) {
+			images = append(images, image)
+		}
+	}
+	return &ec2.DescribeImagesOutput{Images: images}, nil
+}
+
+func TestStepPreValidate_checkAMIRegions(t *testing.T) {
+	conns := map[string]*mockRegionImagesConn{
+		"us-east-1": {},
+		"eu-west-1": {images: []*ec2.Image{{Name: aws.String("app-1.0"), ImageId: aws.String("ami-12345")}}},
+	}
+	step := StepPreValidate{
+		newRegionEC2Conn: func(region string) (ec2iface.EC2API, error) {
+			return conns[region], nil
+		},
+	}
+
+	step.DestAmiName = "app-1.1"
+	if err := step.checkAMIRegions(FakeAccessConfig(), []string{"us-east-1", "eu-west-1"}); err != nil {
+		t.Fatalf("unexpected validation error: %s", err)
+	}
+
+	step.DestAmiName = "app-1.0"
+	err := step.checkAMIRegions(FakeAccessConfig(), []string{"us-east-1", "eu-west-1"})
+	if err == nil {
+		t.Fatal("expected an error for the AMI name used in eu-west-1")
+	}
+	if !strings.Contains(err.Error(), "eu-west-1") || !strings.Contains(err.Error(), "ami-12345") {
+		t.Fatalf("the error should name the conflicting region and AMI, got %q", err)
+	}
+}
@@ -5,13 +5,12 @@ package common
 
 import (
 	"context"
-	"encoding/base64"
 	"fmt"
-	"io/ioutil"
 	"log"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
 
@@ -52,12 +51,19 @@ type StepRunSourceInstance struct {
 	Tenancy                           string
 	UserData                          string
 	UserDataFile                      string
+	UserDataS3Url                     string
+	UserDataSSMParameter              string
 	VolumeTags                        map[string]string
 	NoEphemeral                       bool
 	EnableNitroEnclave                bool
 	IsBurstableInstanceType           bool
+	EIPAllocationId                   string
+	InsufficientCapacityRetries       int
+	EnablePrimaryIpv6                 bool
+	PrivateDnsNameOptions             PrivateDnsNameOptions
 
-	instanceId string
+	instanceId    string
+	associationId string
 }
 
 func (s *StepRunSourceInstance) Run(ctx context.Context, state multistep.StateBag) multistep.StepAction {
@@ -68,21 +74,11 @@ func (s *StepRunSourceInstance) Run(ctx context.Context, state multistep.StateBa
 
 	ui := state.Get("ui").(packersdk.Ui)
 
-	userData := s.UserData
-	if s.UserDataFile != "" {
-		contents, err := ioutil.ReadFile(s.UserDataFile)
-		if err != nil {
-			state.Put("error", fmt.Errorf("Problem reading user data file: %s", err))
-			return multistep.ActionHalt
-		}
-
-		userData = string(contents)
-	}
-
-	// Test if it is encoded already, and if not, encode it
-	if _, err := base64.StdEncoding.DecodeString(userData); err != nil {
-		log.Printf("[DEBUG] base64 encoding user data...")
-		userData = base64.StdEncoding.EncodeToString([]byte(userData))
+	sess, _ := state.Get("awsSession").(*session.Session)
+	userData, err := newUserDataLoader(sess, s.UserData, s.UserDataFile, s.UserDataS3Url, s.UserDataSSMParameter).Load()
+	if err != nil {
+		state.Put("error", err)
+		return multistep.ActionHalt
 	}
 
 	ui.Say("Launching a source AWS instance...")
@@ -156,13 +152,7 @@ func (s *StepRunSourceInstance) Run(ctx context.Context, state multistep.StateBa
 		}
 	}
 
-	if s.IsBurstableInstanceType {
-		runOpts.CreditSpecification = &ec2.CreditSpecificationRequest{CpuCredits: aws.String(CPUCreditsStandard)}
-	}
-
-	if s.EnableUnlimitedCredits {
-		runOpts.CreditSpecification = &ec2.CreditSpecificationRequest{CpuCredits: aws.String(CPUCreditsUnlimited)}
-	}
+	runOpts.CreditSpecification = creditSpecification(s.IsBurstableInstanceType, s.EnableUnlimitedCredits)
 
 	if s.HttpEndpoint == "enabled" {
 		runOpts.MetadataOptions = &ec2.InstanceMetadataOptionsRequest{
@@ -217,24 +207,14 @@ func (s *StepRunSourceInstance) Run(ctx context.Context, state multistep.StateBa
 
 	subnetId := state.Get("subnet_id").(string)
 
-	if subnetId != "" && s.AssociatePublicIpAddress != config.TriUnset {
-		ui.Say(fmt.Sprintf("changing public IP address config to %t for instance on subnet %q",
-			*s.AssociatePublicIpAddress.ToBoolPointer(),
-			subnetId))
-		runOpts.NetworkInterfaces = []*ec2.InstanceNetworkInterfaceSpecification{
-			{
-				DeviceIndex:              aws.Int64(0),
-				AssociatePublicIpAddress: s.AssociatePublicIpAddress.ToBoolPointer(),
-				SubnetId:                 aws.String(subnetId),
-				Groups:                   securityGroupIds,
-				DeleteOnTermination:      aws.Bool(true),
-			},
-		}
-	} else {
-		runOpts.SubnetId = aws.String(subnetId)
-		runOpts.SecurityGroupIds = securityGroupIds
+	if err := checkSubnetIpv6(ec2conn, subnetId, s.EnablePrimaryIpv6, s.PrivateDnsNameOptions); err != nil {
+		state.Put("error", err)
+		ui.Error(err.Error())
+		return multistep.ActionHalt
 	}
 
+	s.setNetworkOptions(ui, runOpts, subnetId, securityGroupIds)
+
 	if s.ExpectedRootDevice == "ebs" {
 		runOpts.InstanceInitiatedShutdownBehavior = &s.InstanceInitiatedShutdownBehavior
 	}
@@ -281,18 +261,7 @@ func (s *StepRunSourceInstance) Run(ctx context.Context, state multistep.StateBa
 		runOpts.Placement.Tenancy = aws.String(s.Tenancy)
 	}
 
-	var runResp *ec2.Reservation
-	err = retry.Config{
-		Tries: 11,
-		ShouldRetry: func(err error) bool {
-			return awserrors.Matches(err, "InvalidParameterValue", "iamInstanceProfile")
-		},
-		RetryDelay: (&retry.Backoff{InitialBackoff: 200 * time.Millisecond, MaxBackoff: 30 * time.Second, Multiplier: 2}).Linear,
-	}.Run(ctx, func(ctx context.Context) error {
-		runResp, err = ec2conn.RunInstances(runOpts)
-		return err
-	})
-
+	runResp, err := s.runInstances(ctx, ec2conn, ui, state, runOpts)
 	if awserrors.Matches(err, "VPCIdNotSpecified", "No default VPC for this user") && subnetId == "" {
 		err := fmt.Errorf("Error launching source instance: a valid Subnet Id was not specified")
 		state.Put("error", err)
@@ -339,6 +308,15 @@ func (s *StepRunSourceInstance) Run(ctx context.Context, state multistep.StateBa
 
 	instance := r.Reservations[0].Instances[0]
 
+	if s.EIPAllocationId != "" {
+		instance, err = s.associateEIP(ec2conn, ui, instance)
+		if err != nil {
+			state.Put("error", err)
+			ui.Error(err.Error())
+			return multistep.ActionHalt
+		}
+	}
+
 	if s.Debug {
 		if instance.PublicDnsName != nil && *instance.PublicDnsName != "" {
 			ui.Message(fmt.Sprintf("Public DNS: %s", *instance.PublicDnsName))
@@ -439,6 +417,77 @@ func (s *StepRunSourceInstance) Run(ctx context.Context, state multistep.StateBa
 	return multistep.ActionContinue
 }
 
+// runInstances launches the source instance. If the availability zone lacks
+// capacity for the instance type, the launch moves on to the fallback subnets
+// StepNetworkInfo found in other zones, up to InsufficientCapacityRetries
+// times.
+func (s *StepRunSourceInstance) runInstances(ctx context.Context, ec2conn ec2iface.EC2API, ui packersdk.Ui, state multistep.StateBag, runOpts *ec2.RunInstancesInput) (*ec2.Reservation, error) {
+	fallbacks, _ := state.Get("fallback_subnets").([]*ec2.Subnet)
+
+	for attempt := 0; ; attempt++ {
+		var runResp *ec2.Reservation
+		err := retry.Config{
+			Tries: 11,
+			ShouldRetry: func(err error) bool {
+				return awserrors.Matches(err, "InvalidParameterValue", "iamInstanceProfile")
+			},
+			RetryDelay: (&retry.Backoff{InitialBackoff: 200 * time.Millisecond, MaxBackoff: 30 * time.Second, Multiplier: 2}).Linear,
+		}.Run(ctx, func(ctx context.Context) error {
+			var err error
+			runResp, err = ec2conn.RunInstances(runOpts)
+			return err
+		})
+		if err == nil || !awserrors.Matches(err, "InsufficientInstanceCapacity", "") ||
+			attempt >= s.InsufficientCapacityRetries || attempt >= len(fallbacks) {
+			return runResp, err
+		}
+
+		subnet := fallbacks[attempt]
+		ui.Say(fmt.Sprintf("Not enough %s capacity in %s, launching in subnet %s of %s instead...",
+			s.InstanceType, aws.StringValue(runOpts.Placement.AvailabilityZone),
+			aws.StringValue(subnet.SubnetId), aws.StringValue(subnet.AvailabilityZone)))
+
+		runOpts.Placement.AvailabilityZone = subnet.AvailabilityZone
+		if runOpts.SubnetId != nil {
+			runOpts.SubnetId = subnet.SubnetId
+		}
+		for _, networkInterface := range runOpts.NetworkInterfaces {
+			networkInterface.SubnetId = subnet.SubnetId
+		}
+		// The steps that follow create resources next to the instance.
+		state.Put("subnet_id", aws.StringValue(subnet.SubnetId))
+		state.Put("availability_zone", aws.StringValue(subnet.AvailabilityZone))
+	}
+}
+
+// associateEIP associates the Elastic IP with the instance, and returns the
+// instance as described once it has the new public address.
+func (s *StepRunSourceInstance) associateEIP(ec2conn *ec2.EC2, ui packersdk.Ui, instance *ec2.Instance) (*ec2.Instance, error) {
+	ui.Say(fmt.Sprintf("Associating Elastic IP %s with the source instance...", s.EIPAllocationId))
+	resp, err := ec2conn.AssociateAddress(&ec2.AssociateAddressInput{
+		AllocationId: aws.String(s.EIPAllocationId),
+		InstanceId:   instance.InstanceId,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Error associating Elastic IP %s: %s", s.EIPAllocationId, err)
+	}
+	s.associationId = aws.StringValue(resp.AssociationId)
+
+	r, err := ec2conn.DescribeInstances(&ec2.DescribeInstancesInput{
+		InstanceIds: []*string{instance.InstanceId},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Error finding source instance after associating Elastic IP %s: %s", s.EIPAllocationId, err)
+	}
+	if len(r.Reservations) == 0 || len(r.Reservations[0].Instances) == 0 {
+		return nil, fmt.Errorf("Error finding source instance after associating Elastic IP %s: instance %s not found",
+			s.EIPAllocationId, aws.StringValue(instance.InstanceId))
+	}
+	instance = r.Reservations[0].Instances[0]
+	ui.Message(fmt.Sprintf("Elastic IP: %s", aws.StringValue(instance.PublicIpAddress)))
+	return instance, nil
+}
+
 func waitForInstanceReadiness(
 	ctx context.Context,
 	instanceId string,
@@ -475,11 +524,56 @@ func waitForInstanceReadiness(
 	return nil
 }
 
+// setNetworkOptions sets the subnet and security groups of the instance,
+// through its primary network interface if the interface needs options
+// RunInstances can't set directly.
+func (s *StepRunSourceInstance) setNetworkOptions(ui packersdk.Ui, runOpts *ec2.RunInstancesInput,
+	subnetId string, securityGroupIds []*string) {
+	if !s.PrivateDnsNameOptions.Empty() {
+		runOpts.PrivateDnsNameOptions = s.PrivateDnsNameOptions.request()
+	}
+
+	if subnetId == "" || (s.AssociatePublicIpAddress == config.TriUnset && !s.EnablePrimaryIpv6) {
+		runOpts.SubnetId = aws.String(subnetId)
+		runOpts.SecurityGroupIds = securityGroupIds
+		return
+	}
+
+	if s.AssociatePublicIpAddress != config.TriUnset {
+		ui.Say(fmt.Sprintf("changing public IP address config to %t for instance on subnet %q",
+			*s.AssociatePublicIpAddress.ToBoolPointer(),
+			subnetId))
+	}
+	networkInterface := &ec2.InstanceNetworkInterfaceSpecification{
+		DeviceIndex:              aws.Int64(0),
+		AssociatePublicIpAddress: s.AssociatePublicIpAddress.ToBoolPointer(),
+		SubnetId:                 aws.String(subnetId),
+		Groups:                   securityGroupIds,
+		DeleteOnTermination:      aws.Bool(true),
+	}
+	if s.EnablePrimaryIpv6 {
+		networkInterface.PrimaryIpv6 = aws.Bool(true)
+	}
+	runOpts.NetworkInterfaces = []*ec2.InstanceNetworkInterfaceSpecification{networkInterface}
+}
+
 func (s *StepRunSourceInstance) Cleanup(state multistep.StateBag) {
 
 	ec2conn := state.Get("ec2").(*ec2.EC2)
 	ui := state.Get("ui").(packersdk.Ui)
 
+	// Give the Elastic IP back before the instance goes away, it is only
+	// borrowed for the build.
+	if s.associationId != "" {
+		ui.Say(fmt.Sprintf("Disassociating Elastic IP %s...", s.EIPAllocationId))
+		if _, err := ec2conn.DisassociateAddress(&ec2.DisassociateAddressInput{
+			AssociationId: aws.String(s.associationId),
+		}); err != nil {
+			ui.Error(fmt.Sprintf("Error disassociating Elastic IP %s: %s", s.EIPAllocationId, err))
+		}
+		s.associationId = ""
+	}
+
 	// Terminate the source instance if it exists
 	if s.instanceId != "" {
 		ui.Say("Terminating the source AWS instance...")
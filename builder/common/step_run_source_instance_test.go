@@ -0,0 +1,254 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package common
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
+)
+
+func TestStepRunSourceInstance_AssociateEIP(t *testing.T) {
+	var calls []string
+	conn := FakeEC2Conn(func(r *request.Request) {
+		switch in := r.Params.(type) {
+		case *ec2.AssociateAddressInput:
+			calls = append(calls, fmt.Sprintf("associate %s with %s", aws.StringValue(in.AllocationId), aws.StringValue(in.InstanceId)))
+			r.Data.(*ec2.AssociateAddressOutput).AssociationId = aws.String("eipassoc-12345678")
+		case *ec2.DescribeInstancesInput:
+			r.Data.(*ec2.DescribeInstancesOutput).Reservations = []*ec2.Reservation{{
+				Instances: []*ec2.Instance{{
+					InstanceId:      aws.String("i-12345"),
+					PublicIpAddress: aws.String("203.0.113.10"),
+				}},
+			}}
+		case *ec2.DisassociateAddressInput:
+			calls = append(calls, fmt.Sprintf("disassociate %s", aws.StringValue(in.AssociationId)))
+		default:
+			t.Fatalf("unexpected request: %#v", r.Params)
+		}
+	})
+
+	state := new(multistep.BasicStateBag)
+	state.Put("ec2", conn)
+	state.Put("ui", packersdk.TestUi(t))
+
+	step := &StepRunSourceInstance{EIPAllocationId: "eipalloc-12345678"}
+	instance, err := step.associateEIP(conn, packersdk.TestUi(t), &ec2.Instance{
+		InstanceId:      aws.String("i-12345"),
+		PublicIpAddress: aws.String("198.51.100.20"),
+	})
+	if err != nil {
+		t.Fatalf("should not have error: %s", err)
+	}
+	if ip := aws.StringValue(instance.PublicIpAddress); ip != "203.0.113.10" {
+		t.Fatalf("the instance should have the Elastic IP as public address, got %s", ip)
+	}
+
+	step.Cleanup(state)
+
+	expected := []string{
+		"associate eipalloc-12345678 with i-12345",
+		"disassociate eipassoc-12345678",
+	}
+	if fmt.Sprint(calls) != fmt.Sprint(expected) {
+		t.Fatalf("expected calls %v, got %v", expected, calls)
+	}
+}
+
+func TestStepRunSourceInstance_AssociateEIPInstanceNotFound(t *testing.T) {
+	conn := FakeEC2Conn(func(r *request.Request) {
+		switch r.Params.(type) {
+		case *ec2.AssociateAddressInput:
+			r.Data.(*ec2.AssociateAddressOutput).AssociationId = aws.String("eipassoc-12345678")
+		case *ec2.DescribeInstancesInput:
+		default:
+			t.Fatalf("unexpected request: %#v", r.Params)
+		}
+	})
+
+	step := &StepRunSourceInstance{EIPAllocationId: "eipalloc-12345678"}
+	_, err := step.associateEIP(conn, packersdk.TestUi(t), &ec2.Instance{InstanceId: aws.String("i-12345")})
+	expected := "Error finding source instance after associating Elastic IP eipalloc-12345678: instance i-12345 not found"
+	if err == nil || err.Error() != expected {
+		t.Fatalf("expected error %q, got %v", expected, err)
+	}
+}
+
+// capacityEC2Conn refuses to launch instances in the availability zones it
+// lacks capacity in.
+type capacityEC2Conn struct {
+	ec2iface.EC2API
+
+	noCapacity map[string]bool
+	launches   []string
+}
+
+func (m *capacityEC2Conn) RunInstances(input *ec2.RunInstancesInput) (*ec2.Reservation, error) {
+	az := aws.StringValue(input.Placement.AvailabilityZone)
+	m.launches = append(m.launches, fmt.Sprintf("%s/%s", az, aws.StringValue(input.SubnetId)))
+	if m.noCapacity[az] {
+		return nil, awserr.New("InsufficientInstanceCapacity",
+			"We currently do not have sufficient m5.24xlarge capacity in the Availability Zone you requested", nil)
+	}
+	return &ec2.Reservation{Instances: []*ec2.Instance{{InstanceId: aws.String("i-12345")}}}, nil
+}
+
+func TestStepRunSourceInstance_InsufficientCapacity(t *testing.T) {
+	newRunOpts := func() *ec2.RunInstancesInput {
+		return &ec2.RunInstancesInput{
+			Placement: &ec2.Placement{AvailabilityZone: aws.String("us-east-1a")},
+			SubnetId:  aws.String("subnet-a"),
+		}
+	}
+	fallbacks := []*ec2.Subnet{
+		{SubnetId: aws.String("subnet-b"), AvailabilityZone: aws.String("us-east-1b")},
+		{SubnetId: aws.String("subnet-c"), AvailabilityZone: aws.String("us-east-1c")},
+	}
+
+	t.Run("rotates to the next availability zone", func(t *testing.T) {
+		conn := &capacityEC2Conn{noCapacity: map[string]bool{"us-east-1a": true}}
+		state := new(multistep.BasicStateBag)
+		state.Put("fallback_subnets", fallbacks)
+
+		step := &StepRunSourceInstance{InstanceType: "m5.24xlarge", InsufficientCapacityRetries: 2}
+		resp, err := step.runInstances(context.TODO(), conn, packersdk.TestUi(t), state, newRunOpts())
+		if err != nil {
+			t.Fatalf("should not have error: %s", err)
+		}
+		if id := aws.StringValue(resp.Instances[0].InstanceId); id != "i-12345" {
+			t.Fatalf("expected instance i-12345, got %s", id)
+		}
+
+		expected := []string{"us-east-1a/subnet-a", "us-east-1b/subnet-b"}
+		if fmt.Sprint(conn.launches) != fmt.Sprint(expected) {
+			t.Fatalf("expected launches %v, got %v", expected, conn.launches)
+		}
+		if subnet := state.Get("subnet_id"); subnet != "subnet-b" {
+			t.Fatalf("the subnet of the instance should be in the state, got %v", subnet)
+		}
+		if az := state.Get("availability_zone"); az != "us-east-1b" {
+			t.Fatalf("the availability zone of the instance should be in the state, got %v", az)
+		}
+	})
+
+	t.Run("stops after the retries", func(t *testing.T) {
+		conn := &capacityEC2Conn{noCapacity: map[string]bool{"us-east-1a": true, "us-east-1b": true}}
+		state := new(multistep.BasicStateBag)
+		state.Put("fallback_subnets", fallbacks)
+
+		step := &StepRunSourceInstance{InstanceType: "m5.24xlarge", InsufficientCapacityRetries: 1}
+		_, err := step.runInstances(context.TODO(), conn, packersdk.TestUi(t), state, newRunOpts())
+		if err == nil {
+			t.Fatal("should have error")
+		}
+		if len(conn.launches) != 2 {
+			t.Fatalf("expected 2 launches, got %v", conn.launches)
+		}
+	})
+
+	t.Run("doesn't retry by default", func(t *testing.T) {
+		conn := &capacityEC2Conn{noCapacity: map[string]bool{"us-east-1a": true}}
+		state := new(multistep.BasicStateBag)
+		state.Put("fallback_subnets", fallbacks)
+
+		step := &StepRunSourceInstance{InstanceType: "m5.24xlarge"}
+		if _, err := step.runInstances(context.TODO(), conn, packersdk.TestUi(t), state, newRunOpts()); err == nil {
+			t.Fatal("should have error")
+		}
+		if len(conn.launches) != 1 {
+			t.Fatalf("expected 1 launch, got %v", conn.launches)
+		}
+	})
+}
+
+func TestStepRunSourceInstance_NetworkOptions(t *testing.T) {
+	step := &StepRunSourceInstance{
+		EnablePrimaryIpv6: true,
+		PrivateDnsNameOptions: PrivateDnsNameOptions{
+			HostnameType:                    "resource-name",
+			EnableResourceNameDnsAAAARecord: true,
+		},
+	}
+	runOpts := &ec2.RunInstancesInput{}
+	step.setNetworkOptions(packersdk.TestUi(t), runOpts, "subnet-12345678", aws.StringSlice([]string{"sg-12345678"}))
+
+	if runOpts.SubnetId != nil || runOpts.SecurityGroupIds != nil {
+		t.Fatalf("the subnet and security groups should be set on the network interface, got %s", runOpts)
+	}
+	if len(runOpts.NetworkInterfaces) != 1 {
+		t.Fatalf("expected a network interface, got %s", runOpts)
+	}
+	networkInterface := runOpts.NetworkInterfaces[0]
+	if !aws.BoolValue(networkInterface.PrimaryIpv6) {
+		t.Errorf("the network interface should have a primary IPv6 address, got %s", networkInterface)
+	}
+	if aws.StringValue(networkInterface.SubnetId) != "subnet-12345678" || aws.Int64Value(networkInterface.DeviceIndex) != 0 {
+		t.Errorf("the network interface should be the primary one of the subnet, got %s", networkInterface)
+	}
+	if networkInterface.AssociatePublicIpAddress != nil {
+		t.Errorf("the public IP address setting should be left to the subnet, got %s", networkInterface)
+	}
+
+	options := runOpts.PrivateDnsNameOptions
+	if options == nil || aws.StringValue(options.HostnameType) != "resource-name" ||
+		!aws.BoolValue(options.EnableResourceNameDnsAAAARecord) || aws.BoolValue(options.EnableResourceNameDnsARecord) {
+		t.Errorf("unexpected private DNS name options: %s", options)
+	}
+
+	// Without options that need it, no network interface is specified.
+	step = &StepRunSourceInstance{}
+	runOpts = &ec2.RunInstancesInput{}
+	step.setNetworkOptions(packersdk.TestUi(t), runOpts, "subnet-12345678", aws.StringSlice([]string{"sg-12345678"}))
+	if runOpts.NetworkInterfaces != nil || runOpts.PrivateDnsNameOptions != nil {
+		t.Fatalf("no network interface nor private DNS name options should be set, got %s", runOpts)
+	}
+	if aws.StringValue(runOpts.SubnetId) != "subnet-12345678" {
+		t.Fatalf("the subnet should be set on the instance, got %s", runOpts)
+	}
+}
+
+type ipv6SubnetEC2Conn struct {
+	ec2iface.EC2API
+
+	subnets map[string]*ec2.Subnet
+}
+
+func (m *ipv6SubnetEC2Conn) DescribeSubnets(input *ec2.DescribeSubnetsInput) (*ec2.DescribeSubnetsOutput, error) {
+	return &ec2.DescribeSubnetsOutput{Subnets: []*ec2.Subnet{m.subnets[aws.StringValue(input.SubnetIds[0])]}}, nil
+}
+
+func TestCheckSubnetIpv6(t *testing.T) {
+	conn := &ipv6SubnetEC2Conn{subnets: map[string]*ec2.Subnet{
+		"subnet-ipv4": {SubnetId: aws.String("subnet-ipv4")},
+		"subnet-dual": {
+			SubnetId: aws.String("subnet-dual"),
+			Ipv6CidrBlockAssociationSet: []*ec2.SubnetIpv6CidrBlockAssociation{{
+				Ipv6CidrBlock:      aws.String("2001:db8::/64"),
+				Ipv6CidrBlockState: &ec2.SubnetCidrBlockState{State: aws.String(ec2.SubnetCidrBlockStateCodeAssociated)},
+			}},
+		},
+	}}
+
+	if err := checkSubnetIpv6(conn, "subnet-dual", true, PrivateDnsNameOptions{}); err != nil {
+		t.Errorf("a dual stack subnet should support the primary IPv6 address: %s", err)
+	}
+	if err := checkSubnetIpv6(conn, "subnet-ipv4", true, PrivateDnsNameOptions{}); err == nil {
+		t.Errorf("an IPv4 only subnet should not support the primary IPv6 address")
+	}
+	if err := checkSubnetIpv6(conn, "subnet-ipv4", false, PrivateDnsNameOptions{EnableResourceNameDnsAAAARecord: true}); err == nil {
+		t.Errorf("an IPv4 only subnet should not support AAAA records")
+	}
+	if err := checkSubnetIpv6(conn, "subnet-ipv4", false, PrivateDnsNameOptions{HostnameType: "resource-name"}); err != nil {
+		t.Errorf("an IPv4 only subnet should support resource name hostnames: %s", err)
+	}
+}
@@ -5,15 +5,16 @@ package common
 
 import (
 	"context"
-	"encoding/base64"
 	"errors"
 	"fmt"
-	"io/ioutil"
 	"log"
+	"slices"
+	"sort"
 	"strings"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
 	"github.com/hashicorp/packer-plugin-amazon/builder/common/awserrors"
@@ -46,24 +47,56 @@ type StepRunSpotInstance struct {
 	InstanceMetadataTags              string
 	InstanceInitiatedShutdownBehavior string
 	InstanceType                      string
+	LogSpotPrices                     bool
 	Region                            string
 	SourceAMI                         string
 	SpotAllocationStrategy            string
 	SpotPrice                         string
+	SpotRequestTimeout                time.Duration
 	SpotTags                          map[string]string
 	SpotInstanceTypes                 []string
 	Tags                              map[string]string
 	VolumeTags                        map[string]string
 	UserData                          string
 	UserDataFile                      string
+	UserDataS3Url                     string
+	UserDataSSMParameter              string
 	Ctx                               interpolate.Context
 	NoEphemeral                       bool
 	IsBurstableInstanceType           bool
 	EnableUnlimitedCredits            bool
+	EnablePrimaryIpv6                 bool
+	PrivateDnsNameOptions             PrivateDnsNameOptions
 
 	instanceId string
 }
 
+// spotCapacityErrorCodes are the fleet error codes reported when the spot
+// capacity needed to fulfill the request is not currently available.
+var spotCapacityErrorCodes = []string{
+	"InsufficientInstanceCapacity",
+	"UnfulfillableCapacity",
+	"capacity-not-available",
+	"capacity-oversubscribed",
+}
+
+// fleetErrorsSummary formats the status codes and messages of the fleet
+// errors so they can be surfaced to the user.
+func fleetErrorsSummary(fleetErrors []*ec2.CreateFleetError) string {
+	if len(fleetErrors) == 0 {
+		return "no status reported"
+	}
+	var statuses []string
+	for _, fleetErr := range fleetErrors {
+		status := aws.StringValue(fleetErr.ErrorCode)
+		if msg := aws.StringValue(fleetErr.ErrorMessage); msg != "" {
+			status = fmt.Sprintf("%s: %s", status, msg)
+		}
+		statuses = append(statuses, status)
+	}
+	return strings.Join(statuses, "; ")
+}
+
 // The EbsBlockDevice and LaunchTemplateEbsBlockDeviceRequest structs are
 // nearly identical except for the struct's name and one extra field in
 // EbsBlockDeviceResuest, which unfortunately means you can't just cast one
@@ -154,18 +187,24 @@ func (s *StepRunSpotInstance) CreateTemplateData(userData *string, az string,
 				subnetId))
 			networkInterface.SetAssociatePublicIpAddress(*s.AssociatePublicIpAddress.ToBoolPointer())
 		}
+		if s.EnablePrimaryIpv6 {
+			networkInterface.SetPrimaryIpv6(true)
+		}
 		templateData.SetNetworkInterfaces([]*ec2.LaunchTemplateInstanceNetworkInterfaceSpecificationRequest{&networkInterface})
 	} else {
 		templateData.SetSecurityGroupIds(securityGroupIds)
 
 	}
 
-	if s.IsBurstableInstanceType {
-		templateData.CreditSpecification = &ec2.CreditSpecificationRequest{CpuCredits: aws.String(CPUCreditsStandard)}
-	}
+	templateData.CreditSpecification = creditSpecification(s.IsBurstableInstanceType, s.EnableUnlimitedCredits)
 
-	if s.EnableUnlimitedCredits {
-		templateData.CreditSpecification = &ec2.CreditSpecificationRequest{CpuCredits: aws.String(CPUCreditsUnlimited)}
+	if !s.PrivateDnsNameOptions.Empty() {
+		options := s.PrivateDnsNameOptions.request()
+		templateData.PrivateDnsNameOptions = &ec2.LaunchTemplatePrivateDnsNameOptionsRequest{
+			HostnameType:                    options.HostnameType,
+			EnableResourceNameDnsARecord:    options.EnableResourceNameDnsARecord,
+			EnableResourceNameDnsAAAARecord: options.EnableResourceNameDnsAAAARecord,
+		}
 	}
 
 	if s.HttpEndpoint == "enabled" {
@@ -193,23 +232,75 @@ func (s *StepRunSpotInstance) CreateTemplateData(userData *string, az string,
 	return &templateData
 }
 
-func (s *StepRunSpotInstance) LoadUserData() (string, error) {
-	userData := s.UserData
-	if s.UserDataFile != "" {
-		contents, err := ioutil.ReadFile(s.UserDataFile)
+// logSpotPrices reports the current spot prices of the requested instance
+// types in the availability zone of the build, or in every zone of the region
+// if none is set. Failing to retrieve them does not fail the build.
+func (s *StepRunSpotInstance) logSpotPrices(ec2conn ec2iface.EC2API, ui packersdk.Ui, az string) {
+	instanceTypes := s.SpotInstanceTypes
+	if s.InstanceType != "" {
+		instanceTypes = []string{s.InstanceType}
+	}
+
+	input := &ec2.DescribeSpotPriceHistoryInput{
+		InstanceTypes: aws.StringSlice(instanceTypes),
+		StartTime:     aws.Time(time.Now()),
+	}
+	if az != "" {
+		input.AvailabilityZone = aws.String(az)
+	}
+
+	var prices []*ec2.SpotPrice
+	for {
+		resp, err := ec2conn.DescribeSpotPriceHistory(input)
 		if err != nil {
-			return "", fmt.Errorf("Problem reading user data file: %s", err)
+			ui.Error(fmt.Sprintf("Unable to retrieve spot prices: %s", err))
+			return
+		}
+		prices = append(prices, resp.SpotPriceHistory...)
+		if aws.StringValue(resp.NextToken) == "" {
+			break
 		}
+		input.NextToken = resp.NextToken
+	}
 
-		userData = string(contents)
+	if len(prices) == 0 {
+		ui.Message("No spot prices found for the requested instance types")
+		return
 	}
 
-	// Test if it is encoded already, and if not, encode it
-	if _, err := base64.StdEncoding.DecodeString(userData); err != nil {
-		log.Printf("[DEBUG] base64 encoding user data...")
-		userData = base64.StdEncoding.EncodeToString([]byte(userData))
+	sort.Slice(prices, func(i, j int) bool {
+		if aws.StringValue(prices[i].InstanceType) != aws.StringValue(prices[j].InstanceType) {
+			return aws.StringValue(prices[i].InstanceType) < aws.StringValue(prices[j].InstanceType)
+		}
+		if aws.StringValue(prices[i].AvailabilityZone) != aws.StringValue(prices[j].AvailabilityZone) {
+			return aws.StringValue(prices[i].AvailabilityZone) < aws.StringValue(prices[j].AvailabilityZone)
+		}
+		return aws.StringValue(prices[i].ProductDescription) < aws.StringValue(prices[j].ProductDescription)
+	})
+
+	ui.Say("Current spot prices:")
+	for _, price := range prices {
+		ui.Message(fmt.Sprintf("%s in %s (%s): $%s/hour",
+			aws.StringValue(price.InstanceType),
+			aws.StringValue(price.AvailabilityZone),
+			aws.StringValue(price.ProductDescription),
+			aws.StringValue(price.SpotPrice)))
 	}
-	return userData, nil
+}
+
+// LoadUserData returns the user data of user_data or user_data_file. The
+// other sources are read with the AWS session of LoadUserDataWithSession.
+func (s *StepRunSpotInstance) LoadUserData() (string, error) {
+	if s.UserDataS3Url != "" || s.UserDataSSMParameter != "" {
+		return "", fmt.Errorf("user_data_s3_url and user_data_ssm_parameter need an AWS session to be read")
+	}
+	return s.LoadUserDataWithSession(nil)
+}
+
+// LoadUserDataWithSession returns the user data of whichever of its sources
+// is set, reading user_data_s3_url and user_data_ssm_parameter with sess.
+func (s *StepRunSpotInstance) LoadUserDataWithSession(sess *session.Session) (string, error) {
+	return newUserDataLoader(sess, s.UserData, s.UserDataFile, s.UserDataS3Url, s.UserDataSSMParameter).Load()
 }
 
 func (s *StepRunSpotInstance) Run(ctx context.Context, state multistep.StateBag) multistep.StepAction {
@@ -240,6 +331,17 @@ func (s *StepRunSpotInstance) Run(ctx context.Context, state multistep.StateBag)
 	}
 	az := azConfig
 
+	if s.LogSpotPrices {
+		s.logSpotPrices(ec2conn, ui, az)
+	}
+
+	subnetId, _ := state.Get("subnet_id").(string)
+	if err := checkSubnetIpv6(ec2conn, subnetId, s.EnablePrimaryIpv6, s.PrivateDnsNameOptions); err != nil {
+		state.Put("error", err)
+		ui.Error(err.Error())
+		return multistep.ActionHalt
+	}
+
 	var instanceId string
 
 	ui.Say("Interpolating tags for spot instance...")
@@ -296,7 +398,8 @@ func (s *StepRunSpotInstance) Run(ctx context.Context, state multistep.StateBag)
 		random.AlphaNum(7))
 	state.Put("launchTemplateName", launchTemplateName) // For the cleanup step
 
-	userData, err := s.LoadUserData()
+	sess, _ := state.Get("awsSession").(*session.Session)
+	userData, err := s.LoadUserDataWithSession(sess)
 	if err != nil {
 		state.Put("error", err)
 		return multistep.ActionHalt
@@ -411,7 +514,7 @@ func (s *StepRunSpotInstance) Run(ctx context.Context, state multistep.StateBag)
 	}
 
 	var createOutput *ec2.CreateFleetOutput
-	err = retry.Config{
+	retryConfig := retry.Config{
 		Tries: 11,
 		ShouldRetry: func(err error) bool {
 			if strings.Contains(err.Error(), "Invalid IAM Instance Profile name") {
@@ -420,15 +523,26 @@ func (s *StepRunSpotInstance) Run(ctx context.Context, state multistep.StateBag)
 				// we can wait on those operations, this can be removed.
 				return true
 			}
-			if err.Error() == "InsufficientInstanceCapacity" {
+			if slices.Contains(spotCapacityErrorCodes, err.Error()) {
 				return true
 			}
 			return false
 		},
 		RetryDelay: (&retry.Backoff{InitialBackoff: 500 * time.Millisecond, MaxBackoff: 30 * time.Second, Multiplier: 2}).Linear,
-	}.Run(ctx, func(ctx context.Context) error {
+	}
+	if s.SpotRequestTimeout > 0 {
+		// Keep retrying until the spot request is fulfilled or the timeout
+		// expires, instead of giving up after a fixed number of tries.
+		retryConfig.Tries = 0
+		retryConfig.StartTimeout = s.SpotRequestTimeout
+	}
+	err = retryConfig.Run(ctx, func(ctx context.Context) error {
 		createOutput, err = ec2conn.CreateFleet(createFleetInput)
-		if err == nil && createOutput.Errors != nil {
+		if err != nil {
+			log.Printf("create request failed %v", err)
+			return err
+		}
+		if createOutput.Errors != nil {
 			err = fmt.Errorf("errors: %v", createOutput.Errors)
 		}
 		// We can end up with errors because one of the allowed availability
@@ -445,23 +559,31 @@ func (s *StepRunSpotInstance) Run(ctx context.Context, state multistep.StateBag)
 		}
 		// We can end up unavailable Spot capacity, we keep retrying
 		for _, err := range createOutput.Errors {
-			if err.ErrorCode != nil && *err.ErrorCode == "InsufficientInstanceCapacity" {
+			if err.ErrorCode != nil && slices.Contains(spotCapacityErrorCodes, *err.ErrorCode) {
 				return fmt.Errorf("%s", *err.ErrorCode)
 			}
 		}
+		if err == nil {
+			err = fmt.Errorf("no instances were launched by fleet request")
+		}
 		return err
 	})
 
 	if err != nil {
-		if createOutput.FleetId != nil {
-			err = fmt.Errorf("Error waiting for fleet request (%s): %s", *createOutput.FleetId, err)
-		}
-		if len(createOutput.Errors) > 0 {
-			errString := fmt.Sprintf("Error waiting for fleet request (%s) to become ready:", *createOutput.FleetId)
-			for _, outErr := range createOutput.Errors {
-				errString = errString + aws.StringValue(outErr.ErrorMessage)
+		if createOutput != nil && s.SpotRequestTimeout > 0 && slices.Contains(spotCapacityErrorCodes, err.Error()) {
+			err = fmt.Errorf("Timed out after %s waiting for spot request fulfillment: %s",
+				s.SpotRequestTimeout, fleetErrorsSummary(createOutput.Errors))
+		} else if createOutput != nil {
+			if createOutput.FleetId != nil {
+				err = fmt.Errorf("Error waiting for fleet request (%s): %s", *createOutput.FleetId, err)
+			}
+			if len(createOutput.Errors) > 0 {
+				errString := fmt.Sprintf("Error waiting for fleet request (%s) to become ready:", aws.StringValue(createOutput.FleetId))
+				for _, outErr := range createOutput.Errors {
+					errString = errString + aws.StringValue(outErr.ErrorMessage)
+				}
+				err = errors.New(errString)
 			}
-			err = errors.New(errString)
 		}
 		state.Put("error", err)
 		ui.Error(err.Error())
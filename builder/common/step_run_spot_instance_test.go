@@ -7,7 +7,9 @@ import (
 	"bytes"
 	"context"
 	"fmt"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/request"
@@ -65,6 +67,25 @@ func getBasicStep() *StepRunSpotInstance {
 	return &stepRunSpotInstance
 }
 
+func TestLoadUserData(t *testing.T) {
+	stepRunSpotInstance := getBasicStep()
+	stepRunSpotInstance.UserData = "#!/bin/sh"
+
+	userData, err := stepRunSpotInstance.LoadUserData()
+	if err != nil {
+		t.Fatalf("should not have error: %s", err)
+	}
+	if userData != "IyEvYmluL3No" {
+		t.Fatalf("expected the user data to be base64 encoded, got %q", userData)
+	}
+
+	// The S3 and SSM sources can't be read without a session.
+	stepRunSpotInstance.UserDataS3Url = "s3://bucket/user-data.sh"
+	if _, err := stepRunSpotInstance.LoadUserData(); err == nil {
+		t.Fatal("should error without a session to read user_data_s3_url with")
+	}
+}
+
 func TestCreateTemplateData(t *testing.T) {
 	state := tStateSpot()
 	stepRunSpotInstance := getBasicStep()
@@ -106,6 +127,27 @@ func TestCreateTemplateData(t *testing.T) {
 	}
 }
 
+func TestCreateTemplateData_Ipv6Options(t *testing.T) {
+	state := tStateSpot()
+	stepRunSpotInstance := getBasicStep()
+	stepRunSpotInstance.EnablePrimaryIpv6 = true
+	stepRunSpotInstance.PrivateDnsNameOptions = PrivateDnsNameOptions{
+		HostnameType:                 "resource-name",
+		EnableResourceNameDnsARecord: true,
+	}
+	template := stepRunSpotInstance.CreateTemplateData(aws.String("userdata"), "az", state,
+		&ec2.LaunchTemplateInstanceMarketOptionsRequest{})
+
+	if len(template.NetworkInterfaces) != 1 || !aws.BoolValue(template.NetworkInterfaces[0].PrimaryIpv6) {
+		t.Fatalf("the network interface should have a primary IPv6 address, got %s", template.NetworkInterfaces)
+	}
+	options := template.PrivateDnsNameOptions
+	if options == nil || aws.StringValue(options.HostnameType) != "resource-name" ||
+		!aws.BoolValue(options.EnableResourceNameDnsARecord) || aws.BoolValue(options.EnableResourceNameDnsAAAARecord) {
+		t.Fatalf("unexpected private DNS name options: %s", options)
+	}
+}
+
 func TestCreateTemplateData_NoEphemeral(t *testing.T) {
 	state := tStateSpot()
 	stepRunSpotInstance := getBasicStep()
@@ -159,6 +201,9 @@ type runSpotEC2ConnMock struct {
 
 	DescribeInstancesParams []*ec2.DescribeInstancesInput
 	DescribeInstancesFn     func(input *ec2.DescribeInstancesInput) (*ec2.DescribeInstancesOutput, error)
+
+	DescribeSpotPriceHistoryParams []*ec2.DescribeSpotPriceHistoryInput
+	DescribeSpotPriceHistoryFn     func(input *ec2.DescribeSpotPriceHistoryInput) (*ec2.DescribeSpotPriceHistoryOutput, error)
 }
 
 func (m *runSpotEC2ConnMock) CreateLaunchTemplate(req *ec2.CreateLaunchTemplateInput) (*ec2.CreateLaunchTemplateOutput, error) {
@@ -187,6 +232,16 @@ func (m *runSpotEC2ConnMock) DescribeInstances(req *ec2.DescribeInstancesInput)
 	}
 }
 
+func (m *runSpotEC2ConnMock) DescribeSpotPriceHistory(req *ec2.DescribeSpotPriceHistoryInput) (*ec2.DescribeSpotPriceHistoryOutput, error) {
+	m.DescribeSpotPriceHistoryParams = append(m.DescribeSpotPriceHistoryParams, req)
+	if m.DescribeSpotPriceHistoryFn != nil {
+		resp, err := m.DescribeSpotPriceHistoryFn(req)
+		return resp, err
+	} else {
+		return nil, nil
+	}
+}
+
 func (m *runSpotEC2ConnMock) WaitUntilInstanceRunningWithContext(ctx context.Context, _ *ec2.DescribeInstancesInput, opts ...request.WaiterOption) error {
 	return nil
 }
@@ -387,3 +442,145 @@ func TestRun_NoSpotTags(t *testing.T) {
 		t.Fatalf("0 launch template tags expected")
 	}
 }
+
+func TestRun_SpotRequestTimeout(t *testing.T) {
+	instanceId := aws.String("test-instance-id")
+	spotRequestId := aws.String("spot-id")
+	volumeId := aws.String("volume-id")
+	launchTemplateId := aws.String("lt-id")
+	ec2Mock := defaultEc2Mock(instanceId, spotRequestId, volumeId, launchTemplateId)
+	ec2Mock.CreateFleetFn = func(*ec2.CreateFleetInput) (*ec2.CreateFleetOutput, error) {
+		return &ec2.CreateFleetOutput{
+			FleetId: aws.String("fleet-id"),
+			Errors: []*ec2.CreateFleetError{
+				{
+					ErrorCode:    aws.String("capacity-not-available"),
+					ErrorMessage: aws.String("There is no Spot capacity available that matches your request."),
+				},
+			},
+		}, nil
+	}
+
+	uiMock := packersdk.TestUi(t)
+
+	state := tStateSpot()
+	state.Put("ec2", ec2Mock)
+	state.Put("ui", uiMock)
+	state.Put("source_image", testImage())
+
+	stepRunSpotInstance := getBasicStep()
+	stepRunSpotInstance.SpotRequestTimeout = 10 * time.Millisecond
+
+	ctx := context.TODO()
+	action := stepRunSpotInstance.Run(ctx, state)
+
+	if action != multistep.ActionHalt {
+		t.Fatalf("should halt, but: %v", action)
+	}
+
+	if len(ec2Mock.CreateFleetParams) < 2 {
+		t.Fatalf("createFleet should be retried until the timeout, but invoked %v", len(ec2Mock.CreateFleetParams))
+	}
+
+	err, ok := state.Get("error").(error)
+	if !ok {
+		t.Fatalf("should error")
+	}
+	for _, expected := range []string{
+		"Timed out after 10ms",
+		"capacity-not-available",
+		"There is no Spot capacity available that matches your request.",
+	} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Fatalf("error %q should contain %q", err, expected)
+		}
+	}
+}
+
+func TestRun_LogSpotPrices(t *testing.T) {
+	instanceId := aws.String("test-instance-id")
+	spotRequestId := aws.String("spot-id")
+	volumeId := aws.String("volume-id")
+	launchTemplateId := aws.String("lt-id")
+	ec2Mock := defaultEc2Mock(instanceId, spotRequestId, volumeId, launchTemplateId)
+	ec2Mock.DescribeSpotPriceHistoryFn = func(input *ec2.DescribeSpotPriceHistoryInput) (*ec2.DescribeSpotPriceHistoryOutput, error) {
+		if input.NextToken == nil {
+			return &ec2.DescribeSpotPriceHistoryOutput{
+				NextToken: aws.String("page-2"),
+				SpotPriceHistory: []*ec2.SpotPrice{
+					{
+						AvailabilityZone:   aws.String("us-east-1a"),
+						InstanceType:       aws.String("t3.small"),
+						ProductDescription: aws.String("Linux/UNIX"),
+						SpotPrice:          aws.String("0.006300"),
+					},
+				},
+			}, nil
+		}
+		return &ec2.DescribeSpotPriceHistoryOutput{
+			SpotPriceHistory: []*ec2.SpotPrice{
+				{
+					AvailabilityZone:   aws.String("us-east-1a"),
+					InstanceType:       aws.String("t3.medium"),
+					ProductDescription: aws.String("Linux/UNIX"),
+					SpotPrice:          aws.String("0.012500"),
+				},
+			},
+		}, nil
+	}
+
+	out := new(bytes.Buffer)
+	ui := &packersdk.BasicUi{
+		Reader: new(bytes.Buffer),
+		Writer: out,
+	}
+
+	state := tStateSpot()
+	state.Put("ec2", ec2Mock)
+	state.Put("ui", ui)
+	state.Put("source_image", testImage())
+	state.Put("availability_zone", "us-east-1a")
+
+	stepRunSpotInstance := getBasicStep()
+	stepRunSpotInstance.InstanceType = ""
+	stepRunSpotInstance.SpotInstanceTypes = []string{"t3.small", "t3.medium"}
+	stepRunSpotInstance.LogSpotPrices = true
+
+	if action := stepRunSpotInstance.Run(context.TODO(), state); action != multistep.ActionContinue {
+		t.Fatalf("should continue, but: %v: %v", action, state.Get("error"))
+	}
+
+	if len(ec2Mock.DescribeSpotPriceHistoryParams) != 2 {
+		t.Fatalf("describeSpotPriceHistory should be invoked twice, but invoked %v", len(ec2Mock.DescribeSpotPriceHistoryParams))
+	}
+	input := ec2Mock.DescribeSpotPriceHistoryParams[0]
+	if aws.StringValue(input.AvailabilityZone) != "us-east-1a" {
+		t.Fatalf("spot prices should be requested for the build availability zone, got %q", aws.StringValue(input.AvailabilityZone))
+	}
+	if types := aws.StringValueSlice(input.InstanceTypes); len(types) != 2 || types[0] != "t3.small" || types[1] != "t3.medium" {
+		t.Fatalf("spot prices should be requested for the spot instance types, got %v", types)
+	}
+
+	expected := "t3.medium in us-east-1a (Linux/UNIX): $0.012500/hour\n" +
+		"t3.small in us-east-1a (Linux/UNIX): $0.006300/hour\n"
+	if !strings.Contains(out.String(), expected) {
+		t.Fatalf("expected output to contain:\n%s\ngot:\n%s", expected, out.String())
+	}
+}
+
+func TestRun_NoLogSpotPrices(t *testing.T) {
+	ec2Mock := defaultEc2Mock(aws.String("test-instance-id"), aws.String("spot-id"), aws.String("volume-id"), aws.String("lt-id"))
+
+	state := tStateSpot()
+	state.Put("ec2", ec2Mock)
+	state.Put("ui", packersdk.TestUi(t))
+	state.Put("source_image", testImage())
+
+	if action := getBasicStep().Run(context.TODO(), state); action != multistep.ActionContinue {
+		t.Fatalf("should continue, but: %v: %v", action, state.Get("error"))
+	}
+
+	if len(ec2Mock.DescribeSpotPriceHistoryParams) != 0 {
+		t.Fatalf("describeSpotPriceHistory should not be invoked unless log_spot_prices is set")
+	}
+}
@@ -9,7 +9,9 @@ import (
 	"sort"
 	"time"
 
+	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
 	"github.com/hashicorp/packer-plugin-sdk/multistep"
 	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
 	"github.com/hashicorp/packer-plugin-sdk/template/config"
@@ -26,8 +28,11 @@ type StepSourceAMIInfo struct {
 	EnableAMISriovNetSupport bool
 	EnableAMIENASupport      config.Trilean
 	AMIVirtType              string
+	MatchAMIVirtType         bool
 	AmiFilters               AmiFilterOptions
+	AmiFilterGroups          AmiFilterGroupOptions
 	IncludeDeprecated        bool
+	AllowedOwners            []string
 }
 
 type imageSort []*ec2.Image
@@ -59,7 +64,13 @@ func (s *StepSourceAMIInfo) Run(ctx context.Context, state multistep.StateBag) m
 		params.ImageIds = []*string{&s.SourceAmi}
 	}
 
-	image, err := s.AmiFilters.GetFilteredImage(params, ec2conn)
+	var image *ec2.Image
+	var err error
+	if len(s.AmiFilterGroups.FilterGroups) > 0 {
+		image, err = s.AmiFilterGroups.GetFilteredImage(params, ec2conn)
+	} else {
+		image, err = s.AmiFilters.GetFilteredImage(params, ec2conn)
+	}
 	if err != nil {
 		state.Put("error", err)
 		ui.Error(err.Error())
@@ -68,6 +79,12 @@ func (s *StepSourceAMIInfo) Run(ctx context.Context, state multistep.StateBag) m
 
 	ui.Message(fmt.Sprintf("Found Image ID: %s", *image.ImageId))
 
+	if err := s.checkOwner(ec2conn, image); err != nil {
+		state.Put("error", err)
+		ui.Error(err.Error())
+		return multistep.ActionHalt
+	}
+
 	// Enhanced Networking can only be enabled on HVM AMIs.
 	// See http://goo.gl/icuXh5
 	if s.EnableAMIENASupport.True() || s.EnableAMISriovNetSupport {
@@ -79,6 +96,14 @@ func (s *StepSourceAMIInfo) Run(ctx context.Context, state multistep.StateBag) m
 		}
 	}
 
+	if s.MatchAMIVirtType {
+		if err := s.checkVirtType(image); err != nil {
+			state.Put("error", err)
+			ui.Error(err.Error())
+			return multistep.ActionHalt
+		}
+	}
+
 	state.Put("source_image", image)
 	return multistep.ActionContinue
 }
@@ -97,3 +122,51 @@ func (s *StepSourceAMIInfo) canEnableEnhancedNetworking(image *ec2.Image) error
 	}
 	return nil
 }
+
+// checkVirtType makes sure the AMI is registered with the virtualization type
+// of the source AMI, as an AMI registered from its disk with another one would
+// not boot.
+func (s *StepSourceAMIInfo) checkVirtType(image *ec2.Image) error {
+	if s.AMIVirtType == "" || image.VirtualizationType == nil {
+		return nil
+	}
+	if *image.VirtualizationType != s.AMIVirtType {
+		return fmt.Errorf("ami_virtualization_type %q doesn't match the virtualization type of "+
+			"the source AMI %s (%s), the resulting AMI would not boot. Set ami_virtualization_type "+
+			"to %q or use a %s source AMI.", s.AMIVirtType, aws.StringValue(image.ImageId),
+			*image.VirtualizationType, *image.VirtualizationType, s.AMIVirtType)
+	}
+	return nil
+}
+
+// checkOwner makes sure the source AMI belongs to one of the allowed owners,
+// matched against either its owner account ID or its owner alias. The `self`
+// owner is left to EC2, which only describes the AMI with the `self` owner if
+// the account of the build owns it.
+func (s *StepSourceAMIInfo) checkOwner(ec2conn ec2iface.EC2API, image *ec2.Image) error {
+	if len(s.AllowedOwners) == 0 {
+		return nil
+	}
+	allowSelf := false
+	for _, owner := range s.AllowedOwners {
+		if owner == aws.StringValue(image.OwnerId) || owner == aws.StringValue(image.ImageOwnerAlias) {
+			return nil
+		}
+		allowSelf = allowSelf || owner == "self"
+	}
+	if allowSelf {
+		resp, err := ec2conn.DescribeImages(&ec2.DescribeImagesInput{
+			ImageIds:          []*string{image.ImageId},
+			Owners:            []*string{aws.String("self")},
+			IncludeDeprecated: aws.Bool(true),
+		})
+		if err != nil {
+			return fmt.Errorf("Error checking whether source AMI %s is owned by self: %s", aws.StringValue(image.ImageId), err)
+		}
+		if len(resp.Images) > 0 {
+			return nil
+		}
+	}
+	return fmt.Errorf("Source AMI %s is owned by %s, which is not in allowed_source_ami_owners %v",
+		aws.StringValue(image.ImageId), aws.StringValue(image.OwnerId), s.AllowedOwners)
+}
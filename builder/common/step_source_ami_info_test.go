@@ -4,10 +4,14 @@
 package common
 
 import (
+	"context"
 	"testing"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -44,3 +48,197 @@ func TestStepSourceAmiInfo_PVImageWithAMIVirtHVM(t *testing.T) {
 	})
 	assert.NoError(t, err)
 }
+
+// describeImagesConn returns an EC2 client answering DescribeImages with the
+// images registered for the value of the "name" filter.
+func describeImagesConn(imagesByName map[string][]*ec2.Image) *ec2.EC2 {
+	conn := FakeEC2Conn(func(r *request.Request) {
+		input := r.Params.(*ec2.DescribeImagesInput)
+		output := r.Data.(*ec2.DescribeImagesOutput)
+		// The account of the connection is 222222222222.
+		if len(input.ImageIds) > 0 && aws.StringValue(input.Owners[0]) == "self" {
+			for _, images := range imagesByName {
+				for _, image := range images {
+					if aws.StringValue(image.ImageId) == aws.StringValue(input.ImageIds[0]) &&
+						aws.StringValue(image.OwnerId) == "222222222222" {
+						output.Images = []*ec2.Image{image}
+					}
+				}
+			}
+			return
+		}
+		for _, filter := range input.Filters {
+			if aws.StringValue(filter.Name) == "name" {
+				output.Images = append(output.Images, imagesByName[aws.StringValue(filter.Values[0])]...)
+			}
+		}
+	})
+	return conn
+}
+
+func TestStepSourceAmiInfo_FilterGroups(t *testing.T) {
+	image := func(id, creationDate string) *ec2.Image {
+		return &ec2.Image{
+			ImageId:      aws.String(id),
+			CreationDate: aws.String(creationDate),
+		}
+	}
+	conn := describeImagesConn(map[string][]*ec2.Image{
+		"jammy": {image("ami-jammy", "2024-01-01T00:00:00Z"), image("ami-shared", "2024-02-01T00:00:00Z")},
+		"noble": {image("ami-shared", "2024-02-01T00:00:00Z"), image("ami-noble", "2024-06-01T00:00:00Z")},
+	})
+
+	groups := []AmiFilterOptions{
+		{Owners: []string{"099720109477"}, Filters: map[string]string{"name": "jammy"}},
+		{Owners: []string{"099720109477"}, Filters: map[string]string{"name": "noble"}},
+	}
+
+	tests := []struct {
+		name          string
+		filters       AmiFilterGroupOptions
+		expectedImage string
+		expectedError bool
+	}{
+		{
+			name:          "or returns the newest image of the union",
+			filters:       AmiFilterGroupOptions{Operator: "or", FilterGroups: groups, MostRecent: true},
+			expectedImage: "ami-noble",
+		},
+		{
+			name:          "or fails on several images without most_recent",
+			filters:       AmiFilterGroupOptions{Operator: "or", FilterGroups: groups},
+			expectedError: true,
+		},
+		{
+			name:          "and returns the intersection",
+			filters:       AmiFilterGroupOptions{Operator: "and", FilterGroups: groups},
+			expectedImage: "ami-shared",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			state := new(multistep.BasicStateBag)
+			state.Put("ec2", conn)
+			state.Put("ui", packersdk.TestUi(t))
+
+			step := &StepSourceAMIInfo{AmiFilterGroups: tt.filters}
+			action := step.Run(context.Background(), state)
+
+			if tt.expectedError {
+				assert.Equal(t, multistep.ActionHalt, action)
+				return
+			}
+			assert.Equal(t, multistep.ActionContinue, action, "error: %v", state.Get("error"))
+			sourceImage := state.Get("source_image").(*ec2.Image)
+			assert.Equal(t, tt.expectedImage, aws.StringValue(sourceImage.ImageId))
+		})
+	}
+}
+
+func TestStepSourceAmiInfo_MatchAMIVirtType(t *testing.T) {
+	conn := describeImagesConn(map[string][]*ec2.Image{
+		"hvm-image": {{
+			ImageId:            aws.String("ami-hvm"),
+			CreationDate:       aws.String("2024-01-01T00:00:00Z"),
+			VirtualizationType: aws.String("hvm"),
+		}},
+	})
+
+	tests := []struct {
+		name          string
+		virtType      string
+		match         bool
+		expectedError bool
+	}{
+		{name: "paravirtual config with an hvm source", virtType: "paravirtual", match: true, expectedError: true},
+		{name: "hvm config with an hvm source", virtType: "hvm", match: true},
+		{name: "unset config", virtType: "", match: true},
+		{name: "check disabled", virtType: "paravirtual", match: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			state := new(multistep.BasicStateBag)
+			state.Put("ec2", conn)
+			state.Put("ui", packersdk.TestUi(t))
+
+			step := &StepSourceAMIInfo{
+				AMIVirtType:      tt.virtType,
+				MatchAMIVirtType: tt.match,
+				AmiFilters: AmiFilterOptions{
+					Owners:  []string{"self"},
+					Filters: map[string]string{"name": "hvm-image"},
+				},
+			}
+			action := step.Run(context.Background(), state)
+
+			if tt.expectedError {
+				assert.Equal(t, multistep.ActionHalt, action)
+				assert.Contains(t, state.Get("error").(error).Error(), "source AMI ami-hvm (hvm)")
+				return
+			}
+			assert.Equal(t, multistep.ActionContinue, action, "error: %v", state.Get("error"))
+		})
+	}
+}
+
+func TestStepSourceAmiInfo_AllowedOwners(t *testing.T) {
+	conn := describeImagesConn(map[string][]*ec2.Image{
+		"public-image": {{
+			ImageId:      aws.String("ami-public"),
+			CreationDate: aws.String("2024-01-01T00:00:00Z"),
+			OwnerId:      aws.String("111111111111"),
+		}},
+		"amazon-image": {{
+			ImageId:         aws.String("ami-amazon"),
+			CreationDate:    aws.String("2024-01-01T00:00:00Z"),
+			OwnerId:         aws.String("137112412989"),
+			ImageOwnerAlias: aws.String("amazon"),
+		}},
+		"own-image": {{
+			ImageId:      aws.String("ami-own"),
+			CreationDate: aws.String("2024-01-01T00:00:00Z"),
+			OwnerId:      aws.String("222222222222"),
+		}},
+	})
+
+	tests := []struct {
+		name          string
+		imageName     string
+		allowedOwners []string
+		expectedError bool
+	}{
+		{name: "disallowed owner", imageName: "public-image", allowedOwners: []string{"123456789012", "amazon"}, expectedError: true},
+		{name: "allowed account", imageName: "public-image", allowedOwners: []string{"111111111111"}},
+		{name: "allowed alias", imageName: "amazon-image", allowedOwners: []string{"amazon"}},
+		{name: "allowed self", imageName: "own-image", allowedOwners: []string{"self"}},
+		{name: "disallowed self", imageName: "public-image", allowedOwners: []string{"self"}, expectedError: true},
+		{name: "no restriction", imageName: "public-image"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			state := new(multistep.BasicStateBag)
+			state.Put("ec2", conn)
+			state.Put("ui", packersdk.TestUi(t))
+
+			step := &StepSourceAMIInfo{
+				AllowedOwners: tt.allowedOwners,
+				AmiFilters: AmiFilterOptions{
+					Owners:  []string{"self"},
+					Filters: map[string]string{"name": tt.imageName},
+				},
+			}
+			action := step.Run(context.Background(), state)
+
+			if tt.expectedError {
+				assert.Equal(t, multistep.ActionHalt, action)
+				assert.Contains(t, state.Get("error").(error).Error(), "ami-public is owned by 111111111111")
+				assert.Nil(t, state.Get("source_image"))
+				return
+			}
+			assert.Equal(t, multistep.ActionContinue, action, "error: %v", state.Get("error"))
+		})
+	}
+}
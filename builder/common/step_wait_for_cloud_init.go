@@ -0,0 +1,71 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package common
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
+)
+
+const cloudInitWaitCommand = "cloud-init status --wait"
+
+// StepWaitForCloudInit waits for cloud-init to be done on the instance, so
+// the image isn't taken while it is still being configured.
+type StepWaitForCloudInit struct {
+	Skip bool
+}
+
+func (s *StepWaitForCloudInit) Run(ctx context.Context, state multistep.StateBag) multistep.StepAction {
+	ui := state.Get("ui").(packersdk.Ui)
+
+	if s.Skip {
+		return multistep.ActionContinue
+	}
+
+	if image, ok := state.Get("source_image").(*ec2.Image); ok &&
+		aws.StringValue(image.Platform) == ec2.PlatformValuesWindows {
+		ui.Say("Skipping cloud-init wait for Windows instance...")
+		return multistep.ActionContinue
+	}
+
+	comm, ok := state.Get("communicator").(packersdk.Communicator)
+	if !ok || comm == nil {
+		err := fmt.Errorf("Can't wait for cloud-init without a communicator")
+		state.Put("error", err)
+		ui.Error(err.Error())
+		return multistep.ActionHalt
+	}
+
+	ui.Say("Waiting for cloud-init to complete...")
+	cmd := &packersdk.RemoteCmd{Command: cloudInitWaitCommand}
+	if err := cmd.RunWithUi(ctx, comm, ui); err != nil {
+		err := fmt.Errorf("Error waiting for cloud-init: %s", err)
+		state.Put("error", err)
+		ui.Error(err.Error())
+		return multistep.ActionHalt
+	}
+
+	switch cmd.ExitStatus() {
+	case 0:
+	case 2:
+		// cloud-init completed, but some modules reported recoverable
+		// errors or deprecations.
+		ui.Message("cloud-init completed with recoverable errors, see the output above")
+	default:
+		err := fmt.Errorf("cloud-init failed with exit status %d. See the output above, "+
+			"or /var/log/cloud-init.log on the instance, for details.", cmd.ExitStatus())
+		state.Put("error", err)
+		ui.Error(err.Error())
+		return multistep.ActionHalt
+	}
+
+	return multistep.ActionContinue
+}
+
+func (s *StepWaitForCloudInit) Cleanup(multistep.StateBag) {}
@@ -0,0 +1,87 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package common
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
+)
+
+func cloudInitState(t *testing.T, comm packersdk.Communicator, platform string) *multistep.BasicStateBag {
+	state := new(multistep.BasicStateBag)
+	state.Put("ui", packersdk.TestUi(t))
+	state.Put("communicator", comm)
+	state.Put("instance", &ec2.Instance{InstanceId: aws.String("i-12345")})
+	image := &ec2.Image{ImageId: aws.String("ami-12345")}
+	if platform != "" {
+		image.Platform = aws.String(platform)
+	}
+	state.Put("source_image", image)
+	return state
+}
+
+func TestStepWaitForCloudInit_Command(t *testing.T) {
+	comm := new(packersdk.MockCommunicator)
+	state := cloudInitState(t, comm, "")
+
+	if action := new(StepWaitForCloudInit).Run(context.Background(), state); action != multistep.ActionContinue {
+		t.Fatalf("should continue, got %v: %v", action, state.Get("error"))
+	}
+	if comm.StartCmd == nil || comm.StartCmd.Command != cloudInitWaitCommand {
+		t.Fatalf("expected %q to be run, got %#v", cloudInitWaitCommand, comm.StartCmd)
+	}
+}
+
+func TestStepWaitForCloudInit_ExitStatus(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   int
+		expected multistep.StepAction
+	}{
+		{name: "done", status: 0, expected: multistep.ActionContinue},
+		{name: "recoverable errors", status: 2, expected: multistep.ActionContinue},
+		{name: "error", status: 1, expected: multistep.ActionHalt},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			comm := &packersdk.MockCommunicator{StartExitStatus: tt.status}
+			state := cloudInitState(t, comm, "")
+
+			if action := new(StepWaitForCloudInit).Run(context.Background(), state); action != tt.expected {
+				t.Fatalf("expected %v, got %v: %v", tt.expected, action, state.Get("error"))
+			}
+		})
+	}
+}
+
+func TestStepWaitForCloudInit_Skip(t *testing.T) {
+	tests := []struct {
+		name     string
+		step     *StepWaitForCloudInit
+		platform string
+	}{
+		{name: "disabled", step: &StepWaitForCloudInit{Skip: true}},
+		{name: "windows", step: &StepWaitForCloudInit{}, platform: ec2.PlatformValuesWindows},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			comm := new(packersdk.MockCommunicator)
+			state := cloudInitState(t, comm, tt.platform)
+
+			if action := tt.step.Run(context.Background(), state); action != multistep.ActionContinue {
+				t.Fatalf("should continue, got %v: %v", action, state.Get("error"))
+			}
+			if comm.StartCalled {
+				t.Fatalf("cloud-init should not be waited for")
+			}
+		})
+	}
+}
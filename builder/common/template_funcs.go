@@ -5,6 +5,7 @@ package common
 
 import (
 	"bytes"
+	"os"
 	"text/template"
 )
 
@@ -38,6 +39,42 @@ func templateCleanAMIName(s string) string {
 	return string(newb[:])
 }
 
+// Environment variables holding the commit, branch and build ID of the CI
+// job running Packer, in order of precedence. They cover Jenkins, GitHub
+// Actions, GitLab CI, CircleCI, Azure Pipelines and AWS CodeBuild.
+var (
+	ciCommitEnv = []string{
+		"GIT_COMMIT", "GITHUB_SHA", "CI_COMMIT_SHA", "CIRCLE_SHA1",
+		"BUILD_SOURCEVERSION", "CODEBUILD_RESOLVED_SOURCE_VERSION",
+	}
+	ciBranchEnv = []string{
+		"GIT_BRANCH", "GITHUB_REF_NAME", "CI_COMMIT_REF_NAME", "CIRCLE_BRANCH",
+		"BUILD_SOURCEBRANCHNAME", "CODEBUILD_WEBHOOK_HEAD_REF",
+	}
+	ciBuildIdEnv = []string{
+		"BUILD_NUMBER", "GITHUB_RUN_ID", "CI_PIPELINE_ID", "CIRCLE_BUILD_NUM",
+		"BUILD_BUILDID", "CODEBUILD_BUILD_NUMBER",
+	}
+)
+
+// firstEnv returns the value of the first of names that is set in the
+// environment, or an empty string.
+func firstEnv(names []string) string {
+	for _, name := range names {
+		if v := os.Getenv(name); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+func templateCICommit() string  { return firstEnv(ciCommitEnv) }
+func templateCIBranch() string  { return firstEnv(ciBranchEnv) }
+func templateCIBuildId() string { return firstEnv(ciBuildIdEnv) }
+
 var TemplateFuncs = template.FuncMap{
 	"clean_resource_name": templateCleanAMIName,
+	"ci_commit":           templateCICommit,
+	"ci_branch":           templateCIBranch,
+	"ci_build_id":         templateCIBuildId,
 }
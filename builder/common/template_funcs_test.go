@@ -5,6 +5,8 @@ package common
 
 import (
 	"testing"
+
+	"github.com/hashicorp/packer-plugin-sdk/template/interpolate"
 )
 
 func TestAMITemplatePrepare_clean(t *testing.T) {
@@ -17,3 +19,32 @@ func TestAMITemplatePrepare_clean(t *testing.T) {
 		t.Fatalf("template names do not match: expected %s got %s\n", expected, name)
 	}
 }
+
+func TestTemplateFuncs_CI(t *testing.T) {
+	for _, names := range [][]string{ciCommitEnv, ciBranchEnv, ciBuildIdEnv} {
+		for _, name := range names {
+			t.Setenv(name, "")
+		}
+	}
+	ctx := &interpolate.Context{Funcs: TemplateFuncs}
+
+	name, err := interpolate.Render("app-{{ ci_branch | clean_resource_name }}-{{ ci_commit }}-{{ ci_build_id }}", ctx)
+	if err != nil {
+		t.Fatalf("shouldn't have err: %s", err)
+	}
+	if name != "app---" {
+		t.Fatalf("expected empty CI metadata outside of CI, got %q", name)
+	}
+
+	t.Setenv("GITHUB_SHA", "abc123")
+	t.Setenv("GIT_COMMIT", "def456")
+	t.Setenv("GITHUB_REF_NAME", "feature/x:y")
+	t.Setenv("GITHUB_RUN_ID", "42")
+	name, err = interpolate.Render("app-{{ ci_branch | clean_resource_name }}-{{ ci_commit }}-{{ ci_build_id }}", ctx)
+	if err != nil {
+		t.Fatalf("shouldn't have err: %s", err)
+	}
+	if expected := "app-feature/x-y-def456-42"; name != expected {
+		t.Fatalf("expected %q, got %q", expected, name)
+	}
+}
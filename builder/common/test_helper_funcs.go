@@ -5,7 +5,11 @@ package common
 
 import (
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/client"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
 )
 
@@ -26,3 +30,26 @@ func FakeAccessConfig() *AccessConfig {
 
 	return &accessConfig
 }
+
+// FakeSession returns a session with static credentials, for the clients
+// of FakeClient.
+func FakeSession() *session.Session {
+	return session.Must(session.NewSession(&aws.Config{
+		Region:      aws.String("us-east-1"),
+		Credentials: credentials.NewStaticCredentials("foo", "bar", ""),
+	}))
+}
+
+// FakeClient makes c never reach AWS: every request is answered by handle,
+// which fills in r.Data or sets r.Error.
+func FakeClient(c *client.Client, handle func(r *request.Request)) {
+	c.Handlers.Clear()
+	c.Handlers.Send.PushBack(handle)
+}
+
+// FakeEC2Conn returns an EC2 client whose requests are answered by handle.
+func FakeEC2Conn(handle func(r *request.Request)) *ec2.EC2 {
+	conn := ec2.New(FakeSession())
+	FakeClient(conn.Client, handle)
+	return conn
+}
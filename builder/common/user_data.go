@@ -0,0 +1,113 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package common
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/aws/aws-sdk-go/service/ssm/ssmiface"
+)
+
+// userDataLoader reads the user data of the source instance from whichever
+// of its sources is set.
+type userDataLoader struct {
+	UserData             string
+	UserDataFile         string
+	UserDataS3Url        string
+	UserDataSSMParameter string
+
+	s3conn  s3iface.S3API
+	ssmconn ssmiface.SSMAPI
+}
+
+func newUserDataLoader(sess *session.Session, userData, userDataFile, s3Url, ssmParameter string) *userDataLoader {
+	l := &userDataLoader{
+		UserData:             userData,
+		UserDataFile:         userDataFile,
+		UserDataS3Url:        s3Url,
+		UserDataSSMParameter: ssmParameter,
+	}
+	if s3Url != "" {
+		l.s3conn = s3.New(sess)
+	}
+	if ssmParameter != "" {
+		l.ssmconn = ssm.New(sess)
+	}
+	return l
+}
+
+// Load returns the user data, base64 encoded if it isn't already.
+func (l *userDataLoader) Load() (string, error) {
+	userData := l.UserData
+
+	switch {
+	case l.UserDataFile != "":
+		contents, err := os.ReadFile(l.UserDataFile)
+		if err != nil {
+			return "", fmt.Errorf("Problem reading user data file: %s", err)
+		}
+		userData = string(contents)
+	case l.UserDataS3Url != "":
+		bucket, key, err := parseS3Url(l.UserDataS3Url)
+		if err != nil {
+			return "", err
+		}
+		log.Printf("[DEBUG] Reading user data from %s", l.UserDataS3Url)
+		resp, err := l.s3conn.GetObject(&s3.GetObjectInput{
+			Bucket: aws.String(bucket),
+			Key:    aws.String(key),
+		})
+		if err != nil {
+			return "", fmt.Errorf("Problem reading user data from %s: %s", l.UserDataS3Url, err)
+		}
+		defer resp.Body.Close()
+		contents, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return "", fmt.Errorf("Problem reading user data from %s: %s", l.UserDataS3Url, err)
+		}
+		userData = string(contents)
+	case l.UserDataSSMParameter != "":
+		log.Printf("[DEBUG] Reading user data from SSM parameter %s", l.UserDataSSMParameter)
+		resp, err := l.ssmconn.GetParameter(&ssm.GetParameterInput{
+			Name:           aws.String(l.UserDataSSMParameter),
+			WithDecryption: aws.Bool(true),
+		})
+		if err != nil {
+			return "", fmt.Errorf("Problem reading user data from SSM parameter %s: %s", l.UserDataSSMParameter, err)
+		}
+		userData = aws.StringValue(resp.Parameter.Value)
+	}
+
+	// Test if it is encoded already, and if not, encode it
+	if _, err := base64.StdEncoding.DecodeString(userData); err != nil {
+		log.Printf("[DEBUG] base64 encoding user data...")
+		userData = base64.StdEncoding.EncodeToString([]byte(userData))
+	}
+	return userData, nil
+}
+
+// parseS3Url splits an s3://bucket/key URL into its bucket and key.
+func parseS3Url(s3Url string) (string, string, error) {
+	u, err := url.Parse(s3Url)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid S3 URL %q: %s", s3Url, err)
+	}
+
+	key := strings.TrimPrefix(u.Path, "/")
+	if u.Scheme != "s3" || u.Host == "" || key == "" {
+		return "", "", fmt.Errorf("invalid S3 URL %q, expected s3://bucket/key", s3Url)
+	}
+	return u.Host, key, nil
+}
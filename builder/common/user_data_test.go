@@ -0,0 +1,121 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package common
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/aws/aws-sdk-go/service/ssm/ssmiface"
+)
+
+type userDataS3Conn struct {
+	s3iface.S3API
+
+	objects map[string]string
+}
+
+func (m *userDataS3Conn) GetObject(input *s3.GetObjectInput) (*s3.GetObjectOutput, error) {
+	contents, ok := m.objects[aws.StringValue(input.Bucket)+"/"+aws.StringValue(input.Key)]
+	if !ok {
+		return nil, fmt.Errorf("NoSuchKey")
+	}
+	return &s3.GetObjectOutput{Body: io.NopCloser(strings.NewReader(contents))}, nil
+}
+
+type userDataSSMConn struct {
+	ssmiface.SSMAPI
+
+	parameters map[string]string
+}
+
+func (m *userDataSSMConn) GetParameter(input *ssm.GetParameterInput) (*ssm.GetParameterOutput, error) {
+	if !aws.BoolValue(input.WithDecryption) {
+		return nil, fmt.Errorf("parameters should be decrypted")
+	}
+	value, ok := m.parameters[aws.StringValue(input.Name)]
+	if !ok {
+		return nil, fmt.Errorf("ParameterNotFound")
+	}
+	return &ssm.GetParameterOutput{Parameter: &ssm.Parameter{Value: aws.String(value)}}, nil
+}
+
+func TestUserDataLoader_Load(t *testing.T) {
+	script := "#!/bin/sh\necho hello"
+	encoded := base64.StdEncoding.EncodeToString([]byte(script))
+
+	s3conn := &userDataS3Conn{objects: map[string]string{
+		"bootstrap/scripts/user-data.sh": script,
+	}}
+	ssmconn := &userDataSSMConn{parameters: map[string]string{
+		"/bootstrap/user-data": script,
+		"/bootstrap/encoded":   encoded,
+	}}
+
+	tests := []struct {
+		name          string
+		loader        userDataLoader
+		expected      string
+		expectedError bool
+	}{
+		{
+			name:     "inline",
+			loader:   userDataLoader{UserData: script},
+			expected: encoded,
+		},
+		{
+			name:     "s3 object",
+			loader:   userDataLoader{UserDataS3Url: "s3://bootstrap/scripts/user-data.sh"},
+			expected: encoded,
+		},
+		{
+			name:          "missing s3 object",
+			loader:        userDataLoader{UserDataS3Url: "s3://bootstrap/scripts/missing.sh"},
+			expectedError: true,
+		},
+		{
+			name:     "ssm parameter",
+			loader:   userDataLoader{UserDataSSMParameter: "/bootstrap/user-data"},
+			expected: encoded,
+		},
+		{
+			name:     "already encoded ssm parameter",
+			loader:   userDataLoader{UserDataSSMParameter: "/bootstrap/encoded"},
+			expected: encoded,
+		},
+		{
+			name:          "missing ssm parameter",
+			loader:        userDataLoader{UserDataSSMParameter: "/bootstrap/missing"},
+			expectedError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.loader.s3conn = s3conn
+			tt.loader.ssmconn = ssmconn
+
+			userData, err := tt.loader.Load()
+			if tt.expectedError {
+				if err == nil {
+					t.Fatalf("expected an error, got user data %q", userData)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("should not have error: %s", err)
+			}
+			if userData != tt.expected {
+				t.Fatalf("expected user data %q, got %q", tt.expected, userData)
+			}
+		})
+	}
+}
@@ -15,6 +15,7 @@ import (
 	"context"
 	"fmt"
 
+	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/aws/aws-sdk-go/service/iam"
 	"github.com/hashicorp/hcl/v2/hcldec"
@@ -88,10 +89,33 @@ type Config struct {
 	// See the [Fast Launch Configuration](#fast-launch-config) section for
 	// information on the attributes supported for this block.
 	FastLaunch FastLaunchConfig `mapstructure:"fast_launch" required:"false"`
+	// If true, Packer creates the AMI from the running instance, without
+	// stopping it first, and asks AWS not to reboot it while the image is
+	// created. This saves the time needed to stop the instance but, as the
+	// file systems are not quiesced, the consistency of the data written
+	// while the snapshots are taken is not guaranteed. Cannot be used with
+	// `disable_stop_instance`, `ena_support` or `sriov_support`. Default
+	// `false`.
+	FastImage bool `mapstructure:"fast_image" required:"false"`
+	// NitroTPM Support. Valid options are `v2.0`. See the documentation on
+	// [NitroTPM Support](https://docs.aws.amazon.com/AWSEC2/latest/UserGuide/enable-nitrotpm-support-on-ami.html) for
+	// more information. The CreateImage API inherits NitroTPM support from
+	// the source AMI, so if it doesn't have it, the AMI is registered again
+	// from its snapshots with NitroTPM support, which needs the
+	// `ec2:RegisterImage` permission. A source AMI billed
+	// with a license, such as a Windows one, needs NitroTPM support already,
+	// registering it again would lose the license.
+	TpmSupport string `mapstructure:"tpm_support" required:"false"`
 
 	ctx interpolate.Context
 }
 
+// skipStopInstance reports whether the instance is left running while the
+// AMI is created from it.
+func (c *Config) skipStopInstance() bool {
+	return c.IsSpotInstance() || c.FastImage
+}
+
 type Builder struct {
 	config Config
 	runner multistep.Runner
@@ -143,6 +167,9 @@ func (b *Builder) Prepare(raws ...interface{}) ([]string, []string, error) {
 	errs = packersdk.MultiErrorAppend(errs, b.config.AMIMappings.Prepare(&b.config.ctx)...)
 	errs = packersdk.MultiErrorAppend(errs, b.config.LaunchMappings.Prepare(&b.config.ctx)...)
 	errs = packersdk.MultiErrorAppend(errs, b.config.RunConfig.Prepare(&b.config.ctx)...)
+	if err := b.config.CheckMaxSize(b.config.LaunchMappings, b.config.AMIMappings); err != nil {
+		errs = packersdk.MultiErrorAppend(errs, err)
+	}
 
 	b.config.FastLaunch.defaultRegion = b.config.RawRegion
 	errs = packersdk.MultiErrorAppend(errs, b.config.FastLaunch.Prepare()...)
@@ -170,6 +197,26 @@ func (b *Builder) Prepare(raws ...interface{}) ([]string, []string, error) {
 				"you use an AMI that already has either SR-IOV or ENA enabled."))
 	}
 
+	if b.config.TpmSupport != "" && b.config.TpmSupport != ec2.TpmSupportValuesV20 {
+		errs = packersdk.MultiErrorAppend(errs, fmt.Errorf(`The only valid tpm_support value is %q`, ec2.TpmSupportValuesV20))
+	}
+
+	if b.config.FastImage {
+		if b.config.DisableStopInstance {
+			errs = packersdk.MultiErrorAppend(errs,
+				fmt.Errorf("`fast_image` and `disable_stop_instance` cannot both be set."))
+		}
+		if b.config.AMIENASupport.True() || b.config.AMISriovNetSupport {
+			errs = packersdk.MultiErrorAppend(errs,
+				fmt.Errorf("`fast_image` does not stop the instance, which is required "+
+					"when either `ena_support` or `sriov_support` are set. Please ensure "+
+					"you use an AMI that already has either SR-IOV or ENA enabled."))
+		}
+		warns = append(warns, "fast_image is set: the AMI is created from the "+
+			"running instance without rebooting it, so the consistency of the "+
+			"file systems in the resulting image is not guaranteed.")
+	}
+
 	if b.config.RunConfig.SpotPriceAutoProduct != "" {
 		warns = append(warns, "spot_price_auto_product is deprecated and no "+
 			"longer necessary for Packer builds. In future versions of "+
@@ -177,6 +224,9 @@ func (b *Builder) Prepare(raws ...interface{}) ([]string, []string, error) {
 			"builds. Please take a look at our current documentation to "+
 			"understand how Packer requests Spot instances.")
 	}
+	if warn := b.config.RunConfig.EbsOptimizedWarning(); warn != "" {
+		warns = append(warns, warn)
+	}
 
 	if b.config.RunConfig.EnableT2Unlimited {
 		warns = append(warns, "enable_t2_unlimited is deprecated please use "+
@@ -191,6 +241,7 @@ func (b *Builder) Prepare(raws ...interface{}) ([]string, []string, error) {
 	packersdk.LogSecretFilter.Set(b.config.AccessKey, b.config.SecretKey, b.config.Token)
 
 	generatedData := awscommon.GetGeneratedDataList()
+	generatedData = append(generatedData, "CentralAMI", "UnshareAfter", "FailedCopyRegions")
 	return generatedData, warns, nil
 }
 
@@ -216,12 +267,45 @@ func (b *Builder) Run(ctx context.Context, ui packersdk.Ui, hook packersdk.Hook)
 	state.Put("region", ec2conn.Config.Region)
 	generatedData := &packerbuilderdata.GeneratedData{State: state}
 
+	steps := b.buildSteps(session, ec2conn, generatedData)
+
+	// Run!
+	b.runner = commonsteps.NewRunner(steps, b.config.PackerConfig, ui)
+	b.runner.Run(ctx, state)
+	// If there was an error, return that
+	if rawErr, ok := state.GetOk("error"); ok {
+		return nil, rawErr.(error)
+	}
+
+	// If there are no AMIs, then just return
+	if _, ok := state.GetOk("amis"); !ok {
+		return nil, nil
+	}
+
+	// Build the artifact and return it
+	artifact := &awscommon.Artifact{
+		Amis:           state.Get("amis").(map[string]string),
+		BuilderIdValue: BuilderId,
+		Session:        session,
+		StateData:      map[string]interface{}{"generated_data": state.Get("generated_data")},
+	}
+	if centralAmi, ok := state.GetOk("central_ami"); ok {
+		artifact.CentralAmi = centralAmi.(string)
+	}
+
+	return artifact, nil
+}
+
+// buildSteps returns the steps of the build, in the order they run.
+func (b *Builder) buildSteps(session *session.Session, ec2conn *ec2.EC2, generatedData *packerbuilderdata.GeneratedData) []multistep.Step {
 	var instanceStep multistep.Step
 
 	if b.config.IsSpotInstance() {
 		instanceStep = &awscommon.StepRunSpotInstance{
 			PollingConfig:                     b.config.PollingConfig,
 			AssociatePublicIpAddress:          b.config.AssociatePublicIpAddress,
+			EnablePrimaryIpv6:                 b.config.EnablePrimaryIpv6,
+			PrivateDnsNameOptions:             b.config.PrivateDnsNameOptions,
 			LaunchMappings:                    b.config.LaunchMappings,
 			BlockDurationMinutes:              b.config.BlockDurationMinutes,
 			Ctx:                               b.config.ctx,
@@ -241,13 +325,17 @@ func (b *Builder) Run(ctx context.Context, ui packersdk.Ui, hook packersdk.Hook)
 			Region:                            *ec2conn.Config.Region,
 			SourceAMI:                         b.config.SourceAmi,
 			SpotPrice:                         b.config.SpotPrice,
+			SpotRequestTimeout:                b.config.SpotRequestTimeout,
+			LogSpotPrices:                     b.config.LogSpotPrices,
 			SpotTags:                          b.config.SpotTags,
-			Tags:                              b.config.RunTags,
+			Tags:                              b.config.EphemeralTags(b.config.RunTags),
 			SpotInstanceTypes:                 b.config.SpotInstanceTypes,
 			SpotAllocationStrategy:            b.config.SpotAllocationStrategy,
 			UserData:                          b.config.UserData,
 			UserDataFile:                      b.config.UserDataFile,
-			VolumeTags:                        b.config.VolumeRunTags,
+			UserDataS3Url:                     b.config.UserDataS3Url,
+			UserDataSSMParameter:              b.config.UserDataSSMParameter,
+			VolumeTags:                        b.config.EphemeralTags(b.config.VolumeRunTags),
 			NoEphemeral:                       b.config.NoEphemeral,
 		}
 	} else {
@@ -264,6 +352,10 @@ func (b *Builder) Run(ctx context.Context, ui packersdk.Ui, hook packersdk.Hook)
 		instanceStep = &awscommon.StepRunSourceInstance{
 			PollingConfig:                     b.config.PollingConfig,
 			AssociatePublicIpAddress:          b.config.AssociatePublicIpAddress,
+			EnablePrimaryIpv6:                 b.config.EnablePrimaryIpv6,
+			PrivateDnsNameOptions:             b.config.PrivateDnsNameOptions,
+			EIPAllocationId:                   b.config.AssociateEIPAllocationId,
+			InsufficientCapacityRetries:       b.config.InsufficientCapacityRetries,
 			LaunchMappings:                    b.config.LaunchMappings,
 			CapacityReservationPreference:     b.config.CapacityReservationPreference,
 			CapacityReservationId:             b.config.CapacityReservationId,
@@ -284,20 +376,34 @@ func (b *Builder) Run(ctx context.Context, ui packersdk.Ui, hook packersdk.Hook)
 			InstanceType:                      b.config.InstanceType,
 			IsRestricted:                      b.config.IsChinaCloud(),
 			SourceAMI:                         b.config.SourceAmi,
-			Tags:                              b.config.RunTags,
+			Tags:                              b.config.EphemeralTags(b.config.RunTags),
 			LicenseSpecifications:             b.config.LicenseSpecifications,
 			HostResourceGroupArn:              b.config.Placement.HostResourceGroupArn,
 			HostId:                            b.config.Placement.HostId,
 			Tenancy:                           tenancy,
 			UserData:                          b.config.UserData,
 			UserDataFile:                      b.config.UserDataFile,
-			VolumeTags:                        b.config.VolumeRunTags,
+			UserDataS3Url:                     b.config.UserDataS3Url,
+			UserDataSSMParameter:              b.config.UserDataSSMParameter,
+			VolumeTags:                        b.config.EphemeralTags(b.config.VolumeRunTags),
 			NoEphemeral:                       b.config.NoEphemeral,
 		}
 	}
 
+	var iamActions []string
+	if b.config.CheckIAMPermissions {
+		iamActions = append(b.config.RunConfig.IAMActions(), b.config.AMIConfig.IAMActions()...)
+		if !b.config.AMISkipCreateImage {
+			iamActions = append(iamActions, "ec2:CreateImage")
+		}
+	}
+
 	// Build the steps
 	steps := []multistep.Step{
+		&awscommon.StepCheckIAMPermissions{
+			Actions: iamActions,
+			Region:  *ec2conn.Config.Region,
+		},
 		&awscommon.StepPreValidate{
 			DestAmiName:        b.config.AMIName,
 			ForceDeregister:    b.config.AMIForceDeregister,
@@ -312,7 +418,9 @@ func (b *Builder) Run(ctx context.Context, ui packersdk.Ui, hook packersdk.Hook)
 			EnableAMISriovNetSupport: b.config.AMISriovNetSupport,
 			EnableAMIENASupport:      b.config.AMIENASupport,
 			AmiFilters:               b.config.SourceAmiFilter,
+			AmiFilterGroups:          b.config.SourceAmiFilters,
 			AMIVirtType:              b.config.AMIVirtType,
+			AllowedOwners:            b.config.AllowedSourceAmiOwners,
 		},
 		&awscommon.StepNetworkInfo{
 			VpcId:                    b.config.VpcId,
@@ -330,7 +438,7 @@ func (b *Builder) Run(ctx context.Context, ui packersdk.Ui, hook packersdk.Hook)
 			Comm:         &b.config.RunConfig.Comm,
 			IsRestricted: b.config.IsChinaCloud(),
 			DebugKeyPath: fmt.Sprintf("ec2_%s.pem", b.config.PackerBuildName),
-			Tags:         b.config.RunTags,
+			Tags:         b.config.EphemeralTags(b.config.RunTags),
 			Ctx:          b.config.ctx,
 		},
 		&awscommon.StepSecurityGroup{
@@ -341,15 +449,15 @@ func (b *Builder) Run(ctx context.Context, ui packersdk.Ui, hook packersdk.Hook)
 			TemporarySGSourcePublicIp: b.config.TemporarySGSourcePublicIp,
 			SkipSSHRuleCreation:       b.config.SSMAgentEnabled(),
 			IsRestricted:              b.config.IsChinaCloud(),
-			Tags:                      b.config.RunTags,
+			Tags:                      b.config.EphemeralTags(b.config.RunTags),
 			Ctx:                       b.config.ctx,
 		},
 		&awscommon.StepIamInstanceProfile{
-			PollingConfig:                             b.config.PollingConfig,
-			IamInstanceProfile:                        b.config.IamInstanceProfile,
-			SkipProfileValidation:                     b.config.SkipProfileValidation,
+			PollingConfig:         b.config.PollingConfig,
+			IamInstanceProfile:    b.config.IamInstanceProfile,
+			SkipProfileValidation: b.config.SkipProfileValidation,
 			TemporaryIamInstanceProfilePolicyDocument: b.config.TemporaryIamInstanceProfilePolicyDocument,
-			Tags: b.config.RunTags,
+			Tags: b.config.EphemeralTags(b.config.RunTags),
 			Ctx:  b.config.ctx,
 		},
 		&awscommon.StepCleanupVolumes{
@@ -366,7 +474,9 @@ func (b *Builder) Run(ctx context.Context, ui packersdk.Ui, hook packersdk.Hook)
 			AWSSession:       session,
 			Region:           *ec2conn.Config.Region,
 			PauseBeforeSSM:   b.config.PauseBeforeSSM,
+			PollingConfig:    b.config.PollingConfig,
 			LocalPortNumber:  b.config.SessionManagerPort,
+			LocalPortRange:   b.config.SessionManagerPortRange,
 			RemotePortNumber: b.config.Comm.Port(),
 			SSMAgentEnabled:  b.config.SSMAgentEnabled(),
 			SSHConfig:        &b.config.Comm.SSH,
@@ -388,12 +498,15 @@ func (b *Builder) Run(ctx context.Context, ui packersdk.Ui, hook packersdk.Hook)
 			GeneratedData: generatedData,
 		},
 		&commonsteps.StepProvision{},
+		&awscommon.StepWaitForCloudInit{
+			Skip: !b.config.WaitForCloudInit,
+		},
 		&commonsteps.StepCleanupTempKeys{
 			Comm: &b.config.RunConfig.Comm,
 		},
 		&awscommon.StepStopEBSBackedInstance{
 			PollingConfig:       b.config.PollingConfig,
-			Skip:                b.config.IsSpotInstance(),
+			Skip:                b.config.skipStopInstance(),
 			DisableStopInstance: b.config.DisableStopInstance,
 		},
 		&awscommon.StepModifyEBSBackedInstance{
@@ -411,6 +524,8 @@ func (b *Builder) Run(ctx context.Context, ui packersdk.Ui, hook packersdk.Hook)
 			AMISkipCreateImage: b.config.AMISkipCreateImage,
 			AMISkipBuildRegion: b.config.AMISkipBuildRegion,
 			AMISkipRunTags:     b.config.AMISkipRunTags,
+			NoReboot:           b.config.FastImage,
+			TpmSupport:         b.config.TpmSupport,
 			PollingConfig:      b.config.PollingConfig,
 			IsRestricted:       b.config.IsChinaCloud() || b.config.IsGovCloud(),
 			Tags:               b.config.RunTags,
@@ -427,6 +542,9 @@ func (b *Builder) Run(ctx context.Context, ui packersdk.Ui, hook packersdk.Hook)
 			AMISkipCreateImage:             b.config.AMISkipCreateImage,
 			AMISkipBuildRegion:             b.config.AMISkipBuildRegion,
 			AMISnapshotCopyDurationMinutes: b.config.AMISnapshotCopyDurationMinutes,
+			IgnoreCopyErrors:               b.config.AMIIgnoreCopyErrors,
+			RegionVolumeTypes:              b.config.AMIRegionVolumeTypes,
+			GeneratedData:                  generatedData,
 		},
 		&stepPrepareFastLaunchTemplate{
 			AccessConfig:       &b.config.AccessConfig,
@@ -443,57 +561,52 @@ func (b *Builder) Run(ctx context.Context, ui packersdk.Ui, hook packersdk.Hook)
 			MaxInstances:       b.config.FastLaunch.MaxParallelLaunches,
 		},
 		&awscommon.StepEnableDeprecation{
-			AccessConfig:       &b.config.AccessConfig,
-			DeprecationTime:    b.config.DeprecationTime,
-			AMISkipCreateImage: b.config.AMISkipCreateImage,
+			AccessConfig:          &b.config.AccessConfig,
+			DeprecationTime:       b.config.DeprecationTime,
+			DeprecatePreviousTags: b.config.DeprecatePreviousTags,
+			AMISkipCreateImage:    b.config.AMISkipCreateImage,
 		},
 		&awscommon.StepEnableDeregistrationProtection{
 			AccessConfig:             &b.config.AccessConfig,
 			AMISkipCreateImage:       b.config.AMISkipCreateImage,
 			DeregistrationProtection: &b.config.DeregistrationProtection,
 		},
+		&awscommon.StepCentralAccountCopy{
+			AccessConfig:       &b.config.AccessConfig,
+			Role:               b.config.AMICentralAccountRole,
+			KmsKeyId:           b.config.AMICentralAccountKmsKeyId,
+			Name:               b.config.AMIName,
+			Region:             *ec2conn.Config.Region,
+			AMISkipCreateImage: b.config.AMISkipCreateImage,
+			PollingConfig:      b.config.PollingConfig,
+			GeneratedData:      generatedData,
+		},
 		&awscommon.StepModifyAMIAttributes{
 			AMISkipCreateImage: b.config.AMISkipCreateImage,
 			Description:        b.config.AMIDescription,
 			Users:              b.config.AMIUsers,
+			UsersSSMParameter:  b.config.AMIUsersSSMParameter,
 			Groups:             b.config.AMIGroups,
 			OrgArns:            b.config.AMIOrgArns,
 			OuArns:             b.config.AMIOuArns,
 			ProductCodes:       b.config.AMIProductCodes,
+			ShareDuration:      b.config.AMIShareDuration,
 			SnapshotUsers:      b.config.SnapshotUsers,
 			SnapshotGroups:     b.config.SnapshotGroups,
+			WaitForSnapshots:   b.config.AMIWaitForSnapshots,
+			PollingConfig:      b.config.PollingConfig,
 			IMDSSupport:        b.config.AMIIMDSSupport,
 			Ctx:                b.config.ctx,
 			GeneratedData:      generatedData,
 		},
 		&awscommon.StepCreateTags{
 			AMISkipCreateImage: b.config.AMISkipCreateImage,
+			TagCopyRegions:     b.config.AMITagCopyRegions,
 			Tags:               b.config.AMITags,
 			SnapshotTags:       b.config.SnapshotTags,
 			Ctx:                b.config.ctx,
 		},
 	}
 
-	// Run!
-	b.runner = commonsteps.NewRunner(steps, b.config.PackerConfig, ui)
-	b.runner.Run(ctx, state)
-	// If there was an error, return that
-	if rawErr, ok := state.GetOk("error"); ok {
-		return nil, rawErr.(error)
-	}
-
-	// If there are no AMIs, then just return
-	if _, ok := state.GetOk("amis"); !ok {
-		return nil, nil
-	}
-
-	// Build the artifact and return it
-	artifact := &awscommon.Artifact{
-		Amis:           state.Get("amis").(map[string]string),
-		BuilderIdValue: BuilderId,
-		Session:        session,
-		StateData:      map[string]interface{}{"generated_data": state.Get("generated_data")},
-	}
-
-	return artifact, nil
+	return steps
 }
@@ -8,7 +8,10 @@ import (
 	"testing"
 	"time"
 
+	"github.com/aws/aws-sdk-go/service/ec2"
+	awscommon "github.com/hashicorp/packer-plugin-amazon/builder/common"
 	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
+	"github.com/hashicorp/packer-plugin-sdk/packerbuilderdata"
 )
 
 func testConfig() map[string]interface{} {
@@ -143,7 +146,8 @@ func TestBuilderPrepare_DeprecationTime(t *testing.T) {
 		deprecationTime string
 		isErr           bool
 	}{
-		{"good", currentTime.Format(time.RFC3339), false},
+		{"good", currentTime.Add(time.Hour).Format(time.RFC3339), false},
+		{"in the past", currentTime.Add(-time.Hour).Format(time.RFC3339), true},
 		{"not in format (YYYY-MM-DDTHH:MM:SSZ)", currentTime.Format(time.ANSIC), true},
 	}
 
@@ -452,3 +456,122 @@ func TestBuilderPrepare_DeregistrationProtection(t *testing.T) {
 		})
 	}
 }
+
+func TestBuilderPrepare_MaxAMISize(t *testing.T) {
+	for _, tt := range []struct {
+		maxSize int
+		wantErr bool
+	}{
+		{maxSize: 30, wantErr: false},
+		{maxSize: 29, wantErr: true},
+	} {
+		var b Builder
+		config := testConfig()
+		config["max_ami_size_gb"] = tt.maxSize
+		config["launch_block_device_mappings"] = []map[string]interface{}{
+			{"device_name": "/dev/sda1", "volume_size": 20},
+		}
+		config["ami_block_device_mappings"] = []map[string]interface{}{
+			{"device_name": "/dev/sdb", "volume_size": 10},
+		}
+
+		_, warnings, err := b.Prepare(config)
+		if len(warnings) > 0 {
+			t.Fatalf("bad: %#v", warnings)
+		}
+		if tt.wantErr && err == nil {
+			t.Fatalf("max_ami_size_gb %d: should have error", tt.maxSize)
+		}
+		if !tt.wantErr && err != nil {
+			t.Fatalf("max_ami_size_gb %d: shouldn't have err: %s", tt.maxSize, err)
+		}
+	}
+}
+
+func TestBuilderPrepare_AMINameCIMetadata(t *testing.T) {
+	t.Setenv("GIT_COMMIT", "")
+	t.Setenv("GITHUB_SHA", "0123abcd")
+
+	var b Builder
+	config := testConfig()
+	config["ami_name"] = "foo-{{ ci_commit }}"
+
+	_, warnings, err := b.Prepare(config)
+	if len(warnings) > 0 {
+		t.Fatalf("bad: %#v", warnings)
+	}
+	if err != nil {
+		t.Fatalf("should not have error: %s", err)
+	}
+	if b.config.AMIName != "foo-0123abcd" {
+		t.Fatalf("expected the commit in the AMI name, got %q", b.config.AMIName)
+	}
+}
+
+func TestBuilderPrepare_TpmSupportValue(t *testing.T) {
+	tests := []struct {
+		name        string
+		optValue    string
+		expectError bool
+	}{
+		{
+			name:        "OK - no value set",
+			optValue:    "",
+			expectError: false,
+		},
+		{
+			name:        "OK - v2.0",
+			optValue:    "v2.0",
+			expectError: false,
+		},
+		{
+			name:        "Error - bad value set",
+			optValue:    "v3.0",
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := testConfig()
+			config["tpm_support"] = tt.optValue
+
+			b := &Builder{}
+
+			_, _, err := b.Prepare(config)
+			if err != nil && !tt.expectError {
+				t.Fatalf("got unexpected error: %s", err)
+			}
+			if err == nil && tt.expectError {
+				t.Fatalf("expected an error, got a success instead")
+			}
+		})
+	}
+}
+
+func TestBuilder_WaitForCloudInitBeforeStop(t *testing.T) {
+	var b Builder
+	config := testConfig()
+	config["wait_for_cloud_init"] = true
+	if _, _, err := b.Prepare(config); err != nil {
+		t.Fatalf("should not have error: %s", err)
+	}
+
+	// The steps only read the region of the session.
+	b.config.AccessConfig = *awscommon.FakeAccessConfig()
+
+	sess := awscommon.FakeSession()
+	steps := b.buildSteps(sess, ec2.New(sess), &packerbuilderdata.GeneratedData{})
+	wait, stop := -1, -1
+	for i, step := range steps {
+		switch step.(type) {
+		case *awscommon.StepWaitForCloudInit:
+			wait = i
+		case *awscommon.StepStopEBSBackedInstance:
+			stop = i
+		}
+	}
+	if wait < 0 || stop < 0 || wait > stop {
+		t.Fatalf("cloud-init should be waited for before the instance is stopped, got steps %d and %d", wait, stop)
+	}
+}
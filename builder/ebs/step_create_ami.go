@@ -7,10 +7,12 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"strings"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
 	awscommon "github.com/hashicorp/packer-plugin-amazon/builder/common"
 	"github.com/hashicorp/packer-plugin-amazon/builder/common/awserrors"
 	"github.com/hashicorp/packer-plugin-sdk/multistep"
@@ -26,6 +28,8 @@ type stepCreateAMI struct {
 	AMISkipCreateImage bool
 	AMISkipBuildRegion bool
 	AMISkipRunTags     bool
+	NoReboot           bool
+	TpmSupport         string
 	IsRestricted       bool
 	Ctx                interpolate.Context
 	Tags               map[string]string
@@ -65,6 +69,10 @@ func (s *stepCreateAMI) Run(ctx context.Context, state multistep.StateBag) multi
 		Name:                &amiName,
 		BlockDeviceMappings: config.AMIMappings.BuildEC2BlockDeviceMappings(),
 	}
+	if s.NoReboot {
+		ui.Message("Creating the AMI from the running instance without rebooting it")
+		createOpts.NoReboot = aws.Bool(true)
+	}
 
 	if !s.IsRestricted {
 		ec2Tags, err := awscommon.TagMap(s.Tags).EC2Tags(s.Ctx, *ec2conn.Config.Region, state)
@@ -151,8 +159,31 @@ func (s *stepCreateAMI) Run(ctx context.Context, state multistep.StateBag) multi
 	}
 	s.image = imagesResp.Images[0]
 
+	// NitroTPM support and billing products can only be set when the AMI is
+	// registered.
+	var registerWith []string
+	if s.TpmSupport != "" && aws.StringValue(s.image.TpmSupport) != s.TpmSupport {
+		registerWith = append(registerWith, "NitroTPM support "+s.TpmSupport)
+	}
+	if len(config.AMIBillingProducts) > 0 {
+		registerWith = append(registerWith, "billing products "+strings.Join(config.AMIBillingProducts, ", "))
+	}
+	if len(registerWith) > 0 {
+		ui.Say(fmt.Sprintf("Registering AMI %s again with %s...", *s.image.ImageId, strings.Join(registerWith, " and ")))
+		image, err := s.registerAgain(ctx, ec2conn, s.image, config.AMIBillingProducts)
+		if err != nil {
+			err := fmt.Errorf("Error registering the AMI with %s: %s", strings.Join(registerWith, " and "), err)
+			state.Put("error", err)
+			ui.Error(err.Error())
+			return multistep.ActionHalt
+		}
+		ui.Message(fmt.Sprintf("AMI: %s", *image.ImageId))
+		s.image = image
+		amis[*ec2conn.Config.Region] = *image.ImageId
+	}
+
 	snapshots := make(map[string][]string)
-	for _, blockDeviceMapping := range imagesResp.Images[0].BlockDeviceMappings {
+	for _, blockDeviceMapping := range s.image.BlockDeviceMappings {
 		if blockDeviceMapping.Ebs != nil && blockDeviceMapping.Ebs.SnapshotId != nil {
 
 			snapshots[*ec2conn.Config.Region] = append(snapshots[*ec2conn.Config.Region], *blockDeviceMapping.Ebs.SnapshotId)
@@ -163,6 +194,35 @@ func (s *stepCreateAMI) Run(ctx context.Context, state multistep.StateBag) multi
 	return multistep.ActionContinue
 }
 
+// registerAgain registers image again from its snapshots with NitroTPM
+// support and billingProducts, which CreateImage inherits from the source AMI
+// and can't be modified afterwards, and returns the new image.
+func (s *stepCreateAMI) registerAgain(ctx context.Context, ec2conn ec2iface.EC2API, image *ec2.Image, billingProducts []string) (*ec2.Image, error) {
+	if err := awscommon.CheckRegisterAgain(image); err != nil {
+		return nil, err
+	}
+	input := awscommon.RegisterImageInputFromImage(image)
+	if s.TpmSupport != "" {
+		input.TpmSupport = aws.String(s.TpmSupport)
+	}
+	if len(billingProducts) > 0 {
+		input.BillingProducts = aws.StringSlice(billingProducts)
+	}
+
+	imageId, err := awscommon.ReregisterImage(ctx, ec2conn, s.PollingConfig, *image.ImageId, input)
+	if err != nil {
+		return nil, err
+	}
+	imagesResp, err := ec2conn.DescribeImages(&ec2.DescribeImagesInput{ImageIds: []*string{aws.String(imageId)}})
+	if err != nil {
+		return nil, fmt.Errorf("Error searching for AMI (%s): %s", imageId, err)
+	}
+	if len(imagesResp.Images) == 0 {
+		return nil, fmt.Errorf("AMI %s not found", imageId)
+	}
+	return imagesResp.Images[0], nil
+}
+
 func (s *stepCreateAMI) Cleanup(state multistep.StateBag) {
 	if s.image == nil {
 		return
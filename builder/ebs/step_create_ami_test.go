@@ -0,0 +1,317 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package ebs
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	awscommon "github.com/hashicorp/packer-plugin-amazon/builder/common"
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
+)
+
+// fakeEC2Conn returns an awscommon.FakeEC2Conn client that also records the
+// parameters of every request.
+func fakeEC2Conn(handle func(r *request.Request)) (*ec2.EC2, *[]interface{}) {
+	var params []interface{}
+	conn := awscommon.FakeEC2Conn(func(r *request.Request) {
+		params = append(params, r.Params)
+		handle(r)
+	})
+	return conn, &params
+}
+
+func TestStepCreateAMI_FastImage(t *testing.T) {
+	var b Builder
+	config := testConfig()
+	config["fast_image"] = true
+
+	_, warnings, err := b.Prepare(config)
+	if err != nil {
+		t.Fatalf("should not have error: %s", err)
+	}
+	if len(warnings) != 1 {
+		t.Fatalf("expected a consistency warning, got: %#v", warnings)
+	}
+
+	conn, params := fakeEC2Conn(func(r *request.Request) {
+		switch out := r.Data.(type) {
+		case *ec2.CreateImageOutput:
+			out.ImageId = aws.String("ami-12345")
+		case *ec2.DescribeImagesOutput:
+			out.Images = []*ec2.Image{
+				{
+					ImageId: aws.String("ami-12345"),
+					State:   aws.String(ec2.ImageStateAvailable),
+				},
+			}
+		}
+	})
+
+	state := new(multistep.BasicStateBag)
+	state.Put("config", &b.config)
+	state.Put("ec2", conn)
+	state.Put("ui", packersdk.TestUi(t))
+	state.Put("instance", &ec2.Instance{InstanceId: aws.String("i-12345")})
+
+	stopStep := &awscommon.StepStopEBSBackedInstance{
+		PollingConfig:       b.config.PollingConfig,
+		Skip:                b.config.skipStopInstance(),
+		DisableStopInstance: b.config.DisableStopInstance,
+	}
+	if action := stopStep.Run(context.Background(), state); action != multistep.ActionContinue {
+		t.Fatalf("stop step should continue, got %v: %v", action, state.Get("error"))
+	}
+	if len(*params) != 0 {
+		t.Fatalf("stop step should be skipped, but sent: %#v", *params)
+	}
+
+	step := &stepCreateAMI{
+		NoReboot:      b.config.FastImage,
+		PollingConfig: b.config.PollingConfig,
+		Ctx:           b.config.ctx,
+	}
+	if action := step.Run(context.Background(), state); action != multistep.ActionContinue {
+		t.Fatalf("create AMI step should continue, got %v: %v", action, state.Get("error"))
+	}
+
+	var createImage *ec2.CreateImageInput
+	for _, p := range *params {
+		if input, ok := p.(*ec2.CreateImageInput); ok {
+			createImage = input
+		}
+		if _, ok := p.(*ec2.StopInstancesInput); ok {
+			t.Fatalf("the instance should not be stopped")
+		}
+	}
+	if createImage == nil {
+		t.Fatalf("CreateImage should have been called")
+	}
+	if !aws.BoolValue(createImage.NoReboot) {
+		t.Fatalf("CreateImage should be called with NoReboot")
+	}
+}
+
+func TestBuilderPrepare_FastImageConflicts(t *testing.T) {
+	for _, option := range []string{"disable_stop_instance", "sriov_support", "ena_support"} {
+		t.Run(option, func(t *testing.T) {
+			var b Builder
+			config := testConfig()
+			config["fast_image"] = true
+			config[option] = true
+
+			if _, _, err := b.Prepare(config); err == nil {
+				t.Fatalf("fast_image should not be allowed with %s", option)
+			}
+		})
+	}
+}
+
+func TestStepCreateAMI_TpmSupport(t *testing.T) {
+	var b Builder
+	config := testConfig()
+	config["tpm_support"] = "v2.0"
+	if _, _, err := b.Prepare(config); err != nil {
+		t.Fatalf("should not have error: %s", err)
+	}
+
+	registered := 0
+	conn, params := fakeEC2Conn(func(r *request.Request) {
+		switch in := r.Params.(type) {
+		case *ec2.CreateImageInput:
+			r.Data.(*ec2.CreateImageOutput).ImageId = aws.String("ami-created")
+		case *ec2.RegisterImageInput:
+			registered++
+			r.Data.(*ec2.RegisterImageOutput).ImageId = aws.String(fmt.Sprintf("ami-registered-%d", registered))
+		case *ec2.DescribeImagesInput:
+			image := &ec2.Image{
+				ImageId: in.ImageIds[0],
+				Name:    aws.String(b.config.AMIName),
+				State:   aws.String(ec2.ImageStateAvailable),
+				BlockDeviceMappings: []*ec2.BlockDeviceMapping{{
+					DeviceName: aws.String("/dev/sda1"),
+					Ebs: &ec2.EbsBlockDevice{
+						SnapshotId: aws.String("snap-12345"),
+						Encrypted:  aws.Bool(true),
+					},
+				}},
+			}
+			if strings.HasPrefix(aws.StringValue(in.ImageIds[0]), "ami-registered") {
+				image.TpmSupport = aws.String(ec2.TpmSupportValuesV20)
+			}
+			r.Data.(*ec2.DescribeImagesOutput).Images = []*ec2.Image{image}
+		}
+	})
+
+	state := new(multistep.BasicStateBag)
+	state.Put("config", &b.config)
+	state.Put("ec2", conn)
+	state.Put("ui", packersdk.TestUi(t))
+	state.Put("instance", &ec2.Instance{InstanceId: aws.String("i-12345")})
+
+	step := &stepCreateAMI{
+		TpmSupport:    b.config.TpmSupport,
+		PollingConfig: b.config.PollingConfig,
+		Ctx:           b.config.ctx,
+	}
+	if action := step.Run(context.Background(), state); action != multistep.ActionContinue {
+		t.Fatalf("create AMI step should continue, got %v: %v", action, state.Get("error"))
+	}
+
+	// The created AMI is only deregistered once it was registered again
+	// under a temporary name.
+	var calls []string
+	var register *ec2.RegisterImageInput
+	for _, p := range *params {
+		switch input := p.(type) {
+		case *ec2.DeregisterImageInput:
+			calls = append(calls, "deregister "+aws.StringValue(input.ImageId))
+		case *ec2.RegisterImageInput:
+			calls = append(calls, "register "+aws.StringValue(input.Name))
+			register = input
+		case *ec2.DeleteSnapshotInput:
+			t.Fatalf("the snapshots should be kept")
+		}
+	}
+	if len(calls) != 4 || !strings.HasPrefix(calls[0], "register packer-") {
+		t.Fatalf("expected the AMI to be registered under a temporary name first, got calls %v", calls)
+	}
+	expected := []string{"deregister ami-created", "register " + b.config.AMIName, "deregister ami-registered-1"}
+	if !reflect.DeepEqual(calls[1:], expected) {
+		t.Fatalf("expected calls %v, got %v", expected, calls[1:])
+	}
+	if aws.StringValue(register.TpmSupport) != ec2.TpmSupportValuesV20 {
+		t.Fatalf("expected TpmSupport v2.0, got %q", aws.StringValue(register.TpmSupport))
+	}
+	if aws.StringValue(register.Name) != b.config.AMIName {
+		t.Fatalf("expected the AMI name %q, got %q", b.config.AMIName, aws.StringValue(register.Name))
+	}
+	if ebs := register.BlockDeviceMappings[0].Ebs; aws.StringValue(ebs.SnapshotId) != "snap-12345" || ebs.Encrypted != nil {
+		t.Fatalf("unexpected block device mapping: %s", ebs)
+	}
+
+	amis := state.Get("amis").(map[string]string)
+	if amis["us-east-1"] != "ami-registered-2" {
+		t.Fatalf("expected the registered AMI in the state, got %v", amis)
+	}
+	snapshots := state.Get("snapshots").(map[string][]string)
+	if len(snapshots["us-east-1"]) != 1 || snapshots["us-east-1"][0] != "snap-12345" {
+		t.Fatalf("expected the snapshots to be kept, got %v", snapshots)
+	}
+}
+
+func TestStepCreateAMI_TpmSupportWindowsSource(t *testing.T) {
+	var b Builder
+	config := testConfig()
+	config["tpm_support"] = "v2.0"
+	if _, _, err := b.Prepare(config); err != nil {
+		t.Fatalf("should not have error: %s", err)
+	}
+
+	conn, params := fakeEC2Conn(func(r *request.Request) {
+		switch in := r.Params.(type) {
+		case *ec2.CreateImageInput:
+			r.Data.(*ec2.CreateImageOutput).ImageId = aws.String("ami-created")
+		case *ec2.DescribeImagesInput:
+			r.Data.(*ec2.DescribeImagesOutput).Images = []*ec2.Image{{
+				ImageId:         in.ImageIds[0],
+				Name:            aws.String(b.config.AMIName),
+				State:           aws.String(ec2.ImageStateAvailable),
+				PlatformDetails: aws.String("Windows"),
+				UsageOperation:  aws.String("RunInstances:0002"),
+			}}
+		}
+	})
+
+	state := new(multistep.BasicStateBag)
+	state.Put("config", &b.config)
+	state.Put("ec2", conn)
+	state.Put("ui", packersdk.TestUi(t))
+	state.Put("instance", &ec2.Instance{InstanceId: aws.String("i-12345")})
+
+	step := &stepCreateAMI{
+		TpmSupport:    b.config.TpmSupport,
+		PollingConfig: b.config.PollingConfig,
+		Ctx:           b.config.ctx,
+	}
+	if action := step.Run(context.Background(), state); action != multistep.ActionHalt {
+		t.Fatalf("create AMI step should halt, got %v", action)
+	}
+
+	// The Windows license would be lost, the AMI isn't registered again.
+	for _, p := range *params {
+		switch p.(type) {
+		case *ec2.RegisterImageInput, *ec2.DeregisterImageInput:
+			t.Fatalf("the AMI shouldn't be registered again, got %T", p)
+		}
+	}
+}
+
+func TestStepCreateAMI_BillingProducts(t *testing.T) {
+	var b Builder
+	config := testConfig()
+	config["ami_billing_products"] = []string{"bp-6ba54002"}
+	if _, _, err := b.Prepare(config); err != nil {
+		t.Fatalf("should not have error: %s", err)
+	}
+
+	conn, params := fakeEC2Conn(func(r *request.Request) {
+		switch in := r.Params.(type) {
+		case *ec2.CreateImageInput:
+			r.Data.(*ec2.CreateImageOutput).ImageId = aws.String("ami-created")
+		case *ec2.RegisterImageInput:
+			r.Data.(*ec2.RegisterImageOutput).ImageId = aws.String("ami-registered")
+		case *ec2.DescribeImagesInput:
+			r.Data.(*ec2.DescribeImagesOutput).Images = []*ec2.Image{{
+				ImageId: in.ImageIds[0],
+				Name:    aws.String(b.config.AMIName),
+				State:   aws.String(ec2.ImageStateAvailable),
+				BlockDeviceMappings: []*ec2.BlockDeviceMapping{{
+					DeviceName: aws.String("/dev/sda1"),
+					Ebs:        &ec2.EbsBlockDevice{SnapshotId: aws.String("snap-12345")},
+				}},
+			}}
+		}
+	})
+
+	state := new(multistep.BasicStateBag)
+	state.Put("config", &b.config)
+	state.Put("ec2", conn)
+	state.Put("ui", packersdk.TestUi(t))
+	state.Put("instance", &ec2.Instance{InstanceId: aws.String("i-12345")})
+
+	step := &stepCreateAMI{
+		PollingConfig: b.config.PollingConfig,
+		Ctx:           b.config.ctx,
+	}
+	if action := step.Run(context.Background(), state); action != multistep.ActionContinue {
+		t.Fatalf("create AMI step should continue, got %v: %v", action, state.Get("error"))
+	}
+
+	var register *ec2.RegisterImageInput
+	for _, p := range *params {
+		if input, ok := p.(*ec2.RegisterImageInput); ok {
+			register = input
+		}
+	}
+	if register == nil {
+		t.Fatalf("the AMI should be registered again with its billing products")
+	}
+	if products := aws.StringValueSlice(register.BillingProducts); len(products) != 1 || products[0] != "bp-6ba54002" {
+		t.Fatalf("expected the billing products of the config, got %v", products)
+	}
+	if register.TpmSupport != nil {
+		t.Fatalf("the NitroTPM support of the AMI should be kept, got %q", aws.StringValue(register.TpmSupport))
+	}
+	if amis := state.Get("amis").(map[string]string); amis["us-east-1"] != "ami-registered" {
+		t.Fatalf("expected the registered AMI in the state, got %v", amis)
+	}
+}
@@ -12,7 +12,10 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"strings"
 
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/aws/aws-sdk-go/service/iam"
 	"github.com/hashicorp/hcl/v2/hcldec"
@@ -37,6 +40,12 @@ type Config struct {
 
 	// The description for the snapshot.
 	SnapshotDescription string `mapstructure:"snapshot_description" required:"false"`
+	// The ARN of the AWS Outpost to store the volume snapshots on, such as
+	// `arn:aws:outposts:us-east-1:123456789012:outpost/op-1234567890abcdef0`.
+	// The launch volumes must be on that Outpost, which means the instance
+	// must run in one of its subnets. By default snapshots are stored in the
+	// region.
+	OutpostArn string `mapstructure:"outpost_arn" required:"false"`
 	// Add one or more block device mappings to the AMI. These will be attached
 	// when booting a new instance from your AMI. To add a block device during
 	// the Packer build see `launch_block_device_mappings` below. Your options
@@ -64,6 +73,17 @@ type Config struct {
 	//     the source instance to be used as the root device for the AMI. This
 	//     must correspond to a block device in `launch_block_device_mapping`.
 	RootDevice RootBlockDevice `mapstructure:"ami_root_device" required:"true"`
+	// Overrides `delete_on_termination` for the launch block device mapping
+	// whose `device_name` matches `ami_root_device.source_device_name`, that
+	// is the volume of the surrogate instance the AMI root is created from.
+	// When unset, the value from `launch_block_device_mappings` is used, which
+	// defaults to `false`.
+	//
+	// ~> **Warning:** if the surrogate root volume is not deleted on
+	// termination, an aborted or failed build leaves it behind as an orphaned
+	// volume that keeps incurring costs. Set this to `true` unless you clean
+	// up those volumes by other means.
+	LaunchRootDeleteOnTermination config.Trilean `mapstructure:"launch_root_delete_on_termination" required:"false"`
 	// Tags to apply to the volumes that are *launched* to create the AMI.
 	// These tags are *not* applied to the resulting AMI unless they're
 	// duplicated in `tags`. This is a [template
@@ -78,7 +98,7 @@ type Config struct {
 	// what architecture to use when registering the final AMI; valid options
 	// are "arm64", "arm64_mac", "i386", "x86_64", or "x86_64_mac". Defaults to "x86_64".
 	Architecture string `mapstructure:"ami_architecture" required:"false"`
-	// The boot mode. Valid options are `legacy-bios` and `uefi`. See the documentation on
+	// The boot mode. Valid options are `legacy-bios`, `uefi` and `uefi-preferred`. See the documentation on
 	// [boot modes](https://docs.aws.amazon.com/AWSEC2/latest/UserGuide/ami-boot.html) for
 	// more information. Defaults to `legacy-bios` when `ami_architecture` is `x86_64` and
 	// `uefi` when `ami_architecture` is `arm64`.
@@ -89,6 +109,13 @@ type Config struct {
 	// NitroTPM Support. Valid options are `v2.0`. See the documentation on
 	// [NitroTPM Support](https://docs.aws.amazon.com/AWSEC2/latest/UserGuide/enable-nitrotpm-support-on-ami.html) for
 	// more information. Only enabled if a valid option is provided, otherwise ignored.
+	// Requires `boot_mode` to be `uefi` or `uefi-preferred`, which is the default
+	// only when `ami_architecture` is `arm64`.
+	//
+	// When unset, the default, the AMI is registered without NitroTPM support,
+	// even when the source AMI had it enabled. This only holds with
+	// `use_create_image` set to `false`: the CreateImage API inherits NitroTPM
+	// support from the surrogate instance.
 	TpmSupport string `mapstructure:"tpm_support" required:"false"`
 	// Whether to use the CreateImage or RegisterImage API when creating the AMI.
 	// When set to `true`, the CreateImage API is used and will create the image
@@ -96,6 +123,13 @@ type Config struct {
 	// When set to `false`, the RegisterImage API is used and the image is created using
 	// a snapshot of the specified EBS volume, and no properties are inherited from the instance.
 	// Defaults to `false`.
+	//
+	// With either API, the root volume of the AMI is the launch block device named
+	// `ami_root_device.source_device_name`, renamed to `ami_root_device.device_name`: the volume
+	// type, size, IOPS and throughput of `ami_root_device` are set on that launch block device
+	// when it leaves them unset, and only produce a warning when it sets different ones. With
+	// CreateImage, the boot mode, NitroTPM support and UEFI data of the AMI are inherited from the surrogate instance:
+	// `boot_mode` and `tpm_support` are ignored, and `uefi_data` can't be set.
 	//Ref: https://docs.aws.amazon.com/AWSEC2/latest/APIReference/API_CreateImage.html
 	//     https://docs.aws.amazon.com/AWSEC2/latest/APIReference/API_RegisterImage.html
 	UseCreateImage bool `mapstructure:"use_create_image" required:"false"`
@@ -155,17 +189,102 @@ func (b *Builder) Prepare(raws ...interface{}) ([]string, []string, error) {
 	errs = packersdk.MultiErrorAppend(errs, b.config.LaunchMappings.Prepare(&b.config.ctx)...)
 	errs = packersdk.MultiErrorAppend(errs, b.config.RootDevice.Prepare(&b.config.ctx)...)
 
+	if b.config.RootDevice.Encrypted.True() && b.config.AMIEncryptBootVolume.True() {
+		// The root snapshot isn't copied to encrypt it, so it has to be
+		// encrypted with the key encrypt_boot would have used.
+		kmsKeyId := b.config.AMIKmsKeyId
+		if kmsKeyId == "" {
+			kmsKeyId = b.config.AMIRegionKMSKeyIDs[b.config.RawRegion]
+		}
+		if b.config.RootDevice.KmsKeyId == "" {
+			b.config.RootDevice.KmsKeyId = kmsKeyId
+		} else if kmsKeyId != "" && kmsKeyId != b.config.RootDevice.KmsKeyId {
+			errs = packersdk.MultiErrorAppend(errs, fmt.Errorf("ami_root_device.kms_key_id %s conflicts "+
+				"with the kms_key_id %s of encrypt_boot in %s", b.config.RootDevice.KmsKeyId, kmsKeyId,
+				b.config.RawRegion))
+		}
+	}
+
+	if b.config.OutpostArn != "" {
+		if err := awscommon.IsValidOutpostArn(b.config.OutpostArn); err != nil {
+			errs = packersdk.MultiErrorAppend(errs, err)
+		}
+	}
+
 	if b.config.AMIVirtType == "" {
 		errs = packersdk.MultiErrorAppend(errs, errors.New("ami_virtualization_type is required."))
 	}
 
 	foundRootVolume := false
-	for _, launchDevice := range b.config.LaunchMappings {
+	for i, launchDevice := range b.config.LaunchMappings {
 		if launchDevice.DeviceName == b.config.RootDevice.SourceDeviceName {
 			foundRootVolume = true
+			if b.config.LaunchRootDeleteOnTermination != config.TriUnset {
+				b.config.LaunchMappings[i].DeleteOnTermination = b.config.LaunchRootDeleteOnTermination.True()
+			}
 			if launchDevice.OmitFromArtifact {
 				errs = packersdk.MultiErrorAppend(errs, fmt.Errorf("You cannot set \"omit_from_artifact\": \"true\" for the root volume."))
 			}
+			if snapshotId := b.config.RootDevice.SnapshotId; snapshotId != "" {
+				if launchDevice.SnapshotId != "" && launchDevice.SnapshotId != snapshotId {
+					errs = packersdk.MultiErrorAppend(errs, fmt.Errorf("ami_root_device.snapshot_id %s conflicts "+
+						"with the snapshot_id %s of launch block device %s", snapshotId, launchDevice.SnapshotId,
+						launchDevice.DeviceName))
+				}
+				b.config.LaunchMappings[i].SnapshotId = snapshotId
+			}
+			// The root volume of the AMI is created from the launch
+			// device, so the volume settings of the root device are set
+			// on it, unless it sets its own.
+			var ignored []string
+			if volumeType := b.config.RootDevice.VolumeType; volumeType != "" {
+				if launchDevice.VolumeType == "" {
+					b.config.LaunchMappings[i].VolumeType = volumeType
+				} else if launchDevice.VolumeType != volumeType {
+					ignored = append(ignored, "volume_type")
+				}
+			}
+			if volumeSize := b.config.RootDevice.VolumeSize; volumeSize != 0 {
+				if launchDevice.VolumeSize == 0 {
+					b.config.LaunchMappings[i].VolumeSize = volumeSize
+				} else if launchDevice.VolumeSize != volumeSize {
+					ignored = append(ignored, "volume_size")
+				}
+			}
+			if iops := b.config.RootDevice.IOPS; iops != 0 {
+				if launchDevice.IOPS == nil {
+					b.config.LaunchMappings[i].IOPS = aws.Int64(iops)
+				} else if *launchDevice.IOPS != iops {
+					ignored = append(ignored, "iops")
+				}
+			}
+			if throughput := b.config.RootDevice.Throughput; throughput != 0 {
+				if launchDevice.Throughput == nil {
+					b.config.LaunchMappings[i].Throughput = aws.Int64(throughput)
+				} else if *launchDevice.Throughput != throughput {
+					ignored = append(ignored, "throughput")
+				}
+			}
+			if len(ignored) > 0 {
+				warns = append(warns, fmt.Sprintf("The %s of ami_root_device differ from the launch block "+
+					"device %s, and are ignored: the root volume of the AMI is created from that launch "+
+					"block device, set them there instead.", strings.Join(ignored, ", "), launchDevice.DeviceName))
+			}
+			if encrypted := b.config.RootDevice.Encrypted; encrypted != config.TriUnset {
+				if launchDevice.Encrypted != config.TriUnset && launchDevice.Encrypted != encrypted {
+					errs = packersdk.MultiErrorAppend(errs, fmt.Errorf("ami_root_device.encrypted conflicts "+
+						"with the encrypted setting of launch block device %s", launchDevice.DeviceName))
+				}
+				b.config.LaunchMappings[i].Encrypted = encrypted
+			}
+			if kmsKeyId := b.config.RootDevice.KmsKeyId; kmsKeyId != "" {
+				if launchDevice.KmsKeyId != "" && launchDevice.KmsKeyId != kmsKeyId {
+					errs = packersdk.MultiErrorAppend(errs, fmt.Errorf("ami_root_device.kms_key_id %s conflicts "+
+						"with the kms_key_id %s of launch block device %s", kmsKeyId, launchDevice.KmsKeyId,
+						launchDevice.DeviceName))
+				}
+				b.config.LaunchMappings[i].KmsKeyId = kmsKeyId
+			}
 		}
 	}
 
@@ -173,6 +292,16 @@ func (b *Builder) Prepare(raws ...interface{}) ([]string, []string, error) {
 		errs = packersdk.MultiErrorAppend(errs, fmt.Errorf("no volume with name '%s' is found", b.config.RootDevice.SourceDeviceName))
 	}
 
+	var artifactMappings []awscommon.BlockDevice
+	for _, launchDevice := range b.config.LaunchMappings {
+		if !launchDevice.OmitFromArtifact {
+			artifactMappings = append(artifactMappings, launchDevice.BlockDevice)
+		}
+	}
+	if err := b.config.CheckMaxSize(artifactMappings, b.config.AMIMappings); err != nil {
+		errs = packersdk.MultiErrorAppend(errs, err)
+	}
+
 	if b.config.RunConfig.SpotPriceAutoProduct != "" {
 		warns = append(warns, "spot_price_auto_product is deprecated and no "+
 			"longer necessary for Packer builds. In future versions of "+
@@ -180,6 +309,9 @@ func (b *Builder) Prepare(raws ...interface{}) ([]string, []string, error) {
 			"builds. Please take a look at our current documentation to "+
 			"understand how Packer requests Spot instances.")
 	}
+	if warn := b.config.RunConfig.EbsOptimizedWarning(); warn != "" {
+		warns = append(warns, warn)
+	}
 
 	if b.config.RunConfig.EnableT2Unlimited {
 		warns = append(warns, "enable_t2_unlimited is deprecated please use "+
@@ -203,6 +335,13 @@ func (b *Builder) Prepare(raws ...interface{}) ([]string, []string, error) {
 
 	if b.config.TpmSupport != "" && b.config.TpmSupport != ec2.TpmSupportValuesV20 {
 		errs = packersdk.MultiErrorAppend(errs, fmt.Errorf(`The only valid tpm_support value is %q`, ec2.TpmSupportValuesV20))
+	} else if b.config.TpmSupport != "" && !b.config.UseCreateImage {
+		if b.config.BootMode == "legacy-bios" {
+			errs = packersdk.MultiErrorAppend(errs, fmt.Errorf(`You can't use tpm_support with boot_mode set to "legacy-bios".`))
+		} else if b.config.BootMode == "" && b.config.Architecture != "arm64" {
+			errs = packersdk.MultiErrorAppend(errs, fmt.Errorf(`You need boot_mode set to "uefi" or "uefi-preferred" to use tpm_support, `+
+				`"%s" architecture defaults to "legacy-bios".`, b.config.Architecture))
+		}
 	}
 
 	if b.config.BootMode != "" {
@@ -221,6 +360,33 @@ func (b *Builder) Prepare(raws ...interface{}) ([]string, []string, error) {
 		}
 	}
 
+	if b.config.RootDevice.Encrypted.True() && b.config.AMIEncryptBootVolume.False() {
+		errs = packersdk.MultiErrorAppend(errs, errors.New("ami_root_device.encrypted can't be true "+
+			"with encrypt_boot set to false: the root snapshot of the AMI is encrypted like the root volume."))
+	}
+
+	if b.config.UseCreateImage {
+		if b.config.UefiData != "" {
+			errs = packersdk.MultiErrorAppend(errs, errors.New(`uefi_data can't be used with use_create_image: `+
+				`CreateImage inherits the UEFI variable store of the surrogate instance. Set use_create_image `+
+				`to false to register the AMI with it.`))
+		}
+		var inherited []string
+		if b.config.BootMode != "" {
+			inherited = append(inherited, "boot_mode")
+		}
+		if b.config.TpmSupport == ec2.TpmSupportValuesV20 {
+			inherited = append(inherited, "tpm_support")
+		}
+		if len(b.config.AMIBillingProducts) > 0 {
+			inherited = append(inherited, "ami_billing_products")
+		}
+		if len(inherited) > 0 {
+			warns = append(warns, fmt.Sprintf("use_create_image ignores %s: CreateImage inherits them "+
+				"from the surrogate instance, and so from source_ami.", strings.Join(inherited, " and ")))
+		}
+	}
+
 	if errs != nil && len(errs.Errors) > 0 {
 		return nil, warns, errs
 	}
@@ -228,9 +394,17 @@ func (b *Builder) Prepare(raws ...interface{}) ([]string, []string, error) {
 	packersdk.LogSecretFilter.Set(b.config.AccessKey, b.config.SecretKey, b.config.Token)
 
 	generatedData := awscommon.GetGeneratedDataList()
+	generatedData = append(generatedData, "CentralAMI", "UnshareAfter", "FailedCopyRegions")
 	return generatedData, warns, nil
 }
 
+// encryptBootCopy reports whether encrypt_boot needs the AMI to be copied in
+// the build region. It doesn't when ami_root_device.encrypted already
+// encrypts the root volume it is created from.
+func (c *Config) encryptBootCopy() bool {
+	return c.AMIEncryptBootVolume.True() && !c.RootDevice.Encrypted.True()
+}
+
 func (b *Builder) Run(ctx context.Context, ui packersdk.Ui, hook packersdk.Hook) (packersdk.Artifact, error) {
 	session, err := b.config.Session()
 	if err != nil {
@@ -253,12 +427,45 @@ func (b *Builder) Run(ctx context.Context, ui packersdk.Ui, hook packersdk.Hook)
 	state.Put("region", ec2conn.Config.Region)
 	generatedData := &packerbuilderdata.GeneratedData{State: state}
 
+	steps := b.buildSteps(session, ec2conn, generatedData)
+
+	// Run!
+	b.runner = commonsteps.NewRunner(steps, b.config.PackerConfig, ui)
+	b.runner.Run(ctx, state)
+
+	// If there was an error, return that
+	if rawErr, ok := state.GetOk("error"); ok {
+		return nil, rawErr.(error)
+	}
+
+	if amis, ok := state.GetOk("amis"); ok {
+		// Build the artifact and return it
+		artifact := &awscommon.Artifact{
+			Amis:           amis.(map[string]string),
+			BuilderIdValue: BuilderId,
+			Session:        session,
+			StateData:      map[string]interface{}{"generated_data": state.Get("generated_data")},
+		}
+		if centralAmi, ok := state.GetOk("central_ami"); ok {
+			artifact.CentralAmi = centralAmi.(string)
+		}
+
+		return artifact, nil
+	}
+
+	return nil, nil
+}
+
+// buildSteps returns the steps of the build, in the order they run.
+func (b *Builder) buildSteps(session *session.Session, ec2conn *ec2.EC2, generatedData *packerbuilderdata.GeneratedData) []multistep.Step {
 	var instanceStep multistep.Step
 
 	if b.config.IsSpotInstance() {
 		instanceStep = &awscommon.StepRunSpotInstance{
 			PollingConfig:                     b.config.PollingConfig,
 			AssociatePublicIpAddress:          b.config.AssociatePublicIpAddress,
+			EnablePrimaryIpv6:                 b.config.EnablePrimaryIpv6,
+			PrivateDnsNameOptions:             b.config.PrivateDnsNameOptions,
 			LaunchMappings:                    b.config.LaunchMappings,
 			BlockDurationMinutes:              b.config.BlockDurationMinutes,
 			Ctx:                               b.config.ctx,
@@ -278,13 +485,17 @@ func (b *Builder) Run(ctx context.Context, ui packersdk.Ui, hook packersdk.Hook)
 			Region:                            *ec2conn.Config.Region,
 			SourceAMI:                         b.config.SourceAmi,
 			SpotPrice:                         b.config.SpotPrice,
+			SpotRequestTimeout:                b.config.SpotRequestTimeout,
+			LogSpotPrices:                     b.config.LogSpotPrices,
 			SpotAllocationStrategy:            b.config.SpotAllocationStrategy,
 			SpotInstanceTypes:                 b.config.SpotInstanceTypes,
 			SpotTags:                          b.config.SpotTags,
-			Tags:                              b.config.RunTags,
+			Tags:                              b.config.EphemeralTags(b.config.RunTags),
 			UserData:                          b.config.UserData,
 			UserDataFile:                      b.config.UserDataFile,
-			VolumeTags:                        b.config.VolumeRunTags,
+			UserDataS3Url:                     b.config.UserDataS3Url,
+			UserDataSSMParameter:              b.config.UserDataSSMParameter,
+			VolumeTags:                        b.config.EphemeralTags(b.config.VolumeRunTags),
 		}
 	} else {
 		var tenancy string
@@ -300,6 +511,10 @@ func (b *Builder) Run(ctx context.Context, ui packersdk.Ui, hook packersdk.Hook)
 		instanceStep = &awscommon.StepRunSourceInstance{
 			PollingConfig:                     b.config.PollingConfig,
 			AssociatePublicIpAddress:          b.config.AssociatePublicIpAddress,
+			EnablePrimaryIpv6:                 b.config.EnablePrimaryIpv6,
+			PrivateDnsNameOptions:             b.config.PrivateDnsNameOptions,
+			EIPAllocationId:                   b.config.AssociateEIPAllocationId,
+			InsufficientCapacityRetries:       b.config.InsufficientCapacityRetries,
 			LaunchMappings:                    b.config.LaunchMappings,
 			CapacityReservationPreference:     b.config.CapacityReservationPreference,
 			CapacityReservationId:             b.config.CapacityReservationId,
@@ -320,13 +535,15 @@ func (b *Builder) Run(ctx context.Context, ui packersdk.Ui, hook packersdk.Hook)
 			InstanceType:                      b.config.InstanceType,
 			IsRestricted:                      b.config.IsChinaCloud(),
 			SourceAMI:                         b.config.SourceAmi,
-			Tags:                              b.config.RunTags,
+			Tags:                              b.config.EphemeralTags(b.config.RunTags),
 			LicenseSpecifications:             b.config.LicenseSpecifications,
 			HostResourceGroupArn:              b.config.Placement.HostResourceGroupArn,
 			Tenancy:                           tenancy,
 			UserData:                          b.config.UserData,
 			UserDataFile:                      b.config.UserDataFile,
-			VolumeTags:                        b.config.VolumeRunTags,
+			UserDataS3Url:                     b.config.UserDataS3Url,
+			UserDataSSMParameter:              b.config.UserDataSSMParameter,
+			VolumeTags:                        b.config.EphemeralTags(b.config.VolumeRunTags),
 		}
 	}
 
@@ -363,6 +580,7 @@ func (b *Builder) Run(ctx context.Context, ui packersdk.Ui, hook packersdk.Hook)
 			SnapshotOmitMap:     b.config.LaunchMappings.GetOmissions(),
 			SnapshotTags:        b.config.SnapshotTags,
 			SnapshotDescription: b.config.SnapshotDescription,
+			OutpostArn:          b.config.OutpostArn,
 			Ctx:                 b.config.ctx,
 		}
 		buildAmiStep = &StepRegisterAMI{
@@ -381,8 +599,22 @@ func (b *Builder) Run(ctx context.Context, ui packersdk.Ui, hook packersdk.Hook)
 		}
 	}
 
+	var iamActions []string
+	if b.config.CheckIAMPermissions {
+		iamActions = append(b.config.RunConfig.IAMActions(), b.config.AMIConfig.IAMActions()...)
+		if b.config.UseCreateImage {
+			iamActions = append(iamActions, "ec2:CreateImage")
+		} else {
+			iamActions = append(iamActions, "ec2:CreateSnapshot", "ec2:RegisterImage")
+		}
+	}
+
 	// Build the steps
 	steps := []multistep.Step{
+		&awscommon.StepCheckIAMPermissions{
+			Actions: iamActions,
+			Region:  *ec2conn.Config.Region,
+		},
 		&awscommon.StepPreValidate{
 			DestAmiName:        b.config.AMIName,
 			ForceDeregister:    b.config.AMIForceDeregister,
@@ -391,12 +623,17 @@ func (b *Builder) Run(ctx context.Context, ui packersdk.Ui, hook packersdk.Hook)
 			SubnetId:           b.config.SubnetId,
 			HasSubnetFilter:    !b.config.SubnetFilter.Empty(),
 		},
+		&StepCheckRootSnapshot{
+			RootDevice: b.config.RootDevice,
+		},
 		&awscommon.StepSourceAMIInfo{
 			SourceAmi:                b.config.SourceAmi,
 			EnableAMISriovNetSupport: b.config.AMISriovNetSupport,
 			EnableAMIENASupport:      b.config.AMIENASupport,
 			AmiFilters:               b.config.SourceAmiFilter,
+			AmiFilterGroups:          b.config.SourceAmiFilters,
 			AMIVirtType:              b.config.AMIVirtType,
+			AllowedOwners:            b.config.AllowedSourceAmiOwners,
 		},
 		&awscommon.StepNetworkInfo{
 			VpcId:                    b.config.VpcId,
@@ -414,7 +651,7 @@ func (b *Builder) Run(ctx context.Context, ui packersdk.Ui, hook packersdk.Hook)
 			Comm:         &b.config.RunConfig.Comm,
 			IsRestricted: b.config.IsChinaCloud(),
 			DebugKeyPath: fmt.Sprintf("ec2_%s.pem", b.config.PackerBuildName),
-			Tags:         b.config.RunTags,
+			Tags:         b.config.EphemeralTags(b.config.RunTags),
 			Ctx:          b.config.ctx,
 		},
 		&awscommon.StepSecurityGroup{
@@ -425,7 +662,7 @@ func (b *Builder) Run(ctx context.Context, ui packersdk.Ui, hook packersdk.Hook)
 			TemporarySGSourcePublicIp: b.config.TemporarySGSourcePublicIp,
 			SkipSSHRuleCreation:       b.config.SSMAgentEnabled(),
 			IsRestricted:              b.config.IsChinaCloud(),
-			Tags:                      b.config.RunTags,
+			Tags:                      b.config.EphemeralTags(b.config.RunTags),
 			Ctx:                       b.config.ctx,
 		},
 		&awscommon.StepIamInstanceProfile{
@@ -433,7 +670,7 @@ func (b *Builder) Run(ctx context.Context, ui packersdk.Ui, hook packersdk.Hook)
 			IamInstanceProfile:                        b.config.IamInstanceProfile,
 			SkipProfileValidation:                     b.config.SkipProfileValidation,
 			TemporaryIamInstanceProfilePolicyDocument: b.config.TemporaryIamInstanceProfilePolicyDocument,
-			Tags: b.config.RunTags,
+			Tags: b.config.EphemeralTags(b.config.RunTags),
 			Ctx:  b.config.ctx,
 		},
 		&awscommon.StepCleanupVolumes{
@@ -450,7 +687,9 @@ func (b *Builder) Run(ctx context.Context, ui packersdk.Ui, hook packersdk.Hook)
 			AWSSession:       session,
 			Region:           *ec2conn.Config.Region,
 			PauseBeforeSSM:   b.config.PauseBeforeSSM,
+			PollingConfig:    b.config.PollingConfig,
 			LocalPortNumber:  b.config.SessionManagerPort,
+			LocalPortRange:   b.config.SessionManagerPortRange,
 			RemotePortNumber: b.config.Comm.Port(),
 			SSMAgentEnabled:  b.config.SSMAgentEnabled(),
 			SSHConfig:        &b.config.Comm.SSH,
@@ -472,6 +711,9 @@ func (b *Builder) Run(ctx context.Context, ui packersdk.Ui, hook packersdk.Hook)
 			GeneratedData: generatedData,
 		},
 		&commonsteps.StepProvision{},
+		&awscommon.StepWaitForCloudInit{
+			Skip: !b.config.WaitForCloudInit,
+		},
 		&commonsteps.StepCleanupTempKeys{
 			Comm: &b.config.RunConfig.Comm,
 		},
@@ -504,55 +746,52 @@ func (b *Builder) Run(ctx context.Context, ui packersdk.Ui, hook packersdk.Hook)
 			OriginalRegion:                 *ec2conn.Config.Region,
 			AMISkipBuildRegion:             b.config.AMISkipBuildRegion,
 			AMISnapshotCopyDurationMinutes: b.config.AMISnapshotCopyDurationMinutes,
+			IgnoreCopyErrors:               b.config.AMIIgnoreCopyErrors,
+			RegionVolumeTypes:              b.config.AMIRegionVolumeTypes,
+			GeneratedData:                  generatedData,
 		},
 		&awscommon.StepEnableDeprecation{
-			AccessConfig:    &b.config.AccessConfig,
-			DeprecationTime: b.config.DeprecationTime,
+			AccessConfig:          &b.config.AccessConfig,
+			DeprecationTime:       b.config.DeprecationTime,
+			DeprecatePreviousTags: b.config.DeprecatePreviousTags,
 		},
 		&awscommon.StepEnableDeregistrationProtection{
 			AccessConfig:             &b.config.AccessConfig,
 			DeregistrationProtection: &b.config.DeregistrationProtection,
 		},
+		&awscommon.StepCentralAccountCopy{
+			AccessConfig:  &b.config.AccessConfig,
+			Role:          b.config.AMICentralAccountRole,
+			KmsKeyId:      b.config.AMICentralAccountKmsKeyId,
+			Name:          b.config.AMIName,
+			Region:        *ec2conn.Config.Region,
+			PollingConfig: b.config.PollingConfig,
+			GeneratedData: generatedData,
+		},
 		&awscommon.StepModifyAMIAttributes{
-			Description:    b.config.AMIDescription,
-			Users:          b.config.AMIUsers,
-			Groups:         b.config.AMIGroups,
-			OrgArns:        b.config.AMIOrgArns,
-			OuArns:         b.config.AMIOuArns,
-			ProductCodes:   b.config.AMIProductCodes,
-			SnapshotUsers:  b.config.SnapshotUsers,
-			SnapshotGroups: b.config.SnapshotGroups,
-			IMDSSupport:    b.config.AMIIMDSSupport,
-			Ctx:            b.config.ctx,
-			GeneratedData:  generatedData,
+			Description:       b.config.AMIDescription,
+			Users:             b.config.AMIUsers,
+			UsersSSMParameter: b.config.AMIUsersSSMParameter,
+			Groups:            b.config.AMIGroups,
+			OrgArns:           b.config.AMIOrgArns,
+			OuArns:            b.config.AMIOuArns,
+			ProductCodes:      b.config.AMIProductCodes,
+			ShareDuration:     b.config.AMIShareDuration,
+			SnapshotUsers:     b.config.SnapshotUsers,
+			SnapshotGroups:    b.config.SnapshotGroups,
+			WaitForSnapshots:  b.config.AMIWaitForSnapshots,
+			PollingConfig:     b.config.PollingConfig,
+			IMDSSupport:       b.config.AMIIMDSSupport,
+			Ctx:               b.config.ctx,
+			GeneratedData:     generatedData,
 		},
 		&awscommon.StepCreateTags{
-			Tags:         b.config.AMITags,
-			SnapshotTags: b.config.SnapshotTags,
-			Ctx:          b.config.ctx,
+			Tags:           b.config.AMITags,
+			SnapshotTags:   b.config.SnapshotTags,
+			TagCopyRegions: b.config.AMITagCopyRegions,
+			Ctx:            b.config.ctx,
 		},
 	}
 
-	// Run!
-	b.runner = commonsteps.NewRunner(steps, b.config.PackerConfig, ui)
-	b.runner.Run(ctx, state)
-
-	// If there was an error, return that
-	if rawErr, ok := state.GetOk("error"); ok {
-		return nil, rawErr.(error)
-	}
-
-	if amis, ok := state.GetOk("amis"); ok {
-		// Build the artifact and return it
-		artifact := &awscommon.Artifact{
-			Amis:           amis.(map[string]string),
-			BuilderIdValue: BuilderId,
-			Session:        session,
-			StateData:      map[string]interface{}{"generated_data": state.Get("generated_data")},
-		}
-
-		return artifact, nil
-	}
-
-	return nil, nil
+	return steps
 }
@@ -4,11 +4,16 @@
 package ebssurrogate
 
 import (
+	"strings"
 	"testing"
 
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/hashicorp/packer-plugin-amazon/builder/common"
 
 	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
+	"github.com/hashicorp/packer-plugin-sdk/packerbuilderdata"
+	"github.com/hashicorp/packer-plugin-sdk/template/config"
 )
 
 func testConfig() map[string]interface{} {
@@ -248,6 +253,8 @@ func TestBuilderPrepare_TpmSupportValue(t *testing.T) {
 	tests := []struct {
 		name        string
 		optValue    string
+		bootMode    string
+		arch        string
 		expectError bool
 	}{
 		{
@@ -256,13 +263,43 @@ func TestBuilderPrepare_TpmSupportValue(t *testing.T) {
 			expectError: false,
 		},
 		{
-			name:        "OK - v2.0",
+			name:        "OK - v2.0 with uefi",
+			optValue:    "v2.0",
+			bootMode:    "uefi",
+			expectError: false,
+		},
+		{
+			name:        "OK - v2.0 with uefi-preferred",
 			optValue:    "v2.0",
+			bootMode:    "uefi-preferred",
 			expectError: false,
 		},
+		{
+			name:        "OK - v2.0 with arm64 default boot mode",
+			optValue:    "v2.0",
+			arch:        "arm64",
+			expectError: false,
+		},
+		{
+			name:        "Error - v2.0 with legacy-bios",
+			optValue:    "v2.0",
+			bootMode:    "legacy-bios",
+			expectError: true,
+		},
+		{
+			name:        "Error - v2.0 with x86_64 default boot mode",
+			optValue:    "v2.0",
+			expectError: true,
+		},
+		{
+			name:        "Error - none",
+			optValue:    "none",
+			expectError: true,
+		},
 		{
 			name:        "Error - bad value set",
 			optValue:    "v3.0",
+			bootMode:    "uefi",
 			expectError: true,
 		},
 	}
@@ -274,6 +311,8 @@ func TestBuilderPrepare_TpmSupportValue(t *testing.T) {
 			config["ami_virtualization_type"] = "kvm"
 
 			config["tpm_support"] = tt.optValue
+			config["boot_mode"] = tt.bootMode
+			config["ami_architecture"] = tt.arch
 
 			b := &Builder{}
 			// Basic configuration
@@ -304,3 +343,482 @@ func TestBuilderPrepare_TpmSupportValue(t *testing.T) {
 		})
 	}
 }
+
+func TestBuilderPrepare_LaunchRootDeleteOnTermination(t *testing.T) {
+	tests := []struct {
+		name                string
+		optValue            interface{}
+		mappingValue        bool
+		expectedRootValue   bool
+		expectedExtraVolume bool
+	}{
+		{
+			name:              "unset - keeps the launch mapping value",
+			optValue:          nil,
+			mappingValue:      true,
+			expectedRootValue: true,
+		},
+		{
+			name:              "true - overrides the launch mapping value",
+			optValue:          true,
+			mappingValue:      false,
+			expectedRootValue: true,
+		},
+		{
+			name:              "false - overrides the launch mapping value",
+			optValue:          false,
+			mappingValue:      true,
+			expectedRootValue: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := testConfig()
+			config["ami_name"] = "name"
+			config["ami_virtualization_type"] = "hvm"
+			config["ami_root_device"] = map[string]interface{}{
+				"source_device_name": "/dev/xvdf",
+				"device_name":        "/dev/xvda",
+			}
+			config["launch_block_device_mappings"] = []map[string]interface{}{
+				{
+					"device_name":           "/dev/xvdf",
+					"delete_on_termination": tt.mappingValue,
+				},
+				{
+					"device_name": "/dev/xvdg",
+				},
+			}
+			if tt.optValue != nil {
+				config["launch_root_delete_on_termination"] = tt.optValue
+			}
+
+			b := &Builder{}
+			_, _, err := b.Prepare(config)
+			if err != nil {
+				t.Fatalf("should not have error: %s", err)
+			}
+
+			mappings := b.config.LaunchMappings.BuildEC2BlockDeviceMappings()
+			if len(mappings) != 2 {
+				t.Fatalf("expected 2 launch mappings, got %d", len(mappings))
+			}
+			if got := *mappings[0].Ebs.DeleteOnTermination; got != tt.expectedRootValue {
+				t.Errorf("root launch mapping DeleteOnTermination: expected %t, got %t", tt.expectedRootValue, got)
+			}
+			if got := *mappings[1].Ebs.DeleteOnTermination; got != tt.expectedExtraVolume {
+				t.Errorf("extra launch mapping DeleteOnTermination should be left untouched, got %t", got)
+			}
+		})
+	}
+}
+
+func TestRootBlockDevicePrepare_IOPS(t *testing.T) {
+	tests := []struct {
+		name        string
+		volumeType  string
+		iops        int64
+		expectError bool
+	}{
+		{name: "io1 with iops", volumeType: "io1", iops: 1000, expectError: false},
+		{name: "gp3 without iops", volumeType: "gp3", expectError: false},
+		{name: "standard without iops", volumeType: "standard", expectError: false},
+		{name: "standard with iops", volumeType: "standard", iops: 1000, expectError: true},
+		{name: "gp2 with iops", volumeType: "gp2", iops: 1000, expectError: true},
+		{name: "io2 with iops", volumeType: "io2", iops: 1000, expectError: false},
+		{name: "gp3 with iops", volumeType: "gp3", iops: 4000, expectError: false},
+		{name: "st1 with iops", volumeType: "st1", iops: 1000, expectError: true},
+		{name: "no volume type with iops", iops: 1000, expectError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			device := RootBlockDevice{
+				SourceDeviceName: "/dev/xvdf",
+				DeviceName:       "/dev/xvda",
+				VolumeType:       tt.volumeType,
+				IOPS:             tt.iops,
+			}
+			errs := device.Prepare(nil)
+			if len(errs) != 0 && !tt.expectError {
+				t.Fatalf("got unexpected errors: %v", errs)
+			}
+			if len(errs) == 0 && tt.expectError {
+				t.Fatalf("expected an error, got a success instead")
+			}
+		})
+	}
+}
+
+func TestRootBlockDevicePrepare_Throughput(t *testing.T) {
+	tests := []struct {
+		name        string
+		volumeType  string
+		throughput  int64
+		expectError bool
+	}{
+		{name: "gp3 with throughput", volumeType: "gp3", throughput: 250, expectError: false},
+		{name: "gp3 with minimum throughput", volumeType: "gp3", throughput: 125, expectError: false},
+		{name: "gp3 with maximum throughput", volumeType: "gp3", throughput: 1000, expectError: false},
+		{name: "gp3 with too low throughput", volumeType: "gp3", throughput: 100, expectError: true},
+		{name: "gp3 with too high throughput", volumeType: "gp3", throughput: 1001, expectError: true},
+		{name: "gp2 with throughput", volumeType: "gp2", throughput: 250, expectError: true},
+		{name: "io1 with throughput", volumeType: "io1", throughput: 250, expectError: true},
+		{name: "io1 without throughput", volumeType: "io1", expectError: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			device := RootBlockDevice{
+				SourceDeviceName: "/dev/xvdf",
+				DeviceName:       "/dev/xvda",
+				VolumeType:       tt.volumeType,
+				Throughput:       tt.throughput,
+			}
+			errs := device.Prepare(nil)
+			if len(errs) != 0 && !tt.expectError {
+				t.Fatalf("got unexpected errors: %v", errs)
+			}
+			if len(errs) == 0 && tt.expectError {
+				t.Fatalf("expected an error, got a success instead")
+			}
+		})
+	}
+}
+
+func TestBuilderPrepare_RootSnapshotId(t *testing.T) {
+	tests := []struct {
+		name               string
+		launchSnapshotId   string
+		launchVolumeSize   int64
+		rootVolumeSize     int64
+		expectError        bool
+		expectedVolumeSize int64
+	}{
+		{name: "snapshot size", expectedVolumeSize: 0},
+		{name: "root volume size", rootVolumeSize: 20, expectedVolumeSize: 20},
+		{name: "launch volume size", launchVolumeSize: 30, expectedVolumeSize: 30},
+		{name: "same launch volume size", launchVolumeSize: 30, rootVolumeSize: 30, expectedVolumeSize: 30},
+		{name: "other launch volume size", launchVolumeSize: 30, rootVolumeSize: 20, expectedVolumeSize: 30},
+		{name: "same launch snapshot", launchSnapshotId: "snap-12345678", expectedVolumeSize: 0},
+		{name: "other launch snapshot", launchSnapshotId: "snap-87654321", expectError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var b Builder
+			b.config.RootDevice = RootBlockDevice{
+				SourceDeviceName: "/dev/xvdf",
+				DeviceName:       "/dev/xvda",
+				VolumeSize:       tt.rootVolumeSize,
+				SnapshotId:       "snap-12345678",
+			}
+			b.config.LaunchMappings = BlockDevices{
+				BlockDevice{
+					BlockDevice: common.BlockDevice{
+						DeviceName: "/dev/xvdf",
+						SnapshotId: tt.launchSnapshotId,
+						VolumeSize: tt.launchVolumeSize,
+					},
+				},
+			}
+			b.config.AMIVirtType = "hvm"
+			config := testConfig()
+			config["ami_name"] = "name"
+
+			_, _, err := b.Prepare(config)
+			if tt.expectError {
+				if err == nil {
+					t.Fatal("expected an error, got a success instead")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("should not have error: %s", err)
+			}
+
+			launchDevice := b.config.LaunchMappings[0]
+			if launchDevice.SnapshotId != "snap-12345678" {
+				t.Errorf("the launch root volume should be restored from the snapshot, got %q", launchDevice.SnapshotId)
+			}
+			if launchDevice.VolumeSize != tt.expectedVolumeSize {
+				t.Errorf("expected a launch root volume of %d GiB, got %d", tt.expectedVolumeSize, launchDevice.VolumeSize)
+			}
+		})
+	}
+}
+
+func TestBuilderPrepare_UseCreateImage(t *testing.T) {
+	tests := []struct {
+		name           string
+		config         map[string]interface{}
+		rootVolumeType string
+		expectError    bool
+		expectWarning  string
+	}{
+		{
+			name:   "register with boot_mode",
+			config: map[string]interface{}{"boot_mode": "uefi"},
+		},
+		{
+			name:          "create with boot_mode",
+			config:        map[string]interface{}{"use_create_image": true, "boot_mode": "uefi"},
+			expectWarning: "use_create_image ignores boot_mode",
+		},
+		{
+			name:          "create with tpm_support",
+			config:        map[string]interface{}{"use_create_image": true, "tpm_support": "v2.0"},
+			expectWarning: "use_create_image ignores tpm_support",
+		},
+		{
+			name: "create with uefi_data",
+			config: map[string]interface{}{"use_create_image": true, "boot_mode": "uefi",
+				"uefi_data": "data"},
+			expectError: true,
+		},
+		{
+			name:           "root volume_type of the launch device",
+			config:         map[string]interface{}{"use_create_image": true},
+			rootVolumeType: "gp3",
+		},
+		{
+			name:           "other root volume_type",
+			config:         map[string]interface{}{"use_create_image": true},
+			rootVolumeType: "io2",
+			expectWarning:  "The volume_type of ami_root_device differ",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var b Builder
+			b.config.RootDevice = RootBlockDevice{
+				SourceDeviceName: "/dev/xvdf",
+				DeviceName:       "/dev/xvda",
+				VolumeType:       tt.rootVolumeType,
+			}
+			b.config.LaunchMappings = BlockDevices{
+				BlockDevice{
+					BlockDevice: common.BlockDevice{
+						DeviceName: "/dev/xvdf",
+						VolumeType: "gp3",
+					},
+				},
+			}
+			b.config.AMIVirtType = "hvm"
+			config := testConfig()
+			config["ami_name"] = "name"
+			for k, v := range tt.config {
+				config[k] = v
+			}
+
+			_, warns, err := b.Prepare(config)
+			if tt.expectError {
+				if err == nil {
+					t.Fatal("expected an error, got a success instead")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("should not have error: %s", err)
+			}
+
+			found := tt.expectWarning == ""
+			for _, warn := range warns {
+				if tt.expectWarning == "" {
+					if strings.Contains(warn, "ami_root_device") || strings.Contains(warn, "use_create_image") {
+						t.Errorf("got unexpected warning: %s", warn)
+					}
+				} else if strings.Contains(warn, tt.expectWarning) {
+					found = true
+				}
+			}
+			if !found {
+				t.Errorf("expected a warning containing %q, got %v", tt.expectWarning, warns)
+			}
+		})
+	}
+}
+
+func TestBuilderPrepare_RootVolumeSettings(t *testing.T) {
+	tests := []struct {
+		name               string
+		launchThroughput   *int64
+		expectedThroughput int64
+		expectWarning      bool
+	}{
+		{name: "unset on the launch device", expectedThroughput: 250},
+		{name: "same on the launch device", launchThroughput: aws.Int64(250), expectedThroughput: 250},
+		{name: "other on the launch device", launchThroughput: aws.Int64(500), expectedThroughput: 500,
+			expectWarning: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var b Builder
+			b.config.RootDevice = RootBlockDevice{
+				SourceDeviceName: "/dev/xvdf",
+				DeviceName:       "/dev/xvda",
+				VolumeType:       "gp3",
+				VolumeSize:       20,
+				IOPS:             4000,
+				Throughput:       250,
+			}
+			b.config.LaunchMappings = BlockDevices{
+				BlockDevice{
+					BlockDevice: common.BlockDevice{
+						DeviceName: "/dev/xvdf",
+						VolumeType: "gp3",
+						Throughput: tt.launchThroughput,
+					},
+				},
+			}
+			b.config.AMIVirtType = "hvm"
+			config := testConfig()
+			config["ami_name"] = "name"
+
+			_, warns, err := b.Prepare(config)
+			if err != nil {
+				t.Fatalf("should not have error: %s", err)
+			}
+
+			warned := false
+			for _, warn := range warns {
+				if strings.Contains(warn, "The throughput of ami_root_device differ") {
+					warned = true
+				}
+			}
+			if warned != tt.expectWarning {
+				t.Errorf("expected a throughput warning: %t, got %v", tt.expectWarning, warns)
+			}
+
+			// The root volume of the AMI is created from the launch device.
+			launchDevice := b.config.LaunchMappings[0]
+			if launchDevice.VolumeType != "gp3" || launchDevice.VolumeSize != 20 ||
+				aws.Int64Value(launchDevice.IOPS) != 4000 ||
+				aws.Int64Value(launchDevice.Throughput) != tt.expectedThroughput {
+				t.Errorf("unexpected launch device settings: %+v", launchDevice.BlockDevice)
+			}
+		})
+	}
+}
+
+func TestBuilderPrepare_RootEncryption(t *testing.T) {
+	kmsKeyId := "12345678-1234-1234-1234-123456789012"
+	tests := []struct {
+		name           string
+		rootEncrypted  config.Trilean
+		rootKmsKeyId   string
+		launchKmsKeyId string
+		amiKmsKeyId    string
+		encryptBoot    interface{}
+		expectError    bool
+	}{
+		{name: "encrypted", rootEncrypted: config.TriTrue},
+		{name: "encrypted with key", rootEncrypted: config.TriTrue, rootKmsKeyId: kmsKeyId},
+		{name: "same launch key", rootEncrypted: config.TriTrue, rootKmsKeyId: kmsKeyId, launchKmsKeyId: kmsKeyId},
+		{name: "other launch key", rootEncrypted: config.TriTrue, rootKmsKeyId: kmsKeyId,
+			launchKmsKeyId: "alias/other", expectError: true},
+		{name: "key without encrypted", rootKmsKeyId: kmsKeyId, expectError: true},
+		{name: "invalid key", rootEncrypted: config.TriTrue, rootKmsKeyId: "not a key", expectError: true},
+		{name: "encrypt_boot false", rootEncrypted: config.TriTrue, encryptBoot: false, expectError: true},
+		{name: "encrypt_boot", rootEncrypted: config.TriTrue, encryptBoot: true},
+		{name: "encrypt_boot key", rootEncrypted: config.TriTrue, encryptBoot: true, amiKmsKeyId: kmsKeyId},
+		{name: "same encrypt_boot key", rootEncrypted: config.TriTrue, rootKmsKeyId: kmsKeyId,
+			encryptBoot: true, amiKmsKeyId: kmsKeyId},
+		{name: "other encrypt_boot key", rootEncrypted: config.TriTrue, rootKmsKeyId: kmsKeyId,
+			encryptBoot: true, amiKmsKeyId: "alias/other", expectError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var b Builder
+			b.config.RootDevice = RootBlockDevice{
+				SourceDeviceName: "/dev/xvdf",
+				DeviceName:       "/dev/xvda",
+				Encrypted:        tt.rootEncrypted,
+				KmsKeyId:         tt.rootKmsKeyId,
+			}
+			b.config.LaunchMappings = BlockDevices{
+				BlockDevice{
+					BlockDevice: common.BlockDevice{
+						DeviceName: "/dev/xvdf",
+						KmsKeyId:   tt.launchKmsKeyId,
+					},
+				},
+			}
+			b.config.AMIVirtType = "hvm"
+			config := testConfig()
+			config["ami_name"] = "name"
+			if tt.encryptBoot != nil {
+				config["encrypt_boot"] = tt.encryptBoot
+			}
+			if tt.amiKmsKeyId != "" {
+				config["kms_key_id"] = tt.amiKmsKeyId
+			}
+
+			_, _, err := b.Prepare(config)
+			if tt.expectError {
+				if err == nil {
+					t.Fatal("expected an error, got a success instead")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("should not have error: %s", err)
+			}
+
+			launchDevice := b.config.LaunchMappings[0]
+			if !launchDevice.Encrypted.True() {
+				t.Error("the launch root volume should be encrypted when it is created")
+			}
+			expectedKmsKeyId := tt.rootKmsKeyId
+			if expectedKmsKeyId == "" {
+				expectedKmsKeyId = tt.amiKmsKeyId
+			}
+			if launchDevice.KmsKeyId != expectedKmsKeyId {
+				t.Errorf("expected the launch root volume to be encrypted with %q, got %q", expectedKmsKeyId, launchDevice.KmsKeyId)
+			}
+			if b.config.encryptBootCopy() {
+				t.Error("the AMI shouldn't be copied in the build region when its root volume is encrypted")
+			}
+		})
+	}
+}
+
+func TestBuilder_WaitForCloudInitBeforeStop(t *testing.T) {
+	var b Builder
+	config := testConfig()
+	config["ami_name"] = "name"
+	config["ami_virtualization_type"] = "hvm"
+	config["ami_root_device"] = map[string]interface{}{
+		"source_device_name": "/dev/xvdf",
+		"device_name":        "/dev/xvda",
+	}
+	config["launch_block_device_mappings"] = []map[string]interface{}{
+		{"device_name": "/dev/xvdf"},
+	}
+	config["wait_for_cloud_init"] = true
+	if _, _, err := b.Prepare(config); err != nil {
+		t.Fatalf("should not have error: %s", err)
+	}
+
+	// The steps only read the region of the session.
+	b.config.AccessConfig = *common.FakeAccessConfig()
+
+	sess := common.FakeSession()
+	steps := b.buildSteps(sess, ec2.New(sess), &packerbuilderdata.GeneratedData{})
+	wait, stop := -1, -1
+	for i, step := range steps {
+		switch step.(type) {
+		case *common.StepWaitForCloudInit:
+			wait = i
+		case *common.StepStopEBSBackedInstance:
+			stop = i
+		}
+	}
+	if wait < 0 || stop < 0 || wait > stop {
+		t.Fatalf("cloud-init should be waited for before the instance is stopped, got steps %d and %d", wait, stop)
+	}
+}
@@ -7,10 +7,25 @@ package ebssurrogate
 
 import (
 	"errors"
+	"fmt"
 
+	awscommon "github.com/hashicorp/packer-plugin-amazon/builder/common"
+	"github.com/hashicorp/packer-plugin-sdk/template/config"
 	"github.com/hashicorp/packer-plugin-sdk/template/interpolate"
 )
 
+const (
+	minThroughput = 125
+	maxThroughput = 1000
+)
+
+// iopsVolumeTypes are the volume types that support provisioned IOPS.
+var iopsVolumeTypes = map[string]bool{
+	"io1": true,
+	"io2": true,
+	"gp3": true,
+}
+
 type RootBlockDevice struct {
 	SourceDeviceName string `mapstructure:"source_device_name"`
 	// The device name exposed to the instance (for
@@ -21,13 +36,18 @@ type RootBlockDevice struct {
 	// deleted on instance termination. Default false. NOTE: If this
 	// value is not explicitly set to true and volumes are not cleaned up by
 	// an alternative method, additional volumes will accumulate after every
-	// build.
+	// build. This only applies to instances launched from the resulting AMI;
+	// use `launch_root_delete_on_termination` to control the root volume of
+	// the surrogate instance.
 	DeleteOnTermination bool `mapstructure:"delete_on_termination" required:"false"`
 	// The number of I/O operations per second (IOPS) that
 	// the volume supports. See the documentation on
 	// IOPs
-	// for more information
+	// for more information. Only supported for io1, io2 and gp3 volumes.
 	IOPS int64 `mapstructure:"iops" required:"false"`
+	// The throughput of the volume, in MiB/s. Only supported for gp3
+	// volumes, must be between 125 and 1000.
+	Throughput int64 `mapstructure:"throughput" required:"false"`
 	// The volume type. gp2 for General Purpose
 	// (SSD) volumes, io1 for Provisioned IOPS (SSD) volumes, st1 for
 	// Throughput Optimized HDD, sc1 for Cold HDD, and standard for
@@ -36,6 +56,23 @@ type RootBlockDevice struct {
 	// The size of the volume, in GiB. Required if
 	// not specifying a snapshot_id.
 	VolumeSize int64 `mapstructure:"volume_size" required:"false"`
+	// The ID of the snapshot to restore the root volume of the surrogate
+	// instance from, e.g. a known-good golden snapshot. It is set on the
+	// launch block device named `source_device_name`. The snapshot size is
+	// used if `volume_size` is not set, otherwise `volume_size` must be at
+	// least the snapshot size.
+	SnapshotId string `mapstructure:"snapshot_id" required:"false"`
+	// Whether to encrypt the root volume of the surrogate instance when it
+	// is created, and so the root snapshot of the AMI. It is set on the
+	// launch block device named `source_device_name`. With `encrypt_boot`,
+	// this saves the copy of the AMI in the build region it needs to
+	// encrypt it afterwards.
+	Encrypted config.Trilean `mapstructure:"encrypted" required:"false"`
+	// The ID, alias or ARN of the KMS key to encrypt the root volume with.
+	// Requires `encrypted` to be true. Defaults to the `kms_key_id` of
+	// `encrypt_boot` in the build region, otherwise to the AWS managed key
+	// of EBS. It can't be another key than that one.
+	KmsKeyId string `mapstructure:"kms_key_id" required:"false"`
 }
 
 func (c *RootBlockDevice) Prepare(ctx *interpolate.Context) []error {
@@ -49,18 +86,39 @@ func (c *RootBlockDevice) Prepare(ctx *interpolate.Context) []error {
 		errs = append(errs, errors.New("device_name for the root_device must be specified"))
 	}
 
-	if c.VolumeType == "gp2" && c.IOPS != 0 {
-		errs = append(errs, errors.New("iops may not be specified for a gp2 volume"))
+	if c.IOPS != 0 && !iopsVolumeTypes[c.VolumeType] {
+		errs = append(errs, fmt.Errorf("iops may not be specified for a %q volume, "+
+			"only io1, io2 and gp3 volumes support it", c.VolumeType))
 	}
 
 	if c.IOPS < 0 {
 		errs = append(errs, errors.New("iops must be greater than 0"))
 	}
 
+	if c.Throughput != 0 {
+		if c.VolumeType != "gp3" {
+			errs = append(errs, fmt.Errorf("throughput may not be specified for a %q volume, "+
+				"only gp3 volumes support it", c.VolumeType))
+		}
+		if c.Throughput < minThroughput || c.Throughput > maxThroughput {
+			errs = append(errs, fmt.Errorf("throughput must be between %d and %d",
+				minThroughput, maxThroughput))
+		}
+	}
+
 	if c.VolumeSize < 0 {
 		errs = append(errs, errors.New("volume_size must be greater than 0"))
 	}
 
+	if c.KmsKeyId != "" {
+		if !c.Encrypted.True() {
+			errs = append(errs, errors.New("the root_device must also have `encrypted: true` when setting a kms_key_id"))
+		}
+		if !awscommon.ValidateKmsKey(c.KmsKeyId) {
+			errs = append(errs, fmt.Errorf("%q is not a valid KMS Key Id.", c.KmsKeyId))
+		}
+	}
+
 	if len(errs) > 0 {
 		return errs
 	}
@@ -0,0 +1,61 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package ebssurrogate
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
+)
+
+// StepCheckRootSnapshot makes sure the snapshot the root volume is restored
+// from exists and fits in the root volume, before launching the surrogate
+// instance.
+type StepCheckRootSnapshot struct {
+	RootDevice RootBlockDevice
+}
+
+func (s *StepCheckRootSnapshot) Run(ctx context.Context, state multistep.StateBag) multistep.StepAction {
+	if s.RootDevice.SnapshotId == "" {
+		return multistep.ActionContinue
+	}
+
+	ec2conn := state.Get("ec2").(ec2iface.EC2API)
+	ui := state.Get("ui").(packersdk.Ui)
+
+	ui.Say(fmt.Sprintf("Checking root snapshot %s...", s.RootDevice.SnapshotId))
+	if err := s.checkSnapshot(ec2conn); err != nil {
+		state.Put("error", err)
+		ui.Error(err.Error())
+		return multistep.ActionHalt
+	}
+	return multistep.ActionContinue
+}
+
+func (s *StepCheckRootSnapshot) checkSnapshot(ec2conn ec2iface.EC2API) error {
+	resp, err := ec2conn.DescribeSnapshots(&ec2.DescribeSnapshotsInput{
+		SnapshotIds: []*string{aws.String(s.RootDevice.SnapshotId)},
+	})
+	if err != nil {
+		return fmt.Errorf("Error describing root snapshot %s: %s", s.RootDevice.SnapshotId, err)
+	}
+	if len(resp.Snapshots) == 0 {
+		return fmt.Errorf("Root snapshot %s not found", s.RootDevice.SnapshotId)
+	}
+
+	snapshotSize := aws.Int64Value(resp.Snapshots[0].VolumeSize)
+	if s.RootDevice.VolumeSize != 0 && s.RootDevice.VolumeSize < snapshotSize {
+		return fmt.Errorf("ami_root_device.volume_size (%d GiB) must be at least the size of snapshot %s (%d GiB)",
+			s.RootDevice.VolumeSize, s.RootDevice.SnapshotId, snapshotSize)
+	}
+	return nil
+}
+
+// Cleanup ...
+func (s *StepCheckRootSnapshot) Cleanup(multistep.StateBag) {}
@@ -0,0 +1,63 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package ebssurrogate
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+)
+
+type snapshotEC2Conn struct {
+	ec2iface.EC2API
+
+	snapshots map[string]int64
+}
+
+func (m *snapshotEC2Conn) DescribeSnapshots(input *ec2.DescribeSnapshotsInput) (*ec2.DescribeSnapshotsOutput, error) {
+	output := &ec2.DescribeSnapshotsOutput{}
+	for _, id := range input.SnapshotIds {
+		if size, ok := m.snapshots[aws.StringValue(id)]; ok {
+			output.Snapshots = append(output.Snapshots, &ec2.Snapshot{SnapshotId: id, VolumeSize: aws.Int64(size)})
+		}
+	}
+	return output, nil
+}
+
+func TestStepCheckRootSnapshot_checkSnapshot(t *testing.T) {
+	conn := &snapshotEC2Conn{snapshots: map[string]int64{"snap-12345678": 20}}
+
+	tests := []struct {
+		name        string
+		snapshotId  string
+		volumeSize  int64
+		expectError bool
+	}{
+		{name: "snapshot size", snapshotId: "snap-12345678"},
+		{name: "same size", snapshotId: "snap-12345678", volumeSize: 20},
+		{name: "larger volume", snapshotId: "snap-12345678", volumeSize: 50},
+		{name: "smaller volume", snapshotId: "snap-12345678", volumeSize: 10, expectError: true},
+		{name: "missing snapshot", snapshotId: "snap-87654321", expectError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			step := &StepCheckRootSnapshot{RootDevice: RootBlockDevice{
+				SourceDeviceName: "/dev/xvdf",
+				DeviceName:       "/dev/xvda",
+				SnapshotId:       tt.snapshotId,
+				VolumeSize:       tt.volumeSize,
+			}}
+			err := step.checkSnapshot(conn)
+			if err != nil && !tt.expectError {
+				t.Fatalf("got unexpected error: %s", err)
+			}
+			if err == nil && tt.expectError {
+				t.Fatal("expected an error, got a success instead")
+			}
+		})
+	}
+}
@@ -46,7 +46,7 @@ func (s *StepRegisterAMI) Run(ctx context.Context, state multistep.StateBag) mul
 	// Create the image
 	amiName := config.AMIName
 	state.Put("intermediary_image", false)
-	if config.AMIEncryptBootVolume.True() || s.AMISkipBuildRegion {
+	if config.encryptBootCopy() || s.AMISkipBuildRegion {
 		state.Put("intermediary_image", true)
 
 		// From AWS SDK docs: You can encrypt a copy of an unencrypted snapshot,
@@ -87,6 +87,9 @@ func (s *StepRegisterAMI) Run(ctx context.Context, state multistep.StateBag) mul
 	if s.TpmSupport != "" {
 		registerOpts.TpmSupport = aws.String(s.TpmSupport)
 	}
+	if len(config.AMIBillingProducts) > 0 {
+		registerOpts.BillingProducts = aws.StringSlice(config.AMIBillingProducts)
+	}
 	registerResp, err := ec2conn.RegisterImage(registerOpts)
 	if err != nil {
 		state.Put("error", fmt.Errorf("Error registering AMI: %s", err))
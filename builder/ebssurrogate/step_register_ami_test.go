@@ -4,13 +4,17 @@
 package ebssurrogate
 
 import (
+	"context"
 	"reflect"
 	"sort"
 	"testing"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/hashicorp/packer-plugin-amazon/builder/common"
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
 )
 
 const sourceDeviceName = "/dev/xvdf"
@@ -250,3 +254,84 @@ func TestStepRegisterAmi_combineDevices(t *testing.T) {
 		}
 	}
 }
+
+func TestStepRegisterAmi_BillingProducts(t *testing.T) {
+	var registerOpts *ec2.RegisterImageInput
+	conn := common.FakeEC2Conn(func(r *request.Request) {
+		switch out := r.Data.(type) {
+		case *ec2.RegisterImageOutput:
+			registerOpts = r.Params.(*ec2.RegisterImageInput)
+			out.ImageId = aws.String("ami-12345")
+		case *ec2.DescribeImagesOutput:
+			out.Images = []*ec2.Image{{ImageId: aws.String("ami-12345"), State: aws.String(ec2.ImageStateAvailable)}}
+		}
+	})
+
+	config := &Config{}
+	config.AMIBillingProducts = []string{"bp-6ba54002"}
+	state := new(multistep.BasicStateBag)
+	state.Put("config", config)
+	state.Put("ec2", conn)
+	state.Put("ui", packersdk.TestUi(t))
+	state.Put("snapshot_ids", map[string]string{})
+
+	step := newStepRegisterAMI(nil, nil)
+	if action := step.Run(context.Background(), state); action != multistep.ActionContinue {
+		t.Fatalf("should continue, got %v: %v", action, state.Get("error"))
+	}
+	if registerOpts == nil {
+		t.Fatalf("RegisterImage should have been called")
+	}
+	if !reflect.DeepEqual(aws.StringValueSlice(registerOpts.BillingProducts), []string{"bp-6ba54002"}) {
+		t.Fatalf("expected the billing products of the config, got %v", aws.StringValueSlice(registerOpts.BillingProducts))
+	}
+}
+
+func TestStepRegisterAmi_TpmSupport(t *testing.T) {
+	cases := []struct {
+		tpmSupport string
+		expected   *string
+	}{
+		{tpmSupport: "", expected: nil},
+		{tpmSupport: "v2.0", expected: aws.String("v2.0")},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.tpmSupport, func(t *testing.T) {
+			var registerOpts *ec2.RegisterImageInput
+			conn := common.FakeEC2Conn(func(r *request.Request) {
+				switch out := r.Data.(type) {
+				case *ec2.RegisterImageOutput:
+					registerOpts = r.Params.(*ec2.RegisterImageInput)
+					out.ImageId = aws.String("ami-12345")
+				case *ec2.DescribeImagesOutput:
+					out.Images = []*ec2.Image{
+						{
+							ImageId: aws.String("ami-12345"),
+							State:   aws.String(ec2.ImageStateAvailable),
+						},
+					}
+				}
+			})
+
+			state := new(multistep.BasicStateBag)
+			state.Put("config", &Config{})
+			state.Put("ec2", conn)
+			state.Put("ui", packersdk.TestUi(t))
+			state.Put("snapshot_ids", map[string]string{})
+
+			step := newStepRegisterAMI(nil, nil)
+			step.TpmSupport = tc.tpmSupport
+			if action := step.Run(context.Background(), state); action != multistep.ActionContinue {
+				t.Fatalf("should continue, got %v: %v", action, state.Get("error"))
+			}
+
+			if registerOpts == nil {
+				t.Fatalf("RegisterImage should have been called")
+			}
+			if !reflect.DeepEqual(registerOpts.TpmSupport, tc.expected) {
+				t.Fatalf("expected TpmSupport %v, got %v", aws.StringValue(tc.expected), aws.StringValue(registerOpts.TpmSupport))
+			}
+		})
+	}
+}
@@ -31,6 +31,7 @@ type StepSnapshotVolumes struct {
 	SnapshotOmitMap     map[string]bool
 	SnapshotTags        map[string]string
 	SnapshotDescription string
+	OutpostArn          string
 	Ctx                 interpolate.Context
 }
 
@@ -74,11 +75,15 @@ func (s *StepSnapshotVolumes) snapshotVolume(ctx context.Context, deviceName str
 	if description == "" {
 		description = fmt.Sprintf("Packer: %s", time.Now().String())
 	}
-	createSnapResp, err := ec2conn.CreateSnapshot(&ec2.CreateSnapshotInput{
+	createSnapshot := &ec2.CreateSnapshotInput{
 		VolumeId:          &volumeId,
 		Description:       aws.String(description),
 		TagSpecifications: tagSpecs,
-	})
+	}
+	if s.OutpostArn != "" {
+		createSnapshot.OutpostArn = aws.String(s.OutpostArn)
+	}
+	createSnapResp, err := ec2conn.CreateSnapshot(createSnapshot)
 	if err != nil {
 		return err
 	}
@@ -30,6 +30,59 @@ type BlockDevice struct {
 	// The description for the snapshot.
 	SnapshotDescription string `mapstructure:"snapshot_description" required:"false"`
 
+	// Copy the tags of the volume, as they are once the build completes, onto
+	// its snapshot. Tags set in `snapshot_tags` take precedence over volume
+	// tags with the same key. Requires `snapshot_volume` to be set. Defaults
+	// to `false`.
+	PropagateTagsToSnapshot bool `mapstructure:"propagate_tags_to_snapshot" required:"false"`
+
+	// Tag the snapshot with the `volume_type`, `iops` and `throughput` of the
+	// volume, as `VolumeType`, `VolumeIops` and `VolumeThroughput`. A snapshot
+	// doesn't keep the performance settings of its volume, so these tags let
+	// the volumes restored from it, for example gp3 volumes with provisioned
+	// throughput, be created with the same settings. Tags set in
+	// `snapshot_tags` take precedence. Requires `snapshot_volume` to be set.
+	// Defaults to `false`.
+	SnapshotPerformanceTags bool `mapstructure:"snapshot_performance_tags" required:"false"`
+
+	// The storage tier of the snapshot, either `standard` or `archive`. When
+	// set to `archive`, the snapshot is moved to the lower cost archive tier
+	// once it is created, which suits snapshots kept for long-term retention.
+	// An archived snapshot has to be restored before it can be used, which
+	// takes from 24 up to 72 hours. Requires `snapshot_volume` to be set.
+	// Defaults to `standard`.
+	SnapshotStorageTier string `mapstructure:"snapshot_storage_tier" required:"false"`
+
+	// Whether the snapshot is encrypted, independently from the volume. A
+	// snapshot is encrypted like its volume; when `snapshot_encrypted` is
+	// set and the snapshot isn't encrypted with the expected key, it is
+	// copied into a snapshot encrypted with `snapshot_kms_key_id`, and the
+	// original snapshot is deleted. The snapshot of an encrypted volume
+	// can't be decrypted, so this can't be set to `false` when `encrypted`
+	// or `kms_key_id` is set. Requires `snapshot_volume` to be set.
+	SnapshotEncrypted config.Trilean `mapstructure:"snapshot_encrypted" required:"false"`
+
+	// The ID, alias or ARN of the KMS key to encrypt the snapshot with,
+	// instead of the key of the volume. If left empty, the AWS managed key
+	// of EBS is used for the snapshots of unencrypted volumes. Requires
+	// `snapshot_encrypted` to be true.
+	SnapshotKmsKeyId string `mapstructure:"snapshot_kms_key_id" required:"false"`
+
+	// Account IDs to share the volume with. Volumes can't be shared, so the
+	// volume is snapshotted, as if `snapshot_volume` was set, and its snapshot
+	// is shared with these accounts, which can then create their own copy of
+	// the volume from it. Can be used alongside `snapshot_users`.
+	ShareViaSnapshotWith []string `mapstructure:"share_via_snapshot_with" required:"false"`
+
+	// Availability zones of the build region to create copies of the volume
+	// in, such as `us-east-1b`. The volume is snapshotted, as if
+	// `snapshot_volume` was set, and a volume with its type, IOPS,
+	// throughput, tags and KMS key is created from the snapshot in each of
+	// these zones, other than the one of the instance, where the volume
+	// already is. The volumes are part of the artifact along with the
+	// snapshot, and are deleted if the build fails.
+	AvailabilityZones []string `mapstructure:"availability_zones" required:"false"`
+
 	awscommon.SnapshotConfig `mapstructure:",squash"`
 }
 
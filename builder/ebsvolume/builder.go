@@ -12,7 +12,9 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"strings"
 
+	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/aws/aws-sdk-go/service/iam"
 	"github.com/hashicorp/hcl/v2/hcldec"
@@ -77,6 +79,11 @@ type Config struct {
 	// [`dynamic_block`](/packer/docs/templates/hcl_templates/expressions#dynamic-blocks)
 	// will allow you to create those programatically.
 	VolumeRunTag config.KeyValues `mapstructure:"run_volume_tag"`
+	// The ARN of the AWS Outpost the snapshots of `ebs_volumes` are stored on,
+	// such as `arn:aws:outposts:us-east-1:123456789012:outpost/op-1234567890abcdef0`.
+	// Only volumes on the Outpost can be snapshotted there, so the instance
+	// has to be launched in an Outpost subnet.
+	OutpostArn string `mapstructure:"outpost_arn" required:"false"`
 
 	launchBlockDevices BlockDevices
 
@@ -136,6 +143,12 @@ func (b *Builder) Prepare(raws ...interface{}) ([]string, []string, error) {
 	errs = packersdk.MultiErrorAppend(errs, b.config.launchBlockDevices.Prepare(&b.config.ctx)...)
 	errs = packersdk.MultiErrorAppend(errs, b.config.VolumeMappings.Prepare(&b.config.ctx)...)
 
+	if b.config.OutpostArn != "" {
+		if err := awscommon.IsValidOutpostArn(b.config.OutpostArn); err != nil {
+			errs = packersdk.MultiErrorAppend(errs, err)
+		}
+	}
+
 	b.config.launchBlockDevices = b.config.VolumeMappings
 	if err != nil {
 		errs = packersdk.MultiErrorAppend(errs, err)
@@ -155,6 +168,9 @@ func (b *Builder) Prepare(raws ...interface{}) ([]string, []string, error) {
 			"builds. Please take a look at our current documentation to "+
 			"understand how Packer requests Spot instances.")
 	}
+	if warn := b.config.RunConfig.EbsOptimizedWarning(); warn != "" {
+		warns = append(warns, warn)
+	}
 
 	if b.config.RunConfig.EnableT2Unlimited {
 		warns = append(warns, "enable_t2_unlimited is deprecated please use "+
@@ -162,11 +178,71 @@ func (b *Builder) Prepare(raws ...interface{}) ([]string, []string, error) {
 			"Packer, inclusion of enable_t2_unlimited will error your builds.")
 	}
 
+	for i := range b.config.VolumeMappings {
+		// Volumes can't be shared, they are shared through their snapshot.
+		if len(b.config.VolumeMappings[i].ShareViaSnapshotWith) > 0 {
+			b.config.VolumeMappings[i].SnapshotVolume = true
+		}
+		// Volumes can't be moved either, they are created again from their
+		// snapshot in the other zones.
+		if len(b.config.VolumeMappings[i].AvailabilityZones) > 0 {
+			b.config.VolumeMappings[i].SnapshotVolume = true
+		}
+	}
+
 	for _, configVolumeMapping := range b.config.VolumeMappings {
 		if configVolumeMapping.SnapshotDescription != "" && !configVolumeMapping.SnapshotVolume {
 			errs = packersdk.MultiErrorAppend(errs,
 				fmt.Errorf("All `ebs_volumes` blocks setting `snapshot_description` must also set `snapshot_volume`."))
 		}
+		if configVolumeMapping.PropagateTagsToSnapshot && !configVolumeMapping.SnapshotVolume {
+			errs = packersdk.MultiErrorAppend(errs,
+				fmt.Errorf("All `ebs_volumes` blocks setting `propagate_tags_to_snapshot` must also set `snapshot_volume`."))
+		}
+		if configVolumeMapping.SnapshotPerformanceTags && !configVolumeMapping.SnapshotVolume {
+			errs = packersdk.MultiErrorAppend(errs,
+				fmt.Errorf("All `ebs_volumes` blocks setting `snapshot_performance_tags` must also set `snapshot_volume`."))
+		}
+		for _, zone := range configVolumeMapping.AvailabilityZones {
+			if region := b.config.RawRegion; region != "" && !strings.HasPrefix(zone, region) {
+				errs = packersdk.MultiErrorAppend(errs,
+					fmt.Errorf("The availability zone %q of %s is not in the region %s",
+						zone, configVolumeMapping.DeviceName, region))
+			}
+		}
+		if (configVolumeMapping.SnapshotEncrypted != config.TriUnset || configVolumeMapping.SnapshotKmsKeyId != "") &&
+			!configVolumeMapping.SnapshotVolume {
+			errs = packersdk.MultiErrorAppend(errs,
+				fmt.Errorf("All `ebs_volumes` blocks setting `snapshot_encrypted` or `snapshot_kms_key_id` must also set `snapshot_volume`."))
+		}
+		if configVolumeMapping.SnapshotKmsKeyId != "" {
+			if !configVolumeMapping.SnapshotEncrypted.True() {
+				errs = packersdk.MultiErrorAppend(errs,
+					fmt.Errorf("The device %v, must also have `snapshot_encrypted: true` when setting a snapshot_kms_key_id.", configVolumeMapping.DeviceName))
+			}
+			if !awscommon.ValidateKmsKey(configVolumeMapping.SnapshotKmsKeyId) {
+				errs = packersdk.MultiErrorAppend(errs,
+					fmt.Errorf("%q is not a valid KMS Key Id.", configVolumeMapping.SnapshotKmsKeyId))
+			}
+		}
+		if configVolumeMapping.SnapshotEncrypted.False() &&
+			(configVolumeMapping.Encrypted.True() || configVolumeMapping.KmsKeyId != "") {
+			errs = packersdk.MultiErrorAppend(errs,
+				fmt.Errorf("The device %v, can't set `snapshot_encrypted: false`, the snapshot of an encrypted volume is always encrypted.", configVolumeMapping.DeviceName))
+		}
+		switch configVolumeMapping.SnapshotStorageTier {
+		case "", ec2.StorageTierStandard:
+		case ec2.StorageTierArchive:
+			if !configVolumeMapping.SnapshotVolume {
+				errs = packersdk.MultiErrorAppend(errs,
+					fmt.Errorf("All `ebs_volumes` blocks setting `snapshot_storage_tier` must also set `snapshot_volume`."))
+			}
+		default:
+			errs = packersdk.MultiErrorAppend(errs,
+				fmt.Errorf("Invalid snapshot_storage_tier %q for %s, valid values are %q and %q",
+					configVolumeMapping.SnapshotStorageTier, configVolumeMapping.DeviceName,
+					ec2.StorageTierStandard, ec2.StorageTierArchive))
+		}
 	}
 
 	if errs != nil && len(errs.Errors) > 0 {
@@ -193,11 +269,37 @@ func (b *Builder) Run(ctx context.Context, ui packersdk.Ui, hook packersdk.Hook)
 	state.Put("access_config", &b.config.AccessConfig)
 	state.Put("ec2", ec2conn)
 	state.Put("iam", iam)
+	state.Put("awsSession", session)
 	state.Put("hook", hook)
 	state.Put("ui", ui)
 	state.Put("region", ec2conn.Config.Region)
 	generatedData := &packerbuilderdata.GeneratedData{State: state}
 
+	steps := b.buildSteps(session, ec2conn, generatedData)
+
+	// Run!
+	b.runner = commonsteps.NewRunner(steps, b.config.PackerConfig, ui)
+	b.runner.Run(ctx, state)
+
+	// If there was an error, return that
+	if rawErr, ok := state.GetOk("error"); ok {
+		return nil, rawErr.(error)
+	}
+
+	// Build the artifact and return it
+	artifact := &Artifact{
+		Volumes:        state.Get("ebsvolumes").(EbsVolumes),
+		Snapshots:      state.Get("ebssnapshots").(EbsSnapshots),
+		BuilderIdValue: BuilderId,
+		Conn:           ec2conn,
+		StateData:      map[string]interface{}{"generated_data": state.Get("generated_data")},
+	}
+	ui.Say(fmt.Sprintf("Created Volumes: %s", artifact))
+	return artifact, nil
+}
+
+// buildSteps returns the steps of the build, in the order they run.
+func (b *Builder) buildSteps(session *session.Session, ec2conn *ec2.EC2, generatedData *packerbuilderdata.GeneratedData) []multistep.Step {
 	var instanceStep multistep.Step
 
 	if b.config.IsSpotInstance() {
@@ -205,6 +307,8 @@ func (b *Builder) Run(ctx context.Context, ui packersdk.Ui, hook packersdk.Hook)
 		instanceStep = &awscommon.StepRunSpotInstance{
 			PollingConfig:                     b.config.PollingConfig,
 			AssociatePublicIpAddress:          b.config.AssociatePublicIpAddress,
+			EnablePrimaryIpv6:                 b.config.EnablePrimaryIpv6,
+			PrivateDnsNameOptions:             b.config.PrivateDnsNameOptions,
 			LaunchMappings:                    b.config.launchBlockDevices,
 			BlockDurationMinutes:              b.config.BlockDurationMinutes,
 			Comm:                              &b.config.RunConfig.Comm,
@@ -226,11 +330,15 @@ func (b *Builder) Run(ctx context.Context, ui packersdk.Ui, hook packersdk.Hook)
 			SpotInstanceTypes:                 b.config.SpotInstanceTypes,
 			SpotAllocationStrategy:            b.config.SpotAllocationStrategy,
 			SpotPrice:                         b.config.SpotPrice,
+			SpotRequestTimeout:                b.config.SpotRequestTimeout,
+			LogSpotPrices:                     b.config.LogSpotPrices,
 			SpotTags:                          b.config.SpotTags,
-			Tags:                              b.config.RunTags,
+			Tags:                              b.config.EphemeralTags(b.config.RunTags),
 			UserData:                          b.config.UserData,
 			UserDataFile:                      b.config.UserDataFile,
-			VolumeTags:                        b.config.VolumeRunTags,
+			UserDataS3Url:                     b.config.UserDataS3Url,
+			UserDataSSMParameter:              b.config.UserDataSSMParameter,
+			VolumeTags:                        b.config.EphemeralTags(b.config.VolumeRunTags),
 		}
 	} else {
 		var tenancy string
@@ -246,6 +354,10 @@ func (b *Builder) Run(ctx context.Context, ui packersdk.Ui, hook packersdk.Hook)
 		instanceStep = &awscommon.StepRunSourceInstance{
 			PollingConfig:                     b.config.PollingConfig,
 			AssociatePublicIpAddress:          b.config.AssociatePublicIpAddress,
+			EnablePrimaryIpv6:                 b.config.EnablePrimaryIpv6,
+			PrivateDnsNameOptions:             b.config.PrivateDnsNameOptions,
+			EIPAllocationId:                   b.config.AssociateEIPAllocationId,
+			InsufficientCapacityRetries:       b.config.InsufficientCapacityRetries,
 			LaunchMappings:                    b.config.launchBlockDevices,
 			CapacityReservationPreference:     b.config.CapacityReservationPreference,
 			CapacityReservationId:             b.config.CapacityReservationId,
@@ -266,23 +378,47 @@ func (b *Builder) Run(ctx context.Context, ui packersdk.Ui, hook packersdk.Hook)
 			InstanceType:                      b.config.InstanceType,
 			IsRestricted:                      b.config.IsChinaCloud(),
 			SourceAMI:                         b.config.SourceAmi,
-			Tags:                              b.config.RunTags,
+			Tags:                              b.config.EphemeralTags(b.config.RunTags),
 			LicenseSpecifications:             b.config.LicenseSpecifications,
 			HostResourceGroupArn:              b.config.Placement.HostResourceGroupArn,
 			Tenancy:                           tenancy,
 			UserData:                          b.config.UserData,
 			UserDataFile:                      b.config.UserDataFile,
-			VolumeTags:                        b.config.VolumeRunTags,
+			UserDataS3Url:                     b.config.UserDataS3Url,
+			UserDataSSMParameter:              b.config.UserDataSSMParameter,
+			VolumeTags:                        b.config.EphemeralTags(b.config.VolumeRunTags),
+		}
+	}
+
+	var iamActions []string
+	if b.config.CheckIAMPermissions {
+		iamActions = b.config.RunConfig.IAMActions()
+		for _, volume := range b.config.VolumeMappings {
+			if volume.SnapshotVolume {
+				iamActions = append(iamActions, "ec2:CreateSnapshot")
+			}
+			if len(volume.AvailabilityZones) > 0 {
+				iamActions = append(iamActions, "ec2:CreateVolume", "ec2:DescribeAvailabilityZones")
+			}
+			if volume.SnapshotEncrypted.True() {
+				iamActions = append(iamActions, "ec2:CopySnapshot", "ec2:DeleteSnapshot")
+			}
 		}
 	}
 
 	// Build the steps
 	steps := []multistep.Step{
+		&awscommon.StepCheckIAMPermissions{
+			Actions: iamActions,
+			Region:  *ec2conn.Config.Region,
+		},
 		&awscommon.StepSourceAMIInfo{
 			SourceAmi:                b.config.SourceAmi,
 			EnableAMISriovNetSupport: b.config.AMISriovNetSupport,
 			EnableAMIENASupport:      b.config.AMIENASupport,
 			AmiFilters:               b.config.SourceAmiFilter,
+			AmiFilterGroups:          b.config.SourceAmiFilters,
+			AllowedOwners:            b.config.AllowedSourceAmiOwners,
 		},
 		&awscommon.StepNetworkInfo{
 			VpcId:                    b.config.VpcId,
@@ -300,7 +436,7 @@ func (b *Builder) Run(ctx context.Context, ui packersdk.Ui, hook packersdk.Hook)
 			Comm:         &b.config.RunConfig.Comm,
 			IsRestricted: b.config.IsChinaCloud(),
 			DebugKeyPath: fmt.Sprintf("ec2_%s.pem", b.config.PackerBuildName),
-			Tags:         b.config.RunTags,
+			Tags:         b.config.EphemeralTags(b.config.RunTags),
 			Ctx:          b.config.ctx,
 		},
 		&awscommon.StepSecurityGroup{
@@ -311,7 +447,7 @@ func (b *Builder) Run(ctx context.Context, ui packersdk.Ui, hook packersdk.Hook)
 			TemporarySGSourcePublicIp: b.config.TemporarySGSourcePublicIp,
 			SkipSSHRuleCreation:       b.config.SSMAgentEnabled(),
 			IsRestricted:              b.config.IsChinaCloud(),
-			Tags:                      b.config.RunTags,
+			Tags:                      b.config.EphemeralTags(b.config.RunTags),
 			Ctx:                       b.config.ctx,
 		},
 		&awscommon.StepIamInstanceProfile{
@@ -319,7 +455,7 @@ func (b *Builder) Run(ctx context.Context, ui packersdk.Ui, hook packersdk.Hook)
 			IamInstanceProfile:                        b.config.IamInstanceProfile,
 			SkipProfileValidation:                     b.config.SkipProfileValidation,
 			TemporaryIamInstanceProfilePolicyDocument: b.config.TemporaryIamInstanceProfilePolicyDocument,
-			Tags: b.config.RunTags,
+			Tags: b.config.EphemeralTags(b.config.RunTags),
 			Ctx:  b.config.ctx,
 		},
 		instanceStep,
@@ -337,7 +473,9 @@ func (b *Builder) Run(ctx context.Context, ui packersdk.Ui, hook packersdk.Hook)
 			AWSSession:       session,
 			Region:           *ec2conn.Config.Region,
 			PauseBeforeSSM:   b.config.PauseBeforeSSM,
+			PollingConfig:    b.config.PollingConfig,
 			LocalPortNumber:  b.config.SessionManagerPort,
+			LocalPortRange:   b.config.SessionManagerPortRange,
 			RemotePortNumber: b.config.Comm.Port(),
 			SSMAgentEnabled:  b.config.SSMAgentEnabled(),
 			SSHConfig:        &b.config.Comm.SSH,
@@ -359,6 +497,9 @@ func (b *Builder) Run(ctx context.Context, ui packersdk.Ui, hook packersdk.Hook)
 			GeneratedData: generatedData,
 		},
 		&commonsteps.StepProvision{},
+		&awscommon.StepWaitForCloudInit{
+			Skip: !b.config.WaitForCloudInit,
+		},
 		&commonsteps.StepCleanupTempKeys{
 			Comm: &b.config.RunConfig.Comm,
 		},
@@ -375,27 +516,10 @@ func (b *Builder) Run(ctx context.Context, ui packersdk.Ui, hook packersdk.Hook)
 			PollingConfig: b.config.PollingConfig,
 			VolumeMapping: b.config.VolumeMappings,
 			AccessConfig:  &b.config.AccessConfig,
+			OutpostArn:    b.config.OutpostArn,
 			Ctx:           b.config.ctx,
 		},
 	}
 
-	// Run!
-	b.runner = commonsteps.NewRunner(steps, b.config.PackerConfig, ui)
-	b.runner.Run(ctx, state)
-
-	// If there was an error, return that
-	if rawErr, ok := state.GetOk("error"); ok {
-		return nil, rawErr.(error)
-	}
-
-	// Build the artifact and return it
-	artifact := &Artifact{
-		Volumes:        state.Get("ebsvolumes").(EbsVolumes),
-		Snapshots:      state.Get("ebssnapshots").(EbsSnapshots),
-		BuilderIdValue: BuilderId,
-		Conn:           ec2conn,
-		StateData:      map[string]interface{}{"generated_data": state.Get("generated_data")},
-	}
-	ui.Say(fmt.Sprintf("Created Volumes: %s", artifact))
-	return artifact, nil
+	return steps
 }
@@ -12,25 +12,32 @@ import (
 // FlatBlockDevice is an auto-generated flat version of BlockDevice.
 // Where the contents of a field with a `mapstructure:,squash` tag are bubbled up.
 type FlatBlockDevice struct {
-	DeleteOnTermination *bool                 `mapstructure:"delete_on_termination" required:"false" cty:"delete_on_termination" hcl:"delete_on_termination"`
-	DeviceName          *string               `mapstructure:"device_name" required:"false" cty:"device_name" hcl:"device_name"`
-	Encrypted           *bool                 `mapstructure:"encrypted" required:"false" cty:"encrypted" hcl:"encrypted"`
-	IOPS                *int64                `mapstructure:"iops" required:"false" cty:"iops" hcl:"iops"`
-	NoDevice            *bool                 `mapstructure:"no_device" required:"false" cty:"no_device" hcl:"no_device"`
-	SnapshotId          *string               `mapstructure:"snapshot_id" required:"false" cty:"snapshot_id" hcl:"snapshot_id"`
-	Throughput          *int64                `mapstructure:"throughput" required:"false" cty:"throughput" hcl:"throughput"`
-	VirtualName         *string               `mapstructure:"virtual_name" required:"false" cty:"virtual_name" hcl:"virtual_name"`
-	VolumeType          *string               `mapstructure:"volume_type" required:"false" cty:"volume_type" hcl:"volume_type"`
-	VolumeSize          *int64                `mapstructure:"volume_size" required:"false" cty:"volume_size" hcl:"volume_size"`
-	KmsKeyId            *string               `mapstructure:"kms_key_id" required:"false" cty:"kms_key_id" hcl:"kms_key_id"`
-	Tags                map[string]string     `mapstructure:"tags" required:"false" cty:"tags" hcl:"tags"`
-	Tag                 []config.FlatKeyValue `mapstructure:"tag" required:"false" cty:"tag" hcl:"tag"`
-	SnapshotVolume      *bool                 `mapstructure:"snapshot_volume" required:"false" cty:"snapshot_volume" hcl:"snapshot_volume"`
-	SnapshotDescription *string               `mapstructure:"snapshot_description" required:"false" cty:"snapshot_description" hcl:"snapshot_description"`
-	SnapshotTags        map[string]string     `mapstructure:"snapshot_tags" required:"false" cty:"snapshot_tags" hcl:"snapshot_tags"`
-	SnapshotTag         []config.FlatKeyValue `mapstructure:"snapshot_tag" required:"false" cty:"snapshot_tag" hcl:"snapshot_tag"`
-	SnapshotUsers       []string              `mapstructure:"snapshot_users" required:"false" cty:"snapshot_users" hcl:"snapshot_users"`
-	SnapshotGroups      []string              `mapstructure:"snapshot_groups" required:"false" cty:"snapshot_groups" hcl:"snapshot_groups"`
+	DeleteOnTermination     *bool                 `mapstructure:"delete_on_termination" required:"false" cty:"delete_on_termination" hcl:"delete_on_termination"`
+	DeviceName              *string               `mapstructure:"device_name" required:"false" cty:"device_name" hcl:"device_name"`
+	Encrypted               *bool                 `mapstructure:"encrypted" required:"false" cty:"encrypted" hcl:"encrypted"`
+	IOPS                    *int64                `mapstructure:"iops" required:"false" cty:"iops" hcl:"iops"`
+	NoDevice                *bool                 `mapstructure:"no_device" required:"false" cty:"no_device" hcl:"no_device"`
+	SnapshotId              *string               `mapstructure:"snapshot_id" required:"false" cty:"snapshot_id" hcl:"snapshot_id"`
+	Throughput              *int64                `mapstructure:"throughput" required:"false" cty:"throughput" hcl:"throughput"`
+	VirtualName             *string               `mapstructure:"virtual_name" required:"false" cty:"virtual_name" hcl:"virtual_name"`
+	VolumeType              *string               `mapstructure:"volume_type" required:"false" cty:"volume_type" hcl:"volume_type"`
+	VolumeSize              *int64                `mapstructure:"volume_size" required:"false" cty:"volume_size" hcl:"volume_size"`
+	KmsKeyId                *string               `mapstructure:"kms_key_id" required:"false" cty:"kms_key_id" hcl:"kms_key_id"`
+	Tags                    map[string]string     `mapstructure:"tags" required:"false" cty:"tags" hcl:"tags"`
+	Tag                     []config.FlatKeyValue `mapstructure:"tag" required:"false" cty:"tag" hcl:"tag"`
+	SnapshotVolume          *bool                 `mapstructure:"snapshot_volume" required:"false" cty:"snapshot_volume" hcl:"snapshot_volume"`
+	SnapshotDescription     *string               `mapstructure:"snapshot_description" required:"false" cty:"snapshot_description" hcl:"snapshot_description"`
+	PropagateTagsToSnapshot *bool                 `mapstructure:"propagate_tags_to_snapshot" required:"false" cty:"propagate_tags_to_snapshot" hcl:"propagate_tags_to_snapshot"`
+	SnapshotPerformanceTags *bool                 `mapstructure:"snapshot_performance_tags" required:"false" cty:"snapshot_performance_tags" hcl:"snapshot_performance_tags"`
+	SnapshotStorageTier     *string               `mapstructure:"snapshot_storage_tier" required:"false" cty:"snapshot_storage_tier" hcl:"snapshot_storage_tier"`
+	SnapshotEncrypted       *bool                 `mapstructure:"snapshot_encrypted" required:"false" cty:"snapshot_encrypted" hcl:"snapshot_encrypted"`
+	SnapshotKmsKeyId        *string               `mapstructure:"snapshot_kms_key_id" required:"false" cty:"snapshot_kms_key_id" hcl:"snapshot_kms_key_id"`
+	ShareViaSnapshotWith    []string              `mapstructure:"share_via_snapshot_with" required:"false" cty:"share_via_snapshot_with" hcl:"share_via_snapshot_with"`
+	AvailabilityZones       []string              `mapstructure:"availability_zones" required:"false" cty:"availability_zones" hcl:"availability_zones"`
+	SnapshotTags            map[string]string     `mapstructure:"snapshot_tags" required:"false" cty:"snapshot_tags" hcl:"snapshot_tags"`
+	SnapshotTag             []config.FlatKeyValue `mapstructure:"snapshot_tag" required:"false" cty:"snapshot_tag" hcl:"snapshot_tag"`
+	SnapshotUsers           []string              `mapstructure:"snapshot_users" required:"false" cty:"snapshot_users" hcl:"snapshot_users"`
+	SnapshotGroups          []string              `mapstructure:"snapshot_groups" required:"false" cty:"snapshot_groups" hcl:"snapshot_groups"`
 }
 
 // FlatMapstructure returns a new FlatBlockDevice.
@@ -45,25 +52,32 @@ func (*BlockDevice) FlatMapstructure() interface{ HCL2Spec() map[string]hcldec.S
 // The decoded values from this spec will then be applied to a FlatBlockDevice.
 func (*FlatBlockDevice) HCL2Spec() map[string]hcldec.Spec {
 	s := map[string]hcldec.Spec{
-		"delete_on_termination": &hcldec.AttrSpec{Name: "delete_on_termination", Type: cty.Bool, Required: false},
-		"device_name":           &hcldec.AttrSpec{Name: "device_name", Type: cty.String, Required: false},
-		"encrypted":             &hcldec.AttrSpec{Name: "encrypted", Type: cty.Bool, Required: false},
-		"iops":                  &hcldec.AttrSpec{Name: "iops", Type: cty.Number, Required: false},
-		"no_device":             &hcldec.AttrSpec{Name: "no_device", Type: cty.Bool, Required: false},
-		"snapshot_id":           &hcldec.AttrSpec{Name: "snapshot_id", Type: cty.String, Required: false},
-		"throughput":            &hcldec.AttrSpec{Name: "throughput", Type: cty.Number, Required: false},
-		"virtual_name":          &hcldec.AttrSpec{Name: "virtual_name", Type: cty.String, Required: false},
-		"volume_type":           &hcldec.AttrSpec{Name: "volume_type", Type: cty.String, Required: false},
-		"volume_size":           &hcldec.AttrSpec{Name: "volume_size", Type: cty.Number, Required: false},
-		"kms_key_id":            &hcldec.AttrSpec{Name: "kms_key_id", Type: cty.String, Required: false},
-		"tags":                  &hcldec.AttrSpec{Name: "tags", Type: cty.Map(cty.String), Required: false},
-		"tag":                   &hcldec.BlockListSpec{TypeName: "tag", Nested: hcldec.ObjectSpec((*config.FlatKeyValue)(nil).HCL2Spec())},
-		"snapshot_volume":       &hcldec.AttrSpec{Name: "snapshot_volume", Type: cty.Bool, Required: false},
-		"snapshot_description":  &hcldec.AttrSpec{Name: "snapshot_description", Type: cty.String, Required: false},
-		"snapshot_tags":         &hcldec.AttrSpec{Name: "snapshot_tags", Type: cty.Map(cty.String), Required: false},
-		"snapshot_tag":          &hcldec.BlockListSpec{TypeName: "snapshot_tag", Nested: hcldec.ObjectSpec((*config.FlatKeyValue)(nil).HCL2Spec())},
-		"snapshot_users":        &hcldec.AttrSpec{Name: "snapshot_users", Type: cty.List(cty.String), Required: false},
-		"snapshot_groups":       &hcldec.AttrSpec{Name: "snapshot_groups", Type: cty.List(cty.String), Required: false},
+		"delete_on_termination":      &hcldec.AttrSpec{Name: "delete_on_termination", Type: cty.Bool, Required: false},
+		"device_name":                &hcldec.AttrSpec{Name: "device_name", Type: cty.String, Required: false},
+		"encrypted":                  &hcldec.AttrSpec{Name: "encrypted", Type: cty.Bool, Required: false},
+		"iops":                       &hcldec.AttrSpec{Name: "iops", Type: cty.Number, Required: false},
+		"no_device":                  &hcldec.AttrSpec{Name: "no_device", Type: cty.Bool, Required: false},
+		"snapshot_id":                &hcldec.AttrSpec{Name: "snapshot_id", Type: cty.String, Required: false},
+		"throughput":                 &hcldec.AttrSpec{Name: "throughput", Type: cty.Number, Required: false},
+		"virtual_name":               &hcldec.AttrSpec{Name: "virtual_name", Type: cty.String, Required: false},
+		"volume_type":                &hcldec.AttrSpec{Name: "volume_type", Type: cty.String, Required: false},
+		"volume_size":                &hcldec.AttrSpec{Name: "volume_size", Type: cty.Number, Required: false},
+		"kms_key_id":                 &hcldec.AttrSpec{Name: "kms_key_id", Type: cty.String, Required: false},
+		"tags":                       &hcldec.AttrSpec{Name: "tags", Type: cty.Map(cty.String), Required: false},
+		"tag":                        &hcldec.BlockListSpec{TypeName: "tag", Nested: hcldec.ObjectSpec((*config.FlatKeyValue)(nil).HCL2Spec())},
+		"snapshot_volume":            &hcldec.AttrSpec{Name: "snapshot_volume", Type: cty.Bool, Required: false},
+		"snapshot_description":       &hcldec.AttrSpec{Name: "snapshot_description", Type: cty.String, Required: false},
+		"propagate_tags_to_snapshot": &hcldec.AttrSpec{Name: "propagate_tags_to_snapshot", Type: cty.Bool, Required: false},
+		"snapshot_performance_tags":  &hcldec.AttrSpec{Name: "snapshot_performance_tags", Type: cty.Bool, Required: false},
+		"snapshot_storage_tier":      &hcldec.AttrSpec{Name: "snapshot_storage_tier", Type: cty.String, Required: false},
+		"snapshot_encrypted":         &hcldec.AttrSpec{Name: "snapshot_encrypted", Type: cty.Bool, Required: false},
+		"snapshot_kms_key_id":        &hcldec.AttrSpec{Name: "snapshot_kms_key_id", Type: cty.String, Required: false},
+		"share_via_snapshot_with":    &hcldec.AttrSpec{Name: "share_via_snapshot_with", Type: cty.List(cty.String), Required: false},
+		"availability_zones":         &hcldec.AttrSpec{Name: "availability_zones", Type: cty.List(cty.String), Required: false},
+		"snapshot_tags":              &hcldec.AttrSpec{Name: "snapshot_tags", Type: cty.Map(cty.String), Required: false},
+		"snapshot_tag":               &hcldec.BlockListSpec{TypeName: "snapshot_tag", Nested: hcldec.ObjectSpec((*config.FlatKeyValue)(nil).HCL2Spec())},
+		"snapshot_users":             &hcldec.AttrSpec{Name: "snapshot_users", Type: cty.List(cty.String), Required: false},
+		"snapshot_groups":            &hcldec.AttrSpec{Name: "snapshot_groups", Type: cty.List(cty.String), Required: false},
 	}
 	return s
 }
@@ -86,16 +100,20 @@ type FlatConfig struct {
 	DecodeAuthZMessages                       *bool                                  `mapstructure:"decode_authorization_messages" required:"false" cty:"decode_authorization_messages" hcl:"decode_authorization_messages"`
 	InsecureSkipTLSVerify                     *bool                                  `mapstructure:"insecure_skip_tls_verify" required:"false" cty:"insecure_skip_tls_verify" hcl:"insecure_skip_tls_verify"`
 	MaxRetries                                *int                                   `mapstructure:"max_retries" required:"false" cty:"max_retries" hcl:"max_retries"`
+	RetryMode                                 *string                                `mapstructure:"aws_retry_mode" required:"false" cty:"aws_retry_mode" hcl:"aws_retry_mode"`
 	MFACode                                   *string                                `mapstructure:"mfa_code" required:"false" cty:"mfa_code" hcl:"mfa_code"`
 	ProfileName                               *string                                `mapstructure:"profile" required:"false" cty:"profile" hcl:"profile"`
 	RawRegion                                 *string                                `mapstructure:"region" required:"true" cty:"region" hcl:"region"`
 	SecretKey                                 *string                                `mapstructure:"secret_key" required:"true" cty:"secret_key" hcl:"secret_key"`
-	SkipMetadataApiCheck                      *bool                                  `mapstructure:"skip_metadata_api_check" cty:"skip_metadata_api_check" hcl:"skip_metadata_api_check"`
+	SkipMetadataApiCheck                      *bool                                  `mapstructure:"skip_metadata_api_check" required:"false" cty:"skip_metadata_api_check" hcl:"skip_metadata_api_check"`
 	SkipCredsValidation                       *bool                                  `mapstructure:"skip_credential_validation" cty:"skip_credential_validation" hcl:"skip_credential_validation"`
+	UserAgentSuffix                           *string                                `mapstructure:"aws_user_agent_suffix" required:"false" cty:"aws_user_agent_suffix" hcl:"aws_user_agent_suffix"`
+	PerRequestTimeout                         *string                                `mapstructure:"per_request_timeout" required:"false" cty:"per_request_timeout" hcl:"per_request_timeout"`
 	Token                                     *string                                `mapstructure:"token" required:"false" cty:"token" hcl:"token"`
 	VaultAWSEngine                            *common.FlatVaultAWSEngineOptions      `mapstructure:"vault_aws_engine" required:"false" cty:"vault_aws_engine" hcl:"vault_aws_engine"`
 	PollingConfig                             *common.FlatAWSPollingConfig           `mapstructure:"aws_polling" required:"false" cty:"aws_polling" hcl:"aws_polling"`
 	AssociatePublicIpAddress                  *bool                                  `mapstructure:"associate_public_ip_address" required:"false" cty:"associate_public_ip_address" hcl:"associate_public_ip_address"`
+	AssociateEIPAllocationId                  *string                                `mapstructure:"associate_eip_allocation_id" required:"false" cty:"associate_eip_allocation_id" hcl:"associate_eip_allocation_id"`
 	AvailabilityZone                          *string                                `mapstructure:"availability_zone" required:"false" cty:"availability_zone" hcl:"availability_zone"`
 	BlockDurationMinutes                      *int64                                 `mapstructure:"block_duration_minutes" required:"false" cty:"block_duration_minutes" hcl:"block_duration_minutes"`
 	CapacityReservationPreference             *string                                `mapstructure:"capacity_reservation_preference" required:"false" cty:"capacity_reservation_preference" hcl:"capacity_reservation_preference"`
@@ -104,30 +122,40 @@ type FlatConfig struct {
 	DisableStopInstance                       *bool                                  `mapstructure:"disable_stop_instance" required:"false" cty:"disable_stop_instance" hcl:"disable_stop_instance"`
 	EbsOptimized                              *bool                                  `mapstructure:"ebs_optimized" required:"false" cty:"ebs_optimized" hcl:"ebs_optimized"`
 	EnableNitroEnclave                        *bool                                  `mapstructure:"enable_nitro_enclave" required:"false" cty:"enable_nitro_enclave" hcl:"enable_nitro_enclave"`
+	EnablePrimaryIpv6                         *bool                                  `mapstructure:"enable_primary_ipv6" required:"false" cty:"enable_primary_ipv6" hcl:"enable_primary_ipv6"`
+	PrivateDnsNameOptions                     *common.FlatPrivateDnsNameOptions      `mapstructure:"private_dns_name_options" required:"false" cty:"private_dns_name_options" hcl:"private_dns_name_options"`
 	EnableT2Unlimited                         *bool                                  `mapstructure:"enable_t2_unlimited" required:"false" cty:"enable_t2_unlimited" hcl:"enable_t2_unlimited"`
 	EnableUnlimitedCredits                    *bool                                  `mapstructure:"enable_unlimited_credits" required:"false" cty:"enable_unlimited_credits" hcl:"enable_unlimited_credits"`
+	CPUCredits                                *string                                `mapstructure:"cpu_credits" required:"false" cty:"cpu_credits" hcl:"cpu_credits"`
 	IamInstanceProfile                        *string                                `mapstructure:"iam_instance_profile" required:"false" cty:"iam_instance_profile" hcl:"iam_instance_profile"`
 	FleetTags                                 map[string]string                      `mapstructure:"fleet_tags" required:"false" cty:"fleet_tags" hcl:"fleet_tags"`
 	FleetTag                                  []config.FlatKeyValue                  `mapstructure:"fleet_tag" required:"false" cty:"fleet_tag" hcl:"fleet_tag"`
 	SkipProfileValidation                     *bool                                  `mapstructure:"skip_profile_validation" required:"false" cty:"skip_profile_validation" hcl:"skip_profile_validation"`
+	CheckIAMPermissions                       *bool                                  `mapstructure:"check_iam_permissions" required:"false" cty:"check_iam_permissions" hcl:"check_iam_permissions"`
 	TemporaryIamInstanceProfilePolicyDocument *common.FlatPolicyDocument             `mapstructure:"temporary_iam_instance_profile_policy_document" required:"false" cty:"temporary_iam_instance_profile_policy_document" hcl:"temporary_iam_instance_profile_policy_document"`
 	InstanceInitiatedShutdownBehavior         *string                                `mapstructure:"shutdown_behavior" required:"false" cty:"shutdown_behavior" hcl:"shutdown_behavior"`
 	InstanceType                              *string                                `mapstructure:"instance_type" required:"true" cty:"instance_type" hcl:"instance_type"`
 	SecurityGroupFilter                       *common.FlatSecurityGroupFilterOptions `mapstructure:"security_group_filter" required:"false" cty:"security_group_filter" hcl:"security_group_filter"`
 	RunTags                                   map[string]string                      `mapstructure:"run_tags" required:"false" cty:"run_tags" hcl:"run_tags"`
 	RunTag                                    []config.FlatKeyValue                  `mapstructure:"run_tag" required:"false" cty:"run_tag" hcl:"run_tag"`
+	TagEphemeralWithRunUUID                   *bool                                  `mapstructure:"tag_ephemeral_with_run_uuid" required:"false" cty:"tag_ephemeral_with_run_uuid" hcl:"tag_ephemeral_with_run_uuid"`
 	SecurityGroupId                           *string                                `mapstructure:"security_group_id" required:"false" cty:"security_group_id" hcl:"security_group_id"`
 	SecurityGroupIds                          []string                               `mapstructure:"security_group_ids" required:"false" cty:"security_group_ids" hcl:"security_group_ids"`
 	SourceAmi                                 *string                                `mapstructure:"source_ami" required:"true" cty:"source_ami" hcl:"source_ami"`
 	SourceAmiFilter                           *common.FlatAmiFilterOptions           `mapstructure:"source_ami_filter" required:"false" cty:"source_ami_filter" hcl:"source_ami_filter"`
+	SourceAmiFilters                          *common.FlatAmiFilterGroupOptions      `mapstructure:"source_ami_filters" required:"false" cty:"source_ami_filters" hcl:"source_ami_filters"`
+	AllowedSourceAmiOwners                    []string                               `mapstructure:"allowed_source_ami_owners" required:"false" cty:"allowed_source_ami_owners" hcl:"allowed_source_ami_owners"`
 	SpotAllocationStrategy                    *string                                `mapstructure:"spot_allocation_strategy" required:"false" cty:"spot_allocation_strategy" hcl:"spot_allocation_strategy"`
 	SpotInstanceTypes                         []string                               `mapstructure:"spot_instance_types" required:"false" cty:"spot_instance_types" hcl:"spot_instance_types"`
 	SpotPrice                                 *string                                `mapstructure:"spot_price" required:"false" cty:"spot_price" hcl:"spot_price"`
 	SpotPriceAutoProduct                      *string                                `mapstructure:"spot_price_auto_product" required:"false" undocumented:"true" cty:"spot_price_auto_product" hcl:"spot_price_auto_product"`
+	SpotRequestTimeout                        *string                                `mapstructure:"spot_request_timeout" required:"false" cty:"spot_request_timeout" hcl:"spot_request_timeout"`
+	LogSpotPrices                             *bool                                  `mapstructure:"log_spot_prices" required:"false" cty:"log_spot_prices" hcl:"log_spot_prices"`
 	SpotTags                                  map[string]string                      `mapstructure:"spot_tags" required:"false" cty:"spot_tags" hcl:"spot_tags"`
 	SpotTag                                   []config.FlatKeyValue                  `mapstructure:"spot_tag" required:"false" cty:"spot_tag" hcl:"spot_tag"`
 	SubnetFilter                              *common.FlatSubnetFilterOptions        `mapstructure:"subnet_filter" required:"false" cty:"subnet_filter" hcl:"subnet_filter"`
 	SubnetId                                  *string                                `mapstructure:"subnet_id" required:"false" cty:"subnet_id" hcl:"subnet_id"`
+	InsufficientCapacityRetries               *int                                   `mapstructure:"insufficient_capacity_retries" required:"false" cty:"insufficient_capacity_retries" hcl:"insufficient_capacity_retries"`
 	LicenseSpecifications                     []common.FlatLicenseSpecification      `mapstructure:"license_specifications" required:"false" cty:"license_specifications" hcl:"license_specifications"`
 	Placement                                 *common.FlatPlacement                  `mapstructure:"placement" required:"false" cty:"placement" hcl:"placement"`
 	Tenancy                                   *string                                `mapstructure:"tenancy" required:"false" cty:"tenancy" hcl:"tenancy"`
@@ -135,8 +163,11 @@ type FlatConfig struct {
 	TemporarySGSourcePublicIp                 *bool                                  `mapstructure:"temporary_security_group_source_public_ip" required:"false" cty:"temporary_security_group_source_public_ip" hcl:"temporary_security_group_source_public_ip"`
 	UserData                                  *string                                `mapstructure:"user_data" required:"false" cty:"user_data" hcl:"user_data"`
 	UserDataFile                              *string                                `mapstructure:"user_data_file" required:"false" cty:"user_data_file" hcl:"user_data_file"`
+	UserDataS3Url                             *string                                `mapstructure:"user_data_s3_url" required:"false" cty:"user_data_s3_url" hcl:"user_data_s3_url"`
+	UserDataSSMParameter                      *string                                `mapstructure:"user_data_ssm_parameter" required:"false" cty:"user_data_ssm_parameter" hcl:"user_data_ssm_parameter"`
 	VpcFilter                                 *common.FlatVpcFilterOptions           `mapstructure:"vpc_filter" required:"false" cty:"vpc_filter" hcl:"vpc_filter"`
 	VpcId                                     *string                                `mapstructure:"vpc_id" required:"false" cty:"vpc_id" hcl:"vpc_id"`
+	WaitForCloudInit                          *bool                                  `mapstructure:"wait_for_cloud_init" required:"false" cty:"wait_for_cloud_init" hcl:"wait_for_cloud_init"`
 	WindowsPasswordTimeout                    *string                                `mapstructure:"windows_password_timeout" required:"false" cty:"windows_password_timeout" hcl:"windows_password_timeout"`
 	Metadata                                  *common.FlatMetadataOptions            `mapstructure:"metadata_options" required:"false" cty:"metadata_options" hcl:"metadata_options"`
 	Type                                      *string                                `mapstructure:"communicator" cty:"communicator" hcl:"communicator"`
@@ -191,11 +222,13 @@ type FlatConfig struct {
 	SSHInterface                              *string                                `mapstructure:"ssh_interface" cty:"ssh_interface" hcl:"ssh_interface"`
 	PauseBeforeSSM                            *string                                `mapstructure:"pause_before_ssm" cty:"pause_before_ssm" hcl:"pause_before_ssm"`
 	SessionManagerPort                        *int                                   `mapstructure:"session_manager_port" cty:"session_manager_port" hcl:"session_manager_port"`
+	SessionManagerPortRange                   *string                                `mapstructure:"session_manager_port_range" cty:"session_manager_port_range" hcl:"session_manager_port_range"`
 	AMIENASupport                             *bool                                  `mapstructure:"ena_support" required:"false" cty:"ena_support" hcl:"ena_support"`
 	AMISriovNetSupport                        *bool                                  `mapstructure:"sriov_support" required:"false" cty:"sriov_support" hcl:"sriov_support"`
 	VolumeMappings                            []FlatBlockDevice                      `mapstructure:"ebs_volumes" required:"false" cty:"ebs_volumes" hcl:"ebs_volumes"`
 	VolumeRunTags                             map[string]string                      `mapstructure:"run_volume_tags" cty:"run_volume_tags" hcl:"run_volume_tags"`
 	VolumeRunTag                              []config.FlatKeyValue                  `mapstructure:"run_volume_tag" cty:"run_volume_tag" hcl:"run_volume_tag"`
+	OutpostArn                                *string                                `mapstructure:"outpost_arn" required:"false" cty:"outpost_arn" hcl:"outpost_arn"`
 }
 
 // FlatMapstructure returns a new FlatConfig.
@@ -225,16 +258,20 @@ func (*FlatConfig) HCL2Spec() map[string]hcldec.Spec {
 		"decode_authorization_messages":   &hcldec.AttrSpec{Name: "decode_authorization_messages", Type: cty.Bool, Required: false},
 		"insecure_skip_tls_verify":        &hcldec.AttrSpec{Name: "insecure_skip_tls_verify", Type: cty.Bool, Required: false},
 		"max_retries":                     &hcldec.AttrSpec{Name: "max_retries", Type: cty.Number, Required: false},
+		"aws_retry_mode":                  &hcldec.AttrSpec{Name: "aws_retry_mode", Type: cty.String, Required: false},
 		"mfa_code":                        &hcldec.AttrSpec{Name: "mfa_code", Type: cty.String, Required: false},
 		"profile":                         &hcldec.AttrSpec{Name: "profile", Type: cty.String, Required: false},
 		"region":                          &hcldec.AttrSpec{Name: "region", Type: cty.String, Required: false},
 		"secret_key":                      &hcldec.AttrSpec{Name: "secret_key", Type: cty.String, Required: false},
 		"skip_metadata_api_check":         &hcldec.AttrSpec{Name: "skip_metadata_api_check", Type: cty.Bool, Required: false},
 		"skip_credential_validation":      &hcldec.AttrSpec{Name: "skip_credential_validation", Type: cty.Bool, Required: false},
+		"aws_user_agent_suffix":           &hcldec.AttrSpec{Name: "aws_user_agent_suffix", Type: cty.String, Required: false},
+		"per_request_timeout":             &hcldec.AttrSpec{Name: "per_request_timeout", Type: cty.String, Required: false},
 		"token":                           &hcldec.AttrSpec{Name: "token", Type: cty.String, Required: false},
 		"vault_aws_engine":                &hcldec.BlockSpec{TypeName: "vault_aws_engine", Nested: hcldec.ObjectSpec((*common.FlatVaultAWSEngineOptions)(nil).HCL2Spec())},
 		"aws_polling":                     &hcldec.BlockSpec{TypeName: "aws_polling", Nested: hcldec.ObjectSpec((*common.FlatAWSPollingConfig)(nil).HCL2Spec())},
 		"associate_public_ip_address":     &hcldec.AttrSpec{Name: "associate_public_ip_address", Type: cty.Bool, Required: false},
+		"associate_eip_allocation_id":     &hcldec.AttrSpec{Name: "associate_eip_allocation_id", Type: cty.String, Required: false},
 		"availability_zone":               &hcldec.AttrSpec{Name: "availability_zone", Type: cty.String, Required: false},
 		"block_duration_minutes":          &hcldec.AttrSpec{Name: "block_duration_minutes", Type: cty.Number, Required: false},
 		"capacity_reservation_preference": &hcldec.AttrSpec{Name: "capacity_reservation_preference", Type: cty.String, Required: false},
@@ -243,30 +280,40 @@ func (*FlatConfig) HCL2Spec() map[string]hcldec.Spec {
 		"disable_stop_instance":           &hcldec.AttrSpec{Name: "disable_stop_instance", Type: cty.Bool, Required: false},
 		"ebs_optimized":                   &hcldec.AttrSpec{Name: "ebs_optimized", Type: cty.Bool, Required: false},
 		"enable_nitro_enclave":            &hcldec.AttrSpec{Name: "enable_nitro_enclave", Type: cty.Bool, Required: false},
+		"enable_primary_ipv6":             &hcldec.AttrSpec{Name: "enable_primary_ipv6", Type: cty.Bool, Required: false},
+		"private_dns_name_options":        &hcldec.BlockSpec{TypeName: "private_dns_name_options", Nested: hcldec.ObjectSpec((*common.FlatPrivateDnsNameOptions)(nil).HCL2Spec())},
 		"enable_t2_unlimited":             &hcldec.AttrSpec{Name: "enable_t2_unlimited", Type: cty.Bool, Required: false},
 		"enable_unlimited_credits":        &hcldec.AttrSpec{Name: "enable_unlimited_credits", Type: cty.Bool, Required: false},
+		"cpu_credits":                     &hcldec.AttrSpec{Name: "cpu_credits", Type: cty.String, Required: false},
 		"iam_instance_profile":            &hcldec.AttrSpec{Name: "iam_instance_profile", Type: cty.String, Required: false},
 		"fleet_tags":                      &hcldec.AttrSpec{Name: "fleet_tags", Type: cty.Map(cty.String), Required: false},
 		"fleet_tag":                       &hcldec.BlockListSpec{TypeName: "fleet_tag", Nested: hcldec.ObjectSpec((*config.FlatKeyValue)(nil).HCL2Spec())},
 		"skip_profile_validation":         &hcldec.AttrSpec{Name: "skip_profile_validation", Type: cty.Bool, Required: false},
+		"check_iam_permissions":           &hcldec.AttrSpec{Name: "check_iam_permissions", Type: cty.Bool, Required: false},
 		"temporary_iam_instance_profile_policy_document": &hcldec.BlockSpec{TypeName: "temporary_iam_instance_profile_policy_document", Nested: hcldec.ObjectSpec((*common.FlatPolicyDocument)(nil).HCL2Spec())},
 		"shutdown_behavior":                     &hcldec.AttrSpec{Name: "shutdown_behavior", Type: cty.String, Required: false},
 		"instance_type":                         &hcldec.AttrSpec{Name: "instance_type", Type: cty.String, Required: false},
 		"security_group_filter":                 &hcldec.BlockSpec{TypeName: "security_group_filter", Nested: hcldec.ObjectSpec((*common.FlatSecurityGroupFilterOptions)(nil).HCL2Spec())},
 		"run_tags":                              &hcldec.AttrSpec{Name: "run_tags", Type: cty.Map(cty.String), Required: false},
 		"run_tag":                               &hcldec.BlockListSpec{TypeName: "run_tag", Nested: hcldec.ObjectSpec((*config.FlatKeyValue)(nil).HCL2Spec())},
+		"tag_ephemeral_with_run_uuid":           &hcldec.AttrSpec{Name: "tag_ephemeral_with_run_uuid", Type: cty.Bool, Required: false},
 		"security_group_id":                     &hcldec.AttrSpec{Name: "security_group_id", Type: cty.String, Required: false},
 		"security_group_ids":                    &hcldec.AttrSpec{Name: "security_group_ids", Type: cty.List(cty.String), Required: false},
 		"source_ami":                            &hcldec.AttrSpec{Name: "source_ami", Type: cty.String, Required: false},
 		"source_ami_filter":                     &hcldec.BlockSpec{TypeName: "source_ami_filter", Nested: hcldec.ObjectSpec((*common.FlatAmiFilterOptions)(nil).HCL2Spec())},
+		"source_ami_filters":                    &hcldec.BlockSpec{TypeName: "source_ami_filters", Nested: hcldec.ObjectSpec((*common.FlatAmiFilterGroupOptions)(nil).HCL2Spec())},
+		"allowed_source_ami_owners":             &hcldec.AttrSpec{Name: "allowed_source_ami_owners", Type: cty.List(cty.String), Required: false},
 		"spot_allocation_strategy":              &hcldec.AttrSpec{Name: "spot_allocation_strategy", Type: cty.String, Required: false},
 		"spot_instance_types":                   &hcldec.AttrSpec{Name: "spot_instance_types", Type: cty.List(cty.String), Required: false},
 		"spot_price":                            &hcldec.AttrSpec{Name: "spot_price", Type: cty.String, Required: false},
 		"spot_price_auto_product":               &hcldec.AttrSpec{Name: "spot_price_auto_product", Type: cty.String, Required: false},
+		"spot_request_timeout":                  &hcldec.AttrSpec{Name: "spot_request_timeout", Type: cty.String, Required: false},
+		"log_spot_prices":                       &hcldec.AttrSpec{Name: "log_spot_prices", Type: cty.Bool, Required: false},
 		"spot_tags":                             &hcldec.AttrSpec{Name: "spot_tags", Type: cty.Map(cty.String), Required: false},
 		"spot_tag":                              &hcldec.BlockListSpec{TypeName: "spot_tag", Nested: hcldec.ObjectSpec((*config.FlatKeyValue)(nil).HCL2Spec())},
 		"subnet_filter":                         &hcldec.BlockSpec{TypeName: "subnet_filter", Nested: hcldec.ObjectSpec((*common.FlatSubnetFilterOptions)(nil).HCL2Spec())},
 		"subnet_id":                             &hcldec.AttrSpec{Name: "subnet_id", Type: cty.String, Required: false},
+		"insufficient_capacity_retries":         &hcldec.AttrSpec{Name: "insufficient_capacity_retries", Type: cty.Number, Required: false},
 		"license_specifications":                &hcldec.BlockListSpec{TypeName: "license_specifications", Nested: hcldec.ObjectSpec((*common.FlatLicenseSpecification)(nil).HCL2Spec())},
 		"placement":                             &hcldec.BlockSpec{TypeName: "placement", Nested: hcldec.ObjectSpec((*common.FlatPlacement)(nil).HCL2Spec())},
 		"tenancy":                               &hcldec.AttrSpec{Name: "tenancy", Type: cty.String, Required: false},
@@ -274,8 +321,11 @@ func (*FlatConfig) HCL2Spec() map[string]hcldec.Spec {
 		"temporary_security_group_source_public_ip": &hcldec.AttrSpec{Name: "temporary_security_group_source_public_ip", Type: cty.Bool, Required: false},
 		"user_data":                    &hcldec.AttrSpec{Name: "user_data", Type: cty.String, Required: false},
 		"user_data_file":               &hcldec.AttrSpec{Name: "user_data_file", Type: cty.String, Required: false},
+		"user_data_s3_url":             &hcldec.AttrSpec{Name: "user_data_s3_url", Type: cty.String, Required: false},
+		"user_data_ssm_parameter":      &hcldec.AttrSpec{Name: "user_data_ssm_parameter", Type: cty.String, Required: false},
 		"vpc_filter":                   &hcldec.BlockSpec{TypeName: "vpc_filter", Nested: hcldec.ObjectSpec((*common.FlatVpcFilterOptions)(nil).HCL2Spec())},
 		"vpc_id":                       &hcldec.AttrSpec{Name: "vpc_id", Type: cty.String, Required: false},
+		"wait_for_cloud_init":          &hcldec.AttrSpec{Name: "wait_for_cloud_init", Type: cty.Bool, Required: false},
 		"windows_password_timeout":     &hcldec.AttrSpec{Name: "windows_password_timeout", Type: cty.String, Required: false},
 		"metadata_options":             &hcldec.BlockSpec{TypeName: "metadata_options", Nested: hcldec.ObjectSpec((*common.FlatMetadataOptions)(nil).HCL2Spec())},
 		"communicator":                 &hcldec.AttrSpec{Name: "communicator", Type: cty.String, Required: false},
@@ -330,11 +380,13 @@ func (*FlatConfig) HCL2Spec() map[string]hcldec.Spec {
 		"ssh_interface":                &hcldec.AttrSpec{Name: "ssh_interface", Type: cty.String, Required: false},
 		"pause_before_ssm":             &hcldec.AttrSpec{Name: "pause_before_ssm", Type: cty.String, Required: false},
 		"session_manager_port":         &hcldec.AttrSpec{Name: "session_manager_port", Type: cty.Number, Required: false},
+		"session_manager_port_range":   &hcldec.AttrSpec{Name: "session_manager_port_range", Type: cty.String, Required: false},
 		"ena_support":                  &hcldec.AttrSpec{Name: "ena_support", Type: cty.Bool, Required: false},
 		"sriov_support":                &hcldec.AttrSpec{Name: "sriov_support", Type: cty.Bool, Required: false},
 		"ebs_volumes":                  &hcldec.BlockListSpec{TypeName: "ebs_volumes", Nested: hcldec.ObjectSpec((*FlatBlockDevice)(nil).HCL2Spec())},
 		"run_volume_tags":              &hcldec.AttrSpec{Name: "run_volume_tags", Type: cty.Map(cty.String), Required: false},
 		"run_volume_tag":               &hcldec.BlockListSpec{TypeName: "run_volume_tag", Nested: hcldec.ObjectSpec((*config.FlatKeyValue)(nil).HCL2Spec())},
+		"outpost_arn":                  &hcldec.AttrSpec{Name: "outpost_arn", Type: cty.String, Required: false},
 	}
 	return s
 }
@@ -6,7 +6,10 @@ package ebsvolume
 import (
 	"testing"
 
+	"github.com/aws/aws-sdk-go/service/ec2"
+	awscommon "github.com/hashicorp/packer-plugin-amazon/builder/common"
 	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
+	"github.com/hashicorp/packer-plugin-sdk/packerbuilderdata"
 )
 
 func testConfig() map[string]interface{} {
@@ -167,3 +170,30 @@ func TestBuidler_ConfigBlockdevicemapping(t *testing.T) {
 	t.Logf("Test gen %+v", b.config.VolumeMappings)
 
 }
+
+func TestBuilder_WaitForCloudInitBeforeStop(t *testing.T) {
+	var b Builder
+	config := testConfig()
+	config["wait_for_cloud_init"] = true
+	if _, _, err := b.Prepare(config); err != nil {
+		t.Fatalf("should not have error: %s", err)
+	}
+
+	// The steps only read the region of the session.
+	b.config.AccessConfig = *awscommon.FakeAccessConfig()
+
+	sess := awscommon.FakeSession()
+	steps := b.buildSteps(sess, ec2.New(sess), &packerbuilderdata.GeneratedData{})
+	wait, stop := -1, -1
+	for i, step := range steps {
+		switch step.(type) {
+		case *awscommon.StepWaitForCloudInit:
+			wait = i
+		case *awscommon.StepStopEBSBackedInstance:
+			stop = i
+		}
+	}
+	if wait < 0 || stop < 0 || wait > stop {
+		t.Fatalf("cloud-init should be waited for before the instance is stopped, got steps %d and %d", wait, stop)
+	}
+}
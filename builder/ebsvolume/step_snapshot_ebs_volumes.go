@@ -6,6 +6,8 @@ package ebsvolume
 import (
 	"context"
 	"fmt"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
@@ -21,9 +23,14 @@ type stepSnapshotEBSVolumes struct {
 	PollingConfig *awscommon.AWSPollingConfig
 	AccessConfig  *awscommon.AccessConfig
 	VolumeMapping []BlockDevice
+	OutpostArn    string
 	//Map of SnapshotID: BlockDevice, Where *BlockDevice is in VolumeMapping
 	snapshotMap map[string]*BlockDevice
 	Ctx         interpolate.Context
+
+	// The volumes created in the availability_zones, deleted when the build
+	// fails.
+	zoneVolumes []string
 }
 
 func (s *stepSnapshotEBSVolumes) Run(ctx context.Context, state multistep.StateBag) multistep.StepAction {
@@ -32,6 +39,15 @@ func (s *stepSnapshotEBSVolumes) Run(ctx context.Context, state multistep.StateB
 	ui := state.Get("ui").(packer.Ui)
 
 	s.snapshotMap = make(map[string]*BlockDevice)
+	snapshots := make(map[string]*ec2.Snapshot)
+	snapshotInputs := make(map[string]*ec2.CreateSnapshotInput)
+
+	if err := checkAvailabilityZones(ec2conn, s.VolumeMapping); err != nil {
+		err := fmt.Errorf("Error checking the availability zones of ebs_volumes: %s", err)
+		state.Put("error", err)
+		ui.Error(err.Error())
+		return multistep.ActionHalt
+	}
 
 	for _, instanceBlockDevice := range instance.BlockDeviceMappings {
 		for _, configVolumeMapping := range s.VolumeMapping {
@@ -50,6 +66,19 @@ func (s *stepSnapshotEBSVolumes) Run(ctx context.Context, state multistep.StateB
 					ui.Error(err.Error())
 					return multistep.ActionHalt
 				}
+				if configVolumeMapping.PropagateTagsToSnapshot {
+					volumeTags, err := describeVolumeTags(ec2conn, *instanceBlockDevice.Ebs.VolumeId)
+					if err != nil {
+						err := fmt.Errorf("Error reading tags of volume %s: %s", *instanceBlockDevice.Ebs.VolumeId, err)
+						state.Put("error", err)
+						ui.Error(err.Error())
+						return multistep.ActionHalt
+					}
+					tags = mergeSnapshotTags(volumeTags, tags)
+				}
+				if configVolumeMapping.SnapshotPerformanceTags {
+					tags = mergeSnapshotTags(performanceTags(configVolumeMapping), tags)
+				}
 				tags.Report(ui)
 
 				tagSpec := &ec2.TagSpecification{
@@ -66,6 +95,10 @@ func (s *stepSnapshotEBSVolumes) Run(ctx context.Context, state multistep.StateB
 					Description:       aws.String(description),
 				}
 
+				if s.OutpostArn != "" {
+					input.OutpostArn = aws.String(s.OutpostArn)
+				}
+
 				//Dont try to set an empty tag spec
 				if len(tags) == 0 {
 					input.TagSpecifications = nil
@@ -81,6 +114,8 @@ func (s *stepSnapshotEBSVolumes) Run(ctx context.Context, state multistep.StateB
 				}
 				ui.Message(fmt.Sprintf("Requested Snapshot of Volume %s: %s", *instanceBlockDevice.Ebs.VolumeId, *snapshot.SnapshotId))
 				s.snapshotMap[*snapshot.SnapshotId] = &configVolumeMapping
+				snapshots[*snapshot.SnapshotId] = snapshot
+				snapshotInputs[*snapshot.SnapshotId] = input
 			}
 		}
 	}
@@ -99,6 +134,60 @@ func (s *stepSnapshotEBSVolumes) Run(ctx context.Context, state multistep.StateB
 		ui.Message(fmt.Sprintf("Snapshot Ready: %s", snapID))
 	}
 
+	var reencrypt []string
+	for snapID, bd := range s.snapshotMap {
+		if needsReencryption(snapshots[snapID], bd) {
+			reencrypt = append(reencrypt, snapID)
+		}
+	}
+	for _, snapID := range reencrypt {
+		bd := s.snapshotMap[snapID]
+		ui.Message(fmt.Sprintf("Re-encrypting snapshot %s of %s...", snapID, bd.DeviceName))
+		copyID, err := s.reencryptSnapshot(ctx, ec2conn, snapID, snapshotInputs[snapID], bd)
+		if err != nil {
+			err := fmt.Errorf("Error re-encrypting snapshot %s: %s", snapID, err)
+			state.Put("error", err)
+			ui.Error(err.Error())
+			return multistep.ActionHalt
+		}
+		ui.Message(fmt.Sprintf("Replaced snapshot %s with its encrypted copy %s", snapID, copyID))
+		delete(s.snapshotMap, snapID)
+		s.snapshotMap[copyID] = bd
+	}
+
+	// The volumes are created before the snapshots are archived, which
+	// makes them unusable.
+	var instanceZone string
+	if instance.Placement != nil {
+		instanceZone = aws.StringValue(instance.Placement.AvailabilityZone)
+	}
+	var zoneVolumes []string
+	for snapID, bd := range s.snapshotMap {
+		for _, zone := range bd.AvailabilityZones {
+			if zone == instanceZone {
+				continue
+			}
+			volumeID, err := s.createZoneVolume(ctx, ec2conn, state, snapID, zone, bd)
+			if err != nil {
+				err := fmt.Errorf("Error creating volume %s in %s: %s", bd.DeviceName, zone, err)
+				state.Put("error", err)
+				ui.Error(err.Error())
+				return multistep.ActionHalt
+			}
+			ui.Message(fmt.Sprintf("Created volume %s in %s from snapshot %s", volumeID, zone, snapID))
+			zoneVolumes = append(zoneVolumes, volumeID)
+		}
+	}
+	if len(zoneVolumes) > 0 {
+		volumes, _ := state.Get("ebsvolumes").(EbsVolumes)
+		if volumes == nil {
+			volumes = make(EbsVolumes)
+		}
+		region := s.AccessConfig.SessionRegion()
+		volumes[region] = append(volumes[region], zoneVolumes...)
+		state.Put("ebsvolumes", volumes)
+	}
+
 	//Attach User and Group permissions to snapshots
 	ui.Say("Setting User/Group Permissions for Snapshots...")
 	for snapID, bd := range s.snapshotMap {
@@ -125,10 +214,10 @@ func (s *stepSnapshotEBSVolumes) Run(ctx context.Context, state multistep.StateB
 
 		}
 
-		if len(bd.SnapshotUsers) > 0 {
-			users := make([]*string, len(bd.SnapshotUsers))
-			addsSnapshot := make([]*ec2.CreateVolumePermission, len(bd.SnapshotUsers))
-			for i, u := range bd.SnapshotUsers {
+		if snapshotUsers := shareSnapshotWith(*bd); len(snapshotUsers) > 0 {
+			users := make([]*string, len(snapshotUsers))
+			addsSnapshot := make([]*ec2.CreateVolumePermission, len(snapshotUsers))
+			for i, u := range snapshotUsers {
 				users[i] = aws.String(u)
 				addsSnapshot[i] = &ec2.CreateVolumePermission{UserId: aws.String(u)}
 			}
@@ -155,21 +244,247 @@ func (s *stepSnapshotEBSVolumes) Run(ctx context.Context, state multistep.StateB
 		}
 	}
 
+	for snapID, bd := range s.snapshotMap {
+		if bd.SnapshotStorageTier != ec2.StorageTierArchive {
+			continue
+		}
+		ui.Message(fmt.Sprintf("Archiving snapshot %s, it will have to be restored before use", snapID))
+		_, err := ec2conn.ModifySnapshotTier(&ec2.ModifySnapshotTierInput{
+			SnapshotId:  aws.String(snapID),
+			StorageTier: aws.String(ec2.TargetStorageTierArchive),
+		})
+		if err != nil {
+			err := fmt.Errorf("Error archiving snapshot %s: %s", snapID, err)
+			state.Put("error", err)
+			ui.Error(err.Error())
+			return multistep.ActionHalt
+		}
+	}
+
 	//Record all snapshots in current Region.
-	snapshots := make(EbsSnapshots)
+	ebsSnapshots := make(EbsSnapshots)
 	currentregion := s.AccessConfig.SessionRegion()
 
 	for snapID := range s.snapshotMap {
-		snapshots[currentregion] = append(
-			snapshots[currentregion],
+		ebsSnapshots[currentregion] = append(
+			ebsSnapshots[currentregion],
 			snapID)
 	}
 	//Records artifacts
-	state.Put("ebssnapshots", snapshots)
+	state.Put("ebssnapshots", ebsSnapshots)
 
 	return multistep.ActionContinue
 }
 
+// createZoneVolume creates a volume like the one of bd from its snapshot
+// snapID in zone, and waits for it to be available.
+func (s *stepSnapshotEBSVolumes) createZoneVolume(ctx context.Context, ec2conn ec2iface.EC2API, state multistep.StateBag, snapID, zone string, bd *BlockDevice) (string, error) {
+	input := &ec2.CreateVolumeInput{
+		AvailabilityZone: aws.String(zone),
+		SnapshotId:       aws.String(snapID),
+		Iops:             bd.IOPS,
+		Throughput:       bd.Throughput,
+	}
+	if bd.VolumeType != "" {
+		input.VolumeType = aws.String(bd.VolumeType)
+	}
+	// CreateVolume ignores the key of a volume that isn't encrypted.
+	if bd.KmsKeyId != "" {
+		input.Encrypted = aws.Bool(true)
+		input.KmsKeyId = aws.String(bd.KmsKeyId)
+	}
+
+	tags, err := awscommon.TagMap(bd.Tags).EC2Tags(s.Ctx, s.AccessConfig.SessionRegion(), state)
+	if err != nil {
+		return "", fmt.Errorf("Error generating tags: %s", err)
+	}
+	if len(tags) > 0 {
+		input.TagSpecifications = []*ec2.TagSpecification{{
+			ResourceType: aws.String(ec2.ResourceTypeVolume),
+			Tags:         tags,
+		}}
+	}
+
+	volume, err := ec2conn.CreateVolume(input)
+	if err != nil {
+		return "", err
+	}
+	s.zoneVolumes = append(s.zoneVolumes, *volume.VolumeId)
+	if err := s.PollingConfig.WaitUntilVolumeAvailable(ctx, ec2conn, *volume.VolumeId); err != nil {
+		return "", fmt.Errorf("Error waiting for volume %s: %s", *volume.VolumeId, err)
+	}
+	return *volume.VolumeId, nil
+}
+
+// reencryptSnapshot copies the snapshot snapID, created by input, into a
+// snapshot encrypted with the snapshot key of bd, and deletes the original
+// once the copy is ready.
+func (s *stepSnapshotEBSVolumes) reencryptSnapshot(ctx context.Context, ec2conn ec2iface.EC2API, snapID string, input *ec2.CreateSnapshotInput, bd *BlockDevice) (string, error) {
+	copyInput := &ec2.CopySnapshotInput{
+		SourceRegion:      aws.String(s.AccessConfig.SessionRegion()),
+		SourceSnapshotId:  aws.String(snapID),
+		Description:       input.Description,
+		Encrypted:         aws.Bool(true),
+		TagSpecifications: input.TagSpecifications,
+	}
+	if bd.SnapshotKmsKeyId != "" {
+		copyInput.KmsKeyId = aws.String(bd.SnapshotKmsKeyId)
+	}
+
+	resp, err := ec2conn.CopySnapshot(copyInput)
+	if err != nil {
+		return "", err
+	}
+	copyID := aws.StringValue(resp.SnapshotId)
+	if err := s.PollingConfig.WaitUntilSnapshotDone(ctx, ec2conn, copyID); err != nil {
+		return "", fmt.Errorf("Error waiting for snapshot %s: %s", copyID, err)
+	}
+
+	if _, err := ec2conn.DeleteSnapshot(&ec2.DeleteSnapshotInput{SnapshotId: aws.String(snapID)}); err != nil {
+		return "", fmt.Errorf("Error deleting snapshot %s: %s", snapID, err)
+	}
+	return copyID, nil
+}
+
+// needsReencryption returns whether the snapshot of bd has to be copied to
+// be encrypted as set by `snapshot_encrypted` and `snapshot_kms_key_id`.
+func needsReencryption(snapshot *ec2.Snapshot, bd *BlockDevice) bool {
+	if !bd.SnapshotEncrypted.True() {
+		return false
+	}
+	if !aws.BoolValue(snapshot.Encrypted) {
+		return true
+	}
+	if bd.SnapshotKmsKeyId == "" {
+		return false
+	}
+	// The snapshot key is always an ARN, while the configured key can also
+	// be a key ID, or an alias, which can't be resolved here, and is always
+	// copied to.
+	keyArn := aws.StringValue(snapshot.KmsKeyId)
+	return keyArn != bd.SnapshotKmsKeyId && !strings.HasSuffix(keyArn, ":key/"+bd.SnapshotKmsKeyId)
+}
+
+// checkAvailabilityZones checks that the availability zones of the volumes
+// are available in the region.
+func checkAvailabilityZones(ec2conn ec2iface.EC2API, mappings []BlockDevice) error {
+	var zones []*string
+	for _, bd := range mappings {
+		for _, zone := range bd.AvailabilityZones {
+			zones = append(zones, aws.String(zone))
+		}
+	}
+	if len(zones) == 0 {
+		return nil
+	}
+
+	resp, err := ec2conn.DescribeAvailabilityZones(&ec2.DescribeAvailabilityZonesInput{
+		Filters: []*ec2.Filter{{
+			Name:   aws.String("zone-name"),
+			Values: zones,
+		}},
+	})
+	if err != nil {
+		return err
+	}
+	available := make(map[string]bool)
+	for _, zone := range resp.AvailabilityZones {
+		available[aws.StringValue(zone.ZoneName)] = aws.StringValue(zone.State) == ec2.AvailabilityZoneStateAvailable
+	}
+	for _, zone := range zones {
+		if !available[*zone] {
+			return fmt.Errorf("%s is not an available zone of the region", *zone)
+		}
+	}
+	return nil
+}
+
+// shareSnapshotWith returns the accounts the snapshot of bd is shared with,
+// from both `snapshot_users` and `share_via_snapshot_with`.
+func shareSnapshotWith(bd BlockDevice) []string {
+	seen := make(map[string]bool)
+	var users []string
+	for _, u := range append(append([]string{}, bd.SnapshotUsers...), bd.ShareViaSnapshotWith...) {
+		if !seen[u] {
+			seen[u] = true
+			users = append(users, u)
+		}
+	}
+	return users
+}
+
+// describeVolumeTags returns the tags currently set on a volume, leaving out
+// the ones reserved by AWS which can't be set on a snapshot.
+func describeVolumeTags(ec2conn ec2iface.EC2API, volumeID string) (awscommon.EC2Tags, error) {
+	resp, err := ec2conn.DescribeVolumes(&ec2.DescribeVolumesInput{
+		VolumeIds: []*string{aws.String(volumeID)},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Volumes) == 0 {
+		return nil, fmt.Errorf("volume %s not found", volumeID)
+	}
+
+	var tags awscommon.EC2Tags
+	for _, tag := range resp.Volumes[0].Tags {
+		if strings.HasPrefix(aws.StringValue(tag.Key), "aws:") {
+			continue
+		}
+		tags = append(tags, tag)
+	}
+	return tags, nil
+}
+
+// mergeSnapshotTags adds the volume tags to the snapshot tags, unless a
+// snapshot tag with the same key is already set.
+func mergeSnapshotTags(volumeTags, snapshotTags awscommon.EC2Tags) awscommon.EC2Tags {
+	keys := make(map[string]bool, len(snapshotTags))
+	for _, tag := range snapshotTags {
+		keys[aws.StringValue(tag.Key)] = true
+	}
+
+	var tags awscommon.EC2Tags
+	for _, tag := range volumeTags {
+		if !keys[aws.StringValue(tag.Key)] {
+			tags = append(tags, tag)
+		}
+	}
+	return append(tags, snapshotTags...)
+}
+
+// performanceTags records the performance settings of the volume of bd, which
+// aren't kept by its snapshot.
+func performanceTags(bd BlockDevice) awscommon.EC2Tags {
+	var tags awscommon.EC2Tags
+	if bd.VolumeType != "" {
+		tags = append(tags, &ec2.Tag{Key: aws.String("VolumeType"), Value: aws.String(bd.VolumeType)})
+	}
+	if bd.IOPS != nil {
+		tags = append(tags, &ec2.Tag{Key: aws.String("VolumeIops"), Value: aws.String(strconv.FormatInt(*bd.IOPS, 10))})
+	}
+	if bd.Throughput != nil {
+		tags = append(tags, &ec2.Tag{Key: aws.String("VolumeThroughput"), Value: aws.String(strconv.FormatInt(*bd.Throughput, 10))})
+	}
+	return tags
+}
+
 func (s *stepSnapshotEBSVolumes) Cleanup(state multistep.StateBag) {
-	// No cleanup...
+	if len(s.zoneVolumes) == 0 {
+		return
+	}
+	_, cancelled := state.GetOk(multistep.StateCancelled)
+	_, halted := state.GetOk(multistep.StateHalted)
+	if !cancelled && !halted {
+		return
+	}
+
+	ec2conn := state.Get("ec2").(ec2iface.EC2API)
+	ui := state.Get("ui").(packer.Ui)
+	ui.Say("Deleting the volumes created in the availability zones...")
+	for _, volumeID := range s.zoneVolumes {
+		if _, err := ec2conn.DeleteVolume(&ec2.DeleteVolumeInput{VolumeId: aws.String(volumeID)}); err != nil {
+			ui.Error(fmt.Sprintf("Error deleting volume %s: %s", volumeID, err))
+		}
+	}
 }
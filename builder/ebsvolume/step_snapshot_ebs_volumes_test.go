@@ -7,6 +7,8 @@ import (
 	"bytes"
 	"context"
 	"fmt"
+	"sort"
+	"strings"
 	"testing"
 
 	"github.com/aws/aws-sdk-go/aws"
@@ -15,6 +17,7 @@ import (
 	//"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"github.com/google/go-cmp/cmp"
 	"github.com/hashicorp/packer-plugin-amazon/builder/common"
 	"github.com/hashicorp/packer-plugin-sdk/multistep"
 	"github.com/hashicorp/packer-plugin-sdk/packer"
@@ -24,19 +27,98 @@ import (
 type mockEC2Conn struct {
 	ec2iface.EC2API
 	Config *aws.Config
+
+	volumeTags               map[string][]*ec2.Tag
+	createSnapshotInputs     []*ec2.CreateSnapshotInput
+	modifySnapshotTierInputs []*ec2.ModifySnapshotTierInput
+
+	modifySnapshotAttributeInputs []*ec2.ModifySnapshotAttributeInput
+	createVolumeInputs            []*ec2.CreateVolumeInput
+
+	// Key ARNs the volumes, and so their snapshots, are encrypted with.
+	volumeKmsKeys      map[string]string
+	copySnapshotInputs []*ec2.CopySnapshotInput
+	deletedSnapshotIds []string
+	deletedVolumeIds   []string
+}
+
+func (m *mockEC2Conn) DescribeAvailabilityZones(input *ec2.DescribeAvailabilityZonesInput) (*ec2.DescribeAvailabilityZonesOutput, error) {
+	var zones []*ec2.AvailabilityZone
+	for _, name := range input.Filters[0].Values {
+		if strings.HasPrefix(*name, "us-west-1") {
+			zones = append(zones, &ec2.AvailabilityZone{ZoneName: name, State: aws.String(ec2.AvailabilityZoneStateAvailable)})
+		}
+	}
+	return &ec2.DescribeAvailabilityZonesOutput{AvailabilityZones: zones}, nil
+}
+
+func (m *mockEC2Conn) CreateVolume(input *ec2.CreateVolumeInput) (*ec2.Volume, error) {
+	m.createVolumeInputs = append(m.createVolumeInputs, input)
+	return &ec2.Volume{
+		VolumeId:         aws.String(fmt.Sprintf("vol-in-%s", *input.AvailabilityZone)),
+		AvailabilityZone: input.AvailabilityZone,
+		SnapshotId:       input.SnapshotId,
+	}, nil
+}
+
+func (m *mockEC2Conn) DeleteVolume(input *ec2.DeleteVolumeInput) (*ec2.DeleteVolumeOutput, error) {
+	m.deletedVolumeIds = append(m.deletedVolumeIds, *input.VolumeId)
+	return &ec2.DeleteVolumeOutput{}, nil
+}
+
+func (m *mockEC2Conn) WaitUntilVolumeAvailableWithContext(aws.Context, *ec2.DescribeVolumesInput, ...request.WaiterOption) error {
+	return nil
+}
+
+func (m *mockEC2Conn) DescribeVolumes(input *ec2.DescribeVolumesInput) (*ec2.DescribeVolumesOutput, error) {
+	var volumes []*ec2.Volume
+	for _, id := range input.VolumeIds {
+		volumes = append(volumes, &ec2.Volume{
+			VolumeId: id,
+			Tags:     m.volumeTags[*id],
+		})
+	}
+	return &ec2.DescribeVolumesOutput{Volumes: volumes}, nil
 }
 
 func (m *mockEC2Conn) CreateSnapshot(input *ec2.CreateSnapshotInput) (*ec2.Snapshot, error) {
+	m.createSnapshotInputs = append(m.createSnapshotInputs, input)
 	snap := &ec2.Snapshot{
 		// This isn't typical amazon format, but injecting the volume id into
 		// this field lets us verify that the right volume was snapshotted with
 		// a simple string comparison
 		SnapshotId: aws.String(fmt.Sprintf("snap-of-%s", *input.VolumeId)),
 	}
+	if key, ok := m.volumeKmsKeys[*input.VolumeId]; ok {
+		snap.Encrypted = aws.Bool(true)
+		snap.KmsKeyId = aws.String(key)
+	}
 
 	return snap, nil
 }
 
+func (m *mockEC2Conn) CopySnapshot(input *ec2.CopySnapshotInput) (*ec2.CopySnapshotOutput, error) {
+	m.copySnapshotInputs = append(m.copySnapshotInputs, input)
+	return &ec2.CopySnapshotOutput{
+		SnapshotId: aws.String(fmt.Sprintf("copy-of-%s", *input.SourceSnapshotId)),
+	}, nil
+}
+
+func (m *mockEC2Conn) DeleteSnapshot(input *ec2.DeleteSnapshotInput) (*ec2.DeleteSnapshotOutput, error) {
+	m.deletedSnapshotIds = append(m.deletedSnapshotIds, *input.SnapshotId)
+	return &ec2.DeleteSnapshotOutput{}, nil
+}
+
+func (m *mockEC2Conn) ModifySnapshotTier(input *ec2.ModifySnapshotTierInput) (*ec2.ModifySnapshotTierOutput, error) {
+	m.modifySnapshotTierInputs = append(m.modifySnapshotTierInputs, input)
+	return &ec2.ModifySnapshotTierOutput{SnapshotId: input.SnapshotId}, nil
+}
+
+func (m *mockEC2Conn) ModifySnapshotAttribute(input *ec2.ModifySnapshotAttributeInput) (*ec2.ModifySnapshotAttributeOutput, error) {
+	m.modifySnapshotAttributeInputs = append(m.modifySnapshotAttributeInputs, input)
+	return &ec2.ModifySnapshotAttributeOutput{}, nil
+}
+
 func (m *mockEC2Conn) WaitUntilSnapshotCompletedWithContext(aws.Context, *ec2.DescribeSnapshotsInput, ...request.WaiterOption) error {
 	return nil
 }
@@ -171,3 +253,582 @@ func TestStepSnapshot_run_no_snaps(t *testing.T) {
 		t.Fatalf("Shouldn't have snapshotted any volumes")
 	}
 }
+
+func TestStepSnapshot_run_propagate_tags(t *testing.T) {
+	var b Builder
+	config := testConfig() //from builder_test
+
+	config["ebs_volumes"] = []map[string]interface{}{
+		{
+			"device_name":                "/dev/xvdb",
+			"volume_size":                "32",
+			"delete_on_termination":      true,
+			"snapshot_volume":            true,
+			"propagate_tags_to_snapshot": true,
+			"snapshot_tags": map[string]string{
+				"Owner": "snapshot-owner",
+			},
+		},
+	}
+
+	_, _, err := b.Prepare(config)
+	if err != nil {
+		t.Fatalf("should not have error: %s", err)
+	}
+
+	state := tState(t)
+	conn := state.Get("ec2").(*mockEC2Conn)
+	conn.volumeTags = map[string][]*ec2.Tag{
+		"vol-5678": {
+			{Key: aws.String("Name"), Value: aws.String("data")},
+			{Key: aws.String("Owner"), Value: aws.String("volume-owner")},
+			{Key: aws.String("aws:cloudformation:stack-name"), Value: aws.String("stack")},
+		},
+	}
+
+	step := stepSnapshotEBSVolumes{
+		PollingConfig: new(common.AWSPollingConfig),
+		AccessConfig:  common.FakeAccessConfig(),
+		VolumeMapping: b.config.VolumeMappings,
+		Ctx:           b.config.ctx,
+	}
+
+	if action := step.Run(context.Background(), state); action != multistep.ActionContinue {
+		t.Fatalf("should continue, got %v: %v", action, state.Get("error"))
+	}
+
+	if len(conn.createSnapshotInputs) != 1 {
+		t.Fatalf("expected 1 snapshot, got %d", len(conn.createSnapshotInputs))
+	}
+	specs := conn.createSnapshotInputs[0].TagSpecifications
+	if len(specs) != 1 || *specs[0].ResourceType != "snapshot" {
+		t.Fatalf("expected a single snapshot tag specification, got %#v", specs)
+	}
+
+	got := make(map[string]string)
+	for _, tag := range specs[0].Tags {
+		got[*tag.Key] = *tag.Value
+	}
+	expected := map[string]string{
+		"Name":  "data",
+		"Owner": "snapshot-owner",
+	}
+	if diff := cmp.Diff(expected, got); diff != "" {
+		t.Fatalf("unexpected snapshot tags: %s", diff)
+	}
+}
+
+func TestStepSnapshot_run_outpost(t *testing.T) {
+	var b Builder
+	config := testConfig() //from builder_test
+
+	outpostArn := "arn:aws:outposts:us-east-1:123456789012:outpost/op-1234567890abcdef0"
+	config["outpost_arn"] = outpostArn
+	config["ebs_volumes"] = []map[string]interface{}{
+		{
+			"device_name":           "/dev/xvdb",
+			"volume_size":           "32",
+			"delete_on_termination": true,
+			"snapshot_volume":       true,
+		},
+	}
+
+	_, _, err := b.Prepare(config)
+	if err != nil {
+		t.Fatalf("should not have error: %s", err)
+	}
+
+	state := tState(t)
+	conn := state.Get("ec2").(*mockEC2Conn)
+
+	step := stepSnapshotEBSVolumes{
+		PollingConfig: new(common.AWSPollingConfig),
+		AccessConfig:  common.FakeAccessConfig(),
+		VolumeMapping: b.config.VolumeMappings,
+		OutpostArn:    b.config.OutpostArn,
+		Ctx:           b.config.ctx,
+	}
+
+	if action := step.Run(context.Background(), state); action != multistep.ActionContinue {
+		t.Fatalf("should continue, got %v: %v", action, state.Get("error"))
+	}
+
+	if len(conn.createSnapshotInputs) != 1 {
+		t.Fatalf("expected 1 snapshot, got %d", len(conn.createSnapshotInputs))
+	}
+	if got := aws.StringValue(conn.createSnapshotInputs[0].OutpostArn); got != outpostArn {
+		t.Fatalf("expected the snapshot to be stored on %s, got %q", outpostArn, got)
+	}
+}
+
+func TestBuilderPrepare_InvalidOutpostArn(t *testing.T) {
+	var b Builder
+	config := testConfig() //from builder_test
+	config["outpost_arn"] = "op-1234567890abcdef0"
+
+	if _, _, err := b.Prepare(config); err == nil {
+		t.Fatalf("should error on an invalid outpost_arn")
+	}
+}
+
+func TestStepSnapshot_run_archive(t *testing.T) {
+	var b Builder
+	config := testConfig() //from builder_test
+
+	config["ebs_volumes"] = []map[string]interface{}{
+		{
+			"device_name":           "/dev/xvda",
+			"volume_size":           "8",
+			"delete_on_termination": true,
+			"snapshot_volume":       true,
+		},
+		{
+			"device_name":           "/dev/xvdb",
+			"volume_size":           "32",
+			"delete_on_termination": true,
+			"snapshot_volume":       true,
+			"snapshot_storage_tier": "archive",
+		},
+	}
+
+	_, _, err := b.Prepare(config)
+	if err != nil {
+		t.Fatalf("should not have error: %s", err)
+	}
+
+	state := tState(t)
+	conn := state.Get("ec2").(*mockEC2Conn)
+
+	step := stepSnapshotEBSVolumes{
+		PollingConfig: new(common.AWSPollingConfig),
+		AccessConfig:  common.FakeAccessConfig(),
+		VolumeMapping: b.config.VolumeMappings,
+		Ctx:           b.config.ctx,
+	}
+
+	if action := step.Run(context.Background(), state); action != multistep.ActionContinue {
+		t.Fatalf("should continue, got %v: %v", action, state.Get("error"))
+	}
+
+	if len(conn.modifySnapshotTierInputs) != 1 {
+		t.Fatalf("expected only the snapshot of /dev/xvdb to be archived, got %d calls", len(conn.modifySnapshotTierInputs))
+	}
+	input := conn.modifySnapshotTierInputs[0]
+	if *input.SnapshotId != "snap-of-vol-5678" || *input.StorageTier != ec2.TargetStorageTierArchive {
+		t.Fatalf("unexpected ModifySnapshotTier input: %#v", input)
+	}
+}
+
+func TestBuilderPrepare_SnapshotStorageTier(t *testing.T) {
+	tests := []struct {
+		name           string
+		tier           string
+		snapshotVolume bool
+		expectedError  bool
+	}{
+		{name: "standard", tier: "standard", snapshotVolume: true},
+		{name: "archive", tier: "archive", snapshotVolume: true},
+		{name: "archive without snapshot", tier: "archive", expectedError: true},
+		{name: "invalid", tier: "glacier", snapshotVolume: true, expectedError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var b Builder
+			config := testConfig() //from builder_test
+			config["ebs_volumes"] = []map[string]interface{}{
+				{
+					"device_name":           "/dev/xvdb",
+					"volume_size":           "32",
+					"snapshot_volume":       tt.snapshotVolume,
+					"snapshot_storage_tier": tt.tier,
+				},
+			}
+
+			_, _, err := b.Prepare(config)
+			if tt.expectedError && err == nil {
+				t.Fatalf("should error with snapshot_storage_tier %q", tt.tier)
+			}
+			if !tt.expectedError && err != nil {
+				t.Fatalf("should not have error: %s", err)
+			}
+		})
+	}
+}
+
+func TestStepSnapshot_run_performance_tags(t *testing.T) {
+	var b Builder
+	config := testConfig() //from builder_test
+
+	config["ebs_volumes"] = []map[string]interface{}{
+		{
+			"device_name":               "/dev/xvdb",
+			"volume_size":               "32",
+			"volume_type":               "gp3",
+			"iops":                      6000,
+			"throughput":                500,
+			"delete_on_termination":     true,
+			"snapshot_volume":           true,
+			"snapshot_performance_tags": true,
+			"snapshot_tags": map[string]string{
+				"VolumeThroughput": "250",
+			},
+		},
+	}
+
+	_, _, err := b.Prepare(config)
+	if err != nil {
+		t.Fatalf("should not have error: %s", err)
+	}
+
+	state := tState(t)
+	conn := state.Get("ec2").(*mockEC2Conn)
+
+	step := stepSnapshotEBSVolumes{
+		PollingConfig: new(common.AWSPollingConfig),
+		AccessConfig:  common.FakeAccessConfig(),
+		VolumeMapping: b.config.VolumeMappings,
+		Ctx:           b.config.ctx,
+	}
+
+	if action := step.Run(context.Background(), state); action != multistep.ActionContinue {
+		t.Fatalf("should continue, got %v: %v", action, state.Get("error"))
+	}
+
+	if len(conn.createSnapshotInputs) != 1 {
+		t.Fatalf("expected 1 snapshot, got %d", len(conn.createSnapshotInputs))
+	}
+	got := make(map[string]string)
+	for _, tag := range conn.createSnapshotInputs[0].TagSpecifications[0].Tags {
+		got[*tag.Key] = *tag.Value
+	}
+	expected := map[string]string{
+		"VolumeType":       "gp3",
+		"VolumeIops":       "6000",
+		"VolumeThroughput": "250",
+	}
+	if diff := cmp.Diff(expected, got); diff != "" {
+		t.Fatalf("unexpected snapshot tags: %s", diff)
+	}
+}
+
+func TestBuilderPrepare_SnapshotPerformanceTagsRequiresSnapshot(t *testing.T) {
+	var b Builder
+	config := testConfig() //from builder_test
+
+	config["ebs_volumes"] = []map[string]interface{}{
+		{
+			"device_name":               "/dev/xvdb",
+			"volume_size":               "32",
+			"volume_type":               "gp3",
+			"snapshot_performance_tags": true,
+		},
+	}
+
+	if _, _, err := b.Prepare(config); err == nil {
+		t.Fatal("snapshot_performance_tags should require snapshot_volume")
+	}
+}
+
+func TestStepSnapshot_run_share_via_snapshot(t *testing.T) {
+	var b Builder
+	config := testConfig() //from builder_test
+
+	config["ebs_volumes"] = []map[string]interface{}{
+		{
+			"device_name":             "/dev/xvda",
+			"volume_size":             "8",
+			"delete_on_termination":   true,
+			"share_via_snapshot_with": []string{"123456789012"},
+		},
+		{
+			"device_name":             "/dev/xvdb",
+			"volume_size":             "32",
+			"delete_on_termination":   true,
+			"snapshot_users":          []string{"123456789012"},
+			"share_via_snapshot_with": []string{"123456789012", "210987654321"},
+		},
+	}
+
+	_, _, err := b.Prepare(config)
+	if err != nil {
+		t.Fatalf("should not have error: %s", err)
+	}
+
+	state := tState(t)
+	conn := state.Get("ec2").(*mockEC2Conn)
+
+	step := stepSnapshotEBSVolumes{
+		PollingConfig: new(common.AWSPollingConfig),
+		AccessConfig:  common.FakeAccessConfig(),
+		VolumeMapping: b.config.VolumeMappings,
+		Ctx:           b.config.ctx,
+	}
+
+	if action := step.Run(context.Background(), state); action != multistep.ActionContinue {
+		t.Fatalf("should continue, got %v: %v", action, state.Get("error"))
+	}
+
+	if len(conn.createSnapshotInputs) != 2 {
+		t.Fatalf("expected a snapshot of each shared volume, got %d", len(conn.createSnapshotInputs))
+	}
+
+	shared := make(map[string][]string)
+	for _, input := range conn.modifySnapshotAttributeInputs {
+		for _, permission := range input.CreateVolumePermission.Add {
+			shared[*input.SnapshotId] = append(shared[*input.SnapshotId], *permission.UserId)
+		}
+	}
+	expected := map[string][]string{
+		"snap-of-vol-1234": {"123456789012"},
+		"snap-of-vol-5678": {"123456789012", "210987654321"},
+	}
+	if diff := cmp.Diff(expected, shared); diff != "" {
+		t.Fatalf("unexpected snapshot sharing: %s", diff)
+	}
+}
+
+func TestStepSnapshot_AvailabilityZones(t *testing.T) {
+	var b Builder
+	config := testConfig() //from builder_test
+	config["region"] = "us-west-1"
+	config["ebs_volumes"] = []map[string]interface{}{
+		{
+			"device_name":        "/dev/xvdb",
+			"volume_size":        "32",
+			"volume_type":        "gp3",
+			"tags":               map[string]string{"Name": "data"},
+			"kms_key_id":         "alias/data",
+			"availability_zones": []string{"us-west-1a", "us-west-1b", "us-west-1c"},
+		},
+	}
+
+	if _, _, err := b.Prepare(config); err != nil {
+		t.Fatalf("should not have error: %s", err)
+	}
+	if !b.config.VolumeMappings[0].SnapshotVolume {
+		t.Fatalf("availability_zones should snapshot the volume")
+	}
+
+	state := tState(t)
+	instance := state.Get("instance").(*ec2.Instance)
+	instance.Placement = &ec2.Placement{AvailabilityZone: aws.String("us-west-1a")}
+	state.Put("ebsvolumes", EbsVolumes{"us-west-1": {"vol-5678"}})
+
+	step := stepSnapshotEBSVolumes{
+		PollingConfig: new(common.AWSPollingConfig),
+		AccessConfig:  common.FakeAccessConfig(),
+		VolumeMapping: b.config.VolumeMappings,
+		Ctx:           b.config.ctx,
+	}
+	if action := step.Run(context.Background(), state); action != multistep.ActionContinue {
+		t.Fatalf("should continue, got error: %v", state.Get("error"))
+	}
+
+	conn := state.Get("ec2").(*mockEC2Conn)
+	if len(conn.createSnapshotInputs) != 1 || *conn.createSnapshotInputs[0].VolumeId != "vol-5678" {
+		t.Fatalf("expected a snapshot of vol-5678, got %v", conn.createSnapshotInputs)
+	}
+
+	// The volume already is in the zone of the instance.
+	var zones []string
+	for _, input := range conn.createVolumeInputs {
+		zones = append(zones, *input.AvailabilityZone)
+		if *input.SnapshotId != "snap-of-vol-5678" {
+			t.Fatalf("expected the volume to be created from snap-of-vol-5678, got %s", *input.SnapshotId)
+		}
+		if aws.StringValue(input.VolumeType) != "gp3" {
+			t.Fatalf("expected a gp3 volume, got %s", aws.StringValue(input.VolumeType))
+		}
+		if !aws.BoolValue(input.Encrypted) || aws.StringValue(input.KmsKeyId) != "alias/data" {
+			t.Fatalf("expected a volume encrypted with alias/data, got %v", input)
+		}
+		if len(input.TagSpecifications) != 1 || *input.TagSpecifications[0].Tags[0].Value != "data" {
+			t.Fatalf("expected the volume tags, got %v", input.TagSpecifications)
+		}
+	}
+	if diff := cmp.Diff([]string{"us-west-1b", "us-west-1c"}, zones); diff != "" {
+		t.Fatalf("unexpected zones: %s", diff)
+	}
+
+	volumes := state.Get("ebsvolumes").(EbsVolumes)
+	if diff := cmp.Diff([]string{"vol-5678", "vol-in-us-west-1b", "vol-in-us-west-1c"}, volumes["us-west-1"]); diff != "" {
+		t.Fatalf("unexpected volumes: %s", diff)
+	}
+
+	// The volumes are kept when the build succeeds, and deleted when it
+	// fails.
+	step.Cleanup(state)
+	if len(conn.deletedVolumeIds) != 0 {
+		t.Fatalf("no volume should be deleted, got %v", conn.deletedVolumeIds)
+	}
+	state.Put(multistep.StateHalted, true)
+	step.Cleanup(state)
+	if diff := cmp.Diff([]string{"vol-in-us-west-1b", "vol-in-us-west-1c"}, conn.deletedVolumeIds); diff != "" {
+		t.Fatalf("unexpected deleted volumes: %s", diff)
+	}
+}
+
+func TestStepSnapshot_UnavailableZone(t *testing.T) {
+	var b Builder
+	config := testConfig() //from builder_test
+	config["ebs_volumes"] = []map[string]interface{}{
+		{
+			"device_name":        "/dev/xvdb",
+			"availability_zones": []string{"us-west-1a"},
+		},
+	}
+
+	if _, _, err := b.Prepare(config); err == nil {
+		t.Fatalf("a zone of another region should be rejected")
+	}
+
+	delete(config, "region")
+	config["ebs_volumes"].([]map[string]interface{})[0]["availability_zones"] = []string{"us-east-1a"}
+	b = Builder{}
+	if _, _, err := b.Prepare(config); err != nil {
+		t.Fatalf("should not have error: %s", err)
+	}
+
+	step := stepSnapshotEBSVolumes{
+		PollingConfig: new(common.AWSPollingConfig),
+		AccessConfig:  common.FakeAccessConfig(),
+		VolumeMapping: b.config.VolumeMappings,
+		Ctx:           b.config.ctx,
+	}
+	state := tState(t)
+	if action := step.Run(context.Background(), state); action != multistep.ActionHalt {
+		t.Fatalf("a zone not available in the region should halt the build")
+	}
+	if conn := state.Get("ec2").(*mockEC2Conn); len(conn.createSnapshotInputs) != 0 {
+		t.Fatalf("nothing should be snapshotted, got %v", conn.createSnapshotInputs)
+	}
+}
+
+func TestStepSnapshot_run_snapshot_encrypted(t *testing.T) {
+	var b Builder
+	config := testConfig() //from builder_test
+
+	snapshotKey := "arn:aws:kms:us-east-1:123456789012:key/12345678-1234-1234-1234-123456789012"
+	config["ebs_volumes"] = []map[string]interface{}{
+		{
+			"device_name":         "/dev/xvda",
+			"volume_size":         "8",
+			"encrypted":           true,
+			"snapshot_volume":     true,
+			"snapshot_encrypted":  true,
+			"snapshot_kms_key_id": snapshotKey,
+		},
+		{
+			"device_name":         "/dev/xvdb",
+			"volume_size":         "32",
+			"encrypted":           true,
+			"snapshot_volume":     true,
+			"snapshot_encrypted":  true,
+			"snapshot_kms_key_id": "12345678-1234-1234-1234-123456789012",
+		},
+	}
+
+	_, _, err := b.Prepare(config)
+	if err != nil {
+		t.Fatalf("should not have error: %s", err)
+	}
+
+	state := tState(t)
+	conn := state.Get("ec2").(*mockEC2Conn)
+	conn.volumeKmsKeys = map[string]string{
+		"vol-1234": "arn:aws:kms:us-east-1:123456789012:key/volume-key",
+		"vol-5678": snapshotKey,
+	}
+
+	step := stepSnapshotEBSVolumes{
+		PollingConfig: new(common.AWSPollingConfig),
+		AccessConfig:  common.FakeAccessConfig(),
+		VolumeMapping: b.config.VolumeMappings,
+		Ctx:           b.config.ctx,
+	}
+
+	if action := step.Run(context.Background(), state); action != multistep.ActionContinue {
+		t.Fatalf("should continue, got %v: %v", action, state.Get("error"))
+	}
+
+	if len(conn.copySnapshotInputs) != 1 {
+		t.Fatalf("expected only the snapshot of /dev/xvda to be re-encrypted, got %d copies", len(conn.copySnapshotInputs))
+	}
+	input := conn.copySnapshotInputs[0]
+	if *input.SourceSnapshotId != "snap-of-vol-1234" || !*input.Encrypted || *input.KmsKeyId != snapshotKey {
+		t.Fatalf("unexpected CopySnapshot input: %#v", input)
+	}
+	if len(conn.deletedSnapshotIds) != 1 || conn.deletedSnapshotIds[0] != "snap-of-vol-1234" {
+		t.Fatalf("expected the original snapshot to be deleted, got %v", conn.deletedSnapshotIds)
+	}
+
+	snapshots := state.Get("ebssnapshots").(EbsSnapshots)
+	got := snapshots[common.FakeAccessConfig().SessionRegion()]
+	sort.Strings(got)
+	if diff := cmp.Diff([]string{"copy-of-snap-of-vol-1234", "snap-of-vol-5678"}, got); diff != "" {
+		t.Fatalf("unexpected snapshots in the artifact: %s", diff)
+	}
+}
+
+func TestBuilderPrepare_SnapshotEncrypted(t *testing.T) {
+	tests := []struct {
+		name          string
+		volume        map[string]interface{}
+		expectedError bool
+	}{
+		{
+			name:   "encrypted snapshot of an unencrypted volume",
+			volume: map[string]interface{}{"snapshot_volume": true, "snapshot_encrypted": true},
+		},
+		{
+			name: "snapshot key",
+			volume: map[string]interface{}{"snapshot_volume": true, "snapshot_encrypted": true,
+				"snapshot_kms_key_id": "alias/snapshots"},
+		},
+		{
+			name:          "without snapshot",
+			volume:        map[string]interface{}{"snapshot_encrypted": true},
+			expectedError: true,
+		},
+		{
+			name:          "snapshot key without snapshot_encrypted",
+			volume:        map[string]interface{}{"snapshot_volume": true, "snapshot_kms_key_id": "alias/snapshots"},
+			expectedError: true,
+		},
+		{
+			name: "invalid snapshot key",
+			volume: map[string]interface{}{"snapshot_volume": true, "snapshot_encrypted": true,
+				"snapshot_kms_key_id": "not a key"},
+			expectedError: true,
+		},
+		{
+			name:          "unencrypted snapshot of an encrypted volume",
+			volume:        map[string]interface{}{"snapshot_volume": true, "encrypted": true, "snapshot_encrypted": false},
+			expectedError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var b Builder
+			config := testConfig() //from builder_test
+			volume := map[string]interface{}{
+				"device_name": "/dev/xvdb",
+				"volume_size": "32",
+			}
+			for k, v := range tt.volume {
+				volume[k] = v
+			}
+			config["ebs_volumes"] = []map[string]interface{}{volume}
+
+			_, _, err := b.Prepare(config)
+			if tt.expectedError && err == nil {
+				t.Fatal("should have error")
+			}
+			if !tt.expectedError && err != nil {
+				t.Fatalf("should not have error: %s", err)
+			}
+		})
+	}
+}
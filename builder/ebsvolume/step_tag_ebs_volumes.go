@@ -46,12 +46,14 @@ func (s *stepTagEBSVolumes) Run(ctx context.Context, state multistep.StateBag) m
 		// If run_volume_tags were set in the template any attached EBS
 		// volume will have had these tags applied when the instance was
 		// created. We now need to remove these tags to ensure only the EBS
-		// volume tags are applied (if any)
-		if len(config.VolumeRunTags) > 0 {
+		// volume tags are applied (if any), along with the run UUID tag if
+		// tag_ephemeral_with_run_uuid is set, as these volumes are kept.
+		runVolumeTags := config.EphemeralTags(config.VolumeRunTags)
+		if len(runVolumeTags) > 0 {
 			ui.Say("Removing any tags applied to EBS volumes when the source instance was created...")
 
 			ui.Message("Compiling list of existing tags to remove...")
-			existingTags, err := awscommon.TagMap(config.VolumeRunTags).EC2Tags(s.Ctx, *ec2conn.Config.Region, state)
+			existingTags, err := awscommon.TagMap(runVolumeTags).EC2Tags(s.Ctx, *ec2conn.Config.Region, state)
 			if err != nil {
 				err := fmt.Errorf("Error generating list of tags to remove: %s", err)
 				state.Put("error", err)
@@ -15,6 +15,7 @@ import (
 	"os"
 	"strings"
 
+	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/aws/aws-sdk-go/service/iam"
 	"github.com/hashicorp/hcl/v2/hcldec"
@@ -193,6 +194,9 @@ func (b *Builder) Prepare(raws ...interface{}) ([]string, []string, error) {
 	errs = packersdk.MultiErrorAppend(errs,
 		b.config.AMIConfig.Prepare(&b.config.AccessConfig, &b.config.ctx)...)
 	errs = packersdk.MultiErrorAppend(errs, b.config.RunConfig.Prepare(&b.config.ctx)...)
+	if err := b.config.CheckMaxSize(b.config.LaunchMappings, b.config.AMIMappings); err != nil {
+		errs = packersdk.MultiErrorAppend(errs, err)
+	}
 
 	if b.config.AccountId == "" {
 		errs = packersdk.MultiErrorAppend(errs, errors.New("account_id is required"))
@@ -233,6 +237,9 @@ func (b *Builder) Prepare(raws ...interface{}) ([]string, []string, error) {
 			"builds. Please take a look at our current documentation to "+
 			"understand how Packer requests Spot instances.")
 	}
+	if warn := b.config.RunConfig.EbsOptimizedWarning(); warn != "" {
+		warns = append(warns, warn)
+	}
 
 	if b.config.RunConfig.EnableT2Unlimited {
 		warns = append(warns, "enable_t2_unlimited is deprecated please use "+
@@ -250,6 +257,7 @@ func (b *Builder) Prepare(raws ...interface{}) ([]string, []string, error) {
 	packersdk.LogSecretFilter.Set(b.config.AccessKey, b.config.SecretKey, b.config.Token)
 
 	generatedData := awscommon.GetGeneratedDataList()
+	generatedData = append(generatedData, "CentralAMI", "UnshareAfter", "FailedCopyRegions")
 	return generatedData, warns, nil
 }
 
@@ -274,12 +282,46 @@ func (b *Builder) Run(ctx context.Context, ui packersdk.Ui, hook packersdk.Hook)
 	state.Put("region", ec2conn.Config.Region)
 	generatedData := &packerbuilderdata.GeneratedData{State: state}
 
+	steps := b.buildSteps(session, ec2conn, generatedData)
+
+	// Run!
+	b.runner = commonsteps.NewRunner(steps, b.config.PackerConfig, ui)
+	b.runner.Run(ctx, state)
+
+	// If there was an error, return that
+	if rawErr, ok := state.GetOk("error"); ok {
+		return nil, rawErr.(error)
+	}
+
+	// If there are no AMIs, then just return
+	if _, ok := state.GetOk("amis"); !ok {
+		return nil, nil
+	}
+
+	// Build the artifact and return it
+	artifact := &awscommon.Artifact{
+		Amis:           state.Get("amis").(map[string]string),
+		BuilderIdValue: BuilderId,
+		Session:        session,
+		StateData:      map[string]interface{}{"generated_data": state.Get("generated_data")},
+	}
+	if centralAmi, ok := state.GetOk("central_ami"); ok {
+		artifact.CentralAmi = centralAmi.(string)
+	}
+
+	return artifact, nil
+}
+
+// buildSteps returns the steps of the build, in the order they run.
+func (b *Builder) buildSteps(session *session.Session, ec2conn *ec2.EC2, generatedData *packerbuilderdata.GeneratedData) []multistep.Step {
 	var instanceStep multistep.Step
 
 	if b.config.IsSpotInstance() {
 		instanceStep = &awscommon.StepRunSpotInstance{
 			PollingConfig:            b.config.PollingConfig,
 			AssociatePublicIpAddress: b.config.AssociatePublicIpAddress,
+			EnablePrimaryIpv6:        b.config.EnablePrimaryIpv6,
+			PrivateDnsNameOptions:    b.config.PrivateDnsNameOptions,
 			LaunchMappings:           b.config.LaunchMappings,
 			BlockDurationMinutes:     b.config.BlockDurationMinutes,
 			Ctx:                      b.config.ctx,
@@ -293,12 +335,16 @@ func (b *Builder) Run(ctx context.Context, ui packersdk.Ui, hook packersdk.Hook)
 			Region:                   *ec2conn.Config.Region,
 			SourceAMI:                b.config.SourceAmi,
 			SpotPrice:                b.config.SpotPrice,
+			SpotRequestTimeout:       b.config.SpotRequestTimeout,
+			LogSpotPrices:            b.config.LogSpotPrices,
 			SpotInstanceTypes:        b.config.SpotInstanceTypes,
 			SpotAllocationStrategy:   b.config.SpotAllocationStrategy,
-			Tags:                     b.config.RunTags,
+			Tags:                     b.config.EphemeralTags(b.config.RunTags),
 			SpotTags:                 b.config.SpotTags,
 			UserData:                 b.config.UserData,
 			UserDataFile:             b.config.UserDataFile,
+			UserDataS3Url:            b.config.UserDataS3Url,
+			UserDataSSMParameter:     b.config.UserDataSSMParameter,
 		}
 	} else {
 		var tenancy string
@@ -314,6 +360,10 @@ func (b *Builder) Run(ctx context.Context, ui packersdk.Ui, hook packersdk.Hook)
 		instanceStep = &awscommon.StepRunSourceInstance{
 			PollingConfig:                 b.config.PollingConfig,
 			AssociatePublicIpAddress:      b.config.AssociatePublicIpAddress,
+			EnablePrimaryIpv6:             b.config.EnablePrimaryIpv6,
+			PrivateDnsNameOptions:         b.config.PrivateDnsNameOptions,
+			EIPAllocationId:               b.config.AssociateEIPAllocationId,
+			InsufficientCapacityRetries:   b.config.InsufficientCapacityRetries,
 			LaunchMappings:                b.config.LaunchMappings,
 			CapacityReservationPreference: b.config.CapacityReservationPreference,
 			CapacityReservationId:         b.config.CapacityReservationId,
@@ -328,17 +378,29 @@ func (b *Builder) Run(ctx context.Context, ui packersdk.Ui, hook packersdk.Hook)
 			InstanceType:                  b.config.InstanceType,
 			IsRestricted:                  b.config.IsChinaCloud(),
 			SourceAMI:                     b.config.SourceAmi,
-			Tags:                          b.config.RunTags,
+			Tags:                          b.config.EphemeralTags(b.config.RunTags),
 			LicenseSpecifications:         b.config.LicenseSpecifications,
 			HostResourceGroupArn:          b.config.Placement.HostResourceGroupArn,
 			Tenancy:                       tenancy,
 			UserData:                      b.config.UserData,
 			UserDataFile:                  b.config.UserDataFile,
+			UserDataS3Url:                 b.config.UserDataS3Url,
+			UserDataSSMParameter:          b.config.UserDataSSMParameter,
 		}
 	}
 
+	var iamActions []string
+	if b.config.CheckIAMPermissions {
+		iamActions = append(b.config.RunConfig.IAMActions(), b.config.AMIConfig.IAMActions()...)
+		iamActions = append(iamActions, "ec2:RegisterImage")
+	}
+
 	// Build the steps
 	steps := []multistep.Step{
+		&awscommon.StepCheckIAMPermissions{
+			Actions: iamActions,
+			Region:  *ec2conn.Config.Region,
+		},
 		&awscommon.StepPreValidate{
 			DestAmiName:     b.config.AMIName,
 			ForceDeregister: b.config.AMIForceDeregister,
@@ -351,7 +413,10 @@ func (b *Builder) Run(ctx context.Context, ui packersdk.Ui, hook packersdk.Hook)
 			EnableAMISriovNetSupport: b.config.AMISriovNetSupport,
 			EnableAMIENASupport:      b.config.AMIENASupport,
 			AmiFilters:               b.config.SourceAmiFilter,
+			AmiFilterGroups:          b.config.SourceAmiFilters,
 			AMIVirtType:              b.config.AMIVirtType,
+			MatchAMIVirtType:         true,
+			AllowedOwners:            b.config.AllowedSourceAmiOwners,
 		},
 		&awscommon.StepNetworkInfo{
 			VpcId:                    b.config.VpcId,
@@ -369,7 +434,7 @@ func (b *Builder) Run(ctx context.Context, ui packersdk.Ui, hook packersdk.Hook)
 			Comm:         &b.config.RunConfig.Comm,
 			IsRestricted: b.config.IsChinaCloud(),
 			DebugKeyPath: fmt.Sprintf("ec2_%s.pem", b.config.PackerBuildName),
-			Tags:         b.config.RunTags,
+			Tags:         b.config.EphemeralTags(b.config.RunTags),
 			Ctx:          b.config.ctx,
 		},
 		&awscommon.StepSecurityGroup{
@@ -380,7 +445,7 @@ func (b *Builder) Run(ctx context.Context, ui packersdk.Ui, hook packersdk.Hook)
 			TemporarySGSourcePublicIp: b.config.TemporarySGSourcePublicIp,
 			SkipSSHRuleCreation:       b.config.SSMAgentEnabled(),
 			IsRestricted:              b.config.IsChinaCloud(),
-			Tags:                      b.config.RunTags,
+			Tags:                      b.config.EphemeralTags(b.config.RunTags),
 			Ctx:                       b.config.ctx,
 		},
 		&awscommon.StepIamInstanceProfile{
@@ -388,7 +453,7 @@ func (b *Builder) Run(ctx context.Context, ui packersdk.Ui, hook packersdk.Hook)
 			IamInstanceProfile:                        b.config.IamInstanceProfile,
 			SkipProfileValidation:                     b.config.SkipProfileValidation,
 			TemporaryIamInstanceProfilePolicyDocument: b.config.TemporaryIamInstanceProfilePolicyDocument,
-			Tags: b.config.RunTags,
+			Tags: b.config.EphemeralTags(b.config.RunTags),
 			Ctx:  b.config.ctx,
 		},
 		instanceStep,
@@ -402,7 +467,9 @@ func (b *Builder) Run(ctx context.Context, ui packersdk.Ui, hook packersdk.Hook)
 			AWSSession:       session,
 			Region:           *ec2conn.Config.Region,
 			PauseBeforeSSM:   b.config.PauseBeforeSSM,
+			PollingConfig:    b.config.PollingConfig,
 			LocalPortNumber:  b.config.SessionManagerPort,
+			LocalPortRange:   b.config.SessionManagerPortRange,
 			RemotePortNumber: b.config.Comm.Port(),
 			SSMAgentEnabled:  b.config.SSMAgentEnabled(),
 			SSHConfig:        &b.config.Comm.SSH,
@@ -426,6 +493,9 @@ func (b *Builder) Run(ctx context.Context, ui packersdk.Ui, hook packersdk.Hook)
 			GeneratedData: generatedData,
 		},
 		&commonsteps.StepProvision{},
+		&awscommon.StepWaitForCloudInit{
+			Skip: !b.config.WaitForCloudInit,
+		},
 		&commonsteps.StepCleanupTempKeys{
 			Comm: &b.config.RunConfig.Comm,
 		},
@@ -459,56 +529,52 @@ func (b *Builder) Run(ctx context.Context, ui packersdk.Ui, hook packersdk.Hook)
 			Name:                           b.config.AMIName,
 			OriginalRegion:                 *ec2conn.Config.Region,
 			AMISnapshotCopyDurationMinutes: b.config.AMISnapshotCopyDurationMinutes,
+			IgnoreCopyErrors:               b.config.AMIIgnoreCopyErrors,
+			RegionVolumeTypes:              b.config.AMIRegionVolumeTypes,
+			GeneratedData:                  generatedData,
 		},
 		&awscommon.StepEnableDeprecation{
-			AccessConfig:    &b.config.AccessConfig,
-			DeprecationTime: b.config.DeprecationTime,
+			AccessConfig:          &b.config.AccessConfig,
+			DeprecationTime:       b.config.DeprecationTime,
+			DeprecatePreviousTags: b.config.DeprecatePreviousTags,
 		},
 		&awscommon.StepEnableDeregistrationProtection{
 			AccessConfig:             &b.config.AccessConfig,
 			DeregistrationProtection: &b.config.DeregistrationProtection,
 		},
+		&awscommon.StepCentralAccountCopy{
+			AccessConfig:  &b.config.AccessConfig,
+			Role:          b.config.AMICentralAccountRole,
+			KmsKeyId:      b.config.AMICentralAccountKmsKeyId,
+			Name:          b.config.AMIName,
+			Region:        *ec2conn.Config.Region,
+			PollingConfig: b.config.PollingConfig,
+			GeneratedData: generatedData,
+		},
 		&awscommon.StepModifyAMIAttributes{
-			Description:    b.config.AMIDescription,
-			Users:          b.config.AMIUsers,
-			Groups:         b.config.AMIGroups,
-			OrgArns:        b.config.AMIOrgArns,
-			OuArns:         b.config.AMIOuArns,
-			ProductCodes:   b.config.AMIProductCodes,
-			SnapshotUsers:  b.config.SnapshotUsers,
-			SnapshotGroups: b.config.SnapshotGroups,
-			IMDSSupport:    b.config.AMIIMDSSupport,
-			Ctx:            b.config.ctx,
-			GeneratedData:  generatedData,
+			Description:       b.config.AMIDescription,
+			Users:             b.config.AMIUsers,
+			UsersSSMParameter: b.config.AMIUsersSSMParameter,
+			Groups:            b.config.AMIGroups,
+			OrgArns:           b.config.AMIOrgArns,
+			OuArns:            b.config.AMIOuArns,
+			ProductCodes:      b.config.AMIProductCodes,
+			ShareDuration:     b.config.AMIShareDuration,
+			SnapshotUsers:     b.config.SnapshotUsers,
+			SnapshotGroups:    b.config.SnapshotGroups,
+			WaitForSnapshots:  b.config.AMIWaitForSnapshots,
+			PollingConfig:     b.config.PollingConfig,
+			IMDSSupport:       b.config.AMIIMDSSupport,
+			Ctx:               b.config.ctx,
+			GeneratedData:     generatedData,
 		},
 		&awscommon.StepCreateTags{
-			Tags:         b.config.AMITags,
-			SnapshotTags: b.config.SnapshotTags,
-			Ctx:          b.config.ctx,
+			Tags:           b.config.AMITags,
+			SnapshotTags:   b.config.SnapshotTags,
+			TagCopyRegions: b.config.AMITagCopyRegions,
+			Ctx:            b.config.ctx,
 		},
 	}
 
-	// Run!
-	b.runner = commonsteps.NewRunner(steps, b.config.PackerConfig, ui)
-	b.runner.Run(ctx, state)
-
-	// If there was an error, return that
-	if rawErr, ok := state.GetOk("error"); ok {
-		return nil, rawErr.(error)
-	}
-
-	// If there are no AMIs, then just return
-	if _, ok := state.GetOk("amis"); !ok {
-		return nil, nil
-	}
-
-	// Build the artifact and return it
-	artifact := &awscommon.Artifact{
-		Amis:           state.Get("amis").(map[string]string),
-		BuilderIdValue: BuilderId,
-		Session:        session,
-		StateData:      map[string]interface{}{"generated_data": state.Get("generated_data")},
-	}
-
-	return artifact, nil
+	return steps
 }
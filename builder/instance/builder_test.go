@@ -8,7 +8,10 @@ import (
 	"os"
 	"testing"
 
+	"github.com/aws/aws-sdk-go/service/ec2"
+	awscommon "github.com/hashicorp/packer-plugin-amazon/builder/common"
 	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
+	"github.com/hashicorp/packer-plugin-sdk/packerbuilderdata"
 )
 
 func testConfig() (config map[string]interface{}, tf *os.File) {
@@ -440,3 +443,32 @@ func TestBuilderPrepare_TpmSupportValue(t *testing.T) {
 		})
 	}
 }
+
+func TestBuilder_WaitForCloudInitBeforeStop(t *testing.T) {
+	var b Builder
+	config, tempfile := testConfig()
+	defer os.Remove(tempfile.Name())
+	defer tempfile.Close()
+	config["wait_for_cloud_init"] = true
+	if _, _, err := b.Prepare(config); err != nil {
+		t.Fatalf("should not have error: %s", err)
+	}
+
+	// The steps only read the region of the session.
+	b.config.AccessConfig = *awscommon.FakeAccessConfig()
+
+	sess := awscommon.FakeSession()
+	steps := b.buildSteps(sess, ec2.New(sess), &packerbuilderdata.GeneratedData{})
+	wait, stop := -1, -1
+	for i, step := range steps {
+		switch step.(type) {
+		case *awscommon.StepWaitForCloudInit:
+			wait = i
+		case *StepBundleVolume:
+			stop = i
+		}
+	}
+	if wait < 0 || stop < 0 || wait > stop {
+		t.Fatalf("cloud-init should be waited for before the volume is bundled, got steps %d and %d", wait, stop)
+	}
+}
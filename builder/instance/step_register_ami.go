@@ -72,6 +72,9 @@ func (s *StepRegisterAMI) Run(ctx context.Context, state multistep.StateBag) mul
 	if s.TpmSupport != "" {
 		registerOpts.TpmSupport = aws.String(s.TpmSupport)
 	}
+	if len(config.AMIBillingProducts) > 0 {
+		registerOpts.BillingProducts = aws.StringSlice(config.AMIBillingProducts)
+	}
 
 	registerResp, err := ec2conn.RegisterImage(registerOpts)
 	if err != nil {
@@ -236,13 +236,7 @@ func (c *AMIConfig) Prepare(accessConfig *AccessConfig, ctx *interpolate.Context
 
 	// Make sure that if we have region_kms_key_ids defined,
 	// the regions in region_kms_key_ids are also in ami_regions
-	if len(c.AMIRegionKMSKeyIDs) > 0 {
-		for kmsKeyRegion := range c.AMIRegionKMSKeyIDs {
-			if !stringInSlice(c.AMIRegions, kmsKeyRegion) {
-				errs = append(errs, fmt.Errorf("Region %s is in region_kms_key_ids but not in ami_regions", kmsKeyRegion))
-			}
-		}
-	}
+	errs = append(errs, validateRegionKMSKeyIDs(c.AMIRegions, c.AMIRegionKMSKeyIDs)...)
 
 	errs = append(errs, c.prepareRegions(accessConfig)...)
 
@@ -337,37 +331,68 @@ func (c *AMIConfig) Prepare(accessConfig *AccessConfig, ctx *interpolate.Context
 
 func (c *AMIConfig) prepareRegions(accessConfig *AccessConfig) (errs []error) {
 	if len(c.AMIRegions) > 0 {
-		regionSet := make(map[string]struct{})
-		regions := make([]string, 0, len(c.AMIRegions))
+		c.AMIRegions, errs = dedupeRegions(accessConfig, c.AMIRegions, c.AMIRegionKMSKeyIDs)
+	}
+	return errs
+}
 
-		for _, region := range c.AMIRegions {
-			// If we already saw the region, then don't look again
-			if _, ok := regionSet[region]; ok {
-				continue
-			}
+// PrepareCopyRegions validates the regions an AMI is copied to, given with
+// the KMS keys to encrypt the copies with in each of them, if any. It
+// returns the regions without duplicates nor the region of accessConfig, in
+// which the AMI is created.
+func PrepareCopyRegions(accessConfig *AccessConfig, regions []string, regionKMSKeyIDs map[string]string) ([]string, []error) {
+	errs := validateRegionKMSKeyIDs(regions, regionKMSKeyIDs)
+	if len(regions) == 0 {
+		return regions, errs
+	}
+	regions, regionErrs := dedupeRegions(accessConfig, regions, regionKMSKeyIDs)
+	return regions, append(errs, regionErrs...)
+}
+
+// validateRegionKMSKeyIDs checks that the regions of regionKMSKeyIDs are
+// also in regions.
+func validateRegionKMSKeyIDs(regions []string, regionKMSKeyIDs map[string]string) (errs []error) {
+	for kmsKeyRegion := range regionKMSKeyIDs {
+		if !stringInSlice(regions, kmsKeyRegion) {
+			errs = append(errs, fmt.Errorf("Region %s is in region_kms_key_ids but not in ami_regions", kmsKeyRegion))
+		}
+	}
+	return errs
+}
 
-			// Mark that we saw the region
-			regionSet[region] = struct{}{}
+// dedupeRegions drops the duplicates of regions and the region of
+// accessConfig, and checks that each region has a KMS key in
+// regionKMSKeyIDs, if any are given.
+func dedupeRegions(accessConfig *AccessConfig, regions []string, regionKMSKeyIDs map[string]string) ([]string, []error) {
+	var errs []error
+	regionSet := make(map[string]struct{})
+	deduped := make([]string, 0, len(regions))
 
-			// Make sure that if we have region_kms_key_ids defined,
-			// the regions in ami_regions are also in region_kms_key_ids
-			if len(c.AMIRegionKMSKeyIDs) > 0 {
-				if _, ok := c.AMIRegionKMSKeyIDs[region]; !ok {
-					errs = append(errs, fmt.Errorf("Region %s is in ami_regions but not in region_kms_key_ids", region))
-				}
-			}
-			if (accessConfig != nil) && (region == accessConfig.RawRegion) {
-				// make sure we don't try to copy to the region we originally
-				// create the AMI in.
-				log.Printf("Cannot copy AMI to AWS session region '%s', deleting it from `ami_regions`.", region)
-				continue
-			}
-			regions = append(regions, region)
+	for _, region := range regions {
+		// If we already saw the region, then don't look again
+		if _, ok := regionSet[region]; ok {
+			continue
 		}
 
-		c.AMIRegions = regions
+		// Mark that we saw the region
+		regionSet[region] = struct{}{}
+
+		// Make sure that if we have region_kms_key_ids defined,
+		// the regions in ami_regions are also in region_kms_key_ids
+		if len(regionKMSKeyIDs) > 0 {
+			if _, ok := regionKMSKeyIDs[region]; !ok {
+				errs = append(errs, fmt.Errorf("Region %s is in ami_regions but not in region_kms_key_ids", region))
+			}
+		}
+		if (accessConfig != nil) && (region == accessConfig.RawRegion) {
+			// make sure we don't try to copy to the region we originally
+			// create the AMI in.
+			log.Printf("Cannot copy AMI to AWS session region '%s', deleting it from `ami_regions`.", region)
+			continue
+		}
+		deduped = append(deduped, region)
 	}
-	return errs
+	return deduped, errs
 }
 
 // See https://docs.aws.amazon.com/AWSEC2/latest/APIReference/API_CopyImage.html
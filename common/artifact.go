@@ -31,15 +31,18 @@ type Artifact struct {
 
 	// EC2 config for performing API stuff.
 	Config *aws.Config
+
+	// LocalFiles are files written along with the AMIs, such as the
+	// checksums of an imported disk.
+	LocalFiles []string
 }
 
 func (a *Artifact) BuilderId() string {
 	return a.BuilderIdValue
 }
 
-func (*Artifact) Files() []string {
-	// We have no files
-	return nil
+func (a *Artifact) Files() []string {
+	return a.LocalFiles
 }
 
 func (a *Artifact) Id() string {
@@ -111,6 +114,18 @@ func (a *Artifact) Destroy() error {
 
 	}
 
+	// The launch template of the metadata options of the imported AMI is
+	// part of the artifact, in the region of the import.
+	if templateId, ok := a.StateData["imds_launch_template_id"].(string); ok && templateId != "" {
+		log.Printf("Deleting launch template (%s) from region (%s)", templateId, a.Config.Region)
+		_, err := ec2.NewFromConfig(*a.Config).DeleteLaunchTemplate(ctx, &ec2.DeleteLaunchTemplateInput{
+			LaunchTemplateId: aws.String(templateId),
+		})
+		if err != nil {
+			errors = append(errors, err)
+		}
+	}
+
 	if len(errors) > 0 {
 		if len(errors) == 1 {
 			return errors[0]
@@ -132,6 +147,23 @@ func (a *Artifact) stateAtlasMetadata() interface{} {
 	return metadata
 }
 
+// ArtifactAMIs returns the AMIs of artifact by region, as the artifacts of
+// the Amazon builders describe them in their atlas.artifact.metadata state,
+// or nil for other artifacts.
+func ArtifactAMIs(artifact packersdk.Artifact) map[string]string {
+	metadata, ok := artifact.State("atlas.artifact.metadata").(map[string]string)
+	if !ok {
+		return nil
+	}
+	amis := make(map[string]string)
+	for k, amiId := range metadata {
+		if region, ok := strings.CutPrefix(k, "region."); ok {
+			amis[region] = amiId
+		}
+	}
+	return amis
+}
+
 // stateHCPPackerRegistryMetadata will write the metadata as an hcpRegistryImage for each of the AMIs
 // present in this artifact.
 func (a *Artifact) stateHCPPackerRegistryMetadata() interface{} {
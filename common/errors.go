@@ -9,14 +9,20 @@ import (
 	"fmt"
 	"log"
 	"regexp"
+	"slices"
 
 	"github.com/aws/smithy-go/middleware"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
 	"github.com/aws/aws-sdk-go-v2/service/sts"
 	"github.com/aws/smithy-go"
 )
 
+// Error codes of server side failures, on top of the ones the SDK retries,
+// which can come without HTTP status, for instance from EC2 query errors.
+var serverErrorCodes = []string{"InternalError", "InternalFailure", "ServiceUnavailable", "Unavailable"}
+
 var encodedFailureMessagePattern = regexp.MustCompile(`(?i)(.*) Encoded authorization failure message: ([\w-]+) ?( .*)?`)
 
 type stsDecoder interface {
@@ -78,3 +84,25 @@ func (a *authZMessageDecoder) middleware(
 
 	return out, metadata, err
 }
+
+// IsRetryableAWSErr returns whether err is transient: the request was
+// throttled, failed on the side of AWS, or its connection failed. Client
+// errors, such as invalid parameters or missing permissions, are not, and
+// won't succeed when retried.
+func IsRetryableAWSErr(err error) bool {
+	if err == nil {
+		return false
+	}
+	if retry.IsErrorThrottles(retry.DefaultThrottles).IsErrorThrottle(err).Bool() {
+		return true
+	}
+	if retry.IsErrorRetryables(retry.DefaultRetryables).IsErrorRetryable(err).Bool() {
+		return true
+	}
+
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.ErrorFault() == smithy.FaultServer || slices.Contains(serverErrorCodes, apiErr.ErrorCode())
+	}
+	return false
+}
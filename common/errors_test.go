@@ -94,3 +94,40 @@ func TestErrorsParsing_NonAWSError(t *testing.T) {
 		t.Error("Expected original error to be returned unchanged")
 	}
 }
+
+func TestIsRetryableAWSErr(t *testing.T) {
+	statusErr := func(status int, err error) error {
+		return &smithy.OperationError{
+			ServiceID:     "EC2",
+			OperationName: "ImportImage",
+			Err: &smithyhttp.ResponseError{
+				Response: &smithyhttp.Response{Response: &http.Response{StatusCode: status}},
+				Err:      err,
+			},
+		}
+	}
+
+	tests := []struct {
+		name      string
+		err       error
+		retryable bool
+	}{
+		{"no error", nil, false},
+		{"request limit", &smithy.GenericAPIError{Code: "RequestLimitExceeded"}, true},
+		{"throttling", &smithy.GenericAPIError{Code: "Throttling"}, true},
+		{"internal error", &smithy.GenericAPIError{Code: "InternalError"}, true},
+		{"server fault", &smithy.GenericAPIError{Code: "Whatever", Fault: smithy.FaultServer}, true},
+		{"service unavailable", statusErr(503, &smithy.GenericAPIError{Code: "Unknown"}), true},
+		{"invalid parameter", statusErr(400, &smithy.GenericAPIError{Code: "InvalidParameter", Fault: smithy.FaultClient}), false},
+		{"missing role", &smithy.GenericAPIError{Code: "InvalidParameter", Message: "The service role vmimport does not exist"}, false},
+		{"unauthorized", &smithy.GenericAPIError{Code: "UnauthorizedOperation", Fault: smithy.FaultClient}, false},
+		{"other error", fmt.Errorf("not an AWS error"), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if retryable := IsRetryableAWSErr(tt.err); retryable != tt.retryable {
+				t.Fatalf("expected retryable to be %t, got %t for %v", tt.retryable, retryable, tt.err)
+			}
+		})
+	}
+}
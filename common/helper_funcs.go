@@ -7,10 +7,14 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"net/url"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
 	"github.com/hashicorp/packer-plugin-amazon/builder/common/awserrors"
 	"github.com/hashicorp/packer-plugin-sdk/retry"
 )
@@ -75,3 +79,100 @@ func DestroyAMIs(imageIds []string, client Ec2Client) error {
 	}
 	return nil
 }
+
+// maxImageNameLength is the longest name an AMI can have.
+const maxImageNameLength = 128
+
+// ReplaceImage replaces the AMI imageId with one of the same name, registered
+// by register, and returns the ID of the new AMI. AMI names are unique, so the
+// new AMI is first registered under a temporary name: imageId is only
+// deregistered once a replacement exists, and the temporary AMI is only
+// deregistered once the new one exists. register must register the AMI under
+// name and wait for it to be available, deregister must keep the snapshots of
+// the AMI, which the new one is registered from. When the registration under
+// name fails, the ID of the temporary AMI, left in place of imageId, is
+// returned along with the error for the caller to clean it up.
+func ReplaceImage(imageId, name string, register func(name string) (string, error), deregister func(imageId string) error) (string, error) {
+	tempName := fmt.Sprintf("packer-%d-", time.Now().UnixNano())
+	tempName += name[:min(len(name), maxImageNameLength-len(tempName))]
+	tempId, err := register(tempName)
+	if err != nil {
+		return "", fmt.Errorf("Error registering AMI, %s was kept: %s", imageId, err)
+	}
+
+	if err := deregister(imageId); err != nil {
+		if err := deregister(tempId); err != nil {
+			log.Printf("[WARN] Failed to deregister the temporary AMI %s: %s", tempId, err)
+		}
+		return "", fmt.Errorf("Error deregistering AMI (%s): %s", imageId, err)
+	}
+
+	newId, err := register(name)
+	if err != nil {
+		return tempId, fmt.Errorf("Error registering AMI, it was kept as %s (%s): %s", tempId, tempName, err)
+	}
+	if err := deregister(tempId); err != nil {
+		log.Printf("[WARN] Failed to deregister the temporary AMI %s: %s", tempId, err)
+	}
+	return newId, nil
+}
+
+// CheckRegisterAgain returns an error if image can't be registered again
+// from its snapshots. RegisterImage doesn't carry over its platform and usage
+// operation, so an AMI billed with a license, such as the one of Windows,
+// would lose it.
+func CheckRegisterAgain(image types.Image) error {
+	usage := aws.ToString(image.UsageOperation)
+	if usage == "" || usage == "RunInstances" {
+		return nil
+	}
+	return fmt.Errorf("AMI %s is billed as %s (%s), which registering it again from its snapshots would lose",
+		aws.ToString(image.ImageId), aws.ToString(image.PlatformDetails), usage)
+}
+
+// ReregisterImage registers input in place of the AMI imageId, see
+// ReplaceImage, and returns the ID of the new AMI.
+func ReregisterImage(ctx context.Context, client Ec2Client, pollingConfig *AWSPollingConfig, imageId string, input *ec2.RegisterImageInput) (string, error) {
+	register := func(name string) (string, error) {
+		params := *input
+		params.Name = aws.String(name)
+		resp, err := client.RegisterImage(ctx, &params)
+		if err != nil {
+			return "", err
+		}
+		newImageId := aws.ToString(resp.ImageId)
+		if err := pollingConfig.WaitUntilAMIAvailable(ctx, client, newImageId); err != nil {
+			return "", fmt.Errorf("Error waiting for AMI (%s): %s", newImageId, err)
+		}
+		return newImageId, nil
+	}
+	deregister := func(imageId string) error {
+		_, err := client.DeregisterImage(ctx, &ec2.DeregisterImageInput{ImageId: aws.String(imageId)})
+		return err
+	}
+	return ReplaceImage(imageId, aws.ToString(input.Name), register, deregister)
+}
+
+// UnshareAfterTagKey is the tag recording when the sharing of an AMI should
+// be revoked, for external automation to do so.
+const UnshareAfterTagKey = "unshare-after"
+
+// S3Tagging encodes tags as the query string S3 expects in the Tagging of an
+// upload. Spaces are encoded as %20, S3 doesn't read + as a space.
+func S3Tagging(tags map[string]string) string {
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, 0, len(keys))
+	for _, k := range keys {
+		pairs = append(pairs, s3TagEscape(k)+"="+s3TagEscape(tags[k]))
+	}
+	return strings.Join(pairs, "&")
+}
+
+func s3TagEscape(s string) string {
+	return strings.ReplaceAll(url.QueryEscape(s), "+", "%20")
+}
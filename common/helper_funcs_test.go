@@ -0,0 +1,94 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package common
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReplaceImage(t *testing.T) {
+	tests := []struct {
+		name       string
+		failCall   string
+		expectedId string
+		expected   []string
+	}{
+		{
+			name:       "success",
+			expectedId: "ami-2",
+			expected:   []string{"register temporary", "deregister ami-old", "register my-ami", "deregister ami-1"},
+		},
+		{
+			name:     "temporary registration fails",
+			failCall: "register temporary",
+			expected: []string{"register temporary"},
+		},
+		{
+			name:     "deregistration fails",
+			failCall: "deregister ami-old",
+			expected: []string{"register temporary", "deregister ami-old", "deregister ami-1"},
+		},
+		{
+			name:       "registration fails",
+			failCall:   "register my-ami",
+			expectedId: "ami-1",
+			expected:   []string{"register temporary", "deregister ami-old", "register my-ami"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls []string
+			registered := 0
+			register := func(name string) (string, error) {
+				if strings.HasPrefix(name, "packer-") && strings.HasSuffix(name, "-my-ami") {
+					name = "temporary"
+				}
+				calls = append(calls, "register "+name)
+				if calls[len(calls)-1] == tt.failCall {
+					return "", fmt.Errorf("failed")
+				}
+				registered++
+				return fmt.Sprintf("ami-%d", registered), nil
+			}
+			deregister := func(imageId string) error {
+				calls = append(calls, "deregister "+imageId)
+				if calls[len(calls)-1] == tt.failCall {
+					return fmt.Errorf("failed")
+				}
+				return nil
+			}
+
+			imageId, err := ReplaceImage("ami-old", "my-ami", register, deregister)
+			if (err != nil) != (tt.failCall != "") {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if imageId != tt.expectedId {
+				t.Fatalf("expected AMI %q, got %q", tt.expectedId, imageId)
+			}
+			if !reflect.DeepEqual(calls, tt.expected) {
+				t.Fatalf("expected calls %v, got %v", tt.expected, calls)
+			}
+			// The AMI kept when the registration under its name fails is
+			// named in the error.
+			if tt.failCall == "register my-ami" && !strings.Contains(err.Error(), "kept as ami-1") {
+				t.Fatalf("the error should name the temporary AMI, got %s", err)
+			}
+		})
+	}
+}
+
+func TestS3Tagging(t *testing.T) {
+	got := S3Tagging(map[string]string{
+		"team":        "platform",
+		"cost center": "a=b&c",
+		"expires":     "2026-01-01 00:00+01:00",
+	})
+	want := "cost%20center=a%3Db%26c&expires=2026-01-01%2000%3A00%2B01%3A00&team=platform"
+	if got != want {
+		t.Fatalf("unexpected tagging %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,142 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package common
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// PolicyStatement is a statement of an IAM policy or of a KMS key policy.
+// Only the elements Packer evaluates are read.
+type PolicyStatement struct {
+	Sid       string
+	Effect    string
+	Principal interface{}
+	Action    PolicyStrings
+	Resource  PolicyStrings
+	Condition map[string]map[string]PolicyStrings
+}
+
+// PrincipalValues returns the principals of kind, such as `AWS` or `Service`,
+// of the statement. The `*` principal, anyone, is returned for any kind.
+func (s *PolicyStatement) PrincipalValues(kind string) PolicyStrings {
+	switch principal := s.Principal.(type) {
+	case string:
+		if principal == "*" {
+			return PolicyStrings{principal}
+		}
+	case map[string]interface{}:
+		switch v := principal[kind].(type) {
+		case string:
+			return PolicyStrings{v}
+		case []interface{}:
+			var values PolicyStrings
+			for _, value := range v {
+				if value, ok := value.(string); ok {
+					values = append(values, value)
+				}
+			}
+			return values
+		}
+	}
+	return nil
+}
+
+// PolicyStatements unmarshals the statements of a policy, which may be a
+// single statement or a list of them.
+type PolicyStatements []PolicyStatement
+
+func (s *PolicyStatements) UnmarshalJSON(data []byte) error {
+	var statement PolicyStatement
+	if err := json.Unmarshal(data, &statement); err == nil {
+		*s = PolicyStatements{statement}
+		return nil
+	}
+	var statements []PolicyStatement
+	if err := json.Unmarshal(data, &statements); err != nil {
+		return err
+	}
+	*s = statements
+	return nil
+}
+
+// PolicyStrings unmarshals the values of a policy element, which may be a
+// single value or a list of them. Condition values may be booleans or
+// numbers, which are kept as they are written.
+type PolicyStrings []string
+
+func (s *PolicyStrings) UnmarshalJSON(data []byte) error {
+	var value interface{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&value); err != nil {
+		return err
+	}
+	if value == nil {
+		*s = nil
+		return nil
+	}
+	values, ok := value.([]interface{})
+	if !ok {
+		values = []interface{}{value}
+	}
+	strs := make(PolicyStrings, 0, len(values))
+	for _, v := range values {
+		switch v := v.(type) {
+		case string:
+			strs = append(strs, v)
+		case bool, json.Number:
+			strs = append(strs, fmt.Sprint(v))
+		default:
+			return fmt.Errorf("unexpected policy value: %s", data)
+		}
+	}
+	*s = strs
+	return nil
+}
+
+// MatchesAny reports whether any of the patterns, which may hold `*` and `?`
+// wildcards, matches any of names. Like in policies, `*` also matches `/`,
+// and matching is case insensitive.
+func (s PolicyStrings) MatchesAny(names ...string) bool {
+	for _, pattern := range s {
+		for _, name := range names {
+			if wildcardMatch(strings.ToLower(pattern), strings.ToLower(name)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// wildcardMatch reports whether name matches pattern, where `*` matches any
+// sequence of characters and `?` any single character.
+func wildcardMatch(pattern, name string) bool {
+	p, n := 0, 0
+	// The position of the last `*` of the pattern, and of the character of
+	// name it is matched up to, to backtrack to.
+	star, matched := -1, 0
+	for n < len(name) {
+		switch {
+		case p < len(pattern) && (pattern[p] == '?' || pattern[p] == name[n]):
+			p++
+			n++
+		case p < len(pattern) && pattern[p] == '*':
+			star, matched = p, n
+			p++
+		case star >= 0:
+			matched++
+			p, n = star+1, matched
+		default:
+			return false
+		}
+	}
+	for p < len(pattern) && pattern[p] == '*' {
+		p++
+	}
+	return p == len(pattern)
+}
@@ -0,0 +1,91 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package common
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestPolicyStrings_MatchesAny(t *testing.T) {
+	tests := []struct {
+		patterns PolicyStrings
+		name     string
+		expected bool
+	}{
+		{PolicyStrings{"ec2:RegisterImage"}, "ec2:RegisterImage", true},
+		{PolicyStrings{"EC2:registerimage"}, "ec2:RegisterImage", true},
+		{PolicyStrings{"ec2:*"}, "ec2:RegisterImage", true},
+		{PolicyStrings{"ec2:Register?mage"}, "ec2:RegisterImage", true},
+		{PolicyStrings{"*"}, "s3:GetObject", true},
+		{PolicyStrings{"ec2:Describe*"}, "ec2:RegisterImage", false},
+		{PolicyStrings{"ec2:Register?"}, "ec2:RegisterImage", false},
+		{PolicyStrings{"s3:GetObject", "ec2:*Image"}, "ec2:RegisterImage", true},
+		{PolicyStrings{"arn:aws:s3:::bucket/*"}, "arn:aws:s3:::bucket/disks/disk.vmdk", true},
+		{PolicyStrings{"arn:aws:s3:::*"}, "arn:aws:s3:::bucket/disk.vmdk", true},
+		{PolicyStrings{"arn:aws:s3:::*/*.vmdk"}, "arn:aws:s3:::bucket/disk.raw", false},
+		{PolicyStrings{"a*b*c"}, "axxbyyc", true},
+		{PolicyStrings{"a*b*c"}, "axxbyy", false},
+		{nil, "ec2:RegisterImage", false},
+	}
+	for _, tt := range tests {
+		if got := tt.patterns.MatchesAny(tt.name); got != tt.expected {
+			t.Errorf("%v matching %s: expected %t, got %t", tt.patterns, tt.name, tt.expected, got)
+		}
+	}
+}
+
+func TestPolicyStatements_UnmarshalJSON(t *testing.T) {
+	var single struct{ Statement PolicyStatements }
+	if err := json.Unmarshal([]byte(`{"Statement": {"Effect": "Allow", "Action": "ec2:*", "Principal": "*"}}`), &single); err != nil {
+		t.Fatalf("should not have error: %s", err)
+	}
+	if len(single.Statement) != 1 || !single.Statement[0].Action.MatchesAny("ec2:CopyImage") {
+		t.Fatalf("unexpected statements: %v", single.Statement)
+	}
+	if principals := single.Statement[0].PrincipalValues("Service"); len(principals) != 1 || principals[0] != "*" {
+		t.Fatalf("expected anyone as principal, got %v", principals)
+	}
+
+	var list struct{ Statement PolicyStatements }
+	err := json.Unmarshal([]byte(`{"Statement": [
+		{"Effect": "Allow", "Action": ["sts:AssumeRole"], "Principal": {"Service": ["vmie.amazonaws.com", "ec2.amazonaws.com"]}},
+		{"Effect": "Deny", "Action": "kms:*", "Principal": {"AWS": "arn:aws:iam::123456789012:root"}}
+	]}`), &list)
+	if err != nil {
+		t.Fatalf("should not have error: %s", err)
+	}
+	if len(list.Statement) != 2 {
+		t.Fatalf("expected 2 statements, got %v", list.Statement)
+	}
+	if !list.Statement[0].PrincipalValues("Service").MatchesAny("ec2.amazonaws.com") {
+		t.Fatalf("expected the EC2 service principal, got %v", list.Statement[0].Principal)
+	}
+	if principals := list.Statement[1].PrincipalValues("Service"); principals != nil {
+		t.Fatalf("expected no service principal, got %v", principals)
+	}
+}
+
+func TestPolicyStatements_UnmarshalJSONConditions(t *testing.T) {
+	var policy struct{ Statement PolicyStatements }
+	err := json.Unmarshal([]byte(`{"Statement": {"Effect": "Allow", "Action": "kms:CreateGrant", "Resource": "*",
+		"Condition": {
+			"Bool": {"kms:GrantIsForAWSResource": true},
+			"NumericLessThan": {"aws:MultiFactorAuthAge": 3600},
+			"StringEquals": {"kms:ViaService": ["ec2.us-east-1.amazonaws.com"]}
+		}}}`), &policy)
+	if err != nil {
+		t.Fatalf("should not have error: %s", err)
+	}
+	conditions := policy.Statement[0].Condition
+	if values := conditions["Bool"]["kms:GrantIsForAWSResource"]; len(values) != 1 || values[0] != "true" {
+		t.Errorf("expected the boolean condition value true, got %v", values)
+	}
+	if values := conditions["NumericLessThan"]["aws:MultiFactorAuthAge"]; len(values) != 1 || values[0] != "3600" {
+		t.Errorf("expected the numeric condition value 3600, got %v", values)
+	}
+	if values := conditions["StringEquals"]["kms:ViaService"]; len(values) != 1 || values[0] != "ec2.us-east-1.amazonaws.com" {
+		t.Errorf("expected the string condition value, got %v", values)
+	}
+}
@@ -7,14 +7,18 @@ package common
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
+	"math/rand"
 	"os"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	ec2types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/hashicorp/packer-plugin-sdk/multistep"
 )
@@ -37,6 +41,76 @@ type StateChangeConf struct {
 	Refresh   StateRefreshFunc
 	StepState multistep.StateBag
 	Target    string
+	// PollingConfig sets the delay between refreshes and how long to wait
+	// for the target state. If nil, only the environment variables apply.
+	PollingConfig *AWSPollingConfig
+}
+
+// The delay between refreshes of WaitForState when neither the polling
+// config nor the environment set one.
+const defaultStateRefreshDelay = 2 * time.Second
+
+// WaitForState refreshes the state until it becomes Target, and returns the
+// last result of Refresh. It fails on a state that is neither pending nor
+// the target, once the wait time or the timeout of the polling config is
+// over, or when ctx is done or the step state is cancelled.
+func (conf *StateChangeConf) WaitForState(ctx context.Context) (any, error) {
+	log.Printf("Waiting for state to become: %s", conf.Target)
+
+	pollingConfig := conf.PollingConfig
+	if pollingConfig == nil {
+		pollingConfig = new(AWSPollingConfig)
+	}
+
+	var result any
+	err := pollingConfig.withTimeout(ctx, func(ctx context.Context) error {
+		var err error
+		result, err = conf.waitForState(ctx, pollingConfig.getWaiterOptions())
+		return err
+	})
+	return result, err
+}
+
+func (conf *StateChangeConf) waitForState(ctx context.Context, opts *PollingOptions) (any, error) {
+	delay := defaultStateRefreshDelay
+	if opts.MinDelay > 0 {
+		delay = opts.MinDelay
+	}
+	var timeout <-chan time.Time
+	if opts.MaxWaitTime > 0 {
+		timer := time.NewTimer(opts.MaxWaitTime)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	for {
+		result, state, err := conf.Refresh()
+		if err != nil {
+			return nil, err
+		}
+		if state == conf.Target {
+			return result, nil
+		}
+		if !slices.Contains(conf.Pending, state) {
+			return nil, fmt.Errorf("unexpected state '%s', wanted target '%s'", state, conf.Target)
+		}
+
+		if conf.StepState != nil {
+			if _, ok := conf.StepState.GetOk(multistep.StateCancelled); ok {
+				return nil, errors.New("interrupted")
+			}
+		}
+
+		log.Printf("Waiting for state to become: %s currently %s (%s)", conf.Target, state, delay)
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-timeout:
+			return nil, fmt.Errorf("timeout after %s while waiting for state to become '%s', last state '%s'",
+				opts.MaxWaitTime, conf.Target, state)
+		case <-time.After(JitterDelay(delay, opts.Jitter)):
+		}
+	}
 }
 
 type envInfo struct {
@@ -46,14 +120,20 @@ type envInfo struct {
 }
 
 type overridableWaitVars struct {
-	awsPollDelaySeconds envInfo
-	awsMaxAttempts      envInfo
-	awsTimeoutSeconds   envInfo
+	awsPollDelaySeconds  envInfo
+	awsMaxAttempts       envInfo
+	awsTimeoutSeconds    envInfo
+	awsPollJitterSeconds envInfo
 }
 
+// ImportTaskObserver is called with the latest state of an import task every
+// time it is polled while waiting for the import to complete.
+type ImportTaskObserver func(ctx context.Context, task ec2types.ImportImageTask)
+
 type PollingOptions struct {
 	MaxWaitTime time.Duration
 	MinDelay    time.Duration
+	Jitter      time.Duration
 }
 
 // Following are wrapper functions that use Packer's environment-variables to
@@ -94,6 +174,17 @@ type AWSPollingConfig struct {
 	// If both option and environment variable are set, the delay_seconds will be considered over the AWS_POLL_DELAY_SECONDS.
 	// If none is set, defaults to AWS waiter default which is 15 seconds.
 	DelaySeconds int `mapstructure:"delay_seconds" required:"false"`
+	// Specifies the maximum wall-clock time to wait for the resource state, such as `90m`,
+	// whatever the number of attempts. The wait fails with a "polling timed out" error once it is over.
+	// If none is set, the wait is only limited by max_attempts and delay_seconds. Can't be negative.
+	Timeout time.Duration `mapstructure:"timeout" required:"false"`
+	// Specifies the maximum jitter in seconds applied to the delay between attempts.
+	// Each attempt waits for the delay plus or minus a random duration of up to jitter_seconds,
+	// so that concurrent builds don't poll the AWS API in lockstep and get throttled.
+	// This value can also be set via the AWS_POLL_JITTER_SECONDS.
+	// If both option and environment variable are set, the jitter_seconds will be considered over the AWS_POLL_JITTER_SECONDS.
+	// If none is set, defaults to 0, no jitter.
+	JitterSeconds int `mapstructure:"jitter_seconds" required:"false"`
 }
 
 // This helper function uses the environment variables AWS_TIMEOUT_SECONDS and
@@ -114,10 +205,59 @@ type AWSPollingConfig struct {
 // if AWS_MAX_ATTEMPTS is set but AWS_POLL_DELAY_SECONDS is not, then we will
 // use waiter-specific defaults.
 
+// ErrPollingTimeout is returned, wrapped, by the waits that went on for
+// longer than the timeout of their AWSPollingConfig.
+var ErrPollingTimeout = errors.New("polling timed out")
+
+// withTimeout calls wait bounded by the timeout of the config, see
+// WithPollingTimeout.
+func (w *AWSPollingConfig) withTimeout(ctx context.Context, wait func(ctx context.Context) error) error {
+	return WithPollingTimeout(ctx, w.Timeout, wait)
+}
+
+// WithPollingTimeout calls wait with ctx bounded by timeout, if positive, and
+// reports a wait cut short by the timeout with ErrPollingTimeout rather than
+// the error of wait.
+func WithPollingTimeout(ctx context.Context, timeout time.Duration, wait func(ctx context.Context) error) error {
+	if timeout <= 0 {
+		return wait(ctx)
+	}
+
+	timeoutCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	err := wait(timeoutCtx)
+	if err != nil && ctx.Err() == nil && errors.Is(timeoutCtx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("%w after %s", ErrPollingTimeout, FormatTimeout(timeout))
+	}
+	return err
+}
+
+// FormatTimeout formats d rounded to the second without its zero units, such
+// as 90m as 1h30m rather than 1h30m0s. Durations under a second are kept.
+func FormatTimeout(d time.Duration) string {
+	if d < time.Second {
+		return d.String()
+	}
+	d = d.Round(time.Second)
+
+	var b strings.Builder
+	for _, unit := range []struct {
+		d      time.Duration
+		suffix string
+	}{{time.Hour, "h"}, {time.Minute, "m"}, {time.Second, "s"}} {
+		if n := d / unit.d; n > 0 {
+			fmt.Fprintf(&b, "%d%s", n, unit.suffix)
+			d -= n * unit.d
+		}
+	}
+	return b.String()
+}
+
 func (w *AWSPollingConfig) LogEnvOverrideWarnings() {
 	pollDelayEnv := os.Getenv("AWS_POLL_DELAY_SECONDS")
 	timeoutSecondsEnv := os.Getenv("AWS_TIMEOUT_SECONDS")
 	maxAttemptsEnv := os.Getenv("AWS_MAX_ATTEMPTS")
+	jitterSecondsEnv := os.Getenv("AWS_POLL_JITTER_SECONDS")
 
 	maxAttemptsIsSet := maxAttemptsEnv != "" || w.MaxAttempts != 0
 	timeoutSecondsIsSet := timeoutSecondsEnv != ""
@@ -134,18 +274,30 @@ func (w *AWSPollingConfig) LogEnvOverrideWarnings() {
 		log.Print(warning)
 	} else if timeoutSecondsIsSet {
 		log.Printf("[WARNING] (aws): env var AWS_TIMEOUT_SECONDS is " +
-			"deprecated in favor of AWS_MAX_ATTEMPTS env or aws_polling_max_attempts config option. " +
+			"deprecated in favor of AWS_MAX_ATTEMPTS env, aws_polling_max_attempts or aws_polling_timeout config option. " +
 			"If you have not explicitly set AWS_POLL_DELAY_SECONDS env or aws_polling_delay_seconds config option, " +
 			"we are defaulting to a poll delay of 2 seconds, regardless of the AWS waiter's default.")
 	}
-	if !maxAttemptsIsSet && !timeoutSecondsIsSet && !pollDelayIsSet {
+	if w.Timeout > 0 {
+		log.Printf("[INFO] (aws): The timeout option is set. Packer will stop waiting "+
+			"for AWS resources after %s, whatever the number of attempts left.", FormatTimeout(w.Timeout))
+	}
+	if !maxAttemptsIsSet && !timeoutSecondsIsSet && !pollDelayIsSet && w.Timeout <= 0 {
 		log.Printf("[INFO] (aws): No AWS timeout and polling overrides have been set. " +
 			"Packer will default to waiter-specific delays and timeouts. If you would " +
 			"like to customize the length of time between retries and max " +
 			"number of retries you may do so by setting the environment " +
 			"variables AWS_POLL_DELAY_SECONDS and AWS_MAX_ATTEMPTS or the " +
 			"configuration options aws_polling_delay_seconds and aws_polling_max_attempts " +
-			"to your desired values.")
+			"to your desired values, or bound the wait time with the aws_polling_timeout " +
+			"configuration option.")
+	}
+	if w.JitterSeconds < 0 {
+		log.Printf("[WARNING] (aws): jitter_seconds is negative, Packer will not " +
+			"apply any jitter to the poll delay.")
+	} else if w.JitterSeconds != 0 || jitterSecondsEnv != "" {
+		log.Printf("[INFO] (aws): A poll jitter has been set. Packer will randomize " +
+			"the delay between retries by up to the jitter in either direction.")
 	}
 }
 func applyEnvOverrides(envOverrides overridableWaitVars) *PollingOptions {
@@ -178,9 +330,27 @@ func applyEnvOverrides(envOverrides overridableWaitVars) *PollingOptions {
 
 	}
 
+	// if poll jitter is set, each attempt waits for the delay plus or minus a random jitter.
+	if envOverrides.awsPollJitterSeconds.overridden && envOverrides.awsPollJitterSeconds.Val > 0 {
+		options.Jitter = time.Duration(envOverrides.awsPollJitterSeconds.Val) * time.Second
+	}
+
 	return &options
 }
 
+// JitterDelay returns delay shifted by a random duration within [-jitter, jitter],
+// never going below zero.
+func JitterDelay(delay, jitter time.Duration) time.Duration {
+	if jitter <= 0 {
+		return delay
+	}
+	delay += time.Duration(rand.Int63n(int64(2*jitter)+1)) - jitter
+	if delay < 0 {
+		return 0
+	}
+	return delay
+}
+
 func getOverride(varInfo envInfo) envInfo {
 	override := os.Getenv(varInfo.envKey)
 	if override != "" {
@@ -202,11 +372,13 @@ func getEnvOverrides() overridableWaitVars {
 		envInfo{"AWS_POLL_DELAY_SECONDS", 2, false},
 		envInfo{"AWS_MAX_ATTEMPTS", 0, false},
 		envInfo{"AWS_TIMEOUT_SECONDS", 0, false},
+		envInfo{"AWS_POLL_JITTER_SECONDS", 0, false},
 	}
 
 	envValues.awsMaxAttempts = getOverride(envValues.awsMaxAttempts)
 	envValues.awsPollDelaySeconds = getOverride(envValues.awsPollDelaySeconds)
 	envValues.awsTimeoutSeconds = getOverride(envValues.awsTimeoutSeconds)
+	envValues.awsPollJitterSeconds = getOverride(envValues.awsPollJitterSeconds)
 
 	return envValues
 }
@@ -221,34 +393,44 @@ func (w *AWSPollingConfig) getWaiterOptions() *PollingOptions {
 		envOverrides.awsPollDelaySeconds.Val = w.DelaySeconds
 		envOverrides.awsPollDelaySeconds.overridden = true
 	}
+	if w.JitterSeconds != 0 {
+		envOverrides.awsPollJitterSeconds.Val = w.JitterSeconds
+		envOverrides.awsPollJitterSeconds.overridden = true
+	}
 
 	waitOpts := applyEnvOverrides(envOverrides)
 	return waitOpts
 }
-func (w *AWSPollingConfig) WaitUntilImageImported(ctx context.Context, conn Ec2Client, taskID string) error {
+func (w *AWSPollingConfig) WaitUntilImageImported(ctx context.Context, conn Ec2Client, taskID string,
+	observers ...ImportTaskObserver) error {
 	importInput := ec2.DescribeImportImageTasksInput{
 		ImportTaskIds: []string{taskID},
 	}
 
-	err := WaitForImageToBeImported(conn,
-		ctx,
-		&importInput,
-		w.getWaiterOptions())
+	err := w.withTimeout(ctx, func(ctx context.Context) error {
+		return WaitForImageToBeImported(conn,
+			ctx,
+			&importInput,
+			w.getWaiterOptions(),
+			observers...)
+	})
 	return err
 }
 
 func WaitForImageToBeImported(client Ec2Client, ctx context.Context, input *ec2.DescribeImportImageTasksInput,
-	opts *PollingOptions) error {
+	opts *PollingOptions, observers ...ImportTaskObserver) error {
 	// we have tried to simulate here a behaviour that's similar to what we have in v1.
 	// aws sdk go v2 does not provide a builtin waiter for Import Image Tasks.
 
 	maxAttempts := 720
 	delay := 5 * time.Second
+	var jitter time.Duration
 
 	if opts != nil {
 		if opts.MinDelay > 0 {
 			delay = opts.MinDelay
 		}
+		jitter = opts.Jitter
 
 		if opts.MaxWaitTime > 0 {
 			maxAttempts = int(opts.MaxWaitTime.Seconds() / delay.Seconds())
@@ -267,6 +449,10 @@ func WaitForImageToBeImported(client Ec2Client, ctx context.Context, input *ec2.
 		}
 
 		for _, task := range output.ImportImageTasks {
+			for _, observe := range observers {
+				observe(ctx, task)
+			}
+
 			// Check for failure states
 			if *task.Status == "deleted" {
 				return fmt.Errorf("import task was deleted")
@@ -282,7 +468,7 @@ func WaitForImageToBeImported(client Ec2Client, ctx context.Context, input *ec2.
 		select {
 		case <-ctx.Done():
 			return ctx.Err()
-		case <-time.After(delay):
+		case <-time.After(JitterDelay(delay, jitter)):
 			continue
 		}
 	}
@@ -290,6 +476,44 @@ func WaitForImageToBeImported(client Ec2Client, ctx context.Context, input *ec2.
 	return fmt.Errorf("timeout waiting for image import to complete after %d attempts", maxAttempts)
 }
 
+// imageAvailableWaiterOptions returns the options of the ImageAvailable
+// waiter for pollingOpts. The waiter keeps its own maximum delay. The jitter
+// lowers the minimum delay and adds a random wait of up to twice the jitter
+// before each retry, which spreads the delays of the waiter by the jitter in
+// either direction.
+func imageAvailableWaiterOptions(pollingOpts *PollingOptions) []func(*ec2.ImageAvailableWaiterOptions) {
+	delay := 5 * time.Second // Set a default 5-second delay
+	if pollingOpts.MinDelay > 0 {
+		delay = pollingOpts.MinDelay
+	}
+	jitter := pollingOpts.Jitter
+
+	return []func(*ec2.ImageAvailableWaiterOptions){func(o *ec2.ImageAvailableWaiterOptions) {
+		o.MinDelay = delay
+		// The waiter rejects a minimum delay above the maximum.
+		if o.MaxDelay < delay {
+			o.MaxDelay = delay
+		}
+		if jitter <= 0 {
+			return
+		}
+		o.MinDelay = max(delay-jitter, time.Second)
+		retryable := o.Retryable
+		o.Retryable = func(ctx context.Context, input *ec2.DescribeImagesInput, output *ec2.DescribeImagesOutput, err error) (bool, error) {
+			retry, err := retryable(ctx, input, output, err)
+			if !retry || err != nil {
+				return retry, err
+			}
+			select {
+			case <-ctx.Done():
+				return false, ctx.Err()
+			case <-time.After(JitterDelay(jitter, jitter)):
+				return true, nil
+			}
+		}
+	}}
+}
+
 func (w *AWSPollingConfig) WaitUntilAMIAvailable(ctx aws.Context, client Ec2Client, imageId string) error {
 
 	imageInput := &ec2.DescribeImagesInput{
@@ -299,7 +523,7 @@ func (w *AWSPollingConfig) WaitUntilAMIAvailable(ctx aws.Context, client Ec2Clie
 
 	pollingOpts := w.getWaiterOptions()
 
-	waiterOpts := []func(*ec2.ImageAvailableWaiterOptions){}
+	waiterOpts := imageAvailableWaiterOptions(pollingOpts)
 	maxWaitTime := pollingOpts.MaxWaitTime // Default to 10 minutes
 
 	if pollingOpts.MaxWaitTime == 0 {
@@ -308,13 +532,9 @@ func (w *AWSPollingConfig) WaitUntilAMIAvailable(ctx aws.Context, client Ec2Clie
 		maxWaitTime = 30 * time.Minute
 	}
 
-	if pollingOpts.MinDelay == 0 {
-		waiterOpts = append(waiterOpts, func(o *ec2.ImageAvailableWaiterOptions) {
-			o.MinDelay = 5 * time.Second // Set a default 5-second delay
-		})
-	}
-
-	err := ec2.NewImageAvailableWaiter(client).Wait(ctx, imageInput, maxWaitTime, waiterOpts...)
+	err := w.withTimeout(ctx, func(ctx context.Context) error {
+		return ec2.NewImageAvailableWaiter(client).Wait(ctx, imageInput, maxWaitTime, waiterOpts...)
+	})
 
 	if err != nil {
 		// The error type for a waiter timeout is *aws.WaiterError
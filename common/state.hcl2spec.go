@@ -10,8 +10,10 @@ import (
 // FlatAWSPollingConfig is an auto-generated flat version of AWSPollingConfig.
 // Where the contents of a field with a `mapstructure:,squash` tag are bubbled up.
 type FlatAWSPollingConfig struct {
-	MaxAttempts  *int `mapstructure:"max_attempts" required:"false" cty:"max_attempts" hcl:"max_attempts"`
-	DelaySeconds *int `mapstructure:"delay_seconds" required:"false" cty:"delay_seconds" hcl:"delay_seconds"`
+	MaxAttempts   *int    `mapstructure:"max_attempts" required:"false" cty:"max_attempts" hcl:"max_attempts"`
+	DelaySeconds  *int    `mapstructure:"delay_seconds" required:"false" cty:"delay_seconds" hcl:"delay_seconds"`
+	Timeout       *string `mapstructure:"timeout" required:"false" cty:"timeout" hcl:"timeout"`
+	JitterSeconds *int    `mapstructure:"jitter_seconds" required:"false" cty:"jitter_seconds" hcl:"jitter_seconds"`
 }
 
 // FlatMapstructure returns a new FlatAWSPollingConfig.
@@ -26,8 +28,10 @@ func (*AWSPollingConfig) FlatMapstructure() interface{ HCL2Spec() map[string]hcl
 // The decoded values from this spec will then be applied to a FlatAWSPollingConfig.
 func (*FlatAWSPollingConfig) HCL2Spec() map[string]hcldec.Spec {
 	s := map[string]hcldec.Spec{
-		"max_attempts":  &hcldec.AttrSpec{Name: "max_attempts", Type: cty.Number, Required: false},
-		"delay_seconds": &hcldec.AttrSpec{Name: "delay_seconds", Type: cty.Number, Required: false},
+		"max_attempts":   &hcldec.AttrSpec{Name: "max_attempts", Type: cty.Number, Required: false},
+		"delay_seconds":  &hcldec.AttrSpec{Name: "delay_seconds", Type: cty.Number, Required: false},
+		"timeout":        &hcldec.AttrSpec{Name: "timeout", Type: cty.String, Required: false},
+		"jitter_seconds": &hcldec.AttrSpec{Name: "jitter_seconds", Type: cty.Number, Required: false},
 	}
 	return s
 }
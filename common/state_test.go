@@ -0,0 +1,277 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package common
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/hashicorp/packer-plugin-sdk/multistep"
+)
+
+func TestJitterDelay(t *testing.T) {
+	delay := 10 * time.Second
+	jitter := 3 * time.Second
+
+	if got := JitterDelay(delay, 0); got != delay {
+		t.Fatalf("expected %s without jitter, got %s", delay, got)
+	}
+
+	for i := 0; i < 100; i++ {
+		got := JitterDelay(delay, jitter)
+		if got < delay-jitter || got > delay+jitter {
+			t.Fatalf("expected delay within %s of %s, got %s", jitter, delay, got)
+		}
+	}
+
+	for i := 0; i < 100; i++ {
+		if got := JitterDelay(time.Second, jitter); got < 0 {
+			t.Fatalf("expected non negative delay, got %s", got)
+		}
+	}
+}
+
+func TestAWSPollingConfig_getWaiterOptions_Jitter(t *testing.T) {
+	tests := []struct {
+		name     string
+		env      string
+		config   AWSPollingConfig
+		expected time.Duration
+	}{
+		{name: "none"},
+		{name: "env", env: "4", expected: 4 * time.Second},
+		{name: "option", config: AWSPollingConfig{JitterSeconds: 2}, expected: 2 * time.Second},
+		{name: "option over env", env: "4", config: AWSPollingConfig{JitterSeconds: 2}, expected: 2 * time.Second},
+		{name: "negative", config: AWSPollingConfig{JitterSeconds: -2}},
+		{name: "invalid env", env: "lots"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("AWS_POLL_JITTER_SECONDS", tt.env)
+
+			opts := tt.config.getWaiterOptions()
+			if opts.Jitter != tt.expected {
+				t.Errorf("expected jitter %s, got %s", tt.expected, opts.Jitter)
+			}
+		})
+	}
+}
+
+func TestImageAvailableWaiterOptions(t *testing.T) {
+	tests := []struct {
+		name             string
+		opts             PollingOptions
+		expectedMinDelay time.Duration
+		expectedMaxDelay time.Duration
+	}{
+		{name: "default", expectedMinDelay: 5 * time.Second, expectedMaxDelay: 120 * time.Second},
+		{name: "delay", opts: PollingOptions{MinDelay: 10 * time.Second}, expectedMinDelay: 10 * time.Second, expectedMaxDelay: 120 * time.Second},
+		{name: "delay above the maximum", opts: PollingOptions{MinDelay: 300 * time.Second}, expectedMinDelay: 300 * time.Second, expectedMaxDelay: 300 * time.Second},
+		{name: "jitter", opts: PollingOptions{MinDelay: 10 * time.Second, Jitter: 300 * time.Millisecond}, expectedMinDelay: 9700 * time.Millisecond, expectedMaxDelay: 120 * time.Second},
+		{name: "jitter below one second", opts: PollingOptions{MinDelay: 1100 * time.Millisecond, Jitter: 300 * time.Millisecond}, expectedMinDelay: time.Second, expectedMaxDelay: 120 * time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			retried := 0
+			options := ec2.ImageAvailableWaiterOptions{
+				MinDelay: 15 * time.Second,
+				MaxDelay: 120 * time.Second,
+				Retryable: func(context.Context, *ec2.DescribeImagesInput, *ec2.DescribeImagesOutput, error) (bool, error) {
+					retried++
+					return true, nil
+				},
+			}
+			for _, fn := range imageAvailableWaiterOptions(&tt.opts) {
+				fn(&options)
+			}
+			if options.MinDelay != tt.expectedMinDelay || options.MaxDelay != tt.expectedMaxDelay {
+				t.Errorf("expected delays %s-%s, got %s-%s", tt.expectedMinDelay, tt.expectedMaxDelay, options.MinDelay, options.MaxDelay)
+			}
+
+			// The jitter waits before the retries decided by the waiter.
+			retry, err := options.Retryable(context.Background(), nil, nil, nil)
+			if !retry || err != nil || retried != 1 {
+				t.Errorf("expected the retry of the waiter, got %t, %v after %d calls", retry, err, retried)
+			}
+		})
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	options := ec2.ImageAvailableWaiterOptions{
+		Retryable: func(context.Context, *ec2.DescribeImagesInput, *ec2.DescribeImagesOutput, error) (bool, error) {
+			return true, nil
+		},
+	}
+	for _, fn := range imageAvailableWaiterOptions(&PollingOptions{Jitter: time.Minute}) {
+		fn(&options)
+	}
+	if _, err := options.Retryable(ctx, nil, nil, nil); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected the jitter to stop with the context, got %v", err)
+	}
+}
+
+// testStateRefresh returns a StateRefreshFunc going through states, staying
+// on the last one, and counting its calls.
+func testStateRefresh(calls *int, states ...string) StateRefreshFunc {
+	return func() (any, string, error) {
+		state := states[min(*calls, len(states)-1)]
+		*calls++
+		return "result-" + state, state, nil
+	}
+}
+
+func TestStateChangeConf_WaitForState(t *testing.T) {
+	var calls int
+	conf := StateChangeConf{
+		Pending:       []string{"pending", "starting"},
+		Target:        "available",
+		Refresh:       testStateRefresh(&calls, "pending", "starting", "available"),
+		PollingConfig: &AWSPollingConfig{DelaySeconds: 1},
+	}
+
+	start := time.Now()
+	result, err := conf.WaitForState(context.Background())
+	if err != nil {
+		t.Fatalf("should not have error: %s", err)
+	}
+	if result != "result-available" || calls != 3 {
+		t.Fatalf("expected the result of the third refresh, got %v after %d", result, calls)
+	}
+	if elapsed := time.Since(start); elapsed < 2*time.Second {
+		t.Fatalf("expected a delay between refreshes, took %s", elapsed)
+	}
+}
+
+func TestStateChangeConf_WaitForState_UnexpectedState(t *testing.T) {
+	var calls int
+	conf := StateChangeConf{
+		Pending: []string{"pending"},
+		Target:  "available",
+		Refresh: testStateRefresh(&calls, "failed"),
+	}
+
+	_, err := conf.WaitForState(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "unexpected state 'failed'") {
+		t.Fatalf("expected an unexpected state error, got %v", err)
+	}
+}
+
+func TestStateChangeConf_WaitForState_RefreshError(t *testing.T) {
+	refreshErr := errors.New("throttled")
+	conf := StateChangeConf{
+		Pending: []string{"pending"},
+		Target:  "available",
+		Refresh: func() (any, string, error) { return nil, "", refreshErr },
+	}
+
+	if _, err := conf.WaitForState(context.Background()); !errors.Is(err, refreshErr) {
+		t.Fatalf("expected the refresh error, got %v", err)
+	}
+}
+
+func TestStateChangeConf_WaitForState_Timeout(t *testing.T) {
+	var calls int
+	conf := StateChangeConf{
+		Pending:       []string{"pending"},
+		Target:        "available",
+		Refresh:       testStateRefresh(&calls, "pending"),
+		PollingConfig: &AWSPollingConfig{MaxAttempts: 1, DelaySeconds: 1},
+	}
+
+	_, err := conf.WaitForState(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "timeout") {
+		t.Fatalf("expected a timeout, got %v", err)
+	}
+}
+
+func TestStateChangeConf_WaitForState_Cancelled(t *testing.T) {
+	var calls int
+	conf := StateChangeConf{
+		Pending:       []string{"pending"},
+		Target:        "available",
+		Refresh:       testStateRefresh(&calls, "pending"),
+		PollingConfig: &AWSPollingConfig{DelaySeconds: 60},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := conf.WaitForState(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the context error, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("expected to stop when the context is done, took %s", elapsed)
+	}
+
+	state := new(multistep.BasicStateBag)
+	state.Put(multistep.StateCancelled, true)
+	conf.StepState = state
+	if _, err := conf.WaitForState(context.Background()); err == nil || err.Error() != "interrupted" {
+		t.Fatalf("expected the wait to be interrupted, got %v", err)
+	}
+}
+
+func TestStateChangeConf_WaitForState_PollingTimeout(t *testing.T) {
+	var calls int
+	conf := StateChangeConf{
+		Pending:       []string{"pending"},
+		Target:        "available",
+		Refresh:       testStateRefresh(&calls, "pending"),
+		PollingConfig: &AWSPollingConfig{DelaySeconds: 60, Timeout: 50 * time.Millisecond},
+	}
+
+	_, err := conf.WaitForState(context.Background())
+	if !errors.Is(err, ErrPollingTimeout) {
+		t.Fatalf("expected a polling timeout, got %v", err)
+	}
+}
+
+func TestAWSPollingConfig_withTimeout(t *testing.T) {
+	block := func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+
+	w := &AWSPollingConfig{Timeout: 90 * time.Minute}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := w.withTimeout(ctx, block); !errors.Is(err, context.Canceled) {
+		t.Fatalf("a cancelled context should not be reported as a timeout, got %v", err)
+	}
+
+	apiErr := errors.New("UnauthorizedOperation")
+	if err := w.withTimeout(context.Background(), func(context.Context) error { return apiErr }); err != apiErr {
+		t.Fatalf("expected the error of the wait, got %v", err)
+	}
+
+	w.Timeout = 10 * time.Millisecond
+	err := w.withTimeout(context.Background(), block)
+	if !errors.Is(err, ErrPollingTimeout) || err.Error() != "polling timed out after 10ms" {
+		t.Fatalf("expected a polling timeout, got %v", err)
+	}
+
+}
+
+func TestFormatTimeout(t *testing.T) {
+	tests := map[time.Duration]string{
+		10 * time.Millisecond:                 "10ms",
+		90 * time.Second:                      "1m30s",
+		90 * time.Minute:                      "1h30m",
+		2 * time.Hour:                         "2h",
+		2*time.Hour + 5*time.Second:           "2h5s",
+		90*time.Minute + 400*time.Millisecond: "1h30m",
+		time.Hour - 300*time.Millisecond:      "1h",
+	}
+	for d, expected := range tests {
+		if got := FormatTimeout(d); got != expected {
+			t.Errorf("expected %s for %s, got %s", expected, d, got)
+		}
+	}
+}
@@ -16,6 +16,8 @@ import (
 	"github.com/hashicorp/packer-plugin-amazon/datasource/parameterstore"
 	"github.com/hashicorp/packer-plugin-amazon/datasource/secretsmanager"
 	amazonimport "github.com/hashicorp/packer-plugin-amazon/post-processor/import"
+	amazonmetadata "github.com/hashicorp/packer-plugin-amazon/post-processor/metadata"
+	amazonwebhook "github.com/hashicorp/packer-plugin-amazon/post-processor/webhook"
 	"github.com/hashicorp/packer-plugin-amazon/version"
 	"github.com/hashicorp/packer-plugin-sdk/plugin"
 )
@@ -31,6 +33,8 @@ func main() {
 	pps.RegisterDatasource("secretsmanager", new(secretsmanager.Datasource))
 	pps.RegisterDatasource("parameterstore", new(parameterstore.Datasource))
 	pps.RegisterPostProcessor("import", new(amazonimport.PostProcessor))
+	pps.RegisterPostProcessor("metadata", new(amazonmetadata.PostProcessor))
+	pps.RegisterPostProcessor("webhook", new(amazonwebhook.PostProcessor))
 	pps.SetVersion(version.PluginVersion)
 	err := pps.Run()
 	if err != nil {
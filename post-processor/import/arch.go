@@ -0,0 +1,294 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package amazonimport
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// The size of the sectors of the partition tables and of VMDK disks.
+const sectorSize = 512
+
+// How much of the EFI system partition is searched for a boot loader, the
+// FAT and the directories holding it are at the start of the partition.
+const efiScanLimit = 64 * 1024 * 1024
+
+// Limits of the sparse VMDK headers, well above what VMware writes, to
+// reject corrupted headers before allocating their tables.
+const (
+	vmdkMaxGrainSize = 2048
+	vmdkMaxGTEntries = 4096
+	vmdkMaxGDEntries = 1 << 24
+)
+
+var (
+	// The GPT type of EFI system partitions, in its on-disk byte order.
+	efiSystemPartitionType = []byte{0x28, 0x73, 0x2a, 0xc1, 0x1f, 0xf8, 0xd2, 0x11, 0xba, 0x4b, 0x00, 0xa0, 0xc9, 0x3e, 0xc9, 0x3b}
+
+	// The architectures of the default EFI boot loaders, by the 8.3 name of
+	// their FAT directory entry.
+	efiBootLoaders = map[string]string{
+		"BOOTX64 EFI": "x86_64",
+		"BOOTAA64EFI": "arm64",
+		"BOOTIA32EFI": "i386",
+	}
+
+	// errNoEFIPartition is returned for disks without EFI system partition,
+	// which boot with legacy BIOS.
+	errNoEFIPartition = errors.New("no EFI system partition found")
+)
+
+// diskArchitecture returns the architecture of the raw or VMDK disk at path,
+// from the EFI boot loader of its EFI system partition.
+func diskArchitecture(path, format string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+	var r io.ReaderAt = f
+	size := info.Size()
+	if format == "vmdk" {
+		vmdk, err := newSparseVMDK(f, size)
+		if err != nil {
+			return "", err
+		}
+		r, size = vmdk, vmdk.size
+	}
+	return efiArchitecture(r, size)
+}
+
+// efiArchitecture finds the EFI system partition of the disk of size bytes
+// and returns the architecture of the boot loader found in it.
+func efiArchitecture(r io.ReaderAt, size int64) (string, error) {
+	offset, length, err := efiSystemPartition(r)
+	if err != nil {
+		return "", err
+	}
+	if offset+length > size {
+		length = size - offset
+	}
+	if length > efiScanLimit {
+		length = efiScanLimit
+	}
+
+	// Directory entries are 32 bytes long and aligned, so are the chunks.
+	chunk := make([]byte, 1024*1024)
+	for read := int64(0); read < length; read += int64(len(chunk)) {
+		n, err := r.ReadAt(chunk[:min(int64(len(chunk)), length-read)], offset+read)
+		if err != nil && err != io.EOF {
+			return "", err
+		}
+		for entry := 0; entry+32 <= n; entry += 32 {
+			// Skip the directories named like a boot loader.
+			if arch, ok := efiBootLoaders[string(chunk[entry:entry+11])]; ok && chunk[entry+11]&0x10 == 0 {
+				return arch, nil
+			}
+		}
+		if n == 0 {
+			break
+		}
+	}
+	return "", fmt.Errorf("no EFI boot loader found in the EFI system partition")
+}
+
+// efiSystemPartition returns the offset and length of the EFI system
+// partition of the GPT or MBR of a disk.
+func efiSystemPartition(r io.ReaderAt) (int64, int64, error) {
+	header := make([]byte, 2*sectorSize)
+	if _, err := r.ReadAt(header, 0); err != nil {
+		return 0, 0, fmt.Errorf("reading partition table: %s", err)
+	}
+
+	gpt := header[sectorSize:]
+	if string(gpt[:8]) == "EFI PART" {
+		entriesLBA := binary.LittleEndian.Uint64(gpt[72:80])
+		count := binary.LittleEndian.Uint32(gpt[80:84])
+		entrySize := binary.LittleEndian.Uint32(gpt[84:88])
+		if count > 1024 || entrySize < 128 || entrySize > 4096 {
+			return 0, 0, fmt.Errorf("invalid GPT header")
+		}
+		entries := make([]byte, count*entrySize)
+		if _, err := r.ReadAt(entries, int64(entriesLBA)*sectorSize); err != nil {
+			return 0, 0, fmt.Errorf("reading GPT entries: %s", err)
+		}
+		for i := uint32(0); i < count; i++ {
+			entry := entries[i*entrySize:]
+			if !bytes.Equal(entry[:16], efiSystemPartitionType) {
+				continue
+			}
+			first := binary.LittleEndian.Uint64(entry[32:40])
+			last := binary.LittleEndian.Uint64(entry[40:48])
+			if last < first {
+				return 0, 0, fmt.Errorf("invalid EFI system partition bounds")
+			}
+			return int64(first) * sectorSize, int64(last-first+1) * sectorSize, nil
+		}
+		return 0, 0, errNoEFIPartition
+	}
+
+	if header[510] != 0x55 || header[511] != 0xaa {
+		return 0, 0, fmt.Errorf("no partition table found")
+	}
+	for i := 0; i < 4; i++ {
+		entry := header[446+16*i:]
+		if entry[4] != 0xef {
+			continue
+		}
+		first := binary.LittleEndian.Uint32(entry[8:12])
+		sectors := binary.LittleEndian.Uint32(entry[12:16])
+		return int64(first) * sectorSize, int64(sectors) * sectorSize, nil
+	}
+	return 0, 0, errNoEFIPartition
+}
+
+// sparseVMDK reads the virtual disk of a monolithic sparse or stream
+// optimized VMDK, see the VMware Virtual Disk Format specification.
+type sparseVMDK struct {
+	r          io.ReaderAt
+	size       int64
+	grainSize  int64
+	gtEntries  int64
+	compressed bool
+	gd         []uint32
+	gts        map[int64][]uint32
+}
+
+// newSparseVMDK reads the headers and grain directory of the sparse VMDK of
+// fileSize bytes.
+func newSparseVMDK(r io.ReaderAt, fileSize int64) (*sparseVMDK, error) {
+	header := make([]byte, sectorSize)
+	if _, err := r.ReadAt(header, 0); err != nil {
+		return nil, err
+	}
+	if string(header[:4]) != "KDMV" {
+		return nil, fmt.Errorf("only sparse and stream optimized VMDK disks are supported")
+	}
+
+	// Stream optimized disks write their grain directory last, its offset
+	// is in the footer, before the end of stream marker.
+	gdOffset := binary.LittleEndian.Uint64(header[56:64])
+	if gdOffset == ^uint64(0) {
+		if fileSize < 3*sectorSize {
+			return nil, fmt.Errorf("no VMDK footer found")
+		}
+		if _, err := r.ReadAt(header, fileSize-2*sectorSize); err != nil {
+			return nil, err
+		}
+		if string(header[:4]) != "KDMV" {
+			return nil, fmt.Errorf("no VMDK footer found")
+		}
+		gdOffset = binary.LittleEndian.Uint64(header[56:64])
+	}
+
+	capacity := binary.LittleEndian.Uint64(header[12:20])
+	grainSize := binary.LittleEndian.Uint64(header[20:28])
+	gtEntries := uint64(binary.LittleEndian.Uint32(header[44:48]))
+	if grainSize == 0 || grainSize > vmdkMaxGrainSize || gtEntries == 0 || gtEntries > vmdkMaxGTEntries {
+		return nil, fmt.Errorf("invalid VMDK header")
+	}
+	gdEntries := (capacity/grainSize + gtEntries - 1) / gtEntries
+	if gdEntries > vmdkMaxGDEntries {
+		return nil, fmt.Errorf("invalid VMDK capacity %d", capacity)
+	}
+
+	gd := make([]byte, gdEntries*4)
+	if _, err := r.ReadAt(gd, int64(gdOffset)*sectorSize); err != nil {
+		return nil, fmt.Errorf("reading grain directory: %s", err)
+	}
+	v := &sparseVMDK{
+		r:          r,
+		size:       int64(capacity) * sectorSize,
+		grainSize:  int64(grainSize) * sectorSize,
+		gtEntries:  int64(gtEntries),
+		compressed: binary.LittleEndian.Uint32(header[8:12])&(1<<16) != 0,
+		gd:         make([]uint32, gdEntries),
+		gts:        map[int64][]uint32{},
+	}
+	for i := range v.gd {
+		v.gd[i] = binary.LittleEndian.Uint32(gd[i*4:])
+	}
+	return v, nil
+}
+
+// ReadAt reads the virtual disk, grain by grain.
+func (v *sparseVMDK) ReadAt(p []byte, off int64) (int, error) {
+	n := 0
+	for n < len(p) {
+		if off >= v.size {
+			return n, io.EOF
+		}
+		grain, err := v.grain(off / v.grainSize)
+		if err != nil {
+			return n, err
+		}
+		copied := copy(p[n:], grain[off%v.grainSize:])
+		n += copied
+		off += int64(copied)
+	}
+	return n, nil
+}
+
+// grain returns the data of the grain at index, unallocated grains are
+// zeroes.
+func (v *sparseVMDK) grain(index int64) ([]byte, error) {
+	data := make([]byte, v.grainSize)
+	gdIndex := index / v.gtEntries
+	if gdIndex >= int64(len(v.gd)) || v.gd[gdIndex] == 0 {
+		return data, nil
+	}
+
+	gt, ok := v.gts[gdIndex]
+	if !ok {
+		raw := make([]byte, v.gtEntries*4)
+		if _, err := v.r.ReadAt(raw, int64(v.gd[gdIndex])*sectorSize); err != nil {
+			return nil, fmt.Errorf("reading grain table: %s", err)
+		}
+		gt = make([]uint32, v.gtEntries)
+		for i := range gt {
+			gt[i] = binary.LittleEndian.Uint32(raw[i*4:])
+		}
+		v.gts[gdIndex] = gt
+	}
+
+	// Grains at sector 1 are zeroed grains.
+	sector := gt[index%v.gtEntries]
+	if sector <= 1 {
+		return data, nil
+	}
+	offset := int64(sector) * sectorSize
+	if !v.compressed {
+		if _, err := v.r.ReadAt(data, offset); err != nil && err != io.EOF {
+			return nil, err
+		}
+		return data, nil
+	}
+
+	// Compressed grains start with their LBA and the size of their
+	// deflated data.
+	marker := make([]byte, 12)
+	if _, err := v.r.ReadAt(marker, offset); err != nil {
+		return nil, err
+	}
+	zr, err := zlib.NewReader(io.NewSectionReader(v.r, offset+12, int64(binary.LittleEndian.Uint32(marker[8:12]))))
+	if err != nil {
+		return nil, fmt.Errorf("reading compressed grain: %s", err)
+	}
+	defer zr.Close()
+	if _, err := io.ReadFull(zr, data); err != nil && err != io.ErrUnexpectedEOF {
+		return nil, fmt.Errorf("reading compressed grain: %s", err)
+	}
+	return data, nil
+}
@@ -0,0 +1,195 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package amazonimport
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
+)
+
+// testGPTDisk returns a 128KB raw disk with a GPT, whose EFI system
+// partition holds the FAT directory entry of loader. Without loader, the
+// disk has no EFI system partition.
+func testGPTDisk(loader string) []byte {
+	disk := make([]byte, 128*1024)
+	disk[510], disk[511] = 0x55, 0xaa
+	gpt := disk[sectorSize:]
+	copy(gpt, "EFI PART")
+	binary.LittleEndian.PutUint64(gpt[72:80], 2)
+	binary.LittleEndian.PutUint32(gpt[80:84], 128)
+	binary.LittleEndian.PutUint32(gpt[84:88], 128)
+	if loader == "" {
+		return disk
+	}
+
+	entry := disk[2*sectorSize:]
+	copy(entry, efiSystemPartitionType)
+	binary.LittleEndian.PutUint64(entry[32:40], 40)
+	binary.LittleEndian.PutUint64(entry[40:48], 255)
+	copy(disk[40*sectorSize+1024:], loader)
+	return disk
+}
+
+// testMBRDisk returns a raw disk with an MBR and no EFI system partition.
+func testMBRDisk() []byte {
+	disk := make([]byte, 4096)
+	disk[446+4] = 0x83
+	disk[510], disk[511] = 0x55, 0xaa
+	return disk
+}
+
+// testSparseVMDK wraps raw into a monolithic sparse VMDK, or a stream
+// optimized one with compressed grains and the grain directory at the end.
+func testSparseVMDK(raw []byte, compressed bool) []byte {
+	const (
+		grainSectors = 128
+		gtEntries    = 512
+	)
+	grainSize := grainSectors * sectorSize
+	grains := (len(raw) + grainSize - 1) / grainSize
+
+	header := make([]byte, sectorSize)
+	copy(header, "KDMV")
+	binary.LittleEndian.PutUint32(header[4:8], 1)
+	binary.LittleEndian.PutUint64(header[12:20], uint64(grains*grainSectors))
+	binary.LittleEndian.PutUint64(header[20:28], grainSectors)
+	binary.LittleEndian.PutUint32(header[44:48], gtEntries)
+
+	// The grain table, then the grains.
+	disk := make([]byte, 2*sectorSize)
+	gt := make([]byte, gtEntries*4)
+	gtSector := uint32(len(disk) / sectorSize)
+	disk = append(disk, gt...)
+	for i := 0; i < grains; i++ {
+		grain := raw[i*grainSize : min(len(raw), (i+1)*grainSize)]
+		if bytes.Count(grain, []byte{0}) == len(grain) {
+			continue
+		}
+		binary.LittleEndian.PutUint32(disk[int(gtSector)*sectorSize+i*4:], uint32(len(disk)/sectorSize))
+		if !compressed {
+			disk = append(disk, grain...)
+			continue
+		}
+		var deflated bytes.Buffer
+		w := zlib.NewWriter(&deflated)
+		_, _ = w.Write(grain)
+		_ = w.Close()
+		marker := make([]byte, 12)
+		binary.LittleEndian.PutUint64(marker[0:8], uint64(i*grainSectors))
+		binary.LittleEndian.PutUint32(marker[8:12], uint32(deflated.Len()))
+		disk = append(disk, marker...)
+		disk = append(disk, deflated.Bytes()...)
+		disk = append(disk, make([]byte, (sectorSize-len(disk)%sectorSize)%sectorSize)...)
+	}
+
+	// The grain directory has a single grain table.
+	gdSector := uint64(1)
+	if compressed {
+		gdSector = uint64(len(disk) / sectorSize)
+		disk = append(disk, make([]byte, sectorSize)...)
+	}
+	binary.LittleEndian.PutUint32(disk[gdSector*sectorSize:], gtSector)
+
+	if compressed {
+		binary.LittleEndian.PutUint32(header[8:12], 1<<16)
+		footer := append([]byte(nil), header...)
+		binary.LittleEndian.PutUint64(footer[56:64], gdSector)
+		binary.LittleEndian.PutUint64(header[56:64], ^uint64(0))
+		disk = append(disk, make([]byte, sectorSize)...)
+		disk = append(disk, footer...)
+		disk = append(disk, make([]byte, sectorSize)...)
+	} else {
+		binary.LittleEndian.PutUint64(header[56:64], gdSector)
+	}
+	copy(disk, header)
+	return disk
+}
+
+func TestDiskArchitecture(t *testing.T) {
+	tests := []struct {
+		name     string
+		format   string
+		contents []byte
+		expected string
+	}{
+		{"x86_64 raw", "raw", testGPTDisk("BOOTX64 EFI"), "x86_64"},
+		{"arm64 raw", "raw", testGPTDisk("BOOTAA64EFI"), "arm64"},
+		{"i386 raw", "raw", testGPTDisk("BOOTIA32EFI"), "i386"},
+		{"arm64 sparse VMDK", "vmdk", testSparseVMDK(testGPTDisk("BOOTAA64EFI"), false), "arm64"},
+		{"arm64 stream optimized VMDK", "vmdk", testSparseVMDK(testGPTDisk("BOOTAA64EFI"), true), "arm64"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "disk."+tt.format)
+			if err := os.WriteFile(path, tt.contents, 0644); err != nil {
+				t.Fatal(err)
+			}
+			arch, err := diskArchitecture(path, tt.format)
+			if err != nil {
+				t.Fatalf("should not have error: %s", err)
+			}
+			if arch != tt.expected {
+				t.Fatalf("expected a %s disk, got %s", tt.expected, arch)
+			}
+		})
+	}
+
+	dir := t.TempDir()
+	for name, contents := range map[string][]byte{"gpt.raw": testGPTDisk(""), "mbr.raw": testMBRDisk()} {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, contents, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := diskArchitecture(path, "raw"); !errors.Is(err, errNoEFIPartition) {
+			t.Fatalf("%s: expected no EFI system partition to be found, got %v", name, err)
+		}
+	}
+
+	path := filepath.Join(dir, "disk.raw")
+	if err := os.WriteFile(path, make([]byte, 4096), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := diskArchitecture(path, "raw"); err == nil {
+		t.Fatal("a disk without partition table should error")
+	}
+}
+
+func TestWarnDiskArchitecture(t *testing.T) {
+	dir := t.TempDir()
+	arm := filepath.Join(dir, "arm.raw")
+	legacy := filepath.Join(dir, "legacy.raw")
+	if err := os.WriteFile(arm, testGPTDisk("BOOTAA64EFI"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(legacy, testMBRDisk(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	ui := &packersdk.BasicUi{Reader: new(bytes.Buffer), Writer: &out, ErrorWriter: &out}
+	warnDiskArchitecture(ui, arm, "raw", "arm64")
+	warnDiskArchitecture(ui, legacy, "raw", "x86_64")
+	if out.Len() != 0 {
+		t.Fatalf("matching architectures shouldn't be warned about, got %q", out.String())
+	}
+
+	warnDiskArchitecture(ui, arm, "raw", "x86_64")
+	if !strings.Contains(out.String(), "arm64 EFI boot loader") || !strings.Contains(out.String(), "setting 'architecture' to 'arm64'") {
+		t.Fatalf("a mismatched architecture should be warned about, got %q", out.String())
+	}
+
+	out.Reset()
+	warnDiskArchitecture(ui, legacy, "raw", "arm64")
+	if !strings.Contains(out.String(), "no EFI system partition") {
+		t.Fatalf("an arm64 disk without EFI system partition should be warned about, got %q", out.String())
+	}
+}
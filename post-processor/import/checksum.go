@@ -0,0 +1,62 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package amazonimport
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"fmt"
+	"hash"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// The algorithms compute_checksum can be set to. The checksum files and the
+// S3 metadata holding the checksums are named after them.
+var checksumHashes = map[string]func() hash.Hash{
+	"sha256": sha256.New,
+	"sha512": sha512.New,
+}
+
+// writeChecksum writes the checksum of the file at source with algorithm to
+// a file in dir, named after source with the algorithm as extension. The file
+// has the format of sha256sum and sha512sum, so that it can be checked with
+// them. It returns the path of the file and the hex encoded checksum.
+func writeChecksum(source, algorithm, dir string) (string, string, error) {
+	newHash, ok := checksumHashes[algorithm]
+	if !ok {
+		return "", "", fmt.Errorf("unknown checksum algorithm %q", algorithm)
+	}
+
+	file, err := os.Open(source)
+	if err != nil {
+		return "", "", fmt.Errorf("Failed to open %s: %s", source, err)
+	}
+	defer file.Close()
+
+	sum, err := fileChecksum(file, newHash())
+	if err != nil {
+		return "", "", err
+	}
+
+	path := filepath.Join(dir, filepath.Base(source)+"."+algorithm)
+	content := fmt.Sprintf("%s  %s\n", sum, filepath.Base(source))
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		return "", "", fmt.Errorf("Failed to write checksum of %s: %s", source, err)
+	}
+	return path, sum, nil
+}
+
+// removeChecksumFiles removes the files compute_checksum wrote, when they
+// aren't part of an artifact.
+func (p *PostProcessor) removeChecksumFiles() {
+	for _, path := range p.checksumFiles {
+		log.Printf("Removing checksum file %s", path)
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			log.Printf("Failed to remove checksum file %s: %s", path, err)
+		}
+	}
+	p.checksumFiles = nil
+}
@@ -0,0 +1,91 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package amazonimport
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
+)
+
+func TestWriteChecksum(t *testing.T) {
+	source := filepath.Join(t.TempDir(), "disk.vmdk")
+	if err := os.WriteFile(source, []byte("hello world"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		algorithm string
+		sum       string
+	}{
+		{"sha256", "b94d27b9934d3e08a52e52d7da7dabfac484efe37a5380ee9088f7ace2efcde9"},
+		{"sha512", "309ecc489c12d6eb4cc40f50c902f2b4d0ed77ee511a7c7a9bcd3ca86d4cd86f" +
+			"989dd35bc5ff499670da34255b45b0cfd830e81f605dcf7dc5542e93ae9cd76f"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.algorithm, func(t *testing.T) {
+			dir := t.TempDir()
+			path, sum, err := writeChecksum(source, tt.algorithm, dir)
+			if err != nil {
+				t.Fatalf("should not have error: %s", err)
+			}
+			if sum != tt.sum {
+				t.Fatalf("expected checksum %s, got %s", tt.sum, sum)
+			}
+			if expected := filepath.Join(dir, "disk.vmdk."+tt.algorithm); path != expected {
+				t.Fatalf("expected checksum file %s, got %s", expected, path)
+			}
+
+			content, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if expected := tt.sum + "  disk.vmdk\n"; string(content) != expected {
+				t.Fatalf("expected checksum file content %q, got %q", expected, content)
+			}
+		})
+	}
+
+	if _, _, err := writeChecksum(source, "md5", t.TempDir()); err == nil {
+		t.Fatal("unknown algorithms should be refused")
+	}
+}
+
+func TestPostProcessor_RemoveChecksumFiles(t *testing.T) {
+	dir := t.TempDir()
+	written := filepath.Join(dir, "disk.vmdk.sha256")
+	if err := os.WriteFile(written, []byte("checksum"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	p := &PostProcessor{checksumFiles: []string{written, filepath.Join(dir, "missing.vmdk.sha256")}}
+	p.removeChecksumFiles()
+	if _, err := os.Stat(written); !os.IsNotExist(err) {
+		t.Fatalf("the checksum file should be removed, got %v", err)
+	}
+	if len(p.checksumFiles) != 0 {
+		t.Fatalf("no checksum file should be left, got %v", p.checksumFiles)
+	}
+}
+
+func TestPostProcessor_ChecksumDirectoryConflict(t *testing.T) {
+	p := &PostProcessor{config: Config{
+		Format:            "vmdk",
+		ComputeChecksum:   "sha256",
+		ChecksumDirectory: t.TempDir(),
+	}}
+	artifact := &packersdk.MockArtifact{FilesValue: []string{"a/disk.vmdk", "b/disk.vmdk"}}
+	ui := &packersdk.BasicUi{Reader: new(bytes.Buffer), Writer: new(bytes.Buffer)}
+
+	_, err := p.uploadDisks(context.Background(), ui, nil, artifact)
+	if err == nil || !strings.Contains(err.Error(), "would both be written to disk.vmdk") {
+		t.Fatalf("images with the same name should be rejected, got %v", err)
+	}
+}
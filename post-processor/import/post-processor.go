@@ -7,68 +7,151 @@ package amazonimport
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"os"
+	"path"
+	"path/filepath"
 	"strings"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/arn"
 	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
 	"github.com/aws/aws-sdk-go-v2/service/ec2"
 	ec2types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
 	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
 
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
 
 	"github.com/hashicorp/hcl/v2/hcldec"
 	awscommon "github.com/hashicorp/packer-plugin-amazon/common"
+	"github.com/hashicorp/packer-plugin-amazon/common/awserrors"
 	"github.com/hashicorp/packer-plugin-sdk/common"
 	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
 	"github.com/hashicorp/packer-plugin-sdk/retry"
 	"github.com/hashicorp/packer-plugin-sdk/template/config"
 	"github.com/hashicorp/packer-plugin-sdk/template/interpolate"
+	"github.com/hashicorp/packer-plugin-sdk/uuid"
 )
 
 const BuilderId = "packer.post-processor.amazon-import"
 
+// bootModeAuto lets AWS detect the boot mode from the imported disk.
+const bootModeAuto = "auto"
+
+// Keys of the tags set by tag_import_source.
+const (
+	importSourceTagKey = "ImportSource"
+	importTaskIdTagKey = "ImportTaskId"
+)
+
+// The most tags S3 allows on an object.
+const maxS3ObjectTags = 10
+
+// The tag marking the AMI an import creates before ami_name renames it, so
+// that intermediaries left behind by a failed rename can be found.
+const intermediaryTagKey = "packer-import-intermediary"
+
+// How long the presigned URLs of the disks are valid by default, long
+// enough for the import of large images. SigV4 presigned URLs can't be
+// valid for more than 7 days.
+const (
+	defaultPresignedURLExpiry = 12 * time.Hour
+	maxPresignedURLExpiry     = 7 * 24 * time.Hour
+)
+
 // Configuration of this post processor
 type Config struct {
 	common.PackerConfig    `mapstructure:",squash"`
 	awscommon.AccessConfig `mapstructure:",squash"`
 
 	// Variables specific to this post processor
-	S3Bucket        string            `mapstructure:"s3_bucket_name"`
-	S3Key           string            `mapstructure:"s3_key_name"`
-	S3Encryption    string            `mapstructure:"s3_encryption"`
-	S3EncryptionKey string            `mapstructure:"s3_encryption_key"`
-	SkipClean       bool              `mapstructure:"skip_clean"`
-	Tags            map[string]string `mapstructure:"tags"`
-	Name            string            `mapstructure:"ami_name"`
-	Description     string            `mapstructure:"ami_description"`
-	Users           []string          `mapstructure:"ami_users"`
-	Groups          []string          `mapstructure:"ami_groups"`
-	OrgArns         []string          `mapstructure:"ami_org_arns"`
-	OuArns          []string          `mapstructure:"ami_ou_arns"`
-	Encrypt         bool              `mapstructure:"ami_encrypt"`
-	KMSKey          string            `mapstructure:"ami_kms_key"`
+	S3Bucket           string            `mapstructure:"s3_bucket_name"`
+	S3Key              string            `mapstructure:"s3_key_name"`
+	S3KeyPrefix        string            `mapstructure:"s3_key_prefix"`
+	S3Encryption       string            `mapstructure:"s3_encryption"`
+	S3EncryptionKey    string            `mapstructure:"s3_encryption_key"`
+	S3Tags             map[string]string `mapstructure:"s3_tags"`
+	S3UploadState      string            `mapstructure:"s3_upload_state_file"`
+	S3PartSizeMB       int64             `mapstructure:"s3_upload_part_size_mb"`
+	S3Concurrency      int               `mapstructure:"s3_upload_concurrency"`
+	S3PresignedURL     bool              `mapstructure:"s3_presigned_url"`
+	S3PresignedExpiry  time.Duration     `mapstructure:"s3_presigned_url_expiry"`
+	SkipClean          bool              `mapstructure:"skip_clean"`
+	SkipUpload         bool              `mapstructure:"skip_upload"`
+	SkipUploadIfExists bool              `mapstructure:"skip_upload_if_exists"`
+	ComputeChecksum    string            `mapstructure:"compute_checksum"`
+	ChecksumDirectory  string            `mapstructure:"checksum_directory"`
+	S3ChecksumMetadata bool              `mapstructure:"s3_checksum_metadata"`
+	ResumeTaskId       string            `mapstructure:"resume_task_id"`
+	DryRun             bool              `mapstructure:"dry_run"`
+	MaxImportTasks     int               `mapstructure:"max_concurrent_import_tasks"`
+	DetectArch         bool              `mapstructure:"detect_architecture"`
+	VerifyRole         bool              `mapstructure:"verify_role"`
+	Tags               map[string]string `mapstructure:"tags"`
+	SnapshotTags       map[string]string `mapstructure:"snapshot_tags"`
+	TagImportSource    bool              `mapstructure:"tag_import_source"`
+	Name               string            `mapstructure:"ami_name"`
+	IntermediaryName   string            `mapstructure:"intermediary_ami_name"`
+	CopyMaxAttempts    int               `mapstructure:"copy_image_max_attempts"`
+	Description        string            `mapstructure:"ami_description"`
+	Users              []string          `mapstructure:"ami_users"`
+	SnapshotUsers      []string          `mapstructure:"share_import_snapshot_with"`
+	Groups             []string          `mapstructure:"ami_groups"`
+	OrgArns            []string          `mapstructure:"ami_org_arns"`
+	OuArns             []string          `mapstructure:"ami_ou_arns"`
+	ShareDuration      time.Duration     `mapstructure:"ami_share_duration"`
+	Encrypt            bool              `mapstructure:"ami_encrypt"`
+	KMSKey             string            `mapstructure:"ami_kms_key"`
+	AMIRegions         []string          `mapstructure:"ami_regions"`
+	RegionKMSKeyIDs    map[string]string `mapstructure:"region_kms_key_ids"`
 	// Enforce version of the Instance Metadata Service on the built AMI.
 	// Valid options are unset (legacy) and `v2.0`. See the documentation on
 	// [IMDS](https://docs.aws.amazon.com/AWSEC2/latest/UserGuide/ec2-instance-metadata.html)
 	// for more information. Defaults to legacy.
 	AMIIMDSSupport string `mapstructure:"imds_support" required:"false"`
-	LicenseType    string `mapstructure:"license_type"`
-	RoleName       string `mapstructure:"role_name"`
-	Format         string `mapstructure:"format"`
-	Architecture   string `mapstructure:"architecture"`
-	BootMode       string `mapstructure:"boot_mode"`
-	Platform       string `mapstructure:"platform"`
+	// The hop limit of the PUT response of the Instance Metadata Service of
+	// the instances launched from the AMI, from 1 to 64. AMIs can't hold
+	// metadata options, so a launch template of the AMI with this hop limit
+	// is created, which needs the `ec2:CreateLaunchTemplate` permission,
+	// and `ec2:DeleteLaunchTemplate` to delete it when the post-processor
+	// fails afterwards or the artifact is destroyed. Its ID is recorded in
+	// the `imds_launch_template_id` artifact state.
+	IMDSHopLimit int `mapstructure:"imds_http_put_response_hop_limit" required:"false"`
+	// NitroTPM Support. Valid options are unset and `v2.0`. VM Import can't
+	// enable it, so the imported AMI is registered again from its snapshots
+	// with NitroTPM support, which needs the `ec2:RegisterImage` permission.
+	// Requires the `uefi` or `uefi-preferred` boot mode, and can't be set
+	// with the `windows` platform, registering the AMI again would lose its
+	// license. See the documentation on [NitroTPM
+	// Support](https://docs.aws.amazon.com/AWSEC2/latest/UserGuide/enable-nitrotpm-support-on-ami.html).
+	TpmSupport   string `mapstructure:"tpm_support" required:"false"`
+	LicenseType  string `mapstructure:"license_type"`
+	RoleName     string `mapstructure:"role_name"`
+	Format       string `mapstructure:"format"`
+	Architecture string `mapstructure:"architecture"`
+	BootMode     string `mapstructure:"boot_mode"`
+	Platform     string `mapstructure:"platform"`
 
 	ctx interpolate.Context
 }
 
 type PostProcessor struct {
 	config Config
+
+	regionClient func(config *aws.Config, region string) awscommon.Ec2Client
+	// checksumFiles are the files compute_checksum wrote for the uploaded
+	// disks.
+	checksumFiles []string
+	// diskURLs are the presigned URLs VM Import reads the disks from, by
+	// key, when s3_presigned_url is set.
+	diskURLs map[string]string
+	// warnings are found by Configure, and shown once PostProcess has a Ui.
+	warnings []string
 }
 
 func (p *PostProcessor) ConfigSpec() hcldec.ObjectSpec { return p.config.FlatMapstructure().HCL2Spec() }
@@ -82,6 +165,8 @@ func (p *PostProcessor) Configure(raws ...interface{}) error {
 		InterpolateFilter: &interpolate.RenderFilter{
 			Exclude: []string{
 				"s3_key_name",
+				"s3_key_prefix",
+				"intermediary_ami_name",
 			},
 		},
 	}, raws...)
@@ -94,16 +179,46 @@ func (p *PostProcessor) Configure(raws ...interface{}) error {
 		p.config.Format = "ova"
 	}
 
-	if p.config.S3Key == "" {
+	// An object uploaded by something else can't be found by the generated
+	// name, so it must be given when skipping the upload.
+	s3KeySet := p.config.S3Key != ""
+	if !s3KeySet && p.config.S3KeyPrefix != "" {
+		p.config.S3Key = prefixedS3Key(p.config.S3KeyPrefix, p.config.Format)
+	} else if !s3KeySet {
 		p.config.S3Key = "packer-import-{{timestamp}}." + p.config.Format
 	}
 
-	if p.config.Architecture == "" {
+	if p.config.Name != "" && p.config.IntermediaryName == "" {
+		p.config.IntermediaryName = "packer-import-intermediary-{{timestamp}}"
+	}
+
+	// detect_architecture checks the boot disk against architecture, so it
+	// isn't defaulted then.
+	if p.config.Architecture == "" && !p.config.DetectArch {
 		p.config.Architecture = "x86_64"
 	}
 
+	if p.config.CopyMaxAttempts == 0 {
+		p.config.CopyMaxAttempts = 11
+	}
+
+	if p.config.S3PresignedURL && p.config.S3PresignedExpiry == 0 {
+		p.config.S3PresignedExpiry = defaultPresignedURLExpiry
+	}
+
 	errs := new(packersdk.MultiError)
 
+	if p.config.S3KeyPrefix != "" {
+		if s3KeySet {
+			errs = packersdk.MultiErrorAppend(
+				errs, fmt.Errorf("s3_key_prefix and s3_key_name can't both be set"))
+		}
+		if strings.HasPrefix(p.config.S3KeyPrefix, "/") {
+			errs = packersdk.MultiErrorAppend(
+				errs, fmt.Errorf("s3_key_prefix must not start with '/', got '%s'", p.config.S3KeyPrefix))
+		}
+	}
+
 	if p.config.BootMode == "" {
 		// Graviton instance types run uefi by default
 		if p.config.Architecture == "arm64" {
@@ -111,7 +226,7 @@ func (p *PostProcessor) Configure(raws ...interface{}) error {
 		} else {
 			p.config.BootMode = "legacy-bios"
 		}
-	} else {
+	} else if p.config.BootMode != bootModeAuto {
 		err := awscommon.IsValidBootMode(p.config.BootMode)
 		if err != nil {
 			errs = packersdk.MultiErrorAppend(errs, err)
@@ -127,18 +242,101 @@ func (p *PostProcessor) Configure(raws ...interface{}) error {
 	// Check we have AWS access variables defined somewhere
 	errs = packersdk.MultiErrorAppend(errs, p.config.AccessConfig.Prepare(&p.config.PackerConfig)...)
 
+	var regionErrs []error
+	p.config.AMIRegions, regionErrs = awscommon.PrepareCopyRegions(&p.config.AccessConfig, p.config.AMIRegions, p.config.RegionKMSKeyIDs)
+	errs = packersdk.MultiErrorAppend(errs, regionErrs...)
+	if len(p.config.RegionKMSKeyIDs) > 0 && !p.config.Encrypt {
+		errs = packersdk.MultiErrorAppend(errs, fmt.Errorf("ami_encrypt must be true when region_kms_key_ids is set"))
+	}
+	for _, kmsKey := range p.config.RegionKMSKeyIDs {
+		if kmsKey != "" && !awscommon.ValidateKmsKey(kmsKey) {
+			errs = packersdk.MultiErrorAppend(errs, fmt.Errorf("%q is not a valid KMS Key Id.", kmsKey))
+		}
+	}
+
 	// define all our required parameters
 	templates := map[string]*string{
 		"s3_bucket_name": &p.config.S3Bucket,
 	}
 	// Check out required params are defined
 	for key, ptr := range templates {
-		if *ptr == "" {
+		// A resumed import doesn't upload anything.
+		if *ptr == "" && p.config.ResumeTaskId == "" {
 			errs = packersdk.MultiErrorAppend(
 				errs, fmt.Errorf("%s must be set", key))
 		}
 	}
 
+	// S3 refuses parts smaller than 5MB, except for the last one.
+	if p.config.S3PartSizeMB != 0 && p.config.S3PartSizeMB < 5 {
+		errs = packersdk.MultiErrorAppend(
+			errs, fmt.Errorf("s3_upload_part_size_mb must be at least 5, got %d", p.config.S3PartSizeMB))
+	}
+	if p.config.S3Concurrency < 0 {
+		errs = packersdk.MultiErrorAppend(
+			errs, fmt.Errorf("s3_upload_concurrency must be positive, got %d", p.config.S3Concurrency))
+	}
+
+	if p.config.SkipUpload {
+		if !s3KeySet {
+			errs = packersdk.MultiErrorAppend(
+				errs, fmt.Errorf("s3_key_name must be set to the object to import when skip_upload is true"))
+		}
+		if p.config.SkipUploadIfExists || p.config.S3UploadState != "" {
+			errs = packersdk.MultiErrorAppend(
+				errs, fmt.Errorf("skip_upload_if_exists and s3_upload_state_file can't be used with skip_upload"))
+		}
+		if !p.config.SkipClean {
+			errs = packersdk.MultiErrorAppend(
+				errs, fmt.Errorf("skip_clean must be true when skip_upload is set, the object to import was not uploaded by this build"))
+		}
+	}
+
+	// The object found may have been uploaded by another build, which
+	// still needs it, and cleaning up after the import would only make the
+	// next build upload it again.
+	if p.config.SkipUploadIfExists && !p.config.SkipUpload && !p.config.SkipClean {
+		errs = packersdk.MultiErrorAppend(
+			errs, fmt.Errorf("skip_clean must be true when skip_upload_if_exists is set, the object to import may not be uploaded by this build"))
+	}
+
+	// The generated s3_key_name changes with every build, so no object is
+	// ever found under it to skip the upload.
+	p.warnings = nil
+	if p.config.SkipUploadIfExists && !s3KeySet && !p.config.SkipUpload {
+		warning := "skip_upload_if_exists is set without s3_key_name, the upload is never skipped " +
+			"as the generated object name changes with every build. Set s3_key_name to a fixed name."
+		log.Printf("[WARN] %s", warning)
+		p.warnings = append(p.warnings, warning)
+	}
+
+	if p.config.ResumeTaskId != "" {
+		if !strings.HasPrefix(p.config.ResumeTaskId, "import-ami-") {
+			errs = packersdk.MultiErrorAppend(
+				errs, fmt.Errorf("invalid resume_task_id '%s', expected an import task ID like 'import-ami-0123456789abcdef0'", p.config.ResumeTaskId))
+		}
+		// The images of a resumed task were uploaded by an earlier build,
+		// which is the one to decide whether to keep them.
+		if !p.config.SkipClean {
+			errs = packersdk.MultiErrorAppend(
+				errs, fmt.Errorf("skip_clean must be true when resume_task_id is set, the images of the task were not uploaded by this build"))
+		}
+		if p.config.DryRun {
+			errs = packersdk.MultiErrorAppend(
+				errs, fmt.Errorf("dry_run can't be used with resume_task_id, the import of the task is already started"))
+		}
+	}
+
+	if p.config.CopyMaxAttempts < 1 {
+		errs = packersdk.MultiErrorAppend(
+			errs, fmt.Errorf("copy_image_max_attempts must be at least 1, got %d", p.config.CopyMaxAttempts))
+	}
+
+	if p.config.MaxImportTasks < 0 {
+		errs = packersdk.MultiErrorAppend(
+			errs, fmt.Errorf("max_concurrent_import_tasks must be positive, got %d", p.config.MaxImportTasks))
+	}
+
 	switch p.config.Format {
 	case "ova", "raw", "vmdk", "vhd", "vhdx":
 	default:
@@ -149,9 +347,9 @@ func (p *PostProcessor) Configure(raws ...interface{}) error {
 	switch p.config.Platform {
 	case "windows", "linux":
 	case "":
-		if p.config.BootMode == "uefi" {
+		if p.config.BootMode == "uefi" || p.config.BootMode == "uefi-preferred" {
 			errs = packersdk.MultiErrorAppend(
-				errs, fmt.Errorf("invalid platform '%s', 'platform' must be set for 'uefi' image imports", p.config.Platform))
+				errs, fmt.Errorf("invalid platform '%s', 'platform' must be set for '%s' image imports", p.config.Platform, p.config.BootMode))
 		}
 	default:
 		errs = packersdk.MultiErrorAppend(errs, fmt.Errorf(
@@ -162,16 +360,87 @@ func (p *PostProcessor) Configure(raws ...interface{}) error {
 			errs, fmt.Errorf("invalid s3 encryption format '%s'. Only 'AES256' and 'aws:kms' are allowed", p.config.S3Encryption))
 	}
 
-	if p.config.BootMode != "legacy-bios" && p.config.BootMode != "uefi" {
+	if len(p.config.S3Tags) > maxS3ObjectTags {
+		errs = packersdk.MultiErrorAppend(
+			errs, fmt.Errorf("s3_tags can't hold more than %d tags, S3 objects are limited to them", maxS3ObjectTags))
+	}
+
+	switch p.config.BootMode {
+	case "legacy-bios", "uefi", "uefi-preferred", bootModeAuto:
+	default:
 		errs = packersdk.MultiErrorAppend(
-			errs, fmt.Errorf("invalid boot mode '%s'. Only 'uefi' and 'legacy-bios' are allowed", p.config.BootMode))
+			errs, fmt.Errorf("invalid boot mode '%s'. Only 'uefi', 'legacy-bios', 'uefi-preferred' and 'auto' are allowed", p.config.BootMode))
 	}
 
-	if p.config.Architecture == "arm64" && p.config.BootMode != "uefi" {
+	// Graviton instances only boot with UEFI.
+	if p.config.Architecture == "arm64" && p.config.BootMode != "uefi" && p.config.BootMode != "uefi-preferred" {
 		errs = packersdk.MultiErrorAppend(
 			errs, fmt.Errorf("invalid boot mode '%s' for 'arm64' architecture", p.config.BootMode))
 	}
 
+	if p.config.DetectArch && p.config.Format != "raw" && p.config.Format != "vmdk" {
+		errs = packersdk.MultiErrorAppend(
+			errs, fmt.Errorf("detect_architecture is only supported with the 'raw' and 'vmdk' formats"))
+	}
+	if p.config.DetectArch && p.config.Architecture == "" {
+		errs = packersdk.MultiErrorAppend(
+			errs, fmt.Errorf("architecture must be set with detect_architecture, the boot disk is checked against it"))
+	}
+
+	if p.config.ComputeChecksum != "" {
+		if _, ok := checksumHashes[p.config.ComputeChecksum]; !ok {
+			errs = packersdk.MultiErrorAppend(
+				errs, fmt.Errorf("invalid compute_checksum '%s'. Only 'sha256' and 'sha512' are allowed", p.config.ComputeChecksum))
+		}
+		// The disk is only read when it is uploaded.
+		if p.config.SkipUpload || p.config.ResumeTaskId != "" {
+			errs = packersdk.MultiErrorAppend(
+				errs, fmt.Errorf("compute_checksum can't be used with skip_upload or resume_task_id"))
+		}
+		// Packer deletes the directory of the images once they are
+		// imported, unless keep_input_artifact is set, so the checksums
+		// are written to the working directory by default.
+		if p.config.ChecksumDirectory == "" {
+			p.config.ChecksumDirectory = "."
+		}
+	} else if p.config.S3ChecksumMetadata || p.config.ChecksumDirectory != "" {
+		errs = packersdk.MultiErrorAppend(errs, fmt.Errorf("s3_checksum_metadata and checksum_directory require compute_checksum"))
+	}
+
+	// Without ami_name, the imported AMI is the final one.
+	if p.config.IntermediaryName != "" && p.config.Name == "" {
+		errs = packersdk.MultiErrorAppend(errs, fmt.Errorf("intermediary_ami_name requires ami_name"))
+	}
+
+	// Prevent sharing of default KMS key encrypted volumes with other aws users
+	sharesAMI := len(p.config.Users) > 0 || len(p.config.OrgArns) > 0 || len(p.config.OuArns) > 0
+	if sharesAMI {
+		if p.config.Encrypt && p.config.KMSKey == "" {
+			errs = packersdk.MultiErrorAppend(errs, fmt.Errorf("Cannot share AMI encrypted with default KMS key"))
+		}
+	}
+
+	if len(p.config.SnapshotUsers) > 0 && p.config.Encrypt && p.config.KMSKey == "" {
+		errs = packersdk.MultiErrorAppend(errs, fmt.Errorf("Cannot share snapshots encrypted with default KMS key"))
+	}
+
+	// The copies are shared too, they need a key of their own in their
+	// region.
+	if (sharesAMI || len(p.config.SnapshotUsers) > 0) && p.config.Encrypt {
+		for _, region := range p.config.AMIRegions {
+			if p.config.RegionKMSKeyIDs[region] == "" {
+				errs = packersdk.MultiErrorAppend(errs, fmt.Errorf("Cannot share the copy of the AMI in %s encrypted with default KMS key, set its key in region_kms_key_ids", region))
+			}
+		}
+	}
+
+	if p.config.KMSKey != "" && !awscommon.ValidateKmsKey(p.config.KMSKey) {
+		errs = packersdk.MultiErrorAppend(errs, fmt.Errorf("%q is not a valid KMS Key Id.", p.config.KMSKey))
+	}
+	if p.config.S3EncryptionKey != "" && !awscommon.ValidateKmsKey(p.config.S3EncryptionKey) {
+		errs = packersdk.MultiErrorAppend(errs, fmt.Errorf("s3_encryption_key: %q is not a valid KMS Key Id.", p.config.S3EncryptionKey))
+	}
+
 	if p.config.AMIIMDSSupport != "" && p.config.AMIIMDSSupport != string(ec2types.ImdsSupportValuesV20) {
 		errs = packersdk.MultiErrorAppend(errs,
 			fmt.Errorf(`The only valid imds_support values are %q or the empty string`,
@@ -179,6 +448,45 @@ func (p *PostProcessor) Configure(raws ...interface{}) error {
 		)
 	}
 
+	if p.config.ShareDuration < 0 {
+		errs = packersdk.MultiErrorAppend(errs, fmt.Errorf("ami_share_duration can't be negative"))
+	} else if p.config.ShareDuration > 0 && !p.sharesImage() {
+		errs = packersdk.MultiErrorAppend(errs, fmt.Errorf("ami_share_duration requires ami_users, "+
+			"ami_groups, ami_org_arns, ami_ou_arns or share_import_snapshot_with to be set"))
+	}
+
+	if p.config.S3PresignedExpiry != 0 && !p.config.S3PresignedURL {
+		errs = packersdk.MultiErrorAppend(errs, fmt.Errorf("s3_presigned_url_expiry requires s3_presigned_url to be true"))
+	} else if p.config.S3PresignedExpiry < 0 || p.config.S3PresignedExpiry > maxPresignedURLExpiry {
+		errs = packersdk.MultiErrorAppend(errs, fmt.Errorf("s3_presigned_url_expiry must be positive and at most %s, got %s",
+			maxPresignedURLExpiry, p.config.S3PresignedExpiry))
+	}
+	if p.config.S3PresignedURL && p.config.ResumeTaskId != "" {
+		errs = packersdk.MultiErrorAppend(errs, fmt.Errorf("s3_presigned_url can't be set with resume_task_id, "+
+			"the task reads the disks the way it was started with"))
+	}
+
+	if p.config.IMDSHopLimit != 0 && (p.config.IMDSHopLimit < 1 || p.config.IMDSHopLimit > 64) {
+		errs = packersdk.MultiErrorAppend(errs,
+			fmt.Errorf("imds_http_put_response_hop_limit must be between 1 and 64, got %d", p.config.IMDSHopLimit))
+	}
+
+	if p.config.TpmSupport != "" {
+		if p.config.TpmSupport != string(ec2types.TpmSupportValuesV20) {
+			errs = packersdk.MultiErrorAppend(errs,
+				fmt.Errorf(`The only valid tpm_support values are %q or the empty string`,
+					string(ec2types.TpmSupportValuesV20)),
+			)
+		} else if p.config.BootMode == "legacy-bios" {
+			errs = packersdk.MultiErrorAppend(errs,
+				fmt.Errorf("tpm_support requires the 'uefi' or 'uefi-preferred' boot mode"))
+		} else if p.config.Platform == "windows" {
+			errs = packersdk.MultiErrorAppend(errs,
+				fmt.Errorf("tpm_support can't be set with the 'windows' platform, registering "+
+					"the imported AMI again from its snapshots would lose its Windows license"))
+		}
+	}
+
 	// Anything which flagged return back up the stack
 	if len(errs.Errors) > 0 {
 		return errs
@@ -193,8 +501,15 @@ func (p *PostProcessor) Configure(raws ...interface{}) error {
 	return nil
 }
 
-func (p *PostProcessor) PostProcess(ctx context.Context, ui packersdk.Ui, artifact packersdk.Artifact) (packersdk.Artifact, bool, bool, error) {
-	var err error
+func (p *PostProcessor) PostProcess(ctx context.Context, ui packersdk.Ui, artifact packersdk.Artifact) (_ packersdk.Artifact, _ bool, _ bool, err error) {
+	// The checksum files are only kept along with the artifact they are
+	// part of.
+	defer func() {
+		if err != nil {
+			p.removeChecksumFiles()
+		}
+	}()
+
 	config, err := p.config.Config(ctx)
 
 	if err != nil {
@@ -208,132 +523,149 @@ func (p *PostProcessor) PostProcess(ctx context.Context, ui packersdk.Ui, artifa
 	}
 	p.config.ctx.Data = generatedData
 
-	s3Client := s3.NewFromConfig(*config)
-
-	// Render this key since we didn't in the configure phase
-	p.config.S3Key, err = interpolate.Render(p.config.S3Key, &p.config.ctx)
-	if err != nil {
-		return nil, false, false, fmt.Errorf("Error rendering s3_key_name template: %s", err)
-	}
-	log.Printf("Rendered s3_key_name as %s", p.config.S3Key)
-
-	log.Println("Looking for image in artifact")
-	// Locate the files output from the builder
-	source := ""
-	for _, path := range artifact.Files() {
-		if strings.HasSuffix(path, "."+p.config.Format) {
-			source = path
-			break
-		}
-	}
-
-	// Hope we found something useful
-	if source == "" {
-		return nil, false, false, fmt.Errorf("No %s image file found in artifact from builder", p.config.Format)
+	for _, warning := range append(p.warnings, p.config.encryptionKeyWarnings()...) {
+		ui.Error("Warning: " + warning)
 	}
 
-	if p.config.S3Encryption == "AES256" && p.config.S3EncryptionKey != "" {
-		ui.Say(fmt.Sprintf("Ignoring s3_encryption_key because s3_encryption is set to '%s'", p.config.S3Encryption))
-	}
+	s3Client := s3.NewFromConfig(*config)
 
-	// open the source file
-	log.Printf("Opening file %s to upload", source)
-	file, err := os.Open(source)
+	ec2Client, err := p.config.NewEC2Client(ctx)
 	if err != nil {
-		return nil, false, false, fmt.Errorf("Failed to open %s: %s", source, err)
-	}
-
-	ui.Say(fmt.Sprintf("Uploading %s to s3://%s/%s", source, p.config.S3Bucket, p.config.S3Key))
-
-	// Prepare S3 request
-	updata := &s3.PutObjectInput{
-		Body:   file,
-		Bucket: &p.config.S3Bucket,
-		Key:    &p.config.S3Key,
+		return nil, false, false, fmt.Errorf("failed to create EC2 client: %s", err)
 	}
 
-	// Add encryption if specified in the config
-	if p.config.S3Encryption != "" {
-		updata.ServerSideEncryption = s3types.ServerSideEncryption(p.config.S3Encryption)
-		if p.config.S3Encryption == string(s3types.ServerSideEncryptionAwsKms) && p.config.S3EncryptionKey != "" {
-			updata.SSEKMSKeyId = aws.String(p.config.S3EncryptionKey)
+	var taskId string
+	var keys []string
+	if p.config.ResumeTaskId != "" {
+		taskId = p.config.ResumeTaskId
+		if err := p.resumeImport(ctx, ec2Client, ui, taskId); err != nil {
+			return nil, false, false, err
 		}
-	}
+	} else {
+		// Render this key since we didn't in the configure phase
+		p.config.S3Key, err = interpolate.Render(p.config.S3Key, &p.config.ctx)
+		if err != nil {
+			return nil, false, false, fmt.Errorf("Error rendering s3_key_name template: %s", err)
+		}
+		log.Printf("Rendered s3_key_name as %s", p.config.S3Key)
 
-	// Copy the image file into the S3 bucket specified
-	uploader := manager.NewUploader(s3Client)
-	if _, err = uploader.Upload(ctx, updata); err != nil {
-		return nil, false, false, fmt.Errorf("Failed to upload %s: %s", source, err)
-	}
+		if p.config.VerifyRole {
+			roleName := p.config.RoleName
+			if roleName == "" {
+				roleName = defaultImportRole
+			}
+			ui.Say(fmt.Sprintf("Verifying the import role %s", roleName))
+			// VM Import reads the disks through the presigned URLs, the
+			// role needs no access to the bucket then.
+			bucket := p.config.S3Bucket
+			if p.config.S3PresignedURL {
+				bucket = ""
+			}
+			warning, err := verifyImportRole(ctx, iam.NewFromConfig(*config), roleName, bucket, p.config.S3Key)
+			if err != nil {
+				return nil, false, false, err
+			}
+			if warning != "" {
+				ui.Error("Warning: " + warning)
+			}
+		}
 
-	// May as well stop holding this open now
-	file.Close()
+		keys, err = p.importSources(ctx, ui, s3Client, artifact)
+		if err != nil {
+			return nil, false, false, err
+		}
+		// The uploaded images are of no use when the post-processor fails
+		// before the import starts.
+		pending := keys
+		defer func() {
+			if err != nil && len(pending) > 0 {
+				if cleanErr := p.deleteSources(ctx, ui, s3Client, pending); cleanErr != nil {
+					ui.Error(fmt.Sprintf("Warning: %s", cleanErr))
+				}
+			}
+		}()
 
-	ui.Say(fmt.Sprintf("Completed upload of %s to s3://%s/%s", source, p.config.S3Bucket, p.config.S3Key))
+		// The URLs are presigned once a slot is free, for them not to
+		// expire while waiting.
+		if !p.config.DryRun {
+			if err := p.waitForImportSlot(ctx, ec2Client, ui); err != nil {
+				return nil, false, false, err
+			}
+		}
 
-	// Call EC2 image import process
-	log.Printf("Calling EC2 to import from s3://%s/%s", p.config.S3Bucket, p.config.S3Key)
+		if p.config.S3PresignedURL {
+			p.diskURLs, err = presignDiskURLs(ctx, s3.NewPresignClient(s3Client), p.config.S3Bucket, keys,
+				p.config.S3PresignedExpiry)
+			if err != nil {
+				return nil, false, false, err
+			}
+		}
 
-	ec2Client, err := p.config.NewEC2Client(ctx)
-	if err != nil {
-		return nil, false, false, fmt.Errorf("failed to create EC2 client: %s", err)
-	}
+		if p.config.DryRun {
+			if err := p.dryRunImport(ctx, ec2Client, ui, keys); err != nil {
+				return nil, false, false, err
+			}
+			pending = nil
+			if err := p.deleteSources(ctx, ui, s3Client, keys); err != nil {
+				return nil, false, false, err
+			}
+			// Nothing was imported, the input artifact is passed on.
+			p.removeChecksumFiles()
+			return artifact, true, false, nil
+		}
 
-	params := &ec2.ImportImageInput{
-		Encrypted: &p.config.Encrypt,
-		DiskContainers: []ec2types.ImageDiskContainer{
-			{
-				Format: &p.config.Format,
-				UserBucket: &ec2types.UserBucket{
-					S3Bucket: &p.config.S3Bucket,
-					S3Key:    &p.config.S3Key,
-				},
-			},
-		},
-		Architecture: &p.config.Architecture,
-		BootMode:     ec2types.BootModeValues(p.config.BootMode),
-		Platform:     &p.config.Platform,
+		taskId, err = p.startImport(ctx, ec2Client, ui, keys)
+		if err != nil {
+			return nil, false, false, err
+		}
+		pending = nil
 	}
 
-	if p.config.Encrypt && p.config.KMSKey != "" {
-		params.KmsKeyId = &p.config.KMSKey
-	}
+	var ec2Tags, ec2SnapshotTags []ec2types.Tag
+	observers := []awscommon.ImportTaskObserver{(&progressReporter{ui: ui}).observe}
+	if len(p.config.Tags) > 0 {
+		log.Printf("Repacking tags into AWS format")
 
-	if p.config.RoleName != "" {
-		params.RoleName = &p.config.RoleName
+		for key, value := range p.config.Tags {
+			ui.Say(fmt.Sprintf("Adding tag \"%s\": \"%s\"", key, value))
+			ec2Tags = append(ec2Tags, ec2types.Tag{
+				Key:   aws.String(key),
+				Value: aws.String(value),
+			})
+		}
 	}
-
-	if p.config.LicenseType != "" {
-		ui.Say(fmt.Sprintf("Setting license type to '%s'", p.config.LicenseType))
-		params.LicenseType = &p.config.LicenseType
+	// Without snapshot_tags, the snapshots get the tags of the AMI.
+	if len(p.config.SnapshotTags) > 0 {
+		for key, value := range p.config.SnapshotTags {
+			ui.Say(fmt.Sprintf("Adding snapshot tag \"%s\": \"%s\"", key, value))
+			ec2SnapshotTags = append(ec2SnapshotTags, ec2types.Tag{
+				Key:   aws.String(key),
+				Value: aws.String(value),
+			})
+		}
 	}
 
-	var importStart *ec2.ImportImageOutput
-	err = retry.Config{
-		Tries:      11,
-		RetryDelay: (&retry.Backoff{InitialBackoff: 200 * time.Millisecond, MaxBackoff: 30 * time.Second, Multiplier: 2}).Linear,
-	}.Run(ctx, func(ctx context.Context) error {
-		importStart, err = ec2Client.ImportImage(ctx, params)
-		return err
-	})
-
-	if err != nil {
-		return nil, false, false, fmt.Errorf("Failed to start import from s3://%s/%s: %s", p.config.S3Bucket, p.config.S3Key, err)
+	if earlyTags := snapshotTagsOrDefault(ec2SnapshotTags, ec2Tags); len(earlyTags) > 0 {
+		// Tag the snapshot as soon as the import creates it, rather than
+		// waiting for the AMI to become available.
+		tagger := &snapshotTagger{
+			client: ec2Client,
+			ui:     ui,
+			tags:   earlyTags,
+		}
+		observers = append(observers, tagger.observe)
 	}
 
-	ui.Say(fmt.Sprintf("Started import of s3://%s/%s, task id %s", p.config.S3Bucket, p.config.S3Key,
-		*importStart.ImportTaskId))
-
 	// Wait for import process to complete, this takes a while
-	ui.Say(fmt.Sprintf("Waiting for task %s to complete (may take a while)", *importStart.ImportTaskId))
+	ui.Say(fmt.Sprintf("Waiting for task %s to complete (may take a while)", taskId))
 
-	err = p.config.PollingConfig.WaitUntilImageImported(ctx, ec2Client, *importStart.ImportTaskId)
+	err = p.config.PollingConfig.WaitUntilImageImported(ctx, ec2Client, taskId, observers...)
 	if err != nil {
 
 		// Retrieve the status message
 		importResult, err2 := ec2Client.DescribeImportImageTasks(ctx, &ec2.DescribeImportImageTasksInput{
 			ImportTaskIds: []string{
-				*importStart.ImportTaskId,
+				taskId,
 			},
 		})
 
@@ -342,26 +674,26 @@ func (p *PostProcessor) PostProcess(ctx context.Context, ui packersdk.Ui, artifa
 		if err2 == nil {
 			statusMessage = *importResult.ImportImageTasks[0].StatusMessage
 		}
-		return nil, false, false, fmt.Errorf("Import task %s failed with status message: %s, error: %s", *importStart.ImportTaskId, statusMessage, err)
+		return nil, false, false, fmt.Errorf("Import task %s failed with status message: %s, error: %s", taskId, statusMessage, err)
 	}
 
 	// Retrieve what the outcome was for the import task
 	importResult, err := ec2Client.DescribeImportImageTasks(ctx, &ec2.DescribeImportImageTasksInput{
 		ImportTaskIds: []string{
-			*importStart.ImportTaskId,
+			taskId,
 		},
 	})
 
 	if err != nil {
-		return nil, false, false, fmt.Errorf("Failed to find import task %s: %s", *importStart.ImportTaskId, err)
+		return nil, false, false, fmt.Errorf("Failed to find import task %s: %s", taskId, err)
 	}
 	// Check it was actually completed
 	if *importResult.ImportImageTasks[0].Status != "completed" {
 		// The most useful error message is from the job itself
-		return nil, false, false, fmt.Errorf("Import task %s failed: %s", *importStart.ImportTaskId, *importResult.ImportImageTasks[0].StatusMessage)
+		return nil, false, false, fmt.Errorf("Import task %s failed: %s", taskId, *importResult.ImportImageTasks[0].StatusMessage)
 	}
 
-	ui.Say(fmt.Sprintf("Import task %s complete", *importStart.ImportTaskId))
+	ui.Say(fmt.Sprintf("Import task %s complete", taskId))
 
 	// Pull AMI ID out of the completed job
 	createdami := *importResult.ImportImageTasks[0].ImageId
@@ -370,10 +702,14 @@ func (p *PostProcessor) PostProcess(ctx context.Context, ui packersdk.Ui, artifa
 
 		ui.Say(fmt.Sprintf("Starting rename of AMI (%s)", createdami))
 
+		p.tagIntermediary(ctx, ec2Client, ui, createdami)
+
 		copyInput := &ec2.CopyImageInput{
 			Name:          &p.config.Name,
 			SourceImageId: &createdami,
 			SourceRegion:  aws.String(config.Region),
+			// Retries reuse the token, so they can't start a second copy.
+			ClientToken: aws.String("packer-rename-" + taskId),
 		}
 		if p.config.Encrypt {
 			copyInput.Encrypted = aws.Bool(p.config.Encrypt)
@@ -382,7 +718,7 @@ func (p *PostProcessor) PostProcess(ctx context.Context, ui packersdk.Ui, artifa
 			}
 		}
 
-		resp, err := ec2Client.CopyImage(ctx, copyInput)
+		resp, err := p.copyImage(ctx, ec2Client, copyInput)
 
 		if err != nil {
 			return nil, false, false, fmt.Errorf("Error Copying AMI (%s): %s", createdami, err)
@@ -406,59 +742,33 @@ func (p *PostProcessor) PostProcess(ctx context.Context, ui packersdk.Ui, artifa
 		createdami = *resp.ImageId
 	}
 
-	// If we have tags, then apply them now to both the AMI and snaps
-	// created by the import
-	if len(p.config.Tags) > 0 {
-		var ec2Tags []ec2types.Tag
-
-		log.Printf("Repacking tags into AWS format")
-
-		for key, value := range p.config.Tags {
-			ui.Say(fmt.Sprintf("Adding tag \"%s\": \"%s\"", key, value))
-			ec2Tags = append(ec2Tags, ec2types.Tag{
-				Key:   aws.String(key),
-				Value: aws.String(value),
-			})
-		}
-
-		resourceIds := []string{createdami}
-
-		log.Printf("Getting details of %s", createdami)
-
-		imageResp, err := ec2Client.DescribeImages(ctx, &ec2.DescribeImagesInput{
-			ImageIds: resourceIds,
-		})
-
+	if p.config.TpmSupport != "" {
+		ui.Say(fmt.Sprintf("Registering AMI %s again with NitroTPM support %s", createdami, p.config.TpmSupport))
+		createdami, err = p.registerWithTpmSupport(ctx, ec2Client, createdami)
 		if err != nil {
-			return nil, false, false, fmt.Errorf("Failed to retrieve details for AMI %s: %s", createdami, err)
+			return nil, false, false, fmt.Errorf("Error registering the AMI with NitroTPM support: %s", err)
 		}
+		ui.Message(fmt.Sprintf("AMI: %s", createdami))
+	}
 
-		if len(imageResp.Images) == 0 {
-			return nil, false, false, fmt.Errorf("AMI %s has no images", createdami)
+	// If we have tags, then apply them now to both the AMI and snaps
+	// created by the import
+	if len(ec2Tags) > 0 || len(ec2SnapshotTags) > 0 {
+		if err := tagImage(ctx, ec2Client, ui, createdami, ec2Tags, ec2SnapshotTags); err != nil {
+			return nil, false, false, err
 		}
+	}
 
-		image := imageResp.Images[0]
-
-		log.Printf("Walking block device mappings for %s to find snapshots", createdami)
-
-		for _, device := range image.BlockDeviceMappings {
-			if device.Ebs != nil && device.Ebs.SnapshotId != nil {
-				ui.Say(fmt.Sprintf("Tagging snapshot %s", *device.Ebs.SnapshotId))
-				resourceIds = append(resourceIds, *device.Ebs.SnapshotId)
-			}
+	if p.config.TagImportSource {
+		if err := p.tagImportSource(ctx, ec2Client, ui, createdami, taskId, keys); err != nil {
+			return nil, false, false, err
 		}
+	}
 
-		ui.Say(fmt.Sprintf("Tagging AMI %s", createdami))
-
-		_, err = ec2Client.CreateTags(ctx, &ec2.CreateTagsInput{
-			Resources: resourceIds,
-			Tags:      ec2Tags,
-		})
-
-		if err != nil {
-			return nil, false, false, fmt.Errorf("Failed to add tags to resources %#v: %s", resourceIds, err)
+	if len(p.config.SnapshotUsers) > 0 {
+		if err := p.shareSnapshots(ctx, ec2Client, ui, createdami); err != nil {
+			return nil, false, false, err
 		}
-
 	}
 
 	// Apply attributes for AMI specified in config
@@ -541,38 +851,1151 @@ func (p *PostProcessor) PostProcess(ctx context.Context, ui packersdk.Ui, artifa
 
 	}
 
-	if len(options) > 0 {
-		for name, input := range options {
-			ui.Say(fmt.Sprintf("Modifying: %s", name))
-			input.ImageId = &createdami
-			_, err := ec2Client.ModifyImageAttribute(ctx, input)
-			if err != nil {
-				return nil, false, false, fmt.Errorf("Error modifying AMI attributes: %s", err)
-			}
-		}
+	if err := modifyImage(ctx, ec2Client, ui, createdami, options); err != nil {
+		return nil, false, false, err
 	}
 
-	// Add the reported AMI ID to the artifact list
-	log.Printf("Adding created AMI ID %s in region %s to output artifacts", createdami, config.Region)
-	artifact = &awscommon.Artifact{
-		Amis: map[string]string{
-			config.Region: createdami,
-		},
-		BuilderIdValue: BuilderId,
-		Config:         config,
+	var unshareAfter string
+	if p.config.ShareDuration > 0 {
+		unshareTag, err := p.tagUnshareAfter(ctx, ec2Client, ui, createdami, time.Now())
+		if err != nil {
+			return nil, false, false, err
+		}
+		unshareAfter = aws.ToString(unshareTag.Value)
+
+		// The copies are shared too, only their AMI gets the tag.
+		ec2SnapshotTags = snapshotTagsOrDefault(ec2SnapshotTags, ec2Tags)
+		ec2Tags = append(append([]ec2types.Tag{}, ec2Tags...), unshareTag)
 	}
 
-	if !p.config.SkipClean {
-		ui.Say(fmt.Sprintf("Deleting import source s3://%s/%s", p.config.S3Bucket, p.config.S3Key))
+	var launchTemplateId string
+	if p.config.IMDSHopLimit != 0 {
+		launchTemplateId, err = p.createMetadataLaunchTemplate(ctx, ec2Client, ui, createdami)
+		if err != nil {
+			return nil, false, false, err
+		}
+		// The launch template is only kept along with the artifact it is
+		// part of.
+		defer func() {
+			if err != nil {
+				p.deleteLaunchTemplate(ctx, ec2Client, ui, launchTemplateId)
+			}
+		}()
+	}
 
-		_, err = s3Client.DeleteObject(ctx, &s3.DeleteObjectInput{
-			Bucket: &p.config.S3Bucket,
-			Key:    &p.config.S3Key,
-		})
+	var copies map[string]string
+	if len(p.config.AMIRegions) > 0 {
+		copies, err = p.copyToRegions(ctx, config, ec2Client, ui, createdami, taskId, keys, ec2Tags, ec2SnapshotTags, options)
 		if err != nil {
-			return nil, false, false, fmt.Errorf("Failed to delete s3://%s/%s: %s", p.config.S3Bucket, p.config.S3Key, err)
+			return nil, false, false, err
 		}
 	}
 
+	// Add the reported AMI ID to the artifact list
+	log.Printf("Adding created AMI ID %s in region %s to output artifacts", createdami, config.Region)
+	importTask := importArtifact(config, createdami, taskId)
+	for region, amiId := range copies {
+		importTask.Amis[region] = amiId
+	}
+	importTask.LocalFiles = p.checksumFiles
+	if len(keys) > 0 {
+		recordSources(importTask, p.config.S3Bucket, keys, objectVersions(ctx, s3Client, p.config.S3Bucket, keys))
+	}
+	if launchTemplateId != "" {
+		importTask.StateData["imds_launch_template_id"] = launchTemplateId
+	}
+	if unshareAfter != "" {
+		importTask.StateData["unshare_after"] = unshareAfter
+	}
+
+	// The task ARN needs the account ID, which is only known to STS. Audit
+	// tooling can still fall back to the task ID and region if it fails.
+	identity, err := sts.NewFromConfig(*config).GetCallerIdentity(ctx, &sts.GetCallerIdentityInput{})
+	if err != nil {
+		log.Printf("[WARN] Failed to get caller identity, import task ARN will not be recorded: %s", err)
+	} else if taskArn, err := importTaskArn(aws.ToString(identity.Arn), config.Region, taskId); err != nil {
+		log.Printf("[WARN] Failed to build import task ARN: %s", err)
+	} else {
+		importTask.StateData["import_task_arn"] = taskArn
+	}
+	artifact = importTask
+
+	if err := p.deleteSources(ctx, ui, s3Client, keys); err != nil {
+		return nil, false, false, err
+	}
+
 	return artifact, false, false, nil
 }
+
+// warnDiskSubtype warns when the VHD or VHDX disk at source isn't a fixed
+// disk, which the import may reject or expand to its full size.
+func warnDiskSubtype(ui packersdk.Ui, source, format string) {
+	subtype, err := diskSubtype(source, format)
+	if err != nil {
+		log.Printf("[WARN] Failed to read the %s headers of %s: %s", format, source, err)
+		return
+	}
+	log.Printf("%s is a %s %s disk", source, subtype, format)
+
+	switch subtype {
+	case vhdDynamic:
+		ui.Error(fmt.Sprintf("Warning: %s is a dynamic %s disk. The import may reject it or expand it to "+
+			"its full size, consider converting it to a fixed disk, for instance with "+
+			"`qemu-img convert -O %s -o subformat=fixed`.", source, format, qemuFormat(format)))
+	case vhdDifferencing:
+		ui.Error(fmt.Sprintf("Warning: %s is a differencing %s disk, which only holds the changes to its "+
+			"parent disk. The import will likely fail, merge it into a fixed disk first.", source, format))
+	}
+}
+
+// warnDiskArchitecture warns when the boot disk at source was detected to be
+// of another architecture than the AMI, which wouldn't boot. The detection
+// relies on the EFI boot loader of the disk, so it is only advisory.
+func warnDiskArchitecture(ui packersdk.Ui, source, format, architecture string) {
+	detected, err := diskArchitecture(source, format)
+	if errors.Is(err, errNoEFIPartition) {
+		log.Printf("%s has no EFI system partition, it boots with legacy BIOS", source)
+		if architecture == "arm64" {
+			ui.Error(fmt.Sprintf("Warning: %s has no EFI system partition, so it likely boots with legacy "+
+				"BIOS, which 'arm64' AMIs don't support. Check the disk, or set 'architecture' to "+
+				"'x86_64' if the disk is not an arm64 one.", source))
+		}
+		return
+	}
+	if err != nil {
+		log.Printf("[WARN] Failed to detect the architecture of %s: %s", source, err)
+		return
+	}
+	log.Printf("%s has a %s EFI boot loader", source, detected)
+
+	if detected != architecture {
+		ui.Error(fmt.Sprintf("Warning: %s has a %s EFI boot loader, but 'architecture' is '%s'. The "+
+			"imported AMI will likely not boot, consider setting 'architecture' to '%s'.",
+			source, detected, architecture, detected))
+	}
+}
+
+// encryptionKeyWarnings returns what is likely unintended in how the images
+// are encrypted in S3 and the AMI is. The two use their own KMS key, which
+// may differ, so a key set for only one of them is worth pointing out.
+func (c *Config) encryptionKeyWarnings() []string {
+	var warnings []string
+	if c.KMSKey != "" && !c.Encrypt {
+		warnings = append(warnings, "ami_kms_key is set but ami_encrypt isn't, the AMI won't be encrypted. "+
+			"Set ami_encrypt to encrypt it with ami_kms_key.")
+	}
+
+	// The images of a resumed task, or not uploaded, were encrypted by
+	// something else.
+	if c.SkipUpload || c.ResumeTaskId != "" {
+		return warnings
+	}
+	switch c.S3Encryption {
+	case "":
+		if c.S3EncryptionKey != "" {
+			warnings = append(warnings, "s3_encryption_key is set but s3_encryption isn't, the images are "+
+				"uploaded unencrypted. Set s3_encryption to 'aws:kms' to encrypt them with s3_encryption_key.")
+		}
+	case string(s3types.ServerSideEncryptionAwsKms):
+		switch {
+		case !c.Encrypt:
+			warnings = append(warnings, "The images are encrypted in S3 with KMS, but the AMI isn't encrypted. "+
+				"Set ami_encrypt, and ami_kms_key to use a key of your own, to encrypt it too.")
+		case c.S3EncryptionKey != "" && c.KMSKey == "":
+			warnings = append(warnings, "The images are encrypted in S3 with s3_encryption_key, but the AMI is "+
+				"encrypted with the default EBS key, as ami_kms_key isn't set.")
+		case c.S3EncryptionKey == "" && c.KMSKey != "":
+			warnings = append(warnings, "The AMI is encrypted with ami_kms_key, but the images are encrypted "+
+				"in S3 with the default S3 key, as s3_encryption_key isn't set.")
+		}
+	}
+	return warnings
+}
+
+// qemuFormat returns the qemu-img name of a VHD or VHDX format.
+func qemuFormat(format string) string {
+	if format == "vhd" {
+		return "vpc"
+	}
+	return format
+}
+
+// prefixedS3Key returns the key of an image of format under prefix, with a
+// name unique to the build.
+func prefixedS3Key(prefix, format string) string {
+	if !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	return fmt.Sprintf("%spacker-import-{{timestamp}}-%s.%s", prefix, uuid.TimeOrderedUUID(), format)
+}
+
+// snapshotTagsOrDefault returns the tags of the snapshots, which are the
+// tags of the AMI unless snapshot_tags is set.
+func snapshotTagsOrDefault(snapshotTags, tags []ec2types.Tag) []ec2types.Tag {
+	if len(snapshotTags) > 0 {
+		return snapshotTags
+	}
+	return tags
+}
+
+// tagIntermediary tags the AMI amiId the import created, and its snapshots,
+// with the intermediary_ami_name as Name and the intermediaryTagKey. AMI
+// names can't be changed, the Name tag is what the console shows instead.
+// Failing to tag only leaves the intermediary harder to find, so it is not
+// fatal.
+func (p *PostProcessor) tagIntermediary(ctx context.Context, client awscommon.Ec2Client, ui packersdk.Ui, amiId string) {
+	name, err := interpolate.Render(p.config.IntermediaryName, &p.config.ctx)
+	if err != nil {
+		ui.Error(fmt.Sprintf("Warning: Error rendering intermediary_ami_name template: %s", err))
+		return
+	}
+
+	tags := []ec2types.Tag{
+		{Key: aws.String("Name"), Value: aws.String(name)},
+		{Key: aws.String(intermediaryTagKey), Value: aws.String("true")},
+	}
+	if err := tagImage(ctx, client, ui, amiId, tags, nil); err != nil {
+		ui.Error(fmt.Sprintf("Warning: Failed to tag intermediary AMI (%s), it will be harder to find "+
+			"if it can't be deleted: %s", amiId, err))
+	}
+}
+
+// tagImage applies tags to the AMI amiId, and snapshotTags to its snapshots.
+// If snapshotTags is empty, the snapshots are tagged with tags along with the
+// AMI.
+func tagImage(ctx context.Context, client awscommon.Ec2Client, ui packersdk.Ui, amiId string, tags, snapshotTags []ec2types.Tag) error {
+	resourceIds := []string{amiId}
+	var snapshotIds []string
+
+	log.Printf("Getting details of %s", amiId)
+
+	imageResp, err := client.DescribeImages(ctx, &ec2.DescribeImagesInput{
+		ImageIds: resourceIds,
+	})
+
+	if err != nil {
+		return fmt.Errorf("Failed to retrieve details for AMI %s: %s", amiId, err)
+	}
+
+	if len(imageResp.Images) == 0 {
+		return fmt.Errorf("AMI %s has no images", amiId)
+	}
+
+	image := imageResp.Images[0]
+
+	log.Printf("Walking block device mappings for %s to find snapshots", amiId)
+
+	for _, device := range image.BlockDeviceMappings {
+		if device.Ebs != nil && device.Ebs.SnapshotId != nil {
+			ui.Say(fmt.Sprintf("Tagging snapshot %s", *device.Ebs.SnapshotId))
+			snapshotIds = append(snapshotIds, *device.Ebs.SnapshotId)
+		}
+	}
+
+	// The snapshots get their own tags in a call of their own.
+	if len(snapshotTags) == 0 {
+		resourceIds = append(resourceIds, snapshotIds...)
+	}
+
+	if len(tags) > 0 {
+		ui.Say(fmt.Sprintf("Tagging AMI %s", amiId))
+
+		_, err = client.CreateTags(ctx, &ec2.CreateTagsInput{
+			Resources: resourceIds,
+			Tags:      tags,
+		})
+
+		if err != nil {
+			return fmt.Errorf("Failed to add tags to resources %#v: %s", resourceIds, err)
+		}
+	}
+
+	if len(snapshotTags) > 0 && len(snapshotIds) > 0 {
+		_, err = client.CreateTags(ctx, &ec2.CreateTagsInput{
+			Resources: snapshotIds,
+			Tags:      snapshotTags,
+		})
+
+		if err != nil {
+			return fmt.Errorf("Failed to add tags to snapshots %#v: %s", snapshotIds, err)
+		}
+	}
+	return nil
+}
+
+// modifyImage applies the attributes of options to the AMI amiId.
+func modifyImage(ctx context.Context, client awscommon.Ec2Client, ui packersdk.Ui, amiId string, options map[string]*ec2.ModifyImageAttributeInput) error {
+	for name, input := range options {
+		ui.Say(fmt.Sprintf("Modifying: %s", name))
+		input.ImageId = aws.String(amiId)
+		_, err := client.ModifyImageAttribute(ctx, input)
+		if err != nil {
+			return fmt.Errorf("Error modifying AMI attributes: %s", err)
+		}
+	}
+	return nil
+}
+
+// copyToRegions copies the AMI amiId to each of ami_regions, encrypted with
+// the KMS key of the region if any, and gives the copies the tags and
+// attributes of the AMI. It returns the IDs of the copies by region.
+func (p *PostProcessor) copyToRegions(ctx context.Context, config *aws.Config, client awscommon.Ec2Client, ui packersdk.Ui, amiId, taskId string, keys []string, tags, snapshotTags []ec2types.Tag, options map[string]*ec2.ModifyImageAttributeInput) (_ map[string]string, err error) {
+	if p.regionClient == nil {
+		p.regionClient = func(config *aws.Config, region string) awscommon.Ec2Client {
+			return ec2.NewFromConfig(*config, func(o *ec2.Options) {
+				o.Region = region
+			})
+		}
+	}
+
+	imageResp, err := client.DescribeImages(ctx, &ec2.DescribeImagesInput{
+		ImageIds: []string{amiId},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Failed to retrieve details for AMI %s: %s", amiId, err)
+	}
+	if len(imageResp.Images) == 0 {
+		return nil, fmt.Errorf("AMI %s has no images", amiId)
+	}
+	image := imageResp.Images[0]
+
+	copies := make(map[string]string, len(p.config.AMIRegions))
+	// The copies are only kept along with the artifact they are part of.
+	defer func() {
+		if err != nil {
+			p.destroyCopies(ui, config, copies)
+		}
+	}()
+	for _, region := range p.config.AMIRegions {
+		ui.Say(fmt.Sprintf("Copying AMI (%s) to region %s", amiId, region))
+
+		copyInput := &ec2.CopyImageInput{
+			Name:          image.Name,
+			Description:   image.Description,
+			SourceImageId: aws.String(amiId),
+			SourceRegion:  aws.String(config.Region),
+			// Retries reuse the token, so they can't start a second copy.
+			ClientToken: aws.String(fmt.Sprintf("packer-copy-%s-%s", taskId, region)),
+		}
+		if p.config.Encrypt {
+			copyInput.Encrypted = aws.Bool(true)
+			if kmsKey := p.config.RegionKMSKeyIDs[region]; kmsKey != "" {
+				copyInput.KmsKeyId = aws.String(kmsKey)
+			}
+		}
+
+		resp, err := p.copyImage(ctx, p.regionClient(config, region), copyInput)
+		if err != nil {
+			return nil, fmt.Errorf("Error Copying AMI (%s) to region %s: %s", amiId, region, err)
+		}
+		copies[region] = aws.ToString(resp.ImageId)
+	}
+
+	for _, region := range p.config.AMIRegions {
+		regionClient := p.regionClient(config, region)
+		copyId := copies[region]
+
+		ui.Say(fmt.Sprintf("Waiting for AMI (%s) in region %s to become ready...", copyId, region))
+		if err := p.config.PollingConfig.WaitUntilAMIAvailable(ctx, regionClient, copyId); err != nil {
+			return nil, fmt.Errorf("Error waiting for AMI (%s) in region %s: %s", copyId, region, err)
+		}
+
+		if len(tags) > 0 || len(snapshotTags) > 0 {
+			if err := tagImage(ctx, regionClient, ui, copyId, tags, snapshotTags); err != nil {
+				return nil, err
+			}
+		}
+		if p.config.TagImportSource {
+			if err := p.tagImportSource(ctx, regionClient, ui, copyId, taskId, keys); err != nil {
+				return nil, err
+			}
+		}
+		if len(p.config.SnapshotUsers) > 0 {
+			if err := p.shareSnapshots(ctx, regionClient, ui, copyId); err != nil {
+				return nil, err
+			}
+		}
+		if err := modifyImage(ctx, regionClient, ui, copyId, options); err != nil {
+			return nil, err
+		}
+	}
+	return copies, nil
+}
+
+// destroyCopies deregisters the AMIs copies, by region, and deletes their
+// snapshots.
+func (p *PostProcessor) destroyCopies(ui packersdk.Ui, config *aws.Config, copies map[string]string) {
+	for region, copyId := range copies {
+		ui.Say(fmt.Sprintf("Deregistering the copy (%s) in region %s", copyId, region))
+		if err := awscommon.DestroyAMIs([]string{copyId}, p.regionClient(config, region)); err != nil {
+			ui.Error(fmt.Sprintf("Warning: failed to deregister the copy (%s) in region %s: %s", copyId, region, err))
+		}
+	}
+}
+
+// importSources uploads the images of the artifact to S3, unless
+// skip_upload is set, and returns the keys of the images to import.
+func (p *PostProcessor) importSources(ctx context.Context, ui packersdk.Ui, s3Client *s3.Client, artifact packersdk.Artifact) ([]string, error) {
+	if p.config.SkipUpload {
+		ui.Say(fmt.Sprintf("Skipping upload, importing s3://%s/%s", p.config.S3Bucket, p.config.S3Key))
+		return []string{p.config.S3Key}, nil
+	}
+	return p.uploadDisks(ctx, ui, s3Client, artifact)
+}
+
+// deleteSources deletes the uploaded images keys from S3, unless skip_clean
+// is set.
+func (p *PostProcessor) deleteSources(ctx context.Context, ui packersdk.Ui, s3Client *s3.Client, keys []string) error {
+	if p.config.SkipClean {
+		return nil
+	}
+	for _, key := range keys {
+		ui.Say(fmt.Sprintf("Deleting import source s3://%s/%s", p.config.S3Bucket, key))
+
+		_, err := s3Client.DeleteObject(ctx, &s3.DeleteObjectInput{
+			Bucket: &p.config.S3Bucket,
+			Key:    aws.String(key),
+		})
+		if err != nil {
+			return fmt.Errorf("Failed to delete s3://%s/%s: %s", p.config.S3Bucket, key, err)
+		}
+	}
+	return nil
+}
+
+// dryRunImport checks that the images keys could be imported, without
+// importing them.
+func (p *PostProcessor) dryRunImport(ctx context.Context, ec2Client awscommon.Ec2Client, ui packersdk.Ui, keys []string) error {
+	params := p.importImageInput(keys)
+	params.DryRun = aws.Bool(true)
+
+	ui.Say(fmt.Sprintf("Dry run of the import of s3://%s/%s", p.config.S3Bucket, p.config.S3Key))
+	_, err := ec2Client.ImportImage(ctx, params)
+	// EC2 answers a dry run that would have succeeded with an error too.
+	if !awserrors.Matches(err, "DryRunOperation", "") {
+		return fmt.Errorf("Dry run of the import from s3://%s/%s failed: %s", p.config.S3Bucket, p.config.S3Key, err)
+	}
+	ui.Say("Dry run succeeded, the import would have been started")
+	return nil
+}
+
+// importSlotDelay is the delay between two counts of the active import tasks
+// while waiting for one to complete.
+var importSlotDelay = 30 * time.Second
+
+// importSlotTimeout bounds the wait for an import slot when the polling
+// config has no timeout. It is as long as an import task is waited for by
+// default.
+var importSlotTimeout = time.Hour
+
+// waitForImportSlot waits until there are fewer active import tasks in the
+// region than max_concurrent_import_tasks, for at most the timeout of the
+// polling config, or importSlotTimeout. Builds running at the same time
+// may still both start an import once a slot is free, in which case the
+// retries of startImport wait for the quota.
+func (p *PostProcessor) waitForImportSlot(ctx context.Context, ec2Client awscommon.Ec2Client, ui packersdk.Ui) error {
+	if p.config.MaxImportTasks == 0 {
+		return nil
+	}
+
+	timeout := importSlotTimeout
+	if p.config.PollingConfig != nil && p.config.PollingConfig.Timeout > 0 {
+		timeout = p.config.PollingConfig.Timeout
+	}
+
+	err := awscommon.WithPollingTimeout(ctx, timeout, func(ctx context.Context) error {
+		for waiting := false; ; waiting = true {
+			active, err := activeImportTasks(ctx, ec2Client)
+			if err != nil {
+				return fmt.Errorf("Failed to count the active import tasks: %s", err)
+			}
+			if active < p.config.MaxImportTasks {
+				if waiting {
+					ui.Say(fmt.Sprintf("%d active import tasks, starting the import", active))
+				}
+				return nil
+			}
+
+			if !waiting {
+				ui.Say(fmt.Sprintf("Waiting for one of the %d active import tasks to complete, at most %d may run at once",
+					active, p.config.MaxImportTasks))
+			}
+			log.Printf("%d active import tasks, waiting %s for a slot", active, importSlotDelay)
+			select {
+			case <-ctx.Done():
+				return fmt.Errorf("Interrupted while waiting for an import slot: %s", ctx.Err())
+			case <-time.After(importSlotDelay):
+			}
+		}
+	})
+	if errors.Is(err, awscommon.ErrPollingTimeout) {
+		return fmt.Errorf("No import slot freed up: %w", err)
+	}
+	return err
+}
+
+// activeImportTasks counts the import tasks of the region that are still
+// running.
+func activeImportTasks(ctx context.Context, ec2Client awscommon.Ec2Client) (int, error) {
+	paginator := ec2.NewDescribeImportImageTasksPaginator(ec2Client, &ec2.DescribeImportImageTasksInput{
+		Filters: []ec2types.Filter{{Name: aws.String("task-state"), Values: []string{"active"}}},
+	})
+	count := 0
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return 0, err
+		}
+		count += len(page.ImportImageTasks)
+	}
+	return count, nil
+}
+
+// startImport starts the task importing the images keys and returns its ID.
+func (p *PostProcessor) startImport(ctx context.Context, ec2Client awscommon.Ec2Client, ui packersdk.Ui, keys []string) (string, error) {
+	var err error
+
+	// Call EC2 image import process
+	log.Printf("Calling EC2 to import from s3://%s/%s", p.config.S3Bucket, p.config.S3Key)
+
+	if p.config.LicenseType != "" {
+		ui.Say(fmt.Sprintf("Setting license type to '%s'", p.config.LicenseType))
+	}
+	params := p.importImageInput(keys)
+
+	var importStart *ec2.ImportImageOutput
+	err = retry.Config{
+		Tries: 11,
+		// Permanent errors, such as an invalid parameter or a missing role,
+		// fail right away. The quota of active import tasks frees up as
+		// other tasks complete.
+		ShouldRetry: func(err error) bool {
+			if awscommon.IsRetryableAWSErr(err) || awserrors.Matches(err, "ResourceCountLimitExceeded", "") {
+				log.Printf("[WARN] Retrying ImportImage after: %s", err)
+				return true
+			}
+			return false
+		},
+		RetryDelay: (&retry.Backoff{InitialBackoff: 200 * time.Millisecond, MaxBackoff: 30 * time.Second, Multiplier: 2}).Linear,
+	}.Run(ctx, func(ctx context.Context) error {
+		importStart, err = ec2Client.ImportImage(ctx, params)
+		return err
+	})
+
+	if err != nil {
+		return "", fmt.Errorf("Failed to start import from s3://%s/%s: %s", p.config.S3Bucket, p.config.S3Key, err)
+	}
+
+	ui.Say(fmt.Sprintf("Started import of s3://%s/%s, task id %s", p.config.S3Bucket, p.config.S3Key,
+		*importStart.ImportTaskId))
+	return *importStart.ImportTaskId, nil
+}
+
+// resumeImport picks up the import task taskId started by an earlier build.
+// The S3 bucket and key of the config are set to the ones of the task's boot
+// disk, so that tag_import_source refers to what was actually imported.
+func (p *PostProcessor) resumeImport(ctx context.Context, client awscommon.Ec2Client, ui packersdk.Ui, taskId string) error {
+	resp, err := client.DescribeImportImageTasks(ctx, &ec2.DescribeImportImageTasksInput{
+		ImportTaskIds: []string{taskId},
+	})
+	if err != nil {
+		return fmt.Errorf("Failed to find import task %s: %s", taskId, err)
+	}
+	if len(resp.ImportImageTasks) == 0 {
+		return fmt.Errorf("Import task %s not found", taskId)
+	}
+
+	task := resp.ImportImageTasks[0]
+	switch status := aws.ToString(task.Status); status {
+	case "deleting", "deleted":
+		return fmt.Errorf("Import task %s can't be resumed, it is %s: %s", taskId, status, aws.ToString(task.StatusMessage))
+	}
+
+	if len(task.SnapshotDetails) > 0 && task.SnapshotDetails[0].UserBucket != nil {
+		p.config.S3Bucket = aws.ToString(task.SnapshotDetails[0].UserBucket.S3Bucket)
+		p.config.S3Key = aws.ToString(task.SnapshotDetails[0].UserBucket.S3Key)
+	}
+
+	ui.Say(fmt.Sprintf("Resuming import task %s (%s)", taskId, aws.ToString(task.Status)))
+	return nil
+}
+
+// uploadDisks uploads the images of the artifact to S3 and returns their
+// keys.
+func (p *PostProcessor) uploadDisks(ctx context.Context, ui packersdk.Ui, s3Client *s3.Client, artifact packersdk.Artifact) ([]string, error) {
+	var err error
+
+	log.Println("Looking for images in artifact")
+	// Locate the files output from the builder
+	sources := imageSources(artifact.Files(), p.config.Format)
+
+	// Hope we found something useful
+	if len(sources) == 0 {
+		return nil, fmt.Errorf("No %s image file found in artifact from builder", p.config.Format)
+	}
+
+	if p.config.S3Encryption == "AES256" && p.config.S3EncryptionKey != "" {
+		ui.Say(fmt.Sprintf("Ignoring s3_encryption_key because s3_encryption is set to '%s'", p.config.S3Encryption))
+	}
+
+	// The checksum files are named after the images.
+	if p.config.ComputeChecksum != "" {
+		names := map[string]string{}
+		for _, source := range sources {
+			name := filepath.Base(source)
+			if other, ok := names[name]; ok {
+				return nil, fmt.Errorf("The checksums of %s and %s would both be written to %s in %s, "+
+					"the images must have different file names", other, source, name, p.config.ChecksumDirectory)
+			}
+			names[name] = source
+		}
+	}
+
+	p.checksumFiles = nil
+	keys := make([]string, len(sources))
+	for i, source := range sources {
+		if p.config.Format == "vhd" || p.config.Format == "vhdx" {
+			warnDiskSubtype(ui, source, p.config.Format)
+		}
+		if i == 0 && p.config.DetectArch {
+			warnDiskArchitecture(ui, source, p.config.Format, p.config.Architecture)
+		}
+		keys[i], err = p.uploadDisk(ctx, ui, s3Client, source, i)
+		if err != nil {
+			// The disks already uploaded won't be imported without this one.
+			if cleanErr := p.deleteSources(ctx, ui, s3Client, keys[:i]); cleanErr != nil {
+				ui.Error(fmt.Sprintf("Warning: %s", cleanErr))
+			}
+			return nil, err
+		}
+	}
+	// The first disk holds the boot volume, the import is referred to by
+	// its key.
+	p.config.S3Key = keys[0]
+	return keys, nil
+}
+
+// configureUploader sets the part size and concurrency of the uploads, as
+// given by s3_upload_part_size_mb and s3_upload_concurrency.
+func (p *PostProcessor) configureUploader(u *manager.Uploader) {
+	if p.config.S3PartSizeMB > 0 {
+		u.PartSize = p.config.S3PartSizeMB * 1024 * 1024
+	}
+	if p.config.S3Concurrency > 0 {
+		u.Concurrency = p.config.S3Concurrency
+	}
+}
+
+// imageSources returns the files of the artifact to import, in the order of
+// the disks of the AMI. An OVA already bundles all the disks of the appliance,
+// so only the first one is imported, while every raw, vhd, vhdx and vmdk file
+// is imported as a disk of its own.
+func imageSources(files []string, format string) []string {
+	var sources []string
+	for _, path := range files {
+		if !strings.HasSuffix(path, "."+format) {
+			continue
+		}
+		sources = append(sources, path)
+		if format == "ova" {
+			break
+		}
+	}
+	return sources
+}
+
+// diskName returns the name of a file or key for the disk at index, the first
+// disk keeps name and the others get their number before its extension.
+func diskName(name string, index int) string {
+	if index == 0 {
+		return name
+	}
+	ext := path.Ext(name)
+	return fmt.Sprintf("%s-%d%s", strings.TrimSuffix(name, ext), index+1, ext)
+}
+
+// uploadDisk uploads the image file source of the disk at index to S3 and
+// returns the key it was uploaded to.
+func (p *PostProcessor) uploadDisk(ctx context.Context, ui packersdk.Ui, s3Client *s3.Client, source string, index int) (string, error) {
+	key := diskName(p.config.S3Key, index)
+
+	// open the source file
+	log.Printf("Opening file %s to upload", source)
+	file, err := os.Open(source)
+	if err != nil {
+		return "", fmt.Errorf("Failed to open %s: %s", source, err)
+	}
+	defer file.Close()
+
+	ui.Say(fmt.Sprintf("Uploading %s to s3://%s/%s", source, p.config.S3Bucket, key))
+
+	// Prepare S3 request
+	updata := &s3.PutObjectInput{
+		Body:   file,
+		Bucket: &p.config.S3Bucket,
+		Key:    aws.String(key),
+	}
+
+	if len(p.config.S3Tags) > 0 {
+		updata.Tagging = aws.String(awscommon.S3Tagging(p.config.S3Tags))
+	}
+
+	// Add encryption if specified in the config
+	if p.config.S3Encryption != "" {
+		updata.ServerSideEncryption = s3types.ServerSideEncryption(p.config.S3Encryption)
+		if p.config.S3Encryption == string(s3types.ServerSideEncryptionAwsKms) && p.config.S3EncryptionKey != "" {
+			updata.SSEKMSKeyId = aws.String(p.config.S3EncryptionKey)
+		}
+	}
+
+	updata.Metadata = make(map[string]string)
+	if p.config.ComputeChecksum != "" {
+		checksumFile, sum, err := writeChecksum(source, p.config.ComputeChecksum, p.config.ChecksumDirectory)
+		if err != nil {
+			return "", err
+		}
+		ui.Say(fmt.Sprintf("Wrote %s checksum of %s to %s", p.config.ComputeChecksum, source, checksumFile))
+		p.checksumFiles = append(p.checksumFiles, checksumFile)
+
+		if p.config.S3ChecksumMetadata {
+			updata.Metadata[p.config.ComputeChecksum] = sum
+		}
+	}
+
+	if p.config.SkipUploadIfExists {
+		sum, ok := updata.Metadata[sha256MetadataKey]
+		if !ok {
+			sum, err = fileSHA256(file)
+			if err != nil {
+				return "", err
+			}
+		}
+		updata.Metadata[sha256MetadataKey] = sum
+
+		exists, err := uploadExists(ctx, s3Client, updata)
+		if err != nil {
+			return "", err
+		}
+		if exists {
+			ui.Say(fmt.Sprintf("s3://%s/%s already holds %s, skipping upload", p.config.S3Bucket, key, source))
+			return key, nil
+		}
+	}
+
+	// Copy the image file into the S3 bucket specified
+	if p.config.S3UploadState != "" {
+		upload := &resumableUpload{
+			client:    s3Client,
+			ui:        ui,
+			stateFile: diskName(p.config.S3UploadState, index),
+			partSize:  p.config.S3PartSizeMB * 1024 * 1024,
+		}
+		// A resumed upload keeps the key it was started with, which may
+		// differ from the rendered s3_key_name.
+		key, err = upload.Upload(ctx, file, updata)
+		if err != nil {
+			return "", err
+		}
+	} else {
+		uploader := manager.NewUploader(s3Client, p.configureUploader)
+		if _, err = uploader.Upload(ctx, updata); err != nil {
+			return "", fmt.Errorf("Failed to upload %s: %s", source, err)
+		}
+	}
+
+	ui.Say(fmt.Sprintf("Completed upload of %s to s3://%s/%s", source, p.config.S3Bucket, key))
+	return key, nil
+}
+
+// importArtifact returns the artifact of a completed import. Along with the
+// AMI, its state records the import task so the build can be correlated with
+// the ImportImage event in CloudTrail.
+func importArtifact(config *aws.Config, amiId, taskId string) *awscommon.Artifact {
+	return &awscommon.Artifact{
+		Amis: map[string]string{
+			config.Region: amiId,
+		},
+		BuilderIdValue: BuilderId,
+		Config:         config,
+		StateData: map[string]interface{}{
+			"import_task_id":     taskId,
+			"import_task_region": config.Region,
+		},
+	}
+}
+
+// recordSources records the S3 objects the AMI was imported from in the
+// state of importTask, so that they can be audited along with the import
+// task. The first key is the boot disk. The version IDs are only recorded if
+// the bucket is versioned.
+func recordSources(importTask *awscommon.Artifact, bucket string, keys, versions []string) {
+	importTask.StateData["s3_bucket"] = bucket
+	importTask.StateData["s3_key"] = keys[0]
+	importTask.StateData["s3_keys"] = keys
+	if versions[0] != "" {
+		importTask.StateData["s3_version_id"] = versions[0]
+		importTask.StateData["s3_version_ids"] = versions
+	}
+}
+
+// importTaskArn builds the ARN of an import task in region, taking the
+// partition and account from the ARN of the caller that started it.
+func importTaskArn(callerArn, region, taskId string) (string, error) {
+	caller, err := arn.Parse(callerArn)
+	if err != nil {
+		return "", err
+	}
+
+	return arn.ARN{
+		Partition: caller.Partition,
+		Service:   "ec2",
+		Region:    region,
+		AccountID: caller.AccountID,
+		Resource:  "import-image-task/" + taskId,
+	}.String(), nil
+}
+
+// tagImportSource tags the AMI with the S3 objects of keys and the task it
+// was imported from, so it can be traced back to the uploaded disks. The boot
+// disk is tagged as ImportSource, the other disks as ImportSource1,
+// ImportSource2 and so on, a single tag value can't hold all of them. Without
+// keys, when the task is resumed, s3_key is the source.
+func (p *PostProcessor) tagImportSource(ctx context.Context, client awscommon.Ec2Client, ui packersdk.Ui, amiId, taskId string, keys []string) error {
+	if len(keys) == 0 {
+		keys = []string{p.config.S3Key}
+	}
+
+	tags := []ec2types.Tag{
+		{Key: aws.String(importTaskIdTagKey), Value: aws.String(taskId)},
+	}
+	for i, key := range keys {
+		tagKey := importSourceTagKey
+		if i > 0 {
+			tagKey = fmt.Sprintf("%s%d", importSourceTagKey, i)
+		}
+		source := fmt.Sprintf("s3://%s/%s", p.config.S3Bucket, key)
+		ui.Say(fmt.Sprintf("Tagging AMI %s with its import source %s", amiId, source))
+		tags = append(tags, ec2types.Tag{Key: aws.String(tagKey), Value: aws.String(source)})
+	}
+
+	_, err := client.CreateTags(ctx, &ec2.CreateTagsInput{
+		Resources: []string{amiId},
+		Tags:      tags,
+	})
+	if err != nil {
+		return fmt.Errorf("Failed to tag AMI %s with its import source: %s", amiId, err)
+	}
+	return nil
+}
+
+// shareSnapshots grants the accounts of share_import_snapshot_with the
+// permission to create volumes from the snapshots of the AMI.
+func (p *PostProcessor) shareSnapshots(ctx context.Context, client awscommon.Ec2Client, ui packersdk.Ui, amiId string) error {
+	imageResp, err := client.DescribeImages(ctx, &ec2.DescribeImagesInput{
+		ImageIds: []string{amiId},
+	})
+	if err != nil {
+		return fmt.Errorf("Failed to retrieve details for AMI %s: %s", amiId, err)
+	}
+	if len(imageResp.Images) == 0 {
+		return fmt.Errorf("AMI %s has no images", amiId)
+	}
+
+	adds := make([]ec2types.CreateVolumePermission, len(p.config.SnapshotUsers))
+	for i, u := range p.config.SnapshotUsers {
+		adds[i] = ec2types.CreateVolumePermission{UserId: aws.String(u)}
+	}
+
+	for _, device := range imageResp.Images[0].BlockDeviceMappings {
+		if device.Ebs == nil || device.Ebs.SnapshotId == nil {
+			continue
+		}
+		ui.Say(fmt.Sprintf("Sharing snapshot %s with %s", *device.Ebs.SnapshotId, strings.Join(p.config.SnapshotUsers, ", ")))
+		_, err := client.ModifySnapshotAttribute(ctx, &ec2.ModifySnapshotAttributeInput{
+			SnapshotId: device.Ebs.SnapshotId,
+			Attribute:  ec2types.SnapshotAttributeNameCreateVolumePermission,
+			CreateVolumePermission: &ec2types.CreateVolumePermissionModifications{
+				Add: adds,
+			},
+		})
+		if err != nil {
+			return fmt.Errorf("Error sharing snapshot %s: %s", *device.Ebs.SnapshotId, err)
+		}
+	}
+	return nil
+}
+
+// snapshotTagger tags the snapshots of an in-progress import task as soon as
+// their IDs show up in the task's snapshot details, so they can be accounted
+// for before the AMI exists.
+type snapshotTagger struct {
+	client awscommon.Ec2Client
+	ui     packersdk.Ui
+	tags   []ec2types.Tag
+	tagged map[string]bool
+}
+
+func (t *snapshotTagger) observe(ctx context.Context, task ec2types.ImportImageTask) {
+	if t.tagged == nil {
+		t.tagged = make(map[string]bool)
+	}
+
+	for _, detail := range task.SnapshotDetails {
+		// The snapshot ID is only filled in once the import has
+		// progressed far enough to create it.
+		snapshotId := aws.ToString(detail.SnapshotId)
+		if snapshotId == "" || t.tagged[snapshotId] {
+			continue
+		}
+
+		t.ui.Say(fmt.Sprintf("Tagging snapshot %s of import task %s", snapshotId, aws.ToString(task.ImportTaskId)))
+		_, err := t.client.CreateTags(ctx, &ec2.CreateTagsInput{
+			Resources: []string{snapshotId},
+			Tags:      t.tags,
+		})
+		if err != nil {
+			// The snapshot is tagged again with the AMI once the import
+			// completes, so this is not fatal.
+			log.Printf("[WARN] Failed to tag snapshot %s early: %s", snapshotId, err)
+			continue
+		}
+		t.tagged[snapshotId] = true
+	}
+}
+
+// progressReporter tells the user how far an import task got whenever its
+// progress or status message changes, so that a stuck import can be told
+// apart from a slow one.
+type progressReporter struct {
+	ui       packersdk.Ui
+	reported string
+}
+
+func (r *progressReporter) observe(ctx context.Context, task ec2types.ImportImageTask) {
+	progress := aws.ToString(task.Progress)
+	if progress == "" {
+		// The progress is not reported once the task completes.
+		return
+	}
+
+	message := fmt.Sprintf("Import task %s: %s%%", aws.ToString(task.ImportTaskId), progress)
+	if statusMessage := aws.ToString(task.StatusMessage); statusMessage != "" {
+		message = fmt.Sprintf("%s (%s)", message, statusMessage)
+	}
+	if message != r.reported {
+		r.ui.Message(message)
+		r.reported = message
+	}
+}
+
+// copyImage copies an image, retrying when the call is throttled or fails
+// transiently.
+func (p *PostProcessor) copyImage(ctx context.Context, client awscommon.Ec2Client, input *ec2.CopyImageInput) (*ec2.CopyImageOutput, error) {
+	var resp *ec2.CopyImageOutput
+	err := retry.Config{
+		Tries: p.config.CopyMaxAttempts,
+		ShouldRetry: func(err error) bool {
+			for _, code := range []string{"RequestLimitExceeded", "Throttling", "InternalError", "Unavailable"} {
+				if awserrors.Matches(err, code, "") {
+					log.Printf("[WARN] Retrying CopyImage after %s", code)
+					return true
+				}
+			}
+			return false
+		},
+		RetryDelay: (&retry.Backoff{InitialBackoff: 200 * time.Millisecond, MaxBackoff: 30 * time.Second, Multiplier: 2}).Linear,
+	}.Run(ctx, func(ctx context.Context) error {
+		var err error
+		resp, err = client.CopyImage(ctx, input)
+		return err
+	})
+	if awserrors.Matches(err, "IdempotentParameterMismatch", "") {
+		return nil, fmt.Errorf("%s: a copy was already started with token %s and other parameters, "+
+			"which can happen when an earlier attempt of this import used another ami_name or encryption", err,
+			aws.ToString(input.ClientToken))
+	}
+	return resp, err
+}
+
+// tagUnshareAfter tags the AMI amiId with when its sharing should be
+// revoked, ami_share_duration after now, and returns the tag. The sharing
+// isn't revoked by Packer, external automation can use the tag to do so.
+func (p *PostProcessor) tagUnshareAfter(ctx context.Context, client awscommon.Ec2Client, ui packersdk.Ui, amiId string, now time.Time) (ec2types.Tag, error) {
+	unshareAfter := now.UTC().Add(p.config.ShareDuration).Format(time.RFC3339)
+	tag := ec2types.Tag{Key: aws.String(awscommon.UnshareAfterTagKey), Value: aws.String(unshareAfter)}
+
+	ui.Say(fmt.Sprintf("Tagging AMI %s with %s=%s", amiId, awscommon.UnshareAfterTagKey, unshareAfter))
+	if _, err := client.CreateTags(ctx, &ec2.CreateTagsInput{
+		Resources: []string{amiId},
+		Tags:      []ec2types.Tag{tag},
+	}); err != nil {
+		return tag, fmt.Errorf("Error tagging AMI (%s): %s", amiId, err)
+	}
+	ui.Error(fmt.Sprintf("Warning: the sharing of the AMI isn't revoked automatically, "+
+		"revoke it after %s", unshareAfter))
+	return tag, nil
+}
+
+// sharesImage reports whether the AMI or its snapshots are shared with other
+// accounts.
+func (p *PostProcessor) sharesImage() bool {
+	return len(p.config.Users) > 0 || len(p.config.Groups) > 0 || len(p.config.OrgArns) > 0 ||
+		len(p.config.OuArns) > 0 || len(p.config.SnapshotUsers) > 0
+}
+
+// createMetadataLaunchTemplate creates a launch template of the AMI amiId
+// with the metadata options of the config, which AMIs can't hold, and returns
+// its ID.
+func (p *PostProcessor) createMetadataLaunchTemplate(ctx context.Context, client awscommon.Ec2Client, ui packersdk.Ui, amiId string) (string, error) {
+	metadata := &ec2types.LaunchTemplateInstanceMetadataOptionsRequest{
+		HttpEndpoint:            ec2types.LaunchTemplateInstanceMetadataEndpointStateEnabled,
+		HttpPutResponseHopLimit: aws.Int32(int32(p.config.IMDSHopLimit)),
+	}
+	if p.config.AMIIMDSSupport == string(ec2types.ImdsSupportValuesV20) {
+		metadata.HttpTokens = ec2types.LaunchTemplateHttpTokensStateRequired
+	}
+
+	name := "packer-import-" + amiId
+	ui.Say(fmt.Sprintf("Creating launch template %s with IMDS hop limit %d", name, p.config.IMDSHopLimit))
+	resp, err := client.CreateLaunchTemplate(ctx, &ec2.CreateLaunchTemplateInput{
+		LaunchTemplateName: aws.String(name),
+		VersionDescription: aws.String(fmt.Sprintf("Metadata options of %s", amiId)),
+		LaunchTemplateData: &ec2types.RequestLaunchTemplateData{
+			ImageId:         aws.String(amiId),
+			MetadataOptions: metadata,
+		},
+	})
+	if err != nil {
+		return "", fmt.Errorf("Error creating launch template %s: %s", name, err)
+	}
+	return aws.ToString(resp.LaunchTemplate.LaunchTemplateId), nil
+}
+
+// deleteLaunchTemplate deletes the launch template id created by
+// createMetadataLaunchTemplate, when the post-processor fails after it.
+func (p *PostProcessor) deleteLaunchTemplate(ctx context.Context, client awscommon.Ec2Client, ui packersdk.Ui, id string) {
+	ui.Say(fmt.Sprintf("Deleting launch template %s", id))
+	_, err := client.DeleteLaunchTemplate(ctx, &ec2.DeleteLaunchTemplateInput{
+		LaunchTemplateId: aws.String(id),
+	})
+	if err != nil {
+		ui.Error(fmt.Sprintf("Warning: failed to delete launch template %s: %s", id, err))
+	}
+}
+
+// registerWithTpmSupport registers the AMI amiId again from its snapshots
+// with NitroTPM support, which VM Import and ModifyImageAttribute can't
+// enable, and returns the ID of the new AMI. The AMI is kept as is if it
+// already has it.
+func (p *PostProcessor) registerWithTpmSupport(ctx context.Context, client awscommon.Ec2Client, amiId string) (string, error) {
+	resp, err := client.DescribeImages(ctx, &ec2.DescribeImagesInput{ImageIds: []string{amiId}})
+	if err != nil {
+		return "", err
+	}
+	if len(resp.Images) == 0 {
+		return "", fmt.Errorf("AMI %s not found", amiId)
+	}
+	image := resp.Images[0]
+	if string(image.TpmSupport) == p.config.TpmSupport {
+		return amiId, nil
+	}
+	if err := awscommon.CheckRegisterAgain(image); err != nil {
+		return "", err
+	}
+
+	mappings := make([]ec2types.BlockDeviceMapping, len(image.BlockDeviceMappings))
+	for i, mapping := range image.BlockDeviceMappings {
+		mappings[i] = mapping
+		if mapping.Ebs != nil && mapping.Ebs.SnapshotId != nil {
+			// The encryption of the volumes is the one of their snapshots.
+			ebs := *mapping.Ebs
+			ebs.Encrypted = nil
+			ebs.KmsKeyId = nil
+			mappings[i].Ebs = &ebs
+		}
+	}
+
+	input := &ec2.RegisterImageInput{
+		Name:                image.Name,
+		Description:         image.Description,
+		Architecture:        image.Architecture,
+		RootDeviceName:      image.RootDeviceName,
+		VirtualizationType:  aws.String(string(image.VirtualizationType)),
+		EnaSupport:          image.EnaSupport,
+		SriovNetSupport:     image.SriovNetSupport,
+		BootMode:            image.BootMode,
+		TpmSupport:          ec2types.TpmSupportValues(p.config.TpmSupport),
+		ImdsSupport:         image.ImdsSupport,
+		BlockDeviceMappings: mappings,
+	}
+	if len(image.Tags) > 0 {
+		input.TagSpecifications = []ec2types.TagSpecification{{
+			ResourceType: ec2types.ResourceTypeImage,
+			Tags:         image.Tags,
+		}}
+	}
+	return awscommon.ReregisterImage(ctx, client, p.config.PollingConfig, amiId, input)
+}
+
+// presignDiskURLs returns presigned GET URLs of the objects keys of bucket,
+// valid for expiry, by key.
+func presignDiskURLs(ctx context.Context, client *s3.PresignClient, bucket string, keys []string, expiry time.Duration) (map[string]string, error) {
+	urls := make(map[string]string, len(keys))
+	for _, key := range keys {
+		req, err := client.PresignGetObject(ctx, &s3.GetObjectInput{
+			Bucket: aws.String(bucket),
+			Key:    aws.String(key),
+		}, s3.WithPresignExpires(expiry))
+		if err != nil {
+			return nil, fmt.Errorf("Failed to presign the URL of s3://%s/%s: %s", bucket, key, err)
+		}
+		urls[key] = req.URL
+	}
+	return urls, nil
+}
+
+// importImageInput builds the parameters of the image import task, with a
+// disk container for each of the uploaded keys. A disk with a presigned URL
+// is read from it rather than from the bucket. When the boot mode is
+// `auto`, it is left out so AWS detects it from the disk.
+func (p *PostProcessor) importImageInput(keys []string) *ec2.ImportImageInput {
+	disks := make([]ec2types.ImageDiskContainer, len(keys))
+	for i, key := range keys {
+		disks[i] = ec2types.ImageDiskContainer{
+			Format: &p.config.Format,
+		}
+		if diskURL, ok := p.diskURLs[key]; ok {
+			disks[i].Url = aws.String(diskURL)
+		} else {
+			disks[i].UserBucket = &ec2types.UserBucket{
+				S3Bucket: &p.config.S3Bucket,
+				S3Key:    aws.String(key),
+			}
+		}
+	}
+
+	params := &ec2.ImportImageInput{
+		Encrypted:      &p.config.Encrypt,
+		DiskContainers: disks,
+		Architecture:   &p.config.Architecture,
+		Platform:       &p.config.Platform,
+	}
+
+	if p.config.BootMode != bootModeAuto {
+		params.BootMode = ec2types.BootModeValues(p.config.BootMode)
+	}
+
+	if p.config.Encrypt && p.config.KMSKey != "" {
+		params.KmsKeyId = &p.config.KMSKey
+	}
+
+	if p.config.RoleName != "" {
+		params.RoleName = &p.config.RoleName
+	}
+
+	if p.config.LicenseType != "" {
+		params.LicenseType = &p.config.LicenseType
+	}
+
+	return params
+}
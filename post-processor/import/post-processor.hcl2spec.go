@@ -26,30 +26,60 @@ type FlatConfig struct {
 	DecodeAuthZMessages   *bool                             `mapstructure:"decode_authorization_messages" required:"false" cty:"decode_authorization_messages" hcl:"decode_authorization_messages"`
 	InsecureSkipTLSVerify *bool                             `mapstructure:"insecure_skip_tls_verify" required:"false" cty:"insecure_skip_tls_verify" hcl:"insecure_skip_tls_verify"`
 	MaxRetries            *int                              `mapstructure:"max_retries" required:"false" cty:"max_retries" hcl:"max_retries"`
+	RetryMode             *string                           `mapstructure:"aws_retry_mode" required:"false" cty:"aws_retry_mode" hcl:"aws_retry_mode"`
 	MFACode               *string                           `mapstructure:"mfa_code" required:"false" cty:"mfa_code" hcl:"mfa_code"`
 	ProfileName           *string                           `mapstructure:"profile" required:"false" cty:"profile" hcl:"profile"`
 	RawRegion             *string                           `mapstructure:"region" required:"true" cty:"region" hcl:"region"`
 	SecretKey             *string                           `mapstructure:"secret_key" required:"true" cty:"secret_key" hcl:"secret_key"`
-	SkipMetadataApiCheck  *bool                             `mapstructure:"skip_metadata_api_check" cty:"skip_metadata_api_check" hcl:"skip_metadata_api_check"`
+	SkipMetadataApiCheck  *bool                             `mapstructure:"skip_metadata_api_check" required:"false" cty:"skip_metadata_api_check" hcl:"skip_metadata_api_check"`
 	SkipCredsValidation   *bool                             `mapstructure:"skip_credential_validation" cty:"skip_credential_validation" hcl:"skip_credential_validation"`
+	UserAgentSuffix       *string                           `mapstructure:"aws_user_agent_suffix" required:"false" cty:"aws_user_agent_suffix" hcl:"aws_user_agent_suffix"`
+	PerRequestTimeout     *string                           `mapstructure:"per_request_timeout" required:"false" cty:"per_request_timeout" hcl:"per_request_timeout"`
 	Token                 *string                           `mapstructure:"token" required:"false" cty:"token" hcl:"token"`
 	VaultAWSEngine        *common.FlatVaultAWSEngineOptions `mapstructure:"vault_aws_engine" required:"false" cty:"vault_aws_engine" hcl:"vault_aws_engine"`
 	PollingConfig         *common.FlatAWSPollingConfig      `mapstructure:"aws_polling" required:"false" cty:"aws_polling" hcl:"aws_polling"`
 	S3Bucket              *string                           `mapstructure:"s3_bucket_name" cty:"s3_bucket_name" hcl:"s3_bucket_name"`
 	S3Key                 *string                           `mapstructure:"s3_key_name" cty:"s3_key_name" hcl:"s3_key_name"`
+	S3KeyPrefix           *string                           `mapstructure:"s3_key_prefix" cty:"s3_key_prefix" hcl:"s3_key_prefix"`
 	S3Encryption          *string                           `mapstructure:"s3_encryption" cty:"s3_encryption" hcl:"s3_encryption"`
 	S3EncryptionKey       *string                           `mapstructure:"s3_encryption_key" cty:"s3_encryption_key" hcl:"s3_encryption_key"`
+	S3Tags                map[string]string                 `mapstructure:"s3_tags" cty:"s3_tags" hcl:"s3_tags"`
+	S3UploadState         *string                           `mapstructure:"s3_upload_state_file" cty:"s3_upload_state_file" hcl:"s3_upload_state_file"`
+	S3PartSizeMB          *int64                            `mapstructure:"s3_upload_part_size_mb" cty:"s3_upload_part_size_mb" hcl:"s3_upload_part_size_mb"`
+	S3Concurrency         *int                              `mapstructure:"s3_upload_concurrency" cty:"s3_upload_concurrency" hcl:"s3_upload_concurrency"`
+	S3PresignedURL        *bool                             `mapstructure:"s3_presigned_url" cty:"s3_presigned_url" hcl:"s3_presigned_url"`
+	S3PresignedExpiry     *string                           `mapstructure:"s3_presigned_url_expiry" cty:"s3_presigned_url_expiry" hcl:"s3_presigned_url_expiry"`
 	SkipClean             *bool                             `mapstructure:"skip_clean" cty:"skip_clean" hcl:"skip_clean"`
+	SkipUpload            *bool                             `mapstructure:"skip_upload" cty:"skip_upload" hcl:"skip_upload"`
+	SkipUploadIfExists    *bool                             `mapstructure:"skip_upload_if_exists" cty:"skip_upload_if_exists" hcl:"skip_upload_if_exists"`
+	ComputeChecksum       *string                           `mapstructure:"compute_checksum" cty:"compute_checksum" hcl:"compute_checksum"`
+	ChecksumDirectory     *string                           `mapstructure:"checksum_directory" cty:"checksum_directory" hcl:"checksum_directory"`
+	S3ChecksumMetadata    *bool                             `mapstructure:"s3_checksum_metadata" cty:"s3_checksum_metadata" hcl:"s3_checksum_metadata"`
+	ResumeTaskId          *string                           `mapstructure:"resume_task_id" cty:"resume_task_id" hcl:"resume_task_id"`
+	DryRun                *bool                             `mapstructure:"dry_run" cty:"dry_run" hcl:"dry_run"`
+	DetectArch            *bool                             `mapstructure:"detect_architecture" cty:"detect_architecture" hcl:"detect_architecture"`
+	MaxImportTasks        *int                              `mapstructure:"max_concurrent_import_tasks" cty:"max_concurrent_import_tasks" hcl:"max_concurrent_import_tasks"`
+	VerifyRole            *bool                             `mapstructure:"verify_role" cty:"verify_role" hcl:"verify_role"`
 	Tags                  map[string]string                 `mapstructure:"tags" cty:"tags" hcl:"tags"`
+	SnapshotTags          map[string]string                 `mapstructure:"snapshot_tags" cty:"snapshot_tags" hcl:"snapshot_tags"`
+	TagImportSource       *bool                             `mapstructure:"tag_import_source" cty:"tag_import_source" hcl:"tag_import_source"`
 	Name                  *string                           `mapstructure:"ami_name" cty:"ami_name" hcl:"ami_name"`
+	IntermediaryName      *string                           `mapstructure:"intermediary_ami_name" cty:"intermediary_ami_name" hcl:"intermediary_ami_name"`
+	CopyMaxAttempts       *int                              `mapstructure:"copy_image_max_attempts" cty:"copy_image_max_attempts" hcl:"copy_image_max_attempts"`
 	Description           *string                           `mapstructure:"ami_description" cty:"ami_description" hcl:"ami_description"`
 	Users                 []string                          `mapstructure:"ami_users" cty:"ami_users" hcl:"ami_users"`
+	SnapshotUsers         []string                          `mapstructure:"share_import_snapshot_with" cty:"share_import_snapshot_with" hcl:"share_import_snapshot_with"`
 	Groups                []string                          `mapstructure:"ami_groups" cty:"ami_groups" hcl:"ami_groups"`
 	OrgArns               []string                          `mapstructure:"ami_org_arns" cty:"ami_org_arns" hcl:"ami_org_arns"`
 	OuArns                []string                          `mapstructure:"ami_ou_arns" cty:"ami_ou_arns" hcl:"ami_ou_arns"`
+	ShareDuration         *string                           `mapstructure:"ami_share_duration" cty:"ami_share_duration" hcl:"ami_share_duration"`
 	Encrypt               *bool                             `mapstructure:"ami_encrypt" cty:"ami_encrypt" hcl:"ami_encrypt"`
 	KMSKey                *string                           `mapstructure:"ami_kms_key" cty:"ami_kms_key" hcl:"ami_kms_key"`
+	AMIRegions            []string                          `mapstructure:"ami_regions" cty:"ami_regions" hcl:"ami_regions"`
+	RegionKMSKeyIDs       map[string]string                 `mapstructure:"region_kms_key_ids" cty:"region_kms_key_ids" hcl:"region_kms_key_ids"`
 	AMIIMDSSupport        *string                           `mapstructure:"imds_support" required:"false" cty:"imds_support" hcl:"imds_support"`
+	IMDSHopLimit          *int                              `mapstructure:"imds_http_put_response_hop_limit" required:"false" cty:"imds_http_put_response_hop_limit" hcl:"imds_http_put_response_hop_limit"`
+	TpmSupport            *string                           `mapstructure:"tpm_support" required:"false" cty:"tpm_support" hcl:"tpm_support"`
 	LicenseType           *string                           `mapstructure:"license_type" cty:"license_type" hcl:"license_type"`
 	RoleName              *string                           `mapstructure:"role_name" cty:"role_name" hcl:"role_name"`
 	Format                *string                           `mapstructure:"format" cty:"format" hcl:"format"`
@@ -70,51 +100,81 @@ func (*Config) FlatMapstructure() interface{ HCL2Spec() map[string]hcldec.Spec }
 // The decoded values from this spec will then be applied to a FlatConfig.
 func (*FlatConfig) HCL2Spec() map[string]hcldec.Spec {
 	s := map[string]hcldec.Spec{
-		"packer_build_name":             &hcldec.AttrSpec{Name: "packer_build_name", Type: cty.String, Required: false},
-		"packer_builder_type":           &hcldec.AttrSpec{Name: "packer_builder_type", Type: cty.String, Required: false},
-		"packer_core_version":           &hcldec.AttrSpec{Name: "packer_core_version", Type: cty.String, Required: false},
-		"packer_debug":                  &hcldec.AttrSpec{Name: "packer_debug", Type: cty.Bool, Required: false},
-		"packer_force":                  &hcldec.AttrSpec{Name: "packer_force", Type: cty.Bool, Required: false},
-		"packer_on_error":               &hcldec.AttrSpec{Name: "packer_on_error", Type: cty.String, Required: false},
-		"packer_user_variables":         &hcldec.AttrSpec{Name: "packer_user_variables", Type: cty.Map(cty.String), Required: false},
-		"packer_sensitive_variables":    &hcldec.AttrSpec{Name: "packer_sensitive_variables", Type: cty.List(cty.String), Required: false},
-		"access_key":                    &hcldec.AttrSpec{Name: "access_key", Type: cty.String, Required: false},
-		"assume_role":                   &hcldec.BlockSpec{TypeName: "assume_role", Nested: hcldec.ObjectSpec((*common.FlatAssumeRoleConfig)(nil).HCL2Spec())},
-		"custom_endpoint_ec2":           &hcldec.AttrSpec{Name: "custom_endpoint_ec2", Type: cty.String, Required: false},
-		"shared_credentials_file":       &hcldec.AttrSpec{Name: "shared_credentials_file", Type: cty.String, Required: false},
-		"decode_authorization_messages": &hcldec.AttrSpec{Name: "decode_authorization_messages", Type: cty.Bool, Required: false},
-		"insecure_skip_tls_verify":      &hcldec.AttrSpec{Name: "insecure_skip_tls_verify", Type: cty.Bool, Required: false},
-		"max_retries":                   &hcldec.AttrSpec{Name: "max_retries", Type: cty.Number, Required: false},
-		"mfa_code":                      &hcldec.AttrSpec{Name: "mfa_code", Type: cty.String, Required: false},
-		"profile":                       &hcldec.AttrSpec{Name: "profile", Type: cty.String, Required: false},
-		"region":                        &hcldec.AttrSpec{Name: "region", Type: cty.String, Required: false},
-		"secret_key":                    &hcldec.AttrSpec{Name: "secret_key", Type: cty.String, Required: false},
-		"skip_metadata_api_check":       &hcldec.AttrSpec{Name: "skip_metadata_api_check", Type: cty.Bool, Required: false},
-		"skip_credential_validation":    &hcldec.AttrSpec{Name: "skip_credential_validation", Type: cty.Bool, Required: false},
-		"token":                         &hcldec.AttrSpec{Name: "token", Type: cty.String, Required: false},
-		"vault_aws_engine":              &hcldec.BlockSpec{TypeName: "vault_aws_engine", Nested: hcldec.ObjectSpec((*common.FlatVaultAWSEngineOptions)(nil).HCL2Spec())},
-		"aws_polling":                   &hcldec.BlockSpec{TypeName: "aws_polling", Nested: hcldec.ObjectSpec((*common.FlatAWSPollingConfig)(nil).HCL2Spec())},
-		"s3_bucket_name":                &hcldec.AttrSpec{Name: "s3_bucket_name", Type: cty.String, Required: false},
-		"s3_key_name":                   &hcldec.AttrSpec{Name: "s3_key_name", Type: cty.String, Required: false},
-		"s3_encryption":                 &hcldec.AttrSpec{Name: "s3_encryption", Type: cty.String, Required: false},
-		"s3_encryption_key":             &hcldec.AttrSpec{Name: "s3_encryption_key", Type: cty.String, Required: false},
-		"skip_clean":                    &hcldec.AttrSpec{Name: "skip_clean", Type: cty.Bool, Required: false},
-		"tags":                          &hcldec.AttrSpec{Name: "tags", Type: cty.Map(cty.String), Required: false},
-		"ami_name":                      &hcldec.AttrSpec{Name: "ami_name", Type: cty.String, Required: false},
-		"ami_description":               &hcldec.AttrSpec{Name: "ami_description", Type: cty.String, Required: false},
-		"ami_users":                     &hcldec.AttrSpec{Name: "ami_users", Type: cty.List(cty.String), Required: false},
-		"ami_groups":                    &hcldec.AttrSpec{Name: "ami_groups", Type: cty.List(cty.String), Required: false},
-		"ami_org_arns":                  &hcldec.AttrSpec{Name: "ami_org_arns", Type: cty.List(cty.String), Required: false},
-		"ami_ou_arns":                   &hcldec.AttrSpec{Name: "ami_ou_arns", Type: cty.List(cty.String), Required: false},
-		"ami_encrypt":                   &hcldec.AttrSpec{Name: "ami_encrypt", Type: cty.Bool, Required: false},
-		"ami_kms_key":                   &hcldec.AttrSpec{Name: "ami_kms_key", Type: cty.String, Required: false},
-		"imds_support":                  &hcldec.AttrSpec{Name: "imds_support", Type: cty.String, Required: false},
-		"license_type":                  &hcldec.AttrSpec{Name: "license_type", Type: cty.String, Required: false},
-		"role_name":                     &hcldec.AttrSpec{Name: "role_name", Type: cty.String, Required: false},
-		"format":                        &hcldec.AttrSpec{Name: "format", Type: cty.String, Required: false},
-		"architecture":                  &hcldec.AttrSpec{Name: "architecture", Type: cty.String, Required: false},
-		"boot_mode":                     &hcldec.AttrSpec{Name: "boot_mode", Type: cty.String, Required: false},
-		"platform":                      &hcldec.AttrSpec{Name: "platform", Type: cty.String, Required: false},
+		"packer_build_name":                &hcldec.AttrSpec{Name: "packer_build_name", Type: cty.String, Required: false},
+		"packer_builder_type":              &hcldec.AttrSpec{Name: "packer_builder_type", Type: cty.String, Required: false},
+		"packer_core_version":              &hcldec.AttrSpec{Name: "packer_core_version", Type: cty.String, Required: false},
+		"packer_debug":                     &hcldec.AttrSpec{Name: "packer_debug", Type: cty.Bool, Required: false},
+		"packer_force":                     &hcldec.AttrSpec{Name: "packer_force", Type: cty.Bool, Required: false},
+		"packer_on_error":                  &hcldec.AttrSpec{Name: "packer_on_error", Type: cty.String, Required: false},
+		"packer_user_variables":            &hcldec.AttrSpec{Name: "packer_user_variables", Type: cty.Map(cty.String), Required: false},
+		"packer_sensitive_variables":       &hcldec.AttrSpec{Name: "packer_sensitive_variables", Type: cty.List(cty.String), Required: false},
+		"access_key":                       &hcldec.AttrSpec{Name: "access_key", Type: cty.String, Required: false},
+		"assume_role":                      &hcldec.BlockSpec{TypeName: "assume_role", Nested: hcldec.ObjectSpec((*common.FlatAssumeRoleConfig)(nil).HCL2Spec())},
+		"custom_endpoint_ec2":              &hcldec.AttrSpec{Name: "custom_endpoint_ec2", Type: cty.String, Required: false},
+		"shared_credentials_file":          &hcldec.AttrSpec{Name: "shared_credentials_file", Type: cty.String, Required: false},
+		"decode_authorization_messages":    &hcldec.AttrSpec{Name: "decode_authorization_messages", Type: cty.Bool, Required: false},
+		"insecure_skip_tls_verify":         &hcldec.AttrSpec{Name: "insecure_skip_tls_verify", Type: cty.Bool, Required: false},
+		"max_retries":                      &hcldec.AttrSpec{Name: "max_retries", Type: cty.Number, Required: false},
+		"aws_retry_mode":                   &hcldec.AttrSpec{Name: "aws_retry_mode", Type: cty.String, Required: false},
+		"mfa_code":                         &hcldec.AttrSpec{Name: "mfa_code", Type: cty.String, Required: false},
+		"profile":                          &hcldec.AttrSpec{Name: "profile", Type: cty.String, Required: false},
+		"region":                           &hcldec.AttrSpec{Name: "region", Type: cty.String, Required: false},
+		"secret_key":                       &hcldec.AttrSpec{Name: "secret_key", Type: cty.String, Required: false},
+		"skip_metadata_api_check":          &hcldec.AttrSpec{Name: "skip_metadata_api_check", Type: cty.Bool, Required: false},
+		"skip_credential_validation":       &hcldec.AttrSpec{Name: "skip_credential_validation", Type: cty.Bool, Required: false},
+		"aws_user_agent_suffix":            &hcldec.AttrSpec{Name: "aws_user_agent_suffix", Type: cty.String, Required: false},
+		"per_request_timeout":              &hcldec.AttrSpec{Name: "per_request_timeout", Type: cty.String, Required: false},
+		"token":                            &hcldec.AttrSpec{Name: "token", Type: cty.String, Required: false},
+		"vault_aws_engine":                 &hcldec.BlockSpec{TypeName: "vault_aws_engine", Nested: hcldec.ObjectSpec((*common.FlatVaultAWSEngineOptions)(nil).HCL2Spec())},
+		"aws_polling":                      &hcldec.BlockSpec{TypeName: "aws_polling", Nested: hcldec.ObjectSpec((*common.FlatAWSPollingConfig)(nil).HCL2Spec())},
+		"s3_bucket_name":                   &hcldec.AttrSpec{Name: "s3_bucket_name", Type: cty.String, Required: false},
+		"s3_key_name":                      &hcldec.AttrSpec{Name: "s3_key_name", Type: cty.String, Required: false},
+		"s3_key_prefix":                    &hcldec.AttrSpec{Name: "s3_key_prefix", Type: cty.String, Required: false},
+		"s3_encryption":                    &hcldec.AttrSpec{Name: "s3_encryption", Type: cty.String, Required: false},
+		"s3_encryption_key":                &hcldec.AttrSpec{Name: "s3_encryption_key", Type: cty.String, Required: false},
+		"s3_tags":                          &hcldec.AttrSpec{Name: "s3_tags", Type: cty.Map(cty.String), Required: false},
+		"s3_upload_state_file":             &hcldec.AttrSpec{Name: "s3_upload_state_file", Type: cty.String, Required: false},
+		"s3_upload_part_size_mb":           &hcldec.AttrSpec{Name: "s3_upload_part_size_mb", Type: cty.Number, Required: false},
+		"s3_upload_concurrency":            &hcldec.AttrSpec{Name: "s3_upload_concurrency", Type: cty.Number, Required: false},
+		"s3_presigned_url":                 &hcldec.AttrSpec{Name: "s3_presigned_url", Type: cty.Bool, Required: false},
+		"s3_presigned_url_expiry":          &hcldec.AttrSpec{Name: "s3_presigned_url_expiry", Type: cty.String, Required: false},
+		"skip_clean":                       &hcldec.AttrSpec{Name: "skip_clean", Type: cty.Bool, Required: false},
+		"skip_upload":                      &hcldec.AttrSpec{Name: "skip_upload", Type: cty.Bool, Required: false},
+		"skip_upload_if_exists":            &hcldec.AttrSpec{Name: "skip_upload_if_exists", Type: cty.Bool, Required: false},
+		"compute_checksum":                 &hcldec.AttrSpec{Name: "compute_checksum", Type: cty.String, Required: false},
+		"checksum_directory":               &hcldec.AttrSpec{Name: "checksum_directory", Type: cty.String, Required: false},
+		"s3_checksum_metadata":             &hcldec.AttrSpec{Name: "s3_checksum_metadata", Type: cty.Bool, Required: false},
+		"resume_task_id":                   &hcldec.AttrSpec{Name: "resume_task_id", Type: cty.String, Required: false},
+		"dry_run":                          &hcldec.AttrSpec{Name: "dry_run", Type: cty.Bool, Required: false},
+		"detect_architecture":              &hcldec.AttrSpec{Name: "detect_architecture", Type: cty.Bool, Required: false},
+		"max_concurrent_import_tasks":      &hcldec.AttrSpec{Name: "max_concurrent_import_tasks", Type: cty.Number, Required: false},
+		"verify_role":                      &hcldec.AttrSpec{Name: "verify_role", Type: cty.Bool, Required: false},
+		"tags":                             &hcldec.AttrSpec{Name: "tags", Type: cty.Map(cty.String), Required: false},
+		"snapshot_tags":                    &hcldec.AttrSpec{Name: "snapshot_tags", Type: cty.Map(cty.String), Required: false},
+		"tag_import_source":                &hcldec.AttrSpec{Name: "tag_import_source", Type: cty.Bool, Required: false},
+		"ami_name":                         &hcldec.AttrSpec{Name: "ami_name", Type: cty.String, Required: false},
+		"intermediary_ami_name":            &hcldec.AttrSpec{Name: "intermediary_ami_name", Type: cty.String, Required: false},
+		"copy_image_max_attempts":          &hcldec.AttrSpec{Name: "copy_image_max_attempts", Type: cty.Number, Required: false},
+		"ami_description":                  &hcldec.AttrSpec{Name: "ami_description", Type: cty.String, Required: false},
+		"ami_users":                        &hcldec.AttrSpec{Name: "ami_users", Type: cty.List(cty.String), Required: false},
+		"share_import_snapshot_with":       &hcldec.AttrSpec{Name: "share_import_snapshot_with", Type: cty.List(cty.String), Required: false},
+		"ami_groups":                       &hcldec.AttrSpec{Name: "ami_groups", Type: cty.List(cty.String), Required: false},
+		"ami_org_arns":                     &hcldec.AttrSpec{Name: "ami_org_arns", Type: cty.List(cty.String), Required: false},
+		"ami_ou_arns":                      &hcldec.AttrSpec{Name: "ami_ou_arns", Type: cty.List(cty.String), Required: false},
+		"ami_share_duration":               &hcldec.AttrSpec{Name: "ami_share_duration", Type: cty.String, Required: false},
+		"ami_encrypt":                      &hcldec.AttrSpec{Name: "ami_encrypt", Type: cty.Bool, Required: false},
+		"ami_kms_key":                      &hcldec.AttrSpec{Name: "ami_kms_key", Type: cty.String, Required: false},
+		"ami_regions":                      &hcldec.AttrSpec{Name: "ami_regions", Type: cty.List(cty.String), Required: false},
+		"region_kms_key_ids":               &hcldec.AttrSpec{Name: "region_kms_key_ids", Type: cty.Map(cty.String), Required: false},
+		"imds_support":                     &hcldec.AttrSpec{Name: "imds_support", Type: cty.String, Required: false},
+		"imds_http_put_response_hop_limit": &hcldec.AttrSpec{Name: "imds_http_put_response_hop_limit", Type: cty.Number, Required: false},
+		"tpm_support":                      &hcldec.AttrSpec{Name: "tpm_support", Type: cty.String, Required: false},
+		"license_type":                     &hcldec.AttrSpec{Name: "license_type", Type: cty.String, Required: false},
+		"role_name":                        &hcldec.AttrSpec{Name: "role_name", Type: cty.String, Required: false},
+		"format":                           &hcldec.AttrSpec{Name: "format", Type: cty.String, Required: false},
+		"architecture":                     &hcldec.AttrSpec{Name: "architecture", Type: cty.String, Required: false},
+		"boot_mode":                        &hcldec.AttrSpec{Name: "boot_mode", Type: cty.String, Required: false},
+		"platform":                         &hcldec.AttrSpec{Name: "platform", Type: cty.String, Required: false},
 	}
 	return s
 }
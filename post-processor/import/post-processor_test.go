@@ -0,0 +1,1729 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package amazonimport
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	ec2types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go"
+	awscommon "github.com/hashicorp/packer-plugin-amazon/common"
+	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
+)
+
+type mockEC2Client struct {
+	awscommon.Ec2Client
+
+	createTagsInputs []*ec2.CreateTagsInput
+}
+
+func (m *mockEC2Client) CreateTags(ctx context.Context, params *ec2.CreateTagsInput, optFns ...func(*ec2.Options)) (*ec2.CreateTagsOutput, error) {
+	m.createTagsInputs = append(m.createTagsInputs, params)
+	return &ec2.CreateTagsOutput{}, nil
+}
+
+func TestSnapshotTagger_TagsOnceSnapshotIsKnown(t *testing.T) {
+	client := &mockEC2Client{}
+	tagger := &snapshotTagger{
+		client: client,
+		ui:     packersdk.TestUi(t),
+		tags: []ec2types.Tag{
+			{Key: aws.String("team"), Value: aws.String("packer")},
+		},
+	}
+
+	task := ec2types.ImportImageTask{
+		ImportTaskId:    aws.String("import-ami-12345"),
+		Status:          aws.String("active"),
+		SnapshotDetails: []ec2types.SnapshotDetail{{Status: aws.String("active")}},
+	}
+
+	tagger.observe(context.TODO(), task)
+	if len(client.createTagsInputs) != 0 {
+		t.Fatalf("expected no tagging before the snapshot id is known, got %d calls", len(client.createTagsInputs))
+	}
+
+	task.SnapshotDetails[0].SnapshotId = aws.String("snap-12345")
+	tagger.observe(context.TODO(), task)
+	tagger.observe(context.TODO(), task)
+
+	if len(client.createTagsInputs) != 1 {
+		t.Fatalf("expected the snapshot to be tagged exactly once, got %d calls", len(client.createTagsInputs))
+	}
+	input := client.createTagsInputs[0]
+	if len(input.Resources) != 1 || input.Resources[0] != "snap-12345" {
+		t.Fatalf("expected snap-12345 to be tagged, got %v", input.Resources)
+	}
+	if len(input.Tags) != 1 || aws.ToString(input.Tags[0].Key) != "team" {
+		t.Fatalf("unexpected tags: %v", input.Tags)
+	}
+}
+
+func TestProgressReporter_ReportsChanges(t *testing.T) {
+	var out bytes.Buffer
+	reporter := &progressReporter{ui: &packersdk.BasicUi{Reader: new(bytes.Buffer), Writer: &out}}
+
+	task := ec2types.ImportImageTask{
+		ImportTaskId:  aws.String("import-ami-12345"),
+		Status:        aws.String("active"),
+		Progress:      aws.String("28"),
+		StatusMessage: aws.String("converting"),
+	}
+	reporter.observe(context.TODO(), task)
+	reporter.observe(context.TODO(), task)
+	task.Progress = aws.String("43")
+	reporter.observe(context.TODO(), task)
+	task.StatusMessage = aws.String("booting")
+	reporter.observe(context.TODO(), task)
+	task.Progress, task.StatusMessage, task.Status = nil, nil, aws.String("completed")
+	reporter.observe(context.TODO(), task)
+
+	expected := "Import task import-ami-12345: 28% (converting)\n" +
+		"Import task import-ami-12345: 43% (converting)\n" +
+		"Import task import-ami-12345: 43% (booting)\n"
+	if got := out.String(); got != expected {
+		t.Fatalf("expected a line per change:\n%s\ngot:\n%s", expected, got)
+	}
+}
+
+func testImportConfig() map[string]interface{} {
+	return map[string]interface{}{
+		"access_key":     "foo",
+		"secret_key":     "bar",
+		"region":         "us-east-1",
+		"s3_bucket_name": "importbucket",
+	}
+}
+
+func TestPostProcessorConfigure_BootModeAuto(t *testing.T) {
+	config := testImportConfig()
+	config["boot_mode"] = "auto"
+	config["platform"] = "linux"
+
+	var p PostProcessor
+	if err := p.Configure(config); err != nil {
+		t.Fatalf("should not have error: %s", err)
+	}
+
+	params := p.importImageInput([]string{p.config.S3Key})
+	if params.BootMode != "" {
+		t.Fatalf("boot mode should be omitted from the import params, got %q", params.BootMode)
+	}
+	if aws.ToString(params.Platform) != "linux" {
+		t.Fatalf("expected linux platform, got %q", aws.ToString(params.Platform))
+	}
+
+	config["architecture"] = "arm64"
+	p = PostProcessor{}
+	if err := p.Configure(config); err == nil {
+		t.Fatal("boot mode 'auto' should not be allowed for arm64 imports")
+	}
+}
+
+func TestPostProcessorConfigure_BootModeDefault(t *testing.T) {
+	var p PostProcessor
+	if err := p.Configure(testImportConfig()); err != nil {
+		t.Fatalf("should not have error: %s", err)
+	}
+
+	if params := p.importImageInput([]string{p.config.S3Key}); params.BootMode != ec2types.BootModeValuesLegacyBios {
+		t.Fatalf("expected legacy-bios boot mode, got %q", params.BootMode)
+	}
+}
+
+func TestPostProcessorConfigure_BootModeArchitecture(t *testing.T) {
+	tests := []struct {
+		architecture string
+		bootMode     string
+		wantErr      bool
+	}{
+		{"x86_64", "", false},
+		{"x86_64", "legacy-bios", false},
+		{"x86_64", "uefi", false},
+		{"x86_64", "uefi-preferred", false},
+		{"x86_64", "auto", false},
+		{"x86_64", "bios", true},
+		{"i386", "legacy-bios", false},
+		{"i386", "uefi-preferred", false},
+		{"arm64", "", false},
+		{"arm64", "legacy-bios", true},
+		{"arm64", "uefi", false},
+		{"arm64", "uefi-preferred", false},
+		{"arm64", "auto", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.architecture+"/"+tt.bootMode, func(t *testing.T) {
+			config := testImportConfig()
+			config["architecture"] = tt.architecture
+			config["platform"] = "linux"
+			if tt.bootMode != "" {
+				config["boot_mode"] = tt.bootMode
+			}
+
+			var p PostProcessor
+			err := p.Configure(config)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("expected error: %t, got %v", tt.wantErr, err)
+			}
+			if err != nil || tt.bootMode == "" || tt.bootMode == "auto" {
+				return
+			}
+			if params := p.importImageInput([]string{p.config.S3Key}); string(params.BootMode) != tt.bootMode {
+				t.Fatalf("expected boot mode %s in the import params, got %q", tt.bootMode, params.BootMode)
+			}
+		})
+	}
+}
+
+func TestPostProcessorConfigure_ShareWithDefaultKMSKey(t *testing.T) {
+	tests := []struct {
+		name    string
+		extra   map[string]interface{}
+		wantErr bool
+	}{
+		{"encrypted, not shared", map[string]interface{}{"ami_encrypt": true}, false},
+		{"shared, not encrypted", map[string]interface{}{"ami_users": []string{"123456789012"}}, false},
+		{"shared with users, default key", map[string]interface{}{"ami_encrypt": true, "ami_users": []string{"123456789012"}}, true},
+		{"shared with an organization, default key", map[string]interface{}{"ami_encrypt": true, "ami_org_arns": []string{"arn:aws:organizations::123456789012:organization/o-abcdefghij"}}, true},
+		{"shared, custom key", map[string]interface{}{"ami_encrypt": true, "ami_users": []string{"123456789012"}, "ami_kms_key": "alias/shared"}, false},
+		{"invalid key", map[string]interface{}{"ami_encrypt": true, "ami_kms_key": "not a key"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := testImportConfig()
+			for k, v := range tt.extra {
+				config[k] = v
+			}
+
+			var p PostProcessor
+			err := p.Configure(config)
+			if tt.wantErr && err == nil {
+				t.Fatal("should have error")
+			}
+			if !tt.wantErr && err != nil {
+				t.Fatalf("should not have error: %s", err)
+			}
+		})
+	}
+}
+
+func TestImportArtifact_RecordsImportTask(t *testing.T) {
+	artifact := importArtifact(&aws.Config{Region: "us-east-1"}, "ami-12345", "import-ami-0123456789abcdef0")
+
+	if got := artifact.State("import_task_id"); got != "import-ami-0123456789abcdef0" {
+		t.Fatalf("expected the import task id in artifact state, got %#v", got)
+	}
+	if got := artifact.State("import_task_region"); got != "us-east-1" {
+		t.Fatalf("expected the import task region in artifact state, got %#v", got)
+	}
+	if got := artifact.Id(); got != "us-east-1:ami-12345" {
+		t.Fatalf("unexpected artifact id %q", got)
+	}
+}
+
+func TestImportTaskArn(t *testing.T) {
+	taskArn, err := importTaskArn("arn:aws-us-gov:sts::123456789012:assumed-role/packer/build", "us-gov-west-1", "import-ami-0123456789abcdef0")
+	if err != nil {
+		t.Fatalf("should not have error: %s", err)
+	}
+
+	expected := "arn:aws-us-gov:ec2:us-gov-west-1:123456789012:import-image-task/import-ami-0123456789abcdef0"
+	if taskArn != expected {
+		t.Fatalf("expected %q, got %q", expected, taskArn)
+	}
+
+	if _, err := importTaskArn("not-an-arn", "us-east-1", "import-ami-0123456789abcdef0"); err == nil {
+		t.Fatalf("should error on an invalid caller ARN")
+	}
+}
+
+func TestPostProcessor_TagImportSource(t *testing.T) {
+	client := &mockEC2Client{}
+	p := &PostProcessor{config: Config{
+		S3Bucket: "importbucket",
+		S3Key:    "packer-import-1.ova",
+	}}
+
+	keys := []string{"packer-import-1.vmdk", "packer-import-2.vmdk", "packer-import-3.vmdk"}
+	if err := p.tagImportSource(context.Background(), client, packersdk.TestUi(t), "ami-12345", "import-ami-0123456789abcdef0", keys); err != nil {
+		t.Fatalf("should not have error: %s", err)
+	}
+
+	if len(client.createTagsInputs) != 1 {
+		t.Fatalf("expected a single CreateTags call, got %d", len(client.createTagsInputs))
+	}
+	input := client.createTagsInputs[0]
+	if len(input.Resources) != 1 || input.Resources[0] != "ami-12345" {
+		t.Fatalf("expected only the AMI to be tagged, got %v", input.Resources)
+	}
+
+	tags := make(map[string]string)
+	for _, tag := range input.Tags {
+		tags[aws.ToString(tag.Key)] = aws.ToString(tag.Value)
+	}
+	expected := map[string]string{
+		"ImportSource":  "s3://importbucket/packer-import-1.vmdk",
+		"ImportSource1": "s3://importbucket/packer-import-2.vmdk",
+		"ImportSource2": "s3://importbucket/packer-import-3.vmdk",
+		"ImportTaskId":  "import-ami-0123456789abcdef0",
+	}
+	if len(tags) != len(expected) {
+		t.Fatalf("expected tags %v, got %v", expected, tags)
+	}
+	for key, value := range expected {
+		if tags[key] != value {
+			t.Fatalf("expected tag %s=%q, got %q", key, value, tags[key])
+		}
+	}
+}
+
+func TestPostProcessor_TagImportSourceResumed(t *testing.T) {
+	client := &mockEC2Client{}
+	p := &PostProcessor{config: Config{
+		S3Bucket: "importbucket",
+		S3Key:    "packer-import-1.ova",
+	}}
+
+	if err := p.tagImportSource(context.Background(), client, packersdk.TestUi(t), "ami-12345", "import-ami-0123456789abcdef0", nil); err != nil {
+		t.Fatalf("should not have error: %s", err)
+	}
+
+	for _, tag := range client.createTagsInputs[0].Tags {
+		if aws.ToString(tag.Key) == "ImportSource" {
+			if aws.ToString(tag.Value) != "s3://importbucket/packer-import-1.ova" {
+				t.Fatalf("expected s3_key as the import source, got %q", aws.ToString(tag.Value))
+			}
+			return
+		}
+	}
+	t.Fatal("expected the AMI to be tagged with its import source")
+}
+
+// throttledCopyClient throttles the first CopyImage calls, and returns the
+// same image for every call with the same client token like EC2 does.
+type throttledCopyClient struct {
+	awscommon.Ec2Client
+
+	throttles int
+	calls     int
+	tokens    []string
+	images    map[string]string
+}
+
+func (m *throttledCopyClient) CopyImage(ctx context.Context, params *ec2.CopyImageInput, optFns ...func(*ec2.Options)) (*ec2.CopyImageOutput, error) {
+	m.calls++
+	m.tokens = append(m.tokens, aws.ToString(params.ClientToken))
+	if m.calls <= m.throttles {
+		return nil, &smithy.GenericAPIError{Code: "RequestLimitExceeded", Message: "Request limit exceeded."}
+	}
+
+	if m.images == nil {
+		m.images = make(map[string]string)
+	}
+	token := aws.ToString(params.ClientToken)
+	if _, ok := m.images[token]; !ok {
+		m.images[token] = fmt.Sprintf("ami-copy%d", len(m.images)+1)
+	}
+	return &ec2.CopyImageOutput{ImageId: aws.String(m.images[token])}, nil
+}
+
+func TestPostProcessor_CopyImageRetriesThrottling(t *testing.T) {
+	client := &throttledCopyClient{throttles: 1}
+	p := &PostProcessor{config: Config{CopyMaxAttempts: 3}}
+
+	resp, err := p.copyImage(context.Background(), client, &ec2.CopyImageInput{
+		Name:          aws.String("imported"),
+		SourceImageId: aws.String("ami-12345"),
+		SourceRegion:  aws.String("us-east-1"),
+		ClientToken:   aws.String("packer-rename-import-ami-0123456789abcdef0"),
+	})
+	if err != nil {
+		t.Fatalf("should not have error: %s", err)
+	}
+
+	if client.calls != 2 {
+		t.Fatalf("expected CopyImage to be retried once, got %d calls", client.calls)
+	}
+	if client.tokens[0] != client.tokens[1] {
+		t.Fatalf("retries should reuse the client token, got %v", client.tokens)
+	}
+	if len(client.images) != 1 || aws.ToString(resp.ImageId) != "ami-copy1" {
+		t.Fatalf("expected a single copied AMI, got %v", client.images)
+	}
+}
+
+func TestPostProcessor_CopyImageGivesUp(t *testing.T) {
+	client := &throttledCopyClient{throttles: 5}
+	p := &PostProcessor{config: Config{CopyMaxAttempts: 2}}
+
+	_, err := p.copyImage(context.Background(), client, &ec2.CopyImageInput{
+		ClientToken: aws.String("packer-rename-import-ami-0123456789abcdef0"),
+	})
+	if err == nil {
+		t.Fatalf("should error once the attempts are exhausted")
+	}
+	if client.calls != 2 {
+		t.Fatalf("expected 2 CopyImage calls, got %d", client.calls)
+	}
+}
+
+func TestPostProcessorConfigure_CopyMaxAttempts(t *testing.T) {
+	var p PostProcessor
+	if err := p.Configure(testImportConfig()); err != nil {
+		t.Fatalf("should not have error: %s", err)
+	}
+	if p.config.CopyMaxAttempts != 11 {
+		t.Fatalf("expected 11 attempts by default, got %d", p.config.CopyMaxAttempts)
+	}
+
+	config := testImportConfig()
+	config["copy_image_max_attempts"] = -1
+	if err := (&PostProcessor{}).Configure(config); err == nil {
+		t.Fatal("a negative copy_image_max_attempts should be rejected")
+	}
+}
+
+type snapshotShareClient struct {
+	awscommon.Ec2Client
+
+	modifySnapshotAttributeInputs []*ec2.ModifySnapshotAttributeInput
+}
+
+func (m *snapshotShareClient) DescribeImages(ctx context.Context, params *ec2.DescribeImagesInput, optFns ...func(*ec2.Options)) (*ec2.DescribeImagesOutput, error) {
+	return &ec2.DescribeImagesOutput{
+		Images: []ec2types.Image{{
+			ImageId: aws.String(params.ImageIds[0]),
+			BlockDeviceMappings: []ec2types.BlockDeviceMapping{
+				{DeviceName: aws.String("/dev/sda1"), Ebs: &ec2types.EbsBlockDevice{SnapshotId: aws.String("snap-1")}},
+				{DeviceName: aws.String("/dev/sdb"), VirtualName: aws.String("ephemeral0")},
+				{DeviceName: aws.String("/dev/sdc"), Ebs: &ec2types.EbsBlockDevice{SnapshotId: aws.String("snap-2")}},
+			},
+		}},
+	}, nil
+}
+
+func (m *snapshotShareClient) ModifySnapshotAttribute(ctx context.Context, params *ec2.ModifySnapshotAttributeInput, optFns ...func(*ec2.Options)) (*ec2.ModifySnapshotAttributeOutput, error) {
+	m.modifySnapshotAttributeInputs = append(m.modifySnapshotAttributeInputs, params)
+	return &ec2.ModifySnapshotAttributeOutput{}, nil
+}
+
+func TestPostProcessor_ShareSnapshots(t *testing.T) {
+	client := &snapshotShareClient{}
+	p := &PostProcessor{config: Config{SnapshotUsers: []string{"123456789012", "210987654321"}}}
+
+	if err := p.shareSnapshots(context.Background(), client, packersdk.TestUi(t), "ami-12345"); err != nil {
+		t.Fatalf("should not have error: %s", err)
+	}
+
+	if len(client.modifySnapshotAttributeInputs) != 2 {
+		t.Fatalf("expected both snapshots to be shared, got %d calls", len(client.modifySnapshotAttributeInputs))
+	}
+	for i, snapshotId := range []string{"snap-1", "snap-2"} {
+		input := client.modifySnapshotAttributeInputs[i]
+		if aws.ToString(input.SnapshotId) != snapshotId {
+			t.Fatalf("expected %s to be shared, got %s", snapshotId, aws.ToString(input.SnapshotId))
+		}
+		if input.Attribute != ec2types.SnapshotAttributeNameCreateVolumePermission {
+			t.Fatalf("unexpected attribute %q", input.Attribute)
+		}
+		var users []string
+		for _, permission := range input.CreateVolumePermission.Add {
+			users = append(users, aws.ToString(permission.UserId))
+		}
+		if fmt.Sprint(users) != "[123456789012 210987654321]" {
+			t.Fatalf("unexpected users %v", users)
+		}
+	}
+}
+
+func TestPostProcessorConfigure_EncryptionKeys(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   map[string]interface{}
+		expected string
+	}{
+		{"two keys", map[string]interface{}{
+			"s3_encryption": "aws:kms", "s3_encryption_key": "alias/upload", "ami_encrypt": true, "ami_kms_key": "alias/ami",
+		}, ""},
+		{"default keys", map[string]interface{}{"s3_encryption": "aws:kms", "ami_encrypt": true}, ""},
+		{"unencrypted", map[string]interface{}{}, ""},
+		{"only the S3 key", map[string]interface{}{
+			"s3_encryption": "aws:kms", "s3_encryption_key": "alias/upload", "ami_encrypt": true,
+		}, "as ami_kms_key isn't set"},
+		{"only the AMI key", map[string]interface{}{
+			"s3_encryption": "aws:kms", "ami_encrypt": true, "ami_kms_key": "alias/ami",
+		}, "as s3_encryption_key isn't set"},
+		{"unencrypted AMI", map[string]interface{}{
+			"s3_encryption": "aws:kms", "s3_encryption_key": "alias/upload",
+		}, "the AMI isn't encrypted"},
+		{"S3 key without encryption", map[string]interface{}{"s3_encryption_key": "alias/upload"}, "uploaded unencrypted"},
+		{"AMI key without encryption", map[string]interface{}{"ami_kms_key": "alias/ami"}, "the AMI won't be encrypted"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := testImportConfig()
+			for key, value := range tt.config {
+				config[key] = value
+			}
+
+			var p PostProcessor
+			if err := p.Configure(config); err != nil {
+				t.Fatalf("should not have error: %s", err)
+			}
+			warnings := strings.Join(p.config.encryptionKeyWarnings(), "\n")
+			if tt.expected == "" && warnings != "" {
+				t.Fatalf("expected no warning, got %q", warnings)
+			}
+			if !strings.Contains(warnings, tt.expected) {
+				t.Fatalf("expected a warning containing %q, got %q", tt.expected, warnings)
+			}
+		})
+	}
+
+	config := testImportConfig()
+	config["s3_encryption"] = "aws:kms"
+	config["s3_encryption_key"] = "not a key"
+	var p PostProcessor
+	if err := p.Configure(config); err == nil {
+		t.Fatal("should error on an invalid s3_encryption_key")
+	}
+}
+
+func TestPostProcessorConfigure_ShareSnapshotsWithDefaultKMSKey(t *testing.T) {
+	config := testImportConfig()
+	config["share_import_snapshot_with"] = []string{"123456789012"}
+	config["ami_encrypt"] = true
+
+	var p PostProcessor
+	if err := p.Configure(config); err == nil {
+		t.Fatal("sharing snapshots encrypted with the default key should be refused")
+	}
+
+	config["ami_kms_key"] = "alias/shared"
+	p = PostProcessor{}
+	if err := p.Configure(config); err != nil {
+		t.Fatalf("should not have error: %s", err)
+	}
+}
+
+func TestImageSources(t *testing.T) {
+	files := []string{"output/disk1.vmdk", "output/box.ovf", "output/disk2.vmdk", "output/a.ova", "output/b.ova"}
+
+	if got := imageSources(files, "vmdk"); !reflect.DeepEqual(got, []string{"output/disk1.vmdk", "output/disk2.vmdk"}) {
+		t.Fatalf("every vmdk file should be a disk, got %v", got)
+	}
+	if got := imageSources(files, "ova"); !reflect.DeepEqual(got, []string{"output/a.ova"}) {
+		t.Fatalf("a single ova should be imported, got %v", got)
+	}
+	if got := imageSources(files, "raw"); len(got) != 0 {
+		t.Fatalf("expected no raw files, got %v", got)
+	}
+}
+
+func TestPostProcessor_ImportsEveryDisk(t *testing.T) {
+	config := testImportConfig()
+	config["format"] = "vmdk"
+	config["s3_key_name"] = "images/web.vmdk"
+
+	var p PostProcessor
+	if err := p.Configure(config); err != nil {
+		t.Fatalf("should not have error: %s", err)
+	}
+
+	keys := []string{diskName(p.config.S3Key, 0), diskName(p.config.S3Key, 1)}
+	if !reflect.DeepEqual(keys, []string{"images/web.vmdk", "images/web-2.vmdk"}) {
+		t.Fatalf("each disk should have its own key, got %v", keys)
+	}
+
+	params := p.importImageInput(keys)
+	if len(params.DiskContainers) != 2 {
+		t.Fatalf("expected a disk container per key, got %d", len(params.DiskContainers))
+	}
+	for i, disk := range params.DiskContainers {
+		if aws.ToString(disk.UserBucket.S3Key) != keys[i] || aws.ToString(disk.Format) != "vmdk" {
+			t.Fatalf("unexpected disk container %d: %s %s", i, aws.ToString(disk.UserBucket.S3Key), aws.ToString(disk.Format))
+		}
+	}
+}
+
+func TestPostProcessorConfigure_ResumeTaskId(t *testing.T) {
+	config := testImportConfig()
+	delete(config, "s3_bucket_name")
+	config["resume_task_id"] = "import-ami-0123456789abcdef0"
+
+	var p PostProcessor
+	if err := p.Configure(config); err == nil {
+		t.Fatal("resuming a task should require skip_clean")
+	}
+
+	config["skip_clean"] = true
+	p = PostProcessor{}
+	if err := p.Configure(config); err != nil {
+		t.Fatalf("resuming a task shouldn't need an S3 bucket: %s", err)
+	}
+
+	config["resume_task_id"] = "ami-12345"
+	p = PostProcessor{}
+	if err := p.Configure(config); err == nil {
+		t.Fatal("should error on an invalid import task ID")
+	}
+}
+
+type importTaskClient struct {
+	awscommon.Ec2Client
+
+	task ec2types.ImportImageTask
+}
+
+func (m *importTaskClient) DescribeImportImageTasks(ctx context.Context, params *ec2.DescribeImportImageTasksInput, optFns ...func(*ec2.Options)) (*ec2.DescribeImportImageTasksOutput, error) {
+	if params.ImportTaskIds[0] != aws.ToString(m.task.ImportTaskId) {
+		return &ec2.DescribeImportImageTasksOutput{}, nil
+	}
+	return &ec2.DescribeImportImageTasksOutput{ImportImageTasks: []ec2types.ImportImageTask{m.task}}, nil
+}
+
+func TestPostProcessor_ResumeImport(t *testing.T) {
+	client := &importTaskClient{task: ec2types.ImportImageTask{
+		ImportTaskId: aws.String("import-ami-0123456789abcdef0"),
+		Status:       aws.String("active"),
+		SnapshotDetails: []ec2types.SnapshotDetail{{
+			UserBucket: &ec2types.UserBucketDetails{S3Bucket: aws.String("importbucket"), S3Key: aws.String("packer-import-1.ova")},
+		}},
+	}}
+	p := &PostProcessor{}
+
+	if err := p.resumeImport(context.Background(), client, packersdk.TestUi(t), "import-ami-0123456789abcdef0"); err != nil {
+		t.Fatalf("should not have error: %s", err)
+	}
+	if p.config.S3Bucket != "importbucket" || p.config.S3Key != "packer-import-1.ova" {
+		t.Fatalf("the import source should come from the task, got s3://%s/%s", p.config.S3Bucket, p.config.S3Key)
+	}
+
+	if err := p.resumeImport(context.Background(), client, packersdk.TestUi(t), "import-ami-fedcba9876543210f"); err == nil {
+		t.Fatal("should error on an unknown task")
+	}
+
+	client.task.Status = aws.String("deleted")
+	if err := p.resumeImport(context.Background(), client, packersdk.TestUi(t), "import-ami-0123456789abcdef0"); err == nil {
+		t.Fatal("should error on a deleted task")
+	}
+}
+
+func TestPostProcessorConfigure_SkipUpload(t *testing.T) {
+	config := testImportConfig()
+	config["skip_upload"] = true
+	config["skip_clean"] = true
+
+	var p PostProcessor
+	if err := p.Configure(config); err == nil {
+		t.Fatal("skip_upload should require s3_key_name")
+	}
+
+	config["s3_key_name"] = "staged/web.vmdk"
+	p = PostProcessor{}
+	if err := p.Configure(config); err != nil {
+		t.Fatalf("should not have error: %s", err)
+	}
+
+	config["skip_upload_if_exists"] = true
+	p = PostProcessor{}
+	if err := p.Configure(config); err == nil {
+		t.Fatal("skip_upload_if_exists should conflict with skip_upload")
+	}
+
+	delete(config, "skip_upload_if_exists")
+	config["skip_clean"] = false
+	p = PostProcessor{}
+	if err := p.Configure(config); err == nil {
+		t.Fatal("skip_upload should require skip_clean")
+	}
+}
+
+func TestPostProcessorConfigure_SkipUploadIfExistsWithoutSkipClean(t *testing.T) {
+	config := testImportConfig()
+	config["skip_upload_if_exists"] = true
+	config["s3_key_name"] = "images/web.vmdk"
+
+	var p PostProcessor
+	if err := p.Configure(config); err == nil || !strings.Contains(err.Error(), "skip_clean") {
+		t.Fatalf("skip_upload_if_exists should require skip_clean, got %v", err)
+	}
+
+	config["skip_clean"] = true
+	p = PostProcessor{}
+	if err := p.Configure(config); err != nil {
+		t.Fatalf("should not have error: %s", err)
+	}
+}
+
+func TestPostProcessorConfigure_SkipUploadIfExistsWithoutKey(t *testing.T) {
+	config := testImportConfig()
+	config["skip_upload_if_exists"] = true
+	config["skip_clean"] = true
+
+	var p PostProcessor
+	if err := p.Configure(config); err != nil {
+		t.Fatalf("should not have error: %s", err)
+	}
+	if len(p.warnings) != 1 || !strings.Contains(p.warnings[0], "s3_key_name") {
+		t.Fatalf("expected a warning about s3_key_name, got %v", p.warnings)
+	}
+
+	config["s3_key_name"] = "images/web.vmdk"
+	p = PostProcessor{}
+	if err := p.Configure(config); err != nil {
+		t.Fatalf("should not have error: %s", err)
+	}
+	if len(p.warnings) != 0 {
+		t.Fatalf("expected no warning with s3_key_name set, got %v", p.warnings)
+	}
+}
+
+func TestPostProcessorConfigure_UploadPartSizeAndConcurrency(t *testing.T) {
+	config := testImportConfig()
+	config["s3_upload_part_size_mb"] = 64
+	config["s3_upload_concurrency"] = 16
+
+	var p PostProcessor
+	if err := p.Configure(config); err != nil {
+		t.Fatalf("should not have error: %s", err)
+	}
+
+	uploader := manager.NewUploader(s3.New(s3.Options{}), p.configureUploader)
+	if uploader.PartSize != 64*1024*1024 || uploader.Concurrency != 16 {
+		t.Fatalf("unexpected uploader part size %d and concurrency %d", uploader.PartSize, uploader.Concurrency)
+	}
+
+	config["s3_upload_part_size_mb"] = 4
+	p = PostProcessor{}
+	if err := p.Configure(config); err == nil {
+		t.Fatal("parts smaller than 5MB should be refused")
+	}
+
+	config["s3_upload_part_size_mb"] = 5
+	config["s3_upload_concurrency"] = -1
+	p = PostProcessor{}
+	if err := p.Configure(config); err == nil {
+		t.Fatal("a negative concurrency should be refused")
+	}
+}
+
+func TestPostProcessorConfigure_S3Tags(t *testing.T) {
+	config := testImportConfig()
+	tags := make(map[string]string)
+	for i := 0; i <= maxS3ObjectTags; i++ {
+		tags[fmt.Sprintf("key-%d", i)] = "value"
+	}
+	config["s3_tags"] = tags
+
+	var p PostProcessor
+	if err := p.Configure(config); err == nil {
+		t.Fatalf("more than %d s3_tags should be refused", maxS3ObjectTags)
+	}
+}
+
+type dryRunClient struct {
+	awscommon.Ec2Client
+
+	err    error
+	params *ec2.ImportImageInput
+}
+
+func (m *dryRunClient) ImportImage(ctx context.Context, params *ec2.ImportImageInput, optFns ...func(*ec2.Options)) (*ec2.ImportImageOutput, error) {
+	m.params = params
+	return nil, m.err
+}
+
+func TestPostProcessor_DryRunImport(t *testing.T) {
+	var p PostProcessor
+	if err := p.Configure(testImportConfig()); err != nil {
+		t.Fatalf("should not have error: %s", err)
+	}
+
+	client := &dryRunClient{err: &smithy.GenericAPIError{Code: "DryRunOperation", Message: "Request would have succeeded, but DryRun flag is set."}}
+	if err := p.dryRunImport(context.TODO(), client, packersdk.TestUi(t), []string{p.config.S3Key}); err != nil {
+		t.Fatalf("a dry run that would have succeeded shouldn't error: %s", err)
+	}
+	if !aws.ToBool(client.params.DryRun) {
+		t.Fatal("the import should be a dry run")
+	}
+
+	client.err = &smithy.GenericAPIError{Code: "UnauthorizedOperation", Message: "You are not authorized to perform this operation."}
+	if err := p.dryRunImport(context.TODO(), client, packersdk.TestUi(t), []string{p.config.S3Key}); err == nil {
+		t.Fatal("a dry run that would have failed should error")
+	}
+}
+
+// failingImportClient fails the ImportImage calls with the given errors,
+// then starts the import.
+type failingImportClient struct {
+	awscommon.Ec2Client
+
+	errs  []error
+	calls int
+}
+
+func (m *failingImportClient) ImportImage(ctx context.Context, params *ec2.ImportImageInput, optFns ...func(*ec2.Options)) (*ec2.ImportImageOutput, error) {
+	m.calls++
+	if m.calls <= len(m.errs) {
+		return nil, m.errs[m.calls-1]
+	}
+	return &ec2.ImportImageOutput{ImportTaskId: aws.String("import-ami-0123456789abcdef0")}, nil
+}
+
+func TestPostProcessor_StartImportRetries(t *testing.T) {
+	var p PostProcessor
+	if err := p.Configure(testImportConfig()); err != nil {
+		t.Fatalf("should not have error: %s", err)
+	}
+
+	client := &failingImportClient{errs: []error{
+		&smithy.GenericAPIError{Code: "RequestLimitExceeded", Message: "Request limit exceeded."},
+		&smithy.GenericAPIError{Code: "ResourceCountLimitExceeded", Message: "You have reached the limit of active import tasks."},
+	}}
+	taskId, err := p.startImport(context.Background(), client, packersdk.TestUi(t), []string{p.config.S3Key})
+	if err != nil {
+		t.Fatalf("transient errors should be retried: %s", err)
+	}
+	if taskId != "import-ami-0123456789abcdef0" || client.calls != 3 {
+		t.Fatalf("expected the import to start on the third call, got %s after %d calls", taskId, client.calls)
+	}
+
+	client = &failingImportClient{errs: []error{
+		&smithy.GenericAPIError{Code: "InvalidParameter", Message: "The service role vmimport provided does not exist.", Fault: smithy.FaultClient},
+	}}
+	if _, err := p.startImport(context.Background(), client, packersdk.TestUi(t), []string{p.config.S3Key}); err == nil {
+		t.Fatal("a client error should fail the import")
+	}
+	if client.calls != 1 {
+		t.Fatalf("a client error shouldn't be retried, got %d calls", client.calls)
+	}
+}
+
+func TestPostProcessorConfigure_DryRunResumeTaskId(t *testing.T) {
+	config := testImportConfig()
+	config["dry_run"] = true
+	config["resume_task_id"] = "import-ami-0123456789abcdef0"
+	config["skip_clean"] = true
+
+	var p PostProcessor
+	if err := p.Configure(config); err == nil {
+		t.Fatal("dry_run can't be used with resume_task_id")
+	}
+}
+
+func TestPostProcessorConfigure_DetectArchitecture(t *testing.T) {
+	for format, valid := range map[string]bool{"raw": true, "vmdk": true, "vhd": false, "ova": false} {
+		config := testImportConfig()
+		config["format"] = format
+		config["detect_architecture"] = true
+		config["architecture"] = "x86_64"
+
+		var p PostProcessor
+		err := p.Configure(config)
+		if valid && err != nil {
+			t.Fatalf("detect_architecture should be supported with %s: %s", format, err)
+		}
+		if !valid && err == nil {
+			t.Fatalf("detect_architecture shouldn't be supported with %s", format)
+		}
+	}
+
+	config := testImportConfig()
+	config["format"] = "raw"
+	config["detect_architecture"] = true
+
+	var p PostProcessor
+	if err := p.Configure(config); err == nil {
+		t.Fatal("detect_architecture should require architecture")
+	}
+}
+
+// importQuotaClient has active import tasks until the given number of
+// counts were made, then none.
+type importQuotaClient struct {
+	awscommon.Ec2Client
+
+	active int
+	counts int
+	freeAt int
+}
+
+func (m *importQuotaClient) DescribeImportImageTasks(ctx context.Context, params *ec2.DescribeImportImageTasksInput, optFns ...func(*ec2.Options)) (*ec2.DescribeImportImageTasksOutput, error) {
+	if len(params.Filters) != 1 || aws.ToString(params.Filters[0].Name) != "task-state" || params.Filters[0].Values[0] != "active" {
+		return nil, fmt.Errorf("expected only the active tasks to be described, got %v", params.Filters)
+	}
+	m.counts++
+	if m.counts >= m.freeAt {
+		return &ec2.DescribeImportImageTasksOutput{}, nil
+	}
+	tasks := make([]ec2types.ImportImageTask, m.active)
+	for i := range tasks {
+		tasks[i] = ec2types.ImportImageTask{ImportTaskId: aws.String(fmt.Sprintf("import-ami-%d", i)), Status: aws.String("active")}
+	}
+	return &ec2.DescribeImportImageTasksOutput{ImportImageTasks: tasks}, nil
+}
+
+func TestPostProcessor_WaitForImportSlot(t *testing.T) {
+	defer func(delay time.Duration) { importSlotDelay = delay }(importSlotDelay)
+	importSlotDelay = time.Millisecond
+
+	// The quota is full for the first two counts.
+	client := &importQuotaClient{active: 5, freeAt: 3}
+	p := &PostProcessor{config: Config{MaxImportTasks: 5}}
+	if err := p.waitForImportSlot(context.Background(), client, packersdk.TestUi(t)); err != nil {
+		t.Fatalf("should not have error: %s", err)
+	}
+	if client.counts != 3 {
+		t.Fatalf("expected to wait until the quota frees up, got %d counts", client.counts)
+	}
+
+	// A slot is left.
+	client = &importQuotaClient{active: 4, freeAt: 3}
+	if err := p.waitForImportSlot(context.Background(), client, packersdk.TestUi(t)); err != nil {
+		t.Fatalf("should not have error: %s", err)
+	}
+	if client.counts != 1 {
+		t.Fatalf("expected not to wait with a free slot, got %d counts", client.counts)
+	}
+
+	// The quota never frees up.
+	client = &importQuotaClient{active: 5, freeAt: 1000}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := p.waitForImportSlot(ctx, client, packersdk.TestUi(t)); err == nil {
+		t.Fatal("should error once the context is done")
+	}
+
+	// The wait is bounded by the polling timeout.
+	client = &importQuotaClient{active: 5, freeAt: 1000}
+	p.config.PollingConfig = &awscommon.AWSPollingConfig{Timeout: 20 * time.Millisecond}
+	err := p.waitForImportSlot(context.Background(), client, packersdk.TestUi(t))
+	if !errors.Is(err, awscommon.ErrPollingTimeout) {
+		t.Fatalf("should time out waiting for a slot, got %v", err)
+	}
+
+	// And by importSlotTimeout without polling timeout.
+	defer func(timeout time.Duration) { importSlotTimeout = timeout }(importSlotTimeout)
+	importSlotTimeout = 20 * time.Millisecond
+	client = &importQuotaClient{active: 5, freeAt: 1000}
+	p.config.PollingConfig = nil
+	err = p.waitForImportSlot(context.Background(), client, packersdk.TestUi(t))
+	if !errors.Is(err, awscommon.ErrPollingTimeout) {
+		t.Fatalf("should time out waiting for a slot, got %v", err)
+	}
+
+	// Without max_concurrent_import_tasks, the tasks aren't counted.
+	client = &importQuotaClient{}
+	p = &PostProcessor{}
+	if err := p.waitForImportSlot(context.Background(), client, packersdk.TestUi(t)); err != nil {
+		t.Fatalf("should not have error: %s", err)
+	}
+	if client.counts != 0 {
+		t.Fatalf("expected no count, got %d", client.counts)
+	}
+}
+
+func TestPostProcessorConfigure_S3KeyPrefix(t *testing.T) {
+	config := testImportConfig()
+	config["s3_key_prefix"] = "images/web"
+
+	keys := make(map[string]bool)
+	for i := 0; i < 2; i++ {
+		var p PostProcessor
+		if err := p.Configure(config); err != nil {
+			t.Fatalf("should not have error: %s", err)
+		}
+		if !strings.HasPrefix(p.config.S3Key, "images/web/packer-import-{{timestamp}}-") || !strings.HasSuffix(p.config.S3Key, ".ova") {
+			t.Fatalf("unexpected key %q", p.config.S3Key)
+		}
+		keys[p.config.S3Key] = true
+	}
+	if len(keys) != 2 {
+		t.Fatalf("each build should get a unique key, got %v", keys)
+	}
+
+	config["s3_key_prefix"] = "/images"
+	var p PostProcessor
+	if err := p.Configure(config); err == nil {
+		t.Fatal("a prefix starting with '/' should be refused")
+	}
+
+	config["s3_key_prefix"] = "images"
+	config["s3_key_name"] = "images/web.ova"
+	p = PostProcessor{}
+	if err := p.Configure(config); err == nil {
+		t.Fatal("s3_key_prefix and s3_key_name can't both be set")
+	}
+}
+
+// regionCopyClient plays the EC2 API of a region, where the copied AMIs are
+// available right away.
+type regionCopyClient struct {
+	awscommon.Ec2Client
+
+	region       string
+	copyInputs   []*ec2.CopyImageInput
+	tagged       []string
+	modified     []string
+	failTags     bool
+	deregistered []string
+}
+
+func (m *regionCopyClient) DescribeImages(ctx context.Context, params *ec2.DescribeImagesInput, optFns ...func(*ec2.Options)) (*ec2.DescribeImagesOutput, error) {
+	return &ec2.DescribeImagesOutput{Images: []ec2types.Image{{
+		ImageId:     aws.String(params.ImageIds[0]),
+		Name:        aws.String("imported"),
+		Description: aws.String("web server"),
+		State:       ec2types.ImageStateAvailable,
+		BlockDeviceMappings: []ec2types.BlockDeviceMapping{{
+			Ebs: &ec2types.EbsBlockDevice{SnapshotId: aws.String("snap-" + m.region)},
+		}},
+	}}}, nil
+}
+
+func (m *regionCopyClient) CopyImage(ctx context.Context, params *ec2.CopyImageInput, optFns ...func(*ec2.Options)) (*ec2.CopyImageOutput, error) {
+	m.copyInputs = append(m.copyInputs, params)
+	return &ec2.CopyImageOutput{ImageId: aws.String("ami-" + m.region)}, nil
+}
+
+func (m *regionCopyClient) CreateTags(ctx context.Context, params *ec2.CreateTagsInput, optFns ...func(*ec2.Options)) (*ec2.CreateTagsOutput, error) {
+	if m.failTags {
+		return nil, fmt.Errorf("UnauthorizedOperation")
+	}
+	m.tagged = append(m.tagged, params.Resources...)
+	return &ec2.CreateTagsOutput{}, nil
+}
+
+func (m *regionCopyClient) ModifyImageAttribute(ctx context.Context, params *ec2.ModifyImageAttributeInput, optFns ...func(*ec2.Options)) (*ec2.ModifyImageAttributeOutput, error) {
+	m.modified = append(m.modified, aws.ToString(params.ImageId))
+	return &ec2.ModifyImageAttributeOutput{}, nil
+}
+
+func (m *regionCopyClient) DeregisterImage(ctx context.Context, params *ec2.DeregisterImageInput, optFns ...func(*ec2.Options)) (*ec2.DeregisterImageOutput, error) {
+	m.deregistered = append(m.deregistered, aws.ToString(params.ImageId))
+	return &ec2.DeregisterImageOutput{}, nil
+}
+
+func (m *regionCopyClient) DeleteSnapshot(ctx context.Context, params *ec2.DeleteSnapshotInput, optFns ...func(*ec2.Options)) (*ec2.DeleteSnapshotOutput, error) {
+	m.deregistered = append(m.deregistered, aws.ToString(params.SnapshotId))
+	return &ec2.DeleteSnapshotOutput{}, nil
+}
+
+func TestPostProcessor_CopyToRegions(t *testing.T) {
+	config := testImportConfig()
+	config["ami_regions"] = []string{"eu-west-1", "us-east-1", "ap-southeast-2", "eu-west-1"}
+	config["ami_encrypt"] = true
+	config["region_kms_key_ids"] = map[string]string{
+		"eu-west-1":      "arn:aws:kms:eu-west-1:123456789012:key/12345678-1234-1234-1234-123456789012",
+		"us-east-1":      "",
+		"ap-southeast-2": "",
+	}
+
+	var p PostProcessor
+	if err := p.Configure(config); err != nil {
+		t.Fatalf("should not have error: %s", err)
+	}
+	if !reflect.DeepEqual(p.config.AMIRegions, []string{"eu-west-1", "ap-southeast-2"}) {
+		t.Fatalf("the regions should be deduplicated, without the import region, got %v", p.config.AMIRegions)
+	}
+
+	clients := make(map[string]*regionCopyClient)
+	p.regionClient = func(_ *aws.Config, region string) awscommon.Ec2Client {
+		if clients[region] == nil {
+			clients[region] = &regionCopyClient{region: region}
+		}
+		return clients[region]
+	}
+
+	source := &regionCopyClient{region: "us-east-1"}
+	tags := []ec2types.Tag{{Key: aws.String("team"), Value: aws.String("platform")}}
+	options := map[string]*ec2.ModifyImageAttributeInput{
+		"users": {UserIds: []string{"123456789012"}},
+	}
+	copies, err := p.copyToRegions(context.TODO(), &aws.Config{Region: "us-east-1"}, source, packersdk.TestUi(t),
+		"ami-12345", "import-ami-0123456789abcdef0", nil, tags, nil, options)
+	if err != nil {
+		t.Fatalf("should not have error: %s", err)
+	}
+
+	expected := map[string]string{"eu-west-1": "ami-eu-west-1", "ap-southeast-2": "ami-ap-southeast-2"}
+	if !reflect.DeepEqual(copies, expected) {
+		t.Fatalf("expected copies %v, got %v", expected, copies)
+	}
+
+	eu := clients["eu-west-1"]
+	input := eu.copyInputs[0]
+	if aws.ToString(input.SourceImageId) != "ami-12345" || aws.ToString(input.SourceRegion) != "us-east-1" || aws.ToString(input.Name) != "imported" {
+		t.Fatalf("unexpected copy input %+v", input)
+	}
+	if !aws.ToBool(input.Encrypted) || aws.ToString(input.KmsKeyId) != config["region_kms_key_ids"].(map[string]string)["eu-west-1"] {
+		t.Fatal("the copy should be encrypted with the key of its region")
+	}
+	if ap := clients["ap-southeast-2"].copyInputs[0]; !aws.ToBool(ap.Encrypted) || ap.KmsKeyId != nil {
+		t.Fatal("the copy should be encrypted with the default key of its region")
+	}
+	if !reflect.DeepEqual(eu.tagged, []string{"ami-eu-west-1", "snap-eu-west-1"}) {
+		t.Fatalf("the copy and its snapshot should be tagged, got %v", eu.tagged)
+	}
+	if !reflect.DeepEqual(eu.modified, []string{"ami-eu-west-1"}) {
+		t.Fatalf("the attributes of the copy should be modified, got %v", eu.modified)
+	}
+}
+
+func TestPostProcessorConfigure_RegionKMSKeyIDs(t *testing.T) {
+	config := testImportConfig()
+	config["ami_regions"] = []string{"eu-west-1"}
+	config["region_kms_key_ids"] = map[string]string{"eu-west-2": "alias/images"}
+	config["ami_encrypt"] = true
+
+	var p PostProcessor
+	if err := p.Configure(config); err == nil {
+		t.Fatal("the regions of region_kms_key_ids should be in ami_regions")
+	}
+
+	config["region_kms_key_ids"] = map[string]string{"eu-west-1": "alias/images"}
+	config["ami_encrypt"] = false
+	p = PostProcessor{}
+	if err := p.Configure(config); err == nil {
+		t.Fatal("region_kms_key_ids should require ami_encrypt")
+	}
+
+	config["region_kms_key_ids"] = map[string]string{"eu-west-1": ""}
+	config["ami_encrypt"] = true
+	config["ami_kms_key"] = "alias/images"
+	config["ami_users"] = []string{"123456789012"}
+	p = PostProcessor{}
+	if err := p.Configure(config); err == nil {
+		t.Fatal("copies encrypted with the default key can't be shared")
+	}
+}
+
+// tagsClient records the tags applied by CreateTags, by resource.
+type tagsClient struct {
+	awscommon.Ec2Client
+
+	calls int
+	tags  map[string][]string
+}
+
+func (m *tagsClient) DescribeImages(ctx context.Context, params *ec2.DescribeImagesInput, optFns ...func(*ec2.Options)) (*ec2.DescribeImagesOutput, error) {
+	return &ec2.DescribeImagesOutput{Images: []ec2types.Image{{
+		ImageId: aws.String(params.ImageIds[0]),
+		BlockDeviceMappings: []ec2types.BlockDeviceMapping{
+			{Ebs: &ec2types.EbsBlockDevice{SnapshotId: aws.String("snap-1")}},
+			{Ebs: &ec2types.EbsBlockDevice{SnapshotId: aws.String("snap-2")}},
+		},
+	}}}, nil
+}
+
+func (m *tagsClient) CreateTags(ctx context.Context, params *ec2.CreateTagsInput, optFns ...func(*ec2.Options)) (*ec2.CreateTagsOutput, error) {
+	m.calls++
+	if m.tags == nil {
+		m.tags = make(map[string][]string)
+	}
+	for _, resource := range params.Resources {
+		for _, tag := range params.Tags {
+			m.tags[resource] = append(m.tags[resource], aws.ToString(tag.Key)+"="+aws.ToString(tag.Value))
+		}
+	}
+	return &ec2.CreateTagsOutput{}, nil
+}
+
+func TestTagImage(t *testing.T) {
+	tags := []ec2types.Tag{{Key: aws.String("team"), Value: aws.String("platform")}}
+	snapshotTags := []ec2types.Tag{{Key: aws.String("retention"), Value: aws.String("long")}}
+
+	cases := []struct {
+		name         string
+		tags         []ec2types.Tag
+		snapshotTags []ec2types.Tag
+		calls        int
+		expected     map[string][]string
+	}{
+		{
+			name:  "tags only",
+			tags:  tags,
+			calls: 1,
+			expected: map[string][]string{
+				"ami-12345": {"team=platform"},
+				"snap-1":    {"team=platform"},
+				"snap-2":    {"team=platform"},
+			},
+		},
+		{
+			name:         "tags and snapshot tags",
+			tags:         tags,
+			snapshotTags: snapshotTags,
+			calls:        2,
+			expected: map[string][]string{
+				"ami-12345": {"team=platform"},
+				"snap-1":    {"retention=long"},
+				"snap-2":    {"retention=long"},
+			},
+		},
+		{
+			name:         "snapshot tags only",
+			snapshotTags: snapshotTags,
+			calls:        1,
+			expected: map[string][]string{
+				"snap-1": {"retention=long"},
+				"snap-2": {"retention=long"},
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			client := &tagsClient{}
+			if err := tagImage(context.TODO(), client, packersdk.TestUi(t), "ami-12345", tc.tags, tc.snapshotTags); err != nil {
+				t.Fatalf("should not have error: %s", err)
+			}
+			if client.calls != tc.calls {
+				t.Errorf("expected %d CreateTags calls, got %d", tc.calls, client.calls)
+			}
+			if !reflect.DeepEqual(client.tags, tc.expected) {
+				t.Errorf("expected tags %v, got %v", tc.expected, client.tags)
+			}
+		})
+	}
+}
+
+func TestPostProcessor_TagIntermediary(t *testing.T) {
+	config := testImportConfig()
+	config["ami_name"] = "web-server"
+
+	var p PostProcessor
+	if err := p.Configure(config); err != nil {
+		t.Fatalf("should not have error: %s", err)
+	}
+
+	client := &tagsClient{}
+	p.tagIntermediary(context.TODO(), client, packersdk.TestUi(t), "ami-12345")
+
+	for _, resource := range []string{"ami-12345", "snap-1", "snap-2"} {
+		tags := client.tags[resource]
+		if len(tags) != 2 || !strings.HasPrefix(tags[0], "Name=packer-import-intermediary-") || strings.Contains(tags[0], "{{") {
+			t.Fatalf("%s should be named after the rendered intermediary_ami_name, got %v", resource, tags)
+		}
+		if tags[1] != "packer-import-intermediary=true" {
+			t.Fatalf("%s should be marked as intermediary, got %v", resource, tags)
+		}
+	}
+}
+
+func TestPostProcessorConfigure_IntermediaryName(t *testing.T) {
+	config := testImportConfig()
+	config["intermediary_ami_name"] = "tmp-{{timestamp}}"
+
+	var p PostProcessor
+	if err := p.Configure(config); err == nil {
+		t.Fatal("intermediary_ami_name should require ami_name")
+	}
+
+	config["ami_name"] = "web-server"
+	p = PostProcessor{}
+	if err := p.Configure(config); err != nil {
+		t.Fatalf("should not have error: %s", err)
+	}
+	if p.config.IntermediaryName != "tmp-{{timestamp}}" {
+		t.Fatalf("intermediary_ami_name should only be rendered at import, got %s", p.config.IntermediaryName)
+	}
+}
+
+func TestPostProcessorConfigure_ComputeChecksum(t *testing.T) {
+	tests := []struct {
+		name    string
+		extra   map[string]interface{}
+		wantErr bool
+		wantDir string
+	}{
+		{"sha256", map[string]interface{}{"compute_checksum": "sha256"}, false, "."},
+		{"sha512 in S3 metadata", map[string]interface{}{"compute_checksum": "sha512", "s3_checksum_metadata": true}, false, "."},
+		{"unknown algorithm", map[string]interface{}{"compute_checksum": "md5"}, true, ""},
+		{"metadata without checksum", map[string]interface{}{"s3_checksum_metadata": true}, true, ""},
+		{"directory", map[string]interface{}{"compute_checksum": "sha256", "checksum_directory": "checksums"}, false, "checksums"},
+		{"directory without checksum", map[string]interface{}{"checksum_directory": "checksums"}, true, ""},
+		{"resumed import", map[string]interface{}{"compute_checksum": "sha256", "resume_task_id": "import-ami-0123456789abcdef0"}, true, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := testImportConfig()
+			for k, v := range tt.extra {
+				config[k] = v
+			}
+
+			var p PostProcessor
+			if err := p.Configure(config); (err != nil) != tt.wantErr {
+				t.Fatalf("expected error: %t, got %v", tt.wantErr, err)
+			}
+			// The directory of the images may be deleted once they are
+			// imported.
+			if !tt.wantErr && p.config.ChecksumDirectory != tt.wantDir {
+				t.Fatalf("expected the checksums in %q, got %q", tt.wantDir, p.config.ChecksumDirectory)
+			}
+		})
+	}
+}
+
+// tpmClient serves a single imported AMI, and records the calls that
+// register it again.
+type tpmClient struct {
+	awscommon.Ec2Client
+
+	image    ec2types.Image
+	calls    []string
+	register *ec2.RegisterImageInput
+}
+
+func (m *tpmClient) DescribeImages(ctx context.Context, params *ec2.DescribeImagesInput, optFns ...func(*ec2.Options)) (*ec2.DescribeImagesOutput, error) {
+	image := m.image
+	image.ImageId = aws.String(params.ImageIds[0])
+	image.State = ec2types.ImageStateAvailable
+	return &ec2.DescribeImagesOutput{Images: []ec2types.Image{image}}, nil
+}
+
+func (m *tpmClient) DeregisterImage(ctx context.Context, params *ec2.DeregisterImageInput, optFns ...func(*ec2.Options)) (*ec2.DeregisterImageOutput, error) {
+	m.calls = append(m.calls, "deregister "+aws.ToString(params.ImageId))
+	return &ec2.DeregisterImageOutput{}, nil
+}
+
+func (m *tpmClient) RegisterImage(ctx context.Context, params *ec2.RegisterImageInput, optFns ...func(*ec2.Options)) (*ec2.RegisterImageOutput, error) {
+	m.calls = append(m.calls, "register "+aws.ToString(params.Name))
+	m.register = params
+	return &ec2.RegisterImageOutput{ImageId: aws.String(fmt.Sprintf("ami-tpm-%d", len(m.calls)))}, nil
+}
+
+func TestPostProcessor_RegisterWithTpmSupport(t *testing.T) {
+	config := testImportConfig()
+	config["tpm_support"] = "v2.0"
+	config["boot_mode"] = "uefi"
+	config["platform"] = "linux"
+
+	var p PostProcessor
+	if err := p.Configure(config); err != nil {
+		t.Fatalf("should not have error: %s", err)
+	}
+
+	client := &tpmClient{image: ec2types.Image{
+		Name:               aws.String("import-ami-12345"),
+		Architecture:       ec2types.ArchitectureValuesX8664,
+		BootMode:           ec2types.BootModeValuesUefi,
+		RootDeviceName:     aws.String("/dev/sda1"),
+		VirtualizationType: ec2types.VirtualizationTypeHvm,
+		BlockDeviceMappings: []ec2types.BlockDeviceMapping{{
+			DeviceName: aws.String("/dev/sda1"),
+			Ebs: &ec2types.EbsBlockDevice{
+				SnapshotId: aws.String("snap-12345"),
+				Encrypted:  aws.Bool(true),
+			},
+		}},
+	}}
+
+	amiId, err := p.registerWithTpmSupport(context.TODO(), client, "ami-12345")
+	if err != nil {
+		t.Fatalf("should not have error: %s", err)
+	}
+	if amiId != "ami-tpm-3" {
+		t.Fatalf("expected the registered AMI, got %s", amiId)
+	}
+
+	// The AMI is only deregistered once it has a replacement.
+	if len(client.calls) != 4 || !strings.HasPrefix(client.calls[0], "register packer-") {
+		t.Fatalf("expected the AMI to be registered under a temporary name first, got calls %v", client.calls)
+	}
+	expected := []string{"deregister ami-12345", "register import-ami-12345", "deregister ami-tpm-1"}
+	if !reflect.DeepEqual(client.calls[1:], expected) {
+		t.Fatalf("expected calls %v, got %v", expected, client.calls[1:])
+	}
+	if client.register.TpmSupport != ec2types.TpmSupportValuesV20 {
+		t.Fatalf("expected TpmSupport v2.0, got %q", client.register.TpmSupport)
+	}
+	if client.register.BootMode != ec2types.BootModeValuesUefi {
+		t.Fatalf("expected the boot mode to be kept, got %q", client.register.BootMode)
+	}
+	ebs := client.register.BlockDeviceMappings[0].Ebs
+	if aws.ToString(ebs.SnapshotId) != "snap-12345" || ebs.Encrypted != nil {
+		t.Fatalf("unexpected block device mapping: %+v", ebs)
+	}
+
+	// An AMI with NitroTPM support already is kept.
+	client.image.TpmSupport = ec2types.TpmSupportValuesV20
+	client.calls = nil
+	if amiId, err := p.registerWithTpmSupport(context.TODO(), client, "ami-12345"); err != nil || amiId != "ami-12345" {
+		t.Fatalf("expected ami-12345 to be kept, got %s: %v", amiId, err)
+	}
+	if len(client.calls) != 0 {
+		t.Fatalf("expected no calls, got %v", client.calls)
+	}
+
+	// An AMI billed with a license isn't registered again, it would lose it.
+	client.image.TpmSupport = ""
+	client.image.PlatformDetails = aws.String("Windows")
+	client.image.UsageOperation = aws.String("RunInstances:0002")
+	if _, err := p.registerWithTpmSupport(context.TODO(), client, "ami-12345"); err == nil {
+		t.Fatal("should have error")
+	}
+	if len(client.calls) != 0 {
+		t.Fatalf("expected no calls, got %v", client.calls)
+	}
+}
+
+func TestPostProcessorConfigure_TpmSupport(t *testing.T) {
+	tests := []struct {
+		name    string
+		extra   map[string]interface{}
+		wantErr bool
+	}{
+		{"unset", map[string]interface{}{}, false},
+		{"v2.0 with uefi", map[string]interface{}{"tpm_support": "v2.0", "boot_mode": "uefi", "platform": "linux"}, false},
+		{"v2.0 with uefi-preferred", map[string]interface{}{"tpm_support": "v2.0", "boot_mode": "uefi-preferred", "platform": "linux"}, false},
+		{"v2.0 with legacy-bios", map[string]interface{}{"tpm_support": "v2.0", "boot_mode": "legacy-bios"}, true},
+		{"v2.0 with the default boot mode", map[string]interface{}{"tpm_support": "v2.0"}, true},
+		{"unknown version", map[string]interface{}{"tpm_support": "v1.2", "boot_mode": "uefi", "platform": "linux"}, true},
+		{"v2.0 with windows", map[string]interface{}{"tpm_support": "v2.0", "boot_mode": "uefi", "platform": "windows"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := testImportConfig()
+			for k, v := range tt.extra {
+				config[k] = v
+			}
+
+			var p PostProcessor
+			err := p.Configure(config)
+			if tt.wantErr && err == nil {
+				t.Fatal("should have error")
+			}
+			if !tt.wantErr && err != nil {
+				t.Fatalf("should not have error: %s", err)
+			}
+		})
+	}
+}
+
+type launchTemplateClient struct {
+	awscommon.Ec2Client
+
+	input   *ec2.CreateLaunchTemplateInput
+	deleted []string
+}
+
+func (m *launchTemplateClient) DeleteLaunchTemplate(ctx context.Context, params *ec2.DeleteLaunchTemplateInput, optFns ...func(*ec2.Options)) (*ec2.DeleteLaunchTemplateOutput, error) {
+	m.deleted = append(m.deleted, aws.ToString(params.LaunchTemplateId))
+	return &ec2.DeleteLaunchTemplateOutput{}, nil
+}
+
+func (m *launchTemplateClient) CreateLaunchTemplate(ctx context.Context, params *ec2.CreateLaunchTemplateInput, optFns ...func(*ec2.Options)) (*ec2.CreateLaunchTemplateOutput, error) {
+	m.input = params
+	return &ec2.CreateLaunchTemplateOutput{
+		LaunchTemplate: &ec2types.LaunchTemplate{LaunchTemplateId: aws.String("lt-12345")},
+	}, nil
+}
+
+func TestPostProcessor_CreateMetadataLaunchTemplate(t *testing.T) {
+	config := testImportConfig()
+	config["imds_support"] = "v2.0"
+	config["imds_http_put_response_hop_limit"] = 2
+
+	var p PostProcessor
+	if err := p.Configure(config); err != nil {
+		t.Fatalf("should not have error: %s", err)
+	}
+
+	client := &launchTemplateClient{}
+	id, err := p.createMetadataLaunchTemplate(context.TODO(), client, packersdk.TestUi(t), "ami-12345")
+	if err != nil {
+		t.Fatalf("should not have error: %s", err)
+	}
+	if id != "lt-12345" {
+		t.Fatalf("expected lt-12345, got %s", id)
+	}
+
+	data := client.input.LaunchTemplateData
+	if aws.ToString(data.ImageId) != "ami-12345" {
+		t.Fatalf("expected the launch template of ami-12345, got %s", aws.ToString(data.ImageId))
+	}
+	if aws.ToInt32(data.MetadataOptions.HttpPutResponseHopLimit) != 2 {
+		t.Fatalf("expected hop limit 2, got %d", aws.ToInt32(data.MetadataOptions.HttpPutResponseHopLimit))
+	}
+	if data.MetadataOptions.HttpTokens != ec2types.LaunchTemplateHttpTokensStateRequired {
+		t.Fatalf("expected IMDSv2 tokens to be required, got %q", data.MetadataOptions.HttpTokens)
+	}
+}
+
+func TestPostProcessor_DeleteLaunchTemplate(t *testing.T) {
+	var p PostProcessor
+	client := &launchTemplateClient{}
+	p.deleteLaunchTemplate(context.TODO(), client, packersdk.TestUi(t), "lt-12345")
+	if !reflect.DeepEqual(client.deleted, []string{"lt-12345"}) {
+		t.Fatalf("expected lt-12345 to be deleted, got %v", client.deleted)
+	}
+}
+
+func TestPostProcessorConfigure_IMDSHopLimit(t *testing.T) {
+	for limit, valid := range map[int]bool{0: true, 1: true, 64: true, -1: false, 65: false} {
+		config := testImportConfig()
+		config["imds_http_put_response_hop_limit"] = limit
+
+		var p PostProcessor
+		if err := p.Configure(config); valid != (err == nil) {
+			t.Fatalf("hop limit %d: expected valid %t, got %v", limit, valid, err)
+		}
+	}
+}
+
+func TestPostProcessor_TagUnshareAfter(t *testing.T) {
+	config := testImportConfig()
+	config["ami_users"] = []string{"123456789012"}
+	config["ami_share_duration"] = "72h"
+
+	var p PostProcessor
+	if err := p.Configure(config); err != nil {
+		t.Fatalf("should not have error: %s", err)
+	}
+
+	client := &tagsClient{}
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	tag, err := p.tagUnshareAfter(context.TODO(), client, packersdk.TestUi(t), "ami-12345", now)
+	if err != nil {
+		t.Fatalf("should not have error: %s", err)
+	}
+
+	expected := []string{"unshare-after=2024-03-04T12:00:00Z"}
+	if !reflect.DeepEqual(client.tags["ami-12345"], expected) {
+		t.Fatalf("expected the AMI to be tagged %v, got %v", expected, client.tags)
+	}
+	if len(client.tags) != 1 {
+		t.Fatalf("only the AMI should be tagged, got %v", client.tags)
+	}
+	if aws.ToString(tag.Value) != "2024-03-04T12:00:00Z" {
+		t.Fatalf("unexpected tag value %s", aws.ToString(tag.Value))
+	}
+}
+
+func TestPostProcessorConfigure_ShareDuration(t *testing.T) {
+	config := testImportConfig()
+	config["ami_share_duration"] = "24h"
+
+	var p PostProcessor
+	if err := p.Configure(config); err == nil {
+		t.Fatal("ami_share_duration should require the AMI to be shared")
+	}
+
+	config["ami_groups"] = []string{"all"}
+	p = PostProcessor{}
+	if err := p.Configure(config); err != nil {
+		t.Fatalf("should not have error: %s", err)
+	}
+
+	config["ami_share_duration"] = "-1h"
+	p = PostProcessor{}
+	if err := p.Configure(config); err == nil {
+		t.Fatal("ami_share_duration should not be negative")
+	}
+}
+
+func TestRecordSources(t *testing.T) {
+	config := &aws.Config{Region: "us-east-1"}
+	keys := []string{"disk-0.vmdk", "disk-1.vmdk"}
+
+	importTask := importArtifact(config, "ami-12345", "import-ami-12345")
+	recordSources(importTask, "bucket", keys, []string{"", ""})
+	if importTask.State("s3_bucket") != "bucket" || importTask.State("s3_key") != "disk-0.vmdk" {
+		t.Fatalf("expected the bucket and boot disk key, got %v", importTask.StateData)
+	}
+	if !reflect.DeepEqual(importTask.State("s3_keys"), keys) {
+		t.Fatalf("expected the keys %v, got %v", keys, importTask.State("s3_keys"))
+	}
+	if importTask.State("s3_version_id") != nil {
+		t.Fatalf("an unversioned bucket should have no version ID, got %v", importTask.State("s3_version_id"))
+	}
+	if importTask.State("import_task_id") != "import-ami-12345" {
+		t.Fatalf("expected the import task ID, got %v", importTask.State("import_task_id"))
+	}
+
+	importTask = importArtifact(config, "ami-12345", "import-ami-12345")
+	recordSources(importTask, "bucket", keys, []string{"v-boot", "v-data"})
+	if importTask.State("s3_version_id") != "v-boot" {
+		t.Fatalf("expected the version ID of the boot disk, got %v", importTask.State("s3_version_id"))
+	}
+	if !reflect.DeepEqual(importTask.State("s3_version_ids"), []string{"v-boot", "v-data"}) {
+		t.Fatalf("unexpected version IDs %v", importTask.State("s3_version_ids"))
+	}
+}
+
+func TestPostProcessorConfigure_S3PresignedURL(t *testing.T) {
+	config := testImportConfig()
+	config["s3_presigned_url_expiry"] = "1h"
+
+	var p PostProcessor
+	if err := p.Configure(config); err == nil {
+		t.Fatal("s3_presigned_url_expiry should require s3_presigned_url")
+	}
+
+	delete(config, "s3_presigned_url_expiry")
+	config["s3_presigned_url"] = true
+	p = PostProcessor{}
+	if err := p.Configure(config); err != nil {
+		t.Fatalf("should not have error: %s", err)
+	}
+	if p.config.S3PresignedExpiry != defaultPresignedURLExpiry {
+		t.Fatalf("expected the default expiry, got %s", p.config.S3PresignedExpiry)
+	}
+
+	for _, expiry := range []string{"-1h", "200h"} {
+		config["s3_presigned_url_expiry"] = expiry
+		p = PostProcessor{}
+		if err := p.Configure(config); err == nil {
+			t.Fatalf("an expiry of %s should be rejected", expiry)
+		}
+	}
+}
+
+func TestPostProcessor_PresignedURLDiskContainers(t *testing.T) {
+	config := testImportConfig()
+	config["format"] = "vmdk"
+	config["s3_presigned_url"] = true
+
+	var p PostProcessor
+	if err := p.Configure(config); err != nil {
+		t.Fatalf("should not have error: %s", err)
+	}
+
+	client := s3.NewPresignClient(s3.New(s3.Options{
+		Region:      "us-east-1",
+		Credentials: credentials.NewStaticCredentialsProvider("AKID", "SECRET", ""),
+	}))
+	keys := []string{"images/web.vmdk", "images/web-2.vmdk"}
+	urls, err := presignDiskURLs(context.TODO(), client, p.config.S3Bucket, keys, p.config.S3PresignedExpiry)
+	if err != nil {
+		t.Fatalf("should not have error: %s", err)
+	}
+	p.diskURLs = urls
+
+	params := p.importImageInput(keys)
+	for i, disk := range params.DiskContainers {
+		if disk.UserBucket != nil {
+			t.Fatalf("disk container %d should not read from the bucket", i)
+		}
+		u, err := url.Parse(aws.ToString(disk.Url))
+		if err != nil {
+			t.Fatalf("unexpected URL of disk container %d: %s", i, err)
+		}
+		if u.Scheme != "https" || !strings.HasSuffix(u.Path, "/"+keys[i]) {
+			t.Fatalf("disk container %d should read %s, got %s", i, keys[i], u)
+		}
+		query := u.Query()
+		if query.Get("X-Amz-Expires") != "43200" || query.Get("X-Amz-Signature") == "" {
+			t.Fatalf("disk container %d should have a URL signed for 12h, got %s", i, u)
+		}
+		if aws.ToString(disk.Format) != "vmdk" {
+			t.Fatalf("unexpected format of disk container %d: %s", i, aws.ToString(disk.Format))
+		}
+	}
+}
+
+func TestPostProcessor_UploadDisksDeletesPartialUpload(t *testing.T) {
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method+" "+r.URL.Path)
+	}))
+	defer server.Close()
+	client := s3.New(s3.Options{
+		Region:       "us-east-1",
+		Credentials:  credentials.NewStaticCredentialsProvider("AKID", "SECRET", ""),
+		BaseEndpoint: aws.String(server.URL),
+		UsePathStyle: true,
+	})
+
+	// The second disk can't be read, once the first one is uploaded.
+	dir := t.TempDir()
+	first := filepath.Join(dir, "web.vmdk")
+	if err := os.WriteFile(first, []byte("disk"), 0644); err != nil {
+		t.Fatalf("failed to write disk: %s", err)
+	}
+	artifact := &packersdk.MockArtifact{FilesValue: []string{first, filepath.Join(dir, "missing.vmdk")}}
+	ui := &packersdk.BasicUi{Reader: new(bytes.Buffer), Writer: new(bytes.Buffer)}
+
+	for _, skipClean := range []bool{false, true} {
+		requests = nil
+		p := &PostProcessor{config: Config{
+			S3Bucket:  "bucket",
+			S3Key:     "images/web.vmdk",
+			Format:    "vmdk",
+			SkipClean: skipClean,
+		}}
+		if _, err := p.uploadDisks(context.Background(), ui, client, artifact); err == nil {
+			t.Fatal("the upload of the second disk should fail")
+		}
+
+		expected := []string{"PUT /bucket/images/web.vmdk"}
+		if !skipClean {
+			expected = append(expected, "DELETE /bucket/images/web.vmdk")
+		}
+		if !reflect.DeepEqual(requests, expected) {
+			t.Fatalf("skip_clean %t: expected requests %v, got %v", skipClean, expected, requests)
+		}
+	}
+}
+
+func TestPostProcessor_CopyToRegionsFailure(t *testing.T) {
+	config := testImportConfig()
+	config["ami_regions"] = []string{"eu-west-1", "ap-southeast-2"}
+
+	var p PostProcessor
+	if err := p.Configure(config); err != nil {
+		t.Fatalf("should not have error: %s", err)
+	}
+
+	clients := map[string]*regionCopyClient{
+		"eu-west-1":      {region: "eu-west-1"},
+		"ap-southeast-2": {region: "ap-southeast-2", failTags: true},
+	}
+	p.regionClient = func(_ *aws.Config, region string) awscommon.Ec2Client {
+		return clients[region]
+	}
+
+	source := &regionCopyClient{region: "us-east-1"}
+	tags := []ec2types.Tag{{Key: aws.String("team"), Value: aws.String("platform")}}
+	copies, err := p.copyToRegions(context.TODO(), &aws.Config{Region: "us-east-1"}, source, packersdk.TestUi(t),
+		"ami-12345", "import-ami-0123456789abcdef0", nil, tags, nil, nil)
+	if err == nil {
+		t.Fatal("the failure to tag a copy should be an error")
+	}
+	if copies != nil {
+		t.Fatalf("no copy should be returned, got %v", copies)
+	}
+
+	// Every copy started is deregistered along with its snapshot, not only
+	// the one that failed.
+	for region, client := range clients {
+		expected := []string{"ami-" + region, "snap-" + region}
+		if !reflect.DeepEqual(client.deregistered, expected) {
+			t.Errorf("expected %v to be deleted in %s, got %v", expected, region, client.deregistered)
+		}
+	}
+}
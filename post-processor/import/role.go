@@ -0,0 +1,224 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package amazonimport
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/arn"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	iamtypes "github.com/aws/aws-sdk-go-v2/service/iam/types"
+	awscommon "github.com/hashicorp/packer-plugin-amazon/common"
+)
+
+// The role VM Import assumes when role_name isn't set.
+const defaultImportRole = "vmimport"
+
+const importRoleDocs = "https://docs.aws.amazon.com/vm-import/latest/userguide/required-permissions.html#vmimport-role"
+
+// The EC2 actions VM Import needs to register the imported image.
+var importRoleEC2Actions = []string{
+	"ec2:CopySnapshot",
+	"ec2:ModifySnapshotAttribute",
+	"ec2:RegisterImage",
+}
+
+// roleClient is the subset of the IAM API needed to read the policies of a
+// role.
+type roleClient interface {
+	GetRole(ctx context.Context, params *iam.GetRoleInput, optFns ...func(*iam.Options)) (*iam.GetRoleOutput, error)
+	ListAttachedRolePolicies(ctx context.Context, params *iam.ListAttachedRolePoliciesInput, optFns ...func(*iam.Options)) (*iam.ListAttachedRolePoliciesOutput, error)
+	GetPolicy(ctx context.Context, params *iam.GetPolicyInput, optFns ...func(*iam.Options)) (*iam.GetPolicyOutput, error)
+	GetPolicyVersion(ctx context.Context, params *iam.GetPolicyVersionInput, optFns ...func(*iam.Options)) (*iam.GetPolicyVersionOutput, error)
+	ListRolePolicies(ctx context.Context, params *iam.ListRolePoliciesInput, optFns ...func(*iam.Options)) (*iam.ListRolePoliciesOutput, error)
+	GetRolePolicy(ctx context.Context, params *iam.GetRolePolicyInput, optFns ...func(*iam.Options)) (*iam.GetRolePolicyOutput, error)
+}
+
+type policyDocument struct {
+	Statement awscommon.PolicyStatements
+}
+
+// parsePolicyDocument parses a policy document as returned by IAM, which
+// URL-encodes them.
+func parsePolicyDocument(document string) (*policyDocument, error) {
+	decoded, err := url.QueryUnescape(document)
+	if err != nil {
+		return nil, err
+	}
+	var doc policyDocument
+	if err := json.Unmarshal([]byte(decoded), &doc); err != nil {
+		return nil, err
+	}
+	return &doc, nil
+}
+
+// allows reports whether an Allow statement of doc grants action on
+// resource, or on any resource if resource is empty. Conditions are not
+// evaluated.
+func (doc *policyDocument) allows(action, resource string) bool {
+	for _, statement := range doc.Statement {
+		if statement.Effect != "Allow" || !statement.Action.MatchesAny(action) {
+			continue
+		}
+		if resource == "" || statement.Resource.MatchesAny(resource) {
+			return true
+		}
+	}
+	return false
+}
+
+// trusts reports whether an Allow statement of the trust policy doc lets
+// service assume the role.
+func (doc *policyDocument) trusts(service string) bool {
+	for _, statement := range doc.Statement {
+		if statement.Effect != "Allow" || !statement.Action.MatchesAny("sts:AssumeRole") {
+			continue
+		}
+		if statement.PrincipalValues("Service").MatchesAny(service) {
+			return true
+		}
+	}
+	return false
+}
+
+// verifyImportRole checks that the role roleName exists, can be assumed by
+// VM Import, and is granted the permissions the import of bucket/key needs,
+// so that a misconfigured role fails the build before the upload rather than
+// once the import task starts. The access to the bucket isn't checked when
+// bucket is empty, for disks read through presigned URLs.
+//
+// Permissions can also be granted by the bucket policy, or denied by
+// conditions, which aren't evaluated: the permissions the policies of the
+// role don't grant are returned as a warning rather than an error.
+func verifyImportRole(ctx context.Context, client roleClient, roleName, bucket, key string) (warning string, err error) {
+	role, err := client.GetRole(ctx, &iam.GetRoleInput{RoleName: aws.String(roleName)})
+	if err != nil {
+		var notFound *iamtypes.NoSuchEntityException
+		if errors.As(err, &notFound) {
+			return "", fmt.Errorf("The import role %q doesn't exist. Create it as described in %s, "+
+				"or set role_name to an existing role", roleName, importRoleDocs)
+		}
+		return "", fmt.Errorf("Failed to verify the import role %q, verify_role needs the iam:GetRole, "+
+			"iam:ListAttachedRolePolicies, iam:GetPolicy, iam:GetPolicyVersion, iam:ListRolePolicies and "+
+			"iam:GetRolePolicy permissions: %s", roleName, err)
+	}
+
+	trust, err := parsePolicyDocument(aws.ToString(role.Role.AssumeRolePolicyDocument))
+	if err != nil {
+		return "", fmt.Errorf("Failed to parse the trust policy of the import role %q: %s", roleName, err)
+	}
+	if !trust.trusts("vmie.amazonaws.com") {
+		return "", fmt.Errorf("The trust policy of the import role %q doesn't let VM Import (vmie.amazonaws.com) "+
+			"assume it. Allow it to, as described in %s", roleName, importRoleDocs)
+	}
+
+	policies, err := rolePolicies(ctx, client, roleName)
+	if err != nil {
+		return "", fmt.Errorf("Failed to read the policies of the import role %q: %s", roleName, err)
+	}
+
+	partition := "aws"
+	if roleArn, err := arn.Parse(aws.ToString(role.Role.Arn)); err == nil {
+		partition = roleArn.Partition
+	}
+	required := map[string]string{}
+	if bucket != "" {
+		required["s3:GetObject"] = fmt.Sprintf("arn:%s:s3:::%s/%s", partition, bucket, key)
+	}
+	// The snapshots and images are only known to VM Import, any resource
+	// will do.
+	for _, action := range importRoleEC2Actions {
+		required[action] = ""
+	}
+
+	var missing []string
+	for action, resource := range required {
+		allowed := false
+		for _, policy := range policies {
+			if policy.allows(action, resource) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed && resource != "" {
+			missing = append(missing, fmt.Sprintf("%s on %s", action, resource))
+		} else if !allowed {
+			missing = append(missing, action)
+		}
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return fmt.Sprintf("The policies of the import role %q don't grant %s. Unless the bucket "+
+			"policy grants them, attach a policy granting them, as described in %s", roleName,
+			strings.Join(missing, ", "), importRoleDocs), nil
+	}
+	return "", nil
+}
+
+// rolePolicies returns the documents of the managed and inline policies of
+// the role roleName.
+func rolePolicies(ctx context.Context, client roleClient, roleName string) ([]*policyDocument, error) {
+	var documents []string
+
+	attached := iam.NewListAttachedRolePoliciesPaginator(client, &iam.ListAttachedRolePoliciesInput{
+		RoleName: aws.String(roleName),
+	})
+	for attached.HasMorePages() {
+		page, err := attached.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range page.AttachedPolicies {
+			policy, err := client.GetPolicy(ctx, &iam.GetPolicyInput{PolicyArn: p.PolicyArn})
+			if err != nil {
+				return nil, err
+			}
+			version, err := client.GetPolicyVersion(ctx, &iam.GetPolicyVersionInput{
+				PolicyArn: p.PolicyArn,
+				VersionId: policy.Policy.DefaultVersionId,
+			})
+			if err != nil {
+				return nil, err
+			}
+			documents = append(documents, aws.ToString(version.PolicyVersion.Document))
+		}
+	}
+
+	inline := iam.NewListRolePoliciesPaginator(client, &iam.ListRolePoliciesInput{
+		RoleName: aws.String(roleName),
+	})
+	for inline.HasMorePages() {
+		page, err := inline.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range page.PolicyNames {
+			policy, err := client.GetRolePolicy(ctx, &iam.GetRolePolicyInput{
+				RoleName:   aws.String(roleName),
+				PolicyName: aws.String(name),
+			})
+			if err != nil {
+				return nil, err
+			}
+			documents = append(documents, aws.ToString(policy.PolicyDocument))
+		}
+	}
+
+	policies := make([]*policyDocument, 0, len(documents))
+	for _, document := range documents {
+		policy, err := parsePolicyDocument(document)
+		if err != nil {
+			return nil, err
+		}
+		policies = append(policies, policy)
+	}
+	return policies, nil
+}
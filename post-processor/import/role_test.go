@@ -0,0 +1,131 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package amazonimport
+
+import (
+	"context"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	iamtypes "github.com/aws/aws-sdk-go-v2/service/iam/types"
+)
+
+const vmimportTrustPolicy = `{
+	"Version": "2012-10-17",
+	"Statement": [{
+		"Effect": "Allow",
+		"Principal": {"Service": "vmie.amazonaws.com"},
+		"Action": "sts:AssumeRole"
+	}]
+}`
+
+const vmimportRolePolicy = `{
+	"Version": "2012-10-17",
+	"Statement": [
+		{
+			"Effect": "Allow",
+			"Action": ["s3:GetBucketLocation", "s3:GetObject", "s3:ListBucket"],
+			"Resource": ["arn:aws:s3:::importbucket", "arn:aws:s3:::importbucket/*"]
+		},
+		{
+			"Effect": "Allow",
+			"Action": ["ec2:ModifySnapshotAttribute", "ec2:CopySnapshot", "ec2:RegisterImage", "ec2:Describe*"],
+			"Resource": "*"
+		}
+	]
+}`
+
+// mockRoleClient serves a single role, with one managed and one inline
+// policy.
+type mockRoleClient struct {
+	role          *iamtypes.Role
+	managedPolicy string
+	inlinePolicy  string
+}
+
+func (m *mockRoleClient) GetRole(ctx context.Context, params *iam.GetRoleInput, optFns ...func(*iam.Options)) (*iam.GetRoleOutput, error) {
+	if m.role == nil || aws.ToString(params.RoleName) != aws.ToString(m.role.RoleName) {
+		return nil, &iamtypes.NoSuchEntityException{Message: aws.String("The role cannot be found.")}
+	}
+	return &iam.GetRoleOutput{Role: m.role}, nil
+}
+
+func (m *mockRoleClient) ListAttachedRolePolicies(ctx context.Context, params *iam.ListAttachedRolePoliciesInput, optFns ...func(*iam.Options)) (*iam.ListAttachedRolePoliciesOutput, error) {
+	out := &iam.ListAttachedRolePoliciesOutput{}
+	if m.managedPolicy != "" {
+		out.AttachedPolicies = []iamtypes.AttachedPolicy{{PolicyArn: aws.String("arn:aws:iam::123456789012:policy/vmimport")}}
+	}
+	return out, nil
+}
+
+func (m *mockRoleClient) GetPolicy(ctx context.Context, params *iam.GetPolicyInput, optFns ...func(*iam.Options)) (*iam.GetPolicyOutput, error) {
+	return &iam.GetPolicyOutput{Policy: &iamtypes.Policy{DefaultVersionId: aws.String("v2")}}, nil
+}
+
+func (m *mockRoleClient) GetPolicyVersion(ctx context.Context, params *iam.GetPolicyVersionInput, optFns ...func(*iam.Options)) (*iam.GetPolicyVersionOutput, error) {
+	return &iam.GetPolicyVersionOutput{PolicyVersion: &iamtypes.PolicyVersion{Document: aws.String(url.QueryEscape(m.managedPolicy))}}, nil
+}
+
+func (m *mockRoleClient) ListRolePolicies(ctx context.Context, params *iam.ListRolePoliciesInput, optFns ...func(*iam.Options)) (*iam.ListRolePoliciesOutput, error) {
+	out := &iam.ListRolePoliciesOutput{}
+	if m.inlinePolicy != "" {
+		out.PolicyNames = []string{"vmimport-inline"}
+	}
+	return out, nil
+}
+
+func (m *mockRoleClient) GetRolePolicy(ctx context.Context, params *iam.GetRolePolicyInput, optFns ...func(*iam.Options)) (*iam.GetRolePolicyOutput, error) {
+	return &iam.GetRolePolicyOutput{PolicyDocument: aws.String(url.QueryEscape(m.inlinePolicy))}, nil
+}
+
+func testRoleClient() *mockRoleClient {
+	return &mockRoleClient{
+		role: &iamtypes.Role{
+			RoleName:                 aws.String("vmimport"),
+			Arn:                      aws.String("arn:aws:iam::123456789012:role/vmimport"),
+			AssumeRolePolicyDocument: aws.String(url.QueryEscape(vmimportTrustPolicy)),
+		},
+		managedPolicy: vmimportRolePolicy,
+	}
+}
+
+func TestVerifyImportRole(t *testing.T) {
+	client := testRoleClient()
+	if warning, err := verifyImportRole(context.TODO(), client, "vmimport", "importbucket", "images/web.ova"); err != nil || warning != "" {
+		t.Fatalf("the documented vmimport role should be accepted: %q, %v", warning, err)
+	}
+
+	// The same permissions granted by an inline policy.
+	client.inlinePolicy, client.managedPolicy = client.managedPolicy, ""
+	if warning, err := verifyImportRole(context.TODO(), client, "vmimport", "importbucket", "images/web.ova"); err != nil || warning != "" {
+		t.Fatalf("inline policies should be accounted for: %q, %v", warning, err)
+	}
+
+	// The bucket policy may grant the access to another bucket, so it is
+	// only a warning.
+	warning, err := verifyImportRole(context.TODO(), client, "vmimport", "otherbucket", "web.ova")
+	if err != nil {
+		t.Fatalf("a missing permission should not be an error: %s", err)
+	}
+	if !strings.Contains(warning, "s3:GetObject on arn:aws:s3:::otherbucket/web.ova") {
+		t.Fatalf("the missing S3 permission should be reported, got %q", warning)
+	}
+
+	// Disks read through presigned URLs need no access to the bucket.
+	if warning, err := verifyImportRole(context.TODO(), client, "vmimport", "", "web.ova"); err != nil || warning != "" {
+		t.Fatalf("the bucket should not be checked without one: %q, %v", warning, err)
+	}
+
+	if _, err := verifyImportRole(context.TODO(), client, "import-role", "importbucket", "web.ova"); err == nil || !strings.Contains(err.Error(), "doesn't exist") {
+		t.Fatalf("a missing role should be reported, got %v", err)
+	}
+
+	client.role.AssumeRolePolicyDocument = aws.String(url.QueryEscape(strings.Replace(vmimportTrustPolicy, "vmie", "ec2", 1)))
+	if _, err := verifyImportRole(context.TODO(), client, "vmimport", "importbucket", "web.ova"); err == nil || !strings.Contains(err.Error(), "trust policy") {
+		t.Fatalf("a role VM Import can't assume should be reported, got %v", err)
+	}
+}
@@ -0,0 +1,343 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package amazonimport
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"log"
+	"os"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
+)
+
+// sha256MetadataKey is the user metadata of the uploaded image holding its
+// SHA256 checksum, as used by skip_upload_if_exists.
+const sha256MetadataKey = "sha256"
+
+// headObjectClient is the part of the S3 API used to look for a previous
+// upload of the image.
+type headObjectClient interface {
+	HeadObject(ctx context.Context, params *s3.HeadObjectInput, optFns ...func(*s3.Options)) (*s3.HeadObjectOutput, error)
+}
+
+// fileSHA256 returns the hex encoded SHA256 checksum of file, and rewinds it
+// so that it can be uploaded.
+func fileSHA256(file *os.File) (string, error) {
+	return fileChecksum(file, sha256.New())
+}
+
+// fileChecksum returns the hex encoded checksum of file computed with h, and
+// rewinds it.
+func fileChecksum(file *os.File, h hash.Hash) (string, error) {
+	if _, err := io.Copy(h, file); err != nil {
+		return "", fmt.Errorf("Failed to checksum %s: %s", file.Name(), err)
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return "", fmt.Errorf("Failed to rewind %s: %s", file.Name(), err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// uploadExists reports whether the object input would create already exists
+// with the same checksum in its metadata.
+func uploadExists(ctx context.Context, client headObjectClient, input *s3.PutObjectInput) (bool, error) {
+	resp, err := client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: input.Bucket,
+		Key:    input.Key,
+	})
+	var notFound *s3types.NotFound
+	if errors.As(err, &notFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("Failed to look for s3://%s/%s: %s", aws.ToString(input.Bucket), aws.ToString(input.Key), err)
+	}
+
+	sum := input.Metadata[sha256MetadataKey]
+	if resp.Metadata[sha256MetadataKey] != sum {
+		log.Printf("[DEBUG] s3://%s/%s has checksum %q, not %q", aws.ToString(input.Bucket), aws.ToString(input.Key),
+			resp.Metadata[sha256MetadataKey], sum)
+		return false, nil
+	}
+	return true, nil
+}
+
+// multipartUploadClient is the part of the S3 API used by resumable uploads.
+type multipartUploadClient interface {
+	CreateMultipartUpload(ctx context.Context, params *s3.CreateMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.CreateMultipartUploadOutput, error)
+	UploadPart(ctx context.Context, params *s3.UploadPartInput, optFns ...func(*s3.Options)) (*s3.UploadPartOutput, error)
+	ListParts(ctx context.Context, params *s3.ListPartsInput, optFns ...func(*s3.Options)) (*s3.ListPartsOutput, error)
+	CompleteMultipartUpload(ctx context.Context, params *s3.CompleteMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.CompleteMultipartUploadOutput, error)
+	AbortMultipartUpload(ctx context.Context, params *s3.AbortMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.AbortMultipartUploadOutput, error)
+}
+
+// uploadState is what is persisted to the upload state file, it is enough to
+// find the multipart upload of a source file again, and to tell whether the
+// parts already uploaded still match the source.
+type uploadState struct {
+	Bucket   string    `json:"bucket"`
+	Key      string    `json:"key"`
+	UploadId string    `json:"upload_id"`
+	Source   string    `json:"source"`
+	Size     int64     `json:"size"`
+	ModTime  time.Time `json:"mod_time"`
+	PartSize int64     `json:"part_size"`
+	// The SHA256 checksums of the uploaded parts, by part number.
+	Parts map[int32]string `json:"parts"`
+}
+
+// resumableUpload uploads a file to S3 with the multipart API, keeping the
+// upload ID in a local state file. If the upload fails, the parts that made it
+// to S3 are kept and the next upload of the same file only sends the others.
+type resumableUpload struct {
+	client    multipartUploadClient
+	ui        packersdk.Ui
+	stateFile string
+
+	// partSize overrides the part size of new uploads, it defaults to the
+	// default part size of the uploader. Either is raised to the smallest
+	// size that fits the file in the maximum number of parts if needed.
+	partSize int64
+}
+
+// Upload uploads file to the bucket and key of input, or resumes a previous
+// upload of the same file. It returns the key the file was uploaded to, which
+// is the one of the resumed upload if there was one.
+func (u *resumableUpload) Upload(ctx context.Context, file *os.File, input *s3.PutObjectInput) (string, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return "", fmt.Errorf("Failed to stat %s: %s", file.Name(), err)
+	}
+
+	state, completed, err := u.resume(ctx, file.Name(), info, aws.ToString(input.Bucket))
+	if err != nil {
+		return "", err
+	}
+	if state == nil {
+		state, err = u.create(ctx, file.Name(), info, input)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	var parts []s3types.CompletedPart
+	for partNumber, offset := int32(1), int64(0); offset < state.Size || partNumber == 1; partNumber, offset = partNumber+1, offset+state.PartSize {
+		length := state.PartSize
+		if offset+length > state.Size {
+			length = state.Size - offset
+		}
+		sum, err := partChecksum(file, offset, length)
+		if err != nil {
+			return "", err
+		}
+
+		// Parts are only reused if they were uploaded from the same data.
+		if etag, ok := completed[partNumber]; ok && state.Parts[partNumber] == sum {
+			parts = append(parts, s3types.CompletedPart{ETag: aws.String(etag), PartNumber: aws.Int32(partNumber)})
+			continue
+		}
+
+		resp, err := u.client.UploadPart(ctx, &s3.UploadPartInput{
+			Bucket:        aws.String(state.Bucket),
+			Key:           aws.String(state.Key),
+			UploadId:      aws.String(state.UploadId),
+			PartNumber:    aws.Int32(partNumber),
+			Body:          io.NewSectionReader(file, offset, length),
+			ContentLength: aws.Int64(length),
+		})
+		if err != nil {
+			return "", fmt.Errorf("Failed to upload part %d of %s, the upload can be resumed with the state in %s: %s",
+				partNumber, file.Name(), u.stateFile, err)
+		}
+		parts = append(parts, s3types.CompletedPart{ETag: resp.ETag, PartNumber: aws.Int32(partNumber)})
+		state.Parts[partNumber] = sum
+		if err := u.save(state); err != nil {
+			return "", err
+		}
+	}
+
+	_, err = u.client.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(state.Bucket),
+		Key:             aws.String(state.Key),
+		UploadId:        aws.String(state.UploadId),
+		MultipartUpload: &s3types.CompletedMultipartUpload{Parts: parts},
+	})
+	if err != nil {
+		return "", fmt.Errorf("Failed to complete upload of %s: %s", file.Name(), err)
+	}
+
+	if err := os.Remove(u.stateFile); err != nil {
+		log.Printf("[WARN] Failed to remove upload state file %s: %s", u.stateFile, err)
+	}
+	return state.Key, nil
+}
+
+// resume looks for a previous upload of source in the state file. It returns
+// nil if there is none, or the upload state and the ETags of its parts that
+// are already in S3 by part number. A previous upload of a source that
+// changed since is aborted.
+func (u *resumableUpload) resume(ctx context.Context, source string, info os.FileInfo, bucket string) (*uploadState, map[int32]string, error) {
+	contents, err := os.ReadFile(u.stateFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("Failed to read upload state file %s: %s", u.stateFile, err)
+	}
+
+	state := new(uploadState)
+	if err := json.Unmarshal(contents, state); err != nil {
+		return nil, nil, fmt.Errorf("Failed to parse upload state file %s: %s", u.stateFile, err)
+	}
+	if state.Source != source || state.Size != info.Size() || !state.ModTime.Equal(info.ModTime()) || state.Bucket != bucket {
+		log.Printf("[DEBUG] Upload state file %s is for another upload, starting a new one", u.stateFile)
+		u.abort(ctx, state)
+		return nil, nil, nil
+	}
+	if state.Parts == nil {
+		state.Parts = make(map[int32]string)
+	}
+
+	completed := make(map[int32]string)
+	params := &s3.ListPartsInput{
+		Bucket:   aws.String(state.Bucket),
+		Key:      aws.String(state.Key),
+		UploadId: aws.String(state.UploadId),
+	}
+	for {
+		resp, err := u.client.ListParts(ctx, params)
+		var noSuchUpload *s3types.NoSuchUpload
+		if errors.As(err, &noSuchUpload) {
+			u.ui.Say(fmt.Sprintf("Upload %s no longer exists, starting a new one", state.UploadId))
+			return nil, nil, nil
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("Failed to list the uploaded parts of %s: %s", source, err)
+		}
+
+		for _, part := range resp.Parts {
+			completed[aws.ToInt32(part.PartNumber)] = aws.ToString(part.ETag)
+		}
+		if !aws.ToBool(resp.IsTruncated) {
+			break
+		}
+		params.PartNumberMarker = resp.NextPartNumberMarker
+	}
+
+	u.ui.Say(fmt.Sprintf("Resuming upload of %s to s3://%s/%s, %d parts already uploaded",
+		source, state.Bucket, state.Key, len(completed)))
+	return state, completed, nil
+}
+
+// create starts a new multipart upload and saves it to the state file.
+func (u *resumableUpload) create(ctx context.Context, source string, info os.FileInfo, input *s3.PutObjectInput) (*uploadState, error) {
+	resp, err := u.client.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{
+		Bucket:               input.Bucket,
+		Key:                  input.Key,
+		ServerSideEncryption: input.ServerSideEncryption,
+		SSEKMSKeyId:          input.SSEKMSKeyId,
+		Metadata:             input.Metadata,
+		Tagging:              input.Tagging,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Failed to start upload of %s: %s", source, err)
+	}
+
+	size := info.Size()
+	partSize := u.partSize
+	if partSize == 0 {
+		partSize = manager.DefaultUploadPartSize
+	}
+	if size/partSize >= int64(manager.MaxUploadParts) {
+		partSize = size/int64(manager.MaxUploadParts) + 1
+	}
+
+	state := &uploadState{
+		Bucket:   aws.ToString(input.Bucket),
+		Key:      aws.ToString(input.Key),
+		UploadId: aws.ToString(resp.UploadId),
+		Source:   source,
+		Size:     size,
+		ModTime:  info.ModTime(),
+		PartSize: partSize,
+		Parts:    make(map[int32]string),
+	}
+	if err := u.save(state); err != nil {
+		return nil, err
+	}
+
+	log.Printf("[DEBUG] Started upload %s of %s in parts of %d bytes", state.UploadId, source, partSize)
+	return state, nil
+}
+
+// save writes state to the state file.
+func (u *resumableUpload) save(state *uploadState) error {
+	contents, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(u.stateFile, contents, 0600); err != nil {
+		return fmt.Errorf("Failed to write upload state file %s: %s", u.stateFile, err)
+	}
+	return nil
+}
+
+// abort aborts the multipart upload of state, so that its parts aren't
+// billed for. Failing to do so is only logged, S3 lifecycle rules can still
+// clean it up.
+func (u *resumableUpload) abort(ctx context.Context, state *uploadState) {
+	_, err := u.client.AbortMultipartUpload(ctx, &s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(state.Bucket),
+		Key:      aws.String(state.Key),
+		UploadId: aws.String(state.UploadId),
+	})
+	var noSuchUpload *s3types.NoSuchUpload
+	if err != nil && !errors.As(err, &noSuchUpload) {
+		log.Printf("[WARN] Failed to abort upload %s of s3://%s/%s: %s", state.UploadId, state.Bucket, state.Key, err)
+		return
+	}
+	log.Printf("[DEBUG] Aborted upload %s of s3://%s/%s", state.UploadId, state.Bucket, state.Key)
+}
+
+// partChecksum returns the hex encoded SHA256 checksum of the length bytes
+// of file at offset.
+func partChecksum(file *os.File, offset, length int64) (string, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, io.NewSectionReader(file, offset, length)); err != nil {
+		return "", fmt.Errorf("Failed to checksum %s: %s", file.Name(), err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// objectVersions returns the version IDs of the objects keys of bucket,
+// aligned with keys. They are empty if the bucket isn't versioned, or if the
+// object couldn't be read, which is only logged as the import already
+// succeeded.
+func objectVersions(ctx context.Context, client headObjectClient, bucket string, keys []string) []string {
+	versions := make([]string, len(keys))
+	for i, key := range keys {
+		resp, err := client.HeadObject(ctx, &s3.HeadObjectInput{
+			Bucket: aws.String(bucket),
+			Key:    aws.String(key),
+		})
+		if err != nil {
+			log.Printf("[WARN] Failed to read the version of s3://%s/%s: %s", bucket, key, err)
+			continue
+		}
+		versions[i] = aws.ToString(resp.VersionId)
+	}
+	return versions
+}
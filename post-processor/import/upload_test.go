@@ -0,0 +1,310 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package amazonimport
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
+)
+
+// mockMultipartClient keeps the parts of a single multipart upload in memory.
+type mockMultipartClient struct {
+	parts    map[int32][]byte
+	uploaded []int32
+	creates  int
+	aborts   int
+	failPart int32
+	object   []byte
+	metadata map[string]string
+}
+
+func (m *mockMultipartClient) CreateMultipartUpload(ctx context.Context, params *s3.CreateMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.CreateMultipartUploadOutput, error) {
+	m.creates++
+	m.parts = make(map[int32][]byte)
+	m.metadata = params.Metadata
+	return &s3.CreateMultipartUploadOutput{UploadId: aws.String("upload-1")}, nil
+}
+
+func (m *mockMultipartClient) UploadPart(ctx context.Context, params *s3.UploadPartInput, optFns ...func(*s3.Options)) (*s3.UploadPartOutput, error) {
+	partNumber := aws.ToInt32(params.PartNumber)
+	if partNumber == m.failPart {
+		return nil, fmt.Errorf("connection reset by peer")
+	}
+	body, err := io.ReadAll(params.Body)
+	if err != nil {
+		return nil, err
+	}
+	m.parts[partNumber] = body
+	m.uploaded = append(m.uploaded, partNumber)
+	return &s3.UploadPartOutput{ETag: aws.String(fmt.Sprintf("etag-%d", partNumber))}, nil
+}
+
+func (m *mockMultipartClient) ListParts(ctx context.Context, params *s3.ListPartsInput, optFns ...func(*s3.Options)) (*s3.ListPartsOutput, error) {
+	if m.parts == nil {
+		return nil, &s3types.NoSuchUpload{}
+	}
+	out := &s3.ListPartsOutput{}
+	for partNumber := range m.parts {
+		out.Parts = append(out.Parts, s3types.Part{
+			PartNumber: aws.Int32(partNumber),
+			ETag:       aws.String(fmt.Sprintf("etag-%d", partNumber)),
+		})
+	}
+	return out, nil
+}
+
+func (m *mockMultipartClient) AbortMultipartUpload(ctx context.Context, params *s3.AbortMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.AbortMultipartUploadOutput, error) {
+	if m.parts == nil {
+		return nil, &s3types.NoSuchUpload{}
+	}
+	m.aborts++
+	m.parts = nil
+	return &s3.AbortMultipartUploadOutput{}, nil
+}
+
+func (m *mockMultipartClient) HeadObject(ctx context.Context, params *s3.HeadObjectInput, optFns ...func(*s3.Options)) (*s3.HeadObjectOutput, error) {
+	if m.object == nil {
+		return nil, &s3types.NotFound{}
+	}
+	return &s3.HeadObjectOutput{Metadata: m.metadata}, nil
+}
+
+func (m *mockMultipartClient) CompleteMultipartUpload(ctx context.Context, params *s3.CompleteMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.CompleteMultipartUploadOutput, error) {
+	m.object = nil
+	for i, part := range params.MultipartUpload.Parts {
+		partNumber := aws.ToInt32(part.PartNumber)
+		if partNumber != int32(i+1) || aws.ToString(part.ETag) != fmt.Sprintf("etag-%d", partNumber) {
+			return nil, fmt.Errorf("unexpected part %d with ETag %s", partNumber, aws.ToString(part.ETag))
+		}
+		m.object = append(m.object, m.parts[partNumber]...)
+	}
+	return &s3.CompleteMultipartUploadOutput{}, nil
+}
+
+func TestResumableUpload_ResumesFromLastCompletedPart(t *testing.T) {
+	dir := t.TempDir()
+	contents := []byte("0123456789")
+	source := filepath.Join(dir, "image.ova")
+	if err := os.WriteFile(source, contents, 0644); err != nil {
+		t.Fatalf("failed to write source: %s", err)
+	}
+	file, err := os.Open(source)
+	if err != nil {
+		t.Fatalf("failed to open source: %s", err)
+	}
+	defer file.Close()
+
+	client := &mockMultipartClient{failPart: 3}
+	stateFile := filepath.Join(dir, "upload.json")
+	input := &s3.PutObjectInput{
+		Bucket: aws.String("importbucket"),
+		Key:    aws.String("packer-import-1.ova"),
+	}
+
+	upload := &resumableUpload{client: client, ui: packersdk.TestUi(t), stateFile: stateFile, partSize: 3}
+	if _, err := upload.Upload(context.Background(), file, input); err == nil {
+		t.Fatalf("the upload should fail on part 3")
+	}
+	if _, err := os.Stat(stateFile); err != nil {
+		t.Fatalf("the upload state should be kept after a failure: %s", err)
+	}
+
+	// The retry renders a new key, but the upload continues where it was.
+	client.failPart = 0
+	client.uploaded = nil
+	input.Key = aws.String("packer-import-2.ova")
+	upload = &resumableUpload{client: client, ui: packersdk.TestUi(t), stateFile: stateFile}
+	key, err := upload.Upload(context.Background(), file, input)
+	if err != nil {
+		t.Fatalf("should not have error: %s", err)
+	}
+
+	if key != "packer-import-1.ova" {
+		t.Fatalf("expected the key of the resumed upload, got %q", key)
+	}
+	if client.creates != 1 {
+		t.Fatalf("expected a single multipart upload, got %d", client.creates)
+	}
+	if fmt.Sprint(client.uploaded) != "[3 4]" {
+		t.Fatalf("expected only parts 3 and 4 to be uploaded again, got %v", client.uploaded)
+	}
+	if !bytes.Equal(client.object, contents) {
+		t.Fatalf("expected object %q, got %q", contents, client.object)
+	}
+	if _, err := os.Stat(stateFile); !os.IsNotExist(err) {
+		t.Fatalf("the upload state should be removed once the upload completes")
+	}
+}
+
+func TestResumableUpload_ChangedSource(t *testing.T) {
+	dir := t.TempDir()
+	source := filepath.Join(dir, "image.ova")
+	stateFile := filepath.Join(dir, "upload.json")
+	input := &s3.PutObjectInput{
+		Bucket: aws.String("importbucket"),
+		Key:    aws.String("packer-import.ova"),
+	}
+	modTime := time.Now().Add(-time.Hour)
+
+	// failedUpload writes contents to source and fails to upload its third
+	// part.
+	failedUpload := func(client *mockMultipartClient, contents string) {
+		if err := os.WriteFile(source, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(source, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+		file, err := os.Open(source)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer file.Close()
+		client.failPart = 3
+		upload := &resumableUpload{client: client, ui: packersdk.TestUi(t), stateFile: stateFile, partSize: 3}
+		if _, err := upload.Upload(context.Background(), file, input); err == nil {
+			t.Fatalf("the upload should fail on part 3")
+		}
+		client.failPart = 0
+		client.uploaded = nil
+	}
+	resume := func(client *mockMultipartClient, contents string, modTime time.Time) {
+		if err := os.WriteFile(source, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(source, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+		file, err := os.Open(source)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer file.Close()
+		upload := &resumableUpload{client: client, ui: packersdk.TestUi(t), stateFile: stateFile}
+		if _, err := upload.Upload(context.Background(), file, input); err != nil {
+			t.Fatalf("should not have error: %s", err)
+		}
+		if string(client.object) != contents {
+			t.Fatalf("expected object %q, got %q", contents, client.object)
+		}
+	}
+
+	// A part that changed without its modification time is uploaded again.
+	client := &mockMultipartClient{}
+	failedUpload(client, "0123456789")
+	resume(client, "abc3456789", modTime)
+	if client.creates != 1 || client.aborts != 0 {
+		t.Fatalf("expected the upload to be resumed, got %d uploads and %d aborts", client.creates, client.aborts)
+	}
+	if fmt.Sprint(client.uploaded) != "[1 3 4]" {
+		t.Fatalf("expected the changed part 1 to be uploaded again, got %v", client.uploaded)
+	}
+
+	// A source modified since is uploaded from scratch, and the previous
+	// upload aborted.
+	client = &mockMultipartClient{}
+	failedUpload(client, "0123456789")
+	resume(client, "0123456789", time.Now())
+	if client.creates != 2 || client.aborts != 1 {
+		t.Fatalf("expected a new upload and the previous one aborted, got %d uploads and %d aborts", client.creates, client.aborts)
+	}
+	if fmt.Sprint(client.uploaded) != "[1]" {
+		t.Fatalf("expected all the parts of the new upload to be uploaded, got %v", client.uploaded)
+	}
+}
+
+func TestUploadExists_SkipsMatchingObject(t *testing.T) {
+	dir := t.TempDir()
+	source := filepath.Join(dir, "disk.raw")
+	if err := os.WriteFile(source, bytes.Repeat([]byte("packer"), 10), 0644); err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.Open(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	sum, err := fileSHA256(file)
+	if err != nil {
+		t.Fatalf("should not have error: %s", err)
+	}
+	input := &s3.PutObjectInput{
+		Bucket:   aws.String("bucket"),
+		Key:      aws.String("disk.raw"),
+		Metadata: map[string]string{sha256MetadataKey: sum},
+	}
+
+	client := &mockMultipartClient{}
+	upload := func() {
+		exists, err := uploadExists(context.TODO(), client, input)
+		if err != nil {
+			t.Fatalf("should not have error: %s", err)
+		}
+		if exists {
+			return
+		}
+		u := &resumableUpload{client: client, ui: packersdk.TestUi(t), stateFile: filepath.Join(dir, "state.json")}
+		if _, err := u.Upload(context.TODO(), file, input); err != nil {
+			t.Fatalf("should not have error: %s", err)
+		}
+	}
+
+	upload()
+	if client.creates != 1 {
+		t.Fatalf("the image should have been uploaded once, got %d uploads", client.creates)
+	}
+	if client.metadata[sha256MetadataKey] != sum {
+		t.Fatalf("the checksum should be stored with the object, got %v", client.metadata)
+	}
+
+	upload()
+	if client.creates != 1 {
+		t.Fatalf("the upload of an identical image should be skipped, got %d uploads", client.creates)
+	}
+
+	input.Metadata = map[string]string{sha256MetadataKey: "changed"}
+	upload()
+	if client.creates != 2 {
+		t.Fatalf("a changed image should be uploaded again, got %d uploads", client.creates)
+	}
+}
+
+// versionedClient serves the version IDs of objects of a versioned bucket.
+type versionedClient struct {
+	versions map[string]string
+}
+
+func (m *versionedClient) HeadObject(ctx context.Context, params *s3.HeadObjectInput, optFns ...func(*s3.Options)) (*s3.HeadObjectOutput, error) {
+	version, ok := m.versions[aws.ToString(params.Key)]
+	if !ok {
+		return nil, &s3types.NotFound{}
+	}
+	return &s3.HeadObjectOutput{VersionId: aws.String(version)}, nil
+}
+
+func TestObjectVersions(t *testing.T) {
+	client := &versionedClient{versions: map[string]string{
+		"disk-0.vmdk": "v-boot",
+		"disk-1.vmdk": "v-data",
+	}}
+
+	versions := objectVersions(context.TODO(), client, "bucket", []string{"disk-0.vmdk", "disk-1.vmdk", "gone.vmdk"})
+	expected := []string{"v-boot", "v-data", ""}
+	if !reflect.DeepEqual(versions, expected) {
+		t.Fatalf("expected versions %v, got %v", expected, versions)
+	}
+}
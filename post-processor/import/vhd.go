@@ -0,0 +1,156 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package amazonimport
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+)
+
+// Subtypes of VHD and VHDX disks.
+const (
+	vhdFixed        = "fixed"
+	vhdDynamic      = "dynamic"
+	vhdDifferencing = "differencing"
+)
+
+// The size of the footer of a VHD, at the end of the file.
+const vhdFooterSize = 512
+
+// Offsets of the structures of a VHDX, see the VHDX format specification.
+const (
+	vhdxRegionTableOffset     = 192 * 1024
+	vhdxRegionTableHeaderSize = 16
+	vhdxMetadataHeaderSize    = 32
+	vhdxTableEntrySize        = 32
+	vhdxMaxTableEntries       = 2047
+)
+
+var (
+	// VHDX GUIDs, in their on-disk byte order.
+	vhdxMetadataRegion = []byte{0x06, 0xa2, 0x7c, 0x8b, 0x90, 0x47, 0x9a, 0x4b, 0xb8, 0xfe, 0x57, 0x5f, 0x05, 0x0f, 0x88, 0x6e}
+	vhdxFileParameters = []byte{0x37, 0x67, 0xa1, 0xca, 0x36, 0xfa, 0x43, 0x4d, 0xb3, 0xb6, 0x33, 0xf0, 0xaa, 0x44, 0xe7, 0x6b}
+)
+
+// diskSubtype returns whether the VHD or VHDX disk at path is fixed, dynamic
+// or differencing, from its headers.
+func diskSubtype(path, format string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	if format == "vhdx" {
+		return vhdxSubtype(f)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+	return vhdSubtype(f, info.Size())
+}
+
+// vhdSubtype reads the disk type of the footer of a VHD of size bytes.
+func vhdSubtype(r io.ReaderAt, size int64) (string, error) {
+	if size < vhdFooterSize {
+		return "", fmt.Errorf("too small to be a VHD")
+	}
+	footer := make([]byte, vhdFooterSize)
+	if _, err := r.ReadAt(footer, size-vhdFooterSize); err != nil {
+		return "", err
+	}
+	if string(footer[:8]) != "conectix" {
+		return "", fmt.Errorf("no VHD footer found")
+	}
+
+	switch diskType := binary.BigEndian.Uint32(footer[60:64]); diskType {
+	case 2:
+		return vhdFixed, nil
+	case 3:
+		return vhdDynamic, nil
+	case 4:
+		return vhdDifferencing, nil
+	default:
+		return "", fmt.Errorf("unknown VHD disk type %d", diskType)
+	}
+}
+
+// vhdxSubtype reads the file parameters in the metadata region of a VHDX.
+func vhdxSubtype(r io.ReaderAt) (string, error) {
+	signature := make([]byte, 8)
+	if _, err := r.ReadAt(signature, 0); err != nil {
+		return "", err
+	}
+	if string(signature) != "vhdxfile" {
+		return "", fmt.Errorf("no VHDX signature found")
+	}
+
+	regionOffset, err := vhdxTableEntry(r, vhdxRegionTableOffset, "regi", vhdxMetadataRegion)
+	if err != nil {
+		return "", fmt.Errorf("reading region table: %s", err)
+	}
+	paramsOffset, err := vhdxTableEntry(r, int64(regionOffset), "metadata", vhdxFileParameters)
+	if err != nil {
+		return "", fmt.Errorf("reading metadata table: %s", err)
+	}
+
+	// The file parameters are the block size, then the flags.
+	params := make([]byte, 8)
+	if _, err := r.ReadAt(params, int64(regionOffset)+int64(paramsOffset)); err != nil {
+		return "", err
+	}
+	flags := binary.LittleEndian.Uint32(params[4:8])
+	switch {
+	case flags&0x2 != 0:
+		return vhdDifferencing, nil
+	case flags&0x1 != 0:
+		return vhdFixed, nil
+	default:
+		return vhdDynamic, nil
+	}
+}
+
+// vhdxTableEntry finds the entry for id in the VHDX region or metadata table
+// at offset, and returns the offset of the item it describes. The two tables
+// share their layout, save for the signature and the width of their fields.
+func vhdxTableEntry(r io.ReaderAt, offset int64, signature string, id []byte) (uint64, error) {
+	header := make([]byte, vhdxMetadataHeaderSize)
+	if _, err := r.ReadAt(header, offset); err != nil {
+		return 0, err
+	}
+	if string(header[:len(signature)]) != signature {
+		return 0, fmt.Errorf("signature %q not found", signature)
+	}
+
+	headerSize := int64(vhdxMetadataHeaderSize)
+	var count uint32
+	if signature == "regi" {
+		headerSize = vhdxRegionTableHeaderSize
+		count = binary.LittleEndian.Uint32(header[8:12])
+	} else {
+		count = uint32(binary.LittleEndian.Uint16(header[10:12]))
+	}
+	if count > vhdxMaxTableEntries {
+		return 0, fmt.Errorf("invalid entry count %d", count)
+	}
+
+	entry := make([]byte, vhdxTableEntrySize)
+	for i := uint32(0); i < count; i++ {
+		if _, err := r.ReadAt(entry, offset+headerSize+int64(i)*vhdxTableEntrySize); err != nil {
+			return 0, err
+		}
+		if !bytes.Equal(entry[:16], id) {
+			continue
+		}
+		if signature == "regi" {
+			return binary.LittleEndian.Uint64(entry[16:24]), nil
+		}
+		return uint64(binary.LittleEndian.Uint32(entry[16:20])), nil
+	}
+	return 0, fmt.Errorf("entry not found")
+}
@@ -0,0 +1,119 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package amazonimport
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
+)
+
+// testVHD returns a VHD of the given disk type, with 1KB of data.
+func testVHD(diskType uint32) []byte {
+	footer := make([]byte, vhdFooterSize)
+	copy(footer, "conectix")
+	binary.BigEndian.PutUint32(footer[60:64], diskType)
+	return append(make([]byte, 1024), footer...)
+}
+
+// testVHDX returns the headers of a VHDX with the given file parameter
+// flags.
+func testVHDX(flags uint32) []byte {
+	const (
+		metadataOffset = 256 * 1024
+		paramsOffset   = 64 * 1024
+	)
+	disk := make([]byte, metadataOffset+paramsOffset+8)
+	copy(disk, "vhdxfile")
+
+	// A region table with the BAT first, then the metadata region.
+	regions := disk[vhdxRegionTableOffset:]
+	copy(regions, "regi")
+	binary.LittleEndian.PutUint32(regions[8:12], 2)
+	bat := regions[vhdxRegionTableHeaderSize:]
+	copy(bat, bytes.Repeat([]byte{0xff}, 16))
+	binary.LittleEndian.PutUint64(bat[16:24], 1024*1024)
+	metadata := regions[vhdxRegionTableHeaderSize+vhdxTableEntrySize:]
+	copy(metadata, vhdxMetadataRegion)
+	binary.LittleEndian.PutUint64(metadata[16:24], metadataOffset)
+
+	table := disk[metadataOffset:]
+	copy(table, "metadata")
+	binary.LittleEndian.PutUint16(table[10:12], 1)
+	params := table[vhdxMetadataHeaderSize:]
+	copy(params, vhdxFileParameters)
+	binary.LittleEndian.PutUint32(params[16:20], paramsOffset)
+	binary.LittleEndian.PutUint32(params[20:24], 8)
+
+	binary.LittleEndian.PutUint32(disk[metadataOffset+paramsOffset:], 32*1024*1024)
+	binary.LittleEndian.PutUint32(disk[metadataOffset+paramsOffset+4:], flags)
+	return disk
+}
+
+func TestDiskSubtype(t *testing.T) {
+	tests := []struct {
+		name     string
+		format   string
+		contents []byte
+		expected string
+	}{
+		{"fixed VHD", "vhd", testVHD(2), vhdFixed},
+		{"dynamic VHD", "vhd", testVHD(3), vhdDynamic},
+		{"differencing VHD", "vhd", testVHD(4), vhdDifferencing},
+		{"fixed VHDX", "vhdx", testVHDX(0x1), vhdFixed},
+		{"dynamic VHDX", "vhdx", testVHDX(0), vhdDynamic},
+		{"differencing VHDX", "vhdx", testVHDX(0x2), vhdDifferencing},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "disk."+tt.format)
+			if err := os.WriteFile(path, tt.contents, 0644); err != nil {
+				t.Fatal(err)
+			}
+			subtype, err := diskSubtype(path, tt.format)
+			if err != nil {
+				t.Fatalf("should not have error: %s", err)
+			}
+			if subtype != tt.expected {
+				t.Fatalf("expected a %s disk, got %s", tt.expected, subtype)
+			}
+		})
+	}
+
+	path := filepath.Join(t.TempDir(), "disk.vhd")
+	if err := os.WriteFile(path, make([]byte, 2048), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := diskSubtype(path, "vhd"); err == nil {
+		t.Fatal("a file without VHD footer should error")
+	}
+}
+
+func TestWarnDiskSubtype(t *testing.T) {
+	dir := t.TempDir()
+	fixed := filepath.Join(dir, "fixed.vhd")
+	dynamic := filepath.Join(dir, "dynamic.vhd")
+	if err := os.WriteFile(fixed, testVHD(2), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(dynamic, testVHD(3), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	ui := &packersdk.BasicUi{Reader: new(bytes.Buffer), Writer: &out, ErrorWriter: &out}
+	warnDiskSubtype(ui, fixed, "vhd")
+	if out.Len() != 0 {
+		t.Fatalf("a fixed disk shouldn't be warned about, got %q", out.String())
+	}
+	warnDiskSubtype(ui, dynamic, "vhd")
+	if !strings.Contains(out.String(), "dynamic vhd disk") || !strings.Contains(out.String(), "subformat=fixed") {
+		t.Fatalf("a dynamic disk should be warned about, got %q", out.String())
+	}
+}
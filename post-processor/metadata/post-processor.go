@@ -0,0 +1,205 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+//go:generate packer-sdc mapstructure-to-hcl2 -type Config
+
+package amazonmetadata
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/hashicorp/hcl/v2/hcldec"
+	awscommon "github.com/hashicorp/packer-plugin-amazon/common"
+	"github.com/hashicorp/packer-plugin-sdk/common"
+	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
+	"github.com/hashicorp/packer-plugin-sdk/template/config"
+	"github.com/hashicorp/packer-plugin-sdk/template/interpolate"
+)
+
+const BuilderId = "packer.post-processor.amazon-metadata"
+
+// Configuration of this post processor
+type Config struct {
+	common.PackerConfig    `mapstructure:",squash"`
+	awscommon.AccessConfig `mapstructure:",squash"`
+
+	// Variables specific to this post processor
+	S3Bucket        string            `mapstructure:"s3_bucket_name"`
+	S3Key           string            `mapstructure:"s3_key_name"`
+	S3Encryption    string            `mapstructure:"s3_encryption"`
+	S3EncryptionKey string            `mapstructure:"s3_encryption_key"`
+	Tags            map[string]string `mapstructure:"tags"`
+	GitCommit       string            `mapstructure:"git_commit"`
+	CustomData      map[string]string `mapstructure:"custom_data"`
+
+	ctx interpolate.Context
+}
+
+// buildMetadata is the document uploaded to S3.
+type buildMetadata struct {
+	BuildName   string            `json:"build_name"`
+	BuildUUID   string            `json:"build_uuid"`
+	BuilderType string            `json:"builder_type"`
+	BuildTime   int64             `json:"build_time"`
+	ArtifactId  string            `json:"artifact_id"`
+	SourceAMI   string            `json:"source_ami,omitempty"`
+	AMIs        map[string]string `json:"amis,omitempty"`
+	GitCommit   string            `json:"git_commit,omitempty"`
+	CustomData  map[string]string `json:"custom_data,omitempty"`
+}
+
+// putObjectClient is the part of the S3 API used to upload the metadata.
+type putObjectClient interface {
+	PutObject(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error)
+}
+
+type PostProcessor struct {
+	config Config
+}
+
+func (p *PostProcessor) ConfigSpec() hcldec.ObjectSpec { return p.config.FlatMapstructure().HCL2Spec() }
+
+func (p *PostProcessor) Configure(raws ...interface{}) error {
+	p.config.ctx.Funcs = awscommon.TemplateFuncs
+	err := config.Decode(&p.config, &config.DecodeOpts{
+		PluginType:         BuilderId,
+		Interpolate:        true,
+		InterpolateContext: &p.config.ctx,
+		InterpolateFilter: &interpolate.RenderFilter{
+			Exclude: []string{
+				"s3_key_name",
+			},
+		},
+	}, raws...)
+	if err != nil {
+		return err
+	}
+
+	// Set defaults
+	if p.config.S3Key == "" {
+		p.config.S3Key = "packer-metadata/{{ build_name }}-{{ timestamp }}.json"
+	}
+
+	if p.config.GitCommit == "" {
+		p.config.GitCommit, err = interpolate.Render("{{ ci_commit }}", &p.config.ctx)
+		if err != nil {
+			return err
+		}
+	}
+
+	errs := new(packersdk.MultiError)
+
+	// Check and render s3_key_name
+	if err = interpolate.Validate(p.config.S3Key, &p.config.ctx); err != nil {
+		errs = packersdk.MultiErrorAppend(
+			errs, fmt.Errorf("Error parsing s3_key_name template: %s", err))
+	}
+
+	// Check we have AWS access variables defined somewhere
+	errs = packersdk.MultiErrorAppend(errs, p.config.AccessConfig.Prepare(&p.config.PackerConfig)...)
+
+	if p.config.S3Bucket == "" {
+		errs = packersdk.MultiErrorAppend(errs, fmt.Errorf("s3_bucket_name must be set"))
+	}
+
+	if p.config.S3Encryption != "" && p.config.S3Encryption != "AES256" && p.config.S3Encryption != "aws:kms" {
+		errs = packersdk.MultiErrorAppend(
+			errs, fmt.Errorf("invalid s3 encryption format '%s'. Only 'AES256' and 'aws:kms' are allowed", p.config.S3Encryption))
+	}
+
+	// Anything which flagged return back up the stack
+	if len(errs.Errors) > 0 {
+		return errs
+	}
+
+	packersdk.LogSecretFilter.Set(p.config.AccessKey, p.config.SecretKey, p.config.Token)
+	log.Println(p.config)
+	return nil
+}
+
+func (p *PostProcessor) PostProcess(ctx context.Context, ui packersdk.Ui, artifact packersdk.Artifact) (packersdk.Artifact, bool, bool, error) {
+	config, err := p.config.Config(ctx)
+	if err != nil {
+		return nil, false, false, err
+	}
+
+	if err := p.uploadMetadata(ctx, s3.NewFromConfig(*config), ui, artifact); err != nil {
+		return nil, false, false, err
+	}
+
+	// The artifact is passed on untouched, it must never be destroyed on
+	// account of this post-processor.
+	return artifact, true, true, nil
+}
+
+// uploadMetadata renders the key of the metadata document of artifact and
+// uploads it to the bucket.
+func (p *PostProcessor) uploadMetadata(ctx context.Context, client putObjectClient, ui packersdk.Ui, artifact packersdk.Artifact) error {
+	generatedData, _ := artifact.State("generated_data").(map[string]interface{})
+	if generatedData == nil {
+		// Make sure it's not a nil map so we can assign to it later.
+		generatedData = make(map[string]interface{})
+	}
+	p.config.ctx.Data = generatedData
+
+	// Render this key since we didn't in the configure phase
+	key, err := interpolate.Render(p.config.S3Key, &p.config.ctx)
+	if err != nil {
+		return fmt.Errorf("Error rendering s3_key_name template: %s", err)
+	}
+
+	metadata := p.buildMetadata(artifact, generatedData)
+	body, err := json.MarshalIndent(metadata, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	input := &s3.PutObjectInput{
+		Body:        bytes.NewReader(body),
+		Bucket:      aws.String(p.config.S3Bucket),
+		Key:         aws.String(key),
+		ContentType: aws.String("application/json"),
+	}
+	if len(p.config.Tags) > 0 {
+		input.Tagging = aws.String(awscommon.S3Tagging(p.config.Tags))
+	}
+	if p.config.S3Encryption != "" {
+		input.ServerSideEncryption = s3types.ServerSideEncryption(p.config.S3Encryption)
+		if p.config.S3Encryption == string(s3types.ServerSideEncryptionAwsKms) && p.config.S3EncryptionKey != "" {
+			input.SSEKMSKeyId = aws.String(p.config.S3EncryptionKey)
+		}
+	}
+
+	ui.Say(fmt.Sprintf("Uploading build metadata to s3://%s/%s", p.config.S3Bucket, key))
+	if _, err := client.PutObject(ctx, input); err != nil {
+		return fmt.Errorf("Failed to upload build metadata to s3://%s/%s: %s", p.config.S3Bucket, key, err)
+	}
+	return nil
+}
+
+func (p *PostProcessor) buildMetadata(artifact packersdk.Artifact, generatedData map[string]interface{}) *buildMetadata {
+	metadata := &buildMetadata{
+		BuildName:   p.config.PackerBuildName,
+		BuilderType: p.config.PackerBuilderType,
+		BuildTime:   time.Now().Unix(),
+		ArtifactId:  artifact.Id(),
+		GitCommit:   p.config.GitCommit,
+		CustomData:  p.config.CustomData,
+	}
+	if uuid, ok := generatedData["PackerRunUUID"].(string); ok {
+		metadata.BuildUUID = uuid
+	}
+	if sourceAMI, ok := generatedData["SourceAMI"].(string); ok {
+		metadata.SourceAMI = sourceAMI
+	}
+	metadata.AMIs = awscommon.ArtifactAMIs(artifact)
+	return metadata
+}
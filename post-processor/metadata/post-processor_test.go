@@ -0,0 +1,118 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package amazonmetadata
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	awscommon "github.com/hashicorp/packer-plugin-amazon/common"
+	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
+)
+
+type mockS3Client struct {
+	putObjectInputs []*s3.PutObjectInput
+	bodies          [][]byte
+}
+
+func (m *mockS3Client) PutObject(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+	body, err := io.ReadAll(params.Body)
+	if err != nil {
+		return nil, err
+	}
+	m.putObjectInputs = append(m.putObjectInputs, params)
+	m.bodies = append(m.bodies, body)
+	return &s3.PutObjectOutput{}, nil
+}
+
+func testMetadataConfig() map[string]interface{} {
+	return map[string]interface{}{
+		"access_key":          "foo",
+		"secret_key":          "bar",
+		"region":              "us-east-1",
+		"s3_bucket_name":      "audit",
+		"s3_key_name":         "builds/{{ build_name }}/{{ .SourceAMI }}.json",
+		"git_commit":          "0123abcd",
+		"tags":                map[string]string{"team": "packer", "audit": "true", "owner": "build team"},
+		"custom_data":         map[string]string{"ticket": "OPS-1"},
+		"packer_build_name":   "web",
+		"packer_builder_type": "amazon-ebs",
+	}
+}
+
+func TestPostProcessorConfigure_RequiresBucket(t *testing.T) {
+	config := testMetadataConfig()
+	delete(config, "s3_bucket_name")
+
+	var p PostProcessor
+	if err := p.Configure(config); err == nil {
+		t.Fatal("s3_bucket_name should be required")
+	}
+}
+
+func TestPostProcessor_UploadMetadata(t *testing.T) {
+	var p PostProcessor
+	if err := p.Configure(testMetadataConfig()); err != nil {
+		t.Fatalf("should not have error: %s", err)
+	}
+
+	artifact := &awscommon.Artifact{
+		Amis: map[string]string{"us-east-1": "ami-12345", "eu-west-1": "ami-67890"},
+		StateData: map[string]interface{}{
+			"generated_data": map[string]interface{}{
+				"PackerRunUUID": "6b1f5a7e-0000-4000-8000-000000000000",
+				"SourceAMI":     "ami-source",
+			},
+		},
+	}
+
+	client := &mockS3Client{}
+	if err := p.uploadMetadata(context.TODO(), client, packersdk.TestUi(t), artifact); err != nil {
+		t.Fatalf("should not have error: %s", err)
+	}
+
+	if len(client.putObjectInputs) != 1 {
+		t.Fatalf("expected one upload, got %d", len(client.putObjectInputs))
+	}
+	input := client.putObjectInputs[0]
+	if bucket := aws.ToString(input.Bucket); bucket != "audit" {
+		t.Fatalf("expected the audit bucket, got %q", bucket)
+	}
+	if key := aws.ToString(input.Key); key != "builds/web/ami-source.json" {
+		t.Fatalf("expected the rendered key, got %q", key)
+	}
+	if tagging := aws.ToString(input.Tagging); tagging != "audit=true&owner=build%20team&team=packer" {
+		t.Fatalf("expected the object to be tagged, got %q", tagging)
+	}
+
+	var metadata buildMetadata
+	if err := json.Unmarshal(client.bodies[0], &metadata); err != nil {
+		t.Fatalf("the metadata should be JSON: %s", err)
+	}
+	if metadata.BuildName != "web" || metadata.BuilderType != "amazon-ebs" {
+		t.Fatalf("unexpected build: %q %q", metadata.BuildName, metadata.BuilderType)
+	}
+	if metadata.BuildUUID != "6b1f5a7e-0000-4000-8000-000000000000" {
+		t.Fatalf("unexpected build uuid %q", metadata.BuildUUID)
+	}
+	if metadata.SourceAMI != "ami-source" {
+		t.Fatalf("unexpected source AMI %q", metadata.SourceAMI)
+	}
+	if metadata.AMIs["us-east-1"] != "ami-12345" || metadata.AMIs["eu-west-1"] != "ami-67890" {
+		t.Fatalf("unexpected AMIs %v", metadata.AMIs)
+	}
+	if metadata.ArtifactId != "eu-west-1:ami-67890,us-east-1:ami-12345" {
+		t.Fatalf("unexpected artifact id %q", metadata.ArtifactId)
+	}
+	if metadata.GitCommit != "0123abcd" || metadata.CustomData["ticket"] != "OPS-1" {
+		t.Fatalf("unexpected git commit %q or custom data %v", metadata.GitCommit, metadata.CustomData)
+	}
+	if metadata.BuildTime == 0 {
+		t.Fatal("the build time should be set")
+	}
+}
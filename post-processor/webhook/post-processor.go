@@ -0,0 +1,202 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+//go:generate packer-sdc mapstructure-to-hcl2 -type Config
+
+package amazonwebhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/go-cleanhttp"
+	"github.com/hashicorp/hcl/v2/hcldec"
+	awscommon "github.com/hashicorp/packer-plugin-amazon/common"
+	"github.com/hashicorp/packer-plugin-sdk/common"
+	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
+	"github.com/hashicorp/packer-plugin-sdk/retry"
+	"github.com/hashicorp/packer-plugin-sdk/template/config"
+	"github.com/hashicorp/packer-plugin-sdk/template/interpolate"
+)
+
+const BuilderId = "packer.post-processor.amazon-webhook"
+
+// Configuration of this post processor
+type Config struct {
+	common.PackerConfig `mapstructure:",squash"`
+
+	// Variables specific to this post processor
+	URL        string            `mapstructure:"url"`
+	Headers    map[string]string `mapstructure:"headers"`
+	AuthToken  string            `mapstructure:"auth_token"`
+	CustomData map[string]string `mapstructure:"custom_data"`
+	MaxRetries *int              `mapstructure:"max_retries"`
+
+	ctx interpolate.Context
+}
+
+// payload is the document posted to the webhook.
+type payload struct {
+	BuildName   string            `json:"build_name"`
+	BuildUUID   string            `json:"build_uuid"`
+	BuilderType string            `json:"builder_type"`
+	ArtifactId  string            `json:"artifact_id"`
+	AMIs        map[string]string `json:"amis,omitempty"`
+	CustomData  map[string]string `json:"custom_data,omitempty"`
+}
+
+// statusError is returned when the webhook answers with a non-2xx status.
+type statusError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("unexpected status %d: %s", e.StatusCode, e.Body)
+}
+
+// retryable reports whether the request may succeed if sent again: the
+// webhook may be throttling or be down, but a client error won't go away.
+func (e *statusError) retryable() bool {
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500
+}
+
+type PostProcessor struct {
+	config Config
+
+	client     *http.Client
+	retryDelay func() time.Duration
+}
+
+func (p *PostProcessor) ConfigSpec() hcldec.ObjectSpec { return p.config.FlatMapstructure().HCL2Spec() }
+
+func (p *PostProcessor) Configure(raws ...interface{}) error {
+	err := config.Decode(&p.config, &config.DecodeOpts{
+		PluginType:         BuilderId,
+		Interpolate:        true,
+		InterpolateContext: &p.config.ctx,
+	}, raws...)
+	if err != nil {
+		return err
+	}
+
+	// Set defaults, 0 disables the retries.
+	if p.config.MaxRetries == nil {
+		maxRetries := 3
+		p.config.MaxRetries = &maxRetries
+	}
+
+	errs := new(packersdk.MultiError)
+
+	if p.config.URL == "" {
+		errs = packersdk.MultiErrorAppend(errs, fmt.Errorf("url must be set"))
+	} else if u, err := url.Parse(p.config.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		errs = packersdk.MultiErrorAppend(errs, fmt.Errorf("url must be an http or https URL, got %q", p.config.URL))
+	}
+
+	if *p.config.MaxRetries < 0 {
+		errs = packersdk.MultiErrorAppend(errs, fmt.Errorf("max_retries must not be negative"))
+	}
+
+	if _, ok := p.config.Headers["Authorization"]; ok && p.config.AuthToken != "" {
+		errs = packersdk.MultiErrorAppend(errs,
+			fmt.Errorf("auth_token and an Authorization header can't both be set"))
+	}
+
+	// Anything which flagged return back up the stack
+	if len(errs.Errors) > 0 {
+		return errs
+	}
+
+	packersdk.LogSecretFilter.Set(p.config.AuthToken)
+
+	p.client = cleanhttp.DefaultClient()
+	p.client.Timeout = 30 * time.Second
+	p.retryDelay = (&retry.Backoff{InitialBackoff: time.Second, MaxBackoff: 30 * time.Second, Multiplier: 2}).Linear
+	return nil
+}
+
+func (p *PostProcessor) PostProcess(ctx context.Context, ui packersdk.Ui, artifact packersdk.Artifact) (packersdk.Artifact, bool, bool, error) {
+	body, err := json.Marshal(p.buildPayload(artifact))
+	if err != nil {
+		return nil, false, false, err
+	}
+
+	ui.Say(fmt.Sprintf("Notifying webhook %s", p.config.URL))
+	err = retry.Config{
+		Tries: *p.config.MaxRetries + 1,
+		ShouldRetry: func(err error) bool {
+			var statusErr *statusError
+			if errors.As(err, &statusErr) && !statusErr.retryable() {
+				return false
+			}
+			log.Printf("Notifying webhook failed, retrying: %s", err)
+			return true
+		},
+		RetryDelay: p.retryDelay,
+	}.Run(ctx, func(ctx context.Context) error {
+		return p.post(ctx, body)
+	})
+	if err != nil {
+		return nil, false, false, fmt.Errorf("Failed to notify webhook %s: %s", p.config.URL, err)
+	}
+
+	// Notifying the webhook doesn't change the artifact, which is kept as
+	// is.
+	return artifact, true, true, nil
+}
+
+// post sends body to the webhook once.
+func (p *PostProcessor) post(ctx context.Context, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.config.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range p.config.Headers {
+		req.Header.Set(k, v)
+	}
+	if p.config.AuthToken != "" {
+		req.Header.Set("Authorization", "Bearer "+p.config.AuthToken)
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return &statusError{
+			StatusCode: resp.StatusCode,
+			Body:       strings.TrimSpace(string(respBody)),
+		}
+	}
+	return nil
+}
+
+func (p *PostProcessor) buildPayload(artifact packersdk.Artifact) *payload {
+	pl := &payload{
+		BuildName:   p.config.PackerBuildName,
+		BuilderType: p.config.PackerBuilderType,
+		ArtifactId:  artifact.Id(),
+		AMIs:        awscommon.ArtifactAMIs(artifact),
+		CustomData:  p.config.CustomData,
+	}
+	if generatedData, ok := artifact.State("generated_data").(map[string]interface{}); ok {
+		if uuid, ok := generatedData["PackerRunUUID"].(string); ok {
+			pl.BuildUUID = uuid
+		}
+	}
+	return pl
+}
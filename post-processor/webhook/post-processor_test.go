@@ -0,0 +1,174 @@
+// Copyright (c) HashiCorp, Inc.
+// SPDX-License-Identifier: MPL-2.0
+
+package amazonwebhook
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	awscommon "github.com/hashicorp/packer-plugin-amazon/common"
+	packersdk "github.com/hashicorp/packer-plugin-sdk/packer"
+)
+
+func testWebhookConfig(url string) map[string]interface{} {
+	return map[string]interface{}{
+		"url":                 url,
+		"headers":             map[string]string{"X-Pipeline": "images"},
+		"auth_token":          "s3cr3t",
+		"custom_data":         map[string]string{"team": "platform"},
+		"max_retries":         2,
+		"packer_build_name":   "web",
+		"packer_builder_type": "amazon-ebs",
+	}
+}
+
+func testArtifact() *awscommon.Artifact {
+	return &awscommon.Artifact{
+		Amis: map[string]string{"us-east-1": "ami-12345", "eu-west-1": "ami-67890"},
+		StateData: map[string]interface{}{
+			"generated_data": map[string]interface{}{
+				"PackerRunUUID": "6b1f5a7e-0000-4000-8000-000000000000",
+			},
+		},
+	}
+}
+
+func testPostProcessor(t *testing.T, url string) *PostProcessor {
+	var p PostProcessor
+	if err := p.Configure(testWebhookConfig(url)); err != nil {
+		t.Fatalf("should not have error: %s", err)
+	}
+	p.retryDelay = func() time.Duration { return 0 }
+	return &p
+}
+
+func TestPostProcessorConfigure_RequiresURL(t *testing.T) {
+	config := testWebhookConfig("")
+
+	var p PostProcessor
+	if err := p.Configure(config); err == nil {
+		t.Fatal("url should be required")
+	}
+
+	config["url"] = "ftp://example.com/hook"
+	if err := p.Configure(config); err == nil {
+		t.Fatal("url should be an http URL")
+	}
+}
+
+func TestPostProcessor_PostsPayload(t *testing.T) {
+	var (
+		got     payload
+		headers http.Header
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		headers = r.Header
+		body, _ := io.ReadAll(r.Body)
+		if err := json.Unmarshal(body, &got); err != nil {
+			t.Errorf("the payload should be JSON: %s", err)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	p := testPostProcessor(t, server.URL)
+	artifact := testArtifact()
+	result, keep, forceOverride, err := p.PostProcess(context.TODO(), packersdk.TestUi(t), artifact)
+	if err != nil {
+		t.Fatalf("should not have error: %s", err)
+	}
+	if result != artifact || !keep || !forceOverride {
+		t.Fatal("the artifact should be passed on untouched")
+	}
+
+	if headers.Get("Content-Type") != "application/json" {
+		t.Fatalf("unexpected content type %q", headers.Get("Content-Type"))
+	}
+	if headers.Get("X-Pipeline") != "images" {
+		t.Fatalf("the custom header should be set, got %q", headers.Get("X-Pipeline"))
+	}
+	if headers.Get("Authorization") != "Bearer s3cr3t" {
+		t.Fatalf("the auth token should be set, got %q", headers.Get("Authorization"))
+	}
+
+	if got.BuildName != "web" || got.BuilderType != "amazon-ebs" {
+		t.Fatalf("unexpected build: %q %q", got.BuildName, got.BuilderType)
+	}
+	if got.BuildUUID != "6b1f5a7e-0000-4000-8000-000000000000" {
+		t.Fatalf("unexpected build uuid %q", got.BuildUUID)
+	}
+	if got.AMIs["us-east-1"] != "ami-12345" || got.AMIs["eu-west-1"] != "ami-67890" {
+		t.Fatalf("unexpected AMIs %v", got.AMIs)
+	}
+	if got.CustomData["team"] != "platform" {
+		t.Fatalf("unexpected custom data %v", got.CustomData)
+	}
+}
+
+func TestPostProcessor_RetriesServerErrors(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	p := testPostProcessor(t, server.URL)
+	if _, _, _, err := p.PostProcess(context.TODO(), packersdk.TestUi(t), testArtifact()); err != nil {
+		t.Fatalf("should not have error: %s", err)
+	}
+	if calls != 3 {
+		t.Fatalf("expected 3 calls, got %d", calls)
+	}
+}
+
+func TestPostProcessor_FailsOnClientErrors(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		http.Error(w, "bad token", http.StatusUnauthorized)
+	}))
+	defer server.Close()
+
+	p := testPostProcessor(t, server.URL)
+	if _, _, _, err := p.PostProcess(context.TODO(), packersdk.TestUi(t), testArtifact()); err == nil {
+		t.Fatal("a non-2xx response should fail the post-processor")
+	}
+	if calls != 1 {
+		t.Fatalf("client errors shouldn't be retried, got %d calls", calls)
+	}
+}
+
+func TestPostProcessor_NoRetries(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	config := testWebhookConfig(server.URL)
+	config["max_retries"] = 0
+	var p PostProcessor
+	if err := p.Configure(config); err != nil {
+		t.Fatalf("should not have error: %s", err)
+	}
+	p.retryDelay = func() time.Duration { return 0 }
+
+	if _, _, _, err := p.PostProcess(context.TODO(), packersdk.TestUi(t), testArtifact()); err == nil {
+		t.Fatal("a server error should fail the post-processor")
+	}
+	if calls != 1 {
+		t.Fatalf("max_retries = 0 should disable the retries, got %d calls", calls)
+	}
+}